@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("audit", runAudit, `
+usage: flynn audit [--user=<user>] [-n <count>]
+
+Show the audit log of mutating requests made to the controller.
+
+Requests made with a cluster key rather than a user token are shown with a
+user of "-".
+
+Options:
+	--user=<user>        only show requests made by <user>
+	-n, --count=<count>  show the given number of most recent requests [default: 20]
+
+Examples:
+
+	$ flynn audit
+	TIME            USER  STATUS  REQUEST                   APP
+	2 minutes ago   jane  200     POST /apps/website/scale  website
+	10 minutes ago  -     200     POST /users               -
+`)
+}
+
+func runAudit(args *docopt.Args, client controller.Client) error {
+	count, err := strconv.Atoi(args.String["--count"])
+	if err != nil {
+		return err
+	}
+	opts := ct.ListEventsOptions{
+		ObjectTypes: []ct.EventType{ct.EventTypeAudit},
+		Count:       count,
+	}
+	if name := args.String["--user"]; name != "" {
+		user, err := client.GetUser(name)
+		if err != nil {
+			return err
+		}
+		opts.ObjectID = user.ID
+	}
+	events, err := client.ListEvents(opts)
+	if err != nil {
+		return err
+	}
+	appNames, err := appNamesByID(client)
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+	listRec(w, "TIME", "USER", "STATUS", "REQUEST", "APP")
+	for _, event := range events {
+		var audit ct.AuditEvent
+		if err := json.Unmarshal(event.Data, &audit); err != nil {
+			continue
+		}
+		user := audit.UserName
+		if user == "" {
+			user = "-"
+		}
+		app := "-"
+		if event.AppID != "" {
+			app = appNames[event.AppID]
+		}
+		listRec(w, humanTime(event.CreatedAt), user, audit.Status, audit.Method+" "+audit.Path, app)
+	}
+	return nil
+}
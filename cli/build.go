@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("builds", runBuilds, `
+usage: flynn builds [-n <count>]
+
+List the app's most recent builds of code pushed with git, newest first.
+
+QUEUED is how long the build waited for another build to finish, and TOOK is
+how long it took from then until it was deployed. CACHE shows whether the
+build could use a cache for its buildpack and dependencies.
+
+Options:
+	-n, --count=<count>  number of builds to list [default: 20]
+
+Examples:
+
+	$ flynn builds
+	ID                                    REV      STATE      CACHE  QUEUED  TOOK   CREATED
+	2b8b9d15-2c2e-4b8e-95d6-4eb0b7d4a3c1  7d9a2c1  running    hit    0s      12s    12 seconds ago
+	c5a8e6a4-5b4e-4b0e-9f2f-0b6c1c9b3a77  e41f0b3  cancelled  miss   1s      1m5s   3 minutes ago
+`)
+}
+
+func runBuilds(args *docopt.Args, client controller.Client) error {
+	count, err := strconv.Atoi(args.String["--count"])
+	if err != nil || count < 1 {
+		return fmt.Errorf("invalid count: %q", args.String["--count"])
+	}
+	builds, err := client.BuildList(mustApp(), count)
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+	listRec(w, "ID", "REV", "STATE", "CACHE", "QUEUED", "TOOK", "CREATED")
+	for _, b := range builds {
+		rev := b.Rev
+		if len(rev) > 7 {
+			rev = rev[:7]
+		}
+		cache := "miss"
+		if b.CacheHit {
+			cache = "hit"
+		}
+		listRec(w, b.ID, rev, b.State, cache, buildDuration(b.QueueDuration), buildDuration(b.Duration), humanTime(b.CreatedAt))
+	}
+	return nil
+}
+
+func buildDuration(d *time.Duration) string {
+	if d == nil {
+		return ""
+	}
+	return (*d / time.Second * time.Second).String()
+}
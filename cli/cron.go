@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("cron", runCron, `
+usage: flynn cron
+       flynn cron add [-t <proc>] [-e <var>=<val>...] [--concurrency-policy=<policy>] <cron> [--] [<command> [<argument>...]]
+       flynn cron update <id> [--cron=<cron>] [--concurrency-policy=<policy>]
+       flynn cron remove <id>
+       flynn cron runs <id>
+
+Manage scheduled jobs.
+
+Cron expressions have five fields (minute, hour, day of month, month and day
+of week) and are evaluated in UTC. The descriptors @yearly, @monthly, @weekly,
+@daily and @hourly are also supported.
+
+Options:
+	-t, --process-type=<proc>        run a process type of the app's release
+	-e, --env=<var>=<val>            set an environment variable for the job, can be given multiple times
+	--cron=<cron>                    change the cron expression
+	--concurrency-policy=<policy>    what to do if jobs from a previous run are still running when the
+	                                 schedule is due, one of allow (default), forbid or replace
+
+Commands:
+	With no arguments, shows a list of schedules.
+
+	add     adds a schedule which runs a job each time the cron expression is due
+	update  updates a schedule
+	remove  removes a schedule
+	runs    lists the jobs run by a schedule
+
+Examples:
+
+	$ flynn cron add "*/15 * * * *" -- bin/send-reminders
+	Created schedule 4e0a8f4f-85dd-4fc6-9e7c-06b48d9d6f3b.
+
+	$ flynn cron add -t worker --concurrency-policy=forbid @daily
+	Created schedule 0b55cd84-5f89-4d2b-a9a4-bf20e6c0cfd3.
+
+	$ flynn cron
+	ID                                    CRON          COMMAND                POLICY  NEXT RUN
+	4e0a8f4f-85dd-4fc6-9e7c-06b48d9d6f3b  */15 * * * *  bin/send-reminders     allow   2016-03-14T10:45:00Z
+	0b55cd84-5f89-4d2b-a9a4-bf20e6c0cfd3  @daily        [process type worker]  forbid  2016-03-15T00:00:00Z
+
+	$ flynn cron remove 4e0a8f4f-85dd-4fc6-9e7c-06b48d9d6f3b
+	Schedule 4e0a8f4f-85dd-4fc6-9e7c-06b48d9d6f3b removed.
+`)
+}
+
+func runCron(args *docopt.Args, client controller.Client) error {
+	if args.Bool["add"] {
+		return runCronAdd(args, client)
+	} else if args.Bool["update"] {
+		return runCronUpdate(args, client)
+	} else if args.Bool["remove"] {
+		return runCronRemove(args, client)
+	} else if args.Bool["runs"] {
+		return runCronRuns(args, client)
+	}
+
+	schedules, err := client.ScheduleList(mustApp())
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+
+	listRec(w, "ID", "CRON", "COMMAND", "POLICY", "NEXT RUN")
+	for _, s := range schedules {
+		command := strings.Join(s.Args, " ")
+		if s.ProcessType != "" && command == "" {
+			command = fmt.Sprintf("[process type %s]", s.ProcessType)
+		}
+		var next string
+		if s.NextRunAt != nil {
+			next = s.NextRunAt.Format(time.RFC3339)
+		}
+		listRec(w, s.ID, s.Cron, command, s.ConcurrencyPolicy, next)
+	}
+	return nil
+}
+
+func runCronAdd(args *docopt.Args, client controller.Client) error {
+	schedule := &ct.Schedule{
+		Cron:              args.String["<cron>"],
+		ProcessType:       args.String["--process-type"],
+		ConcurrencyPolicy: ct.ConcurrencyPolicy(args.String["--concurrency-policy"]),
+	}
+	if command := args.String["<command>"]; command != "" {
+		schedule.Args = append([]string{command}, args.All["<argument>"].([]string)...)
+	}
+	if schedule.ProcessType == "" && len(schedule.Args) == 0 {
+		return errors.New("Either a command or a process type must be given")
+	}
+	if envs, _ := args.All["--env"].([]string); len(envs) > 0 {
+		schedule.Env = make(map[string]string, len(envs))
+		for _, env := range envs {
+			keyVal := strings.SplitN(env, "=", 2)
+			if len(keyVal) != 2 {
+				return fmt.Errorf("Invalid env %q, expected VAR=VAL", env)
+			}
+			schedule.Env[keyVal[0]] = keyVal[1]
+		}
+	}
+
+	if err := client.CreateSchedule(mustApp(), schedule); err != nil {
+		return err
+	}
+	fmt.Printf("Created schedule %s.\n", schedule.ID)
+	return nil
+}
+
+func runCronUpdate(args *docopt.Args, client controller.Client) error {
+	schedule, err := client.GetSchedule(mustApp(), args.String["<id>"])
+	if err != nil {
+		return err
+	}
+	if cron := args.String["--cron"]; cron != "" {
+		schedule.Cron = cron
+	}
+	if policy := args.String["--concurrency-policy"]; policy != "" {
+		schedule.ConcurrencyPolicy = ct.ConcurrencyPolicy(policy)
+	}
+	if err := client.UpdateSchedule(schedule); err != nil {
+		return err
+	}
+	fmt.Printf("Updated schedule %s, next run at %s.\n", schedule.ID, schedule.NextRunAt.Format(time.RFC3339))
+	return nil
+}
+
+func runCronRemove(args *docopt.Args, client controller.Client) error {
+	id := args.String["<id>"]
+	if err := client.DeleteSchedule(mustApp(), id); err != nil {
+		return err
+	}
+	fmt.Printf("Schedule %s removed.\n", id)
+	return nil
+}
+
+func runCronRuns(args *docopt.Args, client controller.Client) error {
+	schedule, err := client.GetSchedule(mustApp(), args.String["<id>"])
+	if err != nil {
+		return err
+	}
+	events, err := client.ListEvents(ct.ListEventsOptions{
+		AppID:       schedule.AppID,
+		ObjectTypes: []ct.EventType{ct.EventTypeSchedule},
+		ObjectID:    schedule.ID,
+	})
+	if err != nil {
+		return err
+	}
+	jobs, err := client.JobList(schedule.AppID)
+	if err != nil {
+		return err
+	}
+	states := make(map[string]ct.JobState, len(jobs))
+	for _, job := range jobs {
+		states[job.ID] = job.State
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+
+	listRec(w, "JOB", "RELEASE", "STATE", "STARTED")
+	for _, event := range events {
+		var job ct.Job
+		if err := json.Unmarshal(event.Data, &job); err != nil {
+			return err
+		}
+		listRec(w, job.ID, job.ReleaseID, states[job.ID], humanTime(event.CreatedAt))
+	}
+	return nil
+}
@@ -13,13 +13,39 @@ func init() {
 	register("deployment", runDeployments, `
 usage: flynn deployment
        flynn deployment timeout [<timeout>]
+       flynn deployment strategy [<strategy>]
+       flynn deployment canary [--count=<count>] [--period=<period>]
+       flynn deployment blue-green [--preview-period=<period>] [--warm-period=<period>]
+       flynn deployment rollback
 
 Manage app deployments
 
 Commands:
     With no arguments, shows a list of deployments
 
-	timeout  gets or sets the number of seconds to wait for each job to start when deploying
+	timeout   gets or sets the number of seconds to wait for each job to start when deploying
+	strategy    gets or sets the deployment strategy (all-at-once, one-by-one, canary or blue-green)
+	canary      gets or sets the options used by the canary strategy
+	blue-green  gets or sets the options used by the blue-green strategy
+	rollback    switches traffic back to the previous release of a blue-green deployment
+
+Options:
+	--count=<count>            number of jobs of each process type to start before observing them
+	--period=<period>          number of seconds to observe the canary jobs before promoting the deployment
+	--preview-period=<period>  number of seconds to serve the new release on preview routes before switching traffic
+	--warm-period=<period>     number of seconds to keep the old release running after switching traffic
+
+The canary strategy starts a number of jobs of the new release alongside the
+existing jobs and observes them for a period. If none of them go down or fail
+their health check, the deployment is promoted and continues one-by-one,
+otherwise it is rolled back.
+
+The blue-green strategy starts all the jobs of the new release whilst the app's
+HTTP routes keep sending traffic to the old release. The new release is served
+on a preview route for each domain (e.g. myapp-preview.example.com for
+myapp.example.com) for the preview period, then the app's routes are switched
+to the new release. The old release keeps running for the warm period, during
+which "flynn deployment rollback" switches traffic straight back to it.
 
 Examples:
 
@@ -34,6 +60,25 @@ Examples:
 
 	$ flynn deployment timeout
 	150
+
+	$ flynn deployment strategy canary
+
+	$ flynn deployment canary --count=2 --period=300
+
+	$ flynn deployment canary
+	count: 2
+	period: 300
+
+	$ flynn deployment strategy blue-green
+
+	$ flynn deployment blue-green --preview-period=120 --warm-period=3600
+
+	$ flynn deployment blue-green
+	preview-period: 120
+	warm-period: 3600
+
+	$ flynn deployment rollback
+	Rolled back deployment 39f8b98b-2aed-40a5-9423-ae174b3fb7a9
 `)
 }
 
@@ -44,6 +89,27 @@ func runDeployments(args *docopt.Args, client controller.Client) error {
 		}
 		return runGetDeployTimeout(args, client)
 	}
+	if args.Bool["strategy"] {
+		if args.String["<strategy>"] != "" {
+			return runSetDeployStrategy(args, client)
+		}
+		return runGetDeployStrategy(args, client)
+	}
+	if args.Bool["canary"] {
+		if args.String["--count"] != "" || args.String["--period"] != "" {
+			return runSetDeployCanary(args, client)
+		}
+		return runGetDeployCanary(args, client)
+	}
+	if args.Bool["blue-green"] {
+		if args.String["--preview-period"] != "" || args.String["--warm-period"] != "" {
+			return runSetDeployBlueGreen(args, client)
+		}
+		return runGetDeployBlueGreen(args, client)
+	}
+	if args.Bool["rollback"] {
+		return runDeploymentRollback(args, client)
+	}
 
 	deployments, err := client.DeploymentList(mustApp())
 	if err != nil {
@@ -79,3 +145,108 @@ func runSetDeployTimeout(args *docopt.Args, client controller.Client) error {
 		DeployTimeout: int32(timeout),
 	})
 }
+
+func runGetDeployStrategy(args *docopt.Args, client controller.Client) error {
+	app, err := client.GetApp(mustApp())
+	if err != nil {
+		return err
+	}
+	fmt.Println(app.Strategy)
+	return nil
+}
+
+func runSetDeployStrategy(args *docopt.Args, client controller.Client) error {
+	return client.UpdateApp(&ct.App{
+		ID:       mustApp(),
+		Strategy: args.String["<strategy>"],
+	})
+}
+
+func runGetDeployCanary(args *docopt.Args, client controller.Client) error {
+	app, err := client.GetApp(mustApp())
+	if err != nil {
+		return err
+	}
+	count, period := app.DeployOptions.Canary()
+	fmt.Println("count:", count)
+	fmt.Println("period:", int(period.Seconds()))
+	return nil
+}
+
+func runSetDeployCanary(args *docopt.Args, client controller.Client) error {
+	app, err := client.GetApp(mustApp())
+	if err != nil {
+		return err
+	}
+	opts := app.DeployOptions
+	if opts == nil {
+		opts = &ct.DeployOptions{}
+	}
+	if s := args.String["--count"]; s != "" {
+		count, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("error parsing count %q: %s", s, err)
+		}
+		opts.CanaryCount = count
+	}
+	if s := args.String["--period"]; s != "" {
+		period, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("error parsing period %q: %s", s, err)
+		}
+		opts.CanaryPeriod = int32(period)
+	}
+	return client.UpdateApp(&ct.App{
+		ID:            app.ID,
+		DeployOptions: opts,
+	})
+}
+
+func runGetDeployBlueGreen(args *docopt.Args, client controller.Client) error {
+	app, err := client.GetApp(mustApp())
+	if err != nil {
+		return err
+	}
+	preview, warm := app.DeployOptions.BlueGreen()
+	fmt.Println("preview-period:", int(preview.Seconds()))
+	fmt.Println("warm-period:", int(warm.Seconds()))
+	return nil
+}
+
+func runSetDeployBlueGreen(args *docopt.Args, client controller.Client) error {
+	app, err := client.GetApp(mustApp())
+	if err != nil {
+		return err
+	}
+	opts := app.DeployOptions
+	if opts == nil {
+		opts = &ct.DeployOptions{}
+	}
+	if s := args.String["--preview-period"]; s != "" {
+		period, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("error parsing preview period %q: %s", s, err)
+		}
+		opts.PreviewPeriod = int32(period)
+	}
+	if s := args.String["--warm-period"]; s != "" {
+		period, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("error parsing warm period %q: %s", s, err)
+		}
+		opts.WarmPeriod = int32(period)
+	}
+	return client.UpdateApp(&ct.App{
+		ID:            app.ID,
+		DeployOptions: opts,
+	})
+}
+
+func runDeploymentRollback(args *docopt.Args, client controller.Client) error {
+	deployment, err := client.RollbackDeployment(mustApp())
+	if err != nil {
+		return err
+	}
+	fmt.Println("Rolled back deployment", deployment.ID)
+	return nil
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
@@ -16,9 +19,12 @@ import (
 
 func init() {
 	register("log", runLog, `
-usage: flynn log [-f] [-j <id>] [-n <lines>] [-r] [-s] [-t <type>]
+usage: flynn log [-f] [-j <id>] [-n <lines>] [-r] [-s] [-t <type>] [--since=<time>] [--until=<time>] [--grep=<pattern>] [--query=<query>]
+       flynn log drain
+       flynn log drain add <url>
+       flynn log drain remove <id>
 
-Stream log for an app.
+Stream log for an app, or manage its log drains.
 
 Options:
 	-f, --follow               stream new lines
@@ -27,6 +33,55 @@ Options:
 	-r, --raw-output           output raw log messages with no prefix
 	-s, --split-stderr         send stderr lines to stderr
 	-t, --process-type=<type>  filter logs to a specific process type
+	--since=<time>             only return lines logged at or after time
+	--until=<time>             only return lines logged before time
+	--grep=<pattern>           only return lines matching the regular expression
+	--query=<query>            only return lines matching the query
+
+Times are either RFC3339 timestamps (e.g. 2016-03-01T12:00:00Z) or durations
+before now (e.g. 30m, 12h or 3d). Lines older than the log buffer are
+returned if the log aggregator is configured to retain them.
+
+Queries are lists of terms separated by spaces which lines must all match:
+
+	text or "quoted text"   the line contains the text
+	/regexp/                the line matches the regular expression
+	stream:<stream>         the line was written to stdout or stderr
+	host:<id>               the line was logged on the host
+	severity:<level>        the line's severity is at least level (one of
+	                        emerg, alert, crit, err, warning, notice, info
+	                        or debug, with stderr lines being err and other
+	                        lines info unless they set a severity)
+
+Filters apply to both the returned lines and new lines when following.
+
+Commands:
+	drain         lists the app's log drains and how many lines have been sent
+	              to, dropped by and failed to be sent to each of them
+	drain add     adds a log drain which forwards the app's logs to a URL
+	drain remove  removes a log drain
+
+Log drains forward RFC5424 syslog messages either over TCP, with URLs of the
+form syslog://host:port (or syslog+tls://host:port to use TLS), or in batches
+using HTTP POST requests to http or https URLs. Lines are buffered whilst a
+drain is unavailable, and dropped if the buffer fills up.
+
+Examples:
+
+	$ flynn log --since 3d --until 2d
+
+	$ flynn log --since 2016-03-01T12:00:00Z -n 100
+
+	$ flynn log -f --grep "timeout|refused"
+
+	$ flynn log --since 1h --query 'stream:stderr "connection reset"'
+
+	$ flynn log drain add syslog+tls://logs.example.com:6514
+	Created log drain 2bf4e5c0-5b5a-4c3e-8f8c-6f1d3c4f5a1b.
+
+	$ flynn log drain
+	ID                                    URL                                SENT  DROPPED  ERRORS  LAST ERROR
+	2bf4e5c0-5b5a-4c3e-8f8c-6f1d3c4f5a1b  syslog+tls://logs.example.com:6514  1024  0        0
 `)
 }
 
@@ -35,6 +90,9 @@ Options:
 const rfc3339micro = "2006-01-02T15:04:05.000000Z07:00"
 
 func runLog(args *docopt.Args, client controller.Client) error {
+	if args.Bool["drain"] {
+		return runLogDrain(args, client)
+	}
 	rawOutput := args.Bool["--raw-output"]
 	opts := ct.LogOpts{
 		Follow: args.Bool["--follow"],
@@ -50,6 +108,31 @@ func runLog(args *docopt.Args, client controller.Client) error {
 		}
 		opts.Lines = &lines
 	}
+	if since := args.String["--since"]; since != "" {
+		t, err := parseLogTime(since)
+		if err != nil {
+			return err
+		}
+		opts.Since = t
+	}
+	if until := args.String["--until"]; until != "" {
+		if opts.Follow {
+			return errors.New("--until cannot be used with --follow")
+		}
+		t, err := parseLogTime(until)
+		if err != nil {
+			return err
+		}
+		opts.Until = t
+	}
+	var query []string
+	if q := args.String["--query"]; q != "" {
+		query = append(query, q)
+	}
+	if pattern := args.String["--grep"]; pattern != "" {
+		query = append(query, "/"+strings.Replace(pattern, "/", `\/`, -1)+"/")
+	}
+	opts.Query = strings.Join(query, " ")
 	rc, err := client.GetAppLog(mustApp(), &opts)
 	if err != nil {
 		return err
@@ -90,9 +173,67 @@ func runLog(args *docopt.Args, client controller.Client) error {
 	}
 }
 
+// parseLogTime parses either an RFC3339 timestamp or a duration before now,
+// which may be given in days (e.g. "3d")
+func parseLogTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	var d time.Duration
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", s)
+		}
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q, expected an RFC3339 timestamp or a duration", s)
+		}
+	}
+	return time.Now().Add(-d), nil
+}
+
 func shorten(msg string, maxLength int) string {
 	if len(msg) > maxLength {
 		return msg[:maxLength]
 	}
 	return msg
 }
+
+func runLogDrain(args *docopt.Args, client controller.Client) error {
+	if args.Bool["add"] {
+		drain := &ct.LogDrain{URL: args.String["<url>"]}
+		if err := client.CreateLogDrain(mustApp(), drain); err != nil {
+			return err
+		}
+		fmt.Printf("Created log drain %s.\n", drain.ID)
+		return nil
+	} else if args.Bool["remove"] {
+		id := args.String["<id>"]
+		if err := client.DeleteLogDrain(mustApp(), id); err != nil {
+			return err
+		}
+		fmt.Printf("Log drain %s removed.\n", id)
+		return nil
+	}
+
+	drains, err := client.LogDrainList(mustApp())
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+
+	listRec(w, "ID", "URL", "SENT", "DROPPED", "ERRORS", "LAST ERROR")
+	for _, d := range drains {
+		if d.Stats == nil {
+			listRec(w, d.ID, d.URL, "-", "-", "-", "")
+			continue
+		}
+		listRec(w, d.ID, d.URL, d.Stats.Sent, d.Stats.Dropped, d.Stats.Errors, d.Stats.LastError)
+	}
+	return nil
+}
@@ -51,6 +51,7 @@ Commands:
 	log         get app log
 	scale       change formation
 	run         run a job
+	cron        manage scheduled jobs
 	env         manage env variables
 	limit       manage resource limits
 	meta        manage app metadata
@@ -64,8 +65,14 @@ Commands:
 	resource    provision a new resource
 	release     manage app releases
 	deployment  list deployments
+	builds      list builds of code pushed with git
+	pipeline    promote releases between apps
 	export      export app data
 	import      create app from exported data
+	user        manage users
+	login       log in to a cluster as a user
+	whoami      show the current user
+	audit       show the audit log
 	version     show flynn version
 
 See 'flynn help <command>' for more information on a specific command.
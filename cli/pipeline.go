@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("pipeline", runPipeline, `
+usage: flynn pipeline
+       flynn pipeline create <name> <app>...
+       flynn pipeline update <name> <app>...
+       flynn pipeline delete <name>
+       flynn pipeline promote [--from=<app>] [--release=<id>] <name>
+       flynn pipeline promotions [-n <count>] <name>
+
+Manage pipelines, which are ordered lists of apps that releases are promoted
+through, e.g. from a staging app to a production app.
+
+Promoting a release of an app creates a release of the next app in the
+pipeline with the same artifacts and processes, but which keeps the next app's
+env and resource limits, then deploys it with the next app's deploy strategy
+and formation. Promotions are recorded as events of the app the release was
+promoted to.
+
+Managing pipelines and promoting releases requires the admin role.
+
+Options:
+	--from=<app>         the app to promote a release of, defaults to the first app in the pipeline
+	--release=<id>       the release to promote, defaults to the app's current release
+	-n, --count=<count>  number of promotions to list [default: 20]
+
+Commands:
+	With no arguments, shows a list of pipelines.
+
+	create      creates a pipeline of the given apps, in order
+	update      replaces the apps in a pipeline
+	delete      deletes a pipeline
+	promote     promotes a release to the next app in the pipeline
+	promotions  lists a pipeline's most recent promotions, newest first
+
+Examples:
+
+	$ flynn pipeline create website website-staging website
+	Created pipeline website.
+
+	$ flynn pipeline
+	NAME     APPS                        CREATED
+	website  website-staging -> website  2 minutes ago
+
+	$ flynn pipeline promote website
+	Promoting release 5e5b1dc7-9d9c-4d8c-8a8a-f0c38b2b0d68 of website-staging to website...
+	Promoted to release 0a3bc6f8-4e6e-4a1f-b0d3-34c9f2e0a1d2 of website.
+
+	$ flynn pipeline promotions website
+	ID                                    FROM                                                  TO                                            CREATED
+	d7a58e3c-8a3e-4f0e-9b5d-2c1e6f4a7b90  website-staging (5e5b1dc7-9d9c-4d8c-8a8a-f0c38b2b0d68)  website (0a3bc6f8-4e6e-4a1f-b0d3-34c9f2e0a1d2)  1 minute ago
+`)
+}
+
+func runPipeline(args *docopt.Args, client controller.Client) error {
+	if args.Bool["create"] {
+		return runPipelineCreate(args, client)
+	} else if args.Bool["update"] {
+		return runPipelineUpdate(args, client)
+	} else if args.Bool["delete"] {
+		return runPipelineDelete(args, client)
+	} else if args.Bool["promote"] {
+		return runPipelinePromote(args, client)
+	} else if args.Bool["promotions"] {
+		return runPipelinePromotions(args, client)
+	}
+
+	pipelines, err := client.PipelineList()
+	if err != nil {
+		return err
+	}
+	appNames, err := appNamesByID(client)
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+	listRec(w, "NAME", "APPS", "CREATED")
+	for _, p := range pipelines {
+		apps := make([]string, len(p.Apps))
+		for i, id := range p.Apps {
+			apps[i] = appName(id, appNames)
+		}
+		listRec(w, p.Name, strings.Join(apps, " -> "), humanTime(p.CreatedAt))
+	}
+	return nil
+}
+
+func appName(id string, appNames map[string]string) string {
+	if name, ok := appNames[id]; ok {
+		return name
+	}
+	return id
+}
+
+func runPipelineCreate(args *docopt.Args, client controller.Client) error {
+	pipeline := &ct.Pipeline{
+		Name: args.String["<name>"],
+		Apps: args.All["<app>"].([]string),
+	}
+	if err := client.CreatePipeline(pipeline); err != nil {
+		return err
+	}
+	fmt.Printf("Created pipeline %s.\n", pipeline.Name)
+	return nil
+}
+
+func runPipelineUpdate(args *docopt.Args, client controller.Client) error {
+	pipeline, err := client.GetPipeline(args.String["<name>"])
+	if err != nil {
+		return err
+	}
+	pipeline.Apps = args.All["<app>"].([]string)
+	if err := client.UpdatePipeline(pipeline); err != nil {
+		return err
+	}
+	fmt.Printf("Updated pipeline %s.\n", pipeline.Name)
+	return nil
+}
+
+func runPipelineDelete(args *docopt.Args, client controller.Client) error {
+	name := args.String["<name>"]
+	if err := client.DeletePipeline(name); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted pipeline %s.\n", name)
+	return nil
+}
+
+func runPipelinePromote(args *docopt.Args, client controller.Client) error {
+	pipeline, err := client.GetPipeline(args.String["<name>"])
+	if err != nil {
+		return err
+	}
+	promotion := &ct.Promotion{
+		FromAppID:     args.String["--from"],
+		FromReleaseID: args.String["--release"],
+	}
+	if err := client.Promote(pipeline.ID, promotion); err != nil {
+		return err
+	}
+	from, err := client.GetApp(promotion.FromAppID)
+	if err != nil {
+		return err
+	}
+	to, err := client.GetApp(promotion.ToAppID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Promoting release %s of %s to %s...\n", promotion.FromReleaseID, from.Name, to.Name)
+	if err := waitForDeployment(client, promotion.DeploymentID); err != nil {
+		return err
+	}
+	fmt.Printf("Promoted to release %s of %s.\n", promotion.ReleaseID, to.Name)
+	return nil
+}
+
+// waitForDeployment waits for the deployment to finish, returning an error
+// if it fails
+func waitForDeployment(client controller.Client, id string) error {
+	d, err := client.GetDeployment(id)
+	if err != nil {
+		return err
+	}
+	if d.FinishedAt != nil {
+		return nil
+	}
+	events := make(chan *ct.DeploymentEvent)
+	stream, err := client.StreamDeployment(d, events)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for e := range events {
+		switch e.Status {
+		case "complete":
+			return nil
+		case "failed":
+			return e.Err()
+		}
+	}
+	return fmt.Errorf("unexpected close of deployment event stream: %s", stream.Err())
+}
+
+func runPipelinePromotions(args *docopt.Args, client controller.Client) error {
+	count, err := strconv.Atoi(args.String["--count"])
+	if err != nil || count < 1 {
+		return fmt.Errorf("invalid count: %q", args.String["--count"])
+	}
+	promotions, err := client.PromotionList(args.String["<name>"], count)
+	if err != nil {
+		return err
+	}
+	appNames, err := appNamesByID(client)
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+	listRec(w, "ID", "FROM", "TO", "CREATED")
+	for _, p := range promotions {
+		listRec(w,
+			p.ID,
+			fmt.Sprintf("%s (%s)", appName(p.FromAppID, appNames), p.FromReleaseID),
+			fmt.Sprintf("%s (%s)", appName(p.ToAppID, appNames), p.ReleaseID),
+			humanTime(p.CreatedAt),
+		)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"sort"
 	"time"
 
@@ -16,6 +17,10 @@ usage: flynn ps [-a]
 
 List flynn jobs.
 
+Jobs which the scheduler is unable to place in the cluster (e.g. because no
+hosts have enough free memory or CPU for their resource requests) are listed
+with the reason they are pending.
+
 Options:
   -a, --all      Show all jobs (default is running and pending)
 
@@ -27,6 +32,8 @@ Example:
 	6ec25d6e-2985-4807-8e64-02dc23c348bc       web   pending  7 seconds ago   1b1db8ef-ba4d-4314-85c1-d5895a44b27e
 	ab14754c-73b7-4212-a6d9-73b825587fd2       web   pending  2 seconds ago   1b1db8ef-ba4d-4314-85c1-d5895a44b27e
 
+	ab14754c-73b7-4212-a6d9-73b825587fd2 is pending: no hosts have enough free memory for job requests (memory=1GB, cpu=1000)
+
 	$ flynn ps --all
 	ID                                         TYPE  STATE    CREATED             RELEASE
 	host-d84dc657-83b8-4a62-aab8-ad97bb994761  web   down     2 minutes ago       cd698657-2955-4fa4-bc2f-8714b218a7a2
@@ -49,8 +56,8 @@ func runPs(args *docopt.Args, client controller.Client) error {
 	sort.Sort(sortJobs(jobs))
 
 	w := tabWriter()
-	defer w.Flush()
 
+	var pending []string
 	listRec(w, "ID", "TYPE", "STATE", "CREATED", "RELEASE")
 	for _, j := range jobs {
 		if j.Type == "" {
@@ -68,8 +75,18 @@ func runPs(args *docopt.Args, client controller.Client) error {
 			created = units.HumanDuration(time.Now().UTC().Sub(*j.CreatedAt)) + " ago"
 		}
 		listRec(w, id, j.Type, j.State, created, j.ReleaseID)
+		if j.State == ct.JobStatePending && j.PendingReason != nil {
+			pending = append(pending, fmt.Sprintf("%s is pending: %s", id, *j.PendingReason))
+		}
 	}
+	w.Flush()
 
+	if len(pending) > 0 {
+		fmt.Println()
+		for _, p := range pending {
+			fmt.Println(p)
+		}
+	}
 	return nil
 }
 
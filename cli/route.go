@@ -19,9 +19,9 @@ import (
 func init() {
 	register("route", runRoute, `
 usage: flynn route
-       flynn route add http [-s <service>] [-c <tls-cert> -k <tls-key>] [--sticky] [--leader] [--no-leader] <domain>
-       flynn route add tcp [-s <service>] [-p <port>] [--leader]
-       flynn route update <id> [-s <service>] [-c <tls-cert> -k <tls-key>] [--sticky] [--no-sticky] [--leader] [--no-leader]
+       flynn route add http [-s <service>] [-c <tls-cert> -k <tls-key>] [--auto-tls] [--sticky] [--leader] [--no-leader] [--target=<target>...] [--rate-limit=<rps>] [--max-concurrent=<n>] <domain>
+       flynn route add tcp [-s <service>] [-p <port>] [--leader] [--max-conns=<n>]
+       flynn route update <id> [-s <service>] [-c <tls-cert> -k <tls-key>] [--auto-tls] [--no-auto-tls] [--sticky] [--no-sticky] [--leader] [--no-leader] [--target=<target>...] [--no-targets] [--rate-limit=<rps>] [--max-concurrent=<n>] [--max-conns=<n>]
        flynn route remove <id>
 
 Manage routes for application.
@@ -30,11 +30,27 @@ Options:
 	-s, --service=<service>    service name to route domain to (defaults to APPNAME-web)
 	-c, --tls-cert=<tls-cert>  path to PEM encoded certificate for TLS, - for stdin (http only)
 	-k, --tls-key=<tls-key>    path to PEM encoded private key for TLS, - for stdin (http only)
+	--auto-tls                 obtain and renew a TLS certificate automatically using ACME (http only)
+	--no-auto-tls              stop renewing the TLS certificate automatically (update http only)
 	--sticky                   enable cookie-based sticky routing (http only)
 	--no-sticky                disable cookie-based sticky routing (update http only)
 	--leader                   enable leader-only routing mode
 	--no-leader                disable leader-only routing mode (update only)
 	-p, --port=<port>          port to accept traffic on (tcp only)
+	--target=<target>          split traffic between services in proportion to their weights, in the form
+	                           SERVICE[@RELEASE]:WEIGHT, can be given multiple times (http only)
+	--no-targets               stop splitting traffic and route to the service (update http only)
+	--rate-limit=<rps>         limit the requests per second from each client IP, 0 for no limit (http only)
+	--max-concurrent=<n>       limit the requests handled at once, 0 for no limit (http only)
+	--max-conns=<n>            limit the open connections, 0 for no limit (tcp only)
+
+Requests over a limit get a 429 Too Many Requests response, and connections
+over a limit are closed.
+
+Automatic TLS requires the router to be configured with an ACME server, for
+example Let's Encrypt, by setting ACME_DIRECTORY_URL and optionally ACME_EMAIL
+in the router app's environment. The domain must resolve to the cluster so
+that the ACME server can reach the router over HTTP to validate it.
 
 Commands:
 	With no arguments, shows a list of routes.
@@ -48,9 +64,17 @@ Examples:
 
 	$ flynn route add http example.com/path/
 
+	$ flynn route add http --auto-tls example.com
+
 	$ flynn route add tcp
 
 	$ flynn route add tcp --leader
+
+	$ flynn route add http --target=myapp-web:90 --target=myapp-beta-web:10 example.com
+
+	$ flynn route add http --rate-limit=100 --max-concurrent=500 example.com
+
+	$ flynn route update tcp/8a47a6a9-6a8d-4bbb-9f4e-5a8f1f1f8c1c --max-conns=1000
 `)
 }
 
@@ -87,7 +111,7 @@ func runRoute(args *docopt.Args, client controller.Client) error {
 	defer w.Flush()
 
 	var route, protocol, service, sticky, path string
-	listRec(w, "ROUTE", "SERVICE", "ID", "STICKY", "LEADER", "PATH")
+	listRec(w, "ROUTE", "SERVICE", "ID", "STICKY", "LEADER", "PATH", "LIMITS")
 	for _, k := range routes {
 		switch k.Type {
 		case "tcp":
@@ -106,7 +130,7 @@ func runRoute(args *docopt.Args, client controller.Client) error {
 			sticky = fmt.Sprintf("%t", k.Sticky)
 			path = k.HTTPRoute().Path
 		}
-		listRec(w, protocol+":"+route, service, k.FormattedID(), sticky, k.Leader, path)
+		listRec(w, protocol+":"+route, service, k.FormattedID(), sticky, k.Leader, path, formatRouteLimits(k.Limits))
 	}
 	return nil
 }
@@ -126,10 +150,16 @@ func runRouteAddTCP(args *docopt.Args, client controller.Client) error {
 		port = p
 	}
 
+	limits, err := parseRouteLimits(args, nil)
+	if err != nil {
+		return err
+	}
+
 	hr := &router.TCPRoute{
 		Service: service,
 		Port:    port,
 		Leader:  args.Bool["--leader"],
+		Limits:  limits,
 	}
 
 	r := hr.ToRoute()
@@ -151,6 +181,9 @@ func runRouteAddHTTP(args *docopt.Args, client controller.Client) error {
 	if err != nil {
 		return err
 	}
+	if args.Bool["--auto-tls"] && tlsCert != "" {
+		return errors.New("--auto-tls cannot be used with a TLS certificate")
+	}
 
 	u, err := url.Parse("http://" + args.String["<domain>"])
 	if err != nil {
@@ -162,10 +195,17 @@ func runRouteAddHTTP(args *docopt.Args, client controller.Client) error {
 		Domain:        u.Host,
 		LegacyTLSCert: tlsCert,
 		LegacyTLSKey:  tlsKey,
+		AutoTLS:       args.Bool["--auto-tls"],
 		Sticky:        args.Bool["--sticky"],
 		Leader:        args.Bool["--leader"],
 		Path:          u.Path,
 	}
+	if hr.Targets, err = parseRouteTargets(args); err != nil {
+		return err
+	}
+	if hr.Limits, err = parseRouteLimits(args, nil); err != nil {
+		return err
+	}
 	route := hr.ToRoute()
 	if err := client.CreateRoute(mustApp(), route); err != nil {
 		return err
@@ -183,11 +223,11 @@ func runRouteUpdateTCP(args *docopt.Args, client controller.Client) error {
 		return err
 	}
 
-	service := args.String["--service"]
-	if service == "" {
+	if service := args.String["--service"]; service != "" {
+		route.Service = service
+	} else if args.String["--max-conns"] == "" {
 		return errors.New("No service name given")
 	}
-	route.Service = service
 
 	if args.Bool["--leader"] {
 		route.Leader = true
@@ -195,6 +235,10 @@ func runRouteUpdateTCP(args *docopt.Args, client controller.Client) error {
 		route.Leader = false
 	}
 
+	if route.Limits, err = parseRouteLimits(args, route.Limits); err != nil {
+		return err
+	}
+
 	if err := client.UpdateRoute(appName, id, route); err != nil {
 		return err
 	}
@@ -222,6 +266,16 @@ func runRouteUpdateHTTP(args *docopt.Args, client controller.Client) error {
 		return err
 	}
 
+	if args.Bool["--auto-tls"] {
+		if route.LegacyTLSCert != "" {
+			return errors.New("--auto-tls cannot be used with a TLS certificate")
+		}
+		route.AutoTLS = true
+	} else if args.Bool["--no-auto-tls"] || route.LegacyTLSCert != "" {
+		// a certificate given by hand replaces the automatic one
+		route.AutoTLS = false
+	}
+
 	if args.Bool["--sticky"] {
 		route.Sticky = true
 	} else if args.Bool["--no-sticky"] {
@@ -234,6 +288,18 @@ func runRouteUpdateHTTP(args *docopt.Args, client controller.Client) error {
 		route.Leader = false
 	}
 
+	if args.Bool["--no-targets"] {
+		route.Targets = nil
+	} else if targets, err := parseRouteTargets(args); err != nil {
+		return err
+	} else if len(targets) > 0 {
+		route.Targets = targets
+	}
+
+	if route.Limits, err = parseRouteLimits(args, route.Limits); err != nil {
+		return err
+	}
+
 	if err := client.UpdateRoute(appName, id, route); err != nil {
 		return err
 	}
@@ -241,6 +307,76 @@ func runRouteUpdateHTTP(args *docopt.Args, client controller.Client) error {
 	return nil
 }
 
+// parseRouteTargets parses --target flags in the form SERVICE[@RELEASE]:WEIGHT
+func parseRouteTargets(args *docopt.Args) ([]*router.Target, error) {
+	flags, _ := args.All["--target"].([]string)
+	targets := make([]*router.Target, 0, len(flags))
+	for _, flag := range flags {
+		i := strings.LastIndex(flag, ":")
+		if i == -1 {
+			return nil, fmt.Errorf("Invalid target %q, expected SERVICE[@RELEASE]:WEIGHT", flag)
+		}
+		weight, err := strconv.Atoi(flag[i+1:])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("Invalid weight in target %q", flag)
+		}
+		target := &router.Target{Service: flag[:i], Weight: weight}
+		if j := strings.Index(target.Service, "@"); j != -1 {
+			target.Service, target.Release = target.Service[:j], target.Service[j+1:]
+		}
+		if target.Service == "" {
+			return nil, fmt.Errorf("Invalid target %q, no service given", flag)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// parseRouteLimits applies the limit flags which were given to a copy of the
+// existing limits, returning nil if no limits are left
+func parseRouteLimits(args *docopt.Args, existing *router.RouteLimits) (*router.RouteLimits, error) {
+	limits := &router.RouteLimits{}
+	if existing != nil {
+		*limits = *existing
+	}
+	for flag, limit := range map[string]*int{
+		"--rate-limit":     &limits.RequestsPerSecond,
+		"--max-concurrent": &limits.MaxConcurrentRequests,
+		"--max-conns":      &limits.MaxConnections,
+	} {
+		value := args.String[flag]
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid value for %s: %q", flag, value)
+		}
+		*limit = n
+	}
+	if limits.RequestsPerSecond == 0 && limits.MaxConcurrentRequests == 0 && limits.MaxConnections == 0 {
+		return nil, nil
+	}
+	return limits, nil
+}
+
+func formatRouteLimits(limits *router.RouteLimits) string {
+	if limits == nil {
+		return ""
+	}
+	var s []string
+	if limits.RequestsPerSecond > 0 {
+		s = append(s, fmt.Sprintf("%d req/s per IP", limits.RequestsPerSecond))
+	}
+	if limits.MaxConcurrentRequests > 0 {
+		s = append(s, fmt.Sprintf("%d concurrent", limits.MaxConcurrentRequests))
+	}
+	if limits.MaxConnections > 0 {
+		s = append(s, fmt.Sprintf("%d conns", limits.MaxConnections))
+	}
+	return strings.Join(s, ", ")
+}
+
 func parseTLSCert(args *docopt.Args) (string, string, error) {
 	tlsCertPath := args.String["--tls-cert"]
 	tlsKeyPath := args.String["--tls-key"]
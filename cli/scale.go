@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -29,10 +31,22 @@ db=3,disk=ssd,mem=high # 3 db processes, distributed amongst hosts tagged with
 
 Ommitting the arguments will show the current scale.
 
+With --autoscale, the autoscale policy of each process type is shown or
+changed instead. The autoscaler keeps the number of jobs of a process type
+between a minimum and maximum, scaling it to keep the average CPU usage,
+memory usage or request rate of each job near the given targets. Policies
+should be formatted like TYPE=MIN..MAX[,KEY=VAL...], for example:
+
+web=2..10,cpu=60       # 2 to 10 web processes, aiming for 60% of their CPU limit
+web=2..10,requests=50  # 2 to 10 web processes, aiming for 50 requests per second each
+worker=1..4,memory=80  # 1 to 4 worker processes, aiming for 80% of their memory limit
+worker=off             # stop autoscaling worker processes
+
 Options:
 	-n, --no-wait            don't wait for the scaling events to happen
 	-r, --release=<release>  id of release to scale (defaults to current app release)
 	-a, --all                show non-zero formations from all releases (only works when listing formations, can't be combined with --release)
+	--autoscale              show or change autoscale policies rather than the current scale
 
 Example:
 
@@ -56,6 +70,11 @@ Example:
 	02:28:37.601 ==> worker flynn-e24760c511af4733b01ed5b98aa54647 up
 
 	scale completed in 3.944629056s
+
+	$ flynn scale --autoscale web=2..10,cpu=60
+	$ flynn scale --autoscale
+	TYPE  MIN  MAX  CPU  MEMORY  REQUESTS
+	web   2    10   60%  -       -
 `)
 }
 
@@ -67,6 +86,13 @@ func runScale(args *docopt.Args, client controller.Client) error {
 
 	typeSpecs := args.All["<type>=<spec>"].([]string)
 
+	if args.Bool["--autoscale"] {
+		if args.Bool["--all"] || args.String["--release"] != "" {
+			return fmt.Errorf("ERROR: Can't use --autoscale in combination with --all or --release")
+		}
+		return runScaleAutoscale(client, app, typeSpecs)
+	}
+
 	showAll := args.Bool["--all"]
 
 	if len(typeSpecs) > 0 && showAll {
@@ -284,3 +310,139 @@ func scalingComplete(actual, expected map[string]int) bool {
 	}
 	return true
 }
+
+// takes args of the form "web=1..5[,cpu=N][,memory=N][,requests=N]" or "web=off"
+func runScaleAutoscale(client controller.Client, appName string, typeSpecs []string) error {
+	app, err := client.GetApp(appName)
+	if err != nil {
+		return err
+	}
+	if len(typeSpecs) == 0 {
+		return showAutoscale(client, app)
+	}
+
+	release, err := client.GetAppRelease(app.ID)
+	if err != nil {
+		return err
+	}
+	policies := make(ct.AutoscalePolicies, len(app.Autoscale)+len(typeSpecs))
+	for typ, policy := range app.Autoscale {
+		policies[typ] = policy
+	}
+	for _, arg := range typeSpecs {
+		i := strings.IndexRune(arg, '=')
+		if i < 0 {
+			return fmt.Errorf("ERROR: autoscale args must be of the form <typ>=<spec>")
+		}
+		processType := arg[:i]
+		if _, ok := release.Processes[processType]; !ok {
+			return fmt.Errorf("ERROR: unknown process type %q", processType)
+		}
+		if arg[i+1:] == "off" {
+			delete(policies, processType)
+			continue
+		}
+		policy, err := parseAutoscalePolicy(arg[i+1:])
+		if err != nil {
+			return fmt.Errorf("ERROR: %s in %q", err, arg)
+		}
+		policies[processType] = policy
+	}
+	if len(policies) == 0 {
+		policies = nil
+	}
+	return client.UpdateAppAutoscale(app.ID, policies)
+}
+
+func parseAutoscalePolicy(spec string) (*ct.AutoscalePolicy, error) {
+	parts := strings.Split(spec, ",")
+	bounds := strings.SplitN(parts[0], "..", 2)
+	if len(bounds) != 2 {
+		return nil, errors.New("bounds must be of the form MIN..MAX")
+	}
+	policy := &ct.AutoscalePolicy{}
+	var err error
+	if policy.Min, err = strconv.Atoi(bounds[0]); err != nil || policy.Min < 0 {
+		return nil, errors.New("could not parse minimum")
+	}
+	if policy.Max, err = strconv.Atoi(bounds[1]); err != nil || policy.Max < policy.Min {
+		return nil, errors.New("could not parse maximum, or it is less than the minimum")
+	}
+	for _, target := range parts[1:] {
+		keyVal := strings.SplitN(target, "=", 2)
+		if len(keyVal) != 2 {
+			return nil, fmt.Errorf("targets must be of the form KEY=VAL")
+		}
+		val, err := strconv.Atoi(strings.TrimSuffix(keyVal[1], "%"))
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("could not parse %s target", keyVal[0])
+		}
+		switch keyVal[0] {
+		case "cpu":
+			policy.TargetCPU = val
+		case "memory":
+			policy.TargetMemory = val
+		case "requests":
+			policy.TargetRequestRate = val
+		default:
+			return nil, fmt.Errorf("unknown target %q", keyVal[0])
+		}
+	}
+	return policy, nil
+}
+
+func showAutoscale(client controller.Client, app *ct.App) error {
+	w := tabWriter()
+	defer w.Flush()
+
+	types := make([]string, 0, len(app.Autoscale))
+	for typ := range app.Autoscale {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	target := func(val int, unit string) string {
+		if val == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d%s", val, unit)
+	}
+	listRec(w, "TYPE", "MIN", "MAX", "CPU", "MEMORY", "REQUESTS")
+	for _, typ := range types {
+		p := app.Autoscale[typ]
+		listRec(w, typ, p.Min, p.Max, target(p.TargetCPU, "%"), target(p.TargetMemory, "%"), target(p.TargetRequestRate, "/s"))
+	}
+
+	// show the autoscaling decisions among the most recent scale events
+	events, err := client.ListEvents(ct.ListEventsOptions{
+		AppID:       app.ID,
+		ObjectTypes: []ct.EventType{ct.EventTypeScale},
+		Count:       5,
+	})
+	if err != nil {
+		return err
+	}
+	var header bool
+	for _, event := range events {
+		var scale ct.Scale
+		if err := json.Unmarshal(event.Data, &scale); err != nil || scale.Reason == "" {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w)
+			listRec(w, "SCALED", "TO", "REASON")
+			header = true
+		}
+		listRec(w, humanTime(event.CreatedAt), formatProcesses(scale.Processes), scale.Reason)
+	}
+	return nil
+}
+
+func formatProcesses(processes map[string]int) string {
+	scale := make([]string, 0, len(processes))
+	for typ, n := range processes {
+		scale = append(scale, fmt.Sprintf("%s=%d", typ, n))
+	}
+	sort.Strings(scale)
+	return strings.Join(scale, " ")
+}
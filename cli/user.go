@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("user", runUser, `
+usage: flynn user
+       flynn user add [--role=<role>] [--app=<app>...] <name>
+       flynn user update <name> [--role=<role>] [--app=<app>...]
+       flynn user remove <name>
+       flynn user tokens <name>
+       flynn user token add [-d <description>] <name>
+       flynn user token remove <name> <token-id>
+
+Manage cluster users and their API tokens.
+
+Users have one of the following roles:
+
+	admin      full access to the cluster
+	deployer   read and write access to the apps given
+	           with --app
+	read-only  read access to the cluster
+
+Managing users requires the admin role.
+
+Options:
+	--role=<role>                      the user's role, defaults to read-only for new users
+	--app=<app>                        an app a deployer can access, can be given multiple times
+	-d, --description=<description>    a description of what the token is used for
+
+Commands:
+	With no arguments, shows a list of users.
+
+	add           adds a user
+	update        changes a user's role and apps
+	remove        removes a user and all of their tokens
+	tokens        lists a user's tokens
+	token add     creates a token for a user, which is only shown once
+	token remove  revokes a token
+
+Examples:
+
+	$ flynn user add --role=deployer --app=website --app=api jane
+	Created user jane.
+
+	$ flynn user token add --description=laptop jane
+	Created token 5a2b4c1e-3b8a-4f5e-9d6b-2a7c8e1f0d3b for user jane:
+
+	    e2c8a4f2d06b4c7e9d1a3b5f7e9c1a3b5d7f9e1a
+
+	Log in with 'flynn login <token>', the token will not be shown again.
+
+	$ flynn user
+	NAME  ROLE      APPS          CREATED
+	jane  deployer  website, api  2 minutes ago
+`)
+
+	register("login", runLogin, `
+usage: flynn login [<token>]
+
+Log in to the cluster as a user.
+
+Replaces the key stored for the cluster in ~/.flynnrc with a token created
+with 'flynn user token add', so that subsequent commands are made as the
+token's user, including 'git push' and 'flynn docker push'. Deployers can
+only push to and fetch from the apps given with --app, and read-only users
+cannot push. If <token> is not given it is read from stdin.
+
+Examples:
+
+	$ flynn login e2c8a4f2d06b4c7e9d1a3b5f7e9c1a3b5d7f9e1a
+	Logged in to cluster default as jane (deployer).
+`)
+
+	register("whoami", runWhoami, `
+usage: flynn whoami
+
+Show the user the cluster key is authenticated as.
+`)
+}
+
+func runUser(args *docopt.Args, client controller.Client) error {
+	if args.Bool["token"] {
+		if args.Bool["add"] {
+			return runUserTokenAdd(args, client)
+		}
+		return runUserTokenRemove(args, client)
+	} else if args.Bool["add"] {
+		return runUserAdd(args, client)
+	} else if args.Bool["update"] {
+		return runUserUpdate(args, client)
+	} else if args.Bool["remove"] {
+		return runUserRemove(args, client)
+	} else if args.Bool["tokens"] {
+		return runUserTokens(args, client)
+	}
+
+	users, err := client.UserList()
+	if err != nil {
+		return err
+	}
+	appNames, err := appNamesByID(client)
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+	listRec(w, "NAME", "ROLE", "APPS", "CREATED")
+	for _, u := range users {
+		listRec(w, u.Name, u.Role, formatUserApps(u, appNames), humanTime(u.CreatedAt))
+	}
+	return nil
+}
+
+func appNamesByID(client controller.Client) (map[string]string, error) {
+	apps, err := client.AppList()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(apps))
+	for _, app := range apps {
+		names[app.ID] = app.Name
+	}
+	return names, nil
+}
+
+func formatUserApps(u *ct.User, appNames map[string]string) string {
+	apps := make([]string, len(u.Apps))
+	for i, id := range u.Apps {
+		if name, ok := appNames[id]; ok {
+			apps[i] = name
+		} else {
+			apps[i] = id
+		}
+	}
+	return strings.Join(apps, ", ")
+}
+
+func runUserAdd(args *docopt.Args, client controller.Client) error {
+	user := &ct.User{
+		Name: args.String["<name>"],
+		Role: ct.Role(args.String["--role"]),
+		Apps: args.All["--app"].([]string),
+	}
+	if user.Role == "" {
+		user.Role = ct.RoleReadOnly
+	}
+	if err := client.CreateUser(user); err != nil {
+		return err
+	}
+	fmt.Printf("Created user %s.\n", user.Name)
+	return nil
+}
+
+func runUserUpdate(args *docopt.Args, client controller.Client) error {
+	user, err := client.GetUser(args.String["<name>"])
+	if err != nil {
+		return err
+	}
+	if role := args.String["--role"]; role != "" {
+		user.Role = ct.Role(role)
+	}
+	// apps are only valid for deployers, so clear them if the user is no
+	// longer one
+	if apps := args.All["--app"].([]string); len(apps) > 0 {
+		user.Apps = apps
+	} else if user.Role != ct.RoleDeployer {
+		user.Apps = nil
+	}
+	if err := client.UpdateUser(user); err != nil {
+		return err
+	}
+	fmt.Printf("Updated user %s.\n", user.Name)
+	return nil
+}
+
+func runUserRemove(args *docopt.Args, client controller.Client) error {
+	name := args.String["<name>"]
+	if err := client.DeleteUser(name); err != nil {
+		return err
+	}
+	fmt.Printf("Removed user %s.\n", name)
+	return nil
+}
+
+func runUserTokens(args *docopt.Args, client controller.Client) error {
+	tokens, err := client.UserTokenList(args.String["<name>"])
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+	listRec(w, "ID", "DESCRIPTION", "CREATED")
+	for _, t := range tokens {
+		listRec(w, t.ID, t.Description, humanTime(t.CreatedAt))
+	}
+	return nil
+}
+
+func runUserTokenAdd(args *docopt.Args, client controller.Client) error {
+	name := args.String["<name>"]
+	token := &ct.UserToken{Description: args.String["--description"]}
+	if err := client.CreateUserToken(name, token); err != nil {
+		return err
+	}
+	fmt.Printf("Created token %s for user %s:\n\n    %s\n\n", token.ID, name, token.Token)
+	fmt.Println("Log in with 'flynn login <token>', the token will not be shown again.")
+	return nil
+}
+
+func runUserTokenRemove(args *docopt.Args, client controller.Client) error {
+	id := args.String["<token-id>"]
+	if err := client.DeleteUserToken(args.String["<name>"], id); err != nil {
+		return err
+	}
+	fmt.Printf("Token %s removed.\n", id)
+	return nil
+}
+
+func runLogin(args *docopt.Args) error {
+	token := args.String["<token>"]
+	if token == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("error reading token: %s", err)
+		}
+		token = strings.TrimSpace(line)
+	}
+	if token == "" {
+		return errors.New("token must not be empty")
+	}
+
+	cluster, err := getCluster()
+	if err != nil {
+		return err
+	}
+
+	// check the token is valid before saving it
+	c := *cluster
+	c.Key = token
+	client, err := c.Client()
+	if err != nil {
+		return err
+	}
+	user, err := client.CurrentUser()
+	if err != nil {
+		return fmt.Errorf("error authenticating with token: %s", err)
+	}
+
+	cluster.Key = token
+	if err := config.SaveTo(configPath()); err != nil {
+		return err
+	}
+	fmt.Printf("Logged in to cluster %s as %s.\n", cluster.Name, formatUser(user))
+	return nil
+}
+
+func runWhoami(args *docopt.Args, client controller.Client) error {
+	user, err := client.CurrentUser()
+	if err != nil {
+		return err
+	}
+	fmt.Println(formatUser(user))
+	return nil
+}
+
+func formatUser(u *ct.User) string {
+	// cluster keys are not associated with a user
+	if u.ID == "" {
+		return "cluster key (admin)"
+	}
+	return fmt.Sprintf("%s (%s)", u.Name, u.Role)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/flynn/flynn/controller/client"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("webhook", runWebhook, `
+usage: flynn webhook secret [<secret>]
+       flynn webhook disable
+
+Manage push webhooks, which deploy an application when a branch is pushed.
+
+Webhook payloads are signed with the application's secret, which can be set
+but not retrieved, so the secret is only shown when it is generated.
+
+Commands:
+	secret   sets the webhook secret, generating one if it isn't given, and enables webhooks
+	disable  removes the webhook secret, disabling webhooks
+
+Examples:
+
+	$ flynn webhook secret
+	Webhook secret: 2f9c5e4b8a7d1c3e6f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e
+
+	$ flynn webhook disable
+`)
+}
+
+func runWebhook(args *docopt.Args, client controller.Client) error {
+	app := mustApp()
+	if args.Bool["disable"] {
+		return client.DeleteWebhookSecret(app)
+	}
+	secret := args.String["<secret>"]
+	generated := secret == ""
+	if generated {
+		secret = random.Hex(32)
+	}
+	if err := client.SetWebhookSecret(app, secret); err != nil {
+		return err
+	}
+	if generated {
+		fmt.Println("Webhook secret:", secret)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flynn/flynn/controller/name"
 	"github.com/flynn/flynn/controller/schema"
@@ -32,16 +33,23 @@ type AppRepo struct {
 	defaultDomain string
 
 	db *postgres.DB
+	q  *que.Client
 }
 
 type appUpdate map[string]interface{}
 
-func NewAppRepo(db *postgres.DB, defaultDomain string, router routerc.Client) *AppRepo {
-	return &AppRepo{db: db, defaultDomain: defaultDomain, router: router}
+func NewAppRepo(db *postgres.DB, q *que.Client, defaultDomain string, router routerc.Client) *AppRepo {
+	return &AppRepo{db: db, q: q, defaultDomain: defaultDomain, router: router}
 }
 
 func (r *AppRepo) Add(data interface{}) error {
 	app := data.(*ct.App)
+	if err := validateAutoscale(app.Autoscale); err != nil {
+		return err
+	}
+	if err := validateMeta(app.Meta); err != nil {
+		return err
+	}
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
@@ -67,7 +75,7 @@ func (r *AppRepo) Add(data interface{}) error {
 	if app.DeployTimeout == 0 {
 		app.DeployTimeout = ct.DefaultDeployTimeout
 	}
-	if err := tx.QueryRow("app_insert", app.ID, app.Name, app.Meta, app.Strategy, app.DeployTimeout).Scan(&app.CreatedAt, &app.UpdatedAt); err != nil {
+	if err := tx.QueryRow("app_insert", app.ID, app.Name, app.Meta, app.Strategy, app.DeployTimeout, app.DeployOptions, app.Autoscale).Scan(&app.CreatedAt, &app.UpdatedAt); err != nil {
 		tx.Rollback()
 		if postgres.IsUniquenessError(err, "apps_name_idx") {
 			return httphelper.ObjectExistsErr(fmt.Sprintf("application %q already exists", app.Name))
@@ -80,6 +88,11 @@ func (r *AppRepo) Add(data interface{}) error {
 		app.Meta = make(map[string]string)
 	}
 
+	if err := r.enqueueReviewAppExpiry(tx, app); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := createEvent(tx.Exec, &ct.Event{
 		AppID:      app.ID,
 		ObjectID:   app.ID,
@@ -107,7 +120,7 @@ func (r *AppRepo) Add(data interface{}) error {
 func scanApp(s postgres.Scanner) (*ct.App, error) {
 	app := &ct.App{}
 	var releaseID *string
-	err := s.Scan(&app.ID, &app.Name, &app.Meta, &app.Strategy, &releaseID, &app.DeployTimeout, &app.CreatedAt, &app.UpdatedAt)
+	err := s.Scan(&app.ID, &app.Name, &app.Meta, &app.Strategy, &releaseID, &app.DeployTimeout, &app.DeployOptions, &app.Autoscale, &app.CreatedAt, &app.UpdatedAt)
 	if err == pgx.ErrNoRows {
 		return nil, ErrNotFound
 	} else if err != nil {
@@ -123,6 +136,75 @@ func scanApp(s postgres.Scanner) (*ct.App, error) {
 	return app, err
 }
 
+func validateAutoscale(policies ct.AutoscalePolicies) error {
+	for typ, policy := range policies {
+		if policy == nil {
+			return ct.ValidationError{Field: "autoscale." + typ, Message: "must not be null"}
+		}
+		if policy.Max < policy.Min {
+			return ct.ValidationError{Field: "autoscale." + typ + ".max", Message: "must be greater than or equal to min"}
+		}
+	}
+	return nil
+}
+
+// webhookSecretMetaKey is the app meta key which webhook secrets were kept in
+// before being moved out of meta, which is returned by the API
+const webhookSecretMetaKey = "webhook.secret"
+
+func validateMeta(meta map[string]string) error {
+	if _, ok := meta[webhookSecretMetaKey]; ok {
+		return ct.ValidationError{Field: "meta." + webhookSecretMetaKey, Message: "must be set with the webhook secret API rather than in meta"}
+	}
+	if v, ok := meta[ct.ReviewExpiresAtMetaKey]; ok {
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return ct.ValidationError{Field: "meta." + ct.ReviewExpiresAtMetaKey, Message: "must be an RFC3339 timestamp"}
+		}
+	}
+	return nil
+}
+
+// GetWebhookSecret returns the secret which the app's webhook payloads are
+// signed with, or ErrNotFound if the app doesn't have one
+func (r *AppRepo) GetWebhookSecret(appID string) (string, error) {
+	var secret string
+	err := r.db.QueryRow("webhook_secret_select", appID).Scan(&secret)
+	if err == pgx.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return secret, err
+}
+
+func (r *AppRepo) SetWebhookSecret(appID, secret string) error {
+	return r.db.Exec("webhook_secret_upsert", appID, secret)
+}
+
+func (r *AppRepo) RemoveWebhookSecret(appID string) error {
+	return r.db.Exec("webhook_secret_delete", appID)
+}
+
+// enqueueReviewAppExpiry enqueues a job to delete a review app when it
+// expires. A job enqueued for a previous expiry time is ignored when it runs
+// as the app no longer expires then.
+func (r *AppRepo) enqueueReviewAppExpiry(tx *postgres.DBTx, app *ct.App) error {
+	expiresAt := app.ReviewExpiresAt()
+	if app.ReviewParent() == "" || expiresAt == nil {
+		return nil
+	}
+	args, err := json.Marshal(ct.ReviewAppExpiry{
+		AppID:     app.ID,
+		ExpiresAt: *expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	return r.q.EnqueueInTx(&que.Job{
+		Type:  "review_app_expiry",
+		Args:  args,
+		RunAt: *expiresAt,
+	}, tx.Tx)
+}
+
 var idPattern = regexp.MustCompile(`^[a-f0-9]{8}-?([a-f0-9]{4}-?){3}[a-f0-9]{12}$`)
 
 type rowQueryer interface {
@@ -177,6 +259,7 @@ func (r *AppRepo) Update(id string, data map[string]interface{}) (interface{}, e
 				tx.Rollback()
 				return nil, fmt.Errorf("controller: expected map[string]interface{}, got %T", v)
 			}
+			prevExpiry := app.Meta[ct.ReviewExpiresAtMetaKey]
 			app.Meta = make(map[string]string, len(data))
 			for k, v := range data {
 				s, ok := v.(string)
@@ -186,10 +269,20 @@ func (r *AppRepo) Update(id string, data map[string]interface{}) (interface{}, e
 				}
 				app.Meta[k] = s
 			}
+			if err := validateMeta(app.Meta); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
 			if err := tx.Exec("app_update_meta", app.ID, app.Meta); err != nil {
 				tx.Rollback()
 				return nil, err
 			}
+			if app.Meta[ct.ReviewExpiresAtMetaKey] != prevExpiry {
+				if err := r.enqueueReviewAppExpiry(tx, app); err != nil {
+					tx.Rollback()
+					return nil, err
+				}
+			}
 		case "deploy_timeout":
 			timeout, ok := v.(json.Number)
 			if !ok {
@@ -206,6 +299,42 @@ func (r *AppRepo) Update(id string, data map[string]interface{}) (interface{}, e
 				tx.Rollback()
 				return nil, err
 			}
+		case "deploy_options":
+			// the options were decoded as a generic map, so round trip
+			// them through JSON to get a *ct.DeployOptions
+			data, err := json.Marshal(v)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			app.DeployOptions = nil
+			if err := json.Unmarshal(data, &app.DeployOptions); err != nil {
+				tx.Rollback()
+				return nil, fmt.Errorf("controller: unable to decode deploy options: %s", err)
+			}
+			if err := tx.Exec("app_update_deploy_options", app.ID, app.DeployOptions); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		case "autoscale":
+			data, err := json.Marshal(v)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			app.Autoscale = nil
+			if err := json.Unmarshal(data, &app.Autoscale); err != nil {
+				tx.Rollback()
+				return nil, fmt.Errorf("controller: unable to decode autoscale policies: %s", err)
+			}
+			if err := validateAutoscale(app.Autoscale); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			if err := tx.Exec("app_update_autoscale", app.ID, app.Autoscale); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
 		}
 	}
 
@@ -226,6 +355,18 @@ func (r *AppRepo) List() (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	return appList(rows)
+}
+
+func (r *AppRepo) ListForApps(appIDs []string) (interface{}, error) {
+	rows, err := r.db.Query("app_list_by_ids", appIDs)
+	if err != nil {
+		return nil, err
+	}
+	return appList(rows)
+}
+
+func appList(rows *pgx.Rows) ([]*ct.App, error) {
 	apps := []*ct.App{}
 	for rows.Next() {
 		app, err := scanApp(rows)
@@ -336,6 +477,52 @@ func (c *controllerAPI) ScheduleAppGarbageCollection(ctx context.Context, w http
 	w.WriteHeader(200)
 }
 
+// SetWebhookSecret sets the secret which the app's webhook payloads are
+// signed with, enabling webhooks for the app. The secret is not returned.
+func (c *controllerAPI) SetWebhookSecret(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var s ct.WebhookSecret
+	if err := httphelper.DecodeJSON(req, &s); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if s.Secret == "" {
+		respondWithError(w, ct.ValidationError{Field: "secret", Message: "must not be empty"})
+		return
+	}
+	if err := c.appRepo.SetWebhookSecret(c.getApp(ctx).ID, s.Secret); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// DeleteWebhookSecret disables the app's webhooks
+func (c *controllerAPI) DeleteWebhookSecret(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if err := c.appRepo.RemoveWebhookSecret(c.getApp(ctx).ID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// VerifyWebhookSignature checks the signature of a webhook payload against
+// the app's webhook secret, responding with a 404 if the app doesn't have one
+func (c *controllerAPI) VerifyWebhookSignature(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var sig ct.WebhookSignature
+	if err := httphelper.DecodeJSON(req, &sig); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	secret, err := c.appRepo.GetWebhookSecret(c.getApp(ctx).ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &ct.WebhookSignature{
+		Valid: utils.ValidWebhookSignature(sig.Signature, sig.Payload, []byte(secret)),
+	})
+}
+
 func (c *controllerAPI) AppLog(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithCancel(ctx)
 
@@ -354,6 +541,23 @@ func (c *controllerAPI) AppLog(ctx context.Context, w http.ResponseWriter, req *
 		}
 		opts.Lines = &lines
 	}
+	if since := req.FormValue("since"); since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			httphelper.ValidationError(w, "since", "since must be an RFC3339 timestamp")
+			return
+		}
+		opts.Since = t
+	}
+	if until := req.FormValue("until"); until != "" {
+		t, err := time.Parse(time.RFC3339Nano, until)
+		if err != nil {
+			httphelper.ValidationError(w, "until", "until must be an RFC3339 timestamp")
+			return
+		}
+		opts.Until = t
+	}
+	opts.Query = req.FormValue("query")
 	rc, err := c.logaggc.GetLog(c.getApp(ctx).ID, &opts)
 	if err != nil {
 		respondWithError(w, err)
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/postgres"
+	"golang.org/x/net/context"
+)
+
+var errUnauthenticated = errors.New("controller: invalid key")
+
+// authorizer authenticates requests using either one of the cluster keys,
+// which have full access to the API, or a user token, in which case the
+// user's role determines which requests are allowed.
+type authorizer struct {
+	keys  []string
+	users *UserRepo
+	db    *postgres.DB
+}
+
+func requestKey(r *http.Request) string {
+	_, password, _ := r.BasicAuth()
+	if password == "" && (strings.Contains(r.Header.Get("Accept"), "text/event-stream") || r.URL.Path == "/backup") {
+		password = r.URL.Query().Get("key")
+	}
+	return password
+}
+
+// authenticate returns the user a request is authenticated as along with the
+// ID of the token used. Requests using a cluster key are authenticated as an
+// admin with no ID.
+func (a *authorizer) authenticate(r *http.Request) (*ct.User, string, error) {
+	key := requestKey(r)
+	if key == "" {
+		return nil, "", errUnauthenticated
+	}
+	for _, k := range a.keys {
+		if len(key) == len(k) && subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			return &ct.User{Role: ct.RoleAdmin}, "", nil
+		}
+	}
+	user, tokenID, err := a.users.Authenticate(key)
+	if err == ErrNotFound {
+		return nil, "", errUnauthenticated
+	}
+	return user, tokenID, err
+}
+
+// requestApp returns the ID of the app a request is scoped to, if any
+func (a *authorizer) requestApp(p string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != "apps" || parts[1] == "" {
+		return "", nil
+	}
+	app, err := selectApp(a.db, parts[1], false)
+	if err == ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return app.ID, nil
+}
+
+// adminOnly returns whether only admins can make requests to the given path,
+// backups containing every app's secrets and users only being managed by admins
+func adminOnly(p string) bool {
+	return p == "/backup" || p == "/users" || strings.HasPrefix(p, "/users/")
+}
+
+// hasApp returns whether the given app IDs include appID
+func hasApp(appIDs []string, appID string) bool {
+	for _, id := range appIDs {
+		if id == appID {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed returns whether the user's role permits the mutating request,
+// appID being the app the request is scoped to
+func allowed(user *ct.User, method, p, appID string) bool {
+	if user.Role == ct.RoleAdmin {
+		return true
+	}
+	if adminOnly(p) || user.Role != ct.RoleDeployer {
+		return false
+	}
+	// deploying new code requires creating artifacts and releases
+	if method == "POST" && (p == "/artifacts" || p == "/releases") {
+		return true
+	}
+	return appID != "" && hasApp(user.Apps, appID)
+}
+
+// deployerReads are the paths outside of their apps which deployers can read,
+// which either aren't specific to any app or are filtered to the user's apps
+// by their handlers
+var deployerReads = map[string]struct{}{
+	"/user":    {},
+	"/ca-cert": {},
+	"/apps":    {},
+	"/events":  {},
+}
+
+// allowedRead returns whether the user's role permits the read request.
+// Deployers can only read their apps and the objects which belong to them.
+func (a *authorizer) allowedRead(user *ct.User, p string) (bool, error) {
+	if user.Role == ct.RoleAdmin {
+		return true, nil
+	}
+	if adminOnly(p) {
+		return false, nil
+	}
+	if user.Role != ct.RoleDeployer {
+		return true, nil
+	}
+	if _, ok := deployerReads[p]; ok {
+		return true, nil
+	}
+	appIDs, err := a.objectApps(p)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range appIDs {
+		if hasApp(user.Apps, id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// objectAppQueries are the queries which select the IDs of the apps an object
+// belongs to, keyed by the path the object is read from
+var objectAppQueries = map[string]string{
+	"releases":    "release_app_ids",
+	"artifacts":   "artifact_app_ids",
+	"deployments": "deployment_app_ids",
+	"events":      "event_app_ids",
+}
+
+// objectApps returns the IDs of the apps the object a read request is for
+// belongs to, which is none for requests which aren't for a single app or
+// object
+func (a *authorizer) objectApps(p string) ([]string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return nil, nil
+	}
+	if parts[0] == "apps" {
+		appID, err := a.requestApp(p)
+		if err != nil || appID == "" {
+			return nil, err
+		}
+		return []string{appID}, nil
+	}
+	query, ok := objectAppQueries[parts[0]]
+	if !ok || len(parts) > 2 {
+		return nil, nil
+	}
+	var id interface{} = parts[1]
+	if parts[0] == "events" {
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, nil
+		}
+		id = n
+	} else if !idPattern.MatchString(parts[1]) {
+		return nil, nil
+	}
+	rows, err := a.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	var appIDs []string
+	for rows.Next() {
+		var appID string
+		if err := rows.Scan(&appID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		appIDs = append(appIDs, appID)
+	}
+	return appIDs, rows.Err()
+}
+
+// userApps returns the apps the user making the request can access if they
+// can only access some apps, which is the case for deployers
+func userApps(ctx context.Context) ([]string, bool) {
+	user, ok := ctx.Value("user").(*ct.User)
+	if !ok || user.Role != ct.RoleDeployer {
+		return nil, false
+	}
+	return user.Apps, true
+}
+
+// isJobStateUpdate returns whether the request is the scheduler updating the
+// state of a job, which is not audited as it happens for every job state
+// change and is already recorded as a job event
+func isJobStateUpdate(method, p string) bool {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	return method == "PUT" && len(parts) == 4 && parts[0] == "apps" && parts[2] == "jobs"
+}
+
+// audit records a mutating request as an audit event
+func (a *authorizer) audit(user *ct.User, tokenID, appID string, r *http.Request, rw *httphelper.ResponseWriter) error {
+	status := rw.Status()
+	if status == 0 {
+		status = 200
+	}
+	reqID, _ := ctxhelper.RequestIDFromContext(rw.Context())
+	return createEvent(a.db.Exec, &ct.Event{
+		AppID:      appID,
+		ObjectID:   user.ID,
+		ObjectType: ct.EventTypeAudit,
+	}, &ct.AuditEvent{
+		UserID:    user.ID,
+		UserName:  user.Name,
+		TokenID:   tokenID,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Status:    status,
+		RequestID: reqID,
+	})
+}
+
+func (a *authorizer) ServeHTTP(main http.Handler, w http.ResponseWriter, r *http.Request) {
+	user, tokenID, err := a.authenticate(r)
+	if err == errUnauthenticated {
+		w.WriteHeader(401)
+		return
+	} else if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+
+	// mutating requests are audited along with the app they are scoped to,
+	// whereas reads are only scoped to apps for deployers
+	p := path.Clean(r.URL.Path)
+	readOnly := r.Method == "GET" || r.Method == "HEAD"
+	var appID string
+	var ok bool
+	if readOnly {
+		ok, err = a.allowedRead(user, p)
+	} else if appID, err = a.requestApp(p); err == nil {
+		ok = allowed(user, r.Method, p, appID)
+	}
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	rw := w.(*httphelper.ResponseWriter)
+	if ok {
+		// make the user available to handlers which filter what they
+		// return to the user's apps
+		main.ServeHTTP(httphelper.NewResponseWriter(rw, context.WithValue(rw.Context(), "user", user)), r)
+	} else {
+		httphelper.ForbiddenError(w, "user does not have permission to make this request")
+	}
+	if readOnly || isJobStateUpdate(r.Method, p) {
+		return
+	}
+
+	if err := a.audit(user, tokenID, appID, r, rw); err != nil {
+		logger, _ := ctxhelper.LoggerFromContext(rw.Context())
+		logger.Error("error creating audit event", "err", err)
+	}
+}
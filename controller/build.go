@@ -0,0 +1,271 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/flynn/flynn/controller/schema"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+)
+
+// defaultBuildListCount is the number of builds listed if no count is given
+const defaultBuildListCount = 50
+
+type BuildRepo struct {
+	db *postgres.DB
+}
+
+func NewBuildRepo(db *postgres.DB) *BuildRepo {
+	return &BuildRepo{db: db}
+}
+
+func (r *BuildRepo) Add(b *ct.Build) error {
+	if b.ID == "" {
+		b.ID = random.UUID()
+	}
+	if b.State == "" {
+		b.State = ct.BuildStatePending
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.QueryRow("build_insert", b.ID, b.AppID, b.Rev, string(b.State), b.CacheKey, b.CacheHit).Scan(&b.CreatedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if b.State != ct.BuildStatePending {
+		// builds created in another state are started immediately
+		if err := r.update(tx, b); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	setBuildDurations(b)
+	if err := createBuildEvent(tx, b); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update changes the state of a build, which can't be changed once the build
+// has finished. The build's start and end times are set when it leaves the
+// pending state and finishes respectively.
+func (r *BuildRepo) Update(b *ct.Build) error {
+	prev, err := r.Get(b.ID)
+	if err != nil {
+		return err
+	}
+	if prev.State.Finished() {
+		return ct.ValidationError{Field: "state", Message: "cannot be changed once the build has finished"}
+	}
+	b.AppID = prev.AppID
+	b.Rev = prev.Rev
+	b.CreatedAt = prev.CreatedAt
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := r.update(tx, b); err != nil {
+		tx.Rollback()
+		return err
+	}
+	setBuildDurations(b)
+	if err := createBuildEvent(tx, b); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *BuildRepo) update(tx *postgres.DBTx, b *ct.Build) error {
+	var releaseID *string
+	if b.ReleaseID != "" {
+		releaseID = &b.ReleaseID
+	}
+	err := tx.QueryRow(
+		"build_update",
+		b.ID,
+		string(b.State),
+		releaseID,
+		b.Error,
+		b.CacheKey,
+		b.CacheHit,
+	).Scan(&b.StartedAt, &b.EndedAt)
+	if postgres.IsPostgresCode(err, postgres.ForeignKeyViolation) {
+		return ct.ValidationError{Field: "release", Message: "does not exist"}
+	}
+	return err
+}
+
+func createBuildEvent(tx *postgres.DBTx, b *ct.Build) error {
+	return createEvent(tx.Exec, &ct.Event{
+		AppID:      b.AppID,
+		ObjectID:   b.ID,
+		ObjectType: ct.EventTypeBuild,
+	}, b)
+}
+
+func (r *BuildRepo) Get(id string) (*ct.Build, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	return scanBuild(r.db.QueryRow("build_select", id))
+}
+
+// List returns the app's most recent builds, newest first.
+func (r *BuildRepo) List(appID string, count int) ([]*ct.Build, error) {
+	rows, err := r.db.Query("build_list_by_app", appID, count)
+	if err != nil {
+		return nil, err
+	}
+	builds := []*ct.Build{}
+	for rows.Next() {
+		b, err := scanBuild(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		builds = append(builds, b)
+	}
+	return builds, rows.Err()
+}
+
+func scanBuild(s postgres.Scanner) (*ct.Build, error) {
+	b := &ct.Build{}
+	var state string
+	var releaseID *string
+	err := s.Scan(&b.ID, &b.AppID, &b.Rev, &state, &releaseID, &b.Error, &b.CacheKey, &b.CacheHit, &b.CreatedAt, &b.StartedAt, &b.EndedAt)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	b.State = ct.BuildState(state)
+	if releaseID != nil {
+		b.ReleaseID = *releaseID
+	}
+	setBuildDurations(b)
+	return b, nil
+}
+
+// setBuildDurations sets the durations of the build from its timestamps,
+// using the current time for those not reached yet
+func setBuildDurations(b *ct.Build) {
+	b.QueueDuration = nil
+	b.Duration = nil
+	if b.CreatedAt == nil {
+		return
+	}
+	now := time.Now()
+	if b.StartedAt == nil {
+		d := now.Sub(*b.CreatedAt)
+		b.QueueDuration = &d
+		return
+	}
+	queued := b.StartedAt.Sub(*b.CreatedAt)
+	b.QueueDuration = &queued
+	end := now
+	if b.EndedAt != nil {
+		end = *b.EndedAt
+	}
+	d := end.Sub(*b.StartedAt)
+	b.Duration = &d
+}
+
+func (c *controllerAPI) getBuild(ctx context.Context) (*ct.Build, error) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	b, err := c.buildRepo.Get(params.ByName("builds_id"))
+	if err != nil {
+		return nil, err
+	}
+	if b.AppID != c.getApp(ctx).ID {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
+
+func (c *controllerAPI) CreateBuild(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var b ct.Build
+	if err := httphelper.DecodeJSON(req, &b); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	b.AppID = c.getApp(ctx).ID
+	b.QueueDuration = nil
+	b.Duration = nil
+	if b.State == "" {
+		b.State = ct.BuildStatePending
+	}
+	if err := schema.Validate(b); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.buildRepo.Add(&b); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &b)
+}
+
+func (c *controllerAPI) UpdateBuild(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	prev, err := c.getBuild(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var b ct.Build
+	if err := httphelper.DecodeJSON(req, &b); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	b.ID = prev.ID
+	b.AppID = prev.AppID
+	b.QueueDuration = nil
+	b.Duration = nil
+	if err := schema.Validate(b); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.buildRepo.Update(&b); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &b)
+}
+
+func (c *controllerAPI) GetBuild(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	b, err := c.getBuild(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, b)
+}
+
+func (c *controllerAPI) ListBuilds(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	count := defaultBuildListCount
+	if s := req.FormValue("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			respondWithError(w, ct.ValidationError{Field: "count", Message: "must be a positive integer"})
+			return
+		}
+		count = n
+	}
+	list, err := c.buildRepo.List(c.getApp(ctx).ID, count)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestBuilds(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "build-test"})
+	release := s.createTestRelease(c, &ct.Release{})
+
+	events := make(chan *ct.Event)
+	stream, err := s.c.StreamEvents(ct.StreamEventsOptions{
+		AppID:       app.ID,
+		ObjectTypes: []ct.EventType{ct.EventTypeBuild},
+	}, events)
+	c.Assert(err, IsNil)
+	defer stream.Close()
+	nextEvent := func(state ct.BuildState) *ct.Build {
+		event := <-events
+		c.Assert(event.ObjectType, Equals, ct.EventTypeBuild)
+		var b ct.Build
+		c.Assert(json.Unmarshal(event.Data, &b), IsNil)
+		c.Assert(b.State, Equals, state)
+		return &b
+	}
+
+	// builds are pending until they leave the queue
+	build := &ct.Build{Rev: "0f1e2d3c", CacheKey: "abc"}
+	c.Assert(s.c.CreateBuild(app.ID, build), IsNil)
+	c.Assert(build.ID, Not(Equals), "")
+	c.Assert(build.AppID, Equals, app.ID)
+	c.Assert(build.State, Equals, ct.BuildStatePending)
+	c.Assert(build.CreatedAt, NotNil)
+	c.Assert(build.StartedAt, IsNil)
+	event := nextEvent(ct.BuildStatePending)
+	c.Assert(event.ID, Equals, build.ID)
+	c.Assert(event.Rev, Equals, build.Rev)
+
+	// starting the build records how long it was queued
+	build.State = ct.BuildStateRunning
+	build.CacheHit = true
+	c.Assert(s.c.UpdateBuild(app.ID, build), IsNil)
+	c.Assert(build.StartedAt, NotNil)
+	c.Assert(build.QueueDuration, NotNil)
+	c.Assert(build.Duration, NotNil)
+	event = nextEvent(ct.BuildStateRunning)
+	c.Assert(event.CacheHit, Equals, true)
+	c.Assert(*event.QueueDuration, Equals, build.StartedAt.Sub(*build.CreatedAt))
+
+	// finishing the build records its duration
+	build.State = ct.BuildStateSucceeded
+	build.ReleaseID = release.ID
+	c.Assert(s.c.UpdateBuild(app.ID, build), IsNil)
+	c.Assert(build.EndedAt, NotNil)
+	event = nextEvent(ct.BuildStateSucceeded)
+	c.Assert(event.ReleaseID, Equals, release.ID)
+	c.Assert(*event.Duration, Equals, build.EndedAt.Sub(*build.StartedAt))
+
+	// finished builds can't be changed
+	build.State = ct.BuildStateFailed
+	err = s.c.UpdateBuild(app.ID, build)
+	e, ok := err.(httphelper.JSONError)
+	c.Assert(ok, Equals, true, Commentf("err = %v", err))
+	c.Assert(e.Code, Equals, httphelper.ValidationErrorCode)
+
+	gotten, err := s.c.GetBuild(app.ID, build.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotten.State, Equals, ct.BuildStateSucceeded)
+	c.Assert(gotten.Rev, Equals, build.Rev)
+	c.Assert(gotten.ReleaseID, Equals, release.ID)
+	c.Assert(*gotten.Duration, Equals, *event.Duration)
+
+	// builds are listed newest first
+	cancelled := &ct.Build{Rev: "4b5a6978"}
+	c.Assert(s.c.CreateBuild(app.ID, cancelled), IsNil)
+	nextEvent(ct.BuildStatePending)
+	cancelled.State = ct.BuildStateCancelled
+	c.Assert(s.c.UpdateBuild(app.ID, cancelled), IsNil)
+	nextEvent(ct.BuildStateCancelled)
+	list, err := s.c.BuildList(app.ID, 0)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
+	c.Assert(list[0].ID, Equals, cancelled.ID)
+	c.Assert(list[1].ID, Equals, build.ID)
+	list, err = s.c.BuildList(app.ID, 1)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+
+	// builds are not accessible from other apps
+	other := s.createTestApp(c, &ct.App{Name: "build-test-other"})
+	_, err = s.c.GetBuild(other.ID, build.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+	list, err = s.c.BuildList(other.ID, 0)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+}
+
+func (s *S) TestBuildValidation(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "build-validation-test"})
+
+	for _, build := range []*ct.Build{
+		{State: "unknown"},
+		{State: ct.BuildStateSucceeded, ReleaseID: "6c0b4ed1-5e2c-4b3a-9a13-8ec4e2bd1a8f"},
+	} {
+		err := s.c.CreateBuild(app.ID, build)
+		e, ok := err.(httphelper.JSONError)
+		c.Assert(ok, Equals, true, Commentf("build = %+v, err = %v", build, err))
+		c.Assert(e.Code, Equals, httphelper.ValidationErrorCode)
+	}
+}
@@ -27,6 +27,7 @@ type Client interface {
 	CreateApp(app *ct.App) error
 	UpdateApp(app *ct.App) error
 	UpdateAppMeta(app *ct.App) error
+	UpdateAppAutoscale(appID string, policies ct.AutoscalePolicies) error
 	DeleteApp(appID string) (*ct.AppDeletion, error)
 	CreateProvider(provider *ct.Provider) error
 	GetProvider(providerID string) (*ct.Provider, error)
@@ -62,6 +63,7 @@ type Client interface {
 	GetDeployment(deploymentID string) (*ct.Deployment, error)
 	CreateDeployment(appID, releaseID string) (*ct.Deployment, error)
 	DeploymentList(appID string) ([]*ct.Deployment, error)
+	RollbackDeployment(appID string) (*ct.Deployment, error)
 	StreamDeployment(d *ct.Deployment, output chan *ct.DeploymentEvent) (stream.Stream, error)
 	DeployAppRelease(appID, releaseID string, stopWait <-chan struct{}) error
 	StreamJobEvents(appID string, output chan *ct.Job) (stream.Stream, error)
@@ -88,6 +90,39 @@ type Client interface {
 	GetBackupMeta() (*ct.ClusterBackup, error)
 	DeleteRelease(appID, releaseID string) (*ct.ReleaseDeletion, error)
 	ScheduleAppGarbageCollection(appID string) error
+	CreateSchedule(appID string, schedule *ct.Schedule) error
+	UpdateSchedule(schedule *ct.Schedule) error
+	GetSchedule(appID, scheduleID string) (*ct.Schedule, error)
+	ScheduleList(appID string) ([]*ct.Schedule, error)
+	DeleteSchedule(appID, scheduleID string) error
+	CreateLogDrain(appID string, drain *ct.LogDrain) error
+	GetLogDrain(appID, drainID string) (*ct.LogDrain, error)
+	LogDrainList(appID string) ([]*ct.LogDrain, error)
+	AllLogDrainList() ([]*ct.LogDrain, error)
+	DeleteLogDrain(appID, drainID string) error
+	SetWebhookSecret(appID, secret string) error
+	DeleteWebhookSecret(appID string) error
+	VerifyWebhookSignature(appID string, sig *ct.WebhookSignature) error
+	CreateBuild(appID string, build *ct.Build) error
+	UpdateBuild(appID string, build *ct.Build) error
+	GetBuild(appID, buildID string) (*ct.Build, error)
+	BuildList(appID string, count int) ([]*ct.Build, error)
+	CreatePipeline(pipeline *ct.Pipeline) error
+	UpdatePipeline(pipeline *ct.Pipeline) error
+	GetPipeline(pipelineID string) (*ct.Pipeline, error)
+	PipelineList() ([]*ct.Pipeline, error)
+	DeletePipeline(pipelineID string) error
+	Promote(pipelineID string, promotion *ct.Promotion) error
+	PromotionList(pipelineID string, count int) ([]*ct.Promotion, error)
+	CurrentUser() (*ct.User, error)
+	CreateUser(user *ct.User) error
+	UpdateUser(user *ct.User) error
+	GetUser(userID string) (*ct.User, error)
+	UserList() ([]*ct.User, error)
+	DeleteUser(userID string) error
+	CreateUserToken(userID string, token *ct.UserToken) error
+	UserTokenList(userID string) ([]*ct.UserToken, error)
+	DeleteUserToken(userID, tokenID string) error
 }
 
 type Config struct {
@@ -98,6 +133,10 @@ type Config struct {
 // ErrNotFound is returned when a resource is not found (HTTP status 404).
 var ErrNotFound = errors.New("controller: resource not found")
 
+// ErrUnauthorized is returned by AuthenticateUser when the controller rejects
+// the key (HTTP status 401).
+var ErrUnauthorized = errors.New("controller: invalid key")
+
 // newClient creates a generic Client object, additional attributes must
 // be set by the caller
 func newClient(key string, url string, http *http.Client) *v1controller.Client {
@@ -130,6 +169,24 @@ func NewClientWithHTTP(uri, key string, httpClient *http.Client) (Client, error)
 	return newClient(key, u.String(), httpClient), nil
 }
 
+// AuthenticateUser returns the user the given key authenticates with the
+// controller at uri as, which is an admin with no ID for cluster keys. It is
+// used by services which users authenticate with using the same keys as the
+// controller, such as gitreceive and docker-receive.
+func AuthenticateUser(uri, key string, httpClient *http.Client) (*ct.User, error) {
+	if uri == "" {
+		uri = "http://controller.discoverd"
+	}
+	user := &ct.User{}
+	res, err := newClient(key, uri, httpClient).RawReq("GET", "/user", nil, nil, user)
+	if res != nil && res.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 // NewClientWithConfig acts like NewClient, but supports custom configuration.
 func NewClientWithConfig(uri, key string, config Config) (Client, error) {
 	if config.Pin == nil {
@@ -168,6 +168,13 @@ func (c *Client) UpdateAppMeta(app *ct.App) error {
 	return c.Post(fmt.Sprintf("/apps/%s/meta", app.ID), app, app)
 }
 
+// UpdateAppAutoscale replaces the autoscale policies of an app, allowing all
+// policies to be removed by passing nil.
+func (c *Client) UpdateAppAutoscale(appID string, policies ct.AutoscalePolicies) error {
+	data := map[string]interface{}{"autoscale": policies}
+	return c.Post(fmt.Sprintf("/apps/%s", appID), data, nil)
+}
+
 // DeleteApp deletes an app.
 func (c *Client) DeleteApp(appID string) (*ct.AppDeletion, error) {
 	events := make(chan *ct.Event)
@@ -388,7 +395,10 @@ func (c *Client) GetApp(appID string) (*ct.App, error) {
 // GetAppLog returns a ReadCloser log stream of the app with ID appID. If lines
 // is zero or above, the number of lines returned will be capped at that value.
 // Otherwise, all available logs are returned. If follow is true, new log lines
-// are streamed after the buffered log.
+// are streamed after the buffered log. If Since or Until are set, only lines
+// in that time range are returned, including retained lines which are no
+// longer buffered. If Query is set, only lines matching the query expression
+// are returned.
 func (c *Client) GetAppLog(appID string, options *ct.LogOpts) (io.ReadCloser, error) {
 	path := fmt.Sprintf("/apps/%s/log", appID)
 	if options != nil {
@@ -406,6 +416,15 @@ func (c *Client) GetAppLog(appID string, options *ct.LogOpts) (io.ReadCloser, er
 		if opts.ProcessType != nil {
 			query.Set("process_type", *opts.ProcessType)
 		}
+		if !opts.Since.IsZero() {
+			query.Set("since", opts.Since.Format(time.RFC3339Nano))
+		}
+		if !opts.Until.IsZero() {
+			query.Set("until", opts.Until.Format(time.RFC3339Nano))
+		}
+		if opts.Query != "" {
+			query.Set("query", opts.Query)
+		}
 		if encodedQuery := query.Encode(); encodedQuery != "" {
 			path = fmt.Sprintf("%s?%s", path, encodedQuery)
 		}
@@ -436,6 +455,15 @@ func (c *Client) StreamAppLog(appID string, options *ct.LogOpts, output chan<- *
 		if opts.ProcessType != nil {
 			query.Set("process_type", *opts.ProcessType)
 		}
+		if !opts.Since.IsZero() {
+			query.Set("since", opts.Since.Format(time.RFC3339Nano))
+		}
+		if !opts.Until.IsZero() {
+			query.Set("until", opts.Until.Format(time.RFC3339Nano))
+		}
+		if opts.Query != "" {
+			query.Set("query", opts.Query)
+		}
 		if encodedQuery := query.Encode(); encodedQuery != "" {
 			path = fmt.Sprintf("%s?%s", path, encodedQuery)
 		}
@@ -460,6 +488,13 @@ func (c *Client) DeploymentList(appID string) ([]*ct.Deployment, error) {
 	return deployments, c.Get(fmt.Sprintf("/apps/%s/deployments", appID), &deployments)
 }
 
+// RollbackDeployment switches the app's routes back to the old release of its
+// latest blue-green deployment whilst the old formation is still running.
+func (c *Client) RollbackDeployment(appID string) (*ct.Deployment, error) {
+	deployment := &ct.Deployment{}
+	return deployment, c.Post(fmt.Sprintf("/apps/%s/rollback", appID), nil, deployment)
+}
+
 func convertEvents(appEvents chan *ct.Event, outputCh interface{}) {
 	outValue := reflect.ValueOf(outputCh)
 	msgType := outValue.Type().Elem().Elem()
@@ -775,6 +810,217 @@ func (c *Client) ScheduleAppGarbageCollection(appID string) error {
 	return c.Post(fmt.Sprintf("/apps/%s/gc", appID), nil, nil)
 }
 
+// CreateSchedule creates a schedule which runs a job for the given app each
+// time its cron expression is due.
+func (c *Client) CreateSchedule(appID string, schedule *ct.Schedule) error {
+	return c.Post(fmt.Sprintf("/apps/%s/schedules", appID), schedule, schedule)
+}
+
+// UpdateSchedule updates an existing schedule.
+func (c *Client) UpdateSchedule(schedule *ct.Schedule) error {
+	if schedule.ID == "" || schedule.AppID == "" {
+		return errors.New("controller: missing schedule id and/or app id")
+	}
+	return c.Put(fmt.Sprintf("/apps/%s/schedules/%s", schedule.AppID, schedule.ID), schedule, schedule)
+}
+
+// GetSchedule returns details for the specified schedule under app.
+func (c *Client) GetSchedule(appID, scheduleID string) (*ct.Schedule, error) {
+	schedule := &ct.Schedule{}
+	return schedule, c.Get(fmt.Sprintf("/apps/%s/schedules/%s", appID, scheduleID), schedule)
+}
+
+// ScheduleList returns a list of all schedules under appID.
+func (c *Client) ScheduleList(appID string) ([]*ct.Schedule, error) {
+	var schedules []*ct.Schedule
+	return schedules, c.Get(fmt.Sprintf("/apps/%s/schedules", appID), &schedules)
+}
+
+// DeleteSchedule deletes the specified schedule under app.
+func (c *Client) DeleteSchedule(appID, scheduleID string) error {
+	return c.Delete(fmt.Sprintf("/apps/%s/schedules/%s", appID, scheduleID), nil)
+}
+
+// CurrentUser returns the user the client is authenticated as, which is an
+// admin with no ID when using a cluster key.
+func (c *Client) CurrentUser() (*ct.User, error) {
+	user := &ct.User{}
+	return user, c.Get("/user", user)
+}
+
+// CreateUser creates a new user.
+func (c *Client) CreateUser(user *ct.User) error {
+	return c.Post("/users", user, user)
+}
+
+// UpdateUser updates the role and apps of an existing user.
+func (c *Client) UpdateUser(user *ct.User) error {
+	if user.ID == "" {
+		return errors.New("controller: missing id")
+	}
+	return c.Put(fmt.Sprintf("/users/%s", user.ID), user, user)
+}
+
+// GetUser returns the user with the given ID or name.
+func (c *Client) GetUser(userID string) (*ct.User, error) {
+	user := &ct.User{}
+	return user, c.Get(fmt.Sprintf("/users/%s", userID), user)
+}
+
+// UserList returns a list of all users.
+func (c *Client) UserList() ([]*ct.User, error) {
+	var users []*ct.User
+	return users, c.Get("/users", &users)
+}
+
+// DeleteUser deletes a user along with all of its tokens.
+func (c *Client) DeleteUser(userID string) error {
+	return c.Delete(fmt.Sprintf("/users/%s", userID), nil)
+}
+
+// CreateUserToken creates a new token for a user, setting token.Token to
+// the secret which can be used as an API key.
+func (c *Client) CreateUserToken(userID string, token *ct.UserToken) error {
+	return c.Post(fmt.Sprintf("/users/%s/tokens", userID), token, token)
+}
+
+// UserTokenList returns a list of a user's tokens (without their secrets).
+func (c *Client) UserTokenList(userID string) ([]*ct.UserToken, error) {
+	var tokens []*ct.UserToken
+	return tokens, c.Get(fmt.Sprintf("/users/%s/tokens", userID), &tokens)
+}
+
+// DeleteUserToken deletes a user's token, after which it can no longer be
+// used to authenticate.
+func (c *Client) DeleteUserToken(userID, tokenID string) error {
+	return c.Delete(fmt.Sprintf("/users/%s/tokens/%s", userID, tokenID), nil)
+}
+
+// CreateLogDrain creates a log drain which forwards the app's logs to the
+// drain's syslog or HTTP URL.
+func (c *Client) CreateLogDrain(appID string, drain *ct.LogDrain) error {
+	return c.Post(fmt.Sprintf("/apps/%s/log-drains", appID), drain, drain)
+}
+
+// GetLogDrain returns details for the specified log drain under app.
+func (c *Client) GetLogDrain(appID, drainID string) (*ct.LogDrain, error) {
+	drain := &ct.LogDrain{}
+	return drain, c.Get(fmt.Sprintf("/apps/%s/log-drains/%s", appID, drainID), drain)
+}
+
+// LogDrainList returns a list of all log drains under appID.
+func (c *Client) LogDrainList(appID string) ([]*ct.LogDrain, error) {
+	var drains []*ct.LogDrain
+	return drains, c.Get(fmt.Sprintf("/apps/%s/log-drains", appID), &drains)
+}
+
+// AllLogDrainList returns a list of the log drains of all apps.
+func (c *Client) AllLogDrainList() ([]*ct.LogDrain, error) {
+	var drains []*ct.LogDrain
+	return drains, c.Get("/log-drains", &drains)
+}
+
+// DeleteLogDrain deletes the specified log drain under app.
+func (c *Client) DeleteLogDrain(appID, drainID string) error {
+	return c.Delete(fmt.Sprintf("/apps/%s/log-drains/%s", appID, drainID), nil)
+}
+
+// SetWebhookSecret sets the secret which the app's push webhook payloads are
+// signed with, enabling webhooks for the app.
+func (c *Client) SetWebhookSecret(appID, secret string) error {
+	return c.Put(fmt.Sprintf("/apps/%s/webhook-secret", appID), &ct.WebhookSecret{Secret: secret}, nil)
+}
+
+// DeleteWebhookSecret disables the app's push webhooks.
+func (c *Client) DeleteWebhookSecret(appID string) error {
+	return c.Delete(fmt.Sprintf("/apps/%s/webhook-secret", appID), nil)
+}
+
+// VerifyWebhookSignature sets sig.Valid to whether sig.Payload was signed
+// with the app's webhook secret, returning ErrNotFound if the app doesn't
+// have one.
+func (c *Client) VerifyWebhookSignature(appID string, sig *ct.WebhookSignature) error {
+	return c.Post(fmt.Sprintf("/apps/%s/webhook-signatures", appID), sig, sig)
+}
+
+// CreateBuild records a build of the app's source code, which is pending
+// unless another state is given.
+func (c *Client) CreateBuild(appID string, build *ct.Build) error {
+	return c.Post(fmt.Sprintf("/apps/%s/builds", appID), build, build)
+}
+
+// UpdateBuild changes the state of a build.
+func (c *Client) UpdateBuild(appID string, build *ct.Build) error {
+	return c.Put(fmt.Sprintf("/apps/%s/builds/%s", appID, build.ID), build, build)
+}
+
+// GetBuild returns details for the specified build under app.
+func (c *Client) GetBuild(appID, buildID string) (*ct.Build, error) {
+	build := &ct.Build{}
+	return build, c.Get(fmt.Sprintf("/apps/%s/builds/%s", appID, buildID), build)
+}
+
+// BuildList returns the app's most recent builds, newest first, returning the
+// controller's default number of builds if count is zero.
+func (c *Client) BuildList(appID string, count int) ([]*ct.Build, error) {
+	path := fmt.Sprintf("/apps/%s/builds", appID)
+	if count > 0 {
+		path += fmt.Sprintf("?count=%d", count)
+	}
+	var builds []*ct.Build
+	return builds, c.Get(path, &builds)
+}
+
+// CreatePipeline creates a pipeline of apps which releases are promoted
+// through, converting app names to IDs.
+func (c *Client) CreatePipeline(pipeline *ct.Pipeline) error {
+	return c.Post("/pipelines", pipeline, pipeline)
+}
+
+// UpdatePipeline updates the apps of an existing pipeline.
+func (c *Client) UpdatePipeline(pipeline *ct.Pipeline) error {
+	if pipeline.ID == "" {
+		return errors.New("controller: missing id")
+	}
+	return c.Put(fmt.Sprintf("/pipelines/%s", pipeline.ID), pipeline, pipeline)
+}
+
+// GetPipeline returns the pipeline with the given ID or name.
+func (c *Client) GetPipeline(pipelineID string) (*ct.Pipeline, error) {
+	pipeline := &ct.Pipeline{}
+	return pipeline, c.Get(fmt.Sprintf("/pipelines/%s", pipelineID), pipeline)
+}
+
+// PipelineList returns a list of all pipelines.
+func (c *Client) PipelineList() ([]*ct.Pipeline, error) {
+	var pipelines []*ct.Pipeline
+	return pipelines, c.Get("/pipelines", &pipelines)
+}
+
+// DeletePipeline deletes a pipeline.
+func (c *Client) DeletePipeline(pipelineID string) error {
+	return c.Delete(fmt.Sprintf("/pipelines/%s", pipelineID), nil)
+}
+
+// Promote promotes a release of one of the pipeline's apps to the next app
+// in the pipeline, creating a release from its artifacts which keeps the next
+// app's env and deploying it. promotion.FromAppID defaults to the first app
+// in the pipeline and promotion.FromReleaseID to the app's current release.
+func (c *Client) Promote(pipelineID string, promotion *ct.Promotion) error {
+	return c.Post(fmt.Sprintf("/pipelines/%s/promotions", pipelineID), promotion, promotion)
+}
+
+// PromotionList returns the pipeline's most recent promotions, newest first,
+// returning the controller's default number of promotions if count is zero.
+func (c *Client) PromotionList(pipelineID string, count int) ([]*ct.Promotion, error) {
+	path := fmt.Sprintf("/pipelines/%s/promotions", pipelineID)
+	if count > 0 {
+		path += fmt.Sprintf("?count=%d", count)
+	}
+	var promotions []*ct.Promotion
+	return promotions, c.Get(path, &promotions)
+}
+
 func (c *Client) Put(path string, in, out interface{}) error {
 	return c.send("PUT", path, in, out)
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"crypto/md5"
-	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -172,6 +171,7 @@ func streamRouterEvents(rc routerc.Client, db *postgres.DB, doneCh chan struct{}
 
 type logClient interface {
 	GetLog(channelID string, options *logaggc.LogOpts) (io.ReadCloser, error)
+	GetDrainStats() ([]*logaggc.DrainStats, error)
 }
 
 type handlerConfig struct {
@@ -208,7 +208,7 @@ func appHandler(c handlerConfig) http.Handler {
 	domainMigrationRepo := NewDomainMigrationRepo(c.db)
 	providerRepo := NewProviderRepo(c.db)
 	resourceRepo := NewResourceRepo(c.db)
-	appRepo := NewAppRepo(c.db, os.Getenv("DEFAULT_ROUTE_DOMAIN"), c.rc)
+	appRepo := NewAppRepo(c.db, q, os.Getenv("DEFAULT_ROUTE_DOMAIN"), c.rc)
 	artifactRepo := NewArtifactRepo(c.db)
 	releaseRepo := NewReleaseRepo(c.db, artifactRepo, q)
 	jobRepo := NewJobRepo(c.db)
@@ -217,6 +217,11 @@ func appHandler(c handlerConfig) http.Handler {
 	deploymentRepo := NewDeploymentRepo(c.db)
 	eventRepo := NewEventRepo(c.db)
 	backupRepo := NewBackupRepo(c.db)
+	scheduleRepo := NewScheduleRepo(c.db, q, releaseRepo)
+	logDrainRepo := NewLogDrainRepo(c.db)
+	buildRepo := NewBuildRepo(c.db)
+	pipelineRepo := NewPipelineRepo(c.db)
+	userRepo := NewUserRepo(c.db)
 
 	api := controllerAPI{
 		domainMigrationRepo: domainMigrationRepo,
@@ -230,6 +235,12 @@ func appHandler(c handlerConfig) http.Handler {
 		deploymentRepo:      deploymentRepo,
 		eventRepo:           eventRepo,
 		backupRepo:          backupRepo,
+		scheduleRepo:        scheduleRepo,
+		logDrainRepo:        logDrainRepo,
+		buildRepo:           buildRepo,
+		pipelineRepo:        pipelineRepo,
+		userRepo:            userRepo,
+		auth:                &authorizer{keys: c.keys, users: userRepo, db: c.db},
 		clusterClient:       c.cc,
 		logaggc:             c.lc,
 		routerc:             c.rc,
@@ -279,10 +290,36 @@ func appHandler(c handlerConfig) http.Handler {
 	httpRouter.DELETE("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.appLookup(api.KillJob)))
 	httpRouter.GET("/active-jobs", httphelper.WrapHandler(api.ListActiveJobs))
 
+	httpRouter.POST("/apps/:apps_id/schedules", httphelper.WrapHandler(api.appLookup(api.CreateSchedule)))
+	httpRouter.GET("/apps/:apps_id/schedules", httphelper.WrapHandler(api.appLookup(api.ListSchedules)))
+	httpRouter.GET("/apps/:apps_id/schedules/:schedules_id", httphelper.WrapHandler(api.appLookup(api.GetSchedule)))
+	httpRouter.PUT("/apps/:apps_id/schedules/:schedules_id", httphelper.WrapHandler(api.appLookup(api.UpdateSchedule)))
+	httpRouter.DELETE("/apps/:apps_id/schedules/:schedules_id", httphelper.WrapHandler(api.appLookup(api.DeleteSchedule)))
+
+	httpRouter.POST("/apps/:apps_id/log-drains", httphelper.WrapHandler(api.appLookup(api.CreateLogDrain)))
+	httpRouter.GET("/apps/:apps_id/log-drains", httphelper.WrapHandler(api.appLookup(api.ListLogDrains)))
+	httpRouter.GET("/apps/:apps_id/log-drains/:log_drains_id", httphelper.WrapHandler(api.appLookup(api.GetLogDrain)))
+	httpRouter.DELETE("/apps/:apps_id/log-drains/:log_drains_id", httphelper.WrapHandler(api.appLookup(api.DeleteLogDrain)))
+	httpRouter.GET("/log-drains", httphelper.WrapHandler(api.ListAllLogDrains))
+
+	httpRouter.POST("/apps/:apps_id/builds", httphelper.WrapHandler(api.appLookup(api.CreateBuild)))
+	httpRouter.GET("/apps/:apps_id/builds", httphelper.WrapHandler(api.appLookup(api.ListBuilds)))
+	httpRouter.GET("/apps/:apps_id/builds/:builds_id", httphelper.WrapHandler(api.appLookup(api.GetBuild)))
+	httpRouter.PUT("/apps/:apps_id/builds/:builds_id", httphelper.WrapHandler(api.appLookup(api.UpdateBuild)))
+
 	httpRouter.POST("/apps/:apps_id/deploy", httphelper.WrapHandler(api.appLookup(api.CreateDeployment)))
 	httpRouter.GET("/apps/:apps_id/deployments", httphelper.WrapHandler(api.appLookup(api.ListDeployments)))
+	httpRouter.POST("/apps/:apps_id/rollback", httphelper.WrapHandler(api.appLookup(api.RollbackDeployment)))
 	httpRouter.GET("/deployments/:deployment_id", httphelper.WrapHandler(api.GetDeployment))
 
+	httpRouter.POST("/pipelines", httphelper.WrapHandler(api.CreatePipeline))
+	httpRouter.GET("/pipelines", httphelper.WrapHandler(api.ListPipelines))
+	httpRouter.GET("/pipelines/:pipelines_id", httphelper.WrapHandler(api.GetPipeline))
+	httpRouter.PUT("/pipelines/:pipelines_id", httphelper.WrapHandler(api.UpdatePipeline))
+	httpRouter.DELETE("/pipelines/:pipelines_id", httphelper.WrapHandler(api.DeletePipeline))
+	httpRouter.POST("/pipelines/:pipelines_id/promotions", httphelper.WrapHandler(api.CreatePromotion))
+	httpRouter.GET("/pipelines/:pipelines_id/promotions", httphelper.WrapHandler(api.ListPromotions))
+
 	httpRouter.PUT("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.SetAppRelease)))
 	httpRouter.GET("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.GetAppRelease)))
 	httpRouter.GET("/apps/:apps_id/releases", httphelper.WrapHandler(api.appLookup(api.GetAppReleases)))
@@ -304,15 +341,28 @@ func appHandler(c handlerConfig) http.Handler {
 	httpRouter.DELETE("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.DeleteRoute)))
 
 	httpRouter.POST("/apps/:apps_id/meta", httphelper.WrapHandler(api.appLookup(api.UpdateApp)))
+	httpRouter.PUT("/apps/:apps_id/webhook-secret", httphelper.WrapHandler(api.appLookup(api.SetWebhookSecret)))
+	httpRouter.DELETE("/apps/:apps_id/webhook-secret", httphelper.WrapHandler(api.appLookup(api.DeleteWebhookSecret)))
+	httpRouter.POST("/apps/:apps_id/webhook-signatures", httphelper.WrapHandler(api.appLookup(api.VerifyWebhookSignature)))
+
+	httpRouter.GET("/user", httphelper.WrapHandler(api.GetCurrentUser))
+	httpRouter.POST("/users", httphelper.WrapHandler(api.CreateUser))
+	httpRouter.GET("/users", httphelper.WrapHandler(api.ListUsers))
+	httpRouter.GET("/users/:users_id", httphelper.WrapHandler(api.GetUser))
+	httpRouter.PUT("/users/:users_id", httphelper.WrapHandler(api.UpdateUser))
+	httpRouter.DELETE("/users/:users_id", httphelper.WrapHandler(api.DeleteUser))
+	httpRouter.POST("/users/:users_id/tokens", httphelper.WrapHandler(api.CreateUserToken))
+	httpRouter.GET("/users/:users_id/tokens", httphelper.WrapHandler(api.ListUserTokens))
+	httpRouter.DELETE("/users/:users_id/tokens/:tokens_id", httphelper.WrapHandler(api.DeleteUserToken))
 
 	httpRouter.GET("/events", httphelper.WrapHandler(api.Events))
 	httpRouter.GET("/events/:id", httphelper.WrapHandler(api.GetEvent))
 
 	return httphelper.ContextInjector("controller",
-		httphelper.NewRequestLogger(muxHandler(httpRouter, c.keys)))
+		httphelper.NewRequestLogger(muxHandler(httpRouter, api.auth)))
 }
 
-func muxHandler(main http.Handler, authKeys []string) http.Handler {
+func muxHandler(main http.Handler, auth *authorizer) http.Handler {
 	return httphelper.CORSAllowAll.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if shutdown.IsActive() {
 			httphelper.ServiceUnavailableError(w, ErrShutdown.Error())
@@ -328,21 +378,7 @@ func muxHandler(main http.Handler, authKeys []string) http.Handler {
 			main.ServeHTTP(w, r)
 			return
 		}
-		if password == "" && (strings.Contains(r.Header.Get("Accept"), "text/event-stream") || r.URL.Path == "/backup") {
-			password = r.URL.Query().Get("key")
-		}
-		var authed bool
-		for _, k := range authKeys {
-			if len(password) == len(k) && subtle.ConstantTimeCompare([]byte(password), []byte(k)) == 1 {
-				authed = true
-				break
-			}
-		}
-		if !authed {
-			w.WriteHeader(401)
-			return
-		}
-		main.ServeHTTP(w, r)
+		auth.ServeHTTP(main, w, r)
 	}))
 }
 
@@ -358,6 +394,12 @@ type controllerAPI struct {
 	deploymentRepo      *DeploymentRepo
 	eventRepo           *EventRepo
 	backupRepo          *BackupRepo
+	scheduleRepo        *ScheduleRepo
+	logDrainRepo        *LogDrainRepo
+	buildRepo           *BuildRepo
+	pipelineRepo        *PipelineRepo
+	userRepo            *UserRepo
+	auth                *authorizer
 	clusterClient       utils.ClusterClient
 	logaggc             logClient
 	routerc             routerc.Client
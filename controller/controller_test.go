@@ -1,6 +1,10 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -8,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/flynn/flynn/controller/client"
 	"github.com/flynn/flynn/controller/schema"
@@ -205,6 +210,155 @@ func (s *S) TestUpdateApp(c *C) {
 	c.Assert(app.Meta, DeepEquals, meta)
 	c.Assert(app.Strategy, Equals, strategy)
 	c.Assert(app.DeployTimeout, Equals, timeout)
+
+	opts := &ct.DeployOptions{CanaryCount: 2, CanaryPeriod: 300}
+	app = &ct.App{
+		ID:            app.ID,
+		Strategy:      "canary",
+		DeployOptions: opts,
+	}
+	c.Assert(s.c.UpdateApp(app), IsNil)
+	c.Assert(app.DeployTimeout, Equals, timeout)
+	c.Assert(app.DeployOptions, DeepEquals, opts)
+
+	app, err = s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(app.Strategy, Equals, "canary")
+	c.Assert(app.DeployOptions, DeepEquals, opts)
+}
+
+func (s *S) TestUpdateAppAutoscale(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "update-app-autoscale"})
+	c.Assert(app.Autoscale, IsNil)
+
+	policies := ct.AutoscalePolicies{
+		"web":    {Min: 1, Max: 5, TargetCPU: 60, TargetRequestRate: 50},
+		"worker": {Min: 0, Max: 2},
+	}
+	c.Assert(s.c.UpdateAppAutoscale(app.ID, policies), IsNil)
+	app, err := s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(app.Autoscale, DeepEquals, policies)
+
+	// updating other fields leaves the policies untouched
+	c.Assert(s.c.UpdateApp(&ct.App{ID: app.ID, Strategy: "one-by-one"}), IsNil)
+	app, err = s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(app.Autoscale, DeepEquals, policies)
+
+	// max must not be less than min
+	err = s.c.UpdateAppAutoscale(app.ID, ct.AutoscalePolicies{"web": {Min: 3, Max: 2}})
+	c.Assert(err, NotNil)
+	e, ok := err.(hh.JSONError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, hh.ValidationErrorCode)
+
+	// removing the policies
+	c.Assert(s.c.UpdateAppAutoscale(app.ID, nil), IsNil)
+	app, err = s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(app.Autoscale, IsNil)
+}
+
+func (s *S) TestReviewAppExpiry(c *C) {
+	parent := s.createTestApp(c, &ct.App{Name: "review-parent"})
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	app := s.createTestApp(c, &ct.App{
+		Name: "review-parent-feature",
+		Meta: map[string]string{
+			ct.ReviewParentMetaKey:    parent.ID,
+			ct.ReviewBranchMetaKey:    "feature",
+			ct.ReviewExpiresAtMetaKey: expiresAt.Format(time.RFC3339),
+		},
+	})
+	c.Assert(app.ReviewParent(), Equals, parent.ID)
+	c.Assert(app.ReviewExpiresAt().Equal(expiresAt), Equals, true)
+
+	expiryJobs := func() []time.Time {
+		rows, err := s.hc.db.Query(`SELECT args->>'expires_at' FROM que_jobs WHERE job_class = 'review_app_expiry' AND args->>'app_id' = $1 ORDER BY run_at`, app.ID)
+		c.Assert(err, IsNil)
+		defer rows.Close()
+		var times []time.Time
+		for rows.Next() {
+			var s string
+			c.Assert(rows.Scan(&s), IsNil)
+			t, err := time.Parse(time.RFC3339, s)
+			c.Assert(err, IsNil)
+			times = append(times, t)
+		}
+		c.Assert(rows.Err(), IsNil)
+		return times
+	}
+
+	// creating the app enqueues a job to delete it when it expires
+	jobs := expiryJobs()
+	c.Assert(jobs, HasLen, 1)
+	c.Assert(jobs[0].Equal(expiresAt), Equals, true)
+
+	// extending the expiry enqueues another job
+	extended := expiresAt.Add(time.Hour)
+	app.Meta[ct.ReviewExpiresAtMetaKey] = extended.Format(time.RFC3339)
+	c.Assert(s.c.UpdateAppMeta(app), IsNil)
+	jobs = expiryJobs()
+	c.Assert(jobs, HasLen, 2)
+	c.Assert(jobs[1].Equal(extended), Equals, true)
+
+	// updating other meta doesn't
+	app.Meta["foo"] = "bar"
+	c.Assert(s.c.UpdateAppMeta(app), IsNil)
+	c.Assert(expiryJobs(), HasLen, 2)
+
+	// the expiry must be a valid time
+	app.Meta[ct.ReviewExpiresAtMetaKey] = "tomorrow"
+	err := s.c.UpdateAppMeta(app)
+	c.Assert(err, NotNil)
+	e, ok := err.(hh.JSONError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, hh.ValidationErrorCode)
+}
+
+func (s *S) TestWebhookSecret(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "webhook-secret"})
+	secret := random.Hex(16)
+	payload := []byte(`{"ref":"refs/heads/master"}`)
+	sign := func(key string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(payload)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	// signatures can't be verified without a secret
+	err := s.c.VerifyWebhookSignature(app.ID, &ct.WebhookSignature{Payload: payload, Signature: sign("")})
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	c.Assert(s.c.SetWebhookSecret(app.ID, secret), IsNil)
+	sig := &ct.WebhookSignature{Payload: payload, Signature: sign(secret)}
+	c.Assert(s.c.VerifyWebhookSignature(app.ID, sig), IsNil)
+	c.Assert(sig.Valid, Equals, true)
+	sig = &ct.WebhookSignature{Payload: payload, Signature: sign("other")}
+	c.Assert(s.c.VerifyWebhookSignature(app.ID, sig), IsNil)
+	c.Assert(sig.Valid, Equals, false)
+
+	// the secret isn't exposed by the app or its events
+	gotApp, err := s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	data, err := json.Marshal(gotApp)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(data), secret), Equals, false)
+	events, err := s.c.ListEvents(ct.ListEventsOptions{AppID: app.ID})
+	c.Assert(err, IsNil)
+	for _, e := range events {
+		c.Assert(strings.Contains(string(e.Data), secret), Equals, false)
+	}
+
+	// nor can it be set in meta
+	app.Meta = map[string]string{"webhook.secret": secret}
+	err = s.c.UpdateAppMeta(app)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	c.Assert(s.c.DeleteWebhookSecret(app.ID), IsNil)
+	err = s.c.VerifyWebhookSignature(app.ID, &ct.WebhookSignature{Payload: payload, Signature: sign(secret)})
+	c.Assert(err, Equals, controller.ErrNotFound)
 }
 
 func (s *S) TestUpdateAppMeta(c *C) {
@@ -290,6 +444,32 @@ func (s *S) TestCreateRelease(c *C) {
 	}
 }
 
+func (s *S) TestCreateReleasePlacement(c *C) {
+	placement := []ct.PlacementConstraint{
+		{Type: ct.PlacementAntiAffinity},
+		{Type: ct.PlacementSpread, HostTag: "zone"},
+		{Type: ct.PlacementAffinity, App: "db-app", ProcessType: "db"},
+	}
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {Placement: placement}},
+	})
+	gotRelease, err := s.c.GetRelease(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.Processes["web"].Placement, DeepEquals, placement)
+
+	for _, invalid := range []ct.PlacementConstraint{
+		{Type: "unknown"},
+		{Type: ct.PlacementAffinity},
+		{Type: ct.PlacementAffinity, ProcessType: "web"},
+	} {
+		err := s.c.CreateRelease(&ct.Release{
+			ArtifactIDs: release.ArtifactIDs,
+			Processes:   map[string]ct.ProcessType{"web": {Placement: []ct.PlacementConstraint{invalid}}},
+		})
+		c.Assert(hh.IsValidationError(err), Equals, true, Commentf("constraint %+v", invalid))
+	}
+}
+
 func (s *S) TestCreateFormation(c *C) {
 	for i, useName := range []bool{false, true} {
 		release := s.createTestRelease(c, &ct.Release{
@@ -21,6 +21,13 @@ type Remover interface {
 	Remove(string) error
 }
 
+// AppLister is implemented by repositories which can list only the things
+// belonging to the given apps, which is used to limit the lists users with
+// access to only some apps can read
+type AppLister interface {
+	ListForApps(appIDs []string) (interface{}, error)
+}
+
 func crud(r *httprouter.Router, resource string, example interface{}, repo Repository) {
 	resourceType := reflect.TypeOf(example)
 	prefix := "/" + resource
@@ -60,7 +67,16 @@ func crud(r *httprouter.Router, resource string, example interface{}, repo Repos
 	}))
 
 	r.GET(prefix, httphelper.WrapHandler(func(ctx context.Context, rw http.ResponseWriter, _ *http.Request) {
-		list, err := repo.List()
+		var list interface{}
+		var err error
+		if appIDs, ok := userApps(ctx); !ok {
+			list, err = repo.List()
+		} else if lister, ok := repo.(AppLister); ok {
+			list, err = lister.ListForApps(appIDs)
+		} else {
+			httphelper.ForbiddenError(rw, "user does not have permission to make this request")
+			return
+		}
 		if err != nil {
 			respondWithError(rw, err)
 			return
@@ -40,7 +40,7 @@ func (r *DeploymentRepo) Add(data interface{}) (*ct.Deployment, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := tx.QueryRow("deployment_insert", d.ID, d.AppID, oldReleaseID, d.NewReleaseID, d.Strategy, d.Processes, d.DeployTimeout).Scan(&d.CreatedAt); err != nil {
+	if err := tx.QueryRow("deployment_insert", d.ID, d.AppID, oldReleaseID, d.NewReleaseID, d.Strategy, d.Processes, d.DeployTimeout, d.DeployOptions).Scan(&d.CreatedAt); err != nil {
 		tx.Rollback()
 		return nil, err
 	}
@@ -114,7 +114,7 @@ func scanDeployment(s postgres.Scanner) (*ct.Deployment, error) {
 	d := &ct.Deployment{}
 	var oldReleaseID *string
 	var status *string
-	err := s.Scan(&d.ID, &d.AppID, &oldReleaseID, &d.NewReleaseID, &d.Strategy, &status, &d.Processes, &d.DeployTimeout, &d.CreatedAt, &d.FinishedAt)
+	err := s.Scan(&d.ID, &d.AppID, &oldReleaseID, &d.NewReleaseID, &d.Strategy, &status, &d.Processes, &d.DeployTimeout, &d.DeployOptions, &d.CreatedAt, &d.FinishedAt)
 	if err == pgx.ErrNoRows {
 		err = ErrNotFound
 	}
@@ -155,22 +155,31 @@ func (c *controllerAPI) CreateDeployment(ctx context.Context, w http.ResponseWri
 		return
 	}
 	release := rel.(*ct.Release)
-	app := c.getApp(ctx)
 
+	d, err := c.deploy(c.getApp(ctx), release)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, d)
+}
+
+// deploy creates a deployment of the release using the app's strategy,
+// scaling the release to the formation of the app's current release. Apps
+// with nothing running are switched to the release straight away.
+func (c *controllerAPI) deploy(app *ct.App, release *ct.Release) (*ct.Deployment, error) {
 	// TODO: wrap all of this in a transaction
 	oldRelease, err := c.appRepo.GetRelease(app.ID)
 	if err == ErrNotFound {
 		oldRelease = &ct.Release{}
 	} else if err != nil {
-		respondWithError(w, err)
-		return
+		return nil, err
 	}
 	oldFormation, err := c.formationRepo.Get(app.ID, oldRelease.ID)
 	if err == ErrNotFound {
 		oldFormation = &ct.Formation{}
 	} else if err != nil {
-		respondWithError(w, err)
-		return
+		return nil, err
 	}
 	procCount := 0
 	for _, i := range oldFormation.Processes {
@@ -184,43 +193,106 @@ func (c *controllerAPI) CreateDeployment(ctx context.Context, w http.ResponseWri
 		OldReleaseID:  oldRelease.ID,
 		Processes:     oldFormation.Processes,
 		DeployTimeout: app.DeployTimeout,
+		DeployOptions: app.DeployOptions,
 	}
 
 	if err := schema.Validate(deployment); err != nil {
-		respondWithError(w, err)
-		return
+		return nil, err
 	}
 	if procCount == 0 {
 		// immediately set app release
 		if err := c.appRepo.SetRelease(app, release.ID); err != nil {
-			respondWithError(w, err)
-			return
+			return nil, err
 		}
 		now := time.Now()
 		deployment.FinishedAt = &now
 	}
 
 	d, err := c.deploymentRepo.Add(deployment)
+	if postgres.IsUniquenessError(err, "isolate_deploys") {
+		return nil, ct.ValidationError{Message: "Cannot create deploy, there is already one in progress for this app."}
+	}
+	return d, err
+}
+
+func (c *controllerAPI) ListDeployments(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	list, err := c.deploymentRepo.List(app.ID)
 	if err != nil {
-		if postgres.IsUniquenessError(err, "isolate_deploys") {
-			httphelper.ValidationError(w, "", "Cannot create deploy, there is already one in progress for this app.")
-			return
-		}
 		respondWithError(w, err)
 		return
 	}
-
-	httphelper.JSON(w, 200, d)
+	httphelper.JSON(w, 200, list)
 }
 
-func (c *controllerAPI) ListDeployments(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+// RollbackDeployment switches the app's routes back to the old release of
+// its latest deployment, which must be a completed blue-green deployment
+// whose old formation is still running, then scales the new formation down.
+func (c *controllerAPI) RollbackDeployment(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	app := c.getApp(ctx)
 	list, err := c.deploymentRepo.List(app.ID)
 	if err != nil {
 		respondWithError(w, err)
 		return
 	}
-	httphelper.JSON(w, 200, list)
+	if len(list) == 0 {
+		respondWithError(w, ct.ValidationError{Message: "app has no deployments to roll back"})
+		return
+	}
+	d := list[0]
+	if d.Strategy != "blue-green" || d.Status != "complete" || d.NewReleaseID != app.ReleaseID {
+		respondWithError(w, ct.ValidationError{Message: "only a completed blue-green deployment of the current release can be rolled back"})
+		return
+	}
+	formation, err := c.formationRepo.Get(app.ID, d.OldReleaseID)
+	if err != nil && err != ErrNotFound {
+		respondWithError(w, err)
+		return
+	}
+	var running bool
+	if formation != nil {
+		for _, n := range formation.Processes {
+			if n > 0 {
+				running = true
+				break
+			}
+		}
+	}
+	if !running {
+		respondWithError(w, ct.ValidationError{Message: "the old formation is no longer running, deploy the previous release instead"})
+		return
+	}
+
+	routes, err := c.routerc.ListRoutes(routeParentRef(app.ID))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	for _, route := range routes {
+		if route.Type != "http" || route.Release != d.NewReleaseID {
+			continue
+		}
+		route.Release = d.OldReleaseID
+		if err := c.routerc.UpdateRoute(route); err != nil {
+			respondWithError(w, err)
+			return
+		}
+	}
+
+	if err := c.appRepo.SetRelease(app, d.OldReleaseID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.formationRepo.Add(&ct.Formation{AppID: app.ID, ReleaseID: d.NewReleaseID}); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := createDeploymentEvent(c.deploymentRepo.db.Exec, d, "rolled-back"); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	d.Status = "rolled-back"
+	httphelper.JSON(w, 200, d)
 }
 
 func createDeploymentEvent(dbExec func(string, ...interface{}) error, d *ct.Deployment, status string) error {
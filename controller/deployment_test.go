@@ -6,6 +6,7 @@ import (
 
 	ct "github.com/flynn/flynn/controller/types"
 	hh "github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/router/types"
 	. "github.com/flynn/go-check"
 )
 
@@ -45,6 +46,100 @@ func (s *S) TestCreateDeployment(c *C) {
 	c.Assert(err.(hh.JSONError).Message, Equals, "Cannot create deploy, there is already one in progress for this app.")
 }
 
+func (s *S) TestCreateDeploymentOptions(c *C) {
+	opts := &ct.DeployOptions{CanaryCount: 2, CanaryPeriod: 30}
+	app := s.createTestApp(c, &ct.App{Name: "create-deployment-options", Strategy: "canary", DeployOptions: opts})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 1},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, release.ID)
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+
+	newRelease := s.createTestRelease(c, &ct.Release{})
+	d, err := s.c.CreateDeployment(app.ID, newRelease.ID)
+	c.Assert(err, IsNil)
+	c.Assert(d.Strategy, Equals, "canary")
+	c.Assert(d.DeployOptions, DeepEquals, opts)
+
+	d, err = s.c.GetDeployment(d.ID)
+	c.Assert(err, IsNil)
+	c.Assert(d.DeployOptions, DeepEquals, opts)
+}
+
+func (s *S) TestRollbackDeployment(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "rollback-deployment", Strategy: "blue-green"})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 1},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, release.ID)
+
+	// the initial deployment cannot be rolled back
+	_, err := s.c.CreateDeployment(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	_, err = s.c.RollbackDeployment(app.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	newRelease := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	d, err := s.c.CreateDeployment(app.ID, newRelease.ID)
+	c.Assert(err, IsNil)
+	c.Assert(d.Strategy, Equals, "blue-green")
+
+	// a pending deployment cannot be rolled back
+	_, err = s.c.RollbackDeployment(app.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// simulate the deployer bringing up the new formation, switching the
+	// route and completing the deployment
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: newRelease.ID,
+		Processes: map[string]int{"web": 1},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, newRelease.ID)
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{
+		Domain:  "rollback-deployment.example.com",
+		Service: "rollback-deployment-web",
+		Release: newRelease.ID,
+	}).ToRoute())
+	c.Assert(s.c.SetAppRelease(app.ID, newRelease.ID), IsNil)
+	c.Assert(s.hc.db.Exec("event_insert", app.ID, d.ID, string(ct.EventTypeDeployment), ct.DeploymentEvent{
+		AppID:        app.ID,
+		DeploymentID: d.ID,
+		ReleaseID:    newRelease.ID,
+		Status:       "complete",
+	}), IsNil)
+
+	d, err = s.c.RollbackDeployment(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(d.Status, Equals, "rolled-back")
+
+	gotRelease, err := s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, release.ID)
+	gotRoute, err := s.c.GetRoute(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Release, Equals, release.ID)
+	formation, err := s.c.GetFormation(app.ID, newRelease.ID)
+	c.Assert(err, IsNil)
+	c.Assert(formation.Processes["web"], Equals, 0)
+
+	// rolling back again should fail
+	_, err = s.c.RollbackDeployment(app.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
 func (s *S) TestStreamDeployment(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "stream-deployment"})
 	release := s.createTestRelease(c, &ct.Release{
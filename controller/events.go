@@ -26,7 +26,7 @@ func NewEventRepo(db *postgres.DB) *EventRepo {
 	return &EventRepo{db: db}
 }
 
-func (r *EventRepo) ListEvents(appID string, objectTypes []string, objectID string, beforeID *int64, sinceID *int64, count int) ([]*ct.Event, error) {
+func (r *EventRepo) ListEvents(appIDs []string, objectTypes []string, objectID string, beforeID *int64, sinceID *int64, count int) ([]*ct.Event, error) {
 	query := "SELECT event_id, app_id, object_id, object_type, data, created_at FROM events"
 	var conditions []string
 	var n int
@@ -41,10 +41,10 @@ func (r *EventRepo) ListEvents(appID string, objectTypes []string, objectID stri
 		conditions = append(conditions, fmt.Sprintf("event_id > $%d", n))
 		args = append(args, *sinceID)
 	}
-	if appID != "" {
+	if len(appIDs) > 0 {
 		n++
-		conditions = append(conditions, fmt.Sprintf("app_id = $%d", n))
-		args = append(args, appID)
+		conditions = append(conditions, fmt.Sprintf("app_id = ANY($%d)", n))
+		args = append(args, appIDs)
 	}
 	if len(objectTypes) > 0 {
 		c := "("
@@ -145,18 +145,31 @@ func (c *controllerAPI) GetEvent(ctx context.Context, w http.ResponseWriter, req
 func (c *controllerAPI) Events(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	l, _ := ctxhelper.LoggerFromContext(ctx)
 	log := l.New("fn", "Events")
-	var app *ct.App
+	var appIDs []string
 	if appID := req.FormValue("app_id"); appID != "" {
 		data, err := c.appRepo.Get(appID)
 		if err != nil {
 			respondWithError(w, err)
 			return
 		}
-		app = data.(*ct.App)
+		appIDs = []string{data.(*ct.App).ID}
+	}
+
+	// users who can only access some apps can only read their events
+	if userAppIDs, ok := userApps(ctx); ok {
+		if appIDs == nil {
+			appIDs = userAppIDs
+		} else if !hasApp(userAppIDs, appIDs[0]) {
+			appIDs = nil
+		}
+		if len(appIDs) == 0 {
+			httphelper.ForbiddenError(w, "user does not have permission to make this request")
+			return
+		}
 	}
 
 	if req.Header.Get("Accept") == "application/json" {
-		if err := listEvents(ctx, w, req, app, c.eventRepo); err != nil {
+		if err := listEvents(ctx, w, req, appIDs, c.eventRepo); err != nil {
 			log.Error("error listing events", "err", err)
 			respondWithError(w, err)
 		}
@@ -167,18 +180,13 @@ func (c *controllerAPI) Events(ctx context.Context, w http.ResponseWriter, req *
 		log.Error("error starting event listener", "err", err)
 		respondWithError(w, err)
 	}
-	if err := streamEvents(ctx, w, req, c.eventListener, app, c.eventRepo); err != nil {
+	if err := streamEvents(ctx, w, req, c.eventListener, appIDs, c.eventRepo); err != nil {
 		log.Error("error streaming events", "err", err)
 		respondWithError(w, err)
 	}
 }
 
-func listEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, app *ct.App, repo *EventRepo) (err error) {
-	var appID string
-	if app != nil {
-		appID = app.ID
-	}
-
+func listEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, appIDs []string, repo *EventRepo) (err error) {
 	var beforeID *int64
 	if req.FormValue("before_id") != "" {
 		id, err := strconv.ParseInt(req.FormValue("before_id"), 10, 64)
@@ -211,7 +219,7 @@ func listEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, a
 	}
 	objectID := req.FormValue("object_id")
 
-	list, err := repo.ListEvents(appID, objectTypes, objectID, beforeID, sinceID, count)
+	list, err := repo.ListEvents(appIDs, objectTypes, objectID, beforeID, sinceID, count)
 	if err != nil {
 		return err
 	}
@@ -219,12 +227,7 @@ func listEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, a
 	return nil
 }
 
-func streamEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, eventListener *EventListener, app *ct.App, repo *EventRepo) (err error) {
-	var appID string
-	if app != nil {
-		appID = app.ID
-	}
-
+func streamEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, eventListener *EventListener, appIDs []string, repo *EventRepo) (err error) {
 	var lastID int64
 	if req.Header.Get("Last-Event-Id") != "" {
 		lastID, err = strconv.ParseInt(req.Header.Get("Last-Event-Id"), 10, 64)
@@ -261,7 +264,7 @@ func streamEvents(ctx context.Context, w http.ResponseWriter, req *http.Request,
 		}
 	}()
 
-	sub, err := eventListener.Subscribe(appID, objectTypes, objectID)
+	sub, err := eventListener.Subscribe(appIDs, objectTypes, objectID)
 	if err != nil {
 		return err
 	}
@@ -269,7 +272,7 @@ func streamEvents(ctx context.Context, w http.ResponseWriter, req *http.Request,
 
 	var currID int64
 	if past == "true" || lastID > 0 {
-		list, err := repo.ListEvents(appID, objectTypes, objectID, nil, &lastID, count)
+		list, err := repo.ListEvents(appIDs, objectTypes, objectID, nil, &lastID, count)
 		if err != nil {
 			return err
 		}
@@ -313,7 +316,7 @@ type EventSubscriber struct {
 
 	l           *EventListener
 	queue       chan *ct.Event
-	appID       string
+	appIDs      []string
 	objectTypes []string
 	objectID    string
 
@@ -393,9 +396,9 @@ type EventListener struct {
 	doneCh    chan struct{}
 }
 
-// Subscribe creates and returns an EventSubscriber for the given app, type and object.
-// Using an empty string for appID subscribes to all apps
-func (e *EventListener) Subscribe(appID string, objectTypes []string, objectID string) (*EventSubscriber, error) {
+// Subscribe creates and returns an EventSubscriber for the given apps, type and object.
+// Using no appIDs subscribes to all apps
+func (e *EventListener) Subscribe(appIDs []string, objectTypes []string, objectID string) (*EventSubscriber, error) {
 	e.subMtx.Lock()
 	defer e.subMtx.Unlock()
 	if e.IsClosed() {
@@ -406,15 +409,20 @@ func (e *EventListener) Subscribe(appID string, objectTypes []string, objectID s
 		l:           e,
 		queue:       make(chan *ct.Event, eventBufferSize),
 		stop:        make(chan struct{}),
-		appID:       appID,
+		appIDs:      appIDs,
 		objectTypes: objectTypes,
 		objectID:    objectID,
 	}
 	go s.loop()
-	if _, ok := e.subscribers[appID]; !ok {
-		e.subscribers[appID] = make(map[*EventSubscriber]struct{})
+	if len(appIDs) == 0 {
+		appIDs = []string{""}
+	}
+	for _, appID := range appIDs {
+		if _, ok := e.subscribers[appID]; !ok {
+			e.subscribers[appID] = make(map[*EventSubscriber]struct{})
+		}
+		e.subscribers[appID][s] = struct{}{}
 	}
-	e.subscribers[appID][s] = struct{}{}
 	return s, nil
 }
 
@@ -422,10 +430,16 @@ func (e *EventListener) Subscribe(appID string, objectTypes []string, objectID s
 func (e *EventListener) Unsubscribe(s *EventSubscriber) {
 	e.subMtx.Lock()
 	defer e.subMtx.Unlock()
-	if subs, ok := e.subscribers[s.appID]; ok {
-		delete(subs, s)
-		if len(subs) == 0 {
-			delete(e.subscribers, s.appID)
+	appIDs := s.appIDs
+	if len(appIDs) == 0 {
+		appIDs = []string{""}
+	}
+	for _, appID := range appIDs {
+		if subs, ok := e.subscribers[appID]; ok {
+			delete(subs, s)
+			if len(subs) == 0 {
+				delete(e.subscribers, appID)
+			}
 		}
 	}
 }
@@ -112,6 +112,7 @@ func (r *FormationRepo) Add(f *ct.Formation) error {
 	scale := &ct.Scale{
 		Processes: f.Processes,
 		ReleaseID: f.ReleaseID,
+		Reason:    f.ScaleReason,
 	}
 	prevFormation, _ := r.Get(f.AppID, f.ReleaseID)
 	if prevFormation != nil {
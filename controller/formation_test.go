@@ -9,6 +9,7 @@ import (
 	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/random"
 	. "github.com/flynn/go-check"
+	"github.com/flynn/que-go"
 )
 
 func (s *S) TestFormationStreaming(c *C) {
@@ -176,7 +177,7 @@ func (s *S) TestFormationListActive(c *C) {
 
 func (s *S) TestFormationStreamingInterrupted(c *C) {
 	before := time.Now()
-	appRepo := NewAppRepo(s.hc.db, os.Getenv("DEFAULT_ROUTE_DOMAIN"), s.hc.rc)
+	appRepo := NewAppRepo(s.hc.db, que.NewClient(s.hc.db.ConnPool), os.Getenv("DEFAULT_ROUTE_DOMAIN"), s.hc.rc)
 	releaseRepo := NewReleaseRepo(s.hc.db, nil, nil)
 	artifactRepo := NewArtifactRepo(s.hc.db)
 	formationRepo := NewFormationRepo(s.hc.db, appRepo, releaseRepo, artifactRepo)
@@ -55,6 +55,7 @@ func (r *JobRepo) Add(job *ct.Job) error {
 		job.Meta,
 		job.ExitStatus,
 		job.HostError,
+		job.PendingReason,
 		job.RunAt,
 		job.Restarts,
 	).Scan(&job.CreatedAt, &job.UpdatedAt)
@@ -85,6 +86,7 @@ func scanJob(s postgres.Scanner) (*ct.Job, error) {
 		&job.Meta,
 		&job.ExitStatus,
 		&job.HostError,
+		&job.PendingReason,
 		&job.RunAt,
 		&job.Restarts,
 		&job.CreatedAt,
@@ -300,7 +302,7 @@ func (c *controllerAPI) RunJob(ctx context.Context, w http.ResponseWriter, req *
 	}
 
 	// ensure slug apps use /runner/init
-	if release.IsGitDeploy() && (len(job.Config.Args) == 0 || job.Config.Args[0] != "/runner/init") {
+	if release.IsGitDeploy() && !release.IsDockerReceiveDeploy() && (len(job.Config.Args) == 0 || job.Config.Args[0] != "/runner/init") {
 		job.Config.Args = append([]string{"/runner/init"}, job.Config.Args...)
 	}
 
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/flynn/flynn/controller/schema"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/logaggregator/drain"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+)
+
+type LogDrainRepo struct {
+	db *postgres.DB
+}
+
+func NewLogDrainRepo(db *postgres.DB) *LogDrainRepo {
+	return &LogDrainRepo{db: db}
+}
+
+func (r *LogDrainRepo) Add(d *ct.LogDrain) error {
+	if err := drain.ValidateURL(d.URL); err != nil {
+		return ct.ValidationError{Field: "url", Message: err.Error()}
+	}
+	if d.ID == "" {
+		d.ID = random.UUID()
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.QueryRow("log_drain_insert", d.ID, d.AppID, d.URL).Scan(&d.CreatedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := createEvent(tx.Exec, &ct.Event{
+		AppID:      d.AppID,
+		ObjectID:   d.ID,
+		ObjectType: ct.EventTypeLogDrain,
+	}, d); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *LogDrainRepo) Get(id string) (*ct.LogDrain, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	return scanLogDrain(r.db.QueryRow("log_drain_select", id))
+}
+
+// List returns the app's log drains, or the drains of all apps if appID is
+// empty.
+func (r *LogDrainRepo) List(appID string) ([]*ct.LogDrain, error) {
+	var rows *pgx.Rows
+	var err error
+	if appID == "" {
+		rows, err = r.db.Query("log_drain_list")
+	} else {
+		rows, err = r.db.Query("log_drain_list_by_app", appID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	drains := []*ct.LogDrain{}
+	for rows.Next() {
+		d, err := scanLogDrain(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		drains = append(drains, d)
+	}
+	return drains, rows.Err()
+}
+
+func (r *LogDrainRepo) Remove(d *ct.LogDrain) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.Exec("log_drain_delete", d.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := createEvent(tx.Exec, &ct.Event{
+		AppID:      d.AppID,
+		ObjectID:   d.ID,
+		ObjectType: ct.EventTypeLogDrainDeletion,
+	}, d); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanLogDrain(s postgres.Scanner) (*ct.LogDrain, error) {
+	d := &ct.LogDrain{}
+	err := s.Scan(&d.ID, &d.AppID, &d.URL, &d.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return d, err
+}
+
+// addLogDrainStats sets the counters of each drain from the log aggregator,
+// leaving them unset if they can't be retrieved as they are only informative
+func (c *controllerAPI) addLogDrainStats(drains []*ct.LogDrain) {
+	if len(drains) == 0 {
+		return
+	}
+	list, err := c.logaggc.GetDrainStats()
+	if err != nil {
+		logger.Error("error getting log drain stats", "fn", "addLogDrainStats", "err", err)
+		return
+	}
+	stats := make(map[string]*ct.LogDrainStats, len(list))
+	for _, s := range list {
+		stats[s.ID] = &ct.LogDrainStats{
+			Sent:        s.Sent,
+			Dropped:     s.Dropped,
+			Errors:      s.Errors,
+			LastError:   s.LastError,
+			LastErrorAt: s.LastErrorAt,
+		}
+	}
+	for _, d := range drains {
+		d.Stats = stats[d.ID]
+	}
+}
+
+func (c *controllerAPI) getLogDrain(ctx context.Context) (*ct.LogDrain, error) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	d, err := c.logDrainRepo.Get(params.ByName("log_drains_id"))
+	if err != nil {
+		return nil, err
+	}
+	if d.AppID != c.getApp(ctx).ID {
+		return nil, ErrNotFound
+	}
+	return d, nil
+}
+
+func (c *controllerAPI) CreateLogDrain(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var d ct.LogDrain
+	if err := httphelper.DecodeJSON(req, &d); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	d.AppID = c.getApp(ctx).ID
+	d.Stats = nil
+	if err := schema.Validate(d); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.logDrainRepo.Add(&d); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &d)
+}
+
+func (c *controllerAPI) GetLogDrain(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	d, err := c.getLogDrain(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	c.addLogDrainStats([]*ct.LogDrain{d})
+	httphelper.JSON(w, 200, d)
+}
+
+func (c *controllerAPI) DeleteLogDrain(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	d, err := c.getLogDrain(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.logDrainRepo.Remove(d); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func (c *controllerAPI) ListLogDrains(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	list, err := c.logDrainRepo.List(c.getApp(ctx).ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	c.addLogDrainStats(list)
+	httphelper.JSON(w, 200, list)
+}
+
+// ListAllLogDrains lists the log drains of all apps, and is used by the log
+// aggregator to load the drains it forwards logs to.
+func (c *controllerAPI) ListAllLogDrains(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	list, err := c.logDrainRepo.List("")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
@@ -61,6 +61,10 @@ type fakeLogAggregatorClient struct {
 	subs map[string]<-chan *logaggc.Message
 }
 
+func (f *fakeLogAggregatorClient) GetDrainStats() ([]*logaggc.DrainStats, error) {
+	return nil, nil
+}
+
 func (f *fakeLogAggregatorClient) GetLog(channelID string, options *logaggc.LogOpts) (io.ReadCloser, error) {
 	buf, ok := f.logs[channelID]
 	if !ok {
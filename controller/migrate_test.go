@@ -333,3 +333,37 @@ func (MigrateSuite) TestMigrateProcessArgs(c *C) {
 		}
 	}
 }
+
+// TestMigrateWebhookSecrets checks that migrating to ID 29 moves webhook
+// secrets out of app meta and the app events which include it
+func (MigrateSuite) TestMigrateWebhookSecrets(c *C) {
+	db := setupTestDB(c, "controllertest_migrate_webhook_secrets")
+	m := &testMigrator{c: c, db: db}
+
+	// start from ID 28
+	m.migrateTo(28)
+
+	// create apps with and without a secret, and an event of the former
+	appID := random.UUID()
+	meta := map[string]string{"webhook.secret": "secret", "foo": "bar"}
+	c.Assert(db.Exec(`INSERT INTO apps (app_id, name, meta) VALUES ($1, $2, $3)`, appID, "migrate-webhook-app", meta), IsNil)
+	c.Assert(db.Exec(`INSERT INTO apps (app_id, name) VALUES ($1, $2)`, random.UUID(), "migrate-no-webhook-app"), IsNil)
+	c.Assert(db.Exec(`INSERT INTO events (app_id, object_id, object_type, data) VALUES ($1, $1, 'app', $2)`, appID, map[string]interface{}{"meta": meta}), IsNil)
+
+	// migrate to 29 and check the secret was moved
+	m.migrateTo(29)
+	var secret string
+	c.Assert(db.QueryRow(`SELECT secret FROM app_webhook_secrets WHERE app_id = $1`, appID).Scan(&secret), IsNil)
+	c.Assert(secret, Equals, "secret")
+	var count int
+	c.Assert(db.QueryRow(`SELECT COUNT(*) FROM app_webhook_secrets`).Scan(&count), IsNil)
+	c.Assert(count, Equals, 1)
+	var appMeta map[string]string
+	c.Assert(db.QueryRow(`SELECT meta FROM apps WHERE app_id = $1`, appID).Scan(&appMeta), IsNil)
+	c.Assert(appMeta, DeepEquals, map[string]string{"foo": "bar"})
+	var data struct {
+		Meta map[string]string `json:"meta"`
+	}
+	c.Assert(db.QueryRow(`SELECT data FROM events WHERE app_id = $1`, appID).Scan(&data), IsNil)
+	c.Assert(data.Meta, DeepEquals, map[string]string{"foo": "bar"})
+}
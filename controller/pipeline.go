@@ -0,0 +1,481 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/flynn/flynn/controller/schema"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+)
+
+// defaultPromotionListCount is the number of promotions listed if no count
+// is given
+const defaultPromotionListCount = 50
+
+type PipelineRepo struct {
+	db *postgres.DB
+}
+
+func NewPipelineRepo(db *postgres.DB) *PipelineRepo {
+	return &PipelineRepo{db: db}
+}
+
+// validate checks the pipeline has at least two distinct apps, converting
+// app names to IDs
+func (r *PipelineRepo) validate(p *ct.Pipeline) error {
+	ids := make([]string, 0, len(p.Apps))
+	seen := make(map[string]struct{}, len(p.Apps))
+	for _, nameOrID := range p.Apps {
+		app, err := selectApp(r.db, nameOrID, false)
+		if err == ErrNotFound {
+			return ct.ValidationError{Field: "apps", Message: fmt.Sprintf("app %q does not exist", nameOrID)}
+		} else if err != nil {
+			return err
+		}
+		if _, ok := seen[app.ID]; ok {
+			return ct.ValidationError{Field: "apps", Message: fmt.Sprintf("app %q is in the pipeline more than once", nameOrID)}
+		}
+		seen[app.ID] = struct{}{}
+		ids = append(ids, app.ID)
+	}
+	if len(ids) < 2 {
+		return ct.ValidationError{Field: "apps", Message: "must contain at least two apps"}
+	}
+	p.Apps = ids
+	return nil
+}
+
+func (r *PipelineRepo) Add(p *ct.Pipeline) error {
+	if err := r.validate(p); err != nil {
+		return err
+	}
+	if p.ID == "" {
+		p.ID = random.UUID()
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow("pipeline_insert", p.ID, p.Name, p.Apps).Scan(&p.CreatedAt, &p.UpdatedAt)
+	if postgres.IsUniquenessError(err, "pipelines_name_idx") {
+		tx.Rollback()
+		return httphelper.ObjectExistsErr(fmt.Sprintf("pipeline %q already exists", p.Name))
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := createPipelineEvent(tx, ct.EventTypePipeline, p); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update changes the apps in the pipeline
+func (r *PipelineRepo) Update(p *ct.Pipeline) error {
+	if err := r.validate(p); err != nil {
+		return err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow("pipeline_update", p.ID, p.Apps).Scan(&p.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		tx.Rollback()
+		return ErrNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := createPipelineEvent(tx, ct.EventTypePipeline, p); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get returns the pipeline with the given ID or name
+func (r *PipelineRepo) Get(id string) (*ct.Pipeline, error) {
+	if idPattern.MatchString(id) {
+		return scanPipeline(r.db.QueryRow("pipeline_select_by_name_or_id", id, id))
+	}
+	return scanPipeline(r.db.QueryRow("pipeline_select_by_name", id))
+}
+
+func (r *PipelineRepo) List() ([]*ct.Pipeline, error) {
+	rows, err := r.db.Query("pipeline_list")
+	if err != nil {
+		return nil, err
+	}
+	pipelines := []*ct.Pipeline{}
+	for rows.Next() {
+		p, err := scanPipeline(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pipelines = append(pipelines, p)
+	}
+	return pipelines, rows.Err()
+}
+
+func (r *PipelineRepo) Remove(p *ct.Pipeline) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.Exec("pipeline_delete", p.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := createPipelineEvent(tx, ct.EventTypePipelineDeletion, p); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func createPipelineEvent(tx *postgres.DBTx, typ ct.EventType, p *ct.Pipeline) error {
+	return createEvent(tx.Exec, &ct.Event{
+		ObjectID:   p.ID,
+		ObjectType: typ,
+	}, p)
+}
+
+// AddPromotion records a promotion, creating a promotion event for the app
+// the release was promoted to
+func (r *PipelineRepo) AddPromotion(p *ct.Promotion) error {
+	if p.ID == "" {
+		p.ID = random.UUID()
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.QueryRow(
+		"promotion_insert",
+		p.ID,
+		p.PipelineID,
+		p.FromAppID,
+		p.FromReleaseID,
+		p.ToAppID,
+		p.ReleaseID,
+		p.DeploymentID,
+	).Scan(&p.CreatedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := createEvent(tx.Exec, &ct.Event{
+		AppID:      p.ToAppID,
+		ObjectID:   p.ID,
+		ObjectType: ct.EventTypePromotion,
+	}, p); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListPromotions returns the pipeline's most recent promotions, newest first
+func (r *PipelineRepo) ListPromotions(pipelineID string, count int) ([]*ct.Promotion, error) {
+	rows, err := r.db.Query("promotion_list", pipelineID, count)
+	if err != nil {
+		return nil, err
+	}
+	promotions := []*ct.Promotion{}
+	for rows.Next() {
+		p := &ct.Promotion{}
+		if err := rows.Scan(&p.ID, &p.PipelineID, &p.FromAppID, &p.FromReleaseID, &p.ToAppID, &p.ReleaseID, &p.DeploymentID, &p.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		promotions = append(promotions, p)
+	}
+	return promotions, rows.Err()
+}
+
+func scanPipeline(s postgres.Scanner) (*ct.Pipeline, error) {
+	p := &ct.Pipeline{}
+	err := s.Scan(&p.ID, &p.Name, &p.Apps, &p.CreatedAt, &p.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// promotedRelease returns a release for the app the release of the from app
+// is being promoted to, with the promoted release's artifacts and processes
+// but the env, process env and process resources of the to app's current
+// release (which may be nil). Process services named after the from app are
+// renamed after the to app, so the promoted processes don't register with the
+// from app's services.
+func promotedRelease(from, to *ct.App, release, current *ct.Release) *ct.Release {
+	if current == nil {
+		current = &ct.Release{}
+	}
+	promoted := &ct.Release{
+		ArtifactIDs: release.ArtifactIDs,
+		Env:         current.Env,
+		Meta:        make(map[string]string, len(release.Meta)+2),
+		Processes:   make(map[string]ct.ProcessType, len(release.Processes)),
+	}
+	for k, v := range release.Meta {
+		promoted.Meta[k] = v
+	}
+	promoted.Meta[ct.PromotedFromAppMetaKey] = from.ID
+	promoted.Meta[ct.PromotedFromReleaseMetaKey] = release.ID
+
+	service := func(name string) string {
+		if name == from.Name {
+			return to.Name
+		}
+		if strings.HasPrefix(name, from.Name+"-") {
+			return to.Name + strings.TrimPrefix(name, from.Name)
+		}
+		return name
+	}
+	for typ, proc := range release.Processes {
+		proc.Service = service(proc.Service)
+		ports := make([]ct.Port, len(proc.Ports))
+		for i, port := range proc.Ports {
+			if port.Service != nil {
+				s := *port.Service
+				s.Name = service(s.Name)
+				port.Service = &s
+			}
+			ports[i] = port
+		}
+		proc.Ports = ports
+		if currentProc, ok := current.Processes[typ]; ok {
+			proc.Env = currentProc.Env
+			proc.Resources = currentProc.Resources
+		}
+		promoted.Processes[typ] = proc
+	}
+	return promoted
+}
+
+// promote creates a release of the next app in the pipeline from the given
+// release of the from app and deploys it
+func (c *controllerAPI) promote(pipeline *ct.Pipeline, from *ct.App, release *ct.Release) (*ct.Promotion, error) {
+	next := -1
+	for i, id := range pipeline.Apps {
+		if id == from.ID && i < len(pipeline.Apps)-1 {
+			next = i + 1
+			break
+		}
+	}
+	if next == -1 {
+		return nil, ct.ValidationError{Field: "from_app", Message: "must be an app in the pipeline other than the last"}
+	}
+	data, err := c.appRepo.Get(pipeline.Apps[next])
+	if err != nil {
+		return nil, err
+	}
+	to := data.(*ct.App)
+	current, err := c.appRepo.GetRelease(to.ID)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	promoted := promotedRelease(from, to, release, current)
+	if err := c.releaseRepo.Add(promoted); err != nil {
+		return nil, err
+	}
+	deployment, err := c.deploy(to, promoted)
+	if err != nil {
+		return nil, err
+	}
+	promotion := &ct.Promotion{
+		PipelineID:    pipeline.ID,
+		FromAppID:     from.ID,
+		FromReleaseID: release.ID,
+		ToAppID:       to.ID,
+		ReleaseID:     promoted.ID,
+		DeploymentID:  deployment.ID,
+	}
+	return promotion, c.pipelineRepo.AddPromotion(promotion)
+}
+
+func (c *controllerAPI) getPipeline(ctx context.Context) (*ct.Pipeline, error) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	return c.pipelineRepo.Get(params.ByName("pipelines_id"))
+}
+
+func (c *controllerAPI) CreatePipeline(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var pipeline ct.Pipeline
+	if err := httphelper.DecodeJSON(req, &pipeline); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := schema.Validate(pipeline); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.pipelineRepo.Add(&pipeline); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &pipeline)
+}
+
+func (c *controllerAPI) GetPipeline(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	pipeline, err := c.getPipeline(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, pipeline)
+}
+
+func (c *controllerAPI) ListPipelines(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	list, err := c.pipelineRepo.List()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
+
+func (c *controllerAPI) UpdatePipeline(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	existing, err := c.getPipeline(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var pipeline ct.Pipeline
+	if err := httphelper.DecodeJSON(req, &pipeline); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	pipeline.ID = existing.ID
+	pipeline.Name = existing.Name
+	pipeline.CreatedAt = existing.CreatedAt
+	if err := schema.Validate(pipeline); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.pipelineRepo.Update(&pipeline); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &pipeline)
+}
+
+func (c *controllerAPI) DeletePipeline(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	pipeline, err := c.getPipeline(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.pipelineRepo.Remove(pipeline); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// CreatePromotion promotes a release of one of the pipeline's apps to the
+// next app in the pipeline. The release defaults to the current release of
+// the app, which defaults to the first app in the pipeline.
+func (c *controllerAPI) CreatePromotion(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	pipeline, err := c.getPipeline(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var promotion ct.Promotion
+	if err := httphelper.DecodeJSON(req, &promotion); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if len(pipeline.Apps) < 2 {
+		// apps are removed from pipelines when they are deleted
+		respondWithError(w, ct.ValidationError{Field: "apps", Message: "pipeline must contain at least two apps"})
+		return
+	}
+	if promotion.FromAppID == "" {
+		promotion.FromAppID = pipeline.Apps[0]
+	}
+	data, err := c.appRepo.Get(promotion.FromAppID)
+	if err == ErrNotFound {
+		respondWithError(w, ct.ValidationError{Field: "from_app", Message: fmt.Sprintf("app %q does not exist", promotion.FromAppID)})
+		return
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	from := data.(*ct.App)
+
+	release, err := c.appRepo.GetRelease(from.ID)
+	if err == ErrNotFound && promotion.FromReleaseID == "" {
+		respondWithError(w, ct.ValidationError{Field: "from_app", Message: fmt.Sprintf("app %q has no release to promote", from.Name)})
+		return
+	} else if err != nil && err != ErrNotFound {
+		respondWithError(w, err)
+		return
+	}
+	if promotion.FromReleaseID != "" && (release == nil || release.ID != promotion.FromReleaseID) {
+		release, err = c.appRelease(from.ID, promotion.FromReleaseID)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+	}
+
+	p, err := c.promote(pipeline, from, release)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, p)
+}
+
+// appRelease returns the release of the app with the given ID, which must
+// have a formation for the app
+func (c *controllerAPI) appRelease(appID, releaseID string) (*ct.Release, error) {
+	releases, err := c.releaseRepo.AppList(appID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range releases {
+		if r.ID == releaseID {
+			return r, nil
+		}
+	}
+	return nil, ct.ValidationError{Field: "from_release", Message: fmt.Sprintf("release %q is not a release of the app", releaseID)}
+}
+
+func (c *controllerAPI) ListPromotions(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	pipeline, err := c.getPipeline(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	count := defaultPromotionListCount
+	if s := req.FormValue("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			respondWithError(w, ct.ValidationError{Field: "count", Message: "must be a positive integer"})
+			return
+		}
+		count = n
+	}
+	list, err := c.pipelineRepo.ListPromotions(pipeline.ID, count)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/resource"
+	"github.com/flynn/flynn/host/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestPipelines(c *C) {
+	staging := s.createTestApp(c, &ct.App{Name: "pipelines-staging"})
+	production := s.createTestApp(c, &ct.App{Name: "pipelines-production"})
+
+	pipeline := &ct.Pipeline{Name: "pipelines", Apps: []string{staging.Name, production.ID}}
+	c.Assert(s.c.CreatePipeline(pipeline), IsNil)
+	c.Assert(pipeline.ID, Not(Equals), "")
+	c.Assert(pipeline.Apps, DeepEquals, []string{staging.ID, production.ID})
+
+	// names are unique
+	err := s.c.CreatePipeline(&ct.Pipeline{Name: pipeline.Name, Apps: pipeline.Apps})
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+
+	// pipelines need at least two distinct apps
+	for _, apps := range [][]string{
+		{staging.ID},
+		{staging.ID, staging.Name},
+		{staging.ID, "pipelines-nonexistent"},
+	} {
+		err := s.c.CreatePipeline(&ct.Pipeline{Name: "pipelines-invalid", Apps: apps})
+		c.Assert(hh.IsValidationError(err), Equals, true)
+	}
+
+	gotPipeline, err := s.c.GetPipeline(pipeline.Name)
+	c.Assert(err, IsNil)
+	c.Assert(gotPipeline.ID, Equals, pipeline.ID)
+
+	pipeline.Apps = []string{production.ID, staging.ID}
+	c.Assert(s.c.UpdatePipeline(pipeline), IsNil)
+	gotPipeline, err = s.c.GetPipeline(pipeline.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotPipeline.Apps, DeepEquals, []string{production.ID, staging.ID})
+
+	list, err := s.c.PipelineList()
+	c.Assert(err, IsNil)
+	var found bool
+	for _, p := range list {
+		if p.ID == pipeline.ID {
+			found = true
+		}
+	}
+	c.Assert(found, Equals, true)
+
+	c.Assert(s.c.DeletePipeline(pipeline.ID), IsNil)
+	_, err = s.c.GetPipeline(pipeline.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
+func (s *S) TestPromote(c *C) {
+	staging := s.createTestApp(c, &ct.App{Name: "promote-staging"})
+	production := s.createTestApp(c, &ct.App{Name: "promote-production"})
+	pipeline := &ct.Pipeline{Name: "promote", Apps: []string{staging.ID, production.ID}}
+	c.Assert(s.c.CreatePipeline(pipeline), IsNil)
+
+	// promoting an app without a release fails
+	err := s.c.Promote(pipeline.ID, &ct.Promotion{})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	stagingRelease := s.createTestRelease(c, &ct.Release{
+		Env:  map[string]string{"DATABASE_URL": "postgres://staging"},
+		Meta: map[string]string{"git": "true"},
+		Processes: map[string]ct.ProcessType{
+			"web": {
+				Args:    []string{"start", "web"},
+				Service: "promote-staging-web",
+				Ports: []ct.Port{{
+					Port:    8080,
+					Proto:   "tcp",
+					Service: &host.Service{Name: "promote-staging-web", Create: true},
+				}},
+			},
+			"worker": {Args: []string{"start", "worker"}},
+		},
+	})
+	c.Assert(s.c.SetAppRelease(staging.ID, stagingRelease.ID), IsNil)
+
+	memory := int64(2 * 1024 * 1024 * 1024)
+	productionRelease := s.createTestRelease(c, &ct.Release{
+		Env: map[string]string{"DATABASE_URL": "postgres://production"},
+		Processes: map[string]ct.ProcessType{
+			"web": {
+				Service:   "promote-production-web",
+				Env:       map[string]string{"WEB_CONCURRENCY": "4"},
+				Resources: resource.Resources{resource.TypeMemory: {Limit: &memory}},
+			},
+		},
+	})
+	c.Assert(s.c.SetAppRelease(production.ID, productionRelease.ID), IsNil)
+
+	promotion := &ct.Promotion{}
+	c.Assert(s.c.Promote(pipeline.Name, promotion), IsNil)
+	c.Assert(promotion.ID, Not(Equals), "")
+	c.Assert(promotion.PipelineID, Equals, pipeline.ID)
+	c.Assert(promotion.FromAppID, Equals, staging.ID)
+	c.Assert(promotion.FromReleaseID, Equals, stagingRelease.ID)
+	c.Assert(promotion.ToAppID, Equals, production.ID)
+	c.Assert(promotion.DeploymentID, Not(Equals), "")
+
+	// the release has the promoted artifacts and processes but keeps the
+	// production env and resources, and production has nothing running so
+	// it is deployed immediately
+	release, err := s.c.GetAppRelease(production.ID)
+	c.Assert(err, IsNil)
+	c.Assert(release.ID, Equals, promotion.ReleaseID)
+	c.Assert(release.ArtifactIDs, DeepEquals, stagingRelease.ArtifactIDs)
+	c.Assert(release.Env, DeepEquals, productionRelease.Env)
+	c.Assert(release.Meta["git"], Equals, "true")
+	c.Assert(release.Meta[ct.PromotedFromAppMetaKey], Equals, staging.ID)
+	c.Assert(release.Meta[ct.PromotedFromReleaseMetaKey], Equals, stagingRelease.ID)
+	c.Assert(release.Processes, HasLen, 2)
+	web := release.Processes["web"]
+	c.Assert(web.Args, DeepEquals, []string{"start", "web"})
+	c.Assert(web.Service, Equals, "promote-production-web")
+	c.Assert(web.Ports[0].Service.Name, Equals, "promote-production-web")
+	c.Assert(web.Env, DeepEquals, map[string]string{"WEB_CONCURRENCY": "4"})
+	c.Assert(*web.Resources[resource.TypeMemory].Limit, Equals, memory)
+	c.Assert(release.Processes["worker"].Args, DeepEquals, []string{"start", "worker"})
+
+	// the last app in the pipeline can't be promoted
+	err = s.c.Promote(pipeline.ID, &ct.Promotion{FromAppID: production.Name})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// nor can releases of other apps
+	other := s.createTestRelease(c, &ct.Release{})
+	err = s.c.Promote(pipeline.ID, &ct.Promotion{FromReleaseID: other.ID})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	promotions, err := s.c.PromotionList(pipeline.ID, 0)
+	c.Assert(err, IsNil)
+	c.Assert(promotions, HasLen, 1)
+	c.Assert(promotions[0].ID, Equals, promotion.ID)
+
+	events, err := s.c.ListEvents(ct.ListEventsOptions{
+		AppID:       production.ID,
+		ObjectTypes: []ct.EventType{ct.EventTypePromotion},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+	c.Assert(events[0].ObjectID, Equals, promotion.ID)
+	var p ct.Promotion
+	c.Assert(json.Unmarshal(events[0].Data, &p), IsNil)
+	c.Assert(p.ReleaseID, Equals, promotion.ReleaseID)
+	c.Assert(p.DeploymentID, Equals, promotion.DeploymentID)
+}
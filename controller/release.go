@@ -61,6 +61,10 @@ func (r *ReleaseRepo) Add(data interface{}) error {
 		}
 		resource.SetDefaults(&proc.Resources)
 		release.Processes[typ] = proc
+
+		if err := validatePlacement(typ, proc.Placement); err != nil {
+			return err
+		}
 	}
 
 	if release.ID == "" {
@@ -112,6 +116,24 @@ func (r *ReleaseRepo) Add(data interface{}) error {
 	return tx.Commit()
 }
 
+func validatePlacement(typ string, constraints []ct.PlacementConstraint) error {
+	field := fmt.Sprintf("processes.%s.placement", typ)
+	for _, c := range constraints {
+		switch c.Type {
+		case ct.PlacementAffinity:
+			// the first job of a process type with affinity to itself
+			// could never be placed
+			if c.App == "" && (c.ProcessType == "" || c.ProcessType == typ) {
+				return ct.ValidationError{Field: field, Message: "affinity constraints must target a different process type"}
+			}
+		case ct.PlacementAntiAffinity, ct.PlacementSpread:
+		default:
+			return ct.ValidationError{Field: field, Message: fmt.Sprintf("unknown placement constraint type %q", c.Type)}
+		}
+	}
+	return nil
+}
+
 func (r *ReleaseRepo) Get(id string) (interface{}, error) {
 	row := r.db.QueryRow("release_select", id)
 	return scanRelease(row)
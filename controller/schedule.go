@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/flynn/flynn/controller/schema"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/cron"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/que-go"
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+)
+
+type ScheduleRepo struct {
+	db       *postgres.DB
+	q        *que.Client
+	releases *ReleaseRepo
+}
+
+func NewScheduleRepo(db *postgres.DB, q *que.Client, releaseRepo *ReleaseRepo) *ScheduleRepo {
+	return &ScheduleRepo{db: db, q: q, releases: releaseRepo}
+}
+
+// validate checks the schedule's cron expression, calculating when it is
+// next due, and that the process type exists in the app's current release
+func (r *ScheduleRepo) validate(app *ct.App, s *ct.Schedule) (time.Time, error) {
+	c, err := cron.Parse(s.Cron)
+	if err != nil {
+		return time.Time{}, ct.ValidationError{Field: "cron", Message: err.Error()}
+	}
+	next := c.Next(time.Now().UTC())
+	if next.IsZero() {
+		return time.Time{}, ct.ValidationError{Field: "cron", Message: cron.ErrNeverDue.Error()}
+	}
+	if s.ProcessType == "" && len(s.Args) == 0 {
+		return time.Time{}, ct.ValidationError{Message: "either process_type or args must be set"}
+	}
+	if s.ProcessType != "" && app.ReleaseID != "" {
+		data, err := r.releases.Get(app.ReleaseID)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if _, ok := data.(*ct.Release).Processes[s.ProcessType]; !ok {
+			return time.Time{}, ct.ValidationError{Field: "process_type", Message: fmt.Sprintf("process type %q does not exist in the app's release", s.ProcessType)}
+		}
+	}
+	return next, nil
+}
+
+func (r *ScheduleRepo) Add(app *ct.App, s *ct.Schedule) error {
+	next, err := r.validate(app, s)
+	if err != nil {
+		return err
+	}
+	if s.ID == "" {
+		s.ID = random.UUID()
+	}
+	if s.ConcurrencyPolicy == "" {
+		s.ConcurrencyPolicy = ct.ConcurrencyPolicyAllow
+	}
+	s.AppID = app.ID
+	s.NextRunAt = &next
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.QueryRow(
+		"schedule_insert",
+		s.ID,
+		s.AppID,
+		s.Cron,
+		s.ProcessType,
+		s.Args,
+		s.Env,
+		string(s.ConcurrencyPolicy),
+		s.NextRunAt,
+	).Scan(&s.CreatedAt, &s.UpdatedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := r.enqueueRun(tx, s); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *ScheduleRepo) Update(app *ct.App, s *ct.Schedule) error {
+	prev, err := r.Get(s.ID)
+	if err != nil {
+		return err
+	}
+	next, err := r.validate(app, s)
+	if err != nil {
+		return err
+	}
+	if s.ConcurrencyPolicy == "" {
+		s.ConcurrencyPolicy = prev.ConcurrencyPolicy
+	}
+	s.AppID = prev.AppID
+	s.NextRunAt = &next
+	s.CreatedAt = prev.CreatedAt
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.QueryRow(
+		"schedule_update",
+		s.ID,
+		s.Cron,
+		s.ProcessType,
+		s.Args,
+		s.Env,
+		string(s.ConcurrencyPolicy),
+		s.NextRunAt,
+	).Scan(&s.UpdatedAt); err != nil {
+		tx.Rollback()
+		if err == pgx.ErrNoRows {
+			err = ErrNotFound
+		}
+		return err
+	}
+	// the job enqueued for the previous time is ignored when it runs as
+	// it no longer matches next_run_at
+	if !prev.NextRunAt.Equal(next) {
+		if err := r.enqueueRun(tx, s); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *ScheduleRepo) enqueueRun(tx *postgres.DBTx, s *ct.Schedule) error {
+	args, err := json.Marshal(ct.ScheduleRun{
+		AppID:      s.AppID,
+		ScheduleID: s.ID,
+		RunAt:      *s.NextRunAt,
+	})
+	if err != nil {
+		return err
+	}
+	return r.q.EnqueueInTx(&que.Job{
+		Type:  "schedule",
+		Args:  args,
+		RunAt: *s.NextRunAt,
+	}, tx.Tx)
+}
+
+func (r *ScheduleRepo) Get(id string) (*ct.Schedule, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	return scanSchedule(r.db.QueryRow("schedule_select", id))
+}
+
+func (r *ScheduleRepo) List(appID string) ([]*ct.Schedule, error) {
+	rows, err := r.db.Query("schedule_list", appID)
+	if err != nil {
+		return nil, err
+	}
+	var schedules []*ct.Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+func (r *ScheduleRepo) Remove(id string) error {
+	return r.db.Exec("schedule_delete", id)
+}
+
+func scanSchedule(s postgres.Scanner) (*ct.Schedule, error) {
+	schedule := &ct.Schedule{}
+	var processType *string
+	var policy string
+	err := s.Scan(
+		&schedule.ID,
+		&schedule.AppID,
+		&schedule.Cron,
+		&processType,
+		&schedule.Args,
+		&schedule.Env,
+		&policy,
+		&schedule.NextRunAt,
+		&schedule.CreatedAt,
+		&schedule.UpdatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if processType != nil {
+		schedule.ProcessType = *processType
+	}
+	schedule.ConcurrencyPolicy = ct.ConcurrencyPolicy(policy)
+	return schedule, nil
+}
+
+func (c *controllerAPI) getSchedule(ctx context.Context) (*ct.Schedule, error) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	schedule, err := c.scheduleRepo.Get(params.ByName("schedules_id"))
+	if err != nil {
+		return nil, err
+	}
+	if schedule.AppID != c.getApp(ctx).ID {
+		return nil, ErrNotFound
+	}
+	return schedule, nil
+}
+
+func (c *controllerAPI) CreateSchedule(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var schedule ct.Schedule
+	if err := httphelper.DecodeJSON(req, &schedule); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	app := c.getApp(ctx)
+	schedule.AppID = app.ID
+	schedule.NextRunAt = nil
+	if err := schema.Validate(schedule); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.scheduleRepo.Add(app, &schedule); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &schedule)
+}
+
+func (c *controllerAPI) GetSchedule(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	schedule, err := c.getSchedule(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, schedule)
+}
+
+func (c *controllerAPI) UpdateSchedule(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	existing, err := c.getSchedule(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var schedule ct.Schedule
+	if err := httphelper.DecodeJSON(req, &schedule); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	schedule.ID = existing.ID
+	schedule.AppID = existing.AppID
+	schedule.NextRunAt = nil
+	if err := schema.Validate(schedule); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.scheduleRepo.Update(c.getApp(ctx), &schedule); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &schedule)
+}
+
+func (c *controllerAPI) DeleteSchedule(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	schedule, err := c.getSchedule(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.scheduleRepo.Remove(schedule.ID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func (c *controllerAPI) ListSchedules(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	list, err := c.scheduleRepo.List(c.getApp(ctx).ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestSchedules(c *C) {
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"worker": {Args: []string{"worker"}}},
+	})
+	app := s.createTestApp(c, &ct.App{Name: "schedule-test"})
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+
+	// create a schedule running a command
+	before := time.Now()
+	schedule := &ct.Schedule{
+		Cron: "*/5 * * * *",
+		Args: []string{"/bin/true"},
+		Env:  map[string]string{"FOO": "bar"},
+	}
+	c.Assert(s.c.CreateSchedule(app.ID, schedule), IsNil)
+	c.Assert(schedule.ID, Not(Equals), "")
+	c.Assert(schedule.AppID, Equals, app.ID)
+	c.Assert(schedule.ConcurrencyPolicy, Equals, ct.ConcurrencyPolicyAllow)
+	c.Assert(schedule.NextRunAt, NotNil)
+	c.Assert(schedule.NextRunAt.After(before), Equals, true)
+	c.Assert(schedule.NextRunAt.Minute()%5, Equals, 0)
+
+	gotten, err := s.c.GetSchedule(app.ID, schedule.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotten.Cron, Equals, schedule.Cron)
+	c.Assert(gotten.Args, DeepEquals, schedule.Args)
+	c.Assert(gotten.Env, DeepEquals, schedule.Env)
+	c.Assert(gotten.NextRunAt.Equal(*schedule.NextRunAt), Equals, true)
+
+	// create a schedule running a process type
+	procSchedule := &ct.Schedule{
+		Cron:              "@daily",
+		ProcessType:       "worker",
+		ConcurrencyPolicy: ct.ConcurrencyPolicyForbid,
+	}
+	c.Assert(s.c.CreateSchedule(app.ID, procSchedule), IsNil)
+	c.Assert(procSchedule.NextRunAt.Hour(), Equals, 0)
+	c.Assert(procSchedule.NextRunAt.Minute(), Equals, 0)
+
+	list, err := s.c.ScheduleList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
+	c.Assert(list[0].ID, Equals, procSchedule.ID)
+	c.Assert(list[1].ID, Equals, schedule.ID)
+
+	// update the cron expression
+	schedule.Cron = "@hourly"
+	schedule.ConcurrencyPolicy = ct.ConcurrencyPolicyReplace
+	c.Assert(s.c.UpdateSchedule(schedule), IsNil)
+	c.Assert(schedule.NextRunAt.Minute(), Equals, 0)
+	gotten, err = s.c.GetSchedule(app.ID, schedule.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotten.Cron, Equals, "@hourly")
+	c.Assert(gotten.ConcurrencyPolicy, Equals, ct.ConcurrencyPolicyReplace)
+
+	// schedules are not accessible from other apps
+	other := s.createTestApp(c, &ct.App{Name: "schedule-test-other"})
+	_, err = s.c.GetSchedule(other.ID, schedule.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// delete a schedule
+	c.Assert(s.c.DeleteSchedule(app.ID, schedule.ID), IsNil)
+	_, err = s.c.GetSchedule(app.ID, schedule.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+	list, err = s.c.ScheduleList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+}
+
+func (s *S) TestScheduleValidation(c *C) {
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"worker": {Args: []string{"worker"}}},
+	})
+	app := s.createTestApp(c, &ct.App{Name: "schedule-validation-test"})
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+
+	for _, schedule := range []*ct.Schedule{
+		{Args: []string{"/bin/true"}},
+		{Cron: "* * *", Args: []string{"/bin/true"}},
+		{Cron: "0 0 30 2 *", Args: []string{"/bin/true"}},
+		{Cron: "@daily"},
+		{Cron: "@daily", ProcessType: "web"},
+		{Cron: "@daily", Args: []string{"/bin/true"}, ConcurrencyPolicy: "sometimes"},
+	} {
+		err := s.c.CreateSchedule(app.ID, schedule)
+		e, ok := err.(httphelper.JSONError)
+		c.Assert(ok, Equals, true, Commentf("cron = %q, err = %v", schedule.Cron, err))
+		c.Assert(e.Code, Equals, httphelper.ValidationErrorCode)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"time"
 
@@ -16,6 +17,10 @@ const serviceName = "controller-scheduler"
 type Discoverd interface {
 	Register() (bool, error)
 	LeaderCh() chan bool
+
+	// WaitForJob waits for the job with the given ID to be registered as
+	// an instance of the given service
+	WaitForJob(service, jobID string, timeout time.Duration) error
 }
 
 func newDiscoverdWrapper(l log15.Logger) *discoverdWrapper {
@@ -94,3 +99,26 @@ func (d *discoverdWrapper) Register() (bool, error) {
 func (d *discoverdWrapper) LeaderCh() chan bool {
 	return d.leader
 }
+
+func (d *discoverdWrapper) WaitForJob(service, jobID string, timeout time.Duration) error {
+	events := make(chan *discoverd.Event)
+	stream, err := discoverd.NewService(service).Watch(events)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	timeoutCh := time.After(timeout)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return fmt.Errorf("service stream closed unexpectedly: %s", stream.Err())
+			}
+			if (event.Kind == discoverd.EventKindUp || event.Kind == discoverd.EventKindUpdate) && event.Instance.Meta["FLYNN_JOB_ID"] == jobID {
+				return nil
+			}
+		case <-timeoutCh:
+			return fmt.Errorf("timed out waiting for job %s to register with service %s", jobID, service)
+		}
+	}
+}
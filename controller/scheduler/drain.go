@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/utils"
+	host "github.com/flynn/flynn/host/types"
+)
+
+// serviceCheck is sent from a goroutine started by checkService to the main
+// scheduler loop once a replacement job has registered with its process
+// type's service (or failed to do so in time)
+type serviceCheck struct {
+	job *Job
+	err error
+}
+
+func drainEqual(a, b *host.DrainStatus) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// setHostDrain updates the drain status of the host, starting to move its
+// jobs to other hosts if it is now being drained, or cancelling any job
+// migrations if it no longer is
+func (s *Scheduler) setHostDrain(h *Host, drain *host.DrainStatus) {
+	log := s.logger.New("fn", "setHostDrain", "host.id", h.ID)
+	wasDraining := h.Drain != nil
+	h.Drain = drain
+
+	if drain == nil {
+		if !wasDraining {
+			return
+		}
+		log.Info("host is no longer draining, cancelling job migrations")
+
+		// count migrating jobs towards their formations again, which
+		// causes the surplus replacement jobs to be stopped
+		for _, job := range s.jobs {
+			if job.HostID == h.ID && job.migrating {
+				job.migrating = false
+				s.triggerRectify(job.Formation.key())
+			}
+		}
+
+		// pending jobs can now be placed on the host
+		s.maybeStartPendingTagJobs(h)
+		s.maybeStartPendingPlacementJobs()
+		return
+	}
+
+	if !wasDraining {
+		log.Info("host is draining, moving jobs to other hosts", "drain.data", drain.Data)
+	}
+	s.drainHost(h)
+}
+
+// drainHosts moves jobs off any hosts which are being drained, and is
+// expected to be called whenever that may be able to progress (i.e. a job has
+// changed state)
+func (s *Scheduler) drainHosts() {
+	for _, h := range s.hosts {
+		if h.Drain != nil {
+			s.drainHost(h)
+		}
+	}
+}
+
+// drainHost moves jobs off the draining host one at a time, starting a
+// replacement for a job on another host and only stopping the job once the
+// replacement is up, then marks the host as drained once the only jobs left
+// on it are omni jobs (which run on every host).
+//
+// Jobs with data volumes are only moved if requested when draining the host,
+// otherwise they are left running and prevent the host from being drained.
+// Their volumes are migrated to the replacement's host, which involves
+// stopping the job before the replacement starts (see migrateVolumes).
+func (s *Scheduler) drainHost(h *Host) {
+	if !s.IsLeader() || h.Drain == nil || h.Shutdown {
+		return
+	}
+	log := s.logger.New("fn", "drainHost", "host.id", h.ID)
+
+	jobs := make(sortJobs, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if job.HostID == h.ID && job.State != JobStateStopped {
+			jobs = append(jobs, job)
+		}
+	}
+	jobs.SortReverse()
+
+	// wait for any job currently being moved to be replaced before moving
+	// the next one
+	for _, job := range jobs {
+		if !job.migrating || job.IsStopped() {
+			continue
+		}
+		replacement := s.jobs.replacementFor(job)
+		switch {
+		case replacement == nil:
+			// the formation was scaled down whilst the job was
+			// being moved, so it is no longer needed
+			log.Info("migrating job no longer needed, stopping", "job.id", job.ID)
+			s.stopJob(job)
+		case replacement.State != JobStateRunning:
+			// wait for the replacement to start
+		case replacement.Service() != "" && !replacement.registered:
+			s.checkService(replacement)
+		default:
+			log.Info("replacement job is up, stopping migrated job", "job.id", job.ID, "replacement.id", replacement.ID, "replacement.host.id", replacement.HostID)
+			s.stopJob(job)
+		}
+		return
+	}
+
+	// jobs with data volumes are stopped before their replacement starts,
+	// so also wait for the replacements of stopped jobs to come up
+	for _, job := range s.jobs {
+		if r := job.replaces; r != nil && r.HostID == h.ID && r.migrating && r.IsStopped() && !job.IsStopped() && job.State != JobStateRunning {
+			return
+		}
+	}
+
+	remaining := 0
+	for _, job := range jobs {
+		if job.IsOmni() {
+			continue
+		}
+		remaining++
+		switch {
+		case job.Formation == nil:
+			// wait for one-off jobs to exit
+		case job.needsVolume() && !h.Drain.Data:
+			// leave jobs with data volumes running
+		case !job.IsRunning():
+			// wait for jobs to either start or stop
+		default:
+			s.migrateJob(job)
+			return
+		}
+	}
+	if remaining > 0 || h.Drain.Drained {
+		return
+	}
+
+	log.Info("host drained")
+	h.Drain.Drained = true
+	go func() {
+		// host.MarkDrained can block, so run it in a goroutine
+		if err := h.client.MarkDrained(); err != nil {
+			log.Error("error marking host as drained", "err", err)
+		}
+	}()
+}
+
+// migrateJob starts a job on another host to replace the given job, which is
+// excluded from its formation's process counts until it is stopped
+func (s *Scheduler) migrateJob(job *Job) {
+	replacement := &Job{
+		ID:        s.generateJobUUID(),
+		Type:      job.Type,
+		AppID:     job.AppID,
+		ReleaseID: job.ReleaseID,
+		Formation: job.Formation,
+		StartedAt: time.Now(),
+		State:     JobStatePending,
+		replaces:  job,
+	}
+	log := s.logger.New("fn", "migrateJob", "job.id", job.ID, "job.type", job.Type, "host.id", job.HostID, "replacement.id", replacement.ID)
+	log.Info("migrating job to another host")
+
+	if job.needsVolume() {
+		if h, ok := s.hosts[job.HostID]; ok {
+			replacement.volumeSource = &volumeSource{host: h.client, jobID: job.JobID}
+		}
+	}
+
+	job.migrating = true
+	s.jobs.Add(replacement)
+
+	// persist the job so that it appears as pending in the database
+	s.persistJob(replacement)
+
+	go s.StartJob(replacement)
+}
+
+// migrateStopTimeout is how long to wait for a data job to stop when
+// migrating its volumes
+const migrateStopTimeout = time.Minute
+
+// volumeSource is the host and cluster job ID of a data job being migrated
+type volumeSource struct {
+	host  utils.HostClient
+	jobID string
+}
+
+// migrateVolumes copies the volumes of the data job being migrated onto new
+// volumes on the target host and binds them in the replacement job's config.
+//
+// The volumes are first copied whilst the data job is still running, then the
+// job is stopped and only what changed in the meantime is copied, which keeps
+// the time that the job is down short.
+func migrateVolumes(src *volumeSource, target utils.HostClient, config *host.Job) (err error) {
+	job, err := src.host.GetJob(src.jobID)
+	if err != nil {
+		return err
+	}
+	bindings := job.Job.Config.Volumes
+	if len(bindings) == 0 {
+		return utils.ProvisionVolume(target, config)
+	}
+
+	volumes := make([]host.VolumeBinding, 0, len(bindings))
+	var snapshots []string
+	defer func() {
+		// the snapshots taken on the source host are only needed to
+		// send the final changes incrementally
+		for _, id := range snapshots {
+			src.host.DestroyVolume(id)
+		}
+		if err != nil {
+			for _, v := range volumes {
+				target.DestroyVolume(v.VolumeID)
+			}
+		}
+	}()
+	pull := func() error {
+		for i, b := range bindings {
+			transfer, err := target.PullVolume(volumes[i].VolumeID, src.host.ID(), b.VolumeID)
+			if err != nil {
+				return err
+			}
+			snapshots = append(snapshots, transfer.SourceSnapshotID)
+		}
+		return nil
+	}
+
+	for _, b := range bindings {
+		vol, err := target.CreateVolume("default")
+		if err != nil {
+			return err
+		}
+		b.VolumeID = vol.ID
+		volumes = append(volumes, b)
+	}
+	if err := pull(); err != nil {
+		return err
+	}
+	if err := stopJobAndWait(src.host, src.jobID); err != nil {
+		return err
+	}
+	if err := pull(); err != nil {
+		return err
+	}
+	config.Config.Volumes = volumes
+	return nil
+}
+
+// stopJobAndWait stops the given job (if it is still running) and waits for
+// the host to report that it has stopped
+func stopJobAndWait(h utils.HostClient, id string) error {
+	timeout := time.After(migrateStopTimeout)
+	stopping := false
+	for {
+		job, err := h.GetJob(id)
+		if err != nil {
+			return err
+		}
+		switch job.Status {
+		case host.StatusDone, host.StatusCrashed, host.StatusFailed:
+			return nil
+		}
+		if !stopping {
+			if err := h.StopJob(id); err != nil {
+				return err
+			}
+			stopping = true
+			continue
+		}
+		select {
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for job %s to stop", id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// checkService starts waiting for the replacement job to register with its
+// process type's service, as the deployer does when deploying a release
+func (s *Scheduler) checkService(job *Job) {
+	if job.checkingService {
+		return
+	}
+	job.checkingService = true
+
+	timeout := time.Duration(ct.DefaultDeployTimeout) * time.Second
+	if t := job.Formation.App.DeployTimeout; t > 0 {
+		timeout = time.Duration(t) * time.Second
+	}
+	service := job.Service()
+	jobID := job.JobID
+	go func() {
+		err := s.discoverd.WaitForJob(service, jobID, timeout)
+		s.serviceChecks <- &serviceCheck{job: job, err: err}
+	}()
+}
+
+func (s *Scheduler) HandleServiceCheck(check *serviceCheck) {
+	job := check.job
+	job.checkingService = false
+	if check.err != nil {
+		// stop the replacement and count the job it was replacing
+		// towards its formation again so that moving it is retried
+		s.logger.Error("error waiting for replacement job to register with service", "fn", "HandleServiceCheck", "job.id", job.ID, "service", job.Service(), "err", check.err)
+		if job.replaces != nil {
+			job.replaces.migrating = false
+		}
+		s.stopJob(job)
+		return
+	}
+	job.registered = true
+	s.drainHosts()
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/flynn/flynn/controller/testutils"
 	"github.com/flynn/flynn/controller/utils"
+	"github.com/flynn/flynn/host/resource"
 	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/stream"
 	"gopkg.in/inconshreveable/log15.v2"
@@ -20,6 +21,20 @@ type Host struct {
 	Checks   int               `json:"checks"`
 	Shutdown bool              `json:"shutdown"`
 
+	// Capacity is the amount of each resource the host makes available
+	// to jobs, and is nil if the host does not report it (in which case
+	// the host is considered to have unlimited capacity)
+	Capacity resource.Capacity `json:"capacity,omitempty"`
+
+	// Reserved is the total requests of jobs placed on the host, and is
+	// only populated in snapshots of the scheduler's internal state (it
+	// is otherwise calculated from the jobs when placing a job)
+	Reserved resource.Capacity `json:"reserved,omitempty"`
+
+	// Drain is set when the host is being drained, in which case no jobs
+	// are placed on it and its jobs are moved to other hosts
+	Drain *host.DrainStatus `json:"drain,omitempty"`
+
 	client   utils.HostClient
 	stop     chan struct{}
 	stopOnce sync.Once
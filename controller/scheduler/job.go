@@ -6,6 +6,7 @@ import (
 
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
+	"github.com/flynn/flynn/host/resource"
 	"github.com/flynn/flynn/pkg/typeconv"
 )
 
@@ -97,6 +98,36 @@ type Job struct {
 
 	// hostError is the error from the host if the job fails to start
 	hostError *string
+
+	// PendingReason is set when the job cannot be placed in the cluster
+	// (e.g. if no hosts have enough free capacity for its resource
+	// requests), and is cleared once it has been placed
+	PendingReason string `json:"pending_reason,omitempty"`
+
+	// resources are the cluster job's resources, assigned whenever a host
+	// event is received for the job, and are used in preference to the
+	// process type's resources when calculating the job's requests
+	resources resource.Resources
+
+	// migrating is set when the job is being moved off a draining host,
+	// and excludes the job from its formation's process counts so that a
+	// replacement is started on another host
+	migrating bool
+
+	// replaces is the migrating job which this job was started to replace,
+	// and which is stopped once this job is up (see Scheduler.drainHost)
+	replaces *Job
+
+	// volumeSource is set on a job replacing a migrating data job, and is
+	// where the data job's volumes are migrated from before this job is
+	// started (see migrateVolumes)
+	volumeSource *volumeSource
+
+	// registered is set once a replacement job has registered with its
+	// process type's service, and checkingService while waiting for it to
+	// do so
+	registered      bool
+	checkingService bool
 }
 
 // Tags returns the tags for the job's process type from the formation
@@ -115,6 +146,45 @@ func (j *Job) TagsMatchHost(host *Host) bool {
 	return true
 }
 
+// Requests returns the amount of each placement resource the job requests,
+// either from the cluster job if it has been seen on a host, or from the
+// corresponding process type in the release
+func (j *Job) Requests() resource.Capacity {
+	res := j.resources
+	if res == nil && j.Formation != nil {
+		res = j.Formation.Release.Processes[j.Type].Resources
+	}
+	requests := make(resource.Capacity, len(placementResources))
+	for _, typ := range placementResources {
+		if spec, ok := res[typ]; ok && spec.Request != nil {
+			requests[typ] = *spec.Request
+		}
+	}
+	return requests
+}
+
+// Placement returns the placement constraints of the job's process type
+func (j *Job) Placement() []ct.PlacementConstraint {
+	if j.Formation == nil {
+		return nil
+	}
+	return j.Formation.Release.Processes[j.Type].Placement
+}
+
+// Service returns the discoverd service the job's process type registers
+// with, if any
+func (j *Job) Service() string {
+	if j.Formation == nil {
+		return ""
+	}
+	return j.Formation.Release.Processes[j.Type].Service
+}
+
+// IsOmni returns whether the job's process type runs on every host
+func (j *Job) IsOmni() bool {
+	return j.Formation != nil && j.Formation.Release.Processes[j.Type].Omni
+}
+
 // needsVolume indicates whether a volume should be provisioned in the cluster
 // for the job, determined from the corresponding process type in the release
 func (j *Job) needsVolume() bool {
@@ -154,6 +224,10 @@ func (j *Job) ControllerJob() *ct.Job {
 		RunAt:     j.RunAt,
 	}
 
+	if j.PendingReason != "" {
+		job.PendingReason = typeconv.StringPtr(j.PendingReason)
+	}
+
 	switch j.State {
 	case JobStatePending:
 		job.State = ct.JobStatePending
@@ -210,16 +284,47 @@ func (j Jobs) GetHostJobCounts(key utils.FormationKey, typ string) map[string]in
 	return counts
 }
 
+// GetHostReserved returns the total requests of jobs which have been placed
+// on each host and have not yet stopped
+func (j Jobs) GetHostReserved() map[string]resource.Capacity {
+	reserved := make(map[string]resource.Capacity)
+	for _, job := range j {
+		if job.HostID == "" || job.State == JobStateStopped {
+			continue
+		}
+		r, ok := reserved[job.HostID]
+		if !ok {
+			r = make(resource.Capacity, len(placementResources))
+			reserved[job.HostID] = r
+		}
+		for typ, n := range job.Requests() {
+			r[typ] += n
+		}
+	}
+	return reserved
+}
+
 func (js Jobs) GetProcesses(key utils.FormationKey) Processes {
 	procs := make(Processes)
 	for _, j := range js {
-		if j.IsInFormation(key) {
+		if j.IsInFormation(key) && !j.migrating {
 			procs[j.Type]++
 		}
 	}
 	return procs
 }
 
+// replacementFor returns the job which is replacing the given migrating job,
+// or nil if there is no active replacement
+func (js Jobs) replacementFor(job *Job) *Job {
+	for _, j := range js {
+		if j.replaces == job && !j.IsStopped() {
+			return j
+		}
+	}
+	return nil
+}
+
 func (js Jobs) Add(j *Job) *Job {
 	js[j.ID] = j
 	return j
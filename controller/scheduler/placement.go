@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/resource"
+)
+
+// PlacementPolicy determines which host a job is placed on out of those which
+// match the job's tags and have enough free capacity for its requests
+type PlacementPolicy string
+
+const (
+	// PlacementPolicySpread places a job on the host running the fewest
+	// jobs of the same type, spreading jobs across the cluster (this is
+	// the default)
+	PlacementPolicySpread PlacementPolicy = "spread"
+
+	// PlacementPolicyBinpack places a job on the host with the least free
+	// capacity, packing jobs onto as few hosts as possible
+	PlacementPolicyBinpack PlacementPolicy = "binpack"
+)
+
+// ParsePlacementPolicy parses a placement policy, returning the default
+// policy for an empty string
+func ParsePlacementPolicy(s string) (PlacementPolicy, error) {
+	switch p := PlacementPolicy(s); p {
+	case "":
+		return PlacementPolicySpread, nil
+	case PlacementPolicySpread, PlacementPolicyBinpack:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown placement policy %q", s)
+	}
+}
+
+// placementResources are the resources which jobs request from a host's
+// capacity. max_fd and max_procs are left out as their requests and limits are
+// per-job rlimits rather than amounts drawn from a pool shared by the host's
+// jobs, so there is no host capacity to reserve them from
+var placementResources = []resource.Type{resource.TypeMemory, resource.TypeCPU}
+
+// insufficientResources returns the resources which the host does not have
+// enough free capacity of to satisfy the given requests
+func (h *Host) insufficientResources(requests, reserved resource.Capacity) []resource.Type {
+	var insufficient []resource.Type
+	for _, typ := range placementResources {
+		capacity, ok := h.Capacity[typ]
+		if !ok {
+			continue
+		}
+		if reserved[typ]+requests[typ] > capacity {
+			insufficient = append(insufficient, typ)
+		}
+	}
+	return insufficient
+}
+
+// freeRatio returns the average fraction of the host's capacity which would
+// be free once the given requests are reserved, treating resources the host
+// does not report the capacity of as entirely free
+func (h *Host) freeRatio(requests, reserved resource.Capacity) float64 {
+	var total float64
+	for _, typ := range placementResources {
+		capacity, ok := h.Capacity[typ]
+		if !ok || capacity <= 0 {
+			total += 1
+			continue
+		}
+		total += float64(capacity-reserved[typ]-requests[typ]) / float64(capacity)
+	}
+	return total / float64(len(placementResources))
+}
+
+// pickHost picks a host for the job from the given hosts, which all match the
+// job's tags and have enough free capacity for it, using the given policy
+func pickHost(policy PlacementPolicy, job *Job, hosts []*Host, counts map[string]int, reserved map[string]resource.Capacity) *Host {
+	var picked *Host
+	switch policy {
+	case PlacementPolicyBinpack:
+		minFree := math.Inf(1)
+		for _, h := range hosts {
+			if free := h.freeRatio(job.Requests(), reserved[h.ID]); free < minFree {
+				minFree = free
+				picked = h
+			}
+		}
+	default:
+		minCount := math.MaxInt32
+		for _, h := range hosts {
+			count, ok := counts[h.ID]
+			if !ok || count == 0 {
+				return h
+			}
+			if count < minCount {
+				minCount = count
+				picked = h
+			}
+		}
+	}
+	return picked
+}
+
+// capacityPendingReason returns the reason a job with the given requests is
+// pending when no hosts have enough free capacity of the given resources
+func capacityPendingReason(requests resource.Capacity, insufficient map[resource.Type]struct{}) string {
+	types := make([]string, 0, len(insufficient))
+	reqs := make([]string, 0, len(requests))
+	for _, typ := range placementResources {
+		if _, ok := insufficient[typ]; ok {
+			types = append(types, string(typ))
+		}
+		if n, ok := requests[typ]; ok {
+			reqs = append(reqs, fmt.Sprintf("%s=%s", typ, resource.FormatLimit(typ, n)))
+		}
+	}
+	return fmt.Sprintf("no hosts have enough free %s for job requests (%s)", strings.Join(types, " or "), strings.Join(reqs, ", "))
+}
+
+// constraintDomain returns the domain the host belongs to for the given
+// constraint, which is either the host itself or the value of the
+// constraint's host tag (with hosts missing the tag sharing a domain)
+func constraintDomain(c ct.PlacementConstraint, h *Host) string {
+	if c.HostTag == "" {
+		return h.ID
+	}
+	return h.Tags[c.HostTag]
+}
+
+// isConstraintTarget returns whether other is a placed, active job which is
+// targeted by the given constraint of job
+func isConstraintTarget(job *Job, c ct.PlacementConstraint, other *Job) bool {
+	if other.ID == job.ID || other.HostID == "" || other.IsStopped() {
+		return false
+	}
+	typ := c.ProcessType
+	if typ == "" {
+		typ = job.Type
+	}
+	if other.Type != typ || other.Formation == nil {
+		return false
+	}
+	if c.App == "" {
+		return job.Formation != nil && other.Formation.key() == job.Formation.key()
+	}
+	return other.AppID == c.App || other.Formation.App.Name == c.App
+}
+
+// constraintCounts returns the number of jobs targeted by each of the job's
+// placement constraints in each domain
+func (s *Scheduler) constraintCounts(job *Job) []map[string]int {
+	constraints := job.Placement()
+	counts := make([]map[string]int, len(constraints))
+	for i, c := range constraints {
+		counts[i] = make(map[string]int)
+		for _, other := range s.jobs {
+			if !isConstraintTarget(job, c, other) {
+				continue
+			}
+			if host, ok := s.hosts[other.HostID]; ok {
+				counts[i][constraintDomain(c, host)]++
+			}
+		}
+	}
+	return counts
+}
+
+// violatedConstraints returns the indexes of the job's affinity and
+// anti-affinity constraints which placing the job on the host would violate
+func violatedConstraints(job *Job, counts []map[string]int, h *Host) []int {
+	var violated []int
+	for i, c := range job.Placement() {
+		count := counts[i][constraintDomain(c, h)]
+		switch c.Type {
+		case ct.PlacementAffinity:
+			if count == 0 {
+				violated = append(violated, i)
+			}
+		case ct.PlacementAntiAffinity:
+			if count > 0 {
+				violated = append(violated, i)
+			}
+		}
+	}
+	return violated
+}
+
+// spreadHosts filters the given hosts down to those in the domains with the
+// fewest targeted jobs for each of the job's spread constraints
+func spreadHosts(job *Job, counts []map[string]int, hosts []*Host) []*Host {
+	for i, c := range job.Placement() {
+		if c.Type != ct.PlacementSpread {
+			continue
+		}
+		min := math.MaxInt32
+		for _, h := range hosts {
+			if count := counts[i][constraintDomain(c, h)]; count < min {
+				min = count
+			}
+		}
+		filtered := make([]*Host, 0, len(hosts))
+		for _, h := range hosts {
+			if counts[i][constraintDomain(c, h)] == min {
+				filtered = append(filtered, h)
+			}
+		}
+		hosts = filtered
+	}
+	return hosts
+}
+
+// constraintPendingReason returns the reason a job is pending when placing
+// it on any host would violate the given constraints
+func constraintPendingReason(job *Job, violated map[int]struct{}) string {
+	descs := make([]string, 0, len(violated))
+	for i, c := range job.Placement() {
+		if _, ok := violated[i]; !ok {
+			continue
+		}
+		target := c.ProcessType
+		if target == "" {
+			target = job.Type
+		}
+		target += " jobs"
+		if c.App != "" {
+			target += " of app " + c.App
+		}
+		domain := "host"
+		if c.HostTag != "" {
+			domain = fmt.Sprintf("value of host tag %q", c.HostTag)
+		}
+		descs = append(descs, fmt.Sprintf("%s with %s per %s", c.Type, target, domain))
+	}
+	return fmt.Sprintf("no hosts satisfy the job's placement constraints (%s)", strings.Join(descs, "; "))
+}
+
+// stopPriority returns how strongly a running job should be preferred when
+// picking one to stop, which is the number of affinity and anti-affinity
+// constraints it currently violates, followed by the number of jobs it shares
+// a domain with for anti-affinity and spread constraints
+func (s *Scheduler) stopPriority(job *Job) (violations int, shared int) {
+	host, ok := s.hosts[job.HostID]
+	if !ok {
+		return 0, 0
+	}
+	counts := s.constraintCounts(job)
+	violations = len(violatedConstraints(job, counts, host))
+	for i, c := range job.Placement() {
+		if c.Type == ct.PlacementAntiAffinity || c.Type == ct.PlacementSpread {
+			shared += counts[i][constraintDomain(c, host)]
+		}
+	}
+	return
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
 	"net/http"
 	"os"
 	"sync"
@@ -14,6 +13,7 @@ import (
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
 	discoverd "github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/host/resource"
 	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/attempt"
 	"github.com/flynn/flynn/pkg/cluster"
@@ -36,6 +36,10 @@ var (
 	ErrNoHosts          = errors.New("no hosts found")
 	ErrJobNotPending    = errors.New("job is no longer pending")
 	ErrNoHostsMatchTags = errors.New("no hosts found matching job tags")
+
+	ErrNoHostsWithCapacity = errors.New("no hosts found with enough free capacity for job")
+
+	ErrNoHostsMatchConstraints = errors.New("no hosts found satisfying job placement constraints")
 )
 
 type Scheduler struct {
@@ -49,6 +53,8 @@ type Scheduler struct {
 
 	maxHostChecks int
 
+	placementPolicy PlacementPolicy
+
 	formations Formations
 	hosts      map[string]*Host
 	jobs       Jobs
@@ -68,6 +74,7 @@ type Scheduler struct {
 	putJobs               chan *ct.Job
 	placementRequests     chan *PlacementRequest
 	internalStateRequests chan *InternalStateRequest
+	serviceChecks         chan *serviceCheck
 
 	rectifyBatch map[utils.FormationKey]struct{}
 
@@ -83,6 +90,12 @@ type Scheduler struct {
 	// jobs when host tags change
 	pendingTagJobs map[string]*Job
 
+	// pendingPlacementJobs is a map of jobs which are currently pending
+	// due to either no hosts having enough free capacity for their
+	// requests or satisfying their placement constraints, and is used to
+	// try and place the jobs when other jobs start or stop
+	pendingPlacementJobs map[string]*Job
+
 	// pause and resume are used by tests to control the main loop
 	pause  chan struct{}
 	resume chan struct{}
@@ -115,8 +128,10 @@ func NewScheduler(cluster utils.ClusterClient, cc utils.ControllerClient, disc D
 		putJobs:               make(chan *ct.Job, eventBufferSize),
 		placementRequests:     make(chan *PlacementRequest, eventBufferSize),
 		internalStateRequests: make(chan *InternalStateRequest, eventBufferSize),
+		serviceChecks:         make(chan *serviceCheck, eventBufferSize),
 		formationlessJobs:     make(map[utils.FormationKey]map[string]*Job),
 		pendingTagJobs:        make(map[string]*Job),
+		pendingPlacementJobs:  make(map[string]*Job),
 		pause:                 make(chan struct{}),
 		resume:                make(chan struct{}),
 		generateJobUUID:       random.UUID,
@@ -143,8 +158,15 @@ func main() {
 		shutdown.Fatal(err)
 	}
 
+	policy, err := ParsePlacementPolicy(os.Getenv("SCHEDULER_PLACEMENT_POLICY"))
+	if err != nil {
+		log.Error("error parsing placement policy", "err", err)
+		shutdown.Fatal(err)
+	}
+
 	s := NewScheduler(clusterClient, controllerClient, newDiscoverdWrapper(logger), logger)
-	log.Info("started scheduler")
+	s.placementPolicy = policy
+	log.Info("started scheduler", "placement_policy", policy)
 
 	go s.startHTTPServer(os.Getenv("PORT"))
 
@@ -332,6 +354,9 @@ func (s *Scheduler) Run() error {
 		case e := <-s.jobEvents:
 			s.HandleJobEvent(e)
 			continue
+		case check := <-s.serviceChecks:
+			s.HandleServiceCheck(check)
+			continue
 		case f := <-s.formationEvents:
 			s.HandleFormationChange(f)
 			continue
@@ -373,6 +398,8 @@ func (s *Scheduler) Run() error {
 			s.PerformHostChecks()
 		case e := <-s.jobEvents:
 			s.HandleJobEvent(e)
+		case check := <-s.serviceChecks:
+			s.HandleServiceCheck(check)
 		case f := <-s.formationEvents:
 			s.HandleFormationChange(f)
 		case <-s.syncFormations:
@@ -415,6 +442,10 @@ func (s *Scheduler) SyncJobs() (err error) {
 		}
 	}
 
+	// continue moving jobs off draining hosts in case doing so was
+	// interrupted (e.g. by a change of leader)
+	s.drainHosts()
+
 	// ensure that all pending / starting / up jobs in the controller are
 	// still in those states
 	jobs, err := s.JobListActive()
@@ -499,10 +530,14 @@ func (s *Scheduler) SyncHosts() (err error) {
 	for _, host := range hosts {
 		known[host.ID()] = struct{}{}
 
+		_, followed := s.hosts[host.ID()]
 		h, err := s.followHost(host)
 		if err == nil {
 			// make sure no jobs are pending which needn't be
 			s.maybeStartPendingTagJobs(h)
+			if !followed {
+				s.maybeStartPendingPlacementJobs()
+			}
 		} else {
 			log.Error("error following host", "host.id", host.ID(), "err", err)
 			// finish the sync before returning the error
@@ -644,6 +679,17 @@ func (s *Scheduler) maybeStartPendingTagJobs(host *Host) {
 	}
 }
 
+// maybeStartPendingPlacementJobs tries to start any jobs which are pending due
+// to no hosts having enough free capacity or satisfying their placement
+// constraints, and is expected to be called when that may have changed (i.e.
+// a job has started or stopped or a host has been added)
+func (s *Scheduler) maybeStartPendingPlacementJobs() {
+	for id, job := range s.pendingPlacementJobs {
+		delete(s.pendingPlacementJobs, id)
+		go s.StartJob(job)
+	}
+}
+
 func (s *Scheduler) formationDiff(formation *Formation) Processes {
 	if formation == nil {
 		return nil
@@ -691,48 +737,103 @@ func (s *Scheduler) HandlePlacementRequest(req *PlacementRequest) {
 	// start
 	req.Job.HostID = ""
 
+	// find the hosts which match the job's tags, satisfy its placement
+	// constraints and have enough free capacity for its requests
 	formation := req.Job.Formation
-	counts := s.jobs.GetHostJobCounts(formation.key(), req.Job.Type)
-	var minCount int = math.MaxInt32
+	requests := req.Job.Requests()
+	reserved := s.jobs.GetHostReserved()
+	constraintCounts := s.constraintCounts(req.Job)
+	var matched, allowed bool
+	var candidates []*Host
+	violated := make(map[int]struct{})
+	insufficient := make(map[resource.Type]struct{})
 	for _, h := range s.ShuffledHosts() {
-		if h.Shutdown {
+		if h.Shutdown || h.Drain != nil {
 			continue
 		}
 		if !req.Job.TagsMatchHost(h) {
 			continue
 		}
-		count, ok := counts[h.ID]
-		if !ok || count == 0 {
-			req.Host = h
-			break
+		matched = true
+		if indexes := violatedConstraints(req.Job, constraintCounts, h); len(indexes) > 0 {
+			for _, i := range indexes {
+				violated[i] = struct{}{}
+			}
+			continue
 		}
-		if count < minCount {
-			minCount = count
-			req.Host = h
+		allowed = true
+		if types := h.insufficientResources(requests, reserved[h.ID]); len(types) > 0 {
+			for _, typ := range types {
+				insufficient[typ] = struct{}{}
+			}
+			continue
 		}
+		candidates = append(candidates, h)
 	}
 
-	// if we didn't pick a host, the job's tags don't match any hosts so
-	// add it to s.pendingTagJobs and return an error to cause the
-	// StartJob goroutine to stop trying to place the job
-	if req.Host == nil {
+	// if the job's tags don't match any hosts, add it to s.pendingTagJobs
+	// and return an error to cause the StartJob goroutine to stop trying
+	// to place the job
+	if !matched {
+		s.setPendingReason(req.Job, ErrNoHostsMatchTags.Error())
 		s.pendingTagJobs[req.Job.ID] = req.Job
 		req.Error(ErrNoHostsMatchTags)
 		return
 	}
 
-	if len(req.Job.Tags()) == 0 {
+	// if placing the job on any matching host would violate its placement
+	// constraints, add it to s.pendingPlacementJobs so it is placed once
+	// other jobs start or stop
+	if !allowed {
+		reason := constraintPendingReason(req.Job, violated)
+		log.Info("unable to place job", "reason", reason)
+		s.setPendingReason(req.Job, reason)
+		s.pendingPlacementJobs[req.Job.ID] = req.Job
+		req.Error(ErrNoHostsMatchConstraints)
+		return
+	}
+
+	// if no matching hosts have enough free capacity, add the job to
+	// s.pendingPlacementJobs so it is placed once capacity is freed up
+	if len(candidates) == 0 {
+		reason := capacityPendingReason(requests, insufficient)
+		log.Info("unable to place job", "reason", reason)
+		s.setPendingReason(req.Job, reason)
+		s.pendingPlacementJobs[req.Job.ID] = req.Job
+		req.Error(ErrNoHostsWithCapacity)
+		return
+	}
+
+	candidates = spreadHosts(req.Job, constraintCounts, candidates)
+	counts := s.jobs.GetHostJobCounts(formation.key(), req.Job.Type)
+	req.Host = pickHost(s.placementPolicy, req.Job, candidates, counts, reserved)
+
+	switch {
+	case s.placementPolicy == PlacementPolicyBinpack:
+		log.Info("placed job on host with least free capacity", "host.id", req.Host.ID)
+	case len(req.Job.Tags()) == 0:
 		log.Info(fmt.Sprintf("placed job on host with least %s jobs", req.Job.Type), "host.id", req.Host.ID)
-	} else {
+	default:
 		log.Info(fmt.Sprintf("placed job on host with matching tags and least %s jobs", req.Job.Type), "host.id", req.Host.ID, "host.tags", req.Host.Tags)
 	}
 
+	req.Job.PendingReason = ""
 	req.Config = jobConfig(req.Job, req.Host.ID)
 	req.Job.JobID = req.Config.ID
 	req.Job.HostID = req.Host.ID
 	req.Error(nil)
 }
 
+// setPendingReason sets the reason the job is pending, persisting the job if
+// the reason has changed so that it is visible in the controller
+func (s *Scheduler) setPendingReason(job *Job, reason string) {
+	if job.PendingReason == reason {
+		return
+	}
+	job.PendingReason = reason
+	s.persistJob(job)
+}
+
 type InternalState struct {
 	Hosts      map[string]*Host      `json:"hosts"`
 	Jobs       Jobs                  `json:"jobs"`
@@ -762,12 +863,22 @@ func (s *Scheduler) HandleInternalStateRequest(req *InternalStateRequest) {
 		IsLeader:   s.isLeader,
 	}
 
+	reserved := s.jobs.GetHostReserved()
 	for id, host := range s.hosts {
 		h := *host
 		h.Tags = make(map[string]string, len(host.Tags))
 		for key, val := range host.Tags {
 			h.Tags[key] = val
 		}
+		h.Capacity = make(resource.Capacity, len(host.Capacity))
+		for typ, n := range host.Capacity {
+			h.Capacity[typ] = n
+		}
+		h.Reserved = reserved[id]
+		if host.Drain != nil {
+			drain := *host.Drain
+			h.Drain = &drain
+		}
 		req.State.Hosts[id] = &h
 	}
 
@@ -807,9 +918,10 @@ func (s *Scheduler) HandleInternalStateRequest(req *InternalStateRequest) {
 		}
 		for name, proc := range formation.Release.Processes {
 			f.Release.Processes[name] = ct.ProcessType{
-				Args: proc.Args,
-				Data: proc.Data,
-				Omni: proc.Omni,
+				Args:      proc.Args,
+				Data:      proc.Data,
+				Omni:      proc.Omni,
+				Placement: proc.Placement,
 			}
 		}
 		req.State.Formations[key.String()] = &f
@@ -935,6 +1047,12 @@ func (s *Scheduler) StartJob(job *Job) {
 		} else if err == ErrNoHostsMatchTags {
 			log.Warn("unable to place job as tags don't match any hosts")
 			return
+		} else if err == ErrNoHostsWithCapacity {
+			log.Warn("unable to place job as no hosts have enough free capacity")
+			return
+		} else if err == ErrNoHostsMatchConstraints {
+			log.Warn("unable to place job as no hosts satisfy its placement constraints")
+			return
 		} else if err == ErrJobNotPending {
 			log.Warn("unable to place job as it is no longer pending")
 			return
@@ -943,7 +1061,13 @@ func (s *Scheduler) StartJob(job *Job) {
 			continue
 		}
 
-		if job.needsVolume() {
+		if job.needsVolume() && job.volumeSource != nil {
+			log.Info("migrating data volumes", "host.id", host.ID, "source.host.id", job.volumeSource.host.ID(), "source.job.id", job.volumeSource.jobID)
+			if err := migrateVolumes(job.volumeSource, host.client, config); err != nil {
+				log.Error("error migrating data volumes", "err", err)
+				continue
+			}
+		} else if job.needsVolume() {
 			log.Info("provisioning data volume", "host.id", host.ID)
 			if err := utils.ProvisionVolume(host.client, config); err != nil {
 				log.Error("error provisioning volume", "err", err)
@@ -988,7 +1112,14 @@ func (s *Scheduler) followHost(h utils.HostClient) (*Host, error) {
 		return host, nil
 	}
 
+	status, err := h.GetStatus()
+	if err != nil {
+		return nil, err
+	}
+
 	host := NewHost(h, s.logger)
+	host.Capacity = status.Capacity
+	host.Drain = status.Drain
 	jobs, err := host.StreamEventsTo(s.jobEvents)
 	if err != nil {
 		return nil, err
@@ -1082,6 +1213,12 @@ func (s *Scheduler) HandleHostEvent(e *discoverd.Event) {
 			s.rectifyAll()
 			s.maybeStartPendingTagJobs(host)
 		}
+
+		// if the host has started or stopped draining, either start
+		// moving its jobs to other hosts or cancel moving them
+		if drain := cluster.HostDrainFromMeta(e.Instance.Meta); !drainEqual(host.Drain, drain) {
+			s.setHostDrain(host, drain)
+		}
 	case discoverd.EventKindDown:
 		id := e.Instance.Meta["id"]
 		log = log.New("host.id", id)
@@ -1115,8 +1252,9 @@ func (s *Scheduler) handleNewHost(id string) {
 	}
 
 	// we have a new host which may now match the tags of some pending jobs
-	// so try to start them
+	// or have capacity for them so try to start them
 	s.maybeStartPendingTagJobs(host)
+	s.maybeStartPendingPlacementJobs()
 }
 
 // activeHostCount returns the number of active hosts (i.e. all hosts which
@@ -1219,6 +1357,7 @@ func (s *Scheduler) handleActiveJob(activeJob *host.ActiveJob) *Job {
 	job.metadata = hostJob.Metadata
 	job.exitStatus = activeJob.ExitStatus
 	job.hostError = activeJob.Error
+	job.resources = hostJob.Resources
 
 	s.handleJobStatus(job, activeJob.Status)
 
@@ -1243,10 +1382,20 @@ func (s *Scheduler) handleJobStatus(job *Job, status host.JobStatus) {
 		job.State = JobStateStopped
 	}
 
-	// if the job's state has changed, persist it to the controller
+	// if the job's state has changed, persist it to the controller, and
+	// once the job has been handled, continue moving jobs off draining
+	// hosts (which waits for jobs to start and stop)
 	if job.State != previousState {
 		log.Info("handling job status change", "from", previousState, "to", job.State)
 		s.persistJob(job)
+		defer s.drainHosts()
+	}
+
+	// if the job has just stopped on a host, it has freed up capacity for
+	// pending jobs, and whether it has started or stopped, pending jobs may
+	// now satisfy their placement constraints, so try to place them
+	if job.State != previousState && job.HostID != "" && (job.State == JobStateStarting || job.State == JobStateStopped) && s.IsLeader() {
+		s.maybeStartPendingPlacementJobs()
 	}
 
 	// ensure jobs started as part of a formation change have a known formation
@@ -1437,11 +1586,18 @@ func (s *Scheduler) stopJob(job *Job) error {
 }
 
 // findJobToStop finds a job from the given formation and type which should be
-// stopped, choosing pending jobs if present, and the most recently started job
-// otherwise
+// stopped, choosing pending jobs if present, then the running job which most
+// violates or crowds the type's placement constraints, and the most recently
+// started job otherwise
 func (s *Scheduler) findJobToStop(f *Formation, typ string) (*Job, error) {
 	var runningJob *Job
+	var maxViolations, maxShared int
 	for _, job := range s.jobs.WithFormationAndType(f, typ) {
+		// jobs being moved off draining hosts are not counted towards
+		// the formation and are stopped once replaced
+		if job.migrating {
+			continue
+		}
 		switch job.State {
 		case JobStatePending:
 			return job, nil
@@ -1456,11 +1612,16 @@ func (s *Scheduler) findJobToStop(f *Formation, typ string) (*Job, error) {
 				return job, nil
 			}
 
-			// return the most recent job (which is the first in
-			// the slice we are iterating over) if none of the
-			// above cases match
-			if runningJob == nil {
+			// prefer jobs which violate or share a domain with
+			// other jobs for the most placement constraints,
+			// otherwise return the most recent job (which is the
+			// first in the slice we are iterating over) if none of
+			// the above cases match
+			violations, shared := s.stopPriority(job)
+			if runningJob == nil || violations > maxViolations || violations == maxViolations && shared > maxShared {
 				runningJob = job
+				maxViolations = violations
+				maxShared = shared
 			}
 		}
 	}
@@ -1527,6 +1688,12 @@ func (s *Scheduler) restartJob(job *Job) {
 		State:     JobStatePending,
 		Restarts:  restarts + 1,
 	}
+
+	// if the job was replacing a job being moved off a draining host,
+	// its replacement takes over
+	if job.replaces != nil && job.replaces.migrating {
+		newJob.replaces = job.replaces
+	}
 	s.jobs.Add(newJob)
 
 	// persist the job so that it appears as pending in the database
@@ -6,9 +6,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/docker/go-units"
 	. "github.com/flynn/flynn/controller/testutils"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
+	"github.com/flynn/flynn/host/resource"
 	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/cluster"
 	"github.com/flynn/flynn/pkg/random"
@@ -52,6 +54,10 @@ func (d *fakeDiscoverd) LeaderCh() chan bool {
 	return d.leader
 }
 
+func (d *fakeDiscoverd) WaitForJob(service, jobID string, timeout time.Duration) error {
+	return nil
+}
+
 func (d *fakeDiscoverd) promote() {
 	d.leader <- true
 }
@@ -745,6 +751,480 @@ func (TestSuite) TestJobPlacementTags(c *C) {
 	}
 }
 
+func (TestSuite) TestJobPlacementPolicy(c *C) {
+	newScheduler := func(policy PlacementPolicy) *Scheduler {
+		return &Scheduler{
+			isLeader:        typeconv.BoolPtr(true),
+			placementPolicy: policy,
+			jobs:            make(Jobs),
+			hosts: map[string]*Host{
+				"host1": {ID: "host1", Capacity: resource.Capacity{resource.TypeMemory: 2 * units.GiB, resource.TypeCPU: 2000}},
+				"host2": {ID: "host2", Capacity: resource.Capacity{resource.TypeMemory: 4 * units.GiB, resource.TypeCPU: 4000}},
+			},
+			pendingPlacementJobs: make(map[string]*Job),
+			putJobs:              make(chan *ct.Job, eventBufferSize),
+			logger:               log15.New(),
+		}
+	}
+
+	// use a formation with process types requesting resources
+	formation := NewFormation(&ct.ExpandedFormation{
+		App: &ct.App{ID: "app"},
+		Release: &ct.Release{ID: "release", Processes: map[string]ct.ProcessType{
+			"web": {Resources: resource.Resources{
+				resource.TypeMemory: {Request: typeconv.Int64Ptr(1 * units.GiB)},
+				resource.TypeCPU:    {Request: typeconv.Int64Ptr(500)},
+			}},
+			"big": {Resources: resource.Resources{
+				resource.TypeMemory: {Request: typeconv.Int64Ptr(8 * units.GiB)},
+			}},
+		}},
+		ImageArtifact: &ct.Artifact{},
+	})
+
+	place := func(s *Scheduler, typ string, i int) (*Job, *Host, error) {
+		job := s.jobs.Add(&Job{ID: fmt.Sprintf("job-%s-%d", typ, i), Formation: formation, Type: typ, State: JobStatePending})
+		req := &PlacementRequest{Job: job, Err: make(chan error, 1)}
+		s.HandlePlacementRequest(req)
+		return job, req.Host, <-req.Err
+	}
+
+	for _, t := range []struct {
+		policy PlacementPolicy
+		hosts  []string
+	}{
+		// spread alternates jobs between the hosts until host1 is full
+		{
+			policy: PlacementPolicySpread,
+			hosts:  []string{"", "", "", "", "host2", "host2"},
+		},
+		// binpack fills host1 before placing jobs on host2
+		{
+			policy: PlacementPolicyBinpack,
+			hosts:  []string{"host1", "host1", "host2", "host2", "host2", "host2"},
+		},
+	} {
+		s := newScheduler(t.policy)
+		counts := make(map[string]int)
+		for i, expected := range t.hosts {
+			_, host, err := place(s, "web", i)
+			c.Assert(err, IsNil, Commentf("placing web job %d with %s policy", i, t.policy))
+			if expected != "" {
+				c.Assert(host.ID, Equals, expected, Commentf("placing web job %d with %s policy", i, t.policy))
+			}
+			counts[host.ID]++
+		}
+		c.Assert(counts, DeepEquals, map[string]int{"host1": 2, "host2": 4}, Commentf("%s policy", t.policy))
+		reserved := s.jobs.GetHostReserved()
+		c.Assert(reserved["host1"], DeepEquals, resource.Capacity{resource.TypeMemory: 2 * units.GiB, resource.TypeCPU: 1000})
+		c.Assert(reserved["host2"], DeepEquals, resource.Capacity{resource.TypeMemory: 4 * units.GiB, resource.TypeCPU: 2000})
+
+		// another job does not fit on either host so stays pending
+		// with a reason
+		job, host, err := place(s, "web", len(t.hosts))
+		c.Assert(err, Equals, ErrNoHostsWithCapacity)
+		c.Assert(host, IsNil)
+		c.Assert(job.HostID, Equals, "")
+		c.Assert(job.PendingReason, Equals, "no hosts have enough free memory for job requests (memory=1GB, cpu=500)")
+		c.Assert(s.pendingPlacementJobs[job.ID], Equals, job)
+		c.Assert(*job.ControllerJob().PendingReason, Equals, job.PendingReason)
+		c.Assert(<-s.putJobs, DeepEquals, job.ControllerJob())
+	}
+
+	// a job which requests more than any host's capacity stays pending
+	s := newScheduler(PlacementPolicySpread)
+	job, _, err := place(s, "big", 0)
+	c.Assert(err, Equals, ErrNoHostsWithCapacity)
+	c.Assert(job.PendingReason, Equals, "no hosts have enough free memory for job requests (memory=8GB)")
+
+	// hosts which don't report capacity have room for any job
+	s.hosts["host3"] = &Host{ID: "host3"}
+	job.State = JobStatePending
+	req := &PlacementRequest{Job: job, Err: make(chan error, 1)}
+	s.HandlePlacementRequest(req)
+	c.Assert(<-req.Err, IsNil)
+	c.Assert(req.Host.ID, Equals, "host3")
+	c.Assert(job.PendingReason, Equals, "")
+}
+
+func (TestSuite) TestJobPlacementDefaultResources(c *C) {
+	// releases get default resources when they are created, which request
+	// their limits (1GiB of memory and 1000 milliCPU)
+	resources := resource.Defaults()
+	formation := NewFormation(&ct.ExpandedFormation{
+		App: &ct.App{ID: "app"},
+		Release: &ct.Release{ID: "release", Processes: map[string]ct.ProcessType{
+			"web": {Resources: resources},
+		}},
+		ImageArtifact: &ct.Artifact{},
+	})
+
+	newScheduler := func(capacity resource.Capacity) *Scheduler {
+		return &Scheduler{
+			isLeader:             typeconv.BoolPtr(true),
+			jobs:                 make(Jobs),
+			hosts:                map[string]*Host{"host1": {ID: "host1", Capacity: capacity}},
+			pendingPlacementJobs: make(map[string]*Job),
+			putJobs:              make(chan *ct.Job, eventBufferSize),
+			logger:               log15.New(),
+		}
+	}
+	place := func(s *Scheduler, i int) (*Job, error) {
+		job := s.jobs.Add(&Job{ID: fmt.Sprintf("job-%d", i), Formation: formation, Type: "web", State: JobStatePending})
+		req := &PlacementRequest{Job: job, Err: make(chan error, 1)}
+		s.HandlePlacementRequest(req)
+		return job, <-req.Err
+	}
+
+	// hosts don't report capacity unless it is set with flynn-host's
+	// --memory-capacity and --cpu-capacity flags, so a host running with
+	// the default flags places jobs with default resources rather than
+	// leaving all but a few of them pending
+	s := newScheduler(nil)
+	for i := 0; i < 32; i++ {
+		job, err := place(s, i)
+		c.Assert(err, IsNil, Commentf("placing job %d", i))
+		c.Assert(job.HostID, Equals, "host1")
+		c.Assert(job.PendingReason, Equals, "")
+	}
+	c.Assert(s.pendingPlacementJobs, HasLen, 0)
+
+	// a host which opts in to capacity placement with only a memory
+	// capacity fits as many jobs as it has GiB of memory, not being
+	// limited by its CPU
+	s = newScheduler(resource.Capacity{resource.TypeMemory: 16 * units.GiB})
+	for i := 0; i < 16; i++ {
+		_, err := place(s, i)
+		c.Assert(err, IsNil, Commentf("placing job %d", i))
+	}
+	job, err := place(s, 16)
+	c.Assert(err, Equals, ErrNoHostsWithCapacity)
+	c.Assert(job.PendingReason, Equals, "no hosts have enough free memory for job requests (memory=1GB, cpu=1000)")
+}
+
+func (TestSuite) TestJobPlacementCapacity(c *C) {
+	hosts := newTestHosts()
+	hosts[testHostID].Capacity = resource.Capacity{resource.TypeMemory: 2 * units.GiB}
+	fakeCluster := newTestCluster(hosts)
+	s := runTestScheduler(c, fakeCluster, true)
+	defer s.Stop()
+	s.waitJobStart()
+
+	// create a formation with three jobs which each request 1GiB of
+	// memory, only two of which fit on the host
+	app := &ct.App{ID: "capacity-app", Name: "capacity-app"}
+	artifact := &ct.Artifact{ID: random.UUID()}
+	processes := map[string]int{"web": 3}
+	release := NewRelease("capacity-release", artifact, processes)
+	release.Processes["web"] = ct.ProcessType{
+		Args: []string{"start", "web"},
+		Resources: resource.Resources{
+			resource.TypeMemory: {Request: typeconv.Int64Ptr(1 * units.GiB)},
+		},
+	}
+	s.CreateApp(app)
+	s.CreateArtifact(artifact)
+	s.CreateRelease(release)
+	s.PutFormation(&ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: processes})
+	_, err := s.waitForEvent("unable to place job")
+	c.Assert(err, IsNil)
+
+	state := s.InternalState()
+	c.Assert(state.Hosts[testHostID].Capacity, DeepEquals, resource.Capacity{resource.TypeMemory: 2 * units.GiB})
+	c.Assert(state.Hosts[testHostID].Reserved, DeepEquals, resource.Capacity{resource.TypeMemory: 2 * units.GiB})
+	var pending *Job
+	for _, job := range state.Jobs {
+		if job.AppID != app.ID {
+			continue
+		}
+		if job.HostID == "" {
+			c.Assert(pending, IsNil)
+			pending = job
+		}
+	}
+	c.Assert(pending, NotNil)
+	c.Assert(pending.State, Equals, JobStatePending)
+	c.Assert(pending.PendingReason, Equals, "no hosts have enough free memory for job requests (memory=1GB)")
+
+	// adding a host with enough capacity starts the pending job
+	host2 := NewFakeHostClient("host2", false)
+	host2.Capacity = resource.Capacity{resource.TypeMemory: 1 * units.GiB}
+	fakeCluster.AddHost(host2)
+	for {
+		job := s.waitJobStart()
+		if job.ID == pending.ID {
+			c.Assert(job.HostID, Equals, "host2")
+			c.Assert(job.PendingReason, Equals, "")
+			break
+		}
+	}
+}
+
+func (TestSuite) TestJobPlacementConstraints(c *C) {
+	newScheduler := func() *Scheduler {
+		return &Scheduler{
+			isLeader: typeconv.BoolPtr(true),
+			jobs:     make(Jobs),
+			hosts: map[string]*Host{
+				"host1": {ID: "host1", Tags: map[string]string{"zone": "a"}},
+				"host2": {ID: "host2", Tags: map[string]string{"zone": "a"}},
+				"host3": {ID: "host3", Tags: map[string]string{"zone": "b"}},
+			},
+			pendingPlacementJobs: make(map[string]*Job),
+			putJobs:              make(chan *ct.Job, eventBufferSize),
+			logger:               log15.New(),
+		}
+	}
+
+	formation := NewFormation(&ct.ExpandedFormation{
+		App: &ct.App{ID: "app"},
+		Release: &ct.Release{ID: "release", Processes: map[string]ct.ProcessType{
+			"web":    {Placement: []ct.PlacementConstraint{{Type: ct.PlacementAntiAffinity}}},
+			"worker": {Placement: []ct.PlacementConstraint{{Type: ct.PlacementSpread, HostTag: "zone"}}},
+			"cache":  {Placement: []ct.PlacementConstraint{{Type: ct.PlacementAffinity, App: "db-app", ProcessType: "db"}}},
+		}},
+		ImageArtifact: &ct.Artifact{},
+	})
+	dbFormation := NewFormation(&ct.ExpandedFormation{
+		App:           &ct.App{ID: "db-app-id", Name: "db-app"},
+		Release:       &ct.Release{ID: "db-release", Processes: map[string]ct.ProcessType{"db": {}}},
+		ImageArtifact: &ct.Artifact{},
+	})
+
+	place := func(s *Scheduler, typ string, i int) (*Job, *Host, error) {
+		job := s.jobs.Add(&Job{ID: fmt.Sprintf("job-%s-%d", typ, i), Formation: formation, Type: typ, State: JobStatePending})
+		req := &PlacementRequest{Job: job, Err: make(chan error, 1)}
+		s.HandlePlacementRequest(req)
+		return job, req.Host, <-req.Err
+	}
+
+	// anti-affinity places web jobs on separate hosts, and leaves jobs
+	// pending once every host has one
+	s := newScheduler()
+	hosts := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		_, host, err := place(s, "web", i)
+		c.Assert(err, IsNil)
+		hosts[host.ID]++
+	}
+	c.Assert(hosts, DeepEquals, map[string]int{"host1": 1, "host2": 1, "host3": 1})
+	job, _, err := place(s, "web", 3)
+	c.Assert(err, Equals, ErrNoHostsMatchConstraints)
+	c.Assert(job.PendingReason, Equals, "no hosts satisfy the job's placement constraints (anti-affinity with web jobs per host)")
+	c.Assert(s.pendingPlacementJobs[job.ID], Equals, job)
+
+	// stopped jobs don't count towards the constraint
+	s.jobs["job-web-0"].State = JobStateStopped
+	job.State = JobStatePending
+	req := &PlacementRequest{Job: job, Err: make(chan error, 1)}
+	s.HandlePlacementRequest(req)
+	c.Assert(<-req.Err, IsNil)
+	c.Assert(req.Host.ID, Equals, s.jobs["job-web-0"].HostID)
+
+	// spread places worker jobs evenly across zones
+	s = newScheduler()
+	zones := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		_, host, err := place(s, "worker", i)
+		c.Assert(err, IsNil)
+		zones[host.Tags["zone"]]++
+		c.Assert(zones["a"]-zones["b"] <= 1 && zones["b"]-zones["a"] <= 1, Equals, true, Commentf("placing worker job %d", i))
+	}
+	c.Assert(zones, DeepEquals, map[string]int{"a": 3, "b": 3})
+
+	// affinity places cache jobs on the host running a db job of db-app,
+	// and leaves them pending if there aren't any
+	s = newScheduler()
+	job, _, err = place(s, "cache", 0)
+	c.Assert(err, Equals, ErrNoHostsMatchConstraints)
+	c.Assert(job.PendingReason, Equals, "no hosts satisfy the job's placement constraints (affinity with db jobs of app db-app per host)")
+	s.jobs.Add(&Job{ID: "db-job", Formation: dbFormation, AppID: "db-app-id", Type: "db", HostID: "host2", State: JobStateRunning})
+	for i := 1; i < 4; i++ {
+		_, host, err := place(s, "cache", i)
+		c.Assert(err, IsNil)
+		c.Assert(host.ID, Equals, "host2")
+	}
+}
+
+func (TestSuite) TestFindJobToStopConstraints(c *C) {
+	s := &Scheduler{
+		jobs: make(Jobs),
+		hosts: map[string]*Host{
+			"host1": {ID: "host1", Tags: map[string]string{"zone": "a"}},
+			"host2": {ID: "host2", Tags: map[string]string{"zone": "a"}},
+			"host3": {ID: "host3", Tags: map[string]string{"zone": "b"}},
+		},
+	}
+	formation := NewFormation(&ct.ExpandedFormation{
+		App: &ct.App{ID: "app"},
+		Release: &ct.Release{ID: "release", Processes: map[string]ct.ProcessType{
+			"worker": {Placement: []ct.PlacementConstraint{{Type: ct.PlacementSpread, HostTag: "zone"}}},
+			"web":    {},
+		}},
+	})
+	now := time.Now()
+	for i, hostID := range []string{"host1", "host2", "host3"} {
+		for _, typ := range []string{"worker", "web"} {
+			s.jobs.Add(&Job{
+				ID:        fmt.Sprintf("job-%s-%d", typ, i),
+				Type:      typ,
+				Formation: formation,
+				HostID:    hostID,
+				State:     JobStateRunning,
+				StartedAt: now.Add(time.Duration(i) * time.Second),
+			})
+		}
+	}
+
+	// the most recent worker job is in the less crowded zone, so the
+	// most recent job in the other zone is stopped
+	job, err := s.findJobToStop(formation, "worker")
+	c.Assert(err, IsNil)
+	c.Assert(job.ID, Equals, "job-worker-1")
+
+	// without constraints, the most recent job is stopped
+	job, err = s.findJobToStop(formation, "web")
+	c.Assert(err, IsNil)
+	c.Assert(job.ID, Equals, "job-web-2")
+}
+
+func (TestSuite) TestDrainHost(c *C) {
+	hosts := newTestHosts()
+	host1 := hosts[testHostID]
+	fakeCluster := newTestCluster(hosts)
+	s := newTestScheduler(c, fakeCluster, true)
+	go s.Run()
+	defer s.Stop()
+	s.waitJobStart()
+
+	// start web jobs with a service, a db job with a data volume and an
+	// omni job on host1
+	app := &ct.App{ID: "drain-app", Name: "drain-app"}
+	artifact := &ct.Artifact{ID: random.UUID()}
+	processes := map[string]int{"web": 2, "db": 1, "omni": 1}
+	release := &ct.Release{
+		ID:          "drain-release",
+		ArtifactIDs: []string{artifact.ID},
+		Processes: map[string]ct.ProcessType{
+			"web":  {Args: []string{"start", "web"}, Service: "drain-app-web"},
+			"db":   {Args: []string{"start", "db"}, Data: true},
+			"omni": {Args: []string{"start", "omni"}, Omni: true},
+		},
+	}
+	s.CreateApp(app)
+	s.CreateArtifact(artifact)
+	s.CreateRelease(release)
+	s.PutFormation(&ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: processes})
+	for i := 0; i < 4; i++ {
+		s.waitJobStart()
+	}
+
+	// add a second host, which starts an omni job
+	host2 := NewFakeHostClient("host2", false)
+	fakeCluster.AddHost(host2)
+	job := s.waitJobStart()
+	c.Assert(job.HostID, Equals, "host2")
+	c.Assert(job.Type, Equals, "omni")
+
+	// migrate checks that draining host1 moves a job to host2, stopping
+	// the original once the replacement is running
+	migrate := func() *Job {
+		job := s.waitJobStart()
+		c.Assert(job.HostID, Equals, "host2")
+		c.Assert(host2.RunJob(job.ID), IsNil)
+		stopped := s.waitJobStop()
+		c.Assert(stopped.HostID, Equals, testHostID)
+		c.Assert(stopped.Type, Equals, job.Type)
+		return stopped
+	}
+
+	// draining host1 moves the web jobs one at a time, but leaves the db
+	// job running
+	host1.SetDrain(&host.DrainStatus{})
+	for i := 0; i < 3; i++ {
+		c.Assert(migrate().Type, Equals, "web")
+	}
+	_, err := s.waitDurationForEvent("host drained", 500*time.Millisecond)
+	c.Assert(err, NotNil)
+	c.Assert(host1.IsDrained(), Equals, false)
+
+	// draining data jobs moves the db job along with its volume, which is
+	// pulled by host2 whilst the job is running and again once it has been
+	// stopped, before the replacement is started
+	findDB := func(h *FakeHostClient) *host.ActiveJob {
+		jobs, err := h.ListJobs()
+		c.Assert(err, IsNil)
+		for _, job := range jobs {
+			if job.Job.Metadata["flynn-controller.type"] == "db" {
+				return &job
+			}
+		}
+		return nil
+	}
+	db := findDB(host1)
+	c.Assert(db, NotNil)
+	c.Assert(db.Job.Config.Volumes, HasLen, 1)
+	host1.SetDrain(&host.DrainStatus{Data: true})
+	var replacement *host.ActiveJob
+	for start := time.Now(); replacement == nil && time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		replacement = findDB(host2)
+	}
+	c.Assert(replacement, NotNil)
+	c.Assert(host1.IsStopped(db.Job.ID), Equals, true)
+	pulls := host2.VolumePulls()
+	c.Assert(pulls, HasLen, 2)
+	for i, pull := range pulls {
+		c.Assert(pull.SourceHostID, Equals, testHostID)
+		c.Assert(pull.SourceVolumeID, Equals, db.Job.Config.Volumes[0].VolumeID)
+		c.Assert(pull.VolumeID, Equals, replacement.Job.Config.Volumes[0].VolumeID)
+		sourceRunning := false
+		for _, id := range pull.SourceJobs {
+			if id == db.Job.ID {
+				sourceRunning = true
+			}
+		}
+		c.Assert(sourceRunning, Equals, i == 0)
+	}
+	c.Assert(replacement.Job.Config.Volumes[0].Target, Equals, "/data")
+	uuid, err := cluster.ExtractUUID(replacement.Job.ID)
+	c.Assert(err, IsNil)
+	waitReplacement := func() {
+		for job := s.waitJobStart(); job.ID != uuid; job = s.waitJobStart() {
+		}
+	}
+	waitReplacement()
+	c.Assert(host2.RunJob(uuid), IsNil)
+	waitReplacement()
+
+	// the host is drained once only the omni job is left
+	for start := time.Now(); !host1.IsDrained() && time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+	}
+	c.Assert(host1.IsDrained(), Equals, true)
+	jobs, err := host1.ListJobs()
+	c.Assert(err, IsNil)
+	c.Assert(jobs, HasLen, 1)
+	for _, job := range jobs {
+		c.Assert(job.Job.Metadata["flynn-controller.type"], Equals, "omni")
+	}
+	state := s.InternalState()
+	c.Assert(state.Hosts[testHostID].Drain, DeepEquals, &host.DrainStatus{Data: true, Drained: true})
+
+	// jobs are not placed on the drained host
+	processes["web"] = 3
+	s.PutFormation(&ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: processes})
+	job = s.waitJobStart()
+	c.Assert(job.HostID, Equals, "host2")
+
+	// but are once it is no longer draining
+	host1.SetDrain(nil)
+	for start := time.Now(); s.InternalState().Hosts[testHostID].Drain != nil && time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+	}
+	processes["web"] = 4
+	s.PutFormation(&ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: processes})
+	job = s.waitJobStart()
+	c.Assert(job.HostID, Equals, testHostID)
+}
+
 func (TestSuite) TestScaleCriticalApp(c *C) {
 	s := runTestScheduler(c, nil, true)
 	defer s.Stop()
@@ -389,6 +389,133 @@ $$ LANGUAGE plpgsql`,
 	migrations.AddSteps(19,
 		migrateProcessArgs,
 	)
+	migrations.Add(20,
+		`INSERT INTO deployment_strategies (name) VALUES ('canary')`,
+		`ALTER TABLE apps ADD COLUMN deploy_options jsonb`,
+		`ALTER TABLE deployments ADD COLUMN deploy_options jsonb`,
+	)
+	migrations.Add(21,
+		`INSERT INTO deployment_strategies (name) VALUES ('blue-green')`,
+	)
+	migrations.Add(22,
+		`CREATE TABLE schedule_concurrency_policies (name text PRIMARY KEY)`,
+		`INSERT INTO schedule_concurrency_policies (name) VALUES
+			('allow'), ('forbid'), ('replace')`,
+		`CREATE TABLE schedules (
+			schedule_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			app_id uuid NOT NULL REFERENCES apps (app_id),
+			cron text NOT NULL,
+			process_type text,
+			args jsonb,
+			env jsonb,
+			concurrency_policy text NOT NULL REFERENCES schedule_concurrency_policies (name),
+			next_run_at timestamptz NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now(),
+			deleted_at timestamptz
+		)`,
+		`CREATE INDEX ON schedules (app_id) WHERE deleted_at IS NULL`,
+		`INSERT INTO event_types (name) VALUES ('schedule')`,
+	)
+	migrations.Add(23,
+		`ALTER TABLE apps ADD COLUMN autoscale jsonb`,
+	)
+	migrations.Add(24,
+		`CREATE TABLE user_roles (name text PRIMARY KEY)`,
+		`INSERT INTO user_roles (name) VALUES
+			('admin'), ('deployer'), ('read-only')`,
+		`CREATE TABLE users (
+			user_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			name text NOT NULL,
+			role text NOT NULL REFERENCES user_roles (name),
+			apps jsonb,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now(),
+			deleted_at timestamptz
+		)`,
+		`CREATE UNIQUE INDEX users_name_idx ON users (name) WHERE deleted_at IS NULL`,
+		`CREATE TABLE user_tokens (
+			token_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id uuid NOT NULL REFERENCES users (user_id),
+			token_hash text NOT NULL,
+			description text,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			deleted_at timestamptz
+		)`,
+		`CREATE UNIQUE INDEX user_tokens_token_hash_idx ON user_tokens (token_hash) WHERE deleted_at IS NULL`,
+		`CREATE INDEX ON user_tokens (user_id) WHERE deleted_at IS NULL`,
+		`INSERT INTO event_types (name) VALUES ('audit')`,
+	)
+	migrations.Add(25,
+		`ALTER TABLE job_cache ADD COLUMN pending_reason text`,
+	)
+	migrations.Add(26,
+		`CREATE TABLE log_drains (
+			log_drain_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			app_id uuid NOT NULL REFERENCES apps (app_id),
+			url text NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			deleted_at timestamptz
+		)`,
+		`CREATE INDEX ON log_drains (app_id) WHERE deleted_at IS NULL`,
+		`INSERT INTO event_types (name) VALUES ('log_drain'), ('log_drain_deletion')`,
+	)
+	migrations.Add(27,
+		`CREATE TABLE builds (
+			build_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			app_id uuid NOT NULL REFERENCES apps (app_id),
+			rev text NOT NULL DEFAULT '',
+			state text NOT NULL,
+			release_id uuid REFERENCES releases (release_id),
+			error text NOT NULL DEFAULT '',
+			cache_key text NOT NULL DEFAULT '',
+			cache_hit boolean NOT NULL DEFAULT false,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			started_at timestamptz,
+			ended_at timestamptz
+		)`,
+		`CREATE INDEX ON builds (app_id, created_at)`,
+		`INSERT INTO event_types (name) VALUES ('build')`,
+	)
+	migrations.Add(28,
+		`CREATE TABLE pipelines (
+			pipeline_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			name text NOT NULL,
+			apps jsonb NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now(),
+			deleted_at timestamptz
+		)`,
+		`CREATE UNIQUE INDEX pipelines_name_idx ON pipelines (name) WHERE deleted_at IS NULL`,
+		`CREATE TABLE promotions (
+			promotion_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			pipeline_id uuid NOT NULL REFERENCES pipelines (pipeline_id),
+			from_app_id uuid NOT NULL REFERENCES apps (app_id),
+			from_release_id uuid NOT NULL REFERENCES releases (release_id),
+			to_app_id uuid NOT NULL REFERENCES apps (app_id),
+			release_id uuid NOT NULL REFERENCES releases (release_id),
+			deployment_id uuid NOT NULL REFERENCES deployments (deployment_id),
+			created_at timestamptz NOT NULL DEFAULT now()
+		)`,
+		`CREATE INDEX ON promotions (pipeline_id, created_at)`,
+		`INSERT INTO event_types (name) VALUES ('pipeline'), ('pipeline_deletion'), ('promotion')`,
+	)
+	migrations.Add(29,
+		`CREATE TABLE app_webhook_secrets (
+			app_id uuid PRIMARY KEY REFERENCES apps (app_id),
+			secret text NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now()
+		)`,
+		// move secrets out of app meta, which is returned by the API
+		// and included in app events
+		`INSERT INTO app_webhook_secrets (app_id, secret)
+		SELECT app_id, meta->>'webhook.secret' FROM apps
+		WHERE deleted_at IS NULL AND meta->>'webhook.secret' <> ''`,
+		`UPDATE apps SET meta = meta - 'webhook.secret' WHERE meta ? 'webhook.secret'`,
+		`UPDATE events SET data = jsonb_set(data, '{meta}', (data->'meta') - 'webhook.secret')
+		WHERE object_type = 'app' AND data->'meta' ? 'webhook.secret'`,
+	)
 }
 
 func migrateDB(db *postgres.DB) error {
@@ -8,6 +8,7 @@ import (
 var preparedStatements = map[string]string{
 	"ping":                                  pingQuery,
 	"app_list":                              appListQuery,
+	"app_list_by_ids":                       appListByIDsQuery,
 	"app_select_by_name":                    appSelectByNameQuery,
 	"app_select_by_name_for_update":         appSelectByNameForUpdateQuery,
 	"app_select_by_name_or_id":              appSelectByNameOrIDQuery,
@@ -17,6 +18,8 @@ var preparedStatements = map[string]string{
 	"app_update_meta":                       appUpdateMetaQuery,
 	"app_update_release":                    appUpdateReleaseQuery,
 	"app_update_deploy_timeout":             appUpdateDeployTimeoutQuery,
+	"app_update_deploy_options":             appUpdateDeployOptionsQuery,
+	"app_update_autoscale":                  appUpdateAutoscaleQuery,
 	"app_delete":                            appDeleteQuery,
 	"app_next_name_id":                      appNextNameIDQuery,
 	"app_get_release":                       appGetReleaseQuery,
@@ -24,11 +27,13 @@ var preparedStatements = map[string]string{
 	"release_select":                        releaseSelectQuery,
 	"release_insert":                        releaseInsertQuery,
 	"release_app_list":                      releaseAppListQuery,
+	"release_app_ids":                       releaseAppIDsQuery,
 	"release_artifacts_insert":              releaseArtifactsInsertQuery,
 	"release_artifacts_delete":              releaseArtifactsDeleteQuery,
 	"release_delete":                        releaseDeleteQuery,
 	"artifact_list":                         artifactListQuery,
 	"artifact_list_ids":                     artifactListIDsQuery,
+	"artifact_app_ids":                      artifactAppIDsQuery,
 	"artifact_select":                       artifactSelectQuery,
 	"artifact_select_by_type_and_uri":       artifactSelectByTypeAndURIQuery,
 	"artifact_insert":                       artifactInsertQuery,
@@ -36,11 +41,13 @@ var preparedStatements = map[string]string{
 	"artifact_release_count":                artifactReleaseCountQuery,
 	"deployment_list":                       deploymentListQuery,
 	"deployment_select":                     deploymentSelectQuery,
+	"deployment_app_ids":                    deploymentAppIDsQuery,
 	"deployment_insert":                     deploymentInsertQuery,
 	"deployment_update_finished_at":         deploymentUpdateFinishedAtQuery,
 	"deployment_update_finished_at_now":     deploymentUpdateFinishedAtNowQuery,
 	"deployment_delete":                     deploymentDeleteQuery,
 	"event_select":                          eventSelectQuery,
+	"event_app_ids":                         eventAppIDsQuery,
 	"event_insert":                          eventInsertQuery,
 	"event_insert_unique":                   eventInsertUniqueQuery,
 	"formation_list_by_app":                 formationListByAppQuery,
@@ -74,6 +81,46 @@ var preparedStatements = map[string]string{
 	"backup_insert":                         backupInsert,
 	"backup_update":                         backupUpdate,
 	"backup_select_latest":                  backupSelectLatest,
+	"schedule_list":                         scheduleListQuery,
+	"schedule_select":                       scheduleSelectQuery,
+	"schedule_insert":                       scheduleInsertQuery,
+	"schedule_update":                       scheduleUpdateQuery,
+	"schedule_update_next_run_at":           scheduleUpdateNextRunAtQuery,
+	"schedule_delete":                       scheduleDeleteQuery,
+	"schedule_delete_by_app":                scheduleDeleteByAppQuery,
+	"log_drain_list":                        logDrainListQuery,
+	"log_drain_list_by_app":                 logDrainListByAppQuery,
+	"log_drain_select":                      logDrainSelectQuery,
+	"log_drain_insert":                      logDrainInsertQuery,
+	"log_drain_delete":                      logDrainDeleteQuery,
+	"log_drain_delete_by_app":               logDrainDeleteByAppQuery,
+	"webhook_secret_select":                 webhookSecretSelectQuery,
+	"webhook_secret_upsert":                 webhookSecretUpsertQuery,
+	"webhook_secret_delete":                 webhookSecretDeleteQuery,
+	"build_list_by_app":                     buildListByAppQuery,
+	"build_select":                          buildSelectQuery,
+	"build_insert":                          buildInsertQuery,
+	"build_update":                          buildUpdateQuery,
+	"pipeline_list":                         pipelineListQuery,
+	"pipeline_select_by_name":               pipelineSelectByNameQuery,
+	"pipeline_select_by_name_or_id":         pipelineSelectByNameOrIDQuery,
+	"pipeline_insert":                       pipelineInsertQuery,
+	"pipeline_update":                       pipelineUpdateQuery,
+	"pipeline_delete":                       pipelineDeleteQuery,
+	"pipeline_remove_app":                   pipelineRemoveAppQuery,
+	"promotion_list":                        promotionListQuery,
+	"promotion_insert":                      promotionInsertQuery,
+	"user_list":                             userListQuery,
+	"user_select_by_name":                   userSelectByNameQuery,
+	"user_select_by_name_or_id":             userSelectByNameOrIDQuery,
+	"user_select_by_token_hash":             userSelectByTokenHashQuery,
+	"user_insert":                           userInsertQuery,
+	"user_update":                           userUpdateQuery,
+	"user_delete":                           userDeleteQuery,
+	"user_token_list":                       userTokenListQuery,
+	"user_token_insert":                     userTokenInsertQuery,
+	"user_token_delete":                     userTokenDeleteQuery,
+	"user_token_delete_by_user":             userTokenDeleteByUserQuery,
 }
 
 func PrepareStatements(conn *pgx.Conn) error {
@@ -93,22 +140,25 @@ const (
 	pingQuery = `SELECT 1`
 	// apps
 	appListQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, deploy_timeout, deploy_options, autoscale, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL ORDER BY created_at DESC`
+	appListByIDsQuery = `
+SELECT app_id, name, meta, strategy, release_id, deploy_timeout, deploy_options, autoscale, created_at, updated_at
+FROM apps WHERE deleted_at IS NULL AND app_id = ANY($1) ORDER BY created_at DESC`
 	appSelectByNameQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, deploy_timeout, deploy_options, autoscale, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL AND name = $1`
 	appSelectByNameForUpdateQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, deploy_timeout, deploy_options, autoscale, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL AND name = $1 FOR UPDATE`
 	appSelectByNameOrIDQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, deploy_timeout, deploy_options, autoscale, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL AND (app_id = $1 OR name = $2) LIMIT 1`
 	appSelectByNameOrIDForUpdateQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, deploy_timeout, deploy_options, autoscale, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL AND (app_id = $1 OR name = $2) LIMIT 1 FOR UPDATE`
 	appInsertQuery = `
-INSERT INTO apps (app_id, name, meta, strategy, deploy_timeout) VALUES ($1, $2, $3, $4, $5) RETURNING created_at, updated_at`
+INSERT INTO apps (app_id, name, meta, strategy, deploy_timeout, deploy_options, autoscale) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at, updated_at`
 	appUpdateStrategyQuery = `
 UPDATE apps SET strategy = $2, updated_at = now() WHERE app_id = $1`
 	appUpdateMetaQuery = `
@@ -117,6 +167,10 @@ UPDATE apps SET meta = $2, updated_at = now() WHERE app_id = $1`
 UPDATE apps SET release_id = $2, updated_at = now() WHERE app_id = $1`
 	appUpdateDeployTimeoutQuery = `
 UPDATE apps SET deploy_timeout = $2, updated_at = now() WHERE app_id = $1`
+	appUpdateDeployOptionsQuery = `
+UPDATE apps SET deploy_options = $2, updated_at = now() WHERE app_id = $1`
+	appUpdateAutoscaleQuery = `
+UPDATE apps SET autoscale = $2, updated_at = now() WHERE app_id = $1`
 	appDeleteQuery = `
 UPDATE apps SET deleted_at = now() WHERE app_id = $1 AND deleted_at IS NULL`
 	appNextNameIDQuery = `
@@ -162,6 +216,9 @@ SELECT DISTINCT(r.release_id),
   ), r.env, r.processes, r.meta, r.created_at
 FROM releases r JOIN formations f USING (release_id)
 WHERE f.app_id = $1 AND r.deleted_at IS NULL ORDER BY r.created_at DESC`
+	releaseAppIDsQuery = `
+SELECT app_id FROM formations WHERE release_id = $1
+UNION SELECT app_id FROM apps WHERE release_id = $1 AND deleted_at IS NULL`
 	releaseArtifactsInsertQuery = `
 INSERT INTO release_artifacts (release_id, artifact_id, index) VALUES ($1, $2, $3)`
 	releaseArtifactsDeleteQuery = `
@@ -174,6 +231,9 @@ WHERE deleted_at IS NULL ORDER BY created_at DESC`
 	artifactListIDsQuery = `
 SELECT artifact_id, type, uri, meta, created_at FROM artifacts
 WHERE deleted_at IS NULL AND artifact_id = ANY($1)`
+	artifactAppIDsQuery = `
+SELECT f.app_id FROM formations f JOIN release_artifacts r USING (release_id) WHERE r.artifact_id = $1
+UNION SELECT a.app_id FROM apps a JOIN release_artifacts r USING (release_id) WHERE r.artifact_id = $1 AND a.deleted_at IS NULL`
 	artifactSelectQuery = `
 SELECT artifact_id, type, uri, meta, created_at FROM artifacts
 WHERE artifact_id = $1 AND deleted_at IS NULL`
@@ -186,8 +246,8 @@ UPDATE artifacts SET deleted_at = now() WHERE artifact_id = $1 AND deleted_at IS
 	artifactReleaseCountQuery = `
 SELECT COUNT(*) FROM release_artifacts WHERE artifact_id = $1 AND deleted_at IS NULL`
 	deploymentInsertQuery = `
-INSERT INTO deployments (deployment_id, app_id, old_release_id, new_release_id, strategy, processes, deploy_timeout)
-VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`
+INSERT INTO deployments (deployment_id, app_id, old_release_id, new_release_id, strategy, processes, deploy_timeout, deploy_options)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING created_at`
 	deploymentUpdateFinishedAtQuery = `
 UPDATE deployments SET finished_at = $2 WHERE deployment_id = $1`
 	deploymentUpdateFinishedAtNowQuery = `
@@ -198,18 +258,20 @@ DELETE FROM deployments WHERE deployment_id = $1`
 WITH deployment_events AS (SELECT * FROM events WHERE object_type = 'deployment')
 SELECT d.deployment_id, d.app_id, d.old_release_id, d.new_release_id,
   strategy, e1.data->>'status' AS status,
-  processes, deploy_timeout, d.created_at, d.finished_at
+  processes, deploy_timeout, deploy_options, d.created_at, d.finished_at
 FROM deployments d
 LEFT JOIN deployment_events e1
   ON d.deployment_id = e1.object_id::uuid
 LEFT OUTER JOIN deployment_events e2
   ON (d.deployment_id = e2.object_id::uuid AND e1.created_at < e2.created_at)
 WHERE e2.created_at IS NULL AND d.deployment_id = $1`
+	deploymentAppIDsQuery = `
+SELECT app_id FROM deployments WHERE deployment_id = $1`
 	deploymentListQuery = `
 WITH deployment_events AS (SELECT * FROM events WHERE object_type = 'deployment')
 SELECT d.deployment_id, d.app_id, d.old_release_id, d.new_release_id,
   strategy, e1.data->>'status' AS status,
-  processes, deploy_timeout, d.created_at, d.finished_at
+  processes, deploy_timeout, deploy_options, d.created_at, d.finished_at
 FROM deployments d
 LEFT JOIN deployment_events e1
   ON d.deployment_id = e1.object_id::uuid
@@ -219,6 +281,8 @@ WHERE e2.created_at IS NULL AND d.app_id = $1 ORDER BY d.created_at DESC`
 	eventSelectQuery = `
 SELECT event_id, app_id, object_id, object_type, data, created_at
 FROM events WHERE event_id = $1`
+	eventAppIDsQuery = `
+SELECT app_id FROM events WHERE event_id = $1 AND app_id IS NOT NULL`
 	eventInsertQuery = `
 INSERT INTO events (app_id, object_id, object_type, data)
 VALUES ($1, $2, $3, $4)`
@@ -290,18 +354,18 @@ WHERE app_id = $1 AND release_id = $2 AND deleted_at IS NULL`
 UPDATE formations SET deleted_at = now(), processes = NULL, updated_at = now()
 WHERE app_id = $1 AND deleted_at IS NULL`
 	jobListQuery = `
-SELECT cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, run_at, restarts, created_at, updated_at
+SELECT cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, pending_reason, run_at, restarts, created_at, updated_at
 FROM job_cache WHERE app_id = $1 ORDER BY created_at DESC`
 	jobListActiveQuery = `
-SELECT cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, run_at, restarts, created_at, updated_at
+SELECT cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, pending_reason, run_at, restarts, created_at, updated_at
 FROM job_cache WHERE state = 'pending' OR state = 'starting' OR state = 'up' ORDER BY updated_at DESC`
 	jobSelectQuery = `
-SELECT cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, run_at, restarts, created_at, updated_at
+SELECT cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, pending_reason, run_at, restarts, created_at, updated_at
 FROM job_cache WHERE job_id = $1`
 	jobInsertQuery = `
-INSERT INTO job_cache (cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, run_at, restarts)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT (job_id) DO UPDATE
-SET cluster_id = $1, host_id = $3, state = $7, exit_status = $9, host_error = $10, run_at = $11, restarts = $12, updated_at = now()
+INSERT INTO job_cache (cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, pending_reason, run_at, restarts)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) ON CONFLICT (job_id) DO UPDATE
+SET cluster_id = $1, host_id = $3, state = $7, exit_status = $9, host_error = $10, pending_reason = $11, run_at = $12, restarts = $13, updated_at = now()
 RETURNING created_at, updated_at`
 	providerListQuery = `
 SELECT provider_id, name, url, created_at, updated_at
@@ -384,4 +448,111 @@ INSERT INTO backups (status, sha512, size, error, completed_at) VALUES ($1, $2,
 UPDATE backups SET status = $2, sha512 = $3, size = $4, error = $5, completed_at = $6, updated_at = now() WHERE backup_id = $1 RETURNING updated_at`
 	backupSelectLatest = `
 SELECT backup_id, status, sha512, size, error, created_at, updated_at, completed_at FROM backups WHERE deleted_at IS NULL ORDER BY updated_at DESC LIMIT 1`
+	scheduleListQuery = `
+SELECT schedule_id, app_id, cron, process_type, args, env, concurrency_policy, next_run_at, created_at, updated_at
+FROM schedules WHERE app_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC`
+	scheduleSelectQuery = `
+SELECT schedule_id, app_id, cron, process_type, args, env, concurrency_policy, next_run_at, created_at, updated_at
+FROM schedules WHERE schedule_id = $1 AND deleted_at IS NULL`
+	scheduleInsertQuery = `
+INSERT INTO schedules (schedule_id, app_id, cron, process_type, args, env, concurrency_policy, next_run_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING created_at, updated_at`
+	scheduleUpdateQuery = `
+UPDATE schedules SET cron = $2, process_type = $3, args = $4, env = $5, concurrency_policy = $6, next_run_at = $7, updated_at = now()
+WHERE schedule_id = $1 AND deleted_at IS NULL RETURNING updated_at`
+	scheduleUpdateNextRunAtQuery = `
+UPDATE schedules SET next_run_at = $3 WHERE schedule_id = $1 AND next_run_at = $2 AND deleted_at IS NULL RETURNING schedule_id`
+	scheduleDeleteQuery = `
+UPDATE schedules SET deleted_at = now() WHERE schedule_id = $1 AND deleted_at IS NULL`
+	scheduleDeleteByAppQuery = `
+UPDATE schedules SET deleted_at = now() WHERE app_id = $1 AND deleted_at IS NULL`
+	pipelineListQuery = `
+SELECT pipeline_id, name, apps, created_at, updated_at
+FROM pipelines WHERE deleted_at IS NULL ORDER BY name`
+	pipelineSelectByNameQuery = `
+SELECT pipeline_id, name, apps, created_at, updated_at
+FROM pipelines WHERE deleted_at IS NULL AND name = $1`
+	pipelineSelectByNameOrIDQuery = `
+SELECT pipeline_id, name, apps, created_at, updated_at
+FROM pipelines WHERE deleted_at IS NULL AND (pipeline_id = $1 OR name = $2) LIMIT 1`
+	pipelineInsertQuery = `
+INSERT INTO pipelines (pipeline_id, name, apps) VALUES ($1, $2, $3) RETURNING created_at, updated_at`
+	pipelineUpdateQuery = `
+UPDATE pipelines SET apps = $2, updated_at = now()
+WHERE pipeline_id = $1 AND deleted_at IS NULL RETURNING updated_at`
+	pipelineDeleteQuery = `
+UPDATE pipelines SET deleted_at = now() WHERE pipeline_id = $1 AND deleted_at IS NULL`
+	pipelineRemoveAppQuery = `
+UPDATE pipelines SET apps = apps - $1::text, updated_at = now()
+WHERE apps ? $1::text AND deleted_at IS NULL`
+	promotionListQuery = `
+SELECT promotion_id, pipeline_id, from_app_id, from_release_id, to_app_id, release_id, deployment_id, created_at
+FROM promotions WHERE pipeline_id = $1 ORDER BY created_at DESC LIMIT $2`
+	promotionInsertQuery = `
+INSERT INTO promotions (promotion_id, pipeline_id, from_app_id, from_release_id, to_app_id, release_id, deployment_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`
+	userListQuery = `
+SELECT user_id, name, role, apps, created_at, updated_at
+FROM users WHERE deleted_at IS NULL ORDER BY name`
+	userSelectByNameQuery = `
+SELECT user_id, name, role, apps, created_at, updated_at
+FROM users WHERE deleted_at IS NULL AND name = $1`
+	userSelectByNameOrIDQuery = `
+SELECT user_id, name, role, apps, created_at, updated_at
+FROM users WHERE deleted_at IS NULL AND (user_id = $1 OR name = $2) LIMIT 1`
+	userSelectByTokenHashQuery = `
+SELECT u.user_id, u.name, u.role, u.apps, u.created_at, u.updated_at, t.token_id
+FROM user_tokens t JOIN users u USING (user_id)
+WHERE t.token_hash = $1 AND t.deleted_at IS NULL AND u.deleted_at IS NULL`
+	userInsertQuery = `
+INSERT INTO users (user_id, name, role, apps) VALUES ($1, $2, $3, $4) RETURNING created_at, updated_at`
+	userUpdateQuery = `
+UPDATE users SET role = $2, apps = $3, updated_at = now()
+WHERE user_id = $1 AND deleted_at IS NULL RETURNING updated_at`
+	userDeleteQuery = `
+UPDATE users SET deleted_at = now() WHERE user_id = $1 AND deleted_at IS NULL`
+	userTokenListQuery = `
+SELECT token_id, user_id, description, created_at
+FROM user_tokens WHERE user_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC`
+	userTokenInsertQuery = `
+INSERT INTO user_tokens (token_id, user_id, token_hash, description) VALUES ($1, $2, $3, $4) RETURNING created_at`
+	userTokenDeleteQuery = `
+UPDATE user_tokens SET deleted_at = now() WHERE token_id = $1 AND user_id = $2 AND deleted_at IS NULL`
+	userTokenDeleteByUserQuery = `
+UPDATE user_tokens SET deleted_at = now() WHERE user_id = $1 AND deleted_at IS NULL`
+	logDrainListQuery = `
+SELECT log_drain_id, app_id, url, created_at FROM log_drains WHERE deleted_at IS NULL ORDER BY created_at`
+	logDrainListByAppQuery = `
+SELECT log_drain_id, app_id, url, created_at FROM log_drains WHERE app_id = $1 AND deleted_at IS NULL ORDER BY created_at`
+	logDrainSelectQuery = `
+SELECT log_drain_id, app_id, url, created_at FROM log_drains WHERE log_drain_id = $1 AND deleted_at IS NULL`
+	logDrainInsertQuery = `
+INSERT INTO log_drains (log_drain_id, app_id, url) VALUES ($1, $2, $3) RETURNING created_at`
+	logDrainDeleteQuery = `
+UPDATE log_drains SET deleted_at = now() WHERE log_drain_id = $1 AND deleted_at IS NULL`
+	logDrainDeleteByAppQuery = `
+UPDATE log_drains SET deleted_at = now() WHERE app_id = $1 AND deleted_at IS NULL`
+	webhookSecretSelectQuery = `
+SELECT secret FROM app_webhook_secrets WHERE app_id = $1`
+	webhookSecretUpsertQuery = `
+INSERT INTO app_webhook_secrets (app_id, secret) VALUES ($1, $2)
+ON CONFLICT (app_id) DO UPDATE SET secret = $2, updated_at = now()`
+	webhookSecretDeleteQuery = `
+DELETE FROM app_webhook_secrets WHERE app_id = $1`
+	buildListByAppQuery = `
+SELECT build_id, app_id, rev, state, release_id, error, cache_key, cache_hit, created_at, started_at, ended_at
+FROM builds WHERE app_id = $1 ORDER BY created_at DESC LIMIT $2`
+	buildSelectQuery = `
+SELECT build_id, app_id, rev, state, release_id, error, cache_key, cache_hit, created_at, started_at, ended_at
+FROM builds WHERE build_id = $1`
+	buildInsertQuery = `
+INSERT INTO builds (build_id, app_id, rev, state, cache_key, cache_hit) VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING created_at`
+	buildUpdateQuery = `
+UPDATE builds SET
+  state = $2, release_id = $3, error = $4, cache_key = $5, cache_hit = $6,
+  started_at = CASE WHEN started_at IS NULL AND $2 <> 'pending' THEN now() ELSE started_at END,
+  ended_at = CASE WHEN $2 IN ('succeeded', 'failed', 'cancelled') THEN now() ELSE NULL END
+WHERE build_id = $1
+RETURNING started_at, ended_at`
 )
@@ -7,6 +7,7 @@ import (
 
 	"github.com/flynn/flynn/controller/utils"
 	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/stream"
 )
 
@@ -107,6 +108,23 @@ func (c *FakeCluster) removeHost(hostID string) {
 	}
 }
 
+// UpdateHost sends an update event for the host with the given drain status
+// set in its metadata
+func (c *FakeCluster) UpdateHost(hostID string, drain *host.DrainStatus) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	for ch := range c.hostChannels {
+		event := createDiscoverdEvent(hostID, discoverd.EventKindUpdate)
+		if drain != nil {
+			event.Instance.Meta[host.DrainMetaKey] = "true"
+			if drain.Data {
+				event.Instance.Meta[host.DrainDataMetaKey] = "true"
+			}
+		}
+		ch <- event
+	}
+}
+
 func createDiscoverdEvent(hostID string, k discoverd.EventKind) *discoverd.Event {
 	return &discoverd.Event{
 		Kind: k,
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/resource"
 	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/host/volume"
 	"github.com/flynn/flynn/pkg/cluster"
@@ -18,6 +19,7 @@ func NewFakeHostClient(hostID string, sync bool) *FakeHostClient {
 	h := &FakeHostClient{
 		hostID:        hostID,
 		stopped:       make(map[string]bool),
+		stoppedJobs:   make(map[string]host.ActiveJob),
 		attach:        make(map[string]attachFunc),
 		volumes:       make(map[string]*volume.Info),
 		Jobs:          make(map[string]host.ActiveJob),
@@ -33,14 +35,19 @@ func NewFakeHostClient(hostID string, sync bool) *FakeHostClient {
 type FakeHostClient struct {
 	hostID           string
 	stopped          map[string]bool
+	stoppedJobs      map[string]host.ActiveJob
 	attach           map[string]attachFunc
 	Jobs             map[string]host.ActiveJob
 	cluster          *FakeCluster
 	volumes          map[string]*volume.Info
+	volumePulls      []*VolumePull
+	volumesMtx       sync.Mutex
 	eventChannelsMtx sync.Mutex
 	eventChannels    map[chan<- *host.Event]struct{}
 	jobsMtx          sync.RWMutex
 	Healthy          bool
+	Capacity         resource.Capacity
+	Drain            *host.DrainStatus
 	TestEventHook    chan struct{}
 }
 
@@ -96,6 +103,10 @@ func (c *FakeHostClient) GetJob(id string) (*host.ActiveJob, error) {
 	c.jobsMtx.RLock()
 	defer c.jobsMtx.RUnlock()
 	job, ok := c.Jobs[id]
+	if !ok {
+		// hosts keep the state of stopped jobs
+		job, ok = c.stoppedJobs[id]
+	}
 	if !ok {
 		return nil, fmt.Errorf("unable to find job with ID %q", id)
 	}
@@ -126,6 +137,7 @@ func (c *FakeHostClient) StopJob(id string) error {
 func (c *FakeHostClient) stop(id string) error {
 	job := c.Jobs[id]
 	delete(c.Jobs, id)
+	c.stoppedJobs[id] = job
 	c.eventChannelsMtx.Lock()
 	defer c.eventChannelsMtx.Unlock()
 	for ch := range c.eventChannels {
@@ -156,6 +168,34 @@ func (c *FakeHostClient) CrashJob(uuid string) error {
 	}
 }
 
+// RunJob marks a starting job as running, sending a start event as the host
+// does once the job's container is running
+func (c *FakeHostClient) RunJob(uuid string) error {
+	c.jobsMtx.Lock()
+	defer c.jobsMtx.Unlock()
+	id := cluster.GenerateJobID(c.hostID, uuid)
+	job, ok := c.Jobs[id]
+	if !ok {
+		return ct.NotFoundError{Resource: id}
+	}
+	job.Status = host.StatusRunning
+	c.Jobs[id] = job
+
+	c.eventChannelsMtx.Lock()
+	defer c.eventChannelsMtx.Unlock()
+	for ch := range c.eventChannels {
+		ch <- &host.Event{
+			Event: host.JobEventStart,
+			JobID: id,
+			Job:   &job,
+		}
+		if c.TestEventHook != nil {
+			<-c.TestEventHook
+		}
+	}
+	return nil
+}
+
 func (c *FakeHostClient) IsStopped(id string) bool {
 	c.jobsMtx.RLock()
 	defer c.jobsMtx.RUnlock()
@@ -173,12 +213,66 @@ func (c *FakeHostClient) SetAttachFunc(id string, f attachFunc) {
 }
 
 func (c *FakeHostClient) CreateVolume(providerID string) (*volume.Info, error) {
+	c.volumesMtx.Lock()
+	defer c.volumesMtx.Unlock()
 	id := random.UUID()
 	volume := &volume.Info{ID: id}
 	c.volumes[id] = volume
 	return volume, nil
 }
 
+func (c *FakeHostClient) DestroyVolume(id string) error {
+	c.volumesMtx.Lock()
+	defer c.volumesMtx.Unlock()
+	if _, ok := c.volumes[id]; !ok {
+		return ct.NotFoundError{Resource: id}
+	}
+	delete(c.volumes, id)
+	return nil
+}
+
+// VolumePull records a call to PullVolume
+type VolumePull struct {
+	VolumeID       string
+	SourceHostID   string
+	SourceVolumeID string
+	SourceJobs     []string // the jobs running on the source host at the time
+}
+
+func (c *FakeHostClient) PullVolume(receiveVolID, sourceHostID, sourceVolID string) (*volume.VolumeTransfer, error) {
+	pull := &VolumePull{
+		VolumeID:       receiveVolID,
+		SourceHostID:   sourceHostID,
+		SourceVolumeID: sourceVolID,
+	}
+	if c.cluster != nil {
+		if source, err := c.cluster.Host(sourceHostID); err == nil {
+			jobs, _ := source.ListJobs()
+			for id := range jobs {
+				pull.SourceJobs = append(pull.SourceJobs, id)
+			}
+		}
+	}
+
+	c.volumesMtx.Lock()
+	defer c.volumesMtx.Unlock()
+	if _, ok := c.volumes[receiveVolID]; !ok {
+		return nil, ct.NotFoundError{Resource: receiveVolID}
+	}
+	c.volumePulls = append(c.volumePulls, pull)
+	return &volume.VolumeTransfer{
+		SourceSnapshotID: random.UUID(),
+		Snapshot:         &volume.Info{ID: random.UUID()},
+	}, nil
+}
+
+// VolumePulls returns the calls made to PullVolume
+func (c *FakeHostClient) VolumePulls() []*VolumePull {
+	c.volumesMtx.Lock()
+	defer c.volumesMtx.Unlock()
+	return append([]*VolumePull(nil), c.volumePulls...)
+}
+
 func (c *FakeHostClient) StreamEvents(id string, ch chan *host.Event) (stream.Stream, error) {
 	c.eventChannelsMtx.Lock()
 	if _, ok := c.eventChannels[ch]; ok {
@@ -203,7 +297,42 @@ func (c *FakeHostClient) GetStatus() (*host.HostStatus, error) {
 	if !c.Healthy {
 		return nil, errors.New("unhealthy")
 	}
-	return &host.HostStatus{ID: c.ID()}, nil
+	status := &host.HostStatus{ID: c.ID(), Capacity: c.Capacity}
+	c.jobsMtx.RLock()
+	if c.Drain != nil {
+		drain := *c.Drain
+		status.Drain = &drain
+	}
+	c.jobsMtx.RUnlock()
+	return status, nil
+}
+
+func (c *FakeHostClient) MarkDrained() error {
+	c.jobsMtx.Lock()
+	defer c.jobsMtx.Unlock()
+	if c.Drain == nil {
+		return errors.New("host is not being drained")
+	}
+	c.Drain.Drained = true
+	return nil
+}
+
+func (c *FakeHostClient) IsDrained() bool {
+	c.jobsMtx.RLock()
+	defer c.jobsMtx.RUnlock()
+	return c.Drain != nil && c.Drain.Drained
+}
+
+// SetDrain sets the host's drain status and sends an update event to the
+// cluster's host event streams, as the host does when it is drained using
+// its API
+func (c *FakeHostClient) SetDrain(drain *host.DrainStatus) {
+	c.jobsMtx.Lock()
+	c.Drain = drain
+	c.jobsMtx.Unlock()
+	if c.cluster != nil {
+		c.cluster.UpdateHost(c.hostID, drain)
+	}
 }
 
 type attachFunc func(req *host.AttachReq, wait bool) (cluster.AttachClient, error)
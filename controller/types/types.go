@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/flynn/flynn/host/resource"
@@ -31,6 +32,8 @@ type App struct {
 	Strategy      string            `json:"strategy,omitempty"`
 	ReleaseID     string            `json:"release,omitempty"`
 	DeployTimeout int32             `json:"deploy_timeout,omitempty"`
+	DeployOptions *DeployOptions    `json:"deploy_options,omitempty"`
+	Autoscale     AutoscalePolicies `json:"autoscale,omitempty"`
 	CreatedAt     *time.Time        `json:"created_at,omitempty"`
 	UpdatedAt     *time.Time        `json:"updated_at,omitempty"`
 }
@@ -46,6 +49,39 @@ func (a *App) Critical() bool {
 	return ok && v == "true"
 }
 
+// Review apps are temporary apps which are created from a parent app for
+// branches other than master, and are deleted when the branch is deleted or
+// once they expire. The following keys in a review app's meta record the
+// parent app's ID, the branch and the RFC3339 time the app expires.
+const (
+	ReviewParentMetaKey    = "review.parent"
+	ReviewBranchMetaKey    = "review.branch"
+	ReviewExpiresAtMetaKey = "review.expires_at"
+)
+
+// ReviewParent returns the ID of the app a review app was created from, or an
+// empty string if the app is not a review app
+func (a *App) ReviewParent() string {
+	return a.Meta[ReviewParentMetaKey]
+}
+
+// ReviewExpiresAt returns when a review app expires, or nil if it doesn't
+// have a valid expiry time
+func (a *App) ReviewExpiresAt() *time.Time {
+	t, err := time.Parse(time.RFC3339, a.Meta[ReviewExpiresAtMetaKey])
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// ReviewAppExpiry is the argument of the review_app_expiry worker job which
+// deletes a review app if it still expires at the given time
+type ReviewAppExpiry struct {
+	AppID     string    `json:"app_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
 type Release struct {
 	ID          string                 `json:"id,omitempty"`
 	ArtifactIDs []string               `json:"artifacts,omitempty"`
@@ -99,11 +135,52 @@ type ProcessType struct {
 	Resurrect   bool               `json:"resurrect,omitempty"`
 	Resources   resource.Resources `json:"resources,omitempty"`
 
+	// Placement constrains which hosts the process type's jobs are placed
+	// on relative to other jobs in the cluster
+	Placement []PlacementConstraint `json:"placement,omitempty"`
+
 	// Entrypoint and Cmd are DEPRECATED: use Args instead
 	DeprecatedCmd        []string `json:"cmd,omitempty"`
 	DeprecatedEntrypoint []string `json:"entrypoint,omitempty"`
 }
 
+type PlacementConstraintType string
+
+const (
+	// PlacementAffinity places jobs only on hosts in the same domain as a
+	// job of the target process type (e.g. co-locate with a database)
+	PlacementAffinity PlacementConstraintType = "affinity"
+
+	// PlacementAntiAffinity never places a job on a host in the same
+	// domain as another job of the target process type (e.g. never run
+	// two web jobs on the same host)
+	PlacementAntiAffinity PlacementConstraintType = "anti-affinity"
+
+	// PlacementSpread prefers placing jobs on hosts in the domain with the
+	// fewest jobs of the target process type (e.g. spread across zones)
+	PlacementSpread PlacementConstraintType = "spread"
+)
+
+// PlacementConstraint constrains the placement of a process type's jobs
+// relative to the jobs of a target process type, which defaults to the
+// constrained process type in the same release.
+//
+// Hosts are grouped into domains by the value of HostTag (e.g. "zone"), or
+// individually if HostTag is empty.
+type PlacementConstraint struct {
+	Type PlacementConstraintType `json:"type"`
+
+	// App is the ID or name of the target app, and if set, the jobs of
+	// all of that app's releases are targeted
+	App string `json:"app,omitempty"`
+
+	// ProcessType is the target process type, defaulting to the
+	// constrained process type
+	ProcessType string `json:"process_type,omitempty"`
+
+	HostTag string `json:"host_tag,omitempty"`
+}
+
 type Port struct {
 	Port    int           `json:"port"`
 	Proto   string        `json:"proto"`
@@ -136,6 +213,11 @@ type Formation struct {
 	Tags      map[string]map[string]string `json:"tags,omitempty"`
 	CreatedAt *time.Time                   `json:"created_at,omitempty"`
 	UpdatedAt *time.Time                   `json:"updated_at,omitempty"`
+
+	// ScaleReason is recorded as the reason of the scale event created
+	// when the formation is saved (e.g. by the autoscaler), and is not
+	// stored with the formation
+	ScaleReason string `json:"scale_reason,omitempty"`
 }
 
 type Key struct {
@@ -158,18 +240,19 @@ type Job struct {
 	// empty if the job is pending
 	HostID string `json:"host_id,omitempty"`
 
-	AppID      string            `json:"app,omitempty"`
-	ReleaseID  string            `json:"release,omitempty"`
-	Type       string            `json:"type,omitempty"`
-	State      JobState          `json:"state,omitempty"`
-	Args       []string          `json:"args,omitempty"`
-	Meta       map[string]string `json:"meta,omitempty"`
-	ExitStatus *int32            `json:"exit_status,omitempty"`
-	HostError  *string           `json:"host_error,omitempty"`
-	RunAt      *time.Time        `json:"run_at,omitempty"`
-	Restarts   *int32            `json:"restarts,omitempty"`
-	CreatedAt  *time.Time        `json:"created_at,omitempty"`
-	UpdatedAt  *time.Time        `json:"updated_at,omitempty"`
+	AppID         string            `json:"app,omitempty"`
+	ReleaseID     string            `json:"release,omitempty"`
+	Type          string            `json:"type,omitempty"`
+	State         JobState          `json:"state,omitempty"`
+	Args          []string          `json:"args,omitempty"`
+	Meta          map[string]string `json:"meta,omitempty"`
+	ExitStatus    *int32            `json:"exit_status,omitempty"`
+	HostError     *string           `json:"host_error,omitempty"`
+	PendingReason *string           `json:"pending_reason,omitempty"`
+	RunAt         *time.Time        `json:"run_at,omitempty"`
+	Restarts      *int32            `json:"restarts,omitempty"`
+	CreatedAt     *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt     *time.Time        `json:"updated_at,omitempty"`
 }
 
 type JobState string
@@ -250,8 +333,362 @@ type NewJob struct {
 	Resources  resource.Resources `json:"resources,omitempty"`
 }
 
+// Schedule runs a one-off job for an app each time a cron expression is due,
+// either using the args, env and resources of one of the app's process types
+// or with the given args (like `flynn run`).
+type Schedule struct {
+	ID                string            `json:"id,omitempty"`
+	AppID             string            `json:"app,omitempty"`
+	Cron              string            `json:"cron,omitempty"`
+	ProcessType       string            `json:"process_type,omitempty"`
+	Args              []string          `json:"args,omitempty"`
+	Env               map[string]string `json:"env,omitempty"`
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrency_policy,omitempty"`
+	NextRunAt         *time.Time        `json:"next_run_at,omitempty"`
+	CreatedAt         *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt         *time.Time        `json:"updated_at,omitempty"`
+}
+
+// ConcurrencyPolicy determines what happens when a schedule is due whilst
+// jobs from previous runs are still running
+type ConcurrencyPolicy string
+
+const (
+	// ConcurrencyPolicyAllow runs the job alongside the running jobs
+	ConcurrencyPolicyAllow ConcurrencyPolicy = "allow"
+
+	// ConcurrencyPolicyForbid skips the run
+	ConcurrencyPolicyForbid ConcurrencyPolicy = "forbid"
+
+	// ConcurrencyPolicyReplace stops the running jobs before running the job
+	ConcurrencyPolicyReplace ConcurrencyPolicy = "replace"
+)
+
+// ScheduleMetaKey is the job metadata key which is set to the ID of the
+// schedule which ran the job
+const ScheduleMetaKey = "flynn-schedule"
+
+// ScheduleRun is the argument of the schedule worker job which runs a
+// schedule at the given time
+type ScheduleRun struct {
+	AppID      string    `json:"app_id"`
+	ScheduleID string    `json:"schedule_id"`
+	RunAt      time.Time `json:"run_at"`
+}
+
+// LogDrain forwards an app's logs to an external syslog or HTTP endpoint. URL
+// is either syslog://host:port or syslog+tls://host:port to stream RFC5424
+// messages over TCP, or an http(s) URL to POST batches of them to.
+type LogDrain struct {
+	ID        string         `json:"id,omitempty"`
+	AppID     string         `json:"app,omitempty"`
+	URL       string         `json:"url,omitempty"`
+	CreatedAt *time.Time     `json:"created_at,omitempty"`
+	Stats     *LogDrainStats `json:"stats,omitempty"`
+}
+
+// LogDrainStats are counters of the messages forwarded to a log drain by the
+// log aggregator since it became the leader.
+type LogDrainStats struct {
+	Sent        uint64     `json:"sent"`
+	Dropped     uint64     `json:"dropped"`
+	Errors      uint64     `json:"errors"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// WebhookSecret is the secret which the payloads of an app's push webhooks
+// are signed with. It is set through the API but never returned by it, so
+// signatures are verified by the controller (see WebhookSignature).
+type WebhookSecret struct {
+	Secret string `json:"secret"`
+}
+
+// WebhookSignature is a webhook payload along with its signature as sent in
+// GitHub's X-Hub-Signature-256 or X-Hub-Signature header (e.g.
+// "sha256=<hex HMAC>"), and Valid is set by the controller to whether the
+// payload was signed with the app's webhook secret.
+type WebhookSignature struct {
+	Payload   []byte `json:"payload,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Valid     bool   `json:"valid"`
+}
+
+// BuildState is the state of a build of an app's source code.
+type BuildState string
+
+const (
+	// BuildStatePending is a build's state whilst it waits for a free
+	// build slot.
+	BuildStatePending BuildState = "pending"
+
+	// BuildStateRunning is a build's state whilst its slugbuilder job is
+	// running.
+	BuildStateRunning BuildState = "running"
+
+	// BuildStateSucceeded is a build's state once its release has been
+	// deployed.
+	BuildStateSucceeded BuildState = "succeeded"
+
+	// BuildStateFailed is a build's state if it failed, with Error
+	// containing the reason.
+	BuildStateFailed BuildState = "failed"
+
+	// BuildStateCancelled is a build's state if it was superseded by a
+	// newer push for the same app before it finished.
+	BuildStateCancelled BuildState = "cancelled"
+)
+
+// Finished returns whether a build in the state has finished.
+func (s BuildState) Finished() bool {
+	return s == BuildStateSucceeded || s == BuildStateFailed || s == BuildStateCancelled
+}
+
+// Build records a build of an app's source code pushed to gitreceive. Each
+// change of state creates a build event, so the durations of builds can be
+// followed to diagnose slow builds.
+type Build struct {
+	ID        string     `json:"id,omitempty"`
+	AppID     string     `json:"app,omitempty"`
+	Rev       string     `json:"rev,omitempty"`
+	State     BuildState `json:"state,omitempty"`
+	ReleaseID string     `json:"release,omitempty"`
+	Error     string     `json:"error,omitempty"`
+
+	// CacheKey identifies the build cache used by the build, which is
+	// derived from the slugbuilder image, the buildpack and the app's
+	// dependency manifests, and CacheHit is whether a cache with the key
+	// already existed.
+	CacheKey string `json:"cache_key,omitempty"`
+	CacheHit bool   `json:"cache_hit,omitempty"`
+
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+
+	// QueueDuration is the time the build waited for a build slot, and
+	// Duration the time from then until it finished.
+	QueueDuration *time.Duration `json:"queue_duration,omitempty"`
+	Duration      *time.Duration `json:"duration,omitempty"`
+}
+
+// Pipeline is an ordered list of apps which releases are promoted through,
+// e.g. from a staging app to a production app.
+type Pipeline struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+
+	// Apps contains the IDs of the pipeline's apps in the order releases
+	// are promoted through them (app names are converted to IDs when the
+	// pipeline is saved)
+	Apps []string `json:"apps,omitempty"`
+
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Promotion records a release of one of a pipeline's apps being promoted to
+// the next app in the pipeline. The new release has the artifacts and
+// processes of the promoted release, but keeps the env, process env and
+// process resources of the next app's current release, and is deployed with the next app's
+// strategy and formation.
+type Promotion struct {
+	ID            string     `json:"id,omitempty"`
+	PipelineID    string     `json:"pipeline,omitempty"`
+	FromAppID     string     `json:"from_app,omitempty"`
+	FromReleaseID string     `json:"from_release,omitempty"`
+	ToAppID       string     `json:"to_app,omitempty"`
+	ReleaseID     string     `json:"release,omitempty"`
+	DeploymentID  string     `json:"deployment,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+}
+
+const (
+	// PromotedFromAppMetaKey and PromotedFromReleaseMetaKey are the meta
+	// keys of a promoted release which record where it was promoted from
+	PromotedFromAppMetaKey     = "promotion.from_app"
+	PromotedFromReleaseMetaKey = "promotion.from_release"
+)
+
+// Role determines which API calls a user can make.
+type Role string
+
+const (
+	// RoleAdmin can make any API call
+	RoleAdmin Role = "admin"
+
+	// RoleDeployer can create artifacts and releases, and make any call
+	// scoped to the apps listed on the user, but can only read those apps
+	// and the releases, artifacts, deployments and events belonging to them
+	RoleDeployer Role = "deployer"
+
+	// RoleReadOnly can read anything except backups and users
+	RoleReadOnly Role = "read-only"
+)
+
+// User is a person or system which authenticates with the controller using
+// one of its tokens.
+type User struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	Role Role   `json:"role,omitempty"`
+
+	// Apps contains the IDs of the apps a deployer can manage (app names
+	// are converted to IDs when the user is saved)
+	Apps []string `json:"apps,omitempty"`
+
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// HasApp returns whether the app with the given ID is one of the user's apps
+func (u *User) HasApp(appID string) bool {
+	for _, id := range u.Apps {
+		if id == appID {
+			return true
+		}
+	}
+	return false
+}
+
+// CanReadApp returns whether the user's role permits reading the app with the
+// given ID, such as fetching its code from gitreceive or its images from
+// docker-receive
+func (u *User) CanReadApp(appID string) bool {
+	switch u.Role {
+	case RoleAdmin, RoleReadOnly:
+		return true
+	case RoleDeployer:
+		return u.HasApp(appID)
+	default:
+		return false
+	}
+}
+
+// CanDeployApp returns whether the user's role permits deploying the app with
+// the given ID, such as pushing its code to gitreceive or its images to
+// docker-receive
+func (u *User) CanDeployApp(appID string) bool {
+	return u.Role == RoleAdmin || u.Role == RoleDeployer && u.HasApp(appID)
+}
+
+// UserToken is an API key which authenticates requests as a user.
+type UserToken struct {
+	ID          string `json:"id,omitempty"`
+	UserID      string `json:"user,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Token is the secret used as the API key, and is only returned when
+	// the token is created (just a hash of it is stored)
+	Token string `json:"token,omitempty"`
+
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// AuditEvent records a mutating API call, with the user which made it if
+// it was authenticated with a user token rather than a cluster key.
+type AuditEvent struct {
+	UserID    string `json:"user_id,omitempty"`
+	UserName  string `json:"user_name,omitempty"`
+	TokenID   string `json:"token_id,omitempty"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
 const DefaultDeployTimeout = 120 // seconds
 
+const (
+	DefaultCanaryCount  = 1
+	DefaultCanaryPeriod = 60 // seconds
+
+	DefaultPreviewPeriod = 60  // seconds
+	DefaultWarmPeriod    = 600 // seconds
+)
+
+// DeployOptions contains strategy specific deployment options which are set
+// on an app and copied to each of its deployments
+type DeployOptions struct {
+	// CanaryCount is the number of jobs of each process type the canary
+	// strategy starts before the observation period
+	CanaryCount int `json:"canary_count,omitempty"`
+
+	// CanaryPeriod is the number of seconds the canary strategy observes
+	// the canary jobs before promoting the deployment
+	CanaryPeriod int32 `json:"canary_period,omitempty"`
+
+	// PreviewPeriod is the number of seconds the blue-green strategy serves
+	// the new release on preview routes before switching the app's routes
+	PreviewPeriod int32 `json:"preview_period,omitempty"`
+
+	// WarmPeriod is the number of seconds the blue-green strategy keeps the
+	// old formation running after switching the app's routes so that the
+	// deployment can be rolled back
+	WarmPeriod int32 `json:"warm_period,omitempty"`
+}
+
+func (o *DeployOptions) Canary() (count int, period time.Duration) {
+	count, period = DefaultCanaryCount, DefaultCanaryPeriod*time.Second
+	if o == nil {
+		return
+	}
+	if o.CanaryCount > 0 {
+		count = o.CanaryCount
+	}
+	if o.CanaryPeriod > 0 {
+		period = time.Duration(o.CanaryPeriod) * time.Second
+	}
+	return
+}
+
+func (o *DeployOptions) BlueGreen() (preview, warm time.Duration) {
+	preview, warm = DefaultPreviewPeriod*time.Second, DefaultWarmPeriod*time.Second
+	if o == nil {
+		return
+	}
+	if o.PreviewPeriod > 0 {
+		preview = time.Duration(o.PreviewPeriod) * time.Second
+	}
+	if o.WarmPeriod > 0 {
+		warm = time.Duration(o.WarmPeriod) * time.Second
+	}
+	return
+}
+
+// AutoscalePolicies maps process types to their autoscale policy
+type AutoscalePolicies map[string]*AutoscalePolicy
+
+// AutoscalePolicy bounds the number of jobs the autoscaler runs for a process
+// type, and sets the per-job utilisation it aims for. A policy with no
+// targets keeps the process type within its bounds without otherwise
+// scaling it.
+type AutoscalePolicy struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+
+	// TargetCPU is the average CPU usage of each job as a percentage of
+	// its CPU limit
+	TargetCPU int `json:"target_cpu,omitempty"`
+
+	// TargetMemory is the average memory usage of each job as a
+	// percentage of its memory limit
+	TargetMemory int `json:"target_memory,omitempty"`
+
+	// TargetRequestRate is the average number of HTTP requests per second
+	// routed to each job
+	TargetRequestRate int `json:"target_request_rate,omitempty"`
+}
+
+// PreviewDomain returns the domain the blue-green strategy uses to preview
+// the new release of an app routed at the given domain, for example
+// "foo.example.com" becomes "foo-preview.example.com"
+func PreviewDomain(domain string) string {
+	parts := strings.SplitN(domain, ".", 2)
+	parts[0] += "-preview"
+	return strings.Join(parts, ".")
+}
+
 type Deployment struct {
 	ID            string         `json:"id,omitempty"`
 	AppID         string         `json:"app,omitempty"`
@@ -261,6 +698,7 @@ type Deployment struct {
 	Status        string         `json:"status,omitempty"`
 	Processes     map[string]int `json:"processes,omitempty"`
 	DeployTimeout int32          `json:"deploy_timeout,omitempty"`
+	DeployOptions *DeployOptions `json:"deploy_options,omitempty"`
 	CreatedAt     *time.Time     `json:"created_at,omitempty"`
 	FinishedAt    *time.Time     `json:"finished_at,omitempty"`
 }
@@ -337,6 +775,9 @@ type LogOpts struct {
 	JobID       string
 	Lines       *int
 	ProcessType *string
+	Since       time.Time
+	Until       time.Time
+	Query       string
 }
 
 type EventType string
@@ -362,6 +803,14 @@ const (
 	EventTypeDomainMigration      EventType = "domain_migration"
 	EventTypeClusterBackup        EventType = "cluster_backup"
 	EventTypeAppGarbageCollection EventType = "app_garbage_collection"
+	EventTypeSchedule             EventType = "schedule"
+	EventTypeAudit                EventType = "audit"
+	EventTypeLogDrain             EventType = "log_drain"
+	EventTypeLogDrainDeletion     EventType = "log_drain_deletion"
+	EventTypeBuild                EventType = "build"
+	EventTypePipeline             EventType = "pipeline"
+	EventTypePipelineDeletion     EventType = "pipeline_deletion"
+	EventTypePromotion            EventType = "promotion"
 )
 
 type Event struct {
@@ -378,6 +827,9 @@ type Scale struct {
 	PrevProcesses map[string]int `json:"prev_processes,omitempty"`
 	Processes     map[string]int `json:"processes"`
 	ReleaseID     string         `json:"release"`
+
+	// Reason is set when the formation is scaled by the autoscaler
+	Reason string `json:"reason,omitempty"`
 }
 
 type AppRelease struct {
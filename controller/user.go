@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/flynn/flynn/controller/schema"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+)
+
+type UserRepo struct {
+	db *postgres.DB
+}
+
+func NewUserRepo(db *postgres.DB) *UserRepo {
+	return &UserRepo{db: db}
+}
+
+// validate checks the user's apps are only set for deployers, converting
+// app names to IDs
+func (r *UserRepo) validate(u *ct.User) error {
+	if u.Role != ct.RoleDeployer {
+		if len(u.Apps) > 0 {
+			return ct.ValidationError{Field: "apps", Message: "can only be set for deployers"}
+		}
+		return nil
+	}
+	if len(u.Apps) == 0 {
+		return ct.ValidationError{Field: "apps", Message: "must be set for deployers"}
+	}
+	ids := make([]string, 0, len(u.Apps))
+	seen := make(map[string]struct{}, len(u.Apps))
+	for _, nameOrID := range u.Apps {
+		app, err := selectApp(r.db, nameOrID, false)
+		if err == ErrNotFound {
+			return ct.ValidationError{Field: "apps", Message: fmt.Sprintf("app %q does not exist", nameOrID)}
+		} else if err != nil {
+			return err
+		}
+		if _, ok := seen[app.ID]; ok {
+			continue
+		}
+		seen[app.ID] = struct{}{}
+		ids = append(ids, app.ID)
+	}
+	u.Apps = ids
+	return nil
+}
+
+func (r *UserRepo) Add(u *ct.User) error {
+	if err := r.validate(u); err != nil {
+		return err
+	}
+	if u.ID == "" {
+		u.ID = random.UUID()
+	}
+	err := r.db.QueryRow("user_insert", u.ID, u.Name, string(u.Role), u.Apps).Scan(&u.CreatedAt, &u.UpdatedAt)
+	if postgres.IsUniquenessError(err, "users_name_idx") {
+		return httphelper.ObjectExistsErr(fmt.Sprintf("user %q already exists", u.Name))
+	}
+	return err
+}
+
+func (r *UserRepo) Update(u *ct.User) error {
+	if err := r.validate(u); err != nil {
+		return err
+	}
+	err := r.db.QueryRow("user_update", u.ID, string(u.Role), u.Apps).Scan(&u.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Get returns the user with the given ID or name
+func (r *UserRepo) Get(id string) (*ct.User, error) {
+	if idPattern.MatchString(id) {
+		return scanUser(r.db.QueryRow("user_select_by_name_or_id", id, id))
+	}
+	return scanUser(r.db.QueryRow("user_select_by_name", id))
+}
+
+func (r *UserRepo) List() ([]*ct.User, error) {
+	rows, err := r.db.Query("user_list")
+	if err != nil {
+		return nil, err
+	}
+	users := []*ct.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// Remove deletes the user along with all of its tokens
+func (r *UserRepo) Remove(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.Exec("user_token_delete_by_user", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Exec("user_delete", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddToken generates a new token for the user, only storing a hash of it
+func (r *UserRepo) AddToken(u *ct.User, t *ct.UserToken) error {
+	t.ID = random.UUID()
+	t.UserID = u.ID
+	t.Token = random.Hex(20)
+	return r.db.QueryRow("user_token_insert", t.ID, t.UserID, hashToken(t.Token), t.Description).Scan(&t.CreatedAt)
+}
+
+func (r *UserRepo) ListTokens(userID string) ([]*ct.UserToken, error) {
+	rows, err := r.db.Query("user_token_list", userID)
+	if err != nil {
+		return nil, err
+	}
+	tokens := []*ct.UserToken{}
+	for rows.Next() {
+		t := &ct.UserToken{}
+		var description *string
+		if err := rows.Scan(&t.ID, &t.UserID, &description, &t.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if description != nil {
+			t.Description = *description
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *UserRepo) RemoveToken(userID, tokenID string) error {
+	if !idPattern.MatchString(tokenID) {
+		return ErrNotFound
+	}
+	return r.db.Exec("user_token_delete", tokenID, userID)
+}
+
+// Authenticate returns the user the given token belongs to along with the
+// token ID, or ErrNotFound if there is no such token
+func (r *UserRepo) Authenticate(token string) (*ct.User, string, error) {
+	var tokenID string
+	u, err := scanUser(r.db.QueryRow("user_select_by_token_hash", hashToken(token)), &tokenID)
+	if err != nil {
+		return nil, "", err
+	}
+	return u, tokenID, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanUser(s postgres.Scanner, extra ...interface{}) (*ct.User, error) {
+	u := &ct.User{}
+	var role string
+	dest := append([]interface{}{&u.ID, &u.Name, &role, &u.Apps, &u.CreatedAt, &u.UpdatedAt}, extra...)
+	err := s.Scan(dest...)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	u.Role = ct.Role(role)
+	return u, nil
+}
+
+func (c *controllerAPI) getUser(ctx context.Context) (*ct.User, error) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	return c.userRepo.Get(params.ByName("users_id"))
+}
+
+func (c *controllerAPI) CreateUser(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var user ct.User
+	if err := httphelper.DecodeJSON(req, &user); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := schema.Validate(user); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.userRepo.Add(&user); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &user)
+}
+
+func (c *controllerAPI) GetUser(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	user, err := c.getUser(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, user)
+}
+
+func (c *controllerAPI) ListUsers(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	list, err := c.userRepo.List()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
+
+func (c *controllerAPI) UpdateUser(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	existing, err := c.getUser(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var user ct.User
+	if err := httphelper.DecodeJSON(req, &user); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	user.ID = existing.ID
+	user.Name = existing.Name
+	user.CreatedAt = existing.CreatedAt
+	if user.Role == "" {
+		user.Role = existing.Role
+	}
+	if err := schema.Validate(user); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.userRepo.Update(&user); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &user)
+}
+
+func (c *controllerAPI) DeleteUser(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	user, err := c.getUser(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.userRepo.Remove(user.ID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func (c *controllerAPI) CreateUserToken(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	user, err := c.getUser(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var token ct.UserToken
+	if err := httphelper.DecodeJSON(req, &token); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.userRepo.AddToken(user, &token); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &token)
+}
+
+func (c *controllerAPI) ListUserTokens(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	user, err := c.getUser(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	list, err := c.userRepo.ListTokens(user.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
+
+func (c *controllerAPI) DeleteUserToken(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	user, err := c.getUser(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	if err := c.userRepo.RemoveToken(user.ID, params.ByName("tokens_id")); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// GetCurrentUser returns the user the request was authenticated as, which
+// is an admin with no ID or name when using a cluster key
+func (c *controllerAPI) GetCurrentUser(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	user, _, err := c.auth.authenticate(req)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, user)
+}
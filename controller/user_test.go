@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) createTestUser(c *C, user *ct.User) (*ct.User, controller.Client) {
+	c.Assert(s.c.CreateUser(user), IsNil)
+	token := &ct.UserToken{Description: "test"}
+	c.Assert(s.c.CreateUserToken(user.ID, token), IsNil)
+	c.Assert(token.Token, Not(Equals), "")
+	client, err := controller.NewClient(s.srv.URL, token.Token)
+	c.Assert(err, IsNil)
+	return user, client
+}
+
+func (s *S) TestUsers(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "users-app"})
+	user := &ct.User{Name: "users-deployer", Role: ct.RoleDeployer, Apps: []string{app.Name, app.ID}}
+	c.Assert(s.c.CreateUser(user), IsNil)
+	c.Assert(user.ID, Not(Equals), "")
+	c.Assert(user.Apps, DeepEquals, []string{app.ID})
+
+	// names are unique
+	err := s.c.CreateUser(&ct.User{Name: user.Name, Role: ct.RoleReadOnly})
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+
+	// apps can only be given to deployers
+	err = s.c.CreateUser(&ct.User{Name: "users-invalid", Role: ct.RoleReadOnly, Apps: []string{app.ID}})
+	e, ok := err.(hh.JSONError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, hh.ValidationErrorCode)
+
+	gotUser, err := s.c.GetUser(user.Name)
+	c.Assert(err, IsNil)
+	c.Assert(gotUser.ID, Equals, user.ID)
+
+	user.Role = ct.RoleAdmin
+	user.Apps = nil
+	c.Assert(s.c.UpdateUser(user), IsNil)
+	gotUser, err = s.c.GetUser(user.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotUser.Role, Equals, ct.RoleAdmin)
+
+	token := &ct.UserToken{Description: "laptop"}
+	c.Assert(s.c.CreateUserToken(user.ID, token), IsNil)
+	tokens, err := s.c.UserTokenList(user.ID)
+	c.Assert(err, IsNil)
+	c.Assert(tokens, HasLen, 1)
+	c.Assert(tokens[0].ID, Equals, token.ID)
+	c.Assert(tokens[0].Description, Equals, "laptop")
+	c.Assert(tokens[0].Token, Equals, "")
+
+	// the token authenticates as the user until it is removed
+	client, err := controller.NewClient(s.srv.URL, token.Token)
+	c.Assert(err, IsNil)
+	current, err := client.CurrentUser()
+	c.Assert(err, IsNil)
+	c.Assert(current.ID, Equals, user.ID)
+	c.Assert(s.c.DeleteUserToken(user.ID, token.ID), IsNil)
+	_, err = client.CurrentUser()
+	c.Assert(err, NotNil)
+
+	c.Assert(s.c.DeleteUser(user.ID), IsNil)
+	_, err = s.c.GetUser(user.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
+func (s *S) TestCurrentUserClusterKey(c *C) {
+	user, err := s.c.CurrentUser()
+	c.Assert(err, IsNil)
+	c.Assert(user.ID, Equals, "")
+	c.Assert(user.Role, Equals, ct.RoleAdmin)
+}
+
+func (s *S) TestAuthenticateUser(c *C) {
+	user, _ := s.createTestUser(c, &ct.User{Name: "authenticate-user", Role: ct.RoleReadOnly})
+	token := &ct.UserToken{Description: "test"}
+	c.Assert(s.c.CreateUserToken(user.ID, token), IsNil)
+
+	// services such as gitreceive authenticate user tokens through the
+	// controller
+	authenticated, err := controller.AuthenticateUser(s.srv.URL, token.Token, http.DefaultClient)
+	c.Assert(err, IsNil)
+	c.Assert(authenticated.ID, Equals, user.ID)
+	c.Assert(authenticated.Role, Equals, ct.RoleReadOnly)
+
+	authenticated, err = controller.AuthenticateUser(s.srv.URL, authKey, http.DefaultClient)
+	c.Assert(err, IsNil)
+	c.Assert(authenticated.Role, Equals, ct.RoleAdmin)
+
+	_, err = controller.AuthenticateUser(s.srv.URL, "invalid-token", http.DefaultClient)
+	c.Assert(err, Equals, controller.ErrUnauthorized)
+}
+
+func (s *S) TestReadOnlyUser(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "read-only-user-app"})
+	_, client := s.createTestUser(c, &ct.User{Name: "read-only-user", Role: ct.RoleReadOnly})
+
+	gotApp, err := client.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.ID, Equals, app.ID)
+
+	err = client.CreateApp(&ct.App{Name: "read-only-user-new-app"})
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+	err = client.UpdateApp(&ct.App{ID: app.ID, Strategy: "one-by-one"})
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+
+	// only admins can manage users
+	_, err = client.UserList()
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+}
+
+func (s *S) TestDeployerUser(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "deployer-user-app"})
+	other := s.createTestApp(c, &ct.App{Name: "deployer-user-other-app"})
+	_, client := s.createTestUser(c, &ct.User{Name: "deployer-user", Role: ct.RoleDeployer, Apps: []string{app.ID}})
+
+	c.Assert(client.UpdateApp(&ct.App{ID: app.ID, Strategy: "one-by-one"}), IsNil)
+	c.Assert(client.UpdateApp(&ct.App{ID: app.Name, Strategy: "all-at-once"}), IsNil)
+	c.Assert(client.CreateArtifact(&ct.Artifact{Type: host.ArtifactTypeDocker, URI: "https://example.com/deployer"}), IsNil)
+
+	err := client.UpdateApp(&ct.App{ID: other.ID, Strategy: "one-by-one"})
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+	err = client.CreateApp(&ct.App{Name: "deployer-user-new-app"})
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+}
+
+func (s *S) TestDeployerUserReads(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "deployer-reads-app"})
+	other := s.createTestApp(c, &ct.App{Name: "deployer-reads-other-app"})
+	release := s.createTestRelease(c, &ct.Release{})
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+	otherRelease := s.createTestRelease(c, &ct.Release{Env: map[string]string{"SECRET": "other"}})
+	c.Assert(s.c.SetAppRelease(other.ID, otherRelease.ID), IsNil)
+	_, client := s.createTestUser(c, &ct.User{Name: "deployer-reads-user", Role: ct.RoleDeployer, Apps: []string{app.ID}})
+
+	// the user's apps and their releases can be read
+	gotApp, err := client.GetApp(app.Name)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.ID, Equals, app.ID)
+	gotRelease, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, release.ID)
+	gotRelease, err = client.GetRelease(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, release.ID)
+
+	// other apps and their releases can't be read
+	_, err = client.GetApp(other.ID)
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+	_, err = client.GetAppRelease(other.ID)
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+	_, err = client.GetRelease(otherRelease.ID)
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+	_, err = client.GetArtifact(otherRelease.ArtifactIDs[0])
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+
+	// nor can other apps' events
+	_, err = client.ListEvents(ct.ListEventsOptions{AppID: other.ID})
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+	otherEvents, err := s.c.ListEvents(ct.ListEventsOptions{AppID: other.ID})
+	c.Assert(err, IsNil)
+	c.Assert(otherEvents, Not(HasLen), 0)
+	_, err = client.GetEvent(otherEvents[0].ID)
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+
+	// listing events and apps only includes the user's apps
+	events, err := client.ListEvents(ct.ListEventsOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(events, Not(HasLen), 0)
+	for _, e := range events {
+		c.Assert(e.AppID, Equals, app.ID)
+	}
+	apps, err := client.AppList()
+	c.Assert(err, IsNil)
+	c.Assert(apps, HasLen, 1)
+	c.Assert(apps[0].ID, Equals, app.ID)
+
+	// the global lists aren't readable
+	_, err = client.ReleaseList()
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+	_, err = client.FormationListActive()
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+}
+
+func (s *S) TestAuditEvents(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "audit-events-app"})
+	user, client := s.createTestUser(c, &ct.User{Name: "audit-events-user", Role: ct.RoleDeployer, Apps: []string{app.ID}})
+
+	c.Assert(client.UpdateApp(&ct.App{ID: app.ID, Strategy: "one-by-one"}), IsNil)
+	err := client.UpdateApp(&ct.App{ID: "audit-events-other-app", Strategy: "one-by-one"})
+	c.Assert(hh.IsForbiddenError(err), Equals, true)
+
+	events, err := s.c.ListEvents(ct.ListEventsOptions{
+		ObjectTypes: []ct.EventType{ct.EventTypeAudit},
+		ObjectID:    user.ID,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 2)
+
+	// events are listed newest first
+	var audit ct.AuditEvent
+	c.Assert(json.Unmarshal(events[0].Data, &audit), IsNil)
+	c.Assert(audit.UserName, Equals, user.Name)
+	c.Assert(audit.Method, Equals, "POST")
+	c.Assert(audit.Path, Equals, "/apps/audit-events-other-app")
+	c.Assert(audit.Status, Equals, 403)
+
+	c.Assert(json.Unmarshal(events[1].Data, &audit), IsNil)
+	c.Assert(events[1].AppID, Equals, app.ID)
+	c.Assert(audit.Path, Equals, "/apps/"+app.ID)
+	c.Assert(audit.Status, Equals, 200)
+}
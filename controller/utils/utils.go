@@ -1,7 +1,12 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"regexp"
 	"strings"
 	"time"
@@ -173,6 +178,9 @@ type HostClient interface {
 	ListJobs() (map[string]host.ActiveJob, error)
 	StreamEvents(id string, ch chan *host.Event) (stream.Stream, error)
 	GetStatus() (*host.HostStatus, error)
+	MarkDrained() error
+	DestroyVolume(string) error
+	PullVolume(receiveVolID, sourceHostID, sourceVolID string) (*volume.VolumeTransfer, error)
 }
 
 type ClusterClient interface {
@@ -227,6 +235,52 @@ func (c clusterClientWrapper) StreamHostEvents(ch chan *discoverd.Event) (stream
 
 var AppNamePattern = regexp.MustCompile(`^[a-z\d]+(-[a-z\d]+)*$`)
 
+// maxReviewAppNameLen keeps the default route domain of review apps within
+// the length limit of a DNS label
+const maxReviewAppNameLen = 63
+
+var invalidAppNameChars = regexp.MustCompile(`[^a-z\d]+`)
+
+// ReviewAppName returns the name of the review app for a branch of the given
+// app, which is the app's name followed by the branch with any characters
+// not allowed in app names replaced. Names which would be too long are
+// shortened and suffixed with a hash of the branch to keep them unique.
+func ReviewAppName(parent, branch string) string {
+	slug := strings.Trim(invalidAppNameChars.ReplaceAllString(strings.ToLower(branch), "-"), "-")
+	name := parent + "-" + slug
+	if slug != "" && len(name) <= maxReviewAppNameLen {
+		return name
+	}
+	sum := sha256.Sum256([]byte(branch))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+	if max := maxReviewAppNameLen - len(suffix); len(name) > max {
+		name = name[:max]
+	}
+	return strings.TrimRight(name, "-") + suffix
+}
+
+// ValidWebhookSignature returns whether the signature of a webhook payload,
+// as sent in GitHub's X-Hub-Signature-256 ("sha256=<hex HMAC>") or
+// X-Hub-Signature ("sha1=<hex HMAC>") header, was created with the secret
+func ValidWebhookSignature(signature string, payload, secret []byte) bool {
+	var newHash func() hash.Hash
+	switch {
+	case strings.HasPrefix(signature, "sha256="):
+		newHash = sha256.New
+	case strings.HasPrefix(signature, "sha1="):
+		newHash = sha1.New
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(signature[strings.Index(signature, "=")+1:])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, secret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
 func FormationTagsEqual(a, b map[string]map[string]string) bool {
 	if len(a) != len(b) {
 		return false
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"testing"
+)
+
+func TestReviewAppName(t *testing.T) {
+	for _, x := range []struct {
+		parent, branch, name string
+	}{
+		{"my-app", "feature", "my-app-feature"},
+		{"my-app", "Feature/Login_Page", "my-app-feature-login-page"},
+		{"my-app", "--fix--", "my-app-fix"},
+		{"my-app", "___", "my-app-bda25155"},
+		{"my-app", strings.Repeat("x", 60), "my-app-" + strings.Repeat("x", 47) + "-42f2d973"},
+	} {
+		name := ReviewAppName(x.parent, x.branch)
+		if name != x.name {
+			t.Errorf("expected review app name for %q to be %q, got %q", x.branch, x.name, name)
+		}
+		if len(name) > maxReviewAppNameLen || !AppNamePattern.MatchString(name) {
+			t.Errorf("invalid review app name %q", name)
+		}
+	}
+}
+
+func TestValidWebhookSignature(t *testing.T) {
+	secret := []byte("secret")
+	payload := []byte(`{"ref":"refs/heads/master"}`)
+	sign := func(newHash func() hash.Hash, key, data []byte) string {
+		mac := hmac.New(newHash, key)
+		mac.Write(data)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	for _, x := range []struct {
+		signature string
+		valid     bool
+	}{
+		{"sha256=" + sign(sha256.New, secret, payload), true},
+		{"sha1=" + sign(sha1.New, secret, payload), true},
+		{"sha256=" + sign(sha256.New, []byte("other"), payload), false},
+		{"sha256=" + sign(sha256.New, secret, []byte("{}")), false},
+		{"sha1=" + sign(sha256.New, secret, payload), false},
+		{sign(sha256.New, secret, payload), false},
+		{"sha256=not-hex", false},
+		{"sha256=", false},
+		{"", false},
+	} {
+		if valid := ValidWebhookSignature(x.signature, payload, secret); valid != x.valid {
+			t.Errorf("expected ValidWebhookSignature(%q) to be %t, got %t", x.signature, x.valid, valid)
+		}
+	}
+}
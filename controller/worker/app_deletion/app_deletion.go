@@ -145,6 +145,30 @@ func (c *context) HandleAppDeletion(job *que.Job) (err error) {
 		tx.Rollback()
 		return err
 	}
+	err = tx.Exec("schedule_delete_by_app", app.ID)
+	if err != nil {
+		log.Error("error executing schedule deletion query", "err", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Exec("log_drain_delete_by_app", app.ID)
+	if err != nil {
+		log.Error("error executing log drain deletion query", "err", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Exec("webhook_secret_delete", app.ID)
+	if err != nil {
+		log.Error("error executing webhook secret deletion query", "err", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Exec("pipeline_remove_app", app.ID)
+	if err != nil {
+		log.Error("error executing pipeline app removal query", "err", err)
+		tx.Rollback()
+		return err
+	}
 	return tx.Commit()
 }
 
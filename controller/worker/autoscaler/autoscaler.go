@@ -0,0 +1,416 @@
+// Package autoscaler periodically scales the process types of apps which have
+// autoscale policies, based on the resource usage of their jobs and the rate
+// of HTTP requests routed to them.
+package autoscaler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/host/resource"
+	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	routerc "github.com/flynn/flynn/router/client"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// Interval is how often the autoscaler checks apps
+	Interval = 30 * time.Second
+
+	// ScaleDownDelay is how long a process type must be watched without
+	// being scaled before it is scaled down, so that a brief lull does not
+	// remove capacity which is about to be needed again, and a process type
+	// is not scaled down from the first samples taken after the autoscaler
+	// starts or becomes the leader
+	ScaleDownDelay = 5 * time.Minute
+
+	// tolerance is how far a metric can be from its target before it
+	// causes the process type to be scaled
+	tolerance = 0.1
+)
+
+type Autoscaler struct {
+	client  controller.Client
+	cluster *cluster.Client
+	logger  log15.Logger
+
+	// cpu contains the previous CPU usage reading of each job, which is
+	// needed to calculate CPU usage over an interval
+	cpu map[string]*host.JobStats
+
+	// requests contains the previous request count of each service summed
+	// across all routers
+	requests map[string]requestSample
+
+	// watchedAt contains the time each process type (keyed by
+	// "appID:type") was first checked since becoming the leader
+	watchedAt map[string]time.Time
+
+	// scaledAt contains the time each process type (keyed by
+	// "appID:type") was last scaled
+	scaledAt map[string]time.Time
+}
+
+type requestSample struct {
+	count uint64
+	time  time.Time
+}
+
+func New(client controller.Client, logger log15.Logger) *Autoscaler {
+	return &Autoscaler{
+		client:    client,
+		cluster:   cluster.NewClient(),
+		logger:    logger.New("component", "autoscaler"),
+		cpu:       make(map[string]*host.JobStats),
+		requests:  make(map[string]requestSample),
+		watchedAt: make(map[string]time.Time),
+		scaledAt:  make(map[string]time.Time),
+	}
+}
+
+// Run checks apps every Interval while isLeader returns true, so that only a
+// single worker scales apps at a time.
+func (a *Autoscaler) Run(isLeader func() bool) {
+	log := a.logger.New("fn", "Run")
+	for range time.Tick(Interval) {
+		if !isLeader() {
+			// drop samples so stale readings are not used if this
+			// worker becomes the leader again, and forget when
+			// process types were watched so that they are watched
+			// for ScaleDownDelay again before being scaled down
+			a.cpu = make(map[string]*host.JobStats)
+			a.requests = make(map[string]requestSample)
+			a.watchedAt = make(map[string]time.Time)
+			continue
+		}
+		if err := a.Check(); err != nil {
+			log.Error("error checking apps", "err", err)
+		}
+	}
+}
+
+// Check scales the process types of each app with autoscale policies.
+func (a *Autoscaler) Check() error {
+	log := a.logger.New("fn", "Check")
+
+	apps, err := a.client.AppList()
+	if err != nil {
+		return err
+	}
+	var scaled []*ct.App
+	for _, app := range apps {
+		if len(app.Autoscale) > 0 && app.ReleaseID != "" {
+			scaled = append(scaled, app)
+		}
+	}
+	if len(scaled) == 0 {
+		return nil
+	}
+
+	jobs, err := a.client.JobListActive()
+	if err != nil {
+		return err
+	}
+	appJobs := make(map[string][]*ct.Job)
+	for _, job := range jobs {
+		if job.State == ct.JobStateUp {
+			appJobs[job.AppID] = append(appJobs[job.AppID], job)
+		}
+	}
+
+	hosts, err := a.cluster.Hosts()
+	if err != nil {
+		return err
+	}
+	hostClients := make(map[string]*cluster.Host, len(hosts))
+	for _, h := range hosts {
+		hostClients[h.ID()] = h
+	}
+
+	rates, err := a.requestRates()
+	if err != nil {
+		// request rates are optional, so just log the error
+		log.Error("error getting request rates", "err", err)
+	}
+
+	seen := make(map[string]struct{})
+	for _, app := range scaled {
+		if err := a.checkApp(app, appJobs[app.ID], hostClients, rates, seen); err != nil {
+			log.Error("error checking app", "app_id", app.ID, "err", err)
+		}
+	}
+
+	// forget jobs which are no longer running
+	for id := range a.cpu {
+		if _, ok := seen[id]; !ok {
+			delete(a.cpu, id)
+		}
+	}
+	return nil
+}
+
+func (a *Autoscaler) checkApp(app *ct.App, jobs []*ct.Job, hosts map[string]*cluster.Host, rates map[string]float64, seen map[string]struct{}) error {
+	log := a.logger.New("fn", "checkApp", "app_id", app.ID, "app_name", app.Name)
+
+	// leave apps which are being deployed to the deployment
+	deployments, err := a.client.DeploymentList(app.ID)
+	if err != nil {
+		return err
+	}
+	if len(deployments) > 0 && deployments[0].FinishedAt == nil {
+		log.Info("app has a deployment in progress, skipping")
+		return nil
+	}
+
+	release, err := a.client.GetAppRelease(app.ID)
+	if err != nil {
+		return err
+	}
+	formation, err := a.client.GetFormation(app.ID, release.ID)
+	if err == controller.ErrNotFound {
+		formation = &ct.Formation{AppID: app.ID, ReleaseID: release.ID}
+	} else if err != nil {
+		return err
+	}
+
+	processes := make(map[string]int, len(formation.Processes))
+	for typ, count := range formation.Processes {
+		processes[typ] = count
+	}
+	var reasons []string
+	for typ, policy := range app.Autoscale {
+		proc, ok := release.Processes[typ]
+		if !ok || policy == nil {
+			continue
+		}
+		var typeJobs []*ct.Job
+		for _, job := range jobs {
+			if job.ReleaseID == release.ID && job.Type == typ {
+				typeJobs = append(typeJobs, job)
+			}
+		}
+		m := a.metrics(proc, typeJobs, hosts, seen)
+		if proc.Service != "" {
+			if rate, ok := rates[proc.Service]; ok {
+				m.RequestRate = &rate
+			}
+		}
+
+		key := app.ID + ":" + typ
+		now := time.Now()
+		if _, ok := a.watchedAt[key]; !ok {
+			a.watchedAt[key] = now
+		}
+
+		current := processes[typ]
+		desired, reason := Desired(policy, current, m)
+		if desired == current {
+			continue
+		}
+		if desired < current && desired >= policy.Min && !a.canScaleDown(key, now) {
+			continue
+		}
+		log.Info("scaling process type", "type", typ, "from", current, "to", desired, "reason", reason)
+		processes[typ] = desired
+		reasons = append(reasons, fmt.Sprintf("%s: %s", typ, reason))
+		a.scaledAt[key] = now
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	sort.Strings(reasons)
+	return a.scale(formation, processes, strings.Join(reasons, "; "))
+}
+
+// canScaleDown returns whether the process type with the given key has been
+// watched for ScaleDownDelay without being scaled. Formations which were
+// scaled manually or before a restart are only scaled down once the process
+// type has been watched for ScaleDownDelay.
+func (a *Autoscaler) canScaleDown(key string, now time.Time) bool {
+	watchedAt, ok := a.watchedAt[key]
+	if !ok || now.Sub(watchedAt) < ScaleDownDelay {
+		return false
+	}
+	return now.Sub(a.scaledAt[key]) >= ScaleDownDelay
+}
+
+// scale updates the formation through the controller, which validates it
+// and emits a scale event with the reason.
+func (a *Autoscaler) scale(formation *ct.Formation, processes map[string]int, reason string) error {
+	return a.client.PutFormation(&ct.Formation{
+		AppID:       formation.AppID,
+		ReleaseID:   formation.ReleaseID,
+		Processes:   processes,
+		Tags:        formation.Tags,
+		ScaleReason: reason,
+	})
+}
+
+// metrics returns the average CPU and memory usage of the given jobs as a
+// percentage of their limits. CPU usage is only known for jobs which were
+// also running when the autoscaler last checked.
+func (a *Autoscaler) metrics(proc ct.ProcessType, jobs []*ct.Job, hosts map[string]*cluster.Host, seen map[string]struct{}) *Metrics {
+	log := a.logger.New("fn", "metrics")
+
+	limits := proc.Resources
+	resource.SetDefaults(&limits)
+	cpuLimit := float64(*limits[resource.TypeCPU].Limit) / 1000
+	memoryLimit := float64(*limits[resource.TypeMemory].Limit)
+
+	var cpuTotal, memoryTotal float64
+	var cpuCount, memoryCount int
+	for _, job := range jobs {
+		h, ok := hosts[job.HostID]
+		if !ok {
+			continue
+		}
+		stats, err := h.JobStats(job.ID)
+		if err != nil {
+			log.Error("error getting job stats", "job_id", job.ID, "err", err)
+			continue
+		}
+		seen[job.ID] = struct{}{}
+
+		memoryTotal += float64(stats.MemoryUsage) / memoryLimit * 100
+		memoryCount++
+
+		if prev, ok := a.cpu[job.ID]; ok && stats.CPUUsage >= prev.CPUUsage {
+			if elapsed := stats.Time.Sub(prev.Time); elapsed > 0 {
+				used := float64(stats.CPUUsage-prev.CPUUsage) / float64(elapsed)
+				cpuTotal += used / cpuLimit * 100
+				cpuCount++
+			}
+		}
+		a.cpu[job.ID] = stats
+	}
+
+	m := &Metrics{}
+	if cpuCount > 0 {
+		cpu := cpuTotal / float64(cpuCount)
+		m.CPU = &cpu
+	}
+	if memoryCount > 0 {
+		memory := memoryTotal / float64(memoryCount)
+		m.Memory = &memory
+	}
+	return m
+}
+
+// requestRates returns the number of requests per second routed to each
+// service since the last check, summed across all routers.
+func (a *Autoscaler) requestRates() (map[string]float64, error) {
+	addrs, err := discoverd.NewService("router-api").Addrs()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]uint64)
+	for _, addr := range addrs {
+		stats, err := routerc.NewWithAddr(addr).ListHTTPServiceStats()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range stats {
+			counts[s.Service] += s.Requests
+		}
+	}
+
+	now := time.Now()
+	rates := make(map[string]float64, len(counts))
+	for service, count := range counts {
+		// the counts reset when a router restarts, so skip a sample
+		// if the total goes down
+		if prev, ok := a.requests[service]; ok && count >= prev.count {
+			rates[service] = float64(count-prev.count) / now.Sub(prev.time).Seconds()
+		}
+		a.requests[service] = requestSample{count: count, time: now}
+	}
+	for service := range a.requests {
+		if _, ok := counts[service]; !ok {
+			delete(a.requests, service)
+		}
+	}
+	return rates, nil
+}
+
+// Metrics contains the measurements used to scale a process type, with nil
+// fields for those which are not known.
+type Metrics struct {
+	// CPU is the average CPU usage of each job as a percentage of its
+	// CPU limit
+	CPU *float64
+
+	// Memory is the average memory usage of each job as a percentage of
+	// its memory limit
+	Memory *float64
+
+	// RequestRate is the total number of requests per second routed to
+	// the process type
+	RequestRate *float64
+}
+
+// Desired returns the number of jobs a process type with the given policy
+// and number of jobs should be scaled to, along with the reason. Each metric
+// with a target suggests enough jobs to bring it back to the target, and the
+// largest suggestion is used so the process type only scales down once every
+// metric allows it.
+func Desired(policy *ct.AutoscalePolicy, current int, m *Metrics) (int, string) {
+	if current < policy.Min {
+		return policy.Min, fmt.Sprintf("below the minimum of %d", policy.Min)
+	}
+	if current > policy.Max {
+		return policy.Max, fmt.Sprintf("above the maximum of %d", policy.Max)
+	}
+
+	desired := -1
+	var reason string
+	suggest := func(n int, name string, value float64, unit string, target int) {
+		if n > desired {
+			desired = n
+			reason = fmt.Sprintf("%s %.0f%s, target %d%s", name, value, unit, target, unit)
+		}
+	}
+	if policy.TargetCPU > 0 && m.CPU != nil && current > 0 {
+		suggest(suggestion(current, *m.CPU, float64(policy.TargetCPU)), "average CPU usage", *m.CPU, "%", policy.TargetCPU)
+	}
+	if policy.TargetMemory > 0 && m.Memory != nil && current > 0 {
+		suggest(suggestion(current, *m.Memory, float64(policy.TargetMemory)), "average memory usage", *m.Memory, "%", policy.TargetMemory)
+	}
+	if policy.TargetRequestRate > 0 && m.RequestRate != nil {
+		perJob := *m.RequestRate
+		if current > 0 {
+			perJob /= float64(current)
+		}
+		n := int(math.Ceil(*m.RequestRate / float64(policy.TargetRequestRate)))
+		if current > 0 {
+			n = suggestion(current, perJob, float64(policy.TargetRequestRate))
+		}
+		suggest(n, "average request rate", perJob, " req/s", policy.TargetRequestRate)
+	}
+	if desired < 0 {
+		return current, ""
+	}
+
+	if desired < policy.Min {
+		desired = policy.Min
+	} else if desired > policy.Max {
+		desired = policy.Max
+	}
+	return desired, reason
+}
+
+// suggestion returns the number of jobs needed to bring a per-job metric to
+// its target, or the current number if it is within the tolerance.
+func suggestion(current int, value, target float64) int {
+	ratio := value / target
+	if math.Abs(ratio-1) <= tolerance {
+		return current
+	}
+	return int(math.Ceil(float64(current) * ratio))
+}
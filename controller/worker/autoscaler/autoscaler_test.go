@@ -0,0 +1,74 @@
+package autoscaler
+
+import (
+	"testing"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	. "github.com/flynn/go-check"
+)
+
+// Hook gocheck up to the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct{}
+
+var _ = Suite(&S{})
+
+func float(f float64) *float64 { return &f }
+
+func (S) TestDesired(c *C) {
+	policy := &ct.AutoscalePolicy{Min: 2, Max: 10, TargetCPU: 50, TargetMemory: 80, TargetRequestRate: 100}
+
+	for _, t := range []struct {
+		desc    string
+		current int
+		metrics *Metrics
+		desired int
+	}{
+		{"below min", 1, &Metrics{}, 2},
+		{"above max", 12, &Metrics{}, 10},
+		{"no metrics", 4, &Metrics{}, 4},
+		{"within tolerance", 4, &Metrics{CPU: float(54)}, 4},
+		{"cpu above target", 4, &Metrics{CPU: float(100)}, 8},
+		{"cpu below target", 4, &Metrics{CPU: float(25)}, 2},
+		{"clamped to max", 4, &Metrics{CPU: float(500)}, 10},
+		{"clamped to min", 4, &Metrics{CPU: float(1)}, 2},
+		{"largest suggestion", 4, &Metrics{CPU: float(25), Memory: float(120)}, 6},
+		{"request rate", 4, &Metrics{RequestRate: float(1000)}, 10},
+		{"request rate per job", 4, &Metrics{RequestRate: float(600)}, 6},
+	} {
+		desired, reason := Desired(policy, t.current, t.metrics)
+		c.Assert(desired, Equals, t.desired, Commentf(t.desc))
+		if desired != t.current {
+			c.Assert(reason, Not(Equals), "", Commentf(t.desc))
+		}
+	}
+
+	// a request rate scales a process type up from zero jobs
+	policy = &ct.AutoscalePolicy{Min: 0, Max: 5, TargetRequestRate: 10}
+	desired, _ := Desired(policy, 0, &Metrics{RequestRate: float(25)})
+	c.Assert(desired, Equals, 3)
+}
+
+func (S) TestCanScaleDown(c *C) {
+	a := &Autoscaler{watchedAt: make(map[string]time.Time), scaledAt: make(map[string]time.Time)}
+	now := time.Now()
+
+	// a process type which has not been watched, e.g. because the
+	// autoscaler just started, is not scaled down
+	c.Assert(a.canScaleDown("app:web", now), Equals, false)
+
+	// nor is one which has been watched for less than ScaleDownDelay
+	a.watchedAt["app:web"] = now.Add(-ScaleDownDelay / 2)
+	c.Assert(a.canScaleDown("app:web", now), Equals, false)
+
+	a.watchedAt["app:web"] = now.Add(-ScaleDownDelay)
+	c.Assert(a.canScaleDown("app:web", now), Equals, true)
+
+	// nor is one which was recently scaled
+	a.scaledAt["app:web"] = now.Add(-ScaleDownDelay / 2)
+	c.Assert(a.canScaleDown("app:web", now), Equals, false)
+	a.scaledAt["app:web"] = now.Add(-ScaleDownDelay)
+	c.Assert(a.canScaleDown("app:web", now), Equals, true)
+}
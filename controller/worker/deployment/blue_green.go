@@ -0,0 +1,182 @@
+package deployment
+
+import (
+	"fmt"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/router/types"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// deployBlueGreen brings the new formation fully up alongside the old one
+// whilst the app's HTTP routes are pinned to the old release, serves the new
+// release on preview routes for a period of time and then switches the app's
+// HTTP routes over to the new release.
+//
+// The old formation is left running so that the deployment can be rolled
+// back instantly, it is scaled down by a deployment_cleanup job once the warm
+// period has passed.
+func (d *DeployJob) deployBlueGreen() error {
+	log := d.logger.New("fn", "deployBlueGreen")
+	log.Info("starting blue-green deployment")
+
+	preview, _ := d.DeployOptions.BlueGreen()
+
+	routes, err := d.blueGreenRoutes(log)
+	if err != nil {
+		log.Error("error getting app routes", "err", err)
+		return err
+	}
+
+	log.Info("pinning routes to the old release", "release_id", d.OldReleaseID)
+	if err := d.setRoutesRelease(routes, d.OldReleaseID); err != nil {
+		log.Error("error pinning routes to the old release", "err", err)
+		return err
+	}
+
+	expected := make(ct.JobEvents)
+	for typ, n := range d.Processes {
+		total := n
+		if d.isOmni(typ) {
+			total *= d.hostCount
+		}
+		existing := d.newReleaseState[typ]
+		for i := existing; i < total; i++ {
+			d.deployEvents <- ct.DeploymentEvent{
+				ReleaseID: d.NewReleaseID,
+				JobState:  ct.JobStateStarting,
+				JobType:   typ,
+			}
+		}
+		if total > existing {
+			expected[typ] = ct.JobUpEvents(total - existing)
+		}
+	}
+	if expected.Count() > 0 {
+		log := log.New("release_id", d.NewReleaseID)
+		log.Info("creating new formation", "processes", d.Processes)
+		if err := d.client.PutFormation(&ct.Formation{
+			AppID:     d.AppID,
+			ReleaseID: d.NewReleaseID,
+			Processes: d.Processes,
+		}); err != nil {
+			log.Error("error creating new formation", "err", err)
+			return err
+		}
+
+		log.Info("waiting for job events", "expected", expected)
+		if err := d.waitForJobEvents(d.NewReleaseID, expected, log); err != nil {
+			log.Error("error waiting for job events", "err", err)
+			return err
+		}
+	}
+
+	var previewRoutes []*router.Route
+	defer func() {
+		for _, route := range previewRoutes {
+			log.Info("deleting preview route", "domain", route.Domain)
+			if err := d.client.DeleteRoute(d.AppID, route.FormattedID()); err != nil {
+				log.Error("error deleting preview route", "domain", route.Domain, "err", err)
+			}
+		}
+	}()
+	for _, route := range routes {
+		if route.Path != "/" {
+			continue
+		}
+		previewRoute := &router.Route{
+			Type:    "http",
+			Service: route.Service,
+			Domain:  ct.PreviewDomain(route.Domain),
+			Sticky:  route.Sticky,
+			Release: d.NewReleaseID,
+		}
+		log.Info("creating preview route", "domain", previewRoute.Domain)
+		if err := d.client.CreateRoute(d.AppID, previewRoute); err != nil {
+			log.Error("error creating preview route", "domain", previewRoute.Domain, "err", err)
+			return err
+		}
+		previewRoutes = append(previewRoutes, previewRoute)
+	}
+
+	d.deployEvents <- ct.DeploymentEvent{
+		ReleaseID: d.NewReleaseID,
+		Status:    "preview",
+	}
+	if err := d.observeRelease("preview", preview, log); err != nil {
+		log.Error("preview failed, aborting deployment", "err", err)
+		return err
+	}
+
+	log.Info("switching routes to the new release", "release_id", d.NewReleaseID)
+	if err := d.setRoutesRelease(routes, d.NewReleaseID); err != nil {
+		log.Error("error switching routes to the new release", "err", err)
+		// the rollback deletes the new formation, so make sure the
+		// routes are pointing at the old release before it does
+		if err := d.setRoutesRelease(routes, d.OldReleaseID); err != nil {
+			log.Error("error pinning routes to the old release", "err", err)
+			return ErrSkipRollback{err.Error()}
+		}
+		return err
+	}
+
+	log.Info("finished blue-green deployment")
+	return nil
+}
+
+// blueGreenRoutes returns the app's HTTP routes which are switched by the
+// blue-green strategy, deleting any preview routes left behind by a previous
+// deployment which did not finish
+func (d *DeployJob) blueGreenRoutes(log log15.Logger) ([]*router.Route, error) {
+	all, err := d.client.RouteList(d.AppID)
+	if err != nil {
+		return nil, err
+	}
+	previewDomains := make(map[string]struct{}, len(all))
+	for _, route := range all {
+		if route.Type == "http" {
+			previewDomains[ct.PreviewDomain(route.Domain)] = struct{}{}
+		}
+	}
+	routes := make([]*router.Route, 0, len(all))
+	for _, route := range all {
+		if route.Type != "http" {
+			continue
+		}
+		if _, ok := previewDomains[route.Domain]; ok && route.Release != "" {
+			log.Info("deleting stale preview route", "domain", route.Domain)
+			if err := d.client.DeleteRoute(d.AppID, route.FormattedID()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// setRoutesRelease restricts the given routes to the given release, or
+// removes the restriction if releaseID is empty
+func (d *DeployJob) setRoutesRelease(routes []*router.Route, releaseID string) error {
+	for _, route := range routes {
+		if route.Release == releaseID {
+			continue
+		}
+		route.Release = releaseID
+		if err := d.client.UpdateRoute(d.AppID, route.FormattedID(), route); err != nil {
+			return fmt.Errorf("deployer: error updating route %s: %s", route.FormattedID(), err)
+		}
+	}
+	return nil
+}
+
+// unpinRoutes removes any release restriction left on the app's HTTP routes
+// by a blue-green deployment, so that a deployment using a different
+// strategy routes traffic to the jobs it starts
+func (d *DeployJob) unpinRoutes() error {
+	routes, err := d.blueGreenRoutes(d.logger)
+	if err != nil {
+		return err
+	}
+	return d.setRoutesRelease(routes, "")
+}
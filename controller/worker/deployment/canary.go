@@ -0,0 +1,154 @@
+package deployment
+
+import (
+	"sort"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+// deployCanary starts a small number of jobs of the new release alongside the
+// old formation and observes them for a period of time. If none of them go
+// down or fail their health checks during that period, the deployment is
+// promoted and continues one-by-one, otherwise an error is returned so that
+// the deployment is rolled back.
+//
+// Omni process types are not started as canaries (scaling them starts a job
+// on every host), they are just deployed one-by-one after promotion.
+func (d *DeployJob) deployCanary() error {
+	log := d.logger.New("fn", "deployCanary")
+	log.Info("starting canary deployment")
+
+	count, period := d.DeployOptions.Canary()
+
+	processTypes := make([]string, 0, len(d.Processes))
+	for typ := range d.Processes {
+		processTypes = append(processTypes, typ)
+	}
+	sort.Strings(processTypes)
+
+	newScale := make(map[string]int, len(d.newReleaseState))
+	for typ, n := range d.newReleaseState {
+		newScale[typ] = n
+		if d.isOmni(typ) {
+			newScale[typ] /= d.hostCount
+		}
+	}
+
+	canaries := make(map[string]int, len(d.Processes))
+	expected := make(ct.JobEvents)
+	for _, typ := range processTypes {
+		if d.isOmni(typ) {
+			continue
+		}
+		target := count
+		if num := d.Processes[typ]; num < target {
+			target = num
+		}
+		existing := newScale[typ]
+		if existing >= target {
+			continue
+		}
+		canaries[typ] = target - existing
+		newScale[typ] = target
+		expected[typ] = ct.JobUpEvents(target - existing)
+		for i := existing; i < target; i++ {
+			d.deployEvents <- ct.DeploymentEvent{
+				ReleaseID: d.NewReleaseID,
+				JobState:  ct.JobStateStarting,
+				JobType:   typ,
+			}
+		}
+	}
+
+	if expected.Count() > 0 {
+		nlog := log.New("release_id", d.NewReleaseID)
+		nlog.Info("starting canary jobs", "canaries", canaries)
+		if err := d.client.PutFormation(&ct.Formation{
+			AppID:     d.AppID,
+			ReleaseID: d.NewReleaseID,
+			Processes: newScale,
+		}); err != nil {
+			nlog.Error("error starting canary jobs", "err", err)
+			return err
+		}
+		nlog.Info("waiting for job events", "expected", expected)
+		if err := d.waitForJobEvents(d.NewReleaseID, expected, nlog); err != nil {
+			nlog.Error("error waiting for job events", "err", err)
+			return err
+		}
+		for typ, n := range canaries {
+			d.newReleaseState[typ] += n
+		}
+	}
+
+	d.deployEvents <- ct.DeploymentEvent{
+		ReleaseID: d.NewReleaseID,
+		Status:    "canary",
+	}
+	if err := d.observeRelease("canary", period, log); err != nil {
+		log.Error("canary failed, aborting deployment", "err", err)
+		return err
+	}
+	log.Info("promoting deployment")
+	d.deployEvents <- ct.DeploymentEvent{
+		ReleaseID: d.NewReleaseID,
+		Status:    "promoted",
+	}
+
+	// the canary jobs have replaced some of the old jobs, so stop the
+	// same number of old jobs before continuing one-by-one
+	if len(canaries) > 0 {
+		oldScale := make(map[string]int, len(d.oldReleaseState))
+		for typ, n := range d.oldReleaseState {
+			oldScale[typ] = n
+			if d.isOmni(typ) {
+				oldScale[typ] /= d.hostCount
+			}
+		}
+		expected := make(ct.JobEvents)
+		for _, typ := range processTypes {
+			n := canaries[typ]
+			if n > oldScale[typ] {
+				n = oldScale[typ]
+			}
+			if n == 0 {
+				continue
+			}
+			oldScale[typ] -= n
+			expected[typ] = ct.JobDownEvents(n)
+			for i := 0; i < n; i++ {
+				d.deployEvents <- ct.DeploymentEvent{
+					ReleaseID: d.OldReleaseID,
+					JobState:  ct.JobStateStopping,
+					JobType:   typ,
+				}
+			}
+		}
+		if expected.Count() > 0 {
+			olog := log.New("release_id", d.OldReleaseID)
+			olog.Info("scaling down old formation by canary count", "expected", expected)
+			if err := d.client.PutFormation(&ct.Formation{
+				AppID:     d.AppID,
+				ReleaseID: d.OldReleaseID,
+				Processes: oldScale,
+			}); err != nil {
+				olog.Error("error scaling down old formation", "err", err)
+				return err
+			}
+			olog.Info("waiting for job events", "expected", expected)
+			if err := d.waitForJobEvents(d.OldReleaseID, expected, olog); err != nil {
+				olog.Error("error waiting for job events", "err", err)
+				return err
+			}
+			for typ, events := range expected {
+				d.oldReleaseState[typ] -= events[ct.JobStateDown]
+			}
+		}
+	}
+
+	if err := d.deployOneByOne(); err != nil {
+		return err
+	}
+	log.Info("finished canary deployment")
+	return nil
+}
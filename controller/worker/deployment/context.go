@@ -128,6 +128,16 @@ func (c *context) HandleDeployment(job *que.Job) (e error) {
 	}
 	log.Info("deployment complete")
 
+	if deployment.Strategy == "blue-green" {
+		_, warm := deployment.DeployOptions.BlueGreen()
+		log.Info("scheduling deployment cleanup", "warm_period", warm)
+		if err := c.scheduleCleanup(deployment, warm); err != nil {
+			// just log the error, the old formation can be scaled
+			// down manually
+			log.Error("error scheduling deployment cleanup", "err", err)
+		}
+	}
+
 	log.Info("scheduling app garbage collection")
 	if err := c.client.ScheduleAppGarbageCollection(deployment.AppID); err != nil {
 		// just log the error, no need to rollback the deploy
@@ -195,6 +205,20 @@ func (c *context) rollback(l log15.Logger, deployment *ct.Deployment, original *
 	return nil
 }
 
+// scheduleCleanup schedules a deployment_cleanup job to scale down the old
+// formation of a blue-green deployment once the warm period has passed
+func (c *context) scheduleCleanup(deployment *ct.Deployment, warm time.Duration) error {
+	args, err := json.Marshal(ct.DeployID{ID: deployment.ID})
+	if err != nil {
+		return err
+	}
+	return que.NewClient(c.db.ConnPool).Enqueue(&que.Job{
+		Type:  "deployment_cleanup",
+		Args:  args,
+		RunAt: time.Now().Add(warm),
+	})
+}
+
 func (c *context) setDeploymentDone(id string) error {
 	return c.execWithRetries("deployment_update_finished_at_now", id)
 }
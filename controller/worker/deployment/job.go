@@ -115,6 +115,10 @@ func (d *DeployJob) Perform() error {
 		deployFunc = d.deploySirenia
 	case "discoverd-meta":
 		deployFunc = d.deployDiscoverdMeta
+	case "canary":
+		deployFunc = d.deployCanary
+	case "blue-green":
+		deployFunc = d.deployBlueGreen
 	default:
 		err := UnknownStrategyError{d.Strategy}
 		log.Error("error validating deployment strategy", "err", err)
@@ -278,6 +282,18 @@ func (d *DeployJob) Perform() error {
 		"old_release", d.oldReleaseState,
 		"new_release", d.newReleaseState,
 	)
+
+	switch d.Strategy {
+	case "one-by-one", "all-at-once", "canary":
+		// a previous blue-green deployment may have left the app's routes
+		// restricted to a release, so remove the restriction so that the
+		// routes balance across the jobs started by this deployment
+		log.Info("unpinning app routes")
+		if err := d.unpinRoutes(); err != nil {
+			log.Error("error unpinning app routes", "err", err)
+			return err
+		}
+	}
 	return deployFunc()
 }
 
@@ -371,3 +387,54 @@ func (d *DeployJob) waitForJobEvents(releaseID string, expected ct.JobEvents, lo
 		}
 	}
 }
+
+// observeRelease watches the new release's jobs for the given period,
+// returning an error if any of them go down or fail their health check. The
+// name describes the jobs being observed in logs and errors (e.g. "canary").
+func (d *DeployJob) observeRelease(name string, period time.Duration, log log15.Logger) error {
+	log.Info("observing new release jobs", "name", name, "period", period)
+	timeout := time.After(period)
+	jobEvents := d.ReleaseJobEvents(d.NewReleaseID)
+	for {
+		select {
+		case <-d.stop:
+			return worker.ErrStopped
+		case <-timeout:
+			log.Info("observation period finished", "name", name)
+			return nil
+		case e := <-jobEvents:
+			switch e.Type {
+			case JobEventTypeDiscoverd:
+				event := e.DiscoverdEvent
+				if event.Kind != discoverd.EventKindDown {
+					continue
+				}
+				typ := event.Instance.Meta["FLYNN_PROCESS_TYPE"]
+				log.Warn("got service down event", "job.id", event.Instance.Meta["FLYNN_JOB_ID"], "job.type", typ)
+				d.deployEvents <- ct.DeploymentEvent{
+					ReleaseID: d.NewReleaseID,
+					JobState:  ct.JobStateDown,
+					JobType:   typ,
+				}
+				return fmt.Errorf("deployer: %s %s job failed its health check", name, typ)
+			case JobEventTypeController:
+				event := e.JobEvent
+				if !event.IsDown() {
+					continue
+				}
+				log.Warn("got job down event", "job.id", event.ID, "job.type", event.Type, "job.state", event.State)
+				d.deployEvents <- ct.DeploymentEvent{
+					ReleaseID: d.NewReleaseID,
+					JobState:  event.State,
+					JobType:   event.Type,
+				}
+				if event.HostError != nil {
+					return fmt.Errorf("deployer: %s %s job failed: %s", name, event.Type, *event.HostError)
+				}
+				return fmt.Errorf("deployer: %s %s job went down during the observation period", name, event.Type)
+			case JobEventTypeError:
+				return e.Error
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package deployment_cleanup
+
+import (
+	"encoding/json"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/que-go"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type context struct {
+	db     *postgres.DB
+	client controller.Client
+	logger log15.Logger
+}
+
+func JobHandler(db *postgres.DB, client controller.Client, logger log15.Logger) func(*que.Job) error {
+	return (&context{db, client, logger}).HandleDeploymentCleanup
+}
+
+// HandleDeploymentCleanup scales down the old formation of a blue-green
+// deployment once its warm period has passed, unless the deployment has
+// since been rolled back.
+func (c *context) HandleDeploymentCleanup(job *que.Job) error {
+	log := c.logger.New("fn", "HandleDeploymentCleanup")
+	log.Info("handling deployment cleanup", "job_id", job.ID, "error_count", job.ErrorCount)
+
+	var args ct.DeployID
+	if err := json.Unmarshal(job.Args, &args); err != nil {
+		log.Error("error unmarshaling job", "err", err)
+		return err
+	}
+
+	log.Info("getting deployment record", "deployment_id", args.ID)
+	deployment, err := c.client.GetDeployment(args.ID)
+	if err != nil {
+		log.Error("error getting deployment record", "err", err)
+		return err
+	}
+	log = log.New("deployment_id", deployment.ID, "app_id", deployment.AppID)
+
+	log.Info("getting app")
+	app, err := c.client.GetApp(deployment.AppID)
+	if err != nil {
+		log.Error("error getting app", "err", err)
+		return err
+	}
+	if app.ReleaseID == deployment.OldReleaseID {
+		log.Info("deployment was rolled back, skipping cleanup")
+		return nil
+	}
+
+	log.Info("getting old formation", "release_id", deployment.OldReleaseID)
+	formation, err := c.client.GetFormation(deployment.AppID, deployment.OldReleaseID)
+	if err == controller.ErrNotFound {
+		log.Info("old formation no longer exists")
+	} else if err != nil {
+		log.Error("error getting old formation", "err", err)
+		return err
+	} else if formationRunning(formation) {
+		log.Info("scaling old formation to zero", "release_id", deployment.OldReleaseID)
+		if err := c.client.PutFormation(&ct.Formation{
+			AppID:     deployment.AppID,
+			ReleaseID: deployment.OldReleaseID,
+		}); err != nil {
+			log.Error("error scaling old formation to zero", "err", err)
+			return err
+		}
+	}
+
+	// the app's routes only need to stay pinned to the new release whilst
+	// the old formation is running, so unpin them unless a subsequent
+	// deployment has pinned them to a different release
+	if app.ReleaseID != deployment.NewReleaseID {
+		log.Info("app release has changed, leaving routes pinned")
+		return nil
+	}
+	log.Info("unpinning app routes")
+	routes, err := c.client.RouteList(deployment.AppID)
+	if err != nil {
+		log.Error("error listing app routes", "err", err)
+		return err
+	}
+	for _, route := range routes {
+		if route.Type != "http" || route.Release != deployment.NewReleaseID {
+			continue
+		}
+		route.Release = ""
+		if err := c.client.UpdateRoute(deployment.AppID, route.FormattedID(), route); err != nil {
+			log.Error("error unpinning route", "route_id", route.FormattedID(), "err", err)
+			return err
+		}
+	}
+
+	log.Info("deployment cleanup complete")
+	return nil
+}
+
+func formationRunning(f *ct.Formation) bool {
+	for _, n := range f.Processes {
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
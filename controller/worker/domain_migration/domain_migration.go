@@ -374,6 +374,8 @@ func (m *migration) appMaybeCreateRoute(appID string, oldRoute *router.Route, ro
 		Domain:  strings.Join([]string{prefix, m.dm.Domain}, ""),
 		Sticky:  oldRoute.Sticky,
 		Service: oldRoute.Service,
+		Release: oldRoute.Release,
+		Targets: oldRoute.Targets,
 	}
 	if oldRoute.Certificate != nil && oldRoute.Certificate.Cert == strings.TrimSpace(m.dm.OldTLSCert.Cert) {
 		route.Certificate = &router.Certificate{
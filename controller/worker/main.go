@@ -9,9 +9,13 @@ import (
 	"github.com/flynn/flynn/controller/schema"
 	"github.com/flynn/flynn/controller/worker/app_deletion"
 	"github.com/flynn/flynn/controller/worker/app_garbage_collection"
+	"github.com/flynn/flynn/controller/worker/autoscaler"
 	"github.com/flynn/flynn/controller/worker/deployment"
+	"github.com/flynn/flynn/controller/worker/deployment_cleanup"
 	"github.com/flynn/flynn/controller/worker/domain_migration"
 	"github.com/flynn/flynn/controller/worker/release_cleanup"
+	"github.com/flynn/flynn/controller/worker/review_app_expiry"
+	"github.com/flynn/flynn/controller/worker/schedule"
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/shutdown"
@@ -53,6 +57,14 @@ func main() {
 			shutdown.Fatal(err)
 		}
 		shutdown.BeforeExit(func() { hb.Close() })
+
+		// only the leader runs the autoscaler so that apps are not
+		// scaled by several workers at once
+		go autoscaler.New(client, logger).Run(func() bool {
+			leader, err := discoverd.NewService("controller-worker").Leader()
+			return err == nil && leader.Addr == hb.Addr()
+		})
+
 		shutdown.Fatal(http.ListenAndServe(addr, nil))
 	}()
 
@@ -64,6 +76,9 @@ func main() {
 			"domain_migration":       domain_migration.JobHandler(db, client, logger),
 			"release_cleanup":        release_cleanup.JobHandler(db, client, logger),
 			"app_garbage_collection": app_garbage_collection.JobHandler(db, client, logger),
+			"deployment_cleanup":     deployment_cleanup.JobHandler(db, client, logger),
+			"schedule":               schedule.JobHandler(db, client, logger),
+			"review_app_expiry":      review_app_expiry.JobHandler(db, client, logger),
 		},
 		workerCount,
 	)
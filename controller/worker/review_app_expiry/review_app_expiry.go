@@ -0,0 +1,61 @@
+package review_app_expiry
+
+import (
+	"encoding/json"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/que-go"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type context struct {
+	db     *postgres.DB
+	client controller.Client
+	logger log15.Logger
+}
+
+func JobHandler(db *postgres.DB, client controller.Client, logger log15.Logger) func(*que.Job) error {
+	return (&context{db, client, logger}).HandleReviewAppExpiry
+}
+
+// HandleReviewAppExpiry deletes a review app which has expired, unless its
+// expiry time has changed since the job was enqueued (in which case another
+// job was enqueued for the new time).
+func (c *context) HandleReviewAppExpiry(job *que.Job) error {
+	log := c.logger.New("fn", "HandleReviewAppExpiry")
+	log.Info("handling review app expiry", "job_id", job.ID, "error_count", job.ErrorCount)
+
+	var args ct.ReviewAppExpiry
+	if err := json.Unmarshal(job.Args, &args); err != nil {
+		log.Error("error unmarshaling job", "err", err)
+		return err
+	}
+	log = log.New("app_id", args.AppID, "expires_at", args.ExpiresAt)
+
+	log.Info("getting app")
+	app, err := c.client.GetApp(args.AppID)
+	if err == controller.ErrNotFound {
+		log.Info("app no longer exists, skipping")
+		return nil
+	} else if err != nil {
+		log.Error("error getting app", "err", err)
+		return err
+	}
+	if app.ReviewParent() == "" {
+		log.Info("app is no longer a review app, skipping")
+		return nil
+	}
+	if expiresAt := app.ReviewExpiresAt(); expiresAt == nil || !expiresAt.Equal(args.ExpiresAt) {
+		log.Info("app expiry has changed since the job was enqueued, skipping")
+		return nil
+	}
+
+	log.Info("deleting expired review app", "app_name", app.Name)
+	if _, err := c.client.DeleteApp(app.ID); err != nil && err != controller.ErrNotFound {
+		log.Error("error deleting app", "err", err)
+		return err
+	}
+	return nil
+}
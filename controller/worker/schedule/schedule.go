@@ -0,0 +1,211 @@
+package schedule
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/cron"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/que-go"
+	"github.com/jackc/pgx"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type context struct {
+	db     *postgres.DB
+	client controller.Client
+	logger log15.Logger
+}
+
+func JobHandler(db *postgres.DB, client controller.Client, logger log15.Logger) func(*que.Job) error {
+	return (&context{db, client, logger}).HandleSchedule
+}
+
+// HandleSchedule runs a schedule which is due, first advancing the schedule
+// to the next time it is due so that each run happens at most once (a job
+// left over from before the schedule was updated or advanced by another
+// worker is ignored).
+func (c *context) HandleSchedule(job *que.Job) error {
+	log := c.logger.New("fn", "HandleSchedule")
+	log.Info("handling schedule", "job_id", job.ID, "error_count", job.ErrorCount)
+
+	var args ct.ScheduleRun
+	if err := json.Unmarshal(job.Args, &args); err != nil {
+		log.Error("error unmarshaling job", "err", err)
+		return err
+	}
+	log = log.New("app_id", args.AppID, "schedule_id", args.ScheduleID, "run_at", args.RunAt)
+
+	log.Info("getting schedule")
+	schedule, err := c.client.GetSchedule(args.AppID, args.ScheduleID)
+	if err == controller.ErrNotFound {
+		log.Info("schedule no longer exists, skipping")
+		return nil
+	} else if err != nil {
+		log.Error("error getting schedule", "err", err)
+		return err
+	}
+	if schedule.NextRunAt == nil || !schedule.NextRunAt.Equal(args.RunAt) {
+		log.Info("schedule has been updated since the job was enqueued, skipping")
+		return nil
+	}
+
+	advanced, err := c.advance(schedule)
+	if err != nil {
+		log.Error("error advancing schedule", "err", err)
+		return err
+	} else if !advanced {
+		log.Info("schedule has already been run, skipping")
+		return nil
+	}
+
+	// from this point on errors are logged rather than returned so the run
+	// is not retried after the schedule has been advanced
+	if err := c.run(schedule, log); err != nil {
+		log.Error("error running schedule", "err", err)
+	}
+	return nil
+}
+
+// advance sets the schedule's next_run_at to the next time it is due and
+// enqueues a job to run it then, returning false if the schedule was
+// advanced concurrently
+func (c *context) advance(schedule *ct.Schedule) (bool, error) {
+	spec, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return false, err
+	}
+	// start from the later of now and the current run time so that a
+	// backlog of missed runs is skipped rather than run in quick succession
+	from := time.Now().UTC()
+	if schedule.NextRunAt.After(from) {
+		from = *schedule.NextRunAt
+	}
+	next := spec.Next(from)
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	var id string
+	if err := tx.QueryRow("schedule_update_next_run_at", schedule.ID, schedule.NextRunAt, next).Scan(&id); err != nil {
+		tx.Rollback()
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if !next.IsZero() {
+		args, err := json.Marshal(ct.ScheduleRun{
+			AppID:      schedule.AppID,
+			ScheduleID: schedule.ID,
+			RunAt:      next,
+		})
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+		if err := que.NewClient(c.db.ConnPool).EnqueueInTx(&que.Job{
+			Type:  "schedule",
+			Args:  args,
+			RunAt: next,
+		}, tx.Tx); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// run applies the schedule's concurrency policy and then runs a job using
+// the app's current release, recording it in a schedule event
+func (c *context) run(schedule *ct.Schedule, log log15.Logger) error {
+	log.Info("getting app release")
+	release, err := c.client.GetAppRelease(schedule.AppID)
+	if err == controller.ErrNotFound {
+		log.Info("app has no release, skipping")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if schedule.ConcurrencyPolicy != ct.ConcurrencyPolicyAllow {
+		log.Info("getting running jobs", "concurrency_policy", schedule.ConcurrencyPolicy)
+		jobs, err := c.runningJobs(schedule)
+		if err != nil {
+			return err
+		}
+		if len(jobs) > 0 {
+			switch schedule.ConcurrencyPolicy {
+			case ct.ConcurrencyPolicyForbid:
+				log.Info("jobs from a previous run are still running, skipping", "count", len(jobs))
+				return nil
+			case ct.ConcurrencyPolicyReplace:
+				for _, job := range jobs {
+					log.Info("stopping job from a previous run", "job_id", job.ID)
+					if err := c.client.DeleteJob(schedule.AppID, job.ID); err != nil && err != controller.ErrNotFound {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	newJob := &ct.NewJob{
+		ReleaseID:  release.ID,
+		ReleaseEnv: true,
+		Args:       schedule.Args,
+		Env:        make(map[string]string, len(schedule.Env)),
+		Meta:       map[string]string{ct.ScheduleMetaKey: schedule.ID},
+	}
+	if schedule.ProcessType != "" {
+		proc, ok := release.Processes[schedule.ProcessType]
+		if !ok {
+			log.Info("process type does not exist in the app's release, skipping", "release_id", release.ID, "process_type", schedule.ProcessType)
+			return nil
+		}
+		if len(newJob.Args) == 0 {
+			newJob.Args = proc.Args
+		}
+		for k, v := range proc.Env {
+			newJob.Env[k] = v
+		}
+		newJob.Resources = proc.Resources
+	}
+	for k, v := range schedule.Env {
+		newJob.Env[k] = v
+	}
+
+	log.Info("running job", "release_id", release.ID)
+	job, err := c.client.RunJobDetached(schedule.AppID, newJob)
+	if err != nil {
+		return err
+	}
+	job.AppID = schedule.AppID
+	job.Meta = newJob.Meta
+	log.Info("started job", "job_id", job.ID)
+
+	return c.db.Exec("event_insert", schedule.AppID, schedule.ID, string(ct.EventTypeSchedule), job)
+}
+
+// runningJobs returns the app's jobs started by previous runs of the schedule
+// which have not yet stopped
+func (c *context) runningJobs(schedule *ct.Schedule) ([]*ct.Job, error) {
+	list, err := c.client.JobList(schedule.AppID)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*ct.Job
+	for _, job := range list {
+		if job.Meta[ct.ScheduleMetaKey] != schedule.ID || job.ID == "" {
+			continue
+		}
+		switch job.State {
+		case ct.JobStatePending, ct.JobStateStarting, ct.JobStateUp:
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
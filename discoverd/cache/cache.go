@@ -14,13 +14,14 @@ var TestMode = false
 type ServiceCache interface {
 	LeaderAddr() []string
 	Addrs() []string
+	AddrsWithMeta(key, value string) []string
 	Close() error
 }
 
 func New(s discoverd.Service) (ServiceCache, error) {
 	d := &serviceCache{
-		addrs: make(map[string]struct{}),
-		stop:  make(chan struct{}),
+		instances: make(map[string]*discoverd.Instance),
+		stop:      make(chan struct{}),
 	}
 	return d, d.start(s)
 }
@@ -30,7 +31,7 @@ type serviceCache struct {
 
 	sync.RWMutex
 	leaderAddr string
-	addrs      map[string]struct{}
+	instances  map[string]*discoverd.Instance
 
 	// used by the test suite
 	watchers map[chan *discoverd.Event]struct{}
@@ -75,11 +76,11 @@ func (d *serviceCache) start(s discoverd.Service) (err error) {
 				switch event.Kind {
 				case discoverd.EventKindUp, discoverd.EventKindUpdate:
 					d.Lock()
-					d.addrs[event.Instance.Addr] = struct{}{}
+					d.instances[event.Instance.Addr] = event.Instance
 					d.Unlock()
 				case discoverd.EventKindDown:
 					d.Lock()
-					delete(d.addrs, event.Instance.Addr)
+					delete(d.instances, event.Instance.Addr)
 					d.Unlock()
 				case discoverd.EventKindLeader:
 					d.Lock()
@@ -107,13 +108,27 @@ func (d *serviceCache) Close() error {
 func (d *serviceCache) Addrs() []string {
 	d.RLock()
 	defer d.RUnlock()
-	res := make([]string, 0, len(d.addrs))
-	for addr := range d.addrs {
+	res := make([]string, 0, len(d.instances))
+	for addr := range d.instances {
 		res = append(res, addr)
 	}
 	return res
 }
 
+// AddrsWithMeta returns the addresses of instances which have the given
+// metadata key set to value.
+func (d *serviceCache) AddrsWithMeta(key, value string) []string {
+	d.RLock()
+	defer d.RUnlock()
+	res := make([]string, 0, len(d.instances))
+	for addr, inst := range d.instances {
+		if inst.Meta[key] == value {
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
 func (d *serviceCache) LeaderAddr() []string {
 	d.RLock()
 	defer d.RUnlock()
@@ -144,10 +159,10 @@ func (d *serviceCache) Watch(current bool) (chan *discoverd.Event, func()) {
 	d.watchers[ch] = struct{}{}
 	go func() {
 		if current {
-			for addr := range d.addrs {
+			for _, inst := range d.instances {
 				ch <- &discoverd.Event{
 					Kind:     discoverd.EventKindUp,
-					Instance: &discoverd.Instance{Addr: addr},
+					Instance: inst,
 				}
 			}
 		}
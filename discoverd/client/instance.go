@@ -18,6 +18,8 @@ const (
 	EventKindLeader
 	EventKindCurrent
 	EventKindServiceMeta
+	EventKindKeySet
+	EventKindKeyDelete
 	EventKindAll     = ^EventKind(0)
 	EventKindUnknown = EventKind(0)
 )
@@ -30,6 +32,8 @@ var eventKindStrings = map[EventKind]string{
 	EventKindCurrent:     "current",
 	EventKindUnknown:     "unknown",
 	EventKindServiceMeta: "service_meta",
+	EventKindKeySet:      "key_set",
+	EventKindKeyDelete:   "key_delete",
 }
 
 func (k EventKind) String() string {
@@ -79,6 +83,7 @@ type Event struct {
 	Kind        EventKind    `json:"kind"`
 	Instance    *Instance    `json:"instance,omitempty"`
 	ServiceMeta *ServiceMeta `json:"service_meta,omitempty"`
+	KV          *KVPair      `json:"kv,omitempty"`
 }
 
 func (e *Event) String() string {
@@ -0,0 +1,85 @@
+package discoverd
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/pkg/stream"
+)
+
+// KVPair is a value stored at a key in the key/value store. Keys are
+// hierarchical, "/" separated paths such as "/myapp/config/timeout".
+type KVPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+
+	// Index is the raft index at which the key was last set. When calling
+	// CompareAndSetKey, Index is checked against the current index and the
+	// set only succeeds if the index is the same. A zero index means the key
+	// does not currently exist.
+	Index uint64 `json:"index"`
+
+	// TTL is the number of seconds after being set that the key expires, it
+	// is never expired if zero.
+	TTL int `json:"ttl,omitempty"`
+
+	// Expires is set by the server to the time the key expires for keys with
+	// a TTL.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func kvPath(key string) string {
+	return "/kv/" + strings.TrimPrefix(key, "/")
+}
+
+// GetKey returns the value stored at key.
+func (c *Client) GetKey(key string) (*KVPair, error) {
+	pair := &KVPair{}
+	return pair, c.Get(kvPath(key), pair)
+}
+
+// ListKeys returns the keys stored at prefix and below it, sorted by key.
+func (c *Client) ListKeys(prefix string) ([]*KVPair, error) {
+	var pairs []*KVPair
+	return pairs, c.Get(kvPath(prefix)+"?recurse=true", &pairs)
+}
+
+// SetKey sets the value of pair.Key regardless of its current index, updating
+// pair with the new index.
+func (c *Client) SetKey(pair *KVPair) error {
+	return c.Put(kvPath(pair.Key), pair, pair)
+}
+
+// CompareAndSetKey sets the value of pair.Key if its current index is
+// pair.Index, updating pair with the new index.
+func (c *Client) CompareAndSetKey(pair *KVPair) error {
+	return c.Put(fmt.Sprintf("%s?index=%d", kvPath(pair.Key), pair.Index), pair, pair)
+}
+
+// DeleteKey deletes key. If index is non-zero then the key is only deleted if
+// its current index is the same, and if recurse is true then all keys below
+// it are also deleted.
+func (c *Client) DeleteKey(key string, index uint64, recurse bool) error {
+	q := make(url.Values)
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+	}
+	if recurse {
+		q.Set("recurse", "true")
+	}
+	path := kvPath(key)
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	return c.Send("DELETE", path, nil, nil)
+}
+
+// WatchKeys streams EventKindKeySet and EventKindKeyDelete events for the
+// keys stored at prefix and below it. The current keys are sent first as
+// EventKindKeySet events followed by an EventKindCurrent event.
+func (c *Client) WatchKeys(prefix string, events chan *Event) (stream.Stream, error) {
+	return c.Stream("GET", kvPath(prefix), nil, events)
+}
@@ -0,0 +1,198 @@
+package discoverd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/random"
+)
+
+// Lock is the state of a named lock held by a session.
+type Lock struct {
+	Name string `json:"name"`
+
+	// Session identifies the holder of the lock, a lock is refreshed by
+	// acquiring it again with the same session.
+	Session string `json:"session"`
+
+	// Token is a fencing token which is set by the server to the raft index
+	// at which the lock was acquired. It stays the same whilst the lock is
+	// refreshed and increases every time the lock changes hands, so
+	// resources protected by the lock can reject requests made with an older
+	// token.
+	Token uint64 `json:"token"`
+
+	// TTL is the number of seconds after being acquired or refreshed that
+	// the lock expires.
+	TTL int `json:"ttl"`
+
+	// Expires is set by the server to the time the lock expires.
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	// ErrLockTimeout is returned by Lock if the lock could not be acquired
+	// within the timeout.
+	ErrLockTimeout = errors.New("discoverd: timed out waiting for lock")
+
+	// ErrLockLost is returned by Unlock if the lock expired or was acquired
+	// by another session before being released.
+	ErrLockLost = errors.New("discoverd: lock lost")
+)
+
+// LockRetryInterval is how long Lock waits between attempts to acquire a lock
+// held by another session.
+var LockRetryInterval = time.Second
+
+// IsLockHeld returns whether err was returned because the lock is held by
+// another session.
+func IsLockHeld(err error) bool {
+	return hh.IsConflictError(err)
+}
+
+// GetLock returns the current state of the named lock.
+func (c *Client) GetLock(name string) (*Lock, error) {
+	lock := &Lock{}
+	return lock, c.Get("/locks/"+name, lock)
+}
+
+// AcquireLock makes a single attempt to acquire or refresh lock using
+// lock.Session and lock.TTL, updating lock with the token and expiry time.
+func (c *Client) AcquireLock(lock *Lock) error {
+	return c.Put("/locks/"+lock.Name, lock, lock)
+}
+
+// ReleaseLock releases lock if it is still held by lock.Session with
+// lock.Token.
+func (c *Client) ReleaseLock(lock *Lock) error {
+	q := make(url.Values)
+	q.Set("session", lock.Session)
+	q.Set("token", strconv.FormatUint(lock.Token, 10))
+	return c.Send("DELETE", fmt.Sprintf("/locks/%s?%s", lock.Name, q.Encode()), nil, nil)
+}
+
+// Lock blocks until the named lock is acquired or timeout elapses. The lock is
+// refreshed in the background until Unlock is called, and the channel
+// returned by Lost is closed if it could not be refreshed in time.
+func (c *Client) Lock(name string, ttl, timeout time.Duration) (*HeldLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := c.TryLock(name, ttl)
+		if err == nil || !IsLockHeld(err) {
+			return l, err
+		}
+		if time.Now().Add(LockRetryInterval).After(deadline) {
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(LockRetryInterval)
+	}
+}
+
+// TryLock makes a single attempt to acquire the named lock, returning an
+// error for which IsLockHeld is true if it is held by another session.
+func (c *Client) TryLock(name string, ttl time.Duration) (*HeldLock, error) {
+	secs := int(ttl / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	l := &HeldLock{
+		client: c,
+		lock:   &Lock{Name: name, Session: random.UUID(), TTL: secs},
+		ttl:    time.Duration(secs) * time.Second,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	start := time.Now()
+	if err := c.AcquireLock(l.lock); err != nil {
+		return nil, err
+	}
+	l.expires = start.Add(l.ttl)
+	l.done.Add(1)
+	go l.refresh()
+	return l, nil
+}
+
+// HeldLock is a lock acquired by Lock or TryLock.
+type HeldLock struct {
+	client *Client
+	lock   *Lock
+	ttl    time.Duration
+
+	// expires is when the lock expires according to the local clock,
+	// measured from before the last successful refresh was sent
+	expires time.Time
+
+	lost     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     sync.WaitGroup
+}
+
+// Token returns the fencing token of the lock.
+func (l *HeldLock) Token() uint64 {
+	return l.lock.Token
+}
+
+// Lost returns a channel which is closed if the lock is lost because it could
+// not be refreshed before expiring or was acquired by another session.
+func (l *HeldLock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock stops refreshing the lock and releases it, returning ErrLockLost if
+// it is no longer held.
+func (l *HeldLock) Unlock() error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	l.done.Wait()
+	select {
+	case <-l.lost:
+		return ErrLockLost
+	default:
+	}
+	if err := l.client.ReleaseLock(l.lock); hh.IsPreconditionFailedError(err) {
+		return ErrLockLost
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (l *HeldLock) refresh() {
+	defer l.done.Done()
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		lock := &Lock{Name: l.lock.Name, Session: l.lock.Session, TTL: l.lock.TTL}
+		start := time.Now()
+		err := l.client.AcquireLock(lock)
+		if err == nil && lock.Token == l.lock.Token {
+			l.expires = start.Add(l.ttl)
+			continue
+		}
+
+		// The lock is lost if it changed hands (a different token means
+		// it expired before being refreshed), otherwise keep retrying
+		// transient errors until it expires.
+		if err == nil {
+			// release the lock which was just reacquired so that
+			// other sessions don't wait for it to expire
+			l.client.ReleaseLock(lock)
+		}
+		if err == nil || IsLockHeld(err) || time.Now().After(l.expires) {
+			close(l.lost)
+			return
+		}
+	}
+}
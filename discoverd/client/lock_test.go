@@ -0,0 +1,117 @@
+package discoverd_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/discoverd/server"
+	. "github.com/flynn/go-check"
+)
+
+// runLockServer runs a single node store behind an HTTP handler, as locks
+// don't need the rest of a discoverd server.
+func runLockServer(c *C) (*discoverd.Client, *server.Store, func()) {
+	dir, err := ioutil.TempDir("", "discoverd-lock-")
+	c.Assert(err, IsNil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	store := server.NewStore(dir)
+	store.Listener = ln
+	store.Advertise = ln.Addr()
+	store.HeartbeatTimeout = 50 * time.Millisecond
+	store.ElectionTimeout = 50 * time.Millisecond
+	store.LeaderLeaseTimeout = 50 * time.Millisecond
+	store.CommitTimeout = 5 * time.Millisecond
+	store.EnableSingleNode = true
+	store.LogOutput = ioutil.Discard
+	c.Assert(store.Open(), IsNil)
+	select {
+	case <-store.LeaderCh():
+	case <-time.After(10 * time.Second):
+		c.Fatal("timed out waiting for leadership")
+	}
+
+	h := server.NewHandler(false, nil)
+	h.Store = store
+	srv := httptest.NewServer(h)
+	return discoverd.NewClientWithURL(srv.URL), store, func() {
+		srv.Close()
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func (s *ClientSuite) TestLock(c *C) {
+	client, _, cleanup := runLockServer(c)
+	defer cleanup()
+	defer func(interval time.Duration) { discoverd.LockRetryInterval = interval }(discoverd.LockRetryInterval)
+	discoverd.LockRetryInterval = 10 * time.Millisecond
+
+	lock, err := client.Lock("lock0", time.Second, time.Second)
+	c.Assert(err, IsNil)
+	token := lock.Token()
+
+	// the lock is held by one session at a time
+	_, err = client.TryLock("lock0", time.Second)
+	c.Assert(discoverd.IsLockHeld(err), Equals, true)
+	_, err = client.Lock("lock0", time.Second, 50*time.Millisecond)
+	c.Assert(err, Equals, discoverd.ErrLockTimeout)
+
+	// the lock is refreshed whilst held, keeping its token
+	time.Sleep(1500 * time.Millisecond)
+	select {
+	case <-lock.Lost():
+		c.Fatal("lock lost")
+	default:
+	}
+	state, err := client.GetLock("lock0")
+	c.Assert(err, IsNil)
+	c.Assert(state.Token, Equals, token)
+
+	// a waiting session acquires the lock once released, with a higher
+	// fencing token
+	acquired := make(chan *discoverd.HeldLock)
+	go func() {
+		l, err := client.Lock("lock0", time.Second, 5*time.Second)
+		c.Check(err, IsNil)
+		acquired <- l
+	}()
+	c.Assert(lock.Unlock(), IsNil)
+	var next *discoverd.HeldLock
+	select {
+	case next = <-acquired:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for lock")
+	}
+	c.Assert(next, NotNil)
+	c.Assert(next.Token() > token, Equals, true)
+	c.Assert(next.Unlock(), IsNil)
+}
+
+func (s *ClientSuite) TestLockLost(c *C) {
+	client, store, cleanup := runLockServer(c)
+	defer cleanup()
+
+	lock, err := client.TryLock("lock0", time.Second)
+	c.Assert(err, IsNil)
+
+	// simulate the lock expiring and being acquired by another session
+	// before the holder refreshes it
+	now := time.Now()
+	store.Now = func() time.Time { return now.Add(time.Minute) }
+	other := &discoverd.Lock{Name: "lock0", Session: "other", TTL: 60}
+	c.Assert(client.AcquireLock(other), IsNil)
+	c.Assert(other.Token > lock.Token(), Equals, true)
+
+	select {
+	case <-lock.Lost():
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for lock to be lost")
+	}
+	c.Assert(lock.Unlock(), Equals, discoverd.ErrLockLost)
+}
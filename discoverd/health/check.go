@@ -7,6 +7,9 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os/exec"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -18,6 +21,8 @@ type Check interface {
 
 var _ Check = &TCPCheck{}
 var _ Check = &HTTPCheck{}
+var _ Check = &ExecCheck{}
+var _ Check = &GRPCCheck{}
 
 type TCPCheck struct {
 	Addr    string
@@ -113,3 +118,81 @@ func (c *HTTPCheck) Check() error {
 func (c *HTTPCheck) String() string {
 	return c.URL
 }
+
+// ExecCheck runs a command, passing if it exits with status 0.
+type ExecCheck struct {
+	// Args is the command to run and its arguments.
+	Args []string
+	// Env is the environment of the command, it defaults to the environment
+	// of the current process.
+	Env []string
+	// Dir is the working directory of the command.
+	Dir string
+	// Credential is the user and group the command runs as, it defaults to
+	// those of the current process.
+	Credential *syscall.Credential
+
+	// The command is killed if it has not exited after Timeout.
+	Timeout time.Duration
+}
+
+func (c *ExecCheck) Check() error {
+	if len(c.Args) == 0 {
+		return fmt.Errorf("healthcheck: no command to run")
+	}
+	cmd := exec.Command(c.Args[0], c.Args[1:]...)
+	cmd.Env = c.Env
+	cmd.Dir = c.Dir
+	var out bytes.Buffer
+	cmd.Stdout = &limitedWriter{W: &out, N: 512}
+	cmd.Stderr = cmd.Stdout
+	// run the command in its own process group so that any children it
+	// starts are also killed on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: c.Credential}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("healthcheck: command failed: %s: %s", err, strings.TrimSpace(out.String()))
+		}
+		return nil
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return fmt.Errorf("healthcheck: command timed out after %s", timeout)
+	}
+}
+
+func (c *ExecCheck) String() string {
+	return "exec: " + strings.Join(c.Args, " ")
+}
+
+// limitedWriter writes up to N bytes to W and discards the rest, so that the
+// output of a check command can be included in errors.
+type limitedWriter struct {
+	W io.Writer
+	N int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > l.N {
+		p = p[:l.N]
+	}
+	if len(p) > 0 {
+		if _, err := l.W.Write(p); err != nil {
+			return 0, err
+		}
+		l.N -= len(p)
+	}
+	return n, nil
+}
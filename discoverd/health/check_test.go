@@ -1,14 +1,18 @@
 package health
 
 import (
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	. "github.com/flynn/go-check"
+	"golang.org/x/net/http2"
 )
 
 // Hook gocheck up to the "go test" runner
@@ -153,3 +157,119 @@ func (CheckSuite) TestHTTPInvalidURL(c *C) {
 	c.Assert(err, NotNil)
 	c.Assert(strings.Contains(err.Error(), "invalid URL escape"), Equals, true, Commentf("err = %s", err))
 }
+
+func (CheckSuite) TestExec(c *C) {
+	c.Assert((&ExecCheck{Args: []string{"true"}}).Check(), IsNil)
+
+	// the environment and directory are passed to the command
+	err := (&ExecCheck{
+		Args: []string{"sh", "-c", `test "$FOO" = bar && test "$(pwd)" = /`},
+		Env:  []string{"FOO=bar"},
+		Dir:  "/",
+	}).Check()
+	c.Assert(err, IsNil)
+}
+
+func (CheckSuite) TestExecCredential(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("running commands as another user requires root")
+	}
+	err := (&ExecCheck{
+		Args:       []string{"sh", "-c", `test "$(id -u):$(id -g)" = 65534:65534`},
+		Credential: &syscall.Credential{Uid: 65534, Gid: 65534},
+	}).Check()
+	c.Assert(err, IsNil)
+}
+
+func (CheckSuite) TestExecFailure(c *C) {
+	err := (&ExecCheck{Args: []string{"sh", "-c", "echo not ready; exit 1"}}).Check()
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "healthcheck: command failed: exit status 1: not ready")
+
+	err = (&ExecCheck{Args: []string{"/nonexistent"}}).Check()
+	c.Assert(err, NotNil)
+}
+
+func (CheckSuite) TestExecTimeout(c *C) {
+	err := (&ExecCheck{
+		Args:    []string{"sh", "-c", "sleep 10; true"},
+		Timeout: 50 * time.Millisecond,
+	}).Check()
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "healthcheck: command timed out after 50ms")
+}
+
+// grpcHealthHandler implements grpc.health.v1.Health/Check, responding with
+// the status of the requested service.
+func grpcHealthHandler(statuses map[string]grpcServingStatus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		body, _ := ioutil.ReadAll(r.Body)
+		msg, err := grpcUnframe(body)
+		if r.URL.Path != "/grpc.health.v1.Health/Check" || err != nil {
+			w.Header().Set("Grpc-Status", "12") // UNIMPLEMENTED
+			return
+		}
+		// the request is either empty or contains just the service name
+		var service string
+		if len(msg) > 2 {
+			service = string(msg[2:])
+		}
+		status, ok := statuses[service]
+		if !ok {
+			w.Header().Set("Grpc-Status", "5") // NOT_FOUND
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		w.Write(grpcFrame([]byte{1 << 3, byte(status)}))
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+func (CheckSuite) TestGRPC(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	handler := grpcHealthHandler(map[string]grpcServingStatus{
+		"":     grpcStatusServing,
+		"down": grpcStatusNotServing,
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+
+	c.Assert((&GRPCCheck{Addr: l.Addr().String()}).Check(), IsNil)
+
+	err = (&GRPCCheck{Addr: l.Addr().String(), Service: "down"}).Check()
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "healthcheck: expected gRPC serving status SERVING, got NOT_SERVING")
+
+	err = (&GRPCCheck{Addr: l.Addr().String(), Service: "foo"}).Check()
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "healthcheck: gRPC error status 5: unknown service")
+}
+
+func (CheckSuite) TestGRPCTLS(c *C) {
+	srv := httptest.NewUnstartedServer(grpcHealthHandler(map[string]grpcServingStatus{"": grpcStatusServing}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	c.Assert((&GRPCCheck{Addr: srv.Listener.Addr().String(), TLS: true}).Check(), IsNil)
+}
+
+func (CheckSuite) TestGRPCConnectRefused(c *C) {
+	err := (&GRPCCheck{
+		Addr:    "127.0.0.1:65535",
+		Timeout: 100 * time.Millisecond,
+	}).Check()
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), "connection refused"), Equals, true, Commentf("err = %s", err))
+}
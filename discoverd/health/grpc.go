@@ -0,0 +1,224 @@
+package health
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// grpcServingStatus is the ServingStatus enum of grpc.health.v1.HealthCheckResponse
+type grpcServingStatus uint64
+
+const (
+	grpcStatusUnknown grpcServingStatus = iota
+	grpcStatusServing
+	grpcStatusNotServing
+	grpcStatusServiceUnknown
+)
+
+func (s grpcServingStatus) String() string {
+	switch s {
+	case grpcStatusServing:
+		return "SERVING"
+	case grpcStatusNotServing:
+		return "NOT_SERVING"
+	case grpcStatusServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// GRPCCheck calls the Check method of the standard gRPC health checking
+// protocol (grpc.health.v1.Health), passing if the status is SERVING.
+type GRPCCheck struct {
+	Addr string
+
+	// Service is the name of the service to check, the overall health of the
+	// server is checked if it is empty.
+	Service string
+
+	// TLS connects using TLS rather than plaintext HTTP/2. Certificates are
+	// not verified.
+	TLS bool
+
+	Timeout time.Duration
+}
+
+func (c *GRPCCheck) Check() error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http2.Transport{
+		TLSClientConfig: &tls.Config{
+			// Don't verify TLS certificates since this is just a health check,
+			// not a connection that needs confidentiality or authenticity.
+			InsecureSkipVerify: true,
+		},
+	}
+	scheme := "https"
+	transport.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		return tls.DialWithDialer(dialer, network, addr, cfg)
+	}
+	if !c.TLS {
+		// gRPC servers accept HTTP/2 without TLS using prior knowledge
+		scheme = "http"
+		transport.AllowHTTP = true
+		transport.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		}
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: timeout}
+
+	req, err := http.NewRequest("POST", scheme+"://"+c.Addr+"/grpc.health.v1.Health/Check", bytes.NewReader(grpcFrame(grpcHealthCheckRequest(c.Service))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("healthcheck: expected HTTP status 200, got %d", res.StatusCode)
+	}
+
+	// Read the response message before the trailers, which are only
+	// available once the body has been read.
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(io.LimitReader(res.Body, 1024)); err != nil {
+		return err
+	}
+
+	// Errors are returned in the trailers, or in the headers if there is no
+	// response message.
+	status := res.Trailer.Get("Grpc-Status")
+	message := res.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = res.Header.Get("Grpc-Status")
+		message = res.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		return fmt.Errorf("healthcheck: gRPC error status %s: %s", status, message)
+	}
+
+	msg, err := grpcUnframe(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	servingStatus, err := grpcHealthCheckResponseStatus(msg)
+	if err != nil {
+		return err
+	}
+	if servingStatus != grpcStatusServing {
+		return fmt.Errorf("healthcheck: expected gRPC serving status SERVING, got %s", servingStatus)
+	}
+	return nil
+}
+
+func (c *GRPCCheck) String() string {
+	s := "grpc://" + c.Addr
+	if c.Service != "" {
+		s += "/" + c.Service
+	}
+	return s
+}
+
+// grpcFrame prefixes msg with the gRPC message header, which consists of an
+// uncompressed flag followed by the big endian length.
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(msg)))
+	copy(frame[5:], msg)
+	return frame
+}
+
+var errGRPCFrame = errors.New("healthcheck: invalid gRPC response message")
+
+func grpcUnframe(frame []byte) ([]byte, error) {
+	if len(frame) < 5 || frame[0] != 0 {
+		return nil, errGRPCFrame
+	}
+	n := binary.BigEndian.Uint32(frame[1:5])
+	if uint32(len(frame)-5) < n {
+		return nil, errGRPCFrame
+	}
+	return frame[5 : 5+n], nil
+}
+
+// The health check messages are small enough to encode by hand rather than
+// depending on a protobuf library:
+//
+//     message HealthCheckRequest { string service = 1; }
+//     message HealthCheckResponse { ServingStatus status = 1; }
+
+func grpcHealthCheckRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	// field 1, wire type 2 (length delimited)
+	buf := []byte{1<<3 | 2}
+	buf = appendUvarint(buf, uint64(len(service)))
+	return append(buf, service...)
+}
+
+func grpcHealthCheckResponseStatus(msg []byte) (grpcServingStatus, error) {
+	status := grpcStatusUnknown
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, errGRPCFrame
+		}
+		msg = msg[n:]
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case 0: // varint
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0, errGRPCFrame
+			}
+			msg = msg[n:]
+			if field == 1 {
+				status = grpcServingStatus(v)
+			}
+		case 1: // 64-bit
+			if len(msg) < 8 {
+				return 0, errGRPCFrame
+			}
+			msg = msg[8:]
+		case 2: // length delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return 0, errGRPCFrame
+			}
+			msg = msg[n+int(l):]
+		case 5: // 32-bit
+			if len(msg) < 4 {
+				return 0, errGRPCFrame
+			}
+			msg = msg[4:]
+		default:
+			return 0, errGRPCFrame
+		}
+	}
+	return status, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
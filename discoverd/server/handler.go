@@ -64,6 +64,14 @@ func NewHandler(proxy bool, peers []string) *Handler {
 	r.PUT("/services/:service/leader", h.servePutLeader)
 	r.GET("/services/:service/leader", h.serveGetLeader)
 
+	r.PUT("/kv/*key", h.servePutKey)
+	r.DELETE("/kv/*key", h.serveDeleteKey)
+	r.GET("/kv/*key", h.serveGetKey)
+
+	r.PUT("/locks/:name", h.servePutLock)
+	r.DELETE("/locks/:name", h.serveDeleteLock)
+	r.GET("/locks/:name", h.serveGetLock)
+
 	r.GET("/raft/leader", h.serveGetRaftLeader)
 	r.GET("/raft/peers", h.serveGetRaftPeers)
 	r.PUT("/raft/peers/:peer", h.servePutRaftPeer)
@@ -102,6 +110,16 @@ type Handler struct {
 		ServiceLeader(service string) (*discoverd.Instance, error)
 		Subscribe(service string, sendCurrent bool, kinds discoverd.EventKind, ch chan *discoverd.Event) stream.Stream
 
+		Key(key string) *discoverd.KVPair
+		Keys(prefix string) []*discoverd.KVPair
+		SetKey(pair *discoverd.KVPair, cas bool) error
+		DeleteKey(key string, index uint64, recurse bool) error
+		WatchKeys(prefix string, sendCurrent bool, ch chan *discoverd.Event) stream.Stream
+
+		Lock(name string) *discoverd.Lock
+		AcquireLock(lock *discoverd.Lock) error
+		ReleaseLock(name, session string, token uint64) error
+
 		AddPeer(peer string) error
 		RemovePeer(peer string) error
 		GetPeers() ([]string, error)
@@ -373,6 +391,225 @@ func (h *Handler) serveGetLeader(w http.ResponseWriter, r *http.Request, params
 	hh.JSON(w, 200, leader)
 }
 
+// servePutKey sets the value of a key, optionally checking its current index
+// if the index query parameter is set.
+func (h *Handler) servePutKey(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	// Retrieve the path parameter.
+	key := params.ByName("key")
+	if err := ValidKey(key); err != nil {
+		hh.ValidationError(w, "key", err.Error())
+		return
+	}
+
+	// Read the pair from the request.
+	pair := &discoverd.KVPair{}
+	if err := hh.DecodeJSON(r, pair); err != nil {
+		hh.Error(w, err)
+		return
+	}
+	pair.Key = key
+	pair.Index = 0
+	pair.Expires = nil
+	if pair.TTL < 0 {
+		hh.ValidationError(w, "ttl", "ttl must not be negative")
+		return
+	}
+
+	// Only compare the index if it is given in the query.
+	var cas bool
+	if s := r.URL.Query().Get("index"); s != "" {
+		index, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			hh.ValidationError(w, "index", "index must be an integer")
+			return
+		}
+		pair.Index = index
+		cas = true
+	}
+
+	// Set the key in the store.
+	if err := h.Store.SetKey(pair, cas); err == ErrNotLeader {
+		h.redirectToLeader(w, r)
+		return
+	} else if err != nil {
+		hh.Error(w, err)
+		return
+	}
+
+	// Write pair back to response.
+	hh.JSON(w, 200, pair)
+}
+
+// serveDeleteKey deletes a key, or all keys with the given prefix if the
+// recurse query parameter is set.
+func (h *Handler) serveDeleteKey(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	// Retrieve the path and query parameters.
+	recurse := r.URL.Query().Get("recurse") == "true"
+	key, err := keyParam(params, recurse)
+	if err != nil {
+		hh.ValidationError(w, "key", err.Error())
+		return
+	}
+	var index uint64
+	if s := r.URL.Query().Get("index"); s != "" {
+		if index, err = strconv.ParseUint(s, 10, 64); err != nil {
+			hh.ValidationError(w, "index", "index must be an integer")
+			return
+		}
+	}
+
+	// Delete from the store.
+	if err := h.Store.DeleteKey(key, index, recurse); err == ErrNotLeader {
+		h.redirectToLeader(w, r)
+		return
+	} else if IsNotFound(err) {
+		hh.ObjectNotFoundError(w, err.Error())
+		return
+	} else if err != nil {
+		hh.Error(w, err)
+		return
+	}
+}
+
+// serveGetKey returns a key, lists all keys with the given prefix if the
+// recurse query parameter is set, or streams changes to keys with the given
+// prefix.
+func (h *Handler) serveGetKey(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	// Retrieve the path and query parameters.
+	stream := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	recurse := stream || r.URL.Query().Get("recurse") == "true"
+	key, err := keyParam(params, recurse)
+	if err != nil {
+		hh.ValidationError(w, "key", err.Error())
+		return
+	}
+
+	// Process as a stream if that's what the client wants.
+	if stream {
+		h.serveKeyStream(w, key)
+		return
+	}
+
+	// Write all keys with the prefix to the response if requested.
+	if recurse {
+		hh.JSON(w, 200, h.Store.Keys(key))
+		return
+	}
+
+	// Otherwise read the key from the store.
+	pair := h.Store.Key(key)
+	if pair == nil {
+		hh.ObjectNotFoundError(w, "key not found")
+		return
+	}
+
+	// Write pair to the response.
+	hh.JSON(w, 200, pair)
+}
+
+// keyParam returns the validated key path parameter, which may be a prefix
+// (including the root prefix) with the trailing slash removed if recurse is
+// true.
+func keyParam(params httprouter.Params, recurse bool) (string, error) {
+	key := params.ByName("key")
+	if !recurse {
+		return key, ValidKey(key)
+	}
+	if key != "/" {
+		key = strings.TrimSuffix(key, "/")
+	}
+	return key, ValidKeyPrefix(key)
+}
+
+// serveKeyStream creates a key subscription and streams out events in SSE format.
+func (h *Handler) serveKeyStream(w http.ResponseWriter, prefix string) {
+	// Create a buffered channel to receive events.
+	ch := make(chan *discoverd.Event, StreamBufferSize)
+
+	// Subscribe to key events on the store.
+	stream := h.Store.WatchKeys(prefix, true, ch)
+
+	// Create and serve an SSE stream.
+	s := sse.NewStream(w, ch, nil)
+	s.Serve()
+	s.Wait()
+	stream.Close()
+
+	// Check if there was an error while closing.
+	if err := stream.Err(); err != nil {
+		s.CloseWithError(err)
+	}
+}
+
+// servePutLock acquires or refreshes a lock.
+func (h *Handler) servePutLock(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	// Retrieve the path parameter.
+	name := params.ByName("name")
+	if err := ValidLockName(name); err != nil {
+		hh.ValidationError(w, "name", err.Error())
+		return
+	}
+
+	// Read the lock from the request.
+	lock := &discoverd.Lock{}
+	if err := hh.DecodeJSON(r, lock); err != nil {
+		hh.Error(w, err)
+		return
+	}
+	lock.Name = name
+	if lock.Session == "" {
+		hh.ValidationError(w, "session", "must not be empty")
+		return
+	} else if lock.TTL <= 0 {
+		hh.ValidationError(w, "ttl", "must be positive")
+		return
+	}
+
+	// Acquire the lock in the store.
+	if err := h.Store.AcquireLock(lock); err == ErrNotLeader {
+		h.redirectToLeader(w, r)
+		return
+	} else if err != nil {
+		hh.Error(w, err)
+		return
+	}
+
+	// Write lock back to response.
+	hh.JSON(w, 200, lock)
+}
+
+// serveDeleteLock releases a lock held by the session and token given in the
+// query parameters.
+func (h *Handler) serveDeleteLock(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	// Retrieve the path and query parameters.
+	name := params.ByName("name")
+	session := r.URL.Query().Get("session")
+	token, err := strconv.ParseUint(r.URL.Query().Get("token"), 10, 64)
+	if err != nil {
+		hh.ValidationError(w, "token", "must be an integer")
+		return
+	}
+
+	// Release the lock in the store.
+	if err := h.Store.ReleaseLock(name, session, token); err == ErrNotLeader {
+		h.redirectToLeader(w, r)
+		return
+	} else if err != nil {
+		hh.Error(w, err)
+		return
+	}
+}
+
+// serveGetLock returns the current state of a lock.
+func (h *Handler) serveGetLock(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	lock := h.Store.Lock(params.ByName("name"))
+	if lock == nil {
+		hh.ObjectNotFoundError(w, "lock not found")
+		return
+	}
+	hh.JSON(w, 200, lock)
+}
+
 // servePing returns a 200 OK.
 func (h *Handler) servePing(w http.ResponseWriter, r *http.Request, params httprouter.Params) {}
 
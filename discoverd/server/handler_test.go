@@ -13,6 +13,7 @@ import (
 
 	discoverd "github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/discoverd/server"
+	hh "github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/stream"
 )
 
@@ -705,6 +706,246 @@ func TestHandler_GetLeader_ErrNoLeader(t *testing.T) {
 	}
 }
 
+// Ensure the handler can set a key.
+func TestHandler_PutKey(t *testing.T) {
+	h := NewHandler()
+
+	// Mock the store key assignment.
+	var called bool
+	h.Store.SetKeyFn = func(pair *discoverd.KVPair, cas bool) error {
+		called = true
+		if !reflect.DeepEqual(pair, &discoverd.KVPair{Key: "/foo/bar", Value: "baz", TTL: 10}) {
+			t.Fatalf("unexpected pair: %#v", pair)
+		} else if cas {
+			t.Fatal("unexpected cas")
+		}
+		pair.Index = 12
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("PUT", "/kv/foo/bar", strings.NewReader(`{"value":"baz","ttl":10,"index":5}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if !called {
+		t.Fatal("Store.SetKey() not called")
+	} else if w.Body.String() != `{"key":"/foo/bar","value":"baz","index":12,"ttl":10}` {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
+
+// Ensure the handler compares the index of a key if given.
+func TestHandler_PutKey_CAS(t *testing.T) {
+	h := NewHandler()
+	h.Store.SetKeyFn = func(pair *discoverd.KVPair, cas bool) error {
+		if pair.Index != 5 || !cas {
+			t.Fatalf("unexpected index: %d (cas = %t)", pair.Index, cas)
+		}
+		return hh.PreconditionFailedErr(`Key "/foo" exists, but wrong index provided`)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("PUT", "/kv/foo?index=5", strings.NewReader(`{"value":"baz"}`)))
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	}
+}
+
+// Ensure the handler returns an error for an invalid key.
+func TestHandler_PutKey_ErrInvalidKey(t *testing.T) {
+	h := NewHandler()
+	for _, path := range []string{"/kv/", "/kv/foo/", "/kv/foo//bar"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, MustNewHTTPRequest("PUT", path, strings.NewReader(`{"value":"baz"}`)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("unexpected status code for %s: %d", path, w.Code)
+		}
+	}
+}
+
+// Ensure the handler can retrieve a key.
+func TestHandler_GetKey(t *testing.T) {
+	h := NewHandler()
+	h.Store.KeyFn = func(key string) *discoverd.KVPair {
+		if key != "/foo/bar" {
+			t.Fatalf("unexpected key: %s", key)
+		}
+		return &discoverd.KVPair{Key: key, Value: "baz", Index: 12}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("GET", "/kv/foo/bar", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if w.Body.String() != `{"key":"/foo/bar","value":"baz","index":12}` {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
+
+// Ensure the handler returns an error if the key cannot be found.
+func TestHandler_GetKey_ErrNotFound(t *testing.T) {
+	h := NewHandler()
+	h.Store.KeyFn = func(key string) *discoverd.KVPair { return nil }
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("GET", "/kv/foo", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if w.Body.String() != `{"code":"object_not_found","message":"key not found","retry":false}` {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
+
+// Ensure the handler can list keys with a prefix.
+func TestHandler_GetKey_Recurse(t *testing.T) {
+	h := NewHandler()
+	h.Store.KeysFn = func(prefix string) []*discoverd.KVPair {
+		if prefix != "/foo" {
+			t.Fatalf("unexpected prefix: %s", prefix)
+		}
+		return []*discoverd.KVPair{{Key: "/foo/bar", Value: "baz", Index: 12}}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("GET", "/kv/foo/?recurse=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if w.Body.String() != `[{"key":"/foo/bar","value":"baz","index":12}]` {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
+
+// Ensure the handler can stream key events.
+func TestHandler_GetKey_Stream(t *testing.T) {
+	h := NewHandler()
+	h.Store.WatchKeysFn = func(prefix string, sendCurrent bool, ch chan *discoverd.Event) stream.Stream {
+		if prefix != "/" {
+			t.Fatalf("unexpected prefix: %s", prefix)
+		} else if !sendCurrent {
+			t.Fatal("expected send current")
+		}
+
+		ch <- &discoverd.Event{Kind: discoverd.EventKindKeySet, KV: &discoverd.KVPair{Key: "/foo", Value: "bar", Index: 1}}
+		close(ch)
+		return chanStream(ch)
+	}
+
+	w := httptest.NewRecorder()
+	r := MustNewHTTPRequest("GET", "/kv/", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if w.Body.String() != `data: {"service":"","kind":"key_set","kv":{"key":"/foo","value":"bar","index":1}}`+"\n\n" {
+		t.Fatalf("unexpected body: %q", w.Body.String())
+	}
+}
+
+// Ensure the handler can delete keys recursively.
+func TestHandler_DeleteKey(t *testing.T) {
+	h := NewHandler()
+
+	var called bool
+	h.Store.DeleteKeyFn = func(key string, index uint64, recurse bool) error {
+		called = true
+		if key != "/foo" || index != 5 || !recurse {
+			t.Fatalf("unexpected args: %s, %d, %t", key, index, recurse)
+		}
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("DELETE", "/kv/foo/?index=5&recurse=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if !called {
+		t.Fatal("Store.DeleteKey() not called")
+	}
+}
+
+// Ensure the handler returns an error if the key cannot be found.
+func TestHandler_DeleteKey_ErrNotFound(t *testing.T) {
+	h := NewHandler()
+	h.Store.DeleteKeyFn = func(key string, index uint64, recurse bool) error {
+		return server.NotFoundError{Key: key}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("DELETE", "/kv/foo", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if w.Body.String() != `{"code":"object_not_found","message":"discoverd: key \"/foo\" not found","retry":false}` {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
+
+// Ensure the handler can acquire a lock.
+func TestHandler_PutLock(t *testing.T) {
+	h := NewHandler()
+	h.Store.AcquireLockFn = func(lock *discoverd.Lock) error {
+		if lock.Name != "lock0" || lock.Session != "session0" || lock.TTL != 10 {
+			t.Fatalf("unexpected lock: %#v", lock)
+		}
+		lock.Token = 12
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("PUT", "/locks/lock0", strings.NewReader(`{"session":"session0","ttl":10}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if w.Body.String() != `{"name":"lock0","session":"session0","token":12,"ttl":10,"expires":"0001-01-01T00:00:00Z"}` {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
+
+// Ensure the handler returns a conflict if the lock is held.
+func TestHandler_PutLock_ErrConflict(t *testing.T) {
+	h := NewHandler()
+	h.Store.AcquireLockFn = func(lock *discoverd.Lock) error {
+		return hh.ConflictErr(`Lock "lock0" is held by another session`)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("PUT", "/locks/lock0", strings.NewReader(`{"session":"session0","ttl":10}`)))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	}
+}
+
+// Ensure the handler validates locks.
+func TestHandler_PutLock_ErrInvalid(t *testing.T) {
+	h := NewHandler()
+	for _, body := range []string{`{"ttl":10}`, `{"session":"session0"}`} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, MustNewHTTPRequest("PUT", "/locks/lock0", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("unexpected status code for %s: %d", body, w.Code)
+		}
+	}
+}
+
+// Ensure the handler can release a lock.
+func TestHandler_DeleteLock(t *testing.T) {
+	h := NewHandler()
+	var called bool
+	h.Store.ReleaseLockFn = func(name, session string, token uint64) error {
+		called = true
+		if name != "lock0" || session != "session0" || token != 12 {
+			t.Fatalf("unexpected args: %s, %s, %d", name, session, token)
+		}
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewHTTPRequest("DELETE", "/locks/lock0?session=session0&token=12", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if !called {
+		t.Fatal("Store.ReleaseLock() not called")
+	}
+}
+
 // Handler represents a test wrapper for server.Handler.
 type Handler struct {
 	*server.Handler
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/discoverd/client"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/stream"
+	"github.com/hashicorp/raft"
+)
+
+var ErrInvalidKey = errors.New("discoverd: key must be a \"/\" separated path with no empty segments")
+
+// ValidKey returns nil if key is a valid key path, for example "/foo/bar".
+// Otherwise returns an error.
+func ValidKey(key string) error {
+	if !strings.HasPrefix(key, "/") || key == "/" || strings.HasSuffix(key, "/") || strings.Contains(key, "//") {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// ValidKeyPrefix returns nil if prefix is either a valid key or the root
+// prefix "/". Otherwise returns an error.
+func ValidKeyPrefix(prefix string) error {
+	if prefix == "/" {
+		return nil
+	}
+	return ValidKey(prefix)
+}
+
+// keyHasPrefix returns whether key is either prefix itself or below it.
+func keyHasPrefix(key, prefix string) bool {
+	return prefix == "/" || key == prefix || strings.HasPrefix(key, prefix+"/")
+}
+
+// Key returns the pair stored at key, or nil if it does not exist.
+func (s *Store) Key(key string) *discoverd.KVPair {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Keys[key]
+}
+
+// Keys returns the pairs stored at prefix and below it, sorted by key.
+func (s *Store) Keys(prefix string) []*discoverd.KVPair {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys(prefix)
+}
+
+func (s *Store) keys(prefix string) []*discoverd.KVPair {
+	pairs := make([]*discoverd.KVPair, 0)
+	for key, pair := range s.data.Keys {
+		if keyHasPrefix(key, prefix) {
+			pairs = append(pairs, pair)
+		}
+	}
+	sort.Sort(kvPairSlice(pairs))
+	return pairs
+}
+
+// SetKey sets the value stored at pair.Key and updates pair with the index
+// it was set at. If cas is true, the key is only set if its current index is
+// pair.Index (with a zero index meaning that the key must not exist).
+func (s *Store) SetKey(pair *discoverd.KVPair, cas bool) error {
+	if err := ValidKey(pair.Key); err != nil {
+		return err
+	}
+
+	// Determine the expiry time on the leader so that it is the same on
+	// all peers.
+	pair.Expires = nil
+	if pair.TTL > 0 {
+		expires := s.Now().Add(time.Duration(pair.TTL) * time.Second)
+		pair.Expires = &expires
+	}
+
+	// Serialize command.
+	cmd, err := json.Marshal(&setKeyCommand{
+		Pair: pair,
+		CAS:  cas,
+	})
+	if err != nil {
+		return err
+	}
+
+	index, err := s.raftApply(setKeyCommandType, cmd)
+	if err != nil {
+		return err
+	}
+	pair.Index = index
+
+	return nil
+}
+
+func (s *Store) applySetKeyCommand(cmd []byte, index uint64) error {
+	var c setKeyCommand
+	if err := json.Unmarshal(cmd, &c); err != nil {
+		return err
+	}
+
+	// Check the index if a compare-and-set was requested.
+	key := c.Pair.Key
+	curr := s.data.Keys[key]
+	if c.CAS {
+		if c.Pair.Index == 0 {
+			if curr != nil {
+				return hh.ObjectExistsErr(fmt.Sprintf("Key %q already exists, use index=n to set", key))
+			}
+		} else {
+			if curr == nil {
+				return hh.PreconditionFailedErr(fmt.Sprintf("Key %q does not exist, use index=0 to set", key))
+			} else if curr.Index != c.Pair.Index {
+				return hh.PreconditionFailedErr(fmt.Sprintf("Key %q exists, but wrong index provided", key))
+			}
+		}
+	}
+
+	// Update the pair and set the index.
+	c.Pair.Index = index
+	s.data.Keys[key] = c.Pair
+
+	s.broadcastKey(&discoverd.Event{
+		Kind: discoverd.EventKindKeySet,
+		KV:   c.Pair,
+	})
+
+	return nil
+}
+
+// DeleteKey deletes the pair stored at key. If index is non-zero then the key
+// is only deleted if its current index is the same, and if recurse is true
+// then all keys below key are also deleted.
+func (s *Store) DeleteKey(key string, index uint64, recurse bool) error {
+	if recurse {
+		if err := ValidKeyPrefix(key); err != nil {
+			return err
+		}
+	} else if err := ValidKey(key); err != nil {
+		return err
+	}
+
+	// Serialize command.
+	cmd, err := json.Marshal(&deleteKeyCommand{
+		Key:     key,
+		Index:   index,
+		Recurse: recurse,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.raftApply(deleteKeyCommandType, cmd); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) applyDeleteKeyCommand(cmd []byte) error {
+	var c deleteKeyCommand
+	if err := json.Unmarshal(cmd, &c); err != nil {
+		return err
+	}
+
+	// Check the index of the key itself.
+	curr := s.data.Keys[c.Key]
+	if c.Index > 0 && (curr == nil || curr.Index != c.Index) {
+		return hh.PreconditionFailedErr(fmt.Sprintf("Key %q does not exist or wrong index provided", c.Key))
+	}
+
+	// Determine the pairs to delete.
+	var pairs []*discoverd.KVPair
+	if c.Recurse {
+		pairs = s.keys(c.Key)
+	} else if curr != nil {
+		pairs = []*discoverd.KVPair{curr}
+	}
+	if len(pairs) == 0 {
+		return NotFoundError{Key: c.Key}
+	}
+
+	for _, pair := range pairs {
+		s.deleteKey(pair)
+	}
+
+	return nil
+}
+
+// deleteKey removes pair from the store and broadcasts the deletion.
+func (s *Store) deleteKey(pair *discoverd.KVPair) {
+	delete(s.data.Keys, pair.Key)
+	s.broadcastKey(&discoverd.Event{
+		Kind: discoverd.EventKindKeyDelete,
+		KV:   pair,
+	})
+}
+
+// EnforceKeyExpiry checks all keys with a TTL for expiration and issues an
+// expiration command, if necessary.
+// This function returns raft.ErrNotLeader if this store is not the current leader.
+func (s *Store) EnforceKeyExpiry() error {
+	var cmd []byte
+	if err := func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if !s.IsLeader() {
+			return raft.ErrNotLeader
+		}
+
+		// Expiry times are stored in the log so, unlike instances, keys
+		// can be expired as soon as leadership is established.
+		now := s.Now()
+		var keys []expireKey
+		for _, pair := range s.data.Keys {
+			if pair.Expires == nil || pair.Expires.After(now) {
+				continue
+			}
+
+			// The index is added to prevent a race condition of the key
+			// being set again while this command is applying.
+			keys = append(keys, expireKey{
+				Key:   pair.Key,
+				Index: pair.Index,
+			})
+		}
+
+		// If we have no keys to expire then exit.
+		if len(keys) == 0 {
+			return nil
+		}
+
+		// Create command to expire keys.
+		buf, err := json.Marshal(&expireKeysCommand{
+			Keys: keys,
+		})
+		if err != nil {
+			return err
+		}
+		cmd = buf
+
+		return nil
+	}(); err != nil {
+		return err
+	} else if cmd == nil {
+		return nil
+	}
+
+	// Apply command to raft.
+	if _, err := s.raftApply(expireKeysCommandType, cmd); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) applyExpireKeysCommand(cmd []byte) error {
+	var c expireKeysCommand
+	if err := json.Unmarshal(cmd, &c); err != nil {
+		return err
+	}
+
+	// Remove keys which have not been set since being marked for expiry.
+	for _, k := range c.Keys {
+		if pair := s.data.Keys[k.Key]; pair != nil && pair.Index == k.Index {
+			s.deleteKey(pair)
+		}
+	}
+
+	return nil
+}
+
+// WatchKeys creates a subscription to changes of the keys stored at prefix
+// and below it.
+func (s *Store) WatchKeys(prefix string, sendCurrent bool, ch chan *discoverd.Event) stream.Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Create and add subscription.
+	sub := &subscription{
+		kinds:  discoverd.EventKindKeySet | discoverd.EventKindKeyDelete,
+		ch:     ch,
+		store:  s,
+		prefix: prefix,
+		kv:     true,
+	}
+	sub.el = s.kvSubscribers.PushBack(sub)
+
+	// Send current keys, followed by a current event.
+	if sendCurrent {
+		for _, pair := range s.keys(prefix) {
+			ch <- &discoverd.Event{
+				Kind: discoverd.EventKindKeySet,
+				KV:   pair,
+			}
+		}
+		ch <- &discoverd.Event{Kind: discoverd.EventKindCurrent}
+	}
+
+	return sub
+}
+
+// broadcastKey sends a key event to all subscribers watching the key.
+// Requires the mu lock to be obtained.
+func (s *Store) broadcastKey(event *discoverd.Event) {
+	logBroadcast(event)
+
+	for el := s.kvSubscribers.Front(); el != nil; el = el.Next() {
+		sub := el.Value.(*subscription)
+
+		// Skip if the key is not being watched.
+		if !keyHasPrefix(event.KV.Key, sub.prefix) {
+			continue
+		}
+
+		// Send event to subscriber.
+		// If subscriber is blocked then close it.
+		select {
+		case sub.ch <- event:
+		default:
+			sub.err = ErrSendBlocked
+			go sub.Close()
+		}
+	}
+}
+
+// kvPairSlice represents a sortable list of pairs by key.
+type kvPairSlice []*discoverd.KVPair
+
+func (a kvPairSlice) Len() int           { return len(a) }
+func (a kvPairSlice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a kvPairSlice) Less(i, j int) bool { return a[i].Key < a[j].Key }
+
+// setKeyCommand represents a command object to set a key.
+type setKeyCommand struct {
+	Pair *discoverd.KVPair
+	CAS  bool
+}
+
+// deleteKeyCommand represents a command object to delete a key.
+type deleteKeyCommand struct {
+	Key     string
+	Index   uint64
+	Recurse bool
+}
+
+// expireKeysCommand represents a command object to expire multiple keys.
+type expireKeysCommand struct {
+	Keys []expireKey
+}
+
+// expireKey represents a single key to expire.
+type expireKey struct {
+	Key   string
+	Index uint64
+}
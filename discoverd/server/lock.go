@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/flynn/flynn/discoverd/client"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	"github.com/hashicorp/raft"
+)
+
+var (
+	ErrInvalidLock = errors.New("discoverd: lock name must be alphanumeric plus dash, underscore and dot")
+
+	ErrUnsetSession = errors.New("discoverd: lock session must not be empty")
+
+	ErrInvalidLockTTL = errors.New("discoverd: lock ttl must be positive")
+)
+
+// ValidLockName returns nil if name is a valid lock name. Otherwise returns an
+// error.
+func ValidLockName(name string) error {
+	if name == "" {
+		return ErrInvalidLock
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' && r != '.' {
+			return ErrInvalidLock
+		}
+	}
+	return nil
+}
+
+// Lock returns the named lock, or nil if it is not held.
+func (s *Store) Lock(name string) *discoverd.Lock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Locks[name]
+}
+
+// AcquireLock acquires lock.Name for lock.Session, or refreshes it if the
+// session already holds it, and updates lock with its fencing token and
+// expiry time. An error for which hh.IsConflictError is true is returned if
+// the lock is held by another session.
+func (s *Store) AcquireLock(lock *discoverd.Lock) error {
+	if err := ValidLockName(lock.Name); err != nil {
+		return err
+	} else if lock.Session == "" {
+		return ErrUnsetSession
+	} else if lock.TTL <= 0 {
+		return ErrInvalidLockTTL
+	}
+
+	// Determine the time on the leader so that the expiry of the current
+	// lock is checked consistently on all peers.
+	now := s.Now()
+	lock.Expires = now.Add(time.Duration(lock.TTL) * time.Second)
+
+	// Serialize command.
+	cmd, err := json.Marshal(&acquireLockCommand{
+		Lock: lock,
+		Time: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The command responds with the token, which is either assigned by
+	// this command or kept from the previous acquisition.
+	_, res, err := s.raftApplyResponse(acquireLockCommandType, cmd)
+	if err != nil {
+		return err
+	}
+	lock.Token = res.(uint64)
+
+	return nil
+}
+
+// applyAcquireLockCommand returns either an error or the lock's token.
+func (s *Store) applyAcquireLockCommand(cmd []byte, index uint64) interface{} {
+	var c acquireLockCommand
+	if err := json.Unmarshal(cmd, &c); err != nil {
+		return err
+	}
+
+	// Locks which have expired but not been removed yet are free.
+	name := c.Lock.Name
+	curr := s.data.Locks[name]
+	if curr != nil && !curr.Expires.After(c.Time) {
+		curr = nil
+	}
+
+	if curr == nil {
+		// The lock is being acquired so assign a new fencing token.
+		c.Lock.Token = index
+	} else if curr.Session == c.Lock.Session {
+		// The lock is being refreshed so keep the current token.
+		c.Lock.Token = curr.Token
+	} else {
+		return hh.ConflictErr(fmt.Sprintf("Lock %q is held by another session", name))
+	}
+	s.data.Locks[name] = c.Lock
+
+	return c.Lock.Token
+}
+
+// ReleaseLock releases the named lock if it is held by session with the given
+// fencing token.
+func (s *Store) ReleaseLock(name, session string, token uint64) error {
+	// Serialize command.
+	cmd, err := json.Marshal(&releaseLockCommand{
+		Name:    name,
+		Session: session,
+		Token:   token,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.raftApply(releaseLockCommandType, cmd); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) applyReleaseLockCommand(cmd []byte) error {
+	var c releaseLockCommand
+	if err := json.Unmarshal(cmd, &c); err != nil {
+		return err
+	}
+
+	curr := s.data.Locks[c.Name]
+	if curr == nil || curr.Session != c.Session || curr.Token != c.Token {
+		return hh.PreconditionFailedErr(fmt.Sprintf("Lock %q is not held by the session with the given token", c.Name))
+	}
+	delete(s.data.Locks, c.Name)
+
+	return nil
+}
+
+// EnforceLockExpiry checks all locks for expiration and issues an expiration
+// command, if necessary.
+// This function returns raft.ErrNotLeader if this store is not the current leader.
+func (s *Store) EnforceLockExpiry() error {
+	var cmd []byte
+	if err := func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if !s.IsLeader() {
+			return raft.ErrNotLeader
+		}
+
+		now := s.Now()
+		var locks []expireLock
+		for _, lock := range s.data.Locks {
+			if lock.Expires.After(now) {
+				continue
+			}
+
+			// The expiry time is added to prevent a race condition of
+			// the lock being refreshed while this command is applying.
+			locks = append(locks, expireLock{
+				Name:    lock.Name,
+				Token:   lock.Token,
+				Expires: lock.Expires,
+			})
+		}
+
+		// If we have no locks to expire then exit.
+		if len(locks) == 0 {
+			return nil
+		}
+
+		// Create command to expire locks.
+		buf, err := json.Marshal(&expireLocksCommand{
+			Locks: locks,
+		})
+		if err != nil {
+			return err
+		}
+		cmd = buf
+
+		return nil
+	}(); err != nil {
+		return err
+	} else if cmd == nil {
+		return nil
+	}
+
+	// Apply command to raft.
+	if _, err := s.raftApply(expireLocksCommandType, cmd); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) applyExpireLocksCommand(cmd []byte) error {
+	var c expireLocksCommand
+	if err := json.Unmarshal(cmd, &c); err != nil {
+		return err
+	}
+
+	// Remove locks which have not been refreshed or reacquired since being
+	// marked for expiry.
+	for _, l := range c.Locks {
+		if lock := s.data.Locks[l.Name]; lock != nil && lock.Token == l.Token && lock.Expires.Equal(l.Expires) {
+			delete(s.data.Locks, l.Name)
+		}
+	}
+
+	return nil
+}
+
+// acquireLockCommand represents a command object to acquire or refresh a lock.
+type acquireLockCommand struct {
+	Lock *discoverd.Lock
+	Time time.Time
+}
+
+// releaseLockCommand represents a command object to release a lock.
+type releaseLockCommand struct {
+	Name    string
+	Session string
+	Token   uint64
+}
+
+// expireLocksCommand represents a command object to expire multiple locks.
+type expireLocksCommand struct {
+	Locks []expireLock
+}
+
+// expireLock represents a single lock to expire.
+type expireLock struct {
+	Name    string
+	Token   uint64
+	Expires time.Time
+}
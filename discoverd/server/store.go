@@ -73,8 +73,9 @@ type Store struct {
 	peerStore   raft.PeerStore
 	stableStore *raftboltdb.BoltStore
 
-	data        *raftData
-	subscribers map[string]*list.List
+	data          *raftData
+	subscribers   map[string]*list.List
+	kvSubscribers *list.List
 
 	leaderCh   chan bool                 // channel for notifying when leadership changes
 	leaderTime time.Time                 // time when leadership was established
@@ -114,9 +115,10 @@ type Store struct {
 // NewStore returns an instance of Store.
 func NewStore(path string) *Store {
 	return &Store{
-		path:        path,
-		data:        newRaftData(),
-		subscribers: make(map[string]*list.List),
+		path:          path,
+		data:          newRaftData(),
+		subscribers:   make(map[string]*list.List),
+		kvSubscribers: list.New(),
 
 		leaderCh:   make(chan bool),
 		heartbeats: make(map[instanceKey]time.Time),
@@ -790,6 +792,16 @@ func (s *Store) expirer() {
 		if err := s.EnforceExpiry(); err != nil && err != raft.ErrNotLeader {
 			s.logger.Printf("enforce expiry: %s", err)
 		}
+
+		// Check all keys for expiration.
+		if err := s.EnforceKeyExpiry(); err != nil && err != raft.ErrNotLeader {
+			s.logger.Printf("enforce key expiry: %s", err)
+		}
+
+		// Check all locks for expiration.
+		if err := s.EnforceLockExpiry(); err != nil && err != raft.ErrNotLeader {
+			s.logger.Printf("enforce lock expiry: %s", err)
+		}
 	}
 }
 
@@ -908,10 +920,17 @@ func (s *Store) applyExpireInstancesCommand(cmd []byte) error {
 // raftApply joins typ and cmd and applies it to raft.
 // This call blocks until the apply completes and returns the error.
 func (s *Store) raftApply(typ byte, cmd []byte) (uint64, error) {
+	index, _, err := s.raftApplyResponse(typ, cmd)
+	return index, err
+}
+
+// raftApplyResponse is like raftApply but also returns the response of
+// commands which respond with something other than an error.
+func (s *Store) raftApplyResponse(typ byte, cmd []byte) (uint64, interface{}, error) {
 	s.mu.RLock()
 	if s.raft == nil {
 		s.mu.RUnlock()
-		return 0, ErrShutdown
+		return 0, nil, ErrShutdown
 	}
 	s.mu.RUnlock()
 
@@ -921,14 +940,14 @@ func (s *Store) raftApply(typ byte, cmd []byte) (uint64, error) {
 	// Apply to raft and receive an ApplyFuture back.
 	f := s.raft.Apply(buf, 30*time.Second)
 	if err := f.Error(); err == raft.ErrNotLeader {
-		return 0, ErrNotLeader // hide underlying implementation error
+		return 0, nil, ErrNotLeader // hide underlying implementation error
 	} else if err != nil {
-		return f.Index(), err
+		return f.Index(), nil, err
 	} else if err, ok := f.Response().(error); ok {
-		return f.Index(), err
+		return f.Index(), nil, err
 	}
 
-	return f.Index(), nil
+	return f.Index(), f.Response(), nil
 }
 
 func (s *Store) Apply(l *raft.Log) interface{} {
@@ -959,6 +978,18 @@ func (s *Store) Apply(l *raft.Log) interface{} {
 		return s.applyRemoveInstanceCommand(cmd)
 	case expireInstancesCommandType:
 		return s.applyExpireInstancesCommand(cmd)
+	case setKeyCommandType:
+		return s.applySetKeyCommand(cmd, l.Index)
+	case deleteKeyCommandType:
+		return s.applyDeleteKeyCommand(cmd)
+	case expireKeysCommandType:
+		return s.applyExpireKeysCommand(cmd)
+	case acquireLockCommandType:
+		return s.applyAcquireLockCommand(cmd, l.Index)
+	case releaseLockCommandType:
+		return s.applyReleaseLockCommand(cmd)
+	case expireLocksCommandType:
+		return s.applyExpireLocksCommand(cmd)
 	default:
 		return fmt.Errorf("invalid command type: %d", typ)
 	}
@@ -981,7 +1012,10 @@ func (s *Store) Restore(r io.ReadCloser) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	data := &raftData{}
+	// Decode into initialized maps so that fields missing from the
+	// snapshot (for example keys in snapshots taken by older versions)
+	// are empty rather than nil.
+	data := newRaftData()
 	if err := json.NewDecoder(r).Decode(data); err != nil {
 		return err
 	}
@@ -1096,6 +1130,9 @@ func logBroadcast(event *discoverd.Event) {
 	if event.ServiceMeta != nil {
 		ctx = append(ctx, []interface{}{"service_meta.index", event.ServiceMeta.Index, "service_meta.data", string(event.ServiceMeta.Data)}...)
 	}
+	if event.KV != nil {
+		ctx = append(ctx, []interface{}{"kv.key", event.KV.Key, "kv.index", event.KV.Index}...)
+	}
 	log.Info(fmt.Sprintf("broadcasting %s event", event.Kind), ctx...)
 }
 
@@ -1136,6 +1173,12 @@ const (
 	addInstanceCommandType     = byte(4)
 	removeInstanceCommandType  = byte(5)
 	expireInstancesCommandType = byte(6)
+	setKeyCommandType          = byte(7)
+	deleteKeyCommandType       = byte(8)
+	expireKeysCommandType      = byte(9)
+	acquireLockCommandType     = byte(10)
+	releaseLockCommandType     = byte(11)
+	expireLocksCommandType     = byte(12)
 )
 
 // addServiceCommand represents a command object to create a service.
@@ -1191,6 +1234,8 @@ type raftData struct {
 	Metas     map[string]*discoverd.ServiceMeta         `json:"metas,omitempty"`
 	Leaders   map[string]string                         `json:"leaders,omitempty"`
 	Instances map[string]map[string]*discoverd.Instance `json:"instances,omitempty"`
+	Keys      map[string]*discoverd.KVPair              `json:"keys,omitempty"`
+	Locks     map[string]*discoverd.Lock                `json:"locks,omitempty"`
 }
 
 func newRaftData() *raftData {
@@ -1199,6 +1244,8 @@ func newRaftData() *raftData {
 		Metas:     make(map[string]*discoverd.ServiceMeta),
 		Leaders:   make(map[string]string),
 		Instances: make(map[string]map[string]*discoverd.Instance),
+		Keys:      make(map[string]*discoverd.KVPair),
+		Locks:     make(map[string]*discoverd.Lock),
 	}
 }
 
@@ -1219,6 +1266,10 @@ type subscription struct {
 	ch    chan *discoverd.Event
 	err   error
 
+	// prefix is the key prefix watched by key subscriptions
+	prefix string
+	kv     bool
+
 	// the following fields are used by Close to clean up
 	el      *list.Element
 	store   *Store
@@ -1247,10 +1298,14 @@ func (s *subscription) close() {
 		return
 	}
 
-	l := s.store.subscribers[s.service]
-	l.Remove(s.el)
-	if l.Len() == 0 {
-		delete(s.store.subscribers, s.service)
+	if s.kv {
+		s.store.kvSubscribers.Remove(s.el)
+	} else {
+		l := s.store.subscribers[s.service]
+		l.Remove(s.el)
+		if l.Len() == 0 {
+			delete(s.store.subscribers, s.service)
+		}
 	}
 	close(s.ch)
 
@@ -1260,9 +1315,13 @@ func (s *subscription) close() {
 type NotFoundError struct {
 	Service  string
 	Instance string
+	Key      string
 }
 
 func (e NotFoundError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("discoverd: key %q not found", e.Key)
+	}
 	if e.Instance == "" {
 		return fmt.Sprintf("discoverd: service %q not found", e.Service)
 	}
@@ -604,6 +604,281 @@ func TestStore_Subscribe_NoBlock(t *testing.T) {
 	// Ensure that program does not hang.
 }
 
+// Ensure the store can set and retrieve keys.
+func TestStore_SetKey(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	// Set a key unconditionally, twice.
+	if err := s.SetKey(&discoverd.KVPair{Key: "/foo/bar", Value: "baz"}, false); err != nil {
+		t.Fatal(err)
+	}
+	pair := &discoverd.KVPair{Key: "/foo/bar", Value: "qux"}
+	if err := s.SetKey(pair, false); err != nil {
+		t.Fatal(err)
+	} else if pair.Index != 3 {
+		t.Fatalf("unexpected index: %d", pair.Index)
+	}
+
+	// Verify the key was updated.
+	if p := s.Key("/foo/bar"); !reflect.DeepEqual(p, &discoverd.KVPair{Key: "/foo/bar", Value: "qux", Index: 3}) {
+		t.Fatalf("unexpected pair: %#v", p)
+	} else if p := s.Key("/foo"); p != nil {
+		t.Fatalf("unexpected pair: %#v", p)
+	}
+
+	// Setting an invalid key should return an error.
+	for _, key := range []string{"", "foo", "/", "/foo/", "/foo//bar"} {
+		if err := s.SetKey(&discoverd.KVPair{Key: key}, false); err != server.ErrInvalidKey {
+			t.Fatalf("unexpected error for key %q: %v", key, err)
+		}
+	}
+}
+
+// Ensure the store checks the index of keys when compare-and-setting them.
+func TestStore_SetKey_CAS(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	// Create key with index=0.
+	if err := s.SetKey(&discoverd.KVPair{Key: "/foo", Value: "bar"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetKey(&discoverd.KVPair{Key: "/foo", Value: "baz"}, true); err == nil || err.Error() != `object_exists: Key "/foo" already exists, use index=n to set` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Update using the wrong index, then the previous index.
+	if err := s.SetKey(&discoverd.KVPair{Key: "/foo", Value: "baz", Index: 100}, true); err == nil || err.Error() != `precondition_failed: Key "/foo" exists, but wrong index provided` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.SetKey(&discoverd.KVPair{Key: "/foo", Value: "baz", Index: 2}, true); err != nil {
+		t.Fatal(err)
+	} else if p := s.Key("/foo"); !reflect.DeepEqual(p, &discoverd.KVPair{Key: "/foo", Value: "baz", Index: 5}) {
+		t.Fatalf("unexpected pair: %#v", p)
+	}
+
+	// Updating a non-existent key with index>0 should fail.
+	if err := s.SetKey(&discoverd.KVPair{Key: "/bar", Value: "baz", Index: 100}, true); err == nil || err.Error() != `precondition_failed: Key "/bar" does not exist, use index=0 to set` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the store can list and delete keys hierarchically.
+func TestStore_DeleteKey_Recurse(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+	for _, key := range []string{"/a", "/a/b", "/a/b/c", "/ab", "/b"} {
+		if err := s.SetKey(&discoverd.KVPair{Key: key, Value: key}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := func(prefix string) []string {
+		var keys []string
+		for _, p := range s.Keys(prefix) {
+			keys = append(keys, p.Key)
+		}
+		return keys
+	}
+	if k := keys("/"); !reflect.DeepEqual(k, []string{"/a", "/a/b", "/a/b/c", "/ab", "/b"}) {
+		t.Fatalf("unexpected keys: %v", k)
+	} else if k := keys("/a"); !reflect.DeepEqual(k, []string{"/a", "/a/b", "/a/b/c"}) {
+		t.Fatalf("unexpected keys: %v", k)
+	}
+
+	// Delete a single key, then with the wrong index.
+	if err := s.DeleteKey("/a/b/c", 0, false); err != nil {
+		t.Fatal(err)
+	} else if err := s.DeleteKey("/a/b/c", 0, false); !server.IsNotFound(err) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.DeleteKey("/a", 100, true); err == nil || err.Error() != `precondition_failed: Key "/a" does not exist or wrong index provided` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Delete a key and all keys below it.
+	if err := s.DeleteKey("/a", 0, true); err != nil {
+		t.Fatal(err)
+	} else if k := keys("/"); !reflect.DeepEqual(k, []string{"/ab", "/b"}) {
+		t.Fatalf("unexpected keys: %v", k)
+	}
+}
+
+// Ensure the store can enforce expiration of keys.
+func TestStore_EnforceKeyExpiry(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+	now := time.Now()
+	s.Now = func() time.Time { return now }
+
+	if err := s.SetKey(&discoverd.KVPair{Key: "/ttl", Value: "foo", TTL: 10}, false); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetKey(&discoverd.KVPair{Key: "/no-ttl", Value: "foo"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if p := s.Key("/ttl"); p.Expires == nil || !p.Expires.Equal(now.Add(10*time.Second)) {
+		t.Fatalf("unexpected expiry: %v", p.Expires)
+	}
+
+	// Keys should not be expired before their TTL.
+	ch := make(chan *discoverd.Event, 1)
+	s.WatchKeys("/", false, ch)
+	now = now.Add(5 * time.Second)
+	if err := s.EnforceKeyExpiry(); err != nil {
+		t.Fatal(err)
+	} else if s.Key("/ttl") == nil {
+		t.Fatal("expected key to exist")
+	}
+
+	// Move past the TTL and enforce expiry.
+	now = now.Add(10 * time.Second)
+	if err := s.EnforceKeyExpiry(); err != nil {
+		t.Fatal(err)
+	} else if s.Key("/ttl") != nil {
+		t.Fatal("expected key to be expired")
+	} else if s.Key("/no-ttl") == nil {
+		t.Fatal("expected key without TTL to exist")
+	}
+
+	// Verify "key_delete" event was received.
+	if e := <-ch; e.Kind != discoverd.EventKindKeyDelete || e.KV.Key != "/ttl" {
+		t.Fatalf("unexpected event: %#v", e)
+	}
+}
+
+// Ensure the store sends events for keys with a watched prefix.
+func TestStore_WatchKeys(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+	if err := s.SetKey(&discoverd.KVPair{Key: "/foo/a", Value: "1"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Watch keys, receiving the current keys.
+	ch := make(chan *discoverd.Event, 10)
+	s.WatchKeys("/foo", true, ch)
+	if e := <-ch; !reflect.DeepEqual(e, &discoverd.Event{
+		Kind: discoverd.EventKindKeySet,
+		KV:   &discoverd.KVPair{Key: "/foo/a", Value: "1", Index: 2},
+	}) {
+		t.Fatalf("unexpected event: %#v", e)
+	} else if e := <-ch; e.Kind != discoverd.EventKindCurrent {
+		t.Fatalf("unexpected event: %#v", e)
+	}
+
+	// Change keys both inside and outside the prefix.
+	if err := s.SetKey(&discoverd.KVPair{Key: "/foobar", Value: "2"}, false); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetKey(&discoverd.KVPair{Key: "/foo/b", Value: "3"}, false); err != nil {
+		t.Fatal(err)
+	} else if err := s.DeleteKey("/foo/a", 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := <-ch; !reflect.DeepEqual(e, &discoverd.Event{
+		Kind: discoverd.EventKindKeySet,
+		KV:   &discoverd.KVPair{Key: "/foo/b", Value: "3", Index: 4},
+	}) {
+		t.Fatalf("unexpected event: %#v", e)
+	} else if e := <-ch; e.Kind != discoverd.EventKindKeyDelete || e.KV.Key != "/foo/a" {
+		t.Fatalf("unexpected event: %#v", e)
+	}
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected event: %#v", e)
+	default:
+	}
+}
+
+// Ensure the store can acquire, refresh and release locks.
+func TestStore_AcquireLock(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	// Acquire the lock.
+	lock := &discoverd.Lock{Name: "lock0", Session: "session0", TTL: 10}
+	if err := s.AcquireLock(lock); err != nil {
+		t.Fatal(err)
+	} else if lock.Token != 2 {
+		t.Fatalf("unexpected token: %d", lock.Token)
+	}
+
+	// Acquiring the lock with another session should fail.
+	if err := s.AcquireLock(&discoverd.Lock{Name: "lock0", Session: "session1", TTL: 10}); err == nil || err.Error() != `conflict: Lock "lock0" is held by another session` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Refreshing the lock should keep the token.
+	refreshed := &discoverd.Lock{Name: "lock0", Session: "session0", TTL: 10}
+	if err := s.AcquireLock(refreshed); err != nil {
+		t.Fatal(err)
+	} else if refreshed.Token != lock.Token {
+		t.Fatalf("unexpected token: %d", refreshed.Token)
+	} else if l := s.Lock("lock0"); l.Token != lock.Token || !l.Expires.Equal(refreshed.Expires) {
+		t.Fatalf("unexpected lock: %#v", l)
+	}
+
+	// Releasing with the wrong token or session should fail.
+	if err := s.ReleaseLock("lock0", "session0", 100); err == nil || err.Error() != `precondition_failed: Lock "lock0" is not held by the session with the given token` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.ReleaseLock("lock0", "session1", lock.Token); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Release the lock and acquire it with another session, which should
+	// get a higher token.
+	if err := s.ReleaseLock("lock0", "session0", lock.Token); err != nil {
+		t.Fatal(err)
+	} else if l := s.Lock("lock0"); l != nil {
+		t.Fatalf("unexpected lock: %#v", l)
+	}
+	other := &discoverd.Lock{Name: "lock0", Session: "session1", TTL: 10}
+	if err := s.AcquireLock(other); err != nil {
+		t.Fatal(err)
+	} else if other.Token <= lock.Token {
+		t.Fatalf("expected token greater than %d, got %d", lock.Token, other.Token)
+	}
+}
+
+// Ensure the store frees locks once they expire.
+func TestStore_EnforceLockExpiry(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+	now := time.Now()
+	s.Now = func() time.Time { return now }
+
+	lock := &discoverd.Lock{Name: "lock0", Session: "session0", TTL: 10}
+	if err := s.AcquireLock(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	// An expired lock can be acquired by another session before it has been
+	// removed, getting a new token.
+	now = now.Add(11 * time.Second)
+	other := &discoverd.Lock{Name: "lock0", Session: "session1", TTL: 10}
+	if err := s.AcquireLock(other); err != nil {
+		t.Fatal(err)
+	} else if other.Token <= lock.Token {
+		t.Fatalf("expected token greater than %d, got %d", lock.Token, other.Token)
+	}
+
+	// Locks should not be expired before their TTL.
+	now = now.Add(5 * time.Second)
+	if err := s.EnforceLockExpiry(); err != nil {
+		t.Fatal(err)
+	} else if s.Lock("lock0") == nil {
+		t.Fatal("expected lock to exist")
+	}
+
+	// Move past the TTL and enforce expiry.
+	now = now.Add(10 * time.Second)
+	if err := s.EnforceLockExpiry(); err != nil {
+		t.Fatal(err)
+	} else if l := s.Lock("lock0"); l != nil {
+		t.Fatalf("expected lock to be expired, got %#v", l)
+	}
+}
+
 func BenchmarkStore_AddInstance(b *testing.B) {
 	s := MustOpenStore()
 	defer s.Close()
@@ -706,6 +981,14 @@ type MockStore struct {
 	SetServiceLeaderFn func(service, id string) error
 	ServiceLeaderFn    func(service string) (*discoverd.Instance, error)
 	SubscribeFn        func(service string, sendCurrent bool, kinds discoverd.EventKind, ch chan *discoverd.Event) stream.Stream
+	KeyFn              func(key string) *discoverd.KVPair
+	KeysFn             func(prefix string) []*discoverd.KVPair
+	SetKeyFn           func(pair *discoverd.KVPair, cas bool) error
+	DeleteKeyFn        func(key string, index uint64, recurse bool) error
+	WatchKeysFn        func(prefix string, sendCurrent bool, ch chan *discoverd.Event) stream.Stream
+	LockFn             func(name string) *discoverd.Lock
+	AcquireLockFn      func(lock *discoverd.Lock) error
+	ReleaseLockFn      func(name, session string, token uint64) error
 }
 
 func (s *MockStore) Leader() string { return s.LeaderFn() }
@@ -760,6 +1043,30 @@ func (s *MockStore) Subscribe(service string, sendCurrent bool, kinds discoverd.
 	return s.SubscribeFn(service, sendCurrent, kinds, ch)
 }
 
+func (s *MockStore) Key(key string) *discoverd.KVPair { return s.KeyFn(key) }
+
+func (s *MockStore) Keys(prefix string) []*discoverd.KVPair { return s.KeysFn(prefix) }
+
+func (s *MockStore) SetKey(pair *discoverd.KVPair, cas bool) error {
+	return s.SetKeyFn(pair, cas)
+}
+
+func (s *MockStore) DeleteKey(key string, index uint64, recurse bool) error {
+	return s.DeleteKeyFn(key, index, recurse)
+}
+
+func (s *MockStore) WatchKeys(prefix string, sendCurrent bool, ch chan *discoverd.Event) stream.Stream {
+	return s.WatchKeysFn(prefix, sendCurrent, ch)
+}
+
+func (s *MockStore) Lock(name string) *discoverd.Lock { return s.LockFn(name) }
+
+func (s *MockStore) AcquireLock(lock *discoverd.Lock) error { return s.AcquireLockFn(lock) }
+
+func (s *MockStore) ReleaseLock(name, session string, token uint64) error {
+	return s.ReleaseLockFn(name, session, token)
+}
+
 // MustRandomPort returns a random port.
 func MustRandomPort() string {
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
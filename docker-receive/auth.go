@@ -3,9 +3,14 @@ package main
 import (
 	"crypto/subtle"
 	"net/http"
+	"time"
 
 	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/registry/auth"
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/docker-receive/pushtoken"
+	"github.com/flynn/flynn/pkg/dialer"
 )
 
 func init() {
@@ -13,15 +18,30 @@ func init() {
 }
 
 func newAuth(options map[string]interface{}) (auth.AccessController, error) {
-	return &Auth{key: options["auth_key"].(string)}, nil
+	client := options["controller"].(controller.Client)
+	httpClient := &http.Client{Transport: &http.Transport{Dial: dialer.Retry.Dial}}
+	return &Auth{
+		key:    options["auth_key"].(string),
+		client: client,
+		authenticateUser: func(key string) (*ct.User, error) {
+			return controller.AuthenticateUser("", key, httpClient)
+		},
+	}, nil
 }
 
 type Auth struct {
-	key string
+	key    string
+	client controller.Client
+
+	// authenticateUser returns the user a key other than the auth key
+	// authenticates as, which is a user token from "flynn login"
+	authenticateUser func(key string) (*ct.User, error)
 }
 
 // Authorized implements the auth.AccessController interface and authorizes a
-// request if it includes the correct auth key
+// request if it includes the correct auth key, a push token for the requested
+// repository, or a user token of a user whose role permits the requested
+// access to the app each repository is named after
 func (a *Auth) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
 	req, err := context.GetRequest(ctx)
 	if err != nil {
@@ -31,12 +51,68 @@ func (a *Auth) Authorized(ctx context.Context, accessRecords ...auth.Access) (co
 	if password == "" {
 		password = req.URL.Query().Get("key")
 	}
-	if subtle.ConstantTimeCompare([]byte(password), []byte(a.key)) != 1 {
+	if password == "" {
 		return nil, Challenge{}
 	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(a.key)) == 1 {
+		return ctx, nil
+	}
+	if pushtoken.Is(password) {
+		repo, err := pushtoken.Verify(a.key, password, time.Now())
+		if err != nil {
+			return nil, Challenge{}
+		}
+		for _, access := range accessRecords {
+			if access.Type != "repository" || access.Name != repo || (access.Action != "pull" && access.Action != "push") {
+				return nil, Challenge{}
+			}
+		}
+		return ctx, nil
+	}
+	user, err := a.authenticateUser(password)
+	if err == controller.ErrUnauthorized {
+		return nil, Challenge{}
+	} else if err != nil {
+		return nil, err
+	}
+	for _, access := range accessRecords {
+		ok, err := a.allowed(user, access)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, Challenge{}
+		}
+	}
 	return ctx, nil
 }
 
+// allowed returns whether the user's role permits the access, pulling
+// requiring read access to the app the repository is named after and pushing
+// requiring deploy access. Deleting images and listing repositories require
+// the admin role.
+func (a *Auth) allowed(user *ct.User, access auth.Access) (bool, error) {
+	if user.Role == ct.RoleAdmin {
+		return true, nil
+	}
+	if access.Type != "repository" {
+		return false, nil
+	}
+	app, err := a.client.GetApp(access.Name)
+	if err == controller.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	switch access.Action {
+	case "pull":
+		return user.CanReadApp(app.ID), nil
+	case "push":
+		return user.CanDeployApp(app.ID), nil
+	default:
+		return false, nil
+	}
+}
+
 type Challenge struct{}
 
 func (Challenge) SetHeaders(w http.ResponseWriter) {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/docker-receive/pushtoken"
+	. "github.com/flynn/go-check"
+)
+
+// Hook gocheck up to the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type AuthSuite struct{}
+
+var _ = Suite(&AuthSuite{})
+
+// fakeController implements the parts of the controller API used by Auth,
+// panicking if anything else is called
+type fakeController struct {
+	controller.Client
+	apps map[string]*ct.App
+}
+
+func (f *fakeController) GetApp(id string) (*ct.App, error) {
+	app, ok := f.apps[id]
+	if !ok {
+		return nil, controller.ErrNotFound
+	}
+	return app, nil
+}
+
+func (AuthSuite) TestAuthorized(c *C) {
+	app := &ct.App{ID: "00000000-0000-0000-0000-000000000001", Name: "example"}
+	other := &ct.App{ID: "00000000-0000-0000-0000-000000000002", Name: "other"}
+	tokens := map[string]*ct.User{
+		"admin-token":     {ID: "admin", Role: ct.RoleAdmin},
+		"deployer-token":  {ID: "deployer", Role: ct.RoleDeployer, Apps: []string{app.ID}},
+		"read-only-token": {ID: "read-only", Role: ct.RoleReadOnly},
+	}
+	a := &Auth{
+		key:    "auth-key",
+		client: &fakeController{apps: map[string]*ct.App{app.Name: app, other.Name: other}},
+		authenticateUser: func(key string) (*ct.User, error) {
+			user, ok := tokens[key]
+			if !ok {
+				return nil, controller.ErrUnauthorized
+			}
+			return user, nil
+		},
+	}
+	pull := func(repo string) auth.Access {
+		return auth.Access{Resource: auth.Resource{Type: "repository", Name: repo}, Action: "pull"}
+	}
+	push := func(repo string) auth.Access {
+		return auth.Access{Resource: auth.Resource{Type: "repository", Name: repo}, Action: "push"}
+	}
+	remove := auth.Access{Resource: auth.Resource{Type: "repository", Name: "example"}, Action: "*"}
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+	pushToken := pushtoken.New("auth-key", "example", time.Now().Add(time.Hour))
+
+	for _, t := range []struct {
+		desc    string
+		key     string
+		access  []auth.Access
+		allowed bool
+	}{
+		{"no key", "", nil, false},
+		{"unknown token", "invalid-token", nil, false},
+		{"auth key", "auth-key", []auth.Access{pull("example"), push("example"), catalog}, true},
+		{"admin", "admin-token", []auth.Access{pull("other"), push("other"), remove, catalog}, true},
+		{"deployer logging in", "deployer-token", nil, true},
+		{"deployer pushing their app", "deployer-token", []auth.Access{pull("example"), push("example")}, true},
+		{"deployer pulling another app", "deployer-token", []auth.Access{pull("other")}, false},
+		{"deployer pushing another app", "deployer-token", []auth.Access{pull("other"), push("other")}, false},
+		{"deployer pushing an unknown app", "deployer-token", []auth.Access{pull("unknown"), push("unknown")}, false},
+		{"deployer deleting", "deployer-token", []auth.Access{remove}, false},
+		{"deployer listing repositories", "deployer-token", []auth.Access{catalog}, false},
+		{"read-only user pulling", "read-only-token", []auth.Access{pull("other")}, true},
+		{"read-only user pushing", "read-only-token", []auth.Access{pull("example"), push("example")}, false},
+		{"push token logging in", pushToken, nil, true},
+		{"push token pushing its repository", pushToken, []auth.Access{pull("example"), push("example")}, true},
+		{"push token pushing another repository", pushToken, []auth.Access{pull("other"), push("other")}, false},
+		{"push token deleting", pushToken, []auth.Access{remove}, false},
+		{"push token listing repositories", pushToken, []auth.Access{catalog}, false},
+		{"expired push token", pushtoken.New("auth-key", "example", time.Now()), []auth.Access{push("example")}, false},
+		{"push token signed with another key", pushtoken.New("other-key", "example", time.Now().Add(time.Hour)), []auth.Access{push("example")}, false},
+	} {
+		req, err := http.NewRequest("GET", "http://docker-receive.discoverd/v2/", nil)
+		c.Assert(err, IsNil)
+		if t.key != "" {
+			req.SetBasicAuth("flynn", t.key)
+		}
+		_, err = a.Authorized(context.WithRequest(context.Background(), req), t.access...)
+		if t.allowed {
+			c.Assert(err, IsNil, Commentf(t.desc))
+		} else {
+			c.Assert(err, FitsTypeOf, Challenge{}, Commentf(t.desc))
+		}
+	}
+}
@@ -52,7 +52,8 @@ func main() {
 		},
 		Auth: configuration.Auth{
 			"flynn": configuration.Parameters{
-				"auth_key": authKey,
+				"auth_key":   authKey,
+				"controller": client,
 			},
 		},
 	}
@@ -0,0 +1,69 @@
+// Package pushtoken implements tokens which permit pushing to and pulling
+// from a single docker-receive repository until they expire, so that jobs
+// which push images don't need the auth key.
+package pushtoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const prefix = "push-token."
+
+var (
+	ErrInvalid = errors.New("pushtoken: invalid token")
+	ErrExpired = errors.New("pushtoken: token expired")
+)
+
+// New returns a token for the repository which expires at the given time,
+// signed with the docker-receive auth key
+func New(key, repo string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(repo)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return prefix + payload + "." + hex.EncodeToString(sign(key, payload))
+}
+
+// Is returns whether s has the format of a token, so that other credentials
+// don't need to be checked
+func Is(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// Verify checks that the token was signed with the key and hasn't expired,
+// returning the repository it was created for
+func Verify(key, token string, now time.Time) (string, error) {
+	if !Is(token) {
+		return "", ErrInvalid
+	}
+	parts := strings.Split(strings.TrimPrefix(token, prefix), ".")
+	if len(parts) != 3 {
+		return "", ErrInvalid
+	}
+	sig, err := hex.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, sign(key, parts[0]+"."+parts[1])) {
+		return "", ErrInvalid
+	}
+	repo, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalid
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	if now.Unix() >= expires {
+		return "", ErrExpired
+	}
+	return string(repo), nil
+}
+
+func sign(key, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,40 @@
+package pushtoken
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	token := New("key", "example", now.Add(time.Hour))
+	if !Is(token) {
+		t.Fatalf("expected %q to be a token", token)
+	}
+	repo, err := Verify("key", token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo != "example" {
+		t.Fatalf("expected repo example, got %q", repo)
+	}
+
+	for _, c := range []struct {
+		desc  string
+		key   string
+		token string
+		err   error
+	}{
+		{"wrong key", "other-key", token, ErrInvalid},
+		{"expired", "key", New("key", "example", now), ErrExpired},
+		{"changed repository", "key", strings.Replace(token, base64.RawURLEncoding.EncodeToString([]byte("example")), base64.RawURLEncoding.EncodeToString([]byte("other")), 1), ErrInvalid},
+		{"not a token", "key", "key", ErrInvalid},
+		{"missing signature", "key", token[:len(token)-65], ErrInvalid},
+	} {
+		if _, err := Verify(c.key, c.token, now); err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.desc, c.err, err)
+		}
+	}
+}
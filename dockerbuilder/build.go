@@ -0,0 +1,357 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/nat"
+	"github.com/docker/docker/pkg/stringutils"
+	"github.com/docker/docker/runconfig"
+	"github.com/flynn/flynn/pinkerton"
+)
+
+// builder builds a Docker image from the instructions in a Dockerfile.
+//
+// Each RUN, ADD and COPY instruction creates a layer, the ID of which is its
+// cache key (a hash of the instruction and all of the instructions before it)
+// so that unchanged layers keep the same ID between builds. Changes to the
+// image config made by other instructions are included in the next layer,
+// or in an empty layer at the end of the build.
+type builder struct {
+	ctx        *pinkerton.Context
+	cache      *layerCache
+	contextDir string
+	ignore     []string
+
+	// imageID is the ID of the most recently created layer
+	imageID string
+
+	// key is the cache key of the build so far, which differs from
+	// imageID if the config has changed since the last layer
+	key string
+
+	config *runconfig.Config
+	author string
+	cmdSet bool
+
+	// pending are the instructions which changed the config since the
+	// last layer
+	pending []string
+
+	// keys are the keys of the cached layers used by the build
+	keys []string
+}
+
+func (b *builder) build(instructions []*instruction) (string, error) {
+	for i, inst := range instructions {
+		fmt.Printf("-----> Step %d/%d : %s\n", i+1, len(instructions), inst.Original)
+		var err error
+		switch inst.Cmd {
+		case "from":
+			err = b.from(inst)
+		case "run":
+			err = b.run(inst)
+		case "add", "copy":
+			err = b.copy(inst)
+		default:
+			err = b.setConfig(inst)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if b.key != b.imageID {
+		if err := b.commitConfig(); err != nil {
+			return "", err
+		}
+	}
+	return b.imageID, nil
+}
+
+func (b *builder) from(inst *instruction) error {
+	if b.imageID != "" {
+		return fmt.Errorf("multiple FROM instructions are not supported")
+	}
+	id, err := b.ctx.PullDocker(imageURL(inst.Args[0]), pinkerton.DockerPullPrinter(os.Stdout))
+	if err != nil {
+		return fmt.Errorf("error pulling %s: %s", inst.Args[0], err)
+	}
+	image, err := b.ctx.ImageConfig(id)
+	if err != nil {
+		return err
+	}
+	b.config = &runconfig.Config{}
+	if image.Config != nil {
+		if err := json.Unmarshal(*image.Config, b.config); err != nil {
+			return err
+		}
+	}
+	b.imageID = id
+	b.key = id
+	return nil
+}
+
+// imageURL converts an image name given to FROM into a pinkerton URL, pulling
+// images without a registry host from the Docker Hub. Pinkerton URLs (e.g. of
+// images in docker-receive) are used as is.
+func imageURL(name string) string {
+	if strings.Contains(name, "?name=") {
+		return name
+	}
+	host := "docker.io"
+	if i := strings.Index(name, "/"); i != -1 && (strings.ContainsAny(name[:i], ".:") || name[:i] == "localhost") {
+		host, name = name[:i], name[i+1:]
+	}
+	ref := "tag=latest"
+	if i := strings.Index(name, "@"); i != -1 {
+		name, ref = name[:i], "id="+name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i != -1 {
+		name, ref = name[:i], "tag="+name[i+1:]
+	}
+	return fmt.Sprintf("https://%s?name=%s&%s", host, name, ref)
+}
+
+func (b *builder) setConfig(inst *instruction) error {
+	args := b.expandArgs(inst)
+	switch inst.Cmd {
+	case "maintainer":
+		b.author = args[0]
+	case "env":
+		for i := 0; i < len(args); i += 2 {
+			b.setEnv(args[i], args[i+1])
+		}
+	case "label":
+		if b.config.Labels == nil {
+			b.config.Labels = make(map[string]string, len(args)/2)
+		}
+		for i := 0; i < len(args); i += 2 {
+			b.config.Labels[args[i]] = args[i+1]
+		}
+	case "cmd":
+		b.config.Cmd = stringutils.NewStrSlice(commandArgs(inst)...)
+		b.cmdSet = true
+	case "entrypoint":
+		b.config.Entrypoint = stringutils.NewStrSlice(commandArgs(inst)...)
+		// the base image's CMD is not used with a new ENTRYPOINT
+		if !b.cmdSet {
+			b.config.Cmd = nil
+		}
+	case "expose":
+		if b.config.ExposedPorts == nil {
+			b.config.ExposedPorts = make(map[nat.Port]struct{}, len(args))
+		}
+		for _, port := range args {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			b.config.ExposedPorts[nat.Port(port)] = struct{}{}
+		}
+	case "workdir":
+		dir := args[0]
+		if !path.IsAbs(dir) {
+			dir = path.Join("/", b.config.WorkingDir, dir)
+		}
+		b.config.WorkingDir = path.Clean(dir)
+	case "user":
+		b.config.User = args[0]
+	case "volume":
+		if b.config.Volumes == nil {
+			b.config.Volumes = make(map[string]struct{}, len(args))
+		}
+		for _, v := range args {
+			b.config.Volumes[v] = struct{}{}
+		}
+	case "stopsignal":
+		b.config.StopSignal = args[0]
+	}
+	b.key = b.nextKey(inst, "")
+	b.pending = append(b.pending, inst.Original)
+	return nil
+}
+
+func (b *builder) run(inst *instruction) error {
+	args := commandArgs(inst)
+	return b.layer(inst, b.nextKey(inst, ""), func(rootfs string) error {
+		return b.exec(rootfs, args)
+	})
+}
+
+// commandArgs returns the arguments of a RUN, CMD or ENTRYPOINT instruction,
+// running shell form instructions with /bin/sh
+func commandArgs(inst *instruction) []string {
+	if inst.JSON {
+		return inst.Args
+	}
+	return []string{"/bin/sh", "-c", inst.Args[0]}
+}
+
+// nextKey returns the cache key of the image created by applying inst to the
+// current image, with extra being a hash of any files the instruction adds
+func (b *builder) nextKey(inst *instruction, extra string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", b.key, inst.Original, extra)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// layer creates a layer with the given key by applying changes to a checkout
+// of the current image, unless the layer is cached
+func (b *builder) layer(inst *instruction, key string, apply func(rootfs string) error) error {
+	if b.restore(key) {
+		fmt.Println("-----> Using cache")
+		b.committed(key)
+		return nil
+	}
+
+	rootfs, err := b.ctx.Checkout(key, b.imageID)
+	if err != nil {
+		return err
+	}
+	defer b.ctx.Cleanup(key)
+	if err := apply(rootfs); err != nil {
+		return err
+	}
+	config, err := b.imageConfig(key, inst.Original)
+	if err != nil {
+		return err
+	}
+	if err := b.ctx.Commit(key, config); err != nil {
+		return err
+	}
+	b.committed(key)
+
+	if b.cache == nil {
+		return nil
+	}
+	layer, err := b.ctx.Layer(key)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+	if err := b.cache.put(key, config, layer); err != nil {
+		fmt.Println("-----> WARN: could not cache layer:", err)
+		return nil
+	}
+	b.keys = append(b.keys, key)
+	return nil
+}
+
+// restore registers the cached layer with the given key, returning whether
+// it was cached
+func (b *builder) restore(key string) bool {
+	if b.cache == nil {
+		return false
+	}
+	config, layer, err := b.cache.get(key)
+	if err != nil {
+		fmt.Println("-----> WARN: could not get cached layer:", err)
+		return false
+	} else if config == nil {
+		return false
+	}
+	defer layer.Close()
+	if config.ID != key || config.ParentID != b.imageID {
+		return false
+	}
+	if err := b.ctx.Register(config, layer); err != nil {
+		fmt.Println("-----> WARN: could not restore cached layer:", err)
+		return false
+	}
+	b.keys = append(b.keys, key)
+	return true
+}
+
+func (b *builder) committed(id string) {
+	b.imageID = id
+	b.key = id
+	b.pending = nil
+}
+
+// commitConfig creates an empty layer for config changes made after the last
+// layer
+func (b *builder) commitConfig() error {
+	config, err := b.imageConfig(b.key, strings.Join(b.pending, "; "))
+	if err != nil {
+		return err
+	}
+	var layer bytes.Buffer
+	if err := tar.NewWriter(&layer).Close(); err != nil {
+		return err
+	}
+	if err := b.ctx.Register(config, &layer); err != nil {
+		return err
+	}
+	b.committed(config.ID)
+	return nil
+}
+
+func (b *builder) imageConfig(id, createdBy string) (*pinkerton.ImageConfig, error) {
+	config, err := rawJSON(b.config)
+	if err != nil {
+		return nil, err
+	}
+	// the container config records the instruction which created the
+	// layer, which is shown by "docker history"
+	containerConfig, err := rawJSON(&runconfig.Config{
+		Cmd: stringutils.NewStrSlice("/bin/sh", "-c", "#(nop) "+createdBy),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pinkerton.ImageConfig{
+		ID:              id,
+		ParentID:        b.imageID,
+		Created:         time.Now().UTC(),
+		Config:          config,
+		ContainerConfig: containerConfig,
+		Author:          b.author,
+		Architecture:    runtime.GOARCH,
+		OS:              runtime.GOOS,
+	}, nil
+}
+
+func rawJSON(v interface{}) (*json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(data)
+	return &raw, nil
+}
+
+// expandArgs returns the instruction's arguments with environment variables
+// from the image config substituted
+func (b *builder) expandArgs(inst *instruction) []string {
+	args := make([]string, len(inst.Args))
+	for i, arg := range inst.Args {
+		args[i] = os.Expand(arg, b.getEnv)
+	}
+	return args
+}
+
+func (b *builder) getEnv(key string) string {
+	for _, kv := range b.config.Env {
+		if strings.HasPrefix(kv, key+"=") {
+			return strings.TrimPrefix(kv, key+"=")
+		}
+	}
+	return ""
+}
+
+func (b *builder) setEnv(key, value string) {
+	for i, kv := range b.config.Env {
+		if strings.HasPrefix(kv, key+"=") {
+			b.config.Env[i] = key + "=" + value
+			return
+		}
+	}
+	b.config.Env = append(b.config.Env, key+"="+value)
+}
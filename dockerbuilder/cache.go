@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/flynn/flynn/pinkerton"
+)
+
+// layerCache stores the layers created by RUN, ADD and COPY instructions in
+// the blobstore so that later builds can reuse them, keyed by a hash of the
+// instruction and all the instructions before it (see builder.nextKey).
+//
+// The cache keeps the layers used by the most recent build, listing them in
+// an index so that layers which are no longer used can be removed.
+type layerCache struct {
+	url   string
+	index *layerCacheIndex
+}
+
+type layerCacheIndex struct {
+	Keys []string `json:"keys"`
+}
+
+func newLayerCache(url string) (*layerCache, error) {
+	c := &layerCache{url: url, index: &layerCacheIndex{}}
+	res, err := http.Get(url + "/index.json")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return c, nil
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d getting layer cache index", res.StatusCode)
+	}
+	return c, json.NewDecoder(res.Body).Decode(c.index)
+}
+
+// get returns the config and uncompressed layer of the cached image with the
+// given key, or a nil config if it isn't cached
+func (c *layerCache) get(key string) (*pinkerton.ImageConfig, io.ReadCloser, error) {
+	res, err := http.Get(c.layerURL(key, "json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil, nil
+	} else if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %d getting cached layer", res.StatusCode)
+	}
+	config := &pinkerton.ImageConfig{}
+	if err := json.NewDecoder(res.Body).Decode(config); err != nil {
+		return nil, nil, err
+	}
+
+	res, err = http.Get(c.layerURL(key, "tar.gz"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, nil, nil
+	} else if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, nil, fmt.Errorf("unexpected status %d getting cached layer", res.StatusCode)
+	}
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		res.Body.Close()
+		return nil, nil, err
+	}
+	return config, readCloser{gz, res.Body}, nil
+}
+
+// put caches the image with the given key, compressing its layer
+func (c *layerCache) put(key string, config *pinkerton.ImageConfig, layer io.Reader) error {
+	// upload the layer before the config so that get never sees a
+	// config without a layer
+	r, w := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(w)
+		if _, err := io.Copy(gz, layer); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		w.CloseWithError(gz.Close())
+	}()
+	err := blobRequest("PUT", c.layerURL(key, "tar.gz"), r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return blobRequest("PUT", c.layerURL(key, "json"), bytes.NewReader(data))
+}
+
+// prune records the keys used by a build in the index, removing the cached
+// layers used by the previous build which weren't used by this one
+func (c *layerCache) prune(keys []string) error {
+	used := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		used[key] = struct{}{}
+	}
+	prev := c.index.Keys
+	c.index = &layerCacheIndex{Keys: keys}
+	data, err := json.Marshal(c.index)
+	if err != nil {
+		return err
+	}
+	if err := blobRequest("PUT", c.url+"/index.json", bytes.NewReader(data)); err != nil {
+		return err
+	}
+	for _, key := range prev {
+		if _, ok := used[key]; ok {
+			continue
+		}
+		blobRequest("DELETE", c.layerURL(key, "tar.gz"), nil)
+		blobRequest("DELETE", c.layerURL(key, "json"), nil)
+	}
+	return nil
+}
+
+func (c *layerCache) layerURL(key, ext string) string {
+	return fmt.Sprintf("%s/%s.%s", c.url, key, ext)
+}
+
+func blobRequest(method, url string, body io.Reader) error {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status %d from %s %s", res.StatusCode, method, url)
+	}
+	return nil
+}
+
+// readCloser reads from a reader, closing both it and the underlying
+// response body
+type readCloser struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (r readCloser) Close() error {
+	r.ReadCloser.Close()
+	return r.body.Close()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/runconfig"
+	"github.com/flynn/flynn/pinkerton"
+)
+
+// testBlobstore is an in-memory blobstore
+type testBlobstore struct {
+	mtx   sync.Mutex
+	files map[string][]byte
+}
+
+func newTestBlobstore() (*testBlobstore, *httptest.Server) {
+	b := &testBlobstore{files: make(map[string][]byte)}
+	return b, httptest.NewServer(b)
+}
+
+func (b *testBlobstore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	switch r.Method {
+	case "GET":
+		data, ok := b.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	case "PUT":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		b.files[r.URL.Path] = data
+	case "DELETE":
+		delete(b.files, r.URL.Path)
+	}
+}
+
+func (b *testBlobstore) has(path string) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	_, ok := b.files[path]
+	return ok
+}
+
+func TestLayerCache(t *testing.T) {
+	blobstore, srv := newTestBlobstore()
+	defer srv.Close()
+
+	cache, err := newLayerCache(srv.URL + "/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config, _, err := cache.get("a"); err != nil {
+		t.Fatal(err)
+	} else if config != nil {
+		t.Fatal("expected a missing layer to not be cached")
+	}
+
+	layers := map[string][]byte{"a": []byte("layer a"), "b": []byte("layer b")}
+	for key, layer := range layers {
+		config := &pinkerton.ImageConfig{ID: key, ParentID: "base"}
+		if err := cache.put(key, config, bytes.NewReader(layer)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, layer := range layers {
+		config, r, err := cache.get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config == nil || config.ID != key || config.ParentID != "base" {
+			t.Fatalf("expected the config of cached layer %s, got %+v", key, config)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, layer) {
+			t.Fatalf("expected cached layer %s to be %q, got %q", key, layer, data)
+		}
+	}
+
+	// the index is read by the next build, which prunes the layers it
+	// didn't use
+	if err := cache.prune([]string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	cache, err = newLayerCache(srv.URL + "/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cache.index.Keys, []string{"a", "b"}) {
+		t.Fatalf("expected index keys to be [a b], got %v", cache.index.Keys)
+	}
+	if err := cache.prune([]string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/cache/a.json", "/cache/a.tar.gz"} {
+		if blobstore.has(path) {
+			t.Fatalf("expected %s to be pruned", path)
+		}
+	}
+	for _, path := range []string{"/cache/b.json", "/cache/b.tar.gz"} {
+		if !blobstore.has(path) {
+			t.Fatalf("expected %s to be kept", path)
+		}
+	}
+}
+
+func TestLayerCacheKeys(t *testing.T) {
+	run := &instruction{Cmd: "run", Args: []string{"make"}, Original: "RUN make"}
+	b := &builder{key: "base"}
+	key := b.nextKey(run, "")
+
+	// keys are the same for the same instruction on the same image
+	if k := (&builder{key: "base"}).nextKey(run, ""); k != key {
+		t.Fatalf("expected the key to be %s, got %s", key, k)
+	}
+
+	// but differ if the image, instruction or added files differ
+	for _, k := range []string{
+		(&builder{key: "other"}).nextKey(run, ""),
+		b.nextKey(&instruction{Cmd: "run", Args: []string{"make test"}, Original: "RUN make test"}, ""),
+		b.nextKey(run, "files"),
+	} {
+		if k == key {
+			t.Fatalf("expected key %s to differ from %s", k, key)
+		}
+	}
+
+	// config changes are included in the key of the next layer
+	env := &instruction{Cmd: "env", Args: []string{"FOO", "bar"}, Original: "ENV FOO bar"}
+	b.config = &runconfig.Config{}
+	b.imageID = "base"
+	if err := b.setConfig(env); err != nil {
+		t.Fatal(err)
+	}
+	if k := b.nextKey(run, ""); k == key {
+		t.Fatal("expected the key to change after ENV")
+	}
+}
+
+func TestLayerCacheRestore(t *testing.T) {
+	_, srv := newTestBlobstore()
+	defer srv.Close()
+	cache, err := newLayerCache(srv.URL + "/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cached layers are only reused if they have the expected parent, so
+	// the image isn't built on a different base
+	config := &pinkerton.ImageConfig{ID: "key", ParentID: "old-base"}
+	if err := cache.put("key", config, bytes.NewReader(nil)); err != nil {
+		t.Fatal(err)
+	}
+	b := &builder{cache: cache, imageID: "new-base", key: "new-base"}
+	if b.restore("key") {
+		t.Fatal("expected a layer with a different parent to not be restored")
+	}
+	if b.restore("missing") {
+		t.Fatal("expected a missing layer to not be restored")
+	}
+	if len(b.keys) != 0 {
+		t.Fatalf("expected no keys to be used, got %v", b.keys)
+	}
+}
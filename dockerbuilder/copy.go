@@ -0,0 +1,316 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/chrootarchive"
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/docker/docker/pkg/symlink"
+)
+
+// copy runs an ADD or COPY instruction, which copies files from the build
+// context (excluding those matching .dockerignore) into the image. ADD also
+// downloads URLs and extracts local archives.
+func (b *builder) copy(inst *instruction) error {
+	args := b.expandArgs(inst)
+	srcs, dest := args[:len(args)-1], args[len(args)-1]
+
+	// the cache key includes the contents of the files being copied, but
+	// only the URL of files being downloaded
+	h := sha256.New()
+	var sources []string
+	for _, src := range srcs {
+		if inst.Cmd == "add" && isURL(src) {
+			fmt.Fprintln(h, src)
+			sources = append(sources, src)
+			continue
+		}
+		matches, err := b.contextGlob(src)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: no such file or directory in the build context", src)
+		}
+		for _, m := range matches {
+			if err := b.hashSource(h, m); err != nil {
+				return err
+			}
+		}
+		sources = append(sources, matches...)
+	}
+
+	toDir := strings.HasSuffix(dest, "/") || len(sources) > 1
+	if !path.IsAbs(dest) {
+		dest = path.Join("/", b.config.WorkingDir, dest)
+	}
+	key := b.nextKey(inst, hex.EncodeToString(h.Sum(nil)))
+	return b.layer(inst, key, func(rootfs string) error {
+		for _, src := range sources {
+			var err error
+			if isURL(src) {
+				err = download(src, rootfs, dest, toDir)
+			} else {
+				err = b.copySource(src, rootfs, dest, toDir, inst.Cmd == "add")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// contextGlob returns the paths in the build context matching pattern
+func (b *builder) contextGlob(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(b.contextDir, filepath.Clean("/"+pattern)))
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if ignored, err := b.ignored(m); err != nil {
+			return nil, err
+		} else if !ignored {
+			paths = append(paths, m)
+		}
+	}
+	return paths, nil
+}
+
+// ignored returns whether the path in the build context matches .dockerignore
+func (b *builder) ignored(p string) (bool, error) {
+	if len(b.ignore) == 0 {
+		return false, nil
+	}
+	rel, err := filepath.Rel(b.contextDir, p)
+	if err != nil {
+		return false, err
+	}
+	return fileutils.Matches(rel, b.ignore)
+}
+
+// walkSource walks the files under the source path in the build context,
+// skipping those matching .dockerignore
+func (b *builder) walkSource(src string, fn func(p string, info os.FileInfo) error) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ignored, err := b.ignored(p); err != nil {
+			return err
+		} else if ignored {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(p, info)
+	})
+}
+
+func (b *builder) hashSource(h hash.Hash, src string) error {
+	return b.walkSource(src, func(p string, info os.FileInfo) error {
+		rel, err := filepath.Rel(b.contextDir, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %s\n", rel, info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(h, link)
+		case info.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(h, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// copySource copies a file or the contents of a directory from the build
+// context into the image, extracting archives if extract is true
+func (b *builder) copySource(src, rootfs, dest string, toDir, extract bool) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return b.walkSource(src, func(p string, info os.FileInfo) error {
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			return copyFile(p, rootfs, path.Join(dest, filepath.ToSlash(rel)), info)
+		})
+	}
+	if extract && isArchive(src) {
+		target, err := inRootfs(rootfs, dest)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return chrootarchive.Untar(f, target, nil)
+	}
+	if toDir || isDir(rootfs, dest) {
+		dest = path.Join(dest, filepath.Base(src))
+	}
+	return copyFile(src, rootfs, dest, info)
+}
+
+// copyFile copies a file, directory or symlink to dest in the image, owned by
+// root
+func copyFile(src, rootfs, dest string, info os.FileInfo) error {
+	target, err := inRootfs(rootfs, dest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		os.Remove(target)
+		if err := os.Symlink(link, target); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		if err := writeFile(src, target, info.Mode().Perm()); err != nil {
+			return err
+		}
+	default:
+		// skip sockets, devices etc.
+		return nil
+	}
+	return os.Lchown(target, 0, 0)
+}
+
+func writeFile(src, target string, mode os.FileMode) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return writeReader(r, target, mode)
+}
+
+func writeReader(r io.Reader, target string, mode os.FileMode) error {
+	w, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(target, mode)
+}
+
+// download downloads a file added by ADD into the image
+func download(src, rootfs, dest string, toDir bool) error {
+	if toDir || isDir(rootfs, dest) {
+		u, err := url.Parse(src)
+		if err != nil {
+			return err
+		}
+		name := path.Base(u.Path)
+		if name == "." || name == "/" {
+			return fmt.Errorf("cannot determine a file name for %s", src)
+		}
+		dest = path.Join(dest, name)
+	}
+	target, err := inRootfs(rootfs, dest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	fmt.Printf("-----> Downloading %s\n", src)
+	res, err := http.Get(src)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d downloading %s", res.StatusCode, src)
+	}
+	if err := writeReader(res.Body, target, 0600); err != nil {
+		return err
+	}
+	return os.Lchown(target, 0, 0)
+}
+
+// inRootfs returns the path on the host of a path in the image, resolving
+// symlinks within the image
+func inRootfs(rootfs, p string) (string, error) {
+	return symlink.FollowSymlinkInScope(filepath.Join(rootfs, p), rootfs)
+}
+
+func isDir(rootfs, p string) bool {
+	target, err := inRootfs(rootfs, p)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(target)
+	return err == nil && info.IsDir()
+}
+
+// isArchive returns whether the file is a possibly compressed tar archive
+func isArchive(p string) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	r, err := archive.DecompressStream(f)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	_, err = tar.NewReader(r).Next()
+	return err == nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestFiles writes files relative to dir, creating symlinks for values
+// prefixed with "->"
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 2 && data[:2] == "->" {
+			if err := os.Symlink(data[2:], p); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertFile(t *testing.T, p, data string) {
+	actual, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != data {
+		t.Fatalf("expected %s to contain %q, got %q", p, data, actual)
+	}
+}
+
+func newTestCopyBuilder(t *testing.T) (*builder, string, func()) {
+	if os.Getuid() != 0 {
+		t.Skip("copied files are chowned to root")
+	}
+	dir, err := ioutil.TempDir("", "dockerbuilder-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contextDir := filepath.Join(dir, "context")
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{contextDir, rootfs} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFiles(t, contextDir, map[string]string{
+		"Dockerfile":       "FROM ubuntu",
+		"app/main.go":      "package main",
+		"app/lib/lib.go":   "package lib",
+		"app/secret.key":   "secret",
+		"app/current":      "->lib",
+		"config/app.conf":  "port 8080",
+		"config/test.conf": "port 8081",
+	})
+	b := &builder{contextDir: contextDir, ignore: []string{"app/*.key"}}
+	return b, rootfs, func() { os.RemoveAll(dir) }
+}
+
+func TestCopySource(t *testing.T) {
+	b, rootfs, cleanup := newTestCopyBuilder(t)
+	defer cleanup()
+	src := func(p string) string { return filepath.Join(b.contextDir, p) }
+
+	// directories have their contents copied, skipping ignored files and
+	// keeping symlinks
+	if err := b.copySource(src("app"), rootfs, "/app", false, false); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(rootfs, "app/main.go"), "package main")
+	assertFile(t, filepath.Join(rootfs, "app/lib/lib.go"), "package lib")
+	if _, err := os.Stat(filepath.Join(rootfs, "app/secret.key")); !os.IsNotExist(err) {
+		t.Fatal("expected ignored files to not be copied")
+	}
+	if link, err := os.Readlink(filepath.Join(rootfs, "app/current")); err != nil {
+		t.Fatal(err)
+	} else if link != "lib" {
+		t.Fatalf("expected app/current to link to lib, got %s", link)
+	}
+
+	// files are copied into directories if the destination has a trailing
+	// slash or is an existing directory, otherwise they are renamed
+	if err := b.copySource(src("Dockerfile"), rootfs, "/build/", true, false); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(rootfs, "build/Dockerfile"), "FROM ubuntu")
+	if err := b.copySource(src("config/app.conf"), rootfs, "/app", false, false); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(rootfs, "app/app.conf"), "port 8080")
+	if err := b.copySource(src("config/app.conf"), rootfs, "/etc/app.conf", false, false); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(rootfs, "etc/app.conf"), "port 8080")
+}
+
+func TestCopySymlinkInScope(t *testing.T) {
+	b, rootfs, cleanup := newTestCopyBuilder(t)
+	defer cleanup()
+
+	outside, err := ioutil.TempDir("", "dockerbuilder-outside-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	// symlinks in the image resolve within the image rather than on the
+	// host, whether they are absolute or relative
+	writeTestFiles(t, rootfs, map[string]string{
+		"abs": "->" + outside,
+		"rel": "->../../../../../../.." + outside,
+	})
+	src := filepath.Join(b.contextDir, "Dockerfile")
+	for _, dest := range []string{"/abs/Dockerfile", "/rel/Dockerfile"} {
+		if err := b.copySource(src, rootfs, dest, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(outside, "Dockerfile")); !os.IsNotExist(err) {
+			t.Fatalf("expected copying to %s to not write outside the image", dest)
+		}
+		assertFile(t, filepath.Join(rootfs, outside, "Dockerfile"), "FROM ubuntu")
+	}
+
+	// and paths which would leave the image are rejected
+	if _, err := inRootfs(rootfs, "/../../etc/passwd"); err == nil {
+		t.Fatal("expected /../../etc/passwd to be rejected")
+	}
+}
+
+func TestCopyContextGlob(t *testing.T) {
+	b, _, cleanup := newTestCopyBuilder(t)
+	defer cleanup()
+
+	for pattern, expected := range map[string][]string{
+		"config/*.conf": {"config/app.conf", "config/test.conf"},
+		"app/*":         {"app/current", "app/lib", "app/main.go"},
+		"../../config":  {"config"},
+		"missing":       {},
+	} {
+		matches, err := b.contextGlob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != len(expected) {
+			t.Fatalf("expected %q to match %v, got %v", pattern, expected, matches)
+		}
+		for i, m := range matches {
+			if m != filepath.Join(b.contextDir, expected[i]) {
+				t.Fatalf("expected %q to match %v, got %v", pattern, expected, matches)
+			}
+		}
+	}
+}
+
+func TestCopyHashSource(t *testing.T) {
+	b, _, cleanup := newTestCopyBuilder(t)
+	defer cleanup()
+
+	hash := func() string {
+		h := sha256.New()
+		if err := b.hashSource(h, filepath.Join(b.contextDir, "app")); err != nil {
+			t.Fatal(err)
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	sum := hash()
+
+	// changes to ignored files don't change the hash, so the layer
+	// is reused
+	writeTestFiles(t, b.contextDir, map[string]string{"app/other.key": "secret"})
+	if h := hash(); h != sum {
+		t.Fatal("expected ignored files to not change the hash")
+	}
+
+	// but changes to copied files do
+	if err := ioutil.WriteFile(filepath.Join(b.contextDir, "app/main.go"), []byte("package app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if h := hash(); h == sum {
+		t.Fatal("expected a changed file to change the hash")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// instruction is a single instruction from a Dockerfile
+type instruction struct {
+	// Cmd is the lower case instruction name (e.g. "run")
+	Cmd string
+
+	// Args are the instruction's arguments. Shell form RUN, CMD and
+	// ENTRYPOINT instructions have a single argument, and ENV and LABEL
+	// instructions have alternating keys and values.
+	Args []string
+
+	// JSON is whether the arguments were given as a JSON array (the exec
+	// form)
+	JSON bool
+
+	// Original is the instruction as written in the Dockerfile (with
+	// continuation lines joined)
+	Original string
+}
+
+// supportedInstructions maps the supported instructions to whether they
+// accept the JSON form
+var supportedInstructions = map[string]bool{
+	"from":       false,
+	"maintainer": false,
+	"run":        true,
+	"cmd":        true,
+	"entrypoint": true,
+	"env":        false,
+	"label":      false,
+	"expose":     false,
+	"add":        true,
+	"copy":       true,
+	"workdir":    false,
+	"user":       false,
+	"volume":     true,
+	"stopsignal": false,
+}
+
+// parseDockerfile parses the instructions from a Dockerfile, the first of
+// which must be FROM
+func parseDockerfile(r io.Reader) ([]*instruction, error) {
+	var instructions []*instruction
+	var line string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		s := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(s, "#") {
+			continue
+		}
+		if strings.HasSuffix(s, `\`) {
+			line += strings.TrimSuffix(s, `\`)
+			continue
+		}
+		line += s
+		if line == "" {
+			continue
+		}
+		inst, err := parseInstruction(line)
+		if err != nil {
+			return nil, fmt.Errorf("Dockerfile line %d: %s", n, err)
+		}
+		instructions = append(instructions, inst)
+		line = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line != "" {
+		return nil, fmt.Errorf("Dockerfile ends with a line continuation")
+	}
+	if len(instructions) == 0 || instructions[0].Cmd != "from" {
+		return nil, fmt.Errorf("Dockerfile must start with a FROM instruction")
+	}
+	return instructions, nil
+}
+
+func parseInstruction(line string) (*instruction, error) {
+	name, rest := splitFirst(line)
+	inst := &instruction{
+		Cmd:      strings.ToLower(name),
+		Original: line,
+	}
+	jsonForm, ok := supportedInstructions[inst.Cmd]
+	if !ok {
+		return nil, fmt.Errorf("unsupported instruction %s", strings.ToUpper(inst.Cmd))
+	}
+	if rest == "" {
+		return nil, fmt.Errorf("%s requires at least one argument", strings.ToUpper(inst.Cmd))
+	}
+
+	if jsonForm && strings.HasPrefix(rest, "[") {
+		if err := json.Unmarshal([]byte(rest), &inst.Args); err == nil {
+			inst.JSON = true
+			return inst, nil
+		}
+	}
+
+	switch inst.Cmd {
+	case "run", "cmd", "entrypoint", "maintainer", "workdir", "user", "stopsignal":
+		inst.Args = []string{rest}
+	case "env", "label":
+		args, err := parseKeyValues(rest)
+		if err != nil {
+			return nil, err
+		}
+		inst.Args = args
+	default:
+		inst.Args = strings.Fields(rest)
+	}
+	if (inst.Cmd == "add" || inst.Cmd == "copy") && len(inst.Args) < 2 {
+		return nil, fmt.Errorf("%s requires a source and a destination", strings.ToUpper(inst.Cmd))
+	}
+	return inst, nil
+}
+
+// parseKeyValues parses either the "<key> <value>" or "<key>=<value> ..."
+// forms of ENV and LABEL, returning alternating keys and values
+func parseKeyValues(s string) ([]string, error) {
+	words, err := splitWords(s)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(words[0], "=") {
+		// the value is the remainder of the line
+		key, value := splitFirst(s)
+		if value == "" {
+			return nil, fmt.Errorf("missing value for %s", key)
+		}
+		return []string{key, value}, nil
+	}
+	args := make([]string, 0, 2*len(words))
+	for _, w := range words {
+		kv := strings.SplitN(w, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid key-value pair %q", w)
+		}
+		args = append(args, kv[0], kv[1])
+	}
+	return args, nil
+}
+
+// splitFirst splits s into its first word and the remainder
+func splitFirst(s string) (string, string) {
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// splitWords splits s into whitespace separated words, removing quotes and
+// backslash escapes
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word []rune
+	var quote rune
+	inWord := false
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			word = append(word, c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case unicode.IsSpace(c):
+			if inWord {
+				words = append(words, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
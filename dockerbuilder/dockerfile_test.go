@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	dockerfile := `
+# comment
+FROM ubuntu:14.04
+ENV FOO bar baz
+ENV A=1 B="two words" C=three\ words
+RUN apt-get update && \
+    apt-get install -y curl
+CMD ["/bin/app", "--port", "8080"]
+COPY . /app/
+EXPOSE 8080 53/udp
+`
+	instructions, err := parseDockerfile(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*instruction{
+		{Cmd: "from", Args: []string{"ubuntu:14.04"}, Original: "FROM ubuntu:14.04"},
+		{Cmd: "env", Args: []string{"FOO", "bar baz"}, Original: "ENV FOO bar baz"},
+		{Cmd: "env", Args: []string{"A", "1", "B", "two words", "C", "three words"}, Original: `ENV A=1 B="two words" C=three\ words`},
+		{Cmd: "run", Args: []string{"apt-get update && apt-get install -y curl"}, Original: "RUN apt-get update && apt-get install -y curl"},
+		{Cmd: "cmd", Args: []string{"/bin/app", "--port", "8080"}, JSON: true, Original: `CMD ["/bin/app", "--port", "8080"]`},
+		{Cmd: "copy", Args: []string{".", "/app/"}, Original: "COPY . /app/"},
+		{Cmd: "expose", Args: []string{"8080", "53/udp"}, Original: "EXPOSE 8080 53/udp"},
+	}
+	if len(instructions) != len(expected) {
+		t.Fatalf("expected %d instructions, got %d", len(expected), len(instructions))
+	}
+	for i, inst := range instructions {
+		if !reflect.DeepEqual(inst, expected[i]) {
+			t.Fatalf("expected instruction %d to be %+v, got %+v", i, expected[i], inst)
+		}
+	}
+}
+
+func TestParseDockerfileErrors(t *testing.T) {
+	for _, dockerfile := range []string{
+		"",
+		"RUN true\nFROM ubuntu",
+		"FROM ubuntu\nONBUILD RUN true",
+		"FROM ubuntu\nCOPY foo",
+		"FROM ubuntu\nENV FOO",
+		"FROM ubuntu\nENV FOO=\"bar",
+		"FROM ubuntu\nRUN true \\",
+	} {
+		if _, err := parseDockerfile(strings.NewReader(dockerfile)); err == nil {
+			t.Fatalf("expected an error parsing %q", dockerfile)
+		}
+	}
+}
+
+func TestImageURL(t *testing.T) {
+	for name, expected := range map[string]string{
+		"ubuntu":                     "https://docker.io?name=ubuntu&tag=latest",
+		"ubuntu:14.04":               "https://docker.io?name=ubuntu&tag=14.04",
+		"flynn/busybox@sha256:1234":  "https://docker.io?name=flynn/busybox&id=sha256:1234",
+		"localhost:5000/foo/bar:baz": "https://localhost:5000?name=foo/bar&tag=baz",
+		"quay.io/foo/bar":            "https://quay.io?name=foo/bar&tag=latest",
+		"http://docker-receive.discoverd?name=app&id=sha256:1234": "http://docker-receive.discoverd?name=app&id=sha256:1234",
+	} {
+		if actual := imageURL(name); actual != expected {
+			t.Fatalf("expected imageURL(%q) to be %q, got %q", name, expected, actual)
+		}
+	}
+}
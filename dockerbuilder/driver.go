@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/chrootarchive"
+	"github.com/docker/docker/pkg/idtools"
+	"github.com/docker/docker/pkg/system"
+)
+
+func init() {
+	graphdriver.Register("copy", newCopyDriver)
+}
+
+// copyDriver is a graph driver which stores each layer as a full copy of its
+// parent with the layer's changes applied. It is slower and uses more disk
+// space than a union filesystem, but doesn't need to create mounts, so builds
+// can run in unprivileged jobs.
+type copyDriver struct {
+	home string
+}
+
+func newCopyDriver(home string, options []string, uidMaps, gidMaps []idtools.IDMap) (graphdriver.Driver, error) {
+	if err := os.MkdirAll(filepath.Join(home, "dir"), 0700); err != nil {
+		return nil, err
+	}
+	return &copyDriver{home: home}, nil
+}
+
+func (d *copyDriver) String() string {
+	return "copy"
+}
+
+func (d *copyDriver) Status() [][2]string {
+	return nil
+}
+
+func (d *copyDriver) GetMetadata(id string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (d *copyDriver) Cleanup() error {
+	return nil
+}
+
+func (d *copyDriver) dir(id string) string {
+	return filepath.Join(d.home, "dir", filepath.Base(id))
+}
+
+func (d *copyDriver) Create(id, parent string) error {
+	dir := d.dir(id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	if parent == "" {
+		return nil
+	}
+	parentDir, err := d.Get(parent, "")
+	if err != nil {
+		return err
+	}
+	if err := chrootarchive.CopyWithTar(parentDir, dir); err != nil {
+		return err
+	}
+	return copyTimes(parentDir, dir)
+}
+
+// copyTimes sets the access and modification times of the files in dir to
+// those of the files they were copied from in parentDir, as tar archives
+// round them to the second, which would otherwise make Changes include files
+// which haven't changed.
+func copyTimes(parentDir, dir string) error {
+	return filepath.Walk(parentDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(parentDir, p)
+		if err != nil {
+			return err
+		}
+		return system.LUtimesNano(filepath.Join(dir, rel), []syscall.Timespec{stat.Atim, stat.Mtim})
+	})
+}
+
+func (d *copyDriver) Remove(id string) error {
+	return os.RemoveAll(d.dir(id))
+}
+
+func (d *copyDriver) Get(id, mountLabel string) (string, error) {
+	dir := d.dir(id)
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func (d *copyDriver) Put(id string) error {
+	return nil
+}
+
+func (d *copyDriver) Exists(id string) bool {
+	_, err := os.Stat(d.dir(id))
+	return err == nil
+}
+
+// Diff and the following methods are simplified versions of those in Docker's
+// NaiveDiffDriver (which is only built into the Docker daemon), comparing the
+// layer's directory with its parent's
+func (d *copyDriver) Diff(id, parent string) (archive.Archive, error) {
+	if parent == "" {
+		return archive.Tar(d.dir(id), archive.Uncompressed)
+	}
+	changes, err := d.Changes(id, parent)
+	if err != nil {
+		return nil, err
+	}
+	return archive.ExportChanges(d.dir(id), changes, nil, nil)
+}
+
+func (d *copyDriver) Changes(id, parent string) ([]archive.Change, error) {
+	var parentDir string
+	if parent != "" {
+		parentDir = d.dir(parent)
+	}
+	return archive.ChangesDirs(d.dir(id), parentDir)
+}
+
+func (d *copyDriver) ApplyDiff(id, parent string, diff archive.Reader) (int64, error) {
+	return chrootarchive.ApplyUncompressedLayer(d.dir(id), diff, nil)
+}
+
+func (d *copyDriver) DiffSize(id, parent string) (int64, error) {
+	changes, err := d.Changes(id, parent)
+	if err != nil {
+		return 0, err
+	}
+	return archive.ChangesSize(d.dir(id), changes), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyDriverDiff(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("applying layers requires root")
+	}
+	home, err := ioutil.TempDir("", "dockerbuilder-driver-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	d, err := newCopyDriver(home, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create a base layer and a child layer which changes, adds and
+	// removes files
+	if err := d.Create("base", ""); err != nil {
+		t.Fatal(err)
+	}
+	base, err := d.Get("base", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, base, map[string]string{
+		"etc/hostname": "base",
+		"etc/removed":  "removed",
+		"bin/app":      "app",
+	})
+	// use modification times which tar rounds up, so unchanged files
+	// would appear to have changed if the times weren't copied
+	mtime := time.Date(2016, time.March, 14, 10, 30, 15, 600000000, time.UTC)
+	for _, p := range []string{"etc/hostname", "etc/removed", "bin/app", "bin", "etc"} {
+		if err := os.Chtimes(filepath.Join(base, p), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Create("child", "base"); err != nil {
+		t.Fatal(err)
+	}
+	child, err := d.Get("child", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(child, "bin/app"), "app")
+	writeTestFiles(t, child, map[string]string{
+		"etc/hostname": "child",
+		"etc/added":    "added",
+	})
+	if err := os.Remove(filepath.Join(child, "etc/removed")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the diff only contains the changes
+	changes, err := d.Changes("child", "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(map[string]struct{}, len(changes))
+	for _, c := range changes {
+		paths[c.Path] = struct{}{}
+	}
+	for _, p := range []string{"/etc/hostname", "/etc/added", "/etc/removed"} {
+		if _, ok := paths[p]; !ok {
+			t.Fatalf("expected %s to be changed, got %v", p, changes)
+		}
+	}
+	if _, ok := paths["/bin/app"]; ok {
+		t.Fatalf("expected /bin/app to be unchanged, got %v", changes)
+	}
+
+	// applying the diff to a copy of the base layer recreates the child
+	diff, err := d.Diff("child", "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.Close()
+	if err := d.Create("applied", "base"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ApplyDiff("applied", "base", diff); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := d.Get("applied", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(applied, "etc/hostname"), "child")
+	assertFile(t, filepath.Join(applied, "etc/added"), "added")
+	assertFile(t, filepath.Join(applied, "bin/app"), "app")
+	if _, err := os.Stat(filepath.Join(applied, "etc/removed")); !os.IsNotExist(err) {
+		t.Fatal("expected etc/removed to be removed")
+	}
+
+	// removing a layer leaves its parent
+	if err := d.Remove("child"); err != nil {
+		t.Fatal(err)
+	}
+	if d.Exists("child") || !d.Exists("base") {
+		t.Fatal("expected only the child layer to be removed")
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/pkg/symlink"
+)
+
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// RUN instructions run in their own user namespace which maps IDs 0 to
+// idMapSize-1 to host IDs starting at idMapBase, so that root in the image is
+// an unprivileged user on the host
+const (
+	idMapBase = 100000
+	idMapSize = 65536
+)
+
+// exec runs a command in a chroot of the image's root filesystem using the
+// image's environment, working directory and user, in new user, mount and
+// PID namespaces so that it can't affect the builder or the host
+func (b *builder) exec(rootfs string, args []string) error {
+	env := b.config.Env
+	pathEnv := b.getEnv("PATH")
+	if pathEnv == "" {
+		pathEnv = defaultPath
+		env = append([]string{"PATH=" + pathEnv}, env...)
+	}
+	dir := b.config.WorkingDir
+	if dir == "" {
+		dir = "/"
+	}
+	hostDir, err := symlink.FollowSymlinkInScope(filepath.Join(rootfs, dir), rootfs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(hostDir, 0755); err != nil {
+		return err
+	}
+	cred, err := lookupUser(rootfs, b.config.User)
+	if err != nil {
+		return err
+	}
+	if cred == nil {
+		// the command must switch to root in the user namespace, as it
+		// otherwise keeps the builder's UID which isn't mapped into it
+		cred = &syscall.Credential{}
+	}
+	cmdPath, err := lookPath(rootfs, args[0], pathEnv)
+	if err != nil {
+		return err
+	}
+
+	restore, err := prepareRootfs(rootfs)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	// files in the image are owned by IDs in the namespace, so shift them
+	// to the mapped host IDs whilst the command runs, and back afterwards
+	// so that only the command's own changes are included in the layer
+	if err := allowTraversal(rootfs); err != nil {
+		return err
+	}
+	if err := shiftOwnership(rootfs, 0, idMapBase); err != nil {
+		shiftOwnership(rootfs, idMapBase, 0)
+		return err
+	}
+
+	idMap := []syscall.SysProcIDMap{{ContainerID: 0, HostID: idMapBase, Size: idMapSize}}
+	cmd := &exec.Cmd{
+		Path:   cmdPath,
+		Args:   args,
+		Env:    env,
+		Dir:    dir,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		SysProcAttr: &syscall.SysProcAttr{
+			Chroot:                     rootfs,
+			Credential:                 cred,
+			Cloneflags:                 syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID,
+			UidMappings:                idMap,
+			GidMappings:                idMap,
+			GidMappingsEnableSetgroups: true,
+		},
+	}
+	runErr := cmd.Run()
+	if err := shiftOwnership(rootfs, idMapBase, 0); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return fmt.Errorf("%q returned an error: %s", strings.Join(args, " "), runErr)
+	}
+	return nil
+}
+
+// lookPath finds an executable in the image's PATH
+func lookPath(rootfs, name, pathEnv string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	for _, dir := range filepath.SplitList(pathEnv) {
+		p := path.Join(dir, name)
+		hostPath, err := symlink.FollowSymlinkInScope(filepath.Join(rootfs, p), rootfs)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(hostPath); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("executable %q not found in %s", name, pathEnv)
+}
+
+// lookupUser returns the credentials of the user given to USER, which is
+// either a name or UID optionally followed by a group name or GID, looking
+// up names in the image's /etc/passwd and /etc/group
+func lookupUser(rootfs, spec string) (*syscall.Credential, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	var uid, gid int
+	entry, err := lookupEntry(rootfs, "/etc/passwd", parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		if uid, err = strconv.Atoi(entry[2]); err != nil {
+			return nil, fmt.Errorf("invalid UID for user %s", parts[0])
+		}
+		if gid, err = strconv.Atoi(entry[3]); err != nil {
+			return nil, fmt.Errorf("invalid GID for user %s", parts[0])
+		}
+	} else if uid, err = strconv.Atoi(parts[0]); err != nil {
+		return nil, fmt.Errorf("unknown user %s", parts[0])
+	}
+	if len(parts) == 2 {
+		entry, err := lookupEntry(rootfs, "/etc/group", parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			if gid, err = strconv.Atoi(entry[2]); err != nil {
+				return nil, fmt.Errorf("invalid GID for group %s", parts[1])
+			}
+		} else if gid, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, fmt.Errorf("unknown group %s", parts[1])
+		}
+	}
+	if uid < 0 || uid >= idMapSize || gid < 0 || gid >= idMapSize {
+		return nil, fmt.Errorf("USER %s must have a UID and GID below %d", spec, idMapSize)
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
+
+// allowTraversal gives other users search permission on the directories
+// containing rootfs, as root in the user namespace has no more access to
+// them than any other unprivileged user
+func allowTraversal(rootfs string) error {
+	for dir := filepath.Dir(rootfs); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if mode := info.Mode(); mode&0001 == 0 {
+			if err := os.Chmod(dir, mode|0001); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// shiftOwnership changes the owner of files in rootfs which are owned by IDs
+// from "from" to from+idMapSize-1 to the same IDs offset to start at "to",
+// keeping setuid and setgid bits which chown clears
+func shiftOwnership(rootfs string, from, to int) error {
+	shift := func(id int) int {
+		if id >= from && id < from+idMapSize {
+			return id - from + to
+		}
+		return id
+	}
+	return filepath.Walk(rootfs, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, gid := shift(int(stat.Uid)), shift(int(stat.Gid))
+		if uid == int(stat.Uid) && gid == int(stat.Gid) {
+			return nil
+		}
+		if err := os.Lchown(p, uid, gid); err != nil {
+			return err
+		}
+		if mode := info.Mode(); mode&(os.ModeSetuid|os.ModeSetgid) != 0 && mode&os.ModeSymlink == 0 {
+			return os.Chmod(p, mode)
+		}
+		return nil
+	})
+}
+
+// lookupEntry returns the fields of the entry in a passwd or group file with
+// the given name or ID, or nil if there is no such entry
+func lookupEntry(rootfs, file, name string) ([]string, error) {
+	p, err := symlink.FollowSymlinkInScope(filepath.Join(rootfs, file), rootfs)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ":")
+		if len(fields) < 3 || (file == "/etc/passwd" && len(fields) < 4) {
+			continue
+		}
+		if fields[0] == name || fields[2] == name {
+			return fields, nil
+		}
+	}
+	return nil, s.Err()
+}
+
+// rootfsDevices are created in the image if they don't exist whilst RUN
+// instructions are running, as there is no /dev mount in the chroot
+var rootfsDevices = []struct {
+	name         string
+	major, minor int
+}{
+	{"null", 1, 3},
+	{"zero", 1, 5},
+	{"random", 1, 8},
+	{"urandom", 1, 9},
+}
+
+// prepareRootfs adds the files RUN instructions need to the image (the
+// host's resolv.conf and basic devices), returning a function which restores
+// the image's own files so that they are not included in the layer
+func prepareRootfs(rootfs string) (func(), error) {
+	var undo undoList
+	if err := addResolvConf(rootfs, &undo); err != nil {
+		undo.run()
+		return nil, err
+	}
+	if err := addDevices(rootfs, &undo); err != nil {
+		undo.run()
+		return nil, err
+	}
+	return undo.run, nil
+}
+
+func addResolvConf(rootfs string, undo *undoList) error {
+	etc := filepath.Join(rootfs, "etc")
+	if info, err := os.Lstat(etc); err != nil || !info.IsDir() {
+		return nil
+	}
+	resolvConf := filepath.Join(etc, "resolv.conf")
+	info, err := os.Lstat(resolvConf)
+	if err == nil && !info.Mode().IsRegular() {
+		// don't follow symlinks out of the image
+		return nil
+	}
+	data, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return err
+	}
+	if err := undo.preserveMtime(etc); err != nil {
+		return err
+	}
+	if info != nil {
+		orig, err := ioutil.ReadFile(resolvConf)
+		if err != nil {
+			return err
+		}
+		undo.add(func() {
+			ioutil.WriteFile(resolvConf, orig, info.Mode())
+			os.Chtimes(resolvConf, time.Now(), info.ModTime())
+		})
+	} else {
+		undo.add(func() { os.Remove(resolvConf) })
+	}
+	return ioutil.WriteFile(resolvConf, data, 0644)
+}
+
+func addDevices(rootfs string, undo *undoList) error {
+	dev := filepath.Join(rootfs, "dev")
+	info, err := os.Lstat(dev)
+	switch {
+	case os.IsNotExist(err):
+		if err := undo.preserveMtime(rootfs); err != nil {
+			return err
+		}
+		if err := os.Mkdir(dev, 0755); err != nil {
+			return err
+		}
+		undo.add(func() { os.Remove(dev) })
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return nil
+	default:
+		if err := undo.preserveMtime(dev); err != nil {
+			return err
+		}
+	}
+	for _, d := range rootfsDevices {
+		p := filepath.Join(dev, d.name)
+		if _, err := os.Lstat(p); err == nil {
+			continue
+		}
+		if err := syscall.Mknod(p, syscall.S_IFCHR|0666, d.major<<8|d.minor); err != nil {
+			return err
+		}
+		undo.add(func() { os.Remove(p) })
+		if err := os.Chmod(p, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoList is a list of changes to undo, which are undone in reverse order
+type undoList []func()
+
+func (u *undoList) add(f func()) {
+	*u = append(*u, f)
+}
+
+// preserveMtime restores the modification time of a directory after files
+// have been added to or removed from it
+func (u *undoList) preserveMtime(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	u.add(func() { os.Chtimes(dir, time.Now(), info.ModTime()) })
+	return nil
+}
+
+func (u undoList) run() {
+	for i := len(u) - 1; i >= 0; i-- {
+		u[i]()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestShiftOwnership(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing file owners requires root")
+	}
+	rootfs, err := ioutil.TempDir("", "dockerbuilder-exec-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+	writeTestFiles(t, rootfs, map[string]string{
+		"bin/su":    "su",
+		"bin/link":  "->su",
+		"home/user": "user",
+		"outside":   "outside",
+	})
+	if err := os.Chown(filepath.Join(rootfs, "home/user"), 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(filepath.Join(rootfs, "outside"), idMapSize, idMapSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(rootfs, "bin/su"), 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, uid, gid int) {
+		info, err := os.Lstat(filepath.Join(rootfs, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if int(stat.Uid) != uid || int(stat.Gid) != gid {
+			t.Errorf("expected %s to be owned by %d:%d, got %d:%d", name, uid, gid, stat.Uid, stat.Gid)
+		}
+		if name == "bin/su" && info.Mode()&os.ModeSetuid == 0 {
+			t.Errorf("expected %s to keep its setuid bit", name)
+		}
+	}
+
+	if err := shiftOwnership(rootfs, 0, idMapBase); err != nil {
+		t.Fatal(err)
+	}
+	check(".", idMapBase, idMapBase)
+	check("bin/su", idMapBase, idMapBase)
+	check("bin/link", idMapBase, idMapBase)
+	check("home/user", idMapBase+1000, idMapBase+1000)
+	check("outside", idMapSize, idMapSize)
+
+	if err := shiftOwnership(rootfs, idMapBase, 0); err != nil {
+		t.Fatal(err)
+	}
+	check(".", 0, 0)
+	check("bin/su", 0, 0)
+	check("bin/link", 0, 0)
+	check("home/user", 1000, 1000)
+	check("outside", idMapSize, idMapSize)
+}
+
+func TestLookupUserRange(t *testing.T) {
+	if _, err := lookupUser("/nonexistent", "1000:1000"); err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range []string{"65536", "1000:65536"} {
+		if _, err := lookupUser("/nonexistent", spec); err == nil {
+			t.Errorf("expected an error for USER %s", spec)
+		}
+	}
+}
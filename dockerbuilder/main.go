@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/flynn/flynn/pinkerton"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// main builds a Docker image from a Dockerfile in the build context read as a
+// tar stream from stdin, and pushes it to IMAGE_URL (a pinkerton URL with a
+// tag, typically pointing at docker-receive). Layers are cached at CACHE_URL
+// in the blobstore if it is set.
+func main() {
+	if err := run(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+}
+
+func run() error {
+	imageURL := os.Getenv("IMAGE_URL")
+	if imageURL == "" {
+		return fmt.Errorf("IMAGE_URL must be set")
+	}
+
+	root, err := ioutil.TempDir("", "dockerbuilder-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+
+	contextDir := filepath.Join(root, "context")
+	if err := os.Mkdir(contextDir, 0755); err != nil {
+		return err
+	}
+	if err := archive.Untar(os.Stdin, contextDir, &archive.TarOptions{NoLchown: true}); err != nil {
+		return fmt.Errorf("error reading build context: %s", err)
+	}
+	f, err := os.Open(filepath.Join(contextDir, "Dockerfile"))
+	if err != nil {
+		return err
+	}
+	instructions, err := parseDockerfile(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	ignore, err := readDockerignore(contextDir)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := pinkerton.BuildContext("copy", filepath.Join(root, "graph"))
+	if err != nil {
+		return err
+	}
+	b := &builder{
+		ctx:        ctx,
+		contextDir: contextDir,
+		ignore:     ignore,
+	}
+	if url := os.Getenv("CACHE_URL"); url != "" {
+		if b.cache, err = newLayerCache(url); err != nil {
+			fmt.Println("-----> WARN: could not get layer cache, building without it:", err)
+		}
+	}
+	imageID, err := b.build(instructions)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("-----> Pushing image...")
+	digest, err := ctx.PushDocker(imageURL, imageID, pinkerton.DockerPullPrinter(os.Stdout))
+	if err != nil {
+		return fmt.Errorf("error pushing image: %s", err)
+	}
+	if b.cache != nil {
+		if err := b.cache.prune(b.keys); err != nil {
+			fmt.Println("-----> WARN: could not prune layer cache:", err)
+		}
+	}
+	fmt.Printf("-----> Image pushed (digest %s)\n", digest)
+	return nil
+}
+
+// readDockerignore reads the patterns of files in the build context which
+// ADD and COPY ignore
+func readDockerignore(contextDir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		p := strings.TrimSpace(s.Text())
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		patterns = append(patterns, filepath.Clean(p))
+	}
+	return patterns, s.Err()
+}
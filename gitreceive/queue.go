@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// defaultMaxBuilds is the number of builds run concurrently if
+// BUILD_CONCURRENCY is not set
+const defaultMaxBuilds = 4
+
+// buildQueue limits the number of concurrent builds, starting queued builds
+// in the order they were queued as running builds finish.
+//
+// Builds are run by flynn-receiver from the pre-receive hook, which queues the
+// build by making a request to /builds/queue and starts the build once it is
+// told to. The build is removed from the queue when the request ends, so
+// builds don't hold on to a slot if the receiver exits unexpectedly.
+type buildQueue struct {
+	mtx     sync.Mutex
+	max     int
+	running int
+	waiting []*queuedBuild
+}
+
+type queuedBuild struct {
+	start   chan struct{}
+	started bool
+}
+
+// queueEvent is streamed as JSON to the receiver waiting for a build slot
+type queueEvent struct {
+	// Type is either "queued", with Position being the number of builds
+	// ahead of this one, or "start"
+	Type     string `json:"type"`
+	Position int    `json:"position,omitempty"`
+}
+
+func newBuildQueue() *buildQueue {
+	max := defaultMaxBuilds
+	if n, err := strconv.Atoi(os.Getenv("BUILD_CONCURRENCY")); err == nil && n > 0 {
+		max = n
+	}
+	return &buildQueue{max: max}
+}
+
+// add queues a build, returning its position in the queue
+func (q *buildQueue) add() (*queuedBuild, int) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	b := &queuedBuild{start: make(chan struct{})}
+	q.waiting = append(q.waiting, b)
+	position := len(q.waiting) - 1
+	q.startBuilds()
+	return b, position
+}
+
+// done removes a build from the queue, starting the next one if it was
+// running
+func (q *buildQueue) done(b *queuedBuild) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if b.started {
+		q.running--
+	} else {
+		for i, w := range q.waiting {
+			if w == b {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	q.startBuilds()
+}
+
+func (q *buildQueue) startBuilds() {
+	for q.running < q.max && len(q.waiting) > 0 {
+		b := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		b.started = true
+		q.running++
+		close(b.start)
+	}
+}
+
+// ServeHTTP queues a build for the duration of the request, streaming
+// queueEvents to the client
+func (q *buildQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, position := q.add()
+	defer q.done(b)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	enc := json.NewEncoder(newWriteFlusher(w))
+	if position > 0 {
+		if err := enc.Encode(&queueEvent{Type: "queued", Position: position}); err != nil {
+			return
+		}
+	}
+	closed := w.(http.CloseNotifier).CloseNotify()
+	select {
+	case <-b.start:
+	case <-closed:
+		return
+	}
+	if err := enc.Encode(&queueEvent{Type: "start"}); err != nil {
+		return
+	}
+
+	// keep the slot until the receiver disconnects
+	<-closed
+}
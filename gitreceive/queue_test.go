@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/flynn/go-check"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type QueueSuite struct{}
+
+var _ = Suite(&QueueSuite{})
+
+func started(b *queuedBuild) bool {
+	select {
+	case <-b.start:
+		return true
+	default:
+		return false
+	}
+}
+
+func (QueueSuite) TestConcurrencyLimit(c *C) {
+	q := &buildQueue{max: 2}
+	b1, pos := q.add()
+	c.Assert(pos, Equals, 0)
+	b2, pos := q.add()
+	c.Assert(pos, Equals, 0)
+	b3, pos := q.add()
+	c.Assert(pos, Equals, 0)
+	b4, pos := q.add()
+	c.Assert(pos, Equals, 1)
+
+	// only max builds run at once
+	c.Assert(started(b1), Equals, true)
+	c.Assert(started(b2), Equals, true)
+	c.Assert(started(b3), Equals, false)
+	c.Assert(started(b4), Equals, false)
+	c.Assert(q.running, Equals, 2)
+
+	q.done(b1)
+	q.done(b2)
+	q.done(b3)
+	q.done(b4)
+	c.Assert(q.running, Equals, 0)
+	c.Assert(q.waiting, HasLen, 0)
+}
+
+func (QueueSuite) TestFIFO(c *C) {
+	q := &buildQueue{max: 1}
+	running, _ := q.add()
+	builds := make([]*queuedBuild, 3)
+	for i := range builds {
+		var pos int
+		builds[i], pos = q.add()
+		c.Assert(pos, Equals, i)
+	}
+
+	// builds start in the order they were queued as running builds
+	// finish
+	for _, b := range builds {
+		c.Assert(started(b), Equals, false)
+		q.done(running)
+		c.Assert(started(b), Equals, true)
+		running = b
+	}
+	q.done(running)
+	c.Assert(q.running, Equals, 0)
+}
+
+func (QueueSuite) TestDoneQueued(c *C) {
+	q := &buildQueue{max: 1}
+	running, _ := q.add()
+	b1, _ := q.add()
+	b2, _ := q.add()
+
+	// removing a queued build doesn't free a slot or start another build
+	q.done(b1)
+	c.Assert(q.running, Equals, 1)
+	c.Assert(q.waiting, DeepEquals, []*queuedBuild{b2})
+	c.Assert(started(b2), Equals, false)
+
+	// but the builds behind it move up
+	_, pos := q.add()
+	c.Assert(pos, Equals, 1)
+	q.done(running)
+	c.Assert(started(b2), Equals, true)
+}
+
+func (QueueSuite) TestDoneRunning(c *C) {
+	q := &buildQueue{max: 1}
+	running, _ := q.add()
+	b1, _ := q.add()
+	b2, _ := q.add()
+
+	// finishing a running build starts the next queued build only
+	q.done(running)
+	c.Assert(q.running, Equals, 1)
+	c.Assert(started(b1), Equals, true)
+	c.Assert(started(b2), Equals, false)
+	c.Assert(q.waiting, DeepEquals, []*queuedBuild{b2})
+}
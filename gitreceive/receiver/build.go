@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/stream"
+)
+
+var errBuildCancelled = errors.New("Build cancelled as a newer push is being built")
+
+// queueEvent is streamed by gitreceive whilst a build waits for a slot
+type queueEvent struct {
+	Type     string `json:"type"`
+	Position int    `json:"position,omitempty"`
+}
+
+// waitForBuildSlot queues the build with gitreceive and returns once it can
+// start, or once cancel is closed. The build holds the slot until the returned
+// io.Closer is closed.
+func waitForBuildSlot(cancel <-chan struct{}) (io.Closer, error) {
+	port := os.Getenv("PORT")
+	if port == "" {
+		// not running under gitreceive, so there is no queue
+		return ioutil.NopCloser(nil), nil
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://127.0.0.1:%s/builds/queue", port), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("", os.Getenv("CONTROLLER_KEY"))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error queueing build: %s", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("Error queueing build: unexpected status %d", res.StatusCode)
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		dec := json.NewDecoder(res.Body)
+		for {
+			var event queueEvent
+			if err := dec.Decode(&event); err != nil {
+				started <- fmt.Errorf("Error waiting for build slot: %s", err)
+				return
+			}
+			switch event.Type {
+			case "queued":
+				fmt.Printf("-----> Waiting for %d other build(s) to finish...\n", event.Position)
+			case "start":
+				started <- nil
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-started:
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		return res.Body, nil
+	case <-cancel:
+		res.Body.Close()
+		return nil, errBuildCancelled
+	}
+}
+
+// watchNewerBuilds streams the app's build events, closing the returned
+// channel when a build is created after the build with the given ID, which
+// supersedes it. It must be called before the build is created.
+func watchNewerBuilds(client controller.Client, appID, buildID string) (<-chan struct{}, stream.Stream, error) {
+	events := make(chan *ct.Event)
+	stream, err := client.StreamEvents(ct.StreamEventsOptions{
+		AppID:       appID,
+		ObjectTypes: []ct.EventType{ct.EventTypeBuild},
+	}, events)
+	if err != nil {
+		return nil, nil, err
+	}
+	superseded := make(chan struct{})
+	go func() {
+		created := false
+		for event := range events {
+			if event.ObjectID == buildID {
+				created = true
+				continue
+			}
+			var build ct.Build
+			if err := json.Unmarshal(event.Data, &build); err != nil {
+				continue
+			}
+			if created && build.State == ct.BuildStatePending {
+				close(superseded)
+				break
+			}
+		}
+		// drain the stream until it is closed
+		for range events {
+		}
+	}()
+	return superseded, stream, nil
+}
+
+// finishBuild records the outcome of the build, only printing errors as the
+// build itself has already either succeeded or failed
+func finishBuild(client controller.Client, build *ct.Build, err error) {
+	switch {
+	case err == errBuildCancelled:
+		build.State = ct.BuildStateCancelled
+	case err != nil:
+		build.State = ct.BuildStateFailed
+		build.Error = err.Error()
+	default:
+		build.State = ct.BuildStateSucceeded
+	}
+	if err := client.UpdateBuild(build.AppID, build); err != nil {
+		fmt.Println("-----> WARN: could not record build status:", err)
+		return
+	}
+	if build.Duration != nil && build.QueueDuration != nil {
+		fmt.Printf("-----> Build took %.1fs (queued for %.1fs)\n", build.Duration.Seconds(), build.QueueDuration.Seconds())
+	}
+}
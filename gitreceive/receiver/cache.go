@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dependencyManifests are the files which determine an app's dependencies for
+// the supported buildpacks, a change to any of which changes the build cache
+// key
+var dependencyManifests = map[string]struct{}{
+	".buildpacks":         {},
+	"Gemfile":             {},
+	"Gemfile.lock":        {},
+	"package.json":        {},
+	"npm-shrinkwrap.json": {},
+	"yarn.lock":           {},
+	"requirements.txt":    {},
+	"runtime.txt":         {},
+	"Pipfile.lock":        {},
+	"setup.py":            {},
+	"composer.json":       {},
+	"composer.lock":       {},
+	"pom.xml":             {},
+	"build.gradle":        {},
+	"project.clj":         {},
+	"Godeps/Godeps.json":  {},
+	"vendor/vendor.json":  {},
+	"glide.lock":          {},
+	"mix.lock":            {},
+	"Cargo.lock":          {},
+}
+
+// maxBuildCaches is the number of build caches kept for each app
+const maxBuildCaches = 3
+
+// source is a copy of the pushed source tarball
+type source struct {
+	*os.File
+
+	// digests are the SHA256 digests of the dependency manifests in the
+	// source
+	digests map[string]string
+
+	// dockerfile is whether the source has a Dockerfile at its root
+	dockerfile bool
+}
+
+// readSource copies the source tarball to a temporary file so that the build
+// cache key and type of build can be determined before the build starts,
+// returning the source with the file positioned at the start.
+func readSource(r io.Reader) (*source, error) {
+	f, err := ioutil.TempFile("", "flynn-receive-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+
+	src := &source{File: f, digests: make(map[string]string)}
+	tr := tar.NewReader(io.TeeReader(r, f))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			f.Close()
+			return nil, err
+		}
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "/")
+		if name == "Dockerfile" && hdr.Typeflag == tar.TypeReg {
+			src.dockerfile = true
+		}
+		if _, ok := dependencyManifests[name]; !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			f.Close()
+			return nil, err
+		}
+		src.digests[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	// copy any trailing padding after the end of the archive
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+// buildCacheKey returns the key of the build cache for a build using the
+// given slugbuilder image, buildpack URL (empty if the buildpack is detected)
+// and dependency manifest digests.
+func buildCacheKey(slugbuilder, buildpackURL string, digests map[string]string) string {
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "slugbuilder %s\n", slugbuilder)
+	fmt.Fprintf(h, "buildpack %s\n", buildpackURL)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %s\n", name, digests[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// buildCacheIndex is stored in the blobstore alongside an app's build caches
+// and lists them, most recently used first
+type buildCacheIndex struct {
+	Caches []*buildCacheEntry `json:"caches"`
+}
+
+type buildCacheEntry struct {
+	Key    string    `json:"key"`
+	UsedAt time.Time `json:"used_at"`
+}
+
+func buildCacheDir(appID string) string {
+	return fmt.Sprintf("%s/build-cache/%s", blobstoreURL, appID)
+}
+
+func buildCacheURL(appID, key string) string {
+	return fmt.Sprintf("%s/%s.tgz", buildCacheDir(appID), key)
+}
+
+// legacyBuildCacheURL is where the app's build cache was stored before caches
+// were keyed, which is used as a fallback for the first keyed build
+func legacyBuildCacheURL(appID string) string {
+	return fmt.Sprintf("%s/%s-cache.tgz", blobstoreURL, appID)
+}
+
+// getBuildCacheIndex returns the app's build cache index, which is empty if
+// it doesn't exist yet
+func getBuildCacheIndex(appID string) (*buildCacheIndex, error) {
+	index := &buildCacheIndex{}
+	res, err := http.Get(buildCacheDir(appID) + "/index.json")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return index, nil
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d getting build cache index", res.StatusCode)
+	}
+	return index, json.NewDecoder(res.Body).Decode(index)
+}
+
+func (i *buildCacheIndex) has(key string) bool {
+	for _, c := range i.Caches {
+		if c.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackURL returns the cache to restore if there isn't one for the build's
+// key, which is the most recently used cache as it is likely to contain most
+// of the dependencies
+func (i *buildCacheIndex) fallbackURL(appID string) string {
+	if len(i.Caches) == 0 {
+		return legacyBuildCacheURL(appID)
+	}
+	return buildCacheURL(appID, i.Caches[0].Key)
+}
+
+// use marks the cache with the given key as the most recently used, removing
+// the least recently used caches from the blobstore so that at most
+// maxBuildCaches are kept
+func (i *buildCacheIndex) use(appID, key string) error {
+	caches := []*buildCacheEntry{{Key: key, UsedAt: time.Now().UTC()}}
+	for _, c := range i.Caches {
+		if c.Key != key {
+			caches = append(caches, c)
+		}
+	}
+	var pruned []*buildCacheEntry
+	if len(caches) > maxBuildCaches {
+		pruned = caches[maxBuildCaches:]
+		caches = caches[:maxBuildCaches]
+	}
+	i.Caches = caches
+
+	data, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	if err := blobRequest("PUT", buildCacheDir(appID)+"/index.json", bytes.NewReader(data)); err != nil {
+		return err
+	}
+	for _, c := range pruned {
+		blobRequest("DELETE", buildCacheURL(appID, c.Key), nil)
+	}
+	return nil
+}
+
+func blobRequest(method, url string, body io.Reader) error {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status %d from %s %s", res.StatusCode, method, url)
+	}
+	return nil
+}
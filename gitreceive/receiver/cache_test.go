@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/flynn/go-check"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type CacheSuite struct{}
+
+var _ = Suite(&CacheSuite{})
+
+func digest(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func (CacheSuite) TestBuildCacheKey(c *C) {
+	digests := map[string]string{
+		"Gemfile":      digest("gem 'rails'"),
+		"Gemfile.lock": digest("rails (5.0.0)"),
+		"package.json": digest("{}"),
+	}
+	key := buildCacheKey("slugbuilder-1", "", digests)
+
+	// the key doesn't depend on map iteration order
+	for i := 0; i < 10; i++ {
+		c.Assert(buildCacheKey("slugbuilder-1", "", digests), Equals, key)
+	}
+
+	// but changes with the slugbuilder, buildpack or any manifest
+	c.Assert(buildCacheKey("slugbuilder-2", "", digests), Not(Equals), key)
+	c.Assert(buildCacheKey("slugbuilder-1", "https://github.com/heroku/heroku-buildpack-ruby", digests), Not(Equals), key)
+	for name := range digests {
+		changed := make(map[string]string, len(digests))
+		for n, d := range digests {
+			changed[n] = d
+		}
+		changed[name] = digest("changed")
+		c.Assert(buildCacheKey("slugbuilder-1", "", changed), Not(Equals), key)
+
+		delete(changed, name)
+		c.Assert(buildCacheKey("slugbuilder-1", "", changed), Not(Equals), key)
+	}
+
+	// a manifest's digest can't be confused with another manifest's
+	c.Assert(
+		buildCacheKey("slugbuilder-1", "", map[string]string{"Gemfile": "a", "Gemfile.lock": "b"}),
+		Not(Equals),
+		buildCacheKey("slugbuilder-1", "", map[string]string{"Gemfile": "b", "Gemfile.lock": "a"}),
+	)
+}
+
+func (CacheSuite) TestReadSource(c *C) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range []struct {
+		name     string
+		typeflag byte
+		data     string
+	}{
+		{name: "./Gemfile", typeflag: tar.TypeReg, data: "gem 'rails'"},
+		{name: "Godeps/", typeflag: tar.TypeDir},
+		{name: "Godeps/Godeps.json", typeflag: tar.TypeReg, data: "{}"},
+		{name: "app/package.json", typeflag: tar.TypeReg, data: "{}"},
+		{name: "yarn.lock", typeflag: tar.TypeSymlink},
+		{name: "main.go", typeflag: tar.TypeReg, data: "package main"},
+	} {
+		c.Assert(tw.WriteHeader(&tar.Header{
+			Name:     f.name,
+			Typeflag: f.typeflag,
+			Mode:     0644,
+			Size:     int64(len(f.data)),
+		}), IsNil)
+		_, err := tw.Write([]byte(f.data))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(tw.Close(), IsNil)
+	tarball := buf.Bytes()
+
+	src, err := readSource(bytes.NewReader(tarball))
+	c.Assert(err, IsNil)
+	defer src.Close()
+
+	// only regular dependency manifests at the root of the source are
+	// digested
+	c.Assert(src.digests, DeepEquals, map[string]string{
+		"Gemfile":            digest("gem 'rails'"),
+		"Godeps/Godeps.json": digest("{}"),
+	})
+	c.Assert(src.dockerfile, Equals, false)
+
+	// the whole tarball is available to the build
+	data, err := ioutil.ReadAll(src)
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, tarball)
+}
+
+func (CacheSuite) TestBuildCacheIndex(c *C) {
+	index := &buildCacheIndex{}
+	c.Assert(index.has("a"), Equals, false)
+	c.Assert(index.fallbackURL("app"), Equals, legacyBuildCacheURL("app"))
+
+	index.Caches = []*buildCacheEntry{{Key: "b"}, {Key: "a"}}
+	c.Assert(index.has("a"), Equals, true)
+	c.Assert(index.has("c"), Equals, false)
+	c.Assert(index.fallbackURL("app"), Equals, buildCacheURL("app", "b"))
+}
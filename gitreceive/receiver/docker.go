@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/docker-receive/pushtoken"
+	"github.com/flynn/flynn/host/types"
+)
+
+// digestPattern matches the digest of the image pushed by dockerbuilder
+var digestPattern = regexp.MustCompile(`Image pushed \(digest (\S+)\)`)
+
+const dockerReceiveURL = "http://docker-receive.discoverd"
+
+// dockerPushTokenExpiry is how long dockerbuilder can push the app's image
+// for after the build starts
+const dockerPushTokenExpiry = 2 * time.Hour
+
+// dockerImageURL returns the pinkerton URL dockerbuilder pushes the app's
+// image to, which is the app's repository in docker-receive. The URL contains
+// a push token for only that repository rather than the controller key, as
+// RUN instructions in the app's Dockerfile can read the job's environment.
+func dockerImageURL(appName string) string {
+	token := pushtoken.New(os.Getenv("CONTROLLER_KEY"), appName, time.Now().Add(dockerPushTokenExpiry))
+	return fmt.Sprintf("http://flynn:%s@docker-receive.discoverd?name=%s&tag=latest", token, appName)
+}
+
+// dockerCacheURL returns where dockerbuilder caches the layers of the app's
+// image
+func dockerCacheURL(appID string) string {
+	return fmt.Sprintf("%s/docker-cache/%s", blobstoreURL, appID)
+}
+
+// dockerArtifact returns the artifact for the app's image with the given
+// digest, which is the same as the one docker-receive creates when the image
+// is pushed (so creating it returns the existing artifact)
+func dockerArtifact(appName, digest string) *ct.Artifact {
+	return &ct.Artifact{
+		Type: host.ArtifactTypeDocker,
+		URI:  fmt.Sprintf("http://flynn:%s@docker-receive.discoverd?name=%s&id=%s", os.Getenv("CONTROLLER_KEY"), appName, digest),
+		Meta: map[string]string{
+			"docker-receive.repository": appName,
+			"docker-receive.digest":     digest,
+		},
+	}
+}
+
+// imageConfig is the part of a Docker image config used to create releases
+type imageConfig struct {
+	Cmd          []string            `json:"Cmd"`
+	Entrypoint   []string            `json:"Entrypoint"`
+	Env          []string            `json:"Env"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+}
+
+// getImageConfig gets the config of the app's image with the given digest
+// from its manifest in docker-receive
+func getImageConfig(appName, digest string) (*imageConfig, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v2/%s/manifests/%s", dockerReceiveURL, appName, digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("flynn", os.Getenv("CONTROLLER_KEY"))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d getting image manifest", res.StatusCode)
+	}
+	var manifest struct {
+		History []struct {
+			V1Compatibility string `json:"v1Compatibility"`
+		} `json:"history"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	if len(manifest.History) == 0 {
+		return nil, fmt.Errorf("image manifest has no history")
+	}
+	// the first history entry is the config of the top layer
+	var image struct {
+		Config *imageConfig `json:"config"`
+	}
+	if err := json.Unmarshal([]byte(manifest.History[0].V1Compatibility), &image); err != nil {
+		return nil, err
+	}
+	if image.Config == nil {
+		return &imageConfig{}, nil
+	}
+	return image.Config, nil
+}
+
+// webProcess returns a web process which runs the image's command, using the
+// lowest TCP port the image exposes (or 8080 if there isn't one)
+func (c *imageConfig) webProcess(appName string, proc ct.ProcessType) ct.ProcessType {
+	proc.Args = append(append([]string{}, c.Entrypoint...), c.Cmd...)
+	proc.Service = appName + "-web"
+	proc.Ports = []ct.Port{{
+		Port:  c.port(),
+		Proto: "tcp",
+		Service: &host.Service{
+			Name:   proc.Service,
+			Create: true,
+			Check:  &host.HealthCheck{Type: "tcp"},
+		},
+	}}
+	return proc
+}
+
+func (c *imageConfig) port() int {
+	var ports []int
+	for p := range c.ExposedPorts {
+		parts := strings.SplitN(p, "/", 2)
+		if len(parts) == 2 && parts[1] != "tcp" {
+			continue
+		}
+		if port, err := strconv.Atoi(parts[0]); err == nil {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 0 {
+		return 8080
+	}
+	sort.Ints(ports)
+	return ports[0]
+}
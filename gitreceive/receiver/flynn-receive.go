@@ -16,6 +16,7 @@ import (
 	"github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/gitreceive/reviewapp"
 	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/cluster"
 	"github.com/flynn/flynn/pkg/exec"
@@ -54,18 +55,20 @@ func main() {
 	}
 }
 
-func run() error {
+func run() (err error) {
 	client, err := controller.NewClient("", os.Getenv("CONTROLLER_KEY"))
 	if err != nil {
 		return fmt.Errorf("Unable to connect to controller: %s", err)
 	}
 
 	usage := `
-Usage: flynn-receiver <app> <rev> [-e <var>=<val>]... [-m <key>=<val>]...
+Usage: flynn-receiver <app> <rev> [--branch=<branch> [--delete]] [-e <var>=<val>]... [-m <key>=<val>]...
 
 Options:
 	-e,--env <var>=<val>
 	-m,--meta <key>=<val>
+	--branch=<branch>  deploy to the review app for the branch
+	--delete           delete the review app for the branch
 `[1:]
 	args, _ := docopt.Parse(usage, nil, true, version.String(), false)
 
@@ -85,33 +88,100 @@ Options:
 	} else if err != nil {
 		return fmt.Errorf("Error retrieving app: %s", err)
 	}
-	prevRelease, err := client.GetAppRelease(app.Name)
-	if err == controller.ErrNotFound {
-		prevRelease = &ct.Release{}
-	} else if err != nil {
-		return fmt.Errorf("Error getting current app release: %s", err)
+	var prevRelease *ct.Release
+	if branch := args.String["--branch"]; branch != "" {
+		parent := app
+		if args.Bool["--delete"] {
+			return deleteReviewApp(client, parent, branch)
+		}
+		app, err = reviewapp.Get(client, parent, branch)
+		if err != nil {
+			return fmt.Errorf("Error getting review app: %s", err)
+		}
+		fmt.Printf("-----> Deploying branch %s to review app %s\n", branch, app.Name)
+		prevRelease, err = reviewapp.BaseRelease(client, parent, app)
+		if err != nil {
+			return fmt.Errorf("Error getting review app release: %s", err)
+		}
+	} else {
+		prevRelease, err = client.GetAppRelease(app.Name)
+		if err == controller.ErrNotFound {
+			prevRelease = &ct.Release{}
+		} else if err != nil {
+			return fmt.Errorf("Error getting current app release: %s", err)
+		}
 	}
 
-	fmt.Printf("-----> Building %s...\n", app.Name)
+	source, err := readSource(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("Error reading source: %s", err)
+	}
+	defer source.Close()
 
 	jobEnv := make(map[string]string)
-	jobEnv["BUILD_CACHE_URL"] = fmt.Sprintf("%s/%s-cache.tgz", blobstoreURL, app.ID)
 	if buildpackURL, ok := env["BUILDPACK_URL"]; ok {
 		jobEnv["BUILDPACK_URL"] = buildpackURL
 	} else if buildpackURL, ok := prevRelease.Env["BUILDPACK_URL"]; ok {
 		jobEnv["BUILDPACK_URL"] = buildpackURL
 	}
-	for _, k := range []string{"SSH_CLIENT_KEY", "SSH_CLIENT_HOSTS"} {
-		if v := os.Getenv(k); v != "" {
-			jobEnv[k] = v
+
+	// apps with a Dockerfile are built from it by dockerbuilder (which
+	// caches image layers itself) unless a buildpack is set
+	dockerBuild := source.dockerfile && jobEnv["BUILDPACK_URL"] == ""
+
+	// slug builds use the cache for their slugbuilder, buildpack and
+	// dependencies, restoring the most recently used cache if there
+	// isn't one yet
+	var cacheKey string
+	var cacheHit bool
+	var cacheIndex *buildCacheIndex
+	if !dockerBuild {
+		cacheKey = buildCacheKey(os.Getenv("SLUGBUILDER_IMAGE_URI"), jobEnv["BUILDPACK_URL"], source.digests)
+		cacheIndex, err = getBuildCacheIndex(app.ID)
+		if err != nil {
+			return fmt.Errorf("Error getting build cache index: %s", err)
+		}
+		cacheHit = cacheIndex.has(cacheKey)
+		jobEnv["BUILD_CACHE_URL"] = buildCacheURL(app.ID, cacheKey)
+		if !cacheHit {
+			jobEnv["BUILD_CACHE_FALLBACK_URL"] = cacheIndex.fallbackURL(app.ID)
 		}
 	}
-	slugURL := fmt.Sprintf("%s/%s/slug.tgz", blobstoreURL, random.UUID())
 
+	// record the build, watching for newer pushes which supersede it
+	build := &ct.Build{
+		ID:       random.UUID(),
+		AppID:    app.ID,
+		Rev:      args.String["<rev>"],
+		CacheKey: cacheKey,
+		CacheHit: cacheHit,
+	}
+	superseded, buildStream, err := watchNewerBuilds(client, app.ID, build.ID)
+	if err != nil {
+		return fmt.Errorf("Error streaming build events: %s", err)
+	}
+	defer buildStream.Close()
+	if err := client.CreateBuild(app.ID, build); err != nil {
+		return fmt.Errorf("Error creating build: %s", err)
+	}
+	defer func() { finishBuild(client, build, err) }()
+
+	slot, err := waitForBuildSlot(superseded)
+	if err != nil {
+		return err
+	}
+	defer slot.Close()
+	build.State = ct.BuildStateRunning
+	if err := client.UpdateBuild(app.ID, build); err != nil {
+		return fmt.Errorf("Error updating build: %s", err)
+	}
+
+	fmt.Printf("-----> Building %s...\n", app.Name)
+	if cacheHit {
+		fmt.Println("-----> Using build cache")
+	}
 	job := &host.Job{
 		Config: host.ContainerConfig{
-			Args:       []string{"/tmp/builder/build.sh", slugURL},
-			Env:        jobEnv,
 			Stdin:      true,
 			DisableLog: true,
 		},
@@ -120,98 +190,122 @@ Options:
 			"flynn-controller.app":      app.ID,
 			"flynn-controller.app_name": app.Name,
 			"flynn-controller.release":  prevRelease.ID,
-			"flynn-controller.type":     "slugbuilder",
 		},
 	}
 	if sb, ok := prevRelease.Processes["slugbuilder"]; ok {
 		job.Resources = sb.Resources
 	}
+	var imageURI, slugURL string
+	if dockerBuild {
+		imageURI = os.Getenv("DOCKERBUILDER_IMAGE_URI")
+		job.Config.Env = map[string]string{
+			"IMAGE_URL": dockerImageURL(app.Name),
+			"CACHE_URL": dockerCacheURL(app.ID),
+		}
+		job.Metadata["flynn-controller.type"] = "dockerbuilder"
+	} else {
+		for _, k := range []string{"SSH_CLIENT_KEY", "SSH_CLIENT_HOSTS"} {
+			if v := os.Getenv(k); v != "" {
+				jobEnv[k] = v
+			}
+		}
+		slugURL = fmt.Sprintf("%s/%s/slug.tgz", blobstoreURL, random.UUID())
+		imageURI = os.Getenv("SLUGBUILDER_IMAGE_URI")
+		job.Config.Args = []string{"/tmp/builder/build.sh", slugURL}
+		job.Config.Env = jobEnv
+		job.Metadata["flynn-controller.type"] = "slugbuilder"
+	}
 
-	cmd := exec.Job(exec.DockerImage(os.Getenv("SLUGBUILDER_IMAGE_URI")), job)
+	cmd := exec.Job(exec.DockerImage(imageURI), job)
 	var output bytes.Buffer
 	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
 	cmd.Stderr = os.Stderr
 
-	if len(prevRelease.Env) > 0 {
+	stdinErr := make(chan error, 1)
+	if !dockerBuild && len(prevRelease.Env) > 0 {
 		stdin, err := cmd.StdinPipe()
 		if err != nil {
 			return err
 		}
 		go func() {
-			if err := appendEnvDir(os.Stdin, stdin, prevRelease.Env); err != nil {
-				log.Fatalln("ERROR:", err)
-			}
+			stdinErr <- appendEnvDir(source, stdin, prevRelease.Env)
 		}()
 	} else {
-		cmd.Stdin = os.Stdin
+		cmd.Stdin = source
 	}
 
 	shutdown.BeforeExit(func() { cmd.Kill() })
-	if err := cmd.Run(); err != nil {
+	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("Build failed: %s", err)
 	}
 
-	var types []string
-	if match := typesPattern.FindSubmatch(output.Bytes()); match != nil {
-		types = strings.Split(string(match[1]), ", ")
+	// stop the build if a newer push arrives whilst it is running
+	buildDone := make(chan struct{})
+	cancelled := make(chan struct{})
+	go func() {
+		select {
+		case <-superseded:
+			close(cancelled)
+			cmd.Kill()
+		case <-buildDone:
+		}
+	}()
+	err = cmd.Wait()
+	close(buildDone)
+	select {
+	case <-cancelled:
+		return errBuildCancelled
+	default:
 	}
-
-	fmt.Printf("-----> Creating release...\n")
-
-	artifact := &ct.Artifact{Type: host.ArtifactTypeDocker, URI: os.Getenv("SLUGRUNNER_IMAGE_URI")}
-	if err := client.CreateArtifact(artifact); err != nil {
-		return fmt.Errorf("Error creating image artifact: %s", err)
+	if err != nil {
+		select {
+		case e := <-stdinErr:
+			if e != nil {
+				err = e
+			}
+		default:
+		}
+		return fmt.Errorf("Build failed: %s", err)
 	}
+	slot.Close()
 
-	slugArtifact := &ct.Artifact{
-		Type: host.ArtifactTypeFile,
-		URI:  slugURL,
-		Meta: map[string]string{"blobstore": "true"},
-	}
-	if err := client.CreateArtifact(slugArtifact); err != nil {
-		return fmt.Errorf("Error creating slug artifact: %s", err)
-	}
+	fmt.Printf("-----> Creating release...\n")
 
 	release := &ct.Release{
-		ArtifactIDs: []string{artifact.ID, slugArtifact.ID},
-		Env:         prevRelease.Env,
-		Meta:        prevRelease.Meta,
+		Env:  prevRelease.Env,
+		Meta: prevRelease.Meta,
 	}
 	if release.Meta == nil {
-		release.Meta = make(map[string]string, len(meta))
+		release.Meta = make(map[string]string, len(meta)+1)
 	}
 	if release.Env == nil {
 		release.Env = make(map[string]string, len(env))
 	}
+	if dockerBuild {
+		if err := dockerRelease(client, app, prevRelease, release, output.Bytes()); err != nil {
+			return err
+		}
+	} else {
+		if err := slugRelease(client, app, prevRelease, release, slugURL, output.Bytes()); err != nil {
+			return err
+		}
+	}
 	for k, v := range env {
 		release.Env[k] = v
 	}
 	for k, v := range meta {
 		release.Meta[k] = v
 	}
-	procs := make(map[string]ct.ProcessType)
-	for _, t := range types {
-		proc := prevRelease.Processes[t]
-		proc.Args = []string{"/runner/init", "start", t}
-		if t == "web" || strings.HasSuffix(t, "-web") {
-			proc.Service = app.Name + "-" + t
-			proc.Ports = []ct.Port{{
-				Port:  8080,
-				Proto: "tcp",
-				Service: &host.Service{
-					Name:   proc.Service,
-					Create: true,
-					Check:  &host.HealthCheck{Type: "tcp"},
-				},
-			}}
-		}
-		procs[t] = proc
-	}
-	release.Processes = procs
 
 	if err := client.CreateRelease(release); err != nil {
 		return fmt.Errorf("Error creating release: %s", err)
 	}
+	build.ReleaseID = release.ID
+	if cacheIndex != nil {
+		if err := cacheIndex.use(app.ID, cacheKey); err != nil {
+			fmt.Println("-----> WARN: could not update build cache index:", err)
+		}
+	}
 	if err := client.DeployAppRelease(app.Name, release.ID, nil); err != nil {
 		return fmt.Errorf("Error deploying app release: %s", err)
 	}
@@ -220,7 +314,7 @@ Options:
 	// web=1 formation and wait for the "APPNAME-web" service to start
 	// (whilst also watching job events so the deploy fails if the job
 	// crashes)
-	if needsDefaultScale(app.ID, prevRelease.ID, procs, client) {
+	if needsDefaultScale(app.ID, prevRelease.ID, release.Processes, client) {
 		fmt.Println("=====> Scaling initial release to web=1")
 
 		formation := &ct.Formation{
@@ -286,6 +380,104 @@ Options:
 	return nil
 }
 
+// slugRelease adds the slugrunner image and the slug built by slugbuilder to
+// the release, with a process for each of the types in the app's Procfile
+func slugRelease(client controller.Client, app *ct.App, prevRelease, release *ct.Release, slugURL string, output []byte) error {
+	var types []string
+	if match := typesPattern.FindSubmatch(output); match != nil {
+		types = strings.Split(string(match[1]), ", ")
+	}
+
+	artifact := &ct.Artifact{Type: host.ArtifactTypeDocker, URI: os.Getenv("SLUGRUNNER_IMAGE_URI")}
+	if err := client.CreateArtifact(artifact); err != nil {
+		return fmt.Errorf("Error creating image artifact: %s", err)
+	}
+
+	slugArtifact := &ct.Artifact{
+		Type: host.ArtifactTypeFile,
+		URI:  slugURL,
+		Meta: map[string]string{"blobstore": "true"},
+	}
+	if err := client.CreateArtifact(slugArtifact); err != nil {
+		return fmt.Errorf("Error creating slug artifact: %s", err)
+	}
+	release.ArtifactIDs = []string{artifact.ID, slugArtifact.ID}
+
+	// the app may previously have been built from a Dockerfile
+	delete(release.Meta, "docker-receive")
+
+	procs := make(map[string]ct.ProcessType)
+	for _, t := range types {
+		proc := prevRelease.Processes[t]
+		proc.Args = []string{"/runner/init", "start", t}
+		if t == "web" || strings.HasSuffix(t, "-web") {
+			proc.Service = app.Name + "-" + t
+			proc.Ports = []ct.Port{{
+				Port:  8080,
+				Proto: "tcp",
+				Service: &host.Service{
+					Name:   proc.Service,
+					Create: true,
+					Check:  &host.HealthCheck{Type: "tcp"},
+				},
+			}}
+		}
+		procs[t] = proc
+	}
+	release.Processes = procs
+	return nil
+}
+
+// dockerRelease adds the image built by dockerbuilder to the release, with a
+// web process which runs the image's command, the other process types of the
+// previous release and the image's environment overriding that of the
+// previous release
+func dockerRelease(client controller.Client, app *ct.App, prevRelease, release *ct.Release, output []byte) error {
+	match := digestPattern.FindSubmatch(output)
+	if match == nil {
+		return errors.New("Error determining the digest of the built image")
+	}
+	digest := string(match[1])
+
+	artifact := dockerArtifact(app.Name, digest)
+	if err := client.CreateArtifact(artifact); err != nil {
+		return fmt.Errorf("Error creating image artifact: %s", err)
+	}
+	release.ArtifactIDs = []string{artifact.ID}
+
+	config, err := getImageConfig(app.Name, digest)
+	if err != nil {
+		return fmt.Errorf("Error getting image config: %s", err)
+	}
+	for _, v := range config.Env {
+		keyVal := strings.SplitN(v, "=", 2)
+		if len(keyVal) != 2 {
+			continue
+		}
+		release.Env[keyVal[0]] = keyVal[1]
+	}
+	release.Meta["docker-receive"] = "true"
+	procs := make(map[string]ct.ProcessType, len(prevRelease.Processes)+1)
+	for t, proc := range prevRelease.Processes {
+		procs[t] = proc
+	}
+	procs["web"] = config.webProcess(app.Name, prevRelease.Processes["web"])
+	release.Processes = procs
+	return nil
+}
+
+func deleteReviewApp(client controller.Client, parent *ct.App, branch string) error {
+	app, err := reviewapp.Delete(client, parent, branch)
+	if err == controller.ErrNotFound {
+		fmt.Printf("-----> Branch %s has no review app\n", branch)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Error deleting review app: %s", err)
+	}
+	fmt.Printf("=====> Review app %s deleted\n", app.Name)
+	return nil
+}
+
 // needsDefaultScale indicates whether a release needs a default scale based on
 // whether it has a web process type and either has no previous release or no
 // previous scale.
@@ -0,0 +1,156 @@
+// Package reviewapp manages review apps, which are temporary apps created
+// from a parent app to run branches other than master. They get their own
+// default route, and are deleted when the branch is deleted or once they
+// expire (a while after the branch was last pushed).
+package reviewapp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/utils"
+)
+
+// TTLMetaKey is the parent app meta key which sets how long review apps are
+// kept after their branch was last pushed, as a Go duration
+const TTLMetaKey = "review.ttl"
+
+// DefaultTTL is used if the parent app doesn't set a TTL
+const DefaultTTL = 72 * time.Hour
+
+// TTL returns how long the parent app's review apps are kept after their
+// branch was last pushed
+func TTL(parent *ct.App) (time.Duration, error) {
+	v, ok := parent.Meta[TTLMetaKey]
+	if !ok {
+		return DefaultTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid %s for app %s: %q", TTLMetaKey, parent.Name, v)
+	}
+	return ttl, nil
+}
+
+// Get returns the review app for the branch of the parent app, creating it
+// if it doesn't exist and otherwise extending its expiry
+func Get(client controller.Client, parent *ct.App, branch string) (*ct.App, error) {
+	ttl, err := TTL(parent)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(ttl).UTC().Format(time.RFC3339)
+
+	app, err := lookup(client, parent, branch)
+	if err == controller.ErrNotFound {
+		app = &ct.App{
+			Name: utils.ReviewAppName(parent.Name, branch),
+			Meta: map[string]string{
+				ct.ReviewParentMetaKey:    parent.ID,
+				ct.ReviewBranchMetaKey:    branch,
+				ct.ReviewExpiresAtMetaKey: expiresAt,
+			},
+			Strategy:      parent.Strategy,
+			DeployTimeout: parent.DeployTimeout,
+		}
+		return app, client.CreateApp(app)
+	} else if err != nil {
+		return nil, err
+	}
+	app.Meta[ct.ReviewExpiresAtMetaKey] = expiresAt
+	return app, client.UpdateAppMeta(app)
+}
+
+// Delete deletes the review app for the branch of the parent app, returning
+// controller.ErrNotFound if there isn't one
+func Delete(client controller.Client, parent *ct.App, branch string) (*ct.App, error) {
+	app, err := lookup(client, parent, branch)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.DeleteApp(app.ID); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+func lookup(client controller.Client, parent *ct.App, branch string) (*ct.App, error) {
+	name := utils.ReviewAppName(parent.Name, branch)
+	app, err := client.GetApp(name)
+	if err != nil {
+		return nil, err
+	}
+	if app.ReviewParent() != parent.ID || app.Meta[ct.ReviewBranchMetaKey] != branch {
+		return nil, fmt.Errorf("app %s already exists and is not the review app for branch %s of %s", name, branch, parent.Name)
+	}
+	return app, nil
+}
+
+// BaseRelease returns the release which the review app's next release should
+// be based on, which is its current release or, if it doesn't have one yet, a
+// copy of the parent app's release. Copies get their own resources from the
+// same providers as the parent app's, with the resources' env replacing that
+// of the parent's resources.
+func BaseRelease(client controller.Client, parent, app *ct.App) (*ct.Release, error) {
+	release, err := client.GetAppRelease(app.ID)
+	if err == nil {
+		return release, nil
+	} else if err != controller.ErrNotFound {
+		return nil, err
+	}
+
+	parentRelease, err := client.GetAppRelease(parent.ID)
+	if err == controller.ErrNotFound {
+		return &ct.Release{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	release = &ct.Release{
+		Env:       make(map[string]string, len(parentRelease.Env)),
+		Meta:      make(map[string]string, len(parentRelease.Meta)),
+		Processes: parentRelease.Processes,
+	}
+	for k, v := range parentRelease.Env {
+		release.Env[k] = v
+	}
+	for k, v := range parentRelease.Meta {
+		release.Meta[k] = v
+	}
+
+	parentResources, err := client.AppResourceList(parent.ID)
+	if err != nil {
+		return nil, err
+	}
+	// resources provisioned by a previous build which failed before
+	// creating a release are reused
+	resources, err := client.AppResourceList(app.ID)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*ct.Resource, len(resources))
+	for _, r := range resources {
+		existing[r.ProviderID] = r
+	}
+	for _, parentResource := range parentResources {
+		for k := range parentResource.Env {
+			delete(release.Env, k)
+		}
+		resource, ok := existing[parentResource.ProviderID]
+		if !ok {
+			resource, err = client.ProvisionResource(&ct.ResourceReq{
+				ProviderID: parentResource.ProviderID,
+				Apps:       []string{app.ID},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error provisioning resource: %s", err)
+			}
+			existing[resource.ProviderID] = resource
+		}
+		for k, v := range resource.Env {
+			release.Env[k] = v
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,139 @@
+package reviewapp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	. "github.com/flynn/go-check"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct{}
+
+var _ = Suite(&S{})
+
+// fakeController stores apps in memory, panicking if anything other than
+// the app methods used by review apps is called
+type fakeController struct {
+	controller.Client
+	apps map[string]*ct.App
+}
+
+func (f *fakeController) GetApp(name string) (*ct.App, error) {
+	app, ok := f.apps[name]
+	if !ok {
+		return nil, controller.ErrNotFound
+	}
+	return app, nil
+}
+
+func (f *fakeController) CreateApp(app *ct.App) error {
+	app.ID = app.Name + "-id"
+	f.apps[app.Name] = app
+	return nil
+}
+
+func (f *fakeController) UpdateAppMeta(app *ct.App) error {
+	f.apps[app.Name] = app
+	return nil
+}
+
+func (f *fakeController) DeleteApp(id string) (*ct.AppDeletion, error) {
+	for name, app := range f.apps {
+		if app.ID == id {
+			delete(f.apps, name)
+		}
+	}
+	return &ct.AppDeletion{AppID: id}, nil
+}
+
+func (S) TestTTL(c *C) {
+	for _, x := range []struct {
+		meta map[string]string
+		ttl  time.Duration
+		err  bool
+	}{
+		{nil, DefaultTTL, false},
+		{map[string]string{TTLMetaKey: "24h"}, 24 * time.Hour, false},
+		{map[string]string{TTLMetaKey: "90m"}, 90 * time.Minute, false},
+		{map[string]string{TTLMetaKey: "1 day"}, 0, true},
+		{map[string]string{TTLMetaKey: "-1h"}, 0, true},
+		{map[string]string{TTLMetaKey: "0"}, 0, true},
+	} {
+		ttl, err := TTL(&ct.App{Name: "parent", Meta: x.meta})
+		if x.err {
+			c.Assert(err, NotNil)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(ttl, Equals, x.ttl)
+	}
+}
+
+func (S) TestGet(c *C) {
+	client := &fakeController{apps: make(map[string]*ct.App)}
+	parent := &ct.App{
+		ID:            "parent-id",
+		Name:          "parent",
+		Meta:          map[string]string{TTLMetaKey: "1h"},
+		Strategy:      "one-by-one",
+		DeployTimeout: 60,
+	}
+
+	// the review app is created with a name based on the branch, and
+	// expires after the TTL
+	start := time.Now().Truncate(time.Second)
+	app, err := Get(client, parent, "Feature/Login")
+	c.Assert(err, IsNil)
+	c.Assert(app.Name, Equals, "parent-feature-login")
+	c.Assert(app.ReviewParent(), Equals, parent.ID)
+	c.Assert(app.Meta[ct.ReviewBranchMetaKey], Equals, "Feature/Login")
+	c.Assert(app.Strategy, Equals, parent.Strategy)
+	c.Assert(app.DeployTimeout, Equals, parent.DeployTimeout)
+	expiresAt := app.ReviewExpiresAt()
+	c.Assert(expiresAt, NotNil)
+	c.Assert(expiresAt.Before(start.Add(time.Hour)), Equals, false)
+	c.Assert(expiresAt.After(time.Now().Add(time.Hour)), Equals, false)
+
+	// pushing the branch again extends the expiry of the same app
+	parent.Meta[TTLMetaKey] = "2h"
+	extended, err := Get(client, parent, "Feature/Login")
+	c.Assert(err, IsNil)
+	c.Assert(extended.ID, Equals, app.ID)
+	c.Assert(extended.ReviewExpiresAt().Sub(*expiresAt) >= time.Hour, Equals, true)
+
+	// apps which aren't the branch's review app are left alone, even if
+	// they have its name
+	client.apps["parent-other"] = &ct.App{ID: "other-id", Name: "parent-other"}
+	_, err = Get(client, parent, "other")
+	c.Assert(err, NotNil)
+	_, err = Get(client, parent, "feature-login")
+	c.Assert(err, NotNil)
+	_, err = Delete(client, parent, "other")
+	c.Assert(err, NotNil)
+	c.Assert(client.apps["parent-other"], NotNil)
+
+	// an invalid TTL doesn't create an app
+	parent.Meta[TTLMetaKey] = "forever"
+	_, err = Get(client, parent, "invalid-ttl")
+	c.Assert(err, NotNil)
+	c.Assert(client.apps["parent-invalid-ttl"], IsNil)
+}
+
+func (S) TestDelete(c *C) {
+	client := &fakeController{apps: make(map[string]*ct.App)}
+	parent := &ct.App{ID: "parent-id", Name: "parent"}
+	app, err := Get(client, parent, "feature")
+	c.Assert(err, IsNil)
+
+	deleted, err := Delete(client, parent, "feature")
+	c.Assert(err, IsNil)
+	c.Assert(deleted.ID, Equals, app.ID)
+	c.Assert(client.apps, HasLen, 0)
+
+	_, err = Delete(client, parent, "feature")
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
@@ -25,9 +25,11 @@ import (
 	"syscall"
 
 	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
 	"github.com/flynn/flynn/pkg/archiver"
 	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/dialer"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/status"
 )
@@ -47,6 +49,11 @@ func main() {
 type gitHandler struct {
 	controller controller.Client
 	authKey    []byte
+	queue      *buildQueue
+
+	// authenticateUser returns the user a key other than the cluster key
+	// authenticates as, which is a user token from "flynn login"
+	authenticateUser func(key string) (*ct.User, error)
 }
 
 type gitService struct {
@@ -67,8 +74,16 @@ var gitServices = [...]gitService{
 	{"POST", "/git-receive-pack", handlePostRPC, "git-receive-pack"},
 }
 
-func newGitHandler(controller controller.Client, authKey []byte) *gitHandler {
-	return &gitHandler{controller, authKey}
+func newGitHandler(client controller.Client, authKey []byte) *gitHandler {
+	httpClient := &http.Client{Transport: &http.Transport{Dial: dialer.Retry.Dial}}
+	return &gitHandler{
+		controller: client,
+		authKey:    authKey,
+		queue:      newBuildQueue(),
+		authenticateUser: func(key string) (*ct.User, error) {
+			return controller.AuthenticateUser("", key, httpClient)
+		},
+	}
 }
 
 func (h *gitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -79,6 +94,26 @@ func (h *gitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// builds started by the pre-receive hook wait for a slot in the queue
+	if r.URL.Path == "/builds/queue" && r.Method == "POST" {
+		if !h.authenticate(w, r) {
+			return
+		}
+		h.queue.ServeHTTP(w, r)
+		return
+	}
+
+	// webhooks are authenticated by their signature rather than the key
+	if r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/webhooks/") {
+		name := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+		if !utils.AppNamePattern.MatchString(name) {
+			http.Error(w, "Not Found", 404)
+			return
+		}
+		h.serveWebhook(w, r, name)
+		return
+	}
+
 	// Look for a matching Git service
 	foundService := false
 	for _, g = range gitServices {
@@ -95,10 +130,8 @@ func (h *gitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, password, _ := r.BasicAuth()
-	if !hmac.Equal([]byte(password), h.authKey) {
-		w.Header().Set("WWW-Authenticate", "Basic")
-		http.Error(w, "Authentication required", 401)
+	user, ok := h.authenticateRequest(w, r)
+	if !ok {
 		return
 	}
 
@@ -112,6 +145,13 @@ func (h *gitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// pushing starts with a GET of the refs for git-receive-pack
+	push := g.rpc == "git-receive-pack" || r.URL.Query().Get("service") == "git-receive-pack"
+	if push && !user.CanDeployApp(app.ID) || !push && !user.CanReadApp(app.ID) {
+		http.Error(w, "Forbidden", 403)
+		return
+	}
+
 	repoPath, err := prepareRepo(app.ID)
 	if err != nil {
 		fail500(w, "prepareRepo", err)
@@ -129,6 +169,45 @@ func (h *gitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	g.handleFunc(gitEnv{App: app.ID}, g.rpc, repoPath, w, r)
 }
 
+// authenticate returns whether the request is authenticated with the cluster
+// key, which only gitreceive's own jobs use for internal requests
+func (h *gitHandler) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	_, password, _ := r.BasicAuth()
+	if !hmac.Equal([]byte(password), h.authKey) {
+		authenticationRequired(w)
+		return false
+	}
+	return true
+}
+
+// authenticateRequest returns the user a Git request is authenticated as,
+// which is an admin for the cluster key, or the user a user token belongs to
+// as determined by the controller
+func (h *gitHandler) authenticateRequest(w http.ResponseWriter, r *http.Request) (*ct.User, bool) {
+	_, password, _ := r.BasicAuth()
+	if password == "" {
+		authenticationRequired(w)
+		return nil, false
+	}
+	if hmac.Equal([]byte(password), h.authKey) {
+		return &ct.User{Role: ct.RoleAdmin}, true
+	}
+	user, err := h.authenticateUser(password)
+	if err == controller.ErrUnauthorized {
+		authenticationRequired(w)
+		return nil, false
+	} else if err != nil {
+		fail500(w, "authenticateUser", err)
+		return nil, false
+	}
+	return user, true
+}
+
+func authenticationRequired(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Basic")
+	http.Error(w, "Authentication required", 401)
+}
+
 func handleGetInfoRefs(env gitEnv, _ string, path string, w http.ResponseWriter, r *http.Request) {
 	rpc := r.URL.Query().Get("service")
 	if !(rpc == "git-upload-pack" || rpc == "git-receive-pack") {
@@ -295,24 +374,31 @@ func (w writeFlusher) Write(p []byte) (int, error) {
 var prereceiveHook = []byte(`#!/bin/bash
 set -eo pipefail;
 
-git-archive-all() {
+# runs in a subshell so that each branch is archived from the git dir
+git-archive-all() (
 	GIT_DIR="$(pwd)"
 	cd ..
 	git checkout --force --quiet $1
 	git submodule --quiet update --init --recursive
 	tar --create --exclude-vcs .
-}
+)
 
 while read oldrev newrev refname; do
-	if [[ $refname = "refs/heads/master" ]]; then
+	[[ $refname = refs/heads/* ]] || continue
+	branch="${refname#refs/heads/}"
+	if [[ $branch = "master" ]]; then
 		git-archive-all $newrev | /bin/flynn-receiver "$RECEIVE_APP" "$newrev" --meta git=true | sed -u "s/^/"$'\e[1G\e[K'"/"
-		master_pushed=1
-		break
+	elif [[ $newrev = 0000000000000000000000000000000000000000 ]]; then
+		# the branch was deleted, so delete its review app
+		/bin/flynn-receiver "$RECEIVE_APP" "$oldrev" --branch "$branch" --delete | sed -u "s/^/"$'\e[1G\e[K'"/"
+	else
+		git-archive-all $newrev | /bin/flynn-receiver "$RECEIVE_APP" "$newrev" --branch "$branch" --meta git=true --meta "branch=$branch" | sed -u "s/^/"$'\e[1G\e[K'"/"
 	fi
+	branch_pushed=1
 done
 
-if [[ -z "${master_pushed}" ]]; then
-  echo "The push must include a change to the master branch to be deployed."
+if [[ -z "${branch_pushed}" ]]; then
+  echo "The push must include a change to a branch to be deployed."
   exit 1
 fi
 `)
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	. "github.com/flynn/go-check"
+)
+
+type ServerSuite struct{}
+
+var _ = Suite(&ServerSuite{})
+
+var testOtherApp = &ct.App{ID: "00000000-0000-0000-0000-000000000003", Name: "other"}
+
+// newTestGitHandler returns a handler which authenticates the given user
+// tokens, as the controller would
+func newTestGitHandler(tokens map[string]*ct.User) *gitHandler {
+	return &gitHandler{
+		controller: newFakeController("", testParent, testOtherApp),
+		authKey:    []byte("cluster-key"),
+		authenticateUser: func(key string) (*ct.User, error) {
+			user, ok := tokens[key]
+			if !ok {
+				return nil, controller.ErrUnauthorized
+			}
+			return user, nil
+		},
+	}
+}
+
+func (ServerSuite) TestAuthenticateRequest(c *C) {
+	deployer := &ct.User{ID: "deployer", Role: ct.RoleDeployer, Apps: []string{testParent.ID}}
+	h := newTestGitHandler(map[string]*ct.User{"deployer-token": deployer})
+
+	authenticate := func(key string) (*ct.User, int) {
+		r, err := http.NewRequest("GET", "/example.git/info/refs?service=git-upload-pack", nil)
+		c.Assert(err, IsNil)
+		if key != "" {
+			r.SetBasicAuth("user", key)
+		}
+		w := httptest.NewRecorder()
+		user, _ := h.authenticateRequest(w, r)
+		return user, w.Code
+	}
+
+	// the cluster key authenticates as an admin
+	user, _ := authenticate("cluster-key")
+	c.Assert(user, NotNil)
+	c.Assert(user.Role, Equals, ct.RoleAdmin)
+
+	// user tokens authenticate as their user
+	user, _ = authenticate("deployer-token")
+	c.Assert(user, Equals, deployer)
+
+	// requests without a key or with an unknown key are rejected
+	user, code := authenticate("")
+	c.Assert(user, IsNil)
+	c.Assert(code, Equals, 401)
+	user, code = authenticate("invalid-token")
+	c.Assert(user, IsNil)
+	c.Assert(code, Equals, 401)
+}
+
+func (ServerSuite) TestAppAccess(c *C) {
+	h := newTestGitHandler(map[string]*ct.User{
+		"deployer-token":  {ID: "deployer", Role: ct.RoleDeployer, Apps: []string{testParent.ID}},
+		"read-only-token": {ID: "read-only", Role: ct.RoleReadOnly},
+	})
+
+	for _, t := range []struct {
+		desc   string
+		key    string
+		method string
+		path   string
+		code   int
+	}{
+		{"unknown token", "invalid-token", "GET", "/example.git/info/refs?service=git-receive-pack", 401},
+		{"deployer pushing another app", "deployer-token", "GET", "/other.git/info/refs?service=git-receive-pack", 403},
+		{"deployer sending a pack to another app", "deployer-token", "POST", "/other.git/git-receive-pack", 403},
+		{"deployer fetching another app", "deployer-token", "GET", "/other.git/info/refs?service=git-upload-pack", 403},
+		{"read-only user pushing", "read-only-token", "GET", "/example.git/info/refs?service=git-receive-pack", 403},
+		{"read-only user sending a pack", "read-only-token", "POST", "/example.git/git-receive-pack", 403},
+	} {
+		r, err := http.NewRequest(t.method, t.path, nil)
+		c.Assert(err, IsNil)
+		r.SetBasicAuth("user", t.key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		c.Assert(w.Code, Equals, t.code, Commentf(t.desc))
+	}
+
+	// the build queue is only for gitreceive's own jobs, which use the
+	// cluster key
+	r, err := http.NewRequest("POST", "/builds/queue", nil)
+	c.Assert(err, IsNil)
+	r.SetBasicAuth("user", "deployer-token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, 401)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/gitreceive/reviewapp"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+const maxWebhookPayloadSize = 5 * 1024 * 1024
+
+// pushEvent is the payload of a webhook, which has the same fields as a
+// GitHub push event so that GitHub can call webhooks directly
+type pushEvent struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		CloneURL      string `json:"clone_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+}
+
+// webhookResponse describes the action taken in response to a webhook
+type webhookResponse struct {
+	Action string `json:"action"`
+	App    string `json:"app,omitempty"`
+	JobID  string `json:"job_id,omitempty"`
+}
+
+// serveWebhook handles a push to an app's repo by deploying the pushed
+// branch with a taffy job, either to the app itself if it is the default
+// branch or otherwise to the branch's review app, or by deleting the review
+// app of a deleted branch. Payloads are signed with the app's webhook secret
+// like GitHub's, using an HMAC of the body in the X-Hub-Signature-256
+// (SHA256) or X-Hub-Signature (SHA1) header, which the controller verifies as
+// it doesn't expose the secret.
+func (h *gitHandler) serveWebhook(w http.ResponseWriter, r *http.Request, name string) {
+	app, err := h.controller.GetApp(name)
+	if err == controller.ErrNotFound {
+		http.Error(w, "unknown app", 404)
+		return
+	} else if err != nil {
+		fail500(w, "getApp", err)
+		return
+	}
+	signature := webhookSignature(r.Header)
+	if signature == "" {
+		http.Error(w, "missing signature", 401)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookPayloadSize))
+	if err != nil {
+		fail500(w, "serveWebhook read body", err)
+		return
+	}
+	sig := &ct.WebhookSignature{Payload: body, Signature: signature}
+	if err := h.controller.VerifyWebhookSignature(app.ID, sig); err == controller.ErrNotFound {
+		http.Error(w, "webhooks are not enabled for this app", 404)
+		return
+	} else if err != nil {
+		fail500(w, "verifyWebhookSignature", err)
+		return
+	}
+	if !sig.Valid {
+		http.Error(w, "invalid signature", 401)
+		return
+	}
+
+	switch r.Header.Get("X-GitHub-Event") {
+	case "", "push":
+	default:
+		// e.g. the ping event sent when the webhook is created
+		httphelper.JSON(w, 200, &webhookResponse{Action: "none"})
+		return
+	}
+	var event pushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid payload", 400)
+		return
+	}
+	if !strings.HasPrefix(event.Ref, "refs/heads/") {
+		httphelper.JSON(w, 200, &webhookResponse{Action: "none"})
+		return
+	}
+	branch := strings.TrimPrefix(event.Ref, "refs/heads/")
+	defaultBranch := event.Repository.DefaultBranch
+	if defaultBranch == "" {
+		defaultBranch = "master"
+	}
+	review := branch != defaultBranch
+
+	if event.Deleted {
+		if !review {
+			httphelper.JSON(w, 200, &webhookResponse{Action: "none"})
+			return
+		}
+		reviewApp, err := reviewapp.Delete(h.controller, app, branch)
+		if err == controller.ErrNotFound {
+			httphelper.JSON(w, 200, &webhookResponse{Action: "none"})
+			return
+		} else if err != nil {
+			fail500(w, "deleteReviewApp", err)
+			return
+		}
+		httphelper.JSON(w, 200, &webhookResponse{Action: "delete", App: reviewApp.Name})
+		return
+	}
+
+	if event.After == "" || event.Repository.CloneURL == "" {
+		http.Error(w, "payload must include after and repository.clone_url", 400)
+		return
+	}
+	job, err := h.runTaffy(app, event.Repository.CloneURL, branch, event.After, review)
+	if err != nil {
+		fail500(w, "runTaffy", err)
+		return
+	}
+	httphelper.JSON(w, 202, &webhookResponse{Action: "deploy", App: app.Name, JobID: job.ID})
+}
+
+// webhookSignature returns the signature of a webhook payload, preferring the
+// SHA256 HMAC in X-Hub-Signature-256 to the SHA1 one in X-Hub-Signature
+func webhookSignature(header http.Header) string {
+	if v := header.Get("X-Hub-Signature-256"); v != "" {
+		return v
+	}
+	return header.Get("X-Hub-Signature")
+}
+
+// runTaffy starts a taffy job which deploys the rev of the branch
+func (h *gitHandler) runTaffy(app *ct.App, cloneURL, branch, rev string, review bool) (*ct.Job, error) {
+	release, err := h.controller.GetAppRelease("taffy")
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"/bin/taffy", app.Name, cloneURL, branch, rev}
+	if review {
+		args = append(args, "--review")
+	}
+	return h.controller.RunJobDetached("taffy", &ct.NewJob{
+		ReleaseID:  release.ID,
+		ReleaseEnv: true,
+		Args:       args,
+		Meta: map[string]string{
+			"webhook":   "true",
+			"branch":    branch,
+			"rev":       rev,
+			"clone_url": cloneURL,
+			"app":       app.ID,
+		},
+	})
+}
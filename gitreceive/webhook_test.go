@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/utils"
+	. "github.com/flynn/go-check"
+)
+
+type WebhookSuite struct{}
+
+var _ = Suite(&WebhookSuite{})
+
+// fakeController implements the parts of the controller API used by
+// webhooks, panicking if anything else is called
+type fakeController struct {
+	controller.Client
+	apps    map[string]*ct.App
+	secret  string
+	deleted []string
+	jobs    []*ct.NewJob
+}
+
+func newFakeController(secret string, apps ...*ct.App) *fakeController {
+	f := &fakeController{apps: make(map[string]*ct.App), secret: secret}
+	for _, app := range apps {
+		f.apps[app.ID] = app
+		f.apps[app.Name] = app
+	}
+	return f
+}
+
+func (f *fakeController) GetApp(id string) (*ct.App, error) {
+	app, ok := f.apps[id]
+	if !ok {
+		return nil, controller.ErrNotFound
+	}
+	return app, nil
+}
+
+func (f *fakeController) VerifyWebhookSignature(appID string, sig *ct.WebhookSignature) error {
+	if f.secret == "" {
+		return controller.ErrNotFound
+	}
+	sig.Valid = utils.ValidWebhookSignature(sig.Signature, sig.Payload, []byte(f.secret))
+	return nil
+}
+
+func (f *fakeController) DeleteApp(id string) (*ct.AppDeletion, error) {
+	f.deleted = append(f.deleted, id)
+	return &ct.AppDeletion{AppID: id}, nil
+}
+
+func (f *fakeController) GetAppRelease(appID string) (*ct.Release, error) {
+	return &ct.Release{ID: appID + "-release"}, nil
+}
+
+func (f *fakeController) RunJobDetached(appID string, job *ct.NewJob) (*ct.Job, error) {
+	f.jobs = append(f.jobs, job)
+	return &ct.Job{ID: "host-job"}, nil
+}
+
+var testParent = &ct.App{ID: "00000000-0000-0000-0000-000000000001", Name: "example"}
+
+var testReviewApp = &ct.App{
+	ID:   "00000000-0000-0000-0000-000000000002",
+	Name: "example-feature-login",
+	Meta: map[string]string{
+		ct.ReviewParentMetaKey: testParent.ID,
+		ct.ReviewBranchMetaKey: "feature/login",
+	},
+}
+
+type webhookRequest struct {
+	event     string
+	payload   interface{}
+	secret    string
+	signature string
+}
+
+func serveTestWebhook(c *C, client controller.Client, req *webhookRequest) (*httptest.ResponseRecorder, *webhookResponse) {
+	body, err := json.Marshal(req.payload)
+	c.Assert(err, IsNil)
+	r, err := http.NewRequest("POST", "/webhooks/"+testParent.Name, bytes.NewReader(body))
+	c.Assert(err, IsNil)
+	if req.event != "" {
+		r.Header.Set("X-GitHub-Event", req.event)
+	}
+	if req.secret != "" {
+		mac := hmac.New(sha256.New, []byte(req.secret))
+		mac.Write(body)
+		req.signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	if req.signature != "" {
+		r.Header.Set("X-Hub-Signature-256", req.signature)
+	}
+	w := httptest.NewRecorder()
+	h := &gitHandler{controller: client}
+	h.serveWebhook(w, r, testParent.Name)
+	if w.Code >= 300 {
+		return w, nil
+	}
+	res := &webhookResponse{}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), res), IsNil)
+	return w, res
+}
+
+func deletePayload(branch string) *pushEvent {
+	e := &pushEvent{Ref: "refs/heads/" + branch, Deleted: true}
+	e.Repository.DefaultBranch = "master"
+	return e
+}
+
+func (WebhookSuite) TestSignature(c *C) {
+	client := newFakeController("secret", testParent)
+	for _, x := range []struct {
+		req  *webhookRequest
+		code int
+	}{
+		{&webhookRequest{event: "ping", secret: "secret"}, 200},
+		{&webhookRequest{event: "ping", secret: "other"}, 401},
+		{&webhookRequest{event: "ping", signature: "sha256=invalid"}, 401},
+		{&webhookRequest{event: "ping"}, 401},
+	} {
+		w, _ := serveTestWebhook(c, client, x.req)
+		c.Assert(w.Code, Equals, x.code)
+	}
+
+	// webhooks are disabled for apps without a secret
+	w, _ := serveTestWebhook(c, newFakeController("", testParent), &webhookRequest{event: "ping", secret: "secret"})
+	c.Assert(w.Code, Equals, 404)
+}
+
+func (WebhookSuite) TestPing(c *C) {
+	client := newFakeController("secret", testParent)
+	w, res := serveTestWebhook(c, client, &webhookRequest{
+		event:   "ping",
+		payload: map[string]string{"zen": "Design for failure."},
+		secret:  "secret",
+	})
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(res.Action, Equals, "none")
+	c.Assert(client.jobs, HasLen, 0)
+	c.Assert(client.deleted, HasLen, 0)
+}
+
+func (WebhookSuite) TestDeleteBranch(c *C) {
+	client := newFakeController("secret", testParent, testReviewApp)
+
+	// deleting a branch deletes its review app
+	w, res := serveTestWebhook(c, client, &webhookRequest{
+		event:   "push",
+		payload: deletePayload("feature/login"),
+		secret:  "secret",
+	})
+	c.Assert(w.Code, Equals, 200)
+	c.Assert(res.Action, Equals, "delete")
+	c.Assert(res.App, Equals, testReviewApp.Name)
+	c.Assert(client.deleted, DeepEquals, []string{testReviewApp.ID})
+
+	// branches without a review app and the default branch are ignored
+	for _, branch := range []string{"feature/other", "master"} {
+		w, res := serveTestWebhook(c, client, &webhookRequest{
+			event:   "push",
+			payload: deletePayload(branch),
+			secret:  "secret",
+		})
+		c.Assert(w.Code, Equals, 200)
+		c.Assert(res.Action, Equals, "none")
+	}
+	c.Assert(client.deleted, HasLen, 1)
+	c.Assert(client.jobs, HasLen, 0)
+}
+
+func (WebhookSuite) TestPush(c *C) {
+	client := newFakeController("secret", testParent)
+	payload := &pushEvent{Ref: "refs/heads/feature/login", After: "3a1d6c46"}
+	payload.Repository.CloneURL = "https://github.com/flynn/example.git"
+	w, res := serveTestWebhook(c, client, &webhookRequest{payload: payload, secret: "secret"})
+	c.Assert(w.Code, Equals, 202)
+	c.Assert(res.Action, Equals, "deploy")
+	c.Assert(res.JobID, Equals, "host-job")
+	c.Assert(client.jobs, HasLen, 1)
+	c.Assert(client.jobs[0].Args, DeepEquals, []string{
+		"/bin/taffy", testParent.Name, payload.Repository.CloneURL, "feature/login", payload.After, "--review",
+	})
+}
@@ -250,6 +250,7 @@ $function$;
 			if step.ID == "gitreceive" {
 				artifactURIs["slugbuilder"] = step.Release.Env["SLUGBUILDER_IMAGE_URI"]
 				artifactURIs["slugrunner"] = step.Release.Env["SLUGRUNNER_IMAGE_URI"]
+				artifactURIs["dockerbuilder"] = step.Release.Env["DOCKERBUILDER_IMAGE_URI"]
 			}
 			// update current artifact in database for service, taking care to
 			// check the database version as migration 15 changed the way
@@ -301,7 +302,7 @@ WHERE uri = (SELECT env->>'SLUGRUNNER_IMAGE_URI' FROM releases WHERE release_id
 		artifactURIs["slugrunner"]))
 
 	for _, app := range []string{"gitreceive", "taffy"} {
-		for _, env := range []string{"slugbuilder", "slugrunner"} {
+		for _, env := range []string{"slugbuilder", "slugrunner", "dockerbuilder"} {
 			sqlBuf.WriteString(fmt.Sprintf(`
 UPDATE releases SET env = pg_temp.json_object_update_key(env, '%s_IMAGE_URI', '%s')
 WHERE release_id = (SELECT release_id from apps WHERE name = '%s');`,
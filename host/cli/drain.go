@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("drain", runDrain, `
+usage: flynn-host drain [--data] [--no-wait] <hostid>
+       flynn-host drain --cancel <hostid>
+
+Drain a host of its jobs so that it can be rebooted or removed from the
+cluster.
+
+The scheduler stops placing jobs on the host and moves its jobs to other
+hosts one at a time, only stopping each job once its replacement is up (and
+registered with service discovery if its process type has a service). Omni
+jobs, which run on every host, are left running.
+
+Jobs with data volumes are only moved if --data is given, in which case their
+volumes are copied to the replacement's host whilst they are running, then
+they are stopped briefly whilst the final changes are copied before their
+replacement is started. Otherwise they are left running and the host is not
+considered drained until they are stopped.
+
+Options:
+	--data      also move jobs with data volumes
+	--no-wait   don't wait for the host to be drained
+	--cancel    stop draining the host so that jobs are placed on it again
+`)
+}
+
+func runDrain(args *docopt.Args, client *cluster.Client) error {
+	id := args.String["<hostid>"]
+	h, err := client.Host(id)
+	if err != nil {
+		return err
+	}
+
+	if args.Bool["--cancel"] {
+		if err := h.Undrain(); err != nil {
+			return err
+		}
+		fmt.Printf("host %s is no longer draining\n", id)
+		return nil
+	}
+
+	if err := h.Drain(args.Bool["--data"]); err != nil {
+		return err
+	}
+	fmt.Printf("draining host %s\n", id)
+	if args.Bool["--no-wait"] {
+		return nil
+	}
+
+	var prev []string
+	for {
+		status, err := h.GetStatus()
+		if err != nil {
+			return err
+		}
+		if status.Drain == nil {
+			return errors.New("host is no longer draining")
+		}
+		if status.Drain.Drained {
+			fmt.Printf("host %s is drained, it is safe to reboot or remove it\n", id)
+			return nil
+		}
+
+		// print the remaining jobs whenever they change
+		jobs, err := h.ListJobs()
+		if err != nil {
+			return err
+		}
+		remaining := make([]string, 0, len(jobs))
+		for _, job := range jobs {
+			if job.Status == host.StatusStarting || job.Status == host.StatusRunning {
+				remaining = append(remaining, job.Job.ID)
+			}
+		}
+		sort.Strings(remaining)
+		if !stringsEqual(remaining, prev) {
+			fmt.Printf("waiting for %d job(s) to be moved (omni jobs are left running):\n", len(remaining))
+			w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+			listRec(w, "ID", "APP", "TYPE")
+			for _, id := range remaining {
+				job := jobs[id]
+				listRec(w, id, job.Job.Metadata["flynn-controller.app_name"], job.Job.Metadata["flynn-controller.type"])
+			}
+			w.Flush()
+			prev = remaining
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
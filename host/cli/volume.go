@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flynn/flynn/host/volume"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("volume", runVolume, `
+usage: flynn-host volume snapshot-policy <hostid> <volumeid>
+       flynn-host volume snapshot-policy set [--url=<url>] <hostid> <volumeid> <interval> <retention>
+       flynn-host volume snapshot-policy delete <hostid> <volumeid>
+       flynn-host volume restore [--provider=<id>] [--snapshot=<id>] <hostid> <url>
+
+Manage volume snapshots which are uploaded to the blobstore.
+
+Commands:
+	snapshot-policy         show the snapshot policy of a volume
+	snapshot-policy set     snapshot a volume every <interval> (e.g. 1h), keeping
+	                        <retention> snapshots in the blobstore
+	snapshot-policy delete  stop snapshotting a volume
+	restore                 create a new volume on a host from snapshots in the
+	                        blobstore, printing its ID
+
+Options:
+	--url=<url>        blobstore directory to upload snapshots to (defaults to
+	                   http://blobstore.discoverd/volume-snapshots/<volumeid>)
+	--provider=<id>    volume provider to create the volume with [default: default]
+	--snapshot=<id>    snapshot to restore (defaults to the latest)
+
+Examples:
+
+	$ flynn-host volume snapshot-policy set host1 a6a2a23c-d37e-4c3c-8b49-a7d9e6ee1d29 1h 24
+
+	$ flynn-host volume restore host2 http://blobstore.discoverd/volume-snapshots/a6a2a23c-d37e-4c3c-8b49-a7d9e6ee1d29
+	bf3ae0a1-9bc5-4fd6-9d0a-7b2c2c0d79c4
+`)
+}
+
+func runVolume(args *docopt.Args, client *cluster.Client) error {
+	h, err := client.Host(args.String["<hostid>"])
+	if err != nil {
+		return err
+	}
+	if args.Bool["restore"] {
+		return runVolumeRestore(args, h)
+	}
+
+	volumeID := args.String["<volumeid>"]
+	switch {
+	case args.Bool["set"]:
+		interval, err := time.ParseDuration(args.String["<interval>"])
+		if err != nil {
+			return fmt.Errorf("invalid interval: %s", err)
+		}
+		retention, err := strconv.Atoi(args.String["<retention>"])
+		if err != nil {
+			return fmt.Errorf("invalid retention: %s", err)
+		}
+		return h.SetSnapshotPolicy(volumeID, &volume.SnapshotPolicy{
+			Interval:  interval,
+			Retention: retention,
+			URL:       args.String["--url"],
+		})
+	case args.Bool["delete"]:
+		return h.DeleteSnapshotPolicy(volumeID)
+	}
+
+	policy, err := h.GetSnapshotPolicy(volumeID)
+	if err == cluster.ErrNotFound {
+		fmt.Println("no snapshot policy")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	url := policy.URL
+	if url == "" {
+		url = volume.DefaultSnapshotURL(volumeID)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "Interval:", policy.Interval)
+	listRec(w, "Retention:", policy.Retention)
+	listRec(w, "URL:", url)
+	return nil
+}
+
+func runVolumeRestore(args *docopt.Args, h *cluster.Host) error {
+	vol, err := h.RestoreVolume(args.String["--provider"], &volume.RestoreRequest{
+		URL:        args.String["<url>"],
+		SnapshotID: args.String["--snapshot"],
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(vol.ID)
+	return nil
+}
@@ -28,6 +28,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime/pprof"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -378,6 +379,38 @@ func setupCommon(c *Config, log log15.Logger) error {
 	return nil
 }
 
+// lookPath finds the executable named by file in the directories of the
+// given PATH, rather than containerinit's own PATH, with paths containing a
+// slash being relative to dir
+func lookPath(file, envPath, dir string) (string, error) {
+	isExecutable := func(p string) bool {
+		info, err := os.Stat(p)
+		return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+	}
+	if strings.Contains(file, "/") {
+		p := file
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		if isExecutable(p) {
+			return p, nil
+		}
+		return "", fmt.Errorf("%s is not an executable file", file)
+	}
+	for _, d := range filepath.SplitList(envPath) {
+		if d == "" {
+			d = "."
+		}
+		if !filepath.IsAbs(d) {
+			d = filepath.Join(dir, d)
+		}
+		if p := filepath.Join(d, file); isExecutable(p) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in PATH %q", file, envPath)
+}
+
 func getCmdPath(c *Config) (string, error) {
 	// Set PATH in containerinit so we can find the cmd
 	if envPath := c.Env["PATH"]; envPath != "" {
@@ -398,8 +431,9 @@ func getCmdPath(c *Config) (string, error) {
 	return cmdPath, nil
 }
 
-func monitor(port host.Port, container *ContainerInit, env map[string]string, log log15.Logger) (discoverd.Heartbeater, error) {
+func monitor(port host.Port, container *ContainerInit, c *Config, log log15.Logger) (discoverd.Heartbeater, error) {
 	config := port.Service
+	env := c.Env
 	client := discoverd.NewClientWithURL(env["DISCOVERD"])
 	client.Logger = logger.New("component", "discoverd")
 
@@ -437,14 +471,48 @@ func monitor(port host.Port, container *ContainerInit, env map[string]string, lo
 	var check health.Check
 	switch config.Check.Type {
 	case "tcp":
-		check = &health.TCPCheck{Addr: inst.Addr}
+		check = &health.TCPCheck{Addr: inst.Addr, Timeout: config.Check.Timeout}
 	case "http", "https":
 		check = &health.HTTPCheck{
 			URL:        fmt.Sprintf("%s://%s%s", config.Check.Type, inst.Addr, config.Check.Path),
 			Host:       config.Check.Host,
+			Timeout:    config.Check.Timeout,
 			StatusCode: config.Check.Status,
 			MatchBytes: []byte(config.Check.Match),
 		}
+	case "exec":
+		if len(config.Check.Command) == 0 {
+			return nil, fmt.Errorf("exec check has no command")
+		}
+		// run the command as the same user and with the same
+		// environment and working directory as the app, so it has no
+		// more privileges than the app
+		checkEnv := make([]string, 0, len(env))
+		for k, v := range env {
+			checkEnv = append(checkEnv, k+"="+v)
+		}
+		cred, err := getCredential(c)
+		if err != nil {
+			return nil, err
+		}
+		cmdPath, err := lookPath(config.Check.Command[0], env["PATH"], c.WorkDir)
+		if err != nil {
+			return nil, fmt.Errorf("exec check command not found: %s", err)
+		}
+		check = &health.ExecCheck{
+			Args:       append([]string{cmdPath}, config.Check.Command[1:]...),
+			Env:        checkEnv,
+			Dir:        c.WorkDir,
+			Credential: cred,
+			Timeout:    config.Check.Timeout,
+		}
+	case "grpc":
+		check = &health.GRPCCheck{
+			Addr:    inst.Addr,
+			Service: config.Check.GRPCService,
+			TLS:     config.Check.TLS,
+			Timeout: config.Check.Timeout,
+		}
 	default:
 		// unsupported checker type
 		return nil, fmt.Errorf("unsupported check type: %s", config.Check.Type)
@@ -684,7 +752,7 @@ func containerInitApp(c *Config, logFile *os.File) error {
 		}
 		log = log.New("service", port.Service.Name, "port", port.Port, "proto", port.Proto)
 		log.Info("monitoring service")
-		hb, err := monitor(port, init, c.Env, log)
+		hb, err := monitor(port, init, c, log)
 		if err != nil {
 			log.Error("error monitoring service", "err", err)
 			os.Exit(70)
@@ -3,13 +3,17 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/host/logmux"
 	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/dialer"
+	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/pkg/shutdown"
 )
 
@@ -137,3 +141,47 @@ func (d *DiscoverdManager) UpdateTags(tags map[string]string) error {
 	}
 	return d.hb.SetMeta(d.inst.Meta)
 }
+
+// UpdateDrain sets the keys in the host's metadata which indicate to the
+// scheduler whether the host is being drained, removing them if drain is nil
+func (d *DiscoverdManager) UpdateDrain(drain *host.DrainStatus) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	delete(d.inst.Meta, host.DrainMetaKey)
+	delete(d.inst.Meta, host.DrainDataMetaKey)
+	delete(d.inst.Meta, host.DrainedMetaKey)
+	if drain != nil {
+		d.inst.Meta[host.DrainMetaKey] = "true"
+		if drain.Data {
+			d.inst.Meta[host.DrainDataMetaKey] = "true"
+		}
+		if drain.Drained {
+			d.inst.Meta[host.DrainedMetaKey] = "true"
+		}
+	}
+	if d.hb == nil {
+		return nil
+	}
+	return d.hb.SetMeta(d.inst.Meta)
+}
+
+// dialDiscoverd dials addr, resolving hosts ending in ".discoverd" to the
+// address of a random instance of the service as the host is likely not
+// using discoverd to resolve DNS queries.
+func dialDiscoverd(network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(host, ".discoverd") {
+		service := strings.TrimSuffix(host, ".discoverd")
+		addrs, err := discoverd.NewClient().Service(service).Addrs()
+		if err != nil {
+			return nil, err
+		} else if len(addrs) == 0 {
+			return nil, fmt.Errorf("lookup %s: no such host", host)
+		}
+		addr = addrs[random.Math.Intn(len(addrs))]
+	}
+	return dialer.Default.Dial(network, addr)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -18,8 +19,10 @@ import (
 	"github.com/flynn/flynn/host/cli"
 	"github.com/flynn/flynn/host/config"
 	"github.com/flynn/flynn/host/logmux"
+	"github.com/flynn/flynn/host/resource"
 	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/host/volume"
+	dirVolume "github.com/flynn/flynn/host/volume/dir"
 	"github.com/flynn/flynn/host/volume/manager"
 	zfsVolume "github.com/flynn/flynn/host/volume/zfs"
 	"github.com/flynn/flynn/pkg/shutdown"
@@ -45,7 +48,7 @@ options:
   --tags=TAGS                host tags (comma separated list of KEY=VAL pairs, used for job constraints in the scheduler)
   --force                    kill all containers booted by flynn-host before starting
   --volpath=PATH             directory to create volumes in [default: /var/lib/flynn/volumes]
-  --vol-provider=VOL         volume provider [default: zfs]
+  --vol-provider=VOL         volume provider (zfs or dir) [default: zfs]
   --backend=BACKEND          runner backend [default: libcontainer]
   --flynn-init=PATH          path to flynn-init binary [default: /usr/local/bin/flynn-init]
   --log-dir=DIR              directory to store job logs [default: /var/log/flynn]
@@ -54,6 +57,8 @@ options:
   --bridge-name=NAME         network bridge name [default: flynnbr0]
   --no-resurrect             disable cluster resurrection
   --max-job-concurrency=NUM  maximum number of jobs to start concurrently
+  --memory-capacity=SIZE     memory available to jobs for scheduling (e.g. 16GB, unlimited if not set)
+  --cpu-capacity=MILLICPU    CPU available to jobs for scheduling in milliCPU (unlimited if not set)
   --partitions=PARTITIONS    specify resource partitions for host [default: system=cpu_shares:4096 background=cpu_shares:4096 user=cpu_shares:8192]
 	`)
 }
@@ -93,6 +98,7 @@ Commands:
   stop                       Stop running jobs
   signal                     Signal a job
   destroy-volumes            Destroys the local volume database
+  volume                     Manage volume snapshots uploaded to the blobstore
   collect-debug-info         Collect debug information into an anonymous gist or tarball
   list                       Lists ID and IP of each host
   version                    Show current version
@@ -190,6 +196,11 @@ func runDaemon(args *docopt.Args) {
 		maxJobConcurrency = m
 	}
 
+	capacity, err := hostCapacity(args.String["--memory-capacity"], args.String["--cpu-capacity"])
+	if err != nil {
+		shutdown.Fatalf("error determining host capacity: %s", err)
+	}
+
 	var partitionCGroups = make(map[string]int64) // name -> cpu shares
 	for _, p := range strings.Split(args.String["--partitions"], " ") {
 		nameShares := strings.Split(p, "=cpu_shares:")
@@ -271,6 +282,12 @@ func runDaemon(args *docopt.Args) {
 				WorkingDir: filepath.Join(volPath, "zfs"),
 			})
 		}
+	case "dir":
+		newVolProvider = func() (volume.Provider, error) {
+			return dirVolume.NewProvider(&dirVolume.ProviderConfig{
+				RootDir: filepath.Join(volPath, "dir"),
+			})
+		}
 	case "mock":
 		newVolProvider = func() (volume.Provider, error) { return nil, nil }
 	default:
@@ -307,11 +324,12 @@ func runDaemon(args *docopt.Args) {
 		id:  hostID,
 		url: publishURL,
 		status: &host.HostStatus{
-			ID:      hostID,
-			PID:     os.Getpid(),
-			URL:     publishURL,
-			Tags:    tags,
-			Version: version.String(),
+			ID:       hostID,
+			PID:      os.Getpid(),
+			URL:      publishURL,
+			Tags:     tags,
+			Version:  version.String(),
+			Capacity: capacity,
 		},
 		state:   state,
 		backend: backend,
@@ -320,6 +338,7 @@ func runDaemon(args *docopt.Args) {
 		log:     logger.New("host.id", hostID),
 
 		maxJobConcurrency: maxJobConcurrency,
+		blobClient:        &http.Client{Transport: &http.Transport{Dial: dialDiscoverd}},
 	}
 	backend.SetHost(host)
 
@@ -335,6 +354,10 @@ func runDaemon(args *docopt.Args) {
 		host.status.PID = pid
 		// keep the same tags as the parent
 		discoverdManager.UpdateTags(host.status.Tags)
+		// and continue draining if the parent was
+		discoverdManager.UpdateDrain(host.status.Drain)
+		// but use the capacity from our own flags
+		host.status.Capacity = capacity
 	}
 
 	log.Info("creating HTTP listener")
@@ -425,6 +448,11 @@ func runDaemon(args *docopt.Args) {
 	log.Info("serving HTTP requests")
 	host.ServeHTTP()
 
+	log.Info("running volume snapshot policies")
+	stopSnapshots := make(chan struct{})
+	go vman.RunSnapshotPolicies(host.blobClient, logger.New("host.id", hostID, "component", "snapshots"), stopSnapshots)
+	shutdown.BeforeExit(func() { close(stopSnapshots) })
+
 	if controlFD > 0 {
 		// now that we are serving requests, send an "ok" message to the parent
 		log.Info("sending ok message to parent")
@@ -500,6 +528,33 @@ func parseTagArgs(args string) map[string]string {
 	return tags
 }
 
+// hostCapacity returns the memory and CPU the host makes available to jobs,
+// which is only limited for the resources given. Capacity placement is opt-in
+// as jobs request their limits by default (e.g. 1GiB of memory and a whole
+// CPU), which is far more than most jobs use, so limiting hosts to their
+// system memory and CPU cores would leave most jobs pending.
+func hostCapacity(memory, cpu string) (resource.Capacity, error) {
+	if memory == "" && cpu == "" {
+		return nil, nil
+	}
+	capacity := make(resource.Capacity, 2)
+	if memory != "" {
+		n, err := resource.ParseLimit(resource.TypeMemory, memory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory capacity %q: %s", memory, err)
+		}
+		capacity[resource.TypeMemory] = n
+	}
+	if cpu != "" {
+		n, err := strconv.ParseInt(cpu, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid CPU capacity %q", cpu)
+		}
+		capacity[resource.TypeCPU] = n
+	}
+	return capacity, nil
+}
+
 func setupLogger(logDir string) (log15.Logger, error) {
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		return nil, err
@@ -50,6 +50,9 @@ type Host struct {
 
 	maxJobConcurrency uint64
 
+	// blobClient is used to upload and download volume snapshots
+	blobClient *http.Client
+
 	log log15.Logger
 }
 
@@ -103,6 +106,20 @@ func (h *Host) SignalJob(id string, sig int) error {
 	return h.backend.Signal(id, sig)
 }
 
+func (h *Host) JobStats(id string) (*host.JobStats, error) {
+	log := h.log.New("fn", "JobStats", "job.id", id)
+
+	job := h.state.GetJob(id)
+	if job == nil {
+		log.Warn("job not found")
+		return nil, ErrNotFound
+	}
+	if job.Status != host.StatusRunning {
+		return nil, host.ErrJobNotRunning
+	}
+	return h.backend.Stats(id)
+}
+
 func (h *Host) streamEvents(id string, w http.ResponseWriter) error {
 	ch := h.state.AddListener(id)
 	defer h.state.RemoveListener(id, ch)
@@ -229,6 +246,18 @@ func (h *jobAPI) SignalJob(w http.ResponseWriter, r *http.Request, ps httprouter
 	w.WriteHeader(200)
 }
 
+func (h *jobAPI) JobStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	stats, err := h.host.JobStats(ps.ByName("id"))
+	if err == ErrNotFound {
+		httphelper.ObjectNotFoundError(w, err.Error())
+		return
+	} else if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, stats)
+}
+
 func (h *jobAPI) PullImages(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	log := h.host.log.New("fn", "PullImages")
 
@@ -445,6 +474,65 @@ func (h *Host) UpdateTags(tags map[string]string) error {
 	return nil
 }
 
+func (h *jobAPI) Drain(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	drain := &host.DrainStatus{}
+	if err := httphelper.DecodeJSON(r, drain); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	// only the scheduler marks the host as drained
+	drain.Drained = false
+	if err := h.host.UpdateDrain(drain); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func (h *jobAPI) Undrain(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := h.host.UpdateDrain(nil); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func (h *jobAPI) MarkDrained(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := h.host.MarkDrained(); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// UpdateDrain starts draining the host, or stops draining it if drain is nil
+func (h *Host) UpdateDrain(drain *host.DrainStatus) error {
+	h.statusMtx.Lock()
+	defer h.statusMtx.Unlock()
+	if err := h.discMan.UpdateDrain(drain); err != nil {
+		return err
+	}
+	h.status.Drain = drain
+	return nil
+}
+
+// MarkDrained records that the scheduler has moved all of its jobs off the
+// host
+func (h *Host) MarkDrained() error {
+	h.statusMtx.Lock()
+	defer h.statusMtx.Unlock()
+	if h.status.Drain == nil {
+		return httphelper.PreconditionFailedErr("host is not being drained")
+	}
+	drain := *h.status.Drain
+	drain.Drained = true
+	if err := h.discMan.UpdateDrain(&drain); err != nil {
+		return err
+	}
+	h.status.Drain = &drain
+	return nil
+}
+
 func checkPort(port host.Port) bool {
 	l, err := net.Listen(port.Proto, fmt.Sprintf(":%d", port.Port))
 	if err != nil {
@@ -533,6 +621,7 @@ func (h *jobAPI) RegisterRoutes(r *httprouter.Router) error {
 	r.PUT("/host/jobs/:id", h.AddJob)
 	r.DELETE("/host/jobs/:id", h.StopJob)
 	r.PUT("/host/jobs/:id/signal/:signal", h.SignalJob)
+	r.GET("/host/jobs/:id/stats", h.JobStats)
 	r.POST("/host/pull/images", h.PullImages)
 	r.POST("/host/pull/binaries", h.PullBinariesAndConfig)
 	r.POST("/host/discoverd", h.ConfigureDiscoverd)
@@ -541,6 +630,9 @@ func (h *jobAPI) RegisterRoutes(r *httprouter.Router) error {
 	r.POST("/host/resource-check", h.ResourceCheck)
 	r.POST("/host/update", h.Update)
 	r.POST("/host/tags", h.UpdateTags)
+	r.PUT("/host/drain", h.Drain)
+	r.DELETE("/host/drain", h.Undrain)
+	r.PUT("/host/drain/drained", h.MarkDrained)
 	return nil
 }
 
@@ -556,6 +648,7 @@ func (h *Host) ServeHTTP() {
 	jobAPI.RegisterRoutes(r)
 
 	volAPI := volumeapi.NewHTTPAPI(cluster.NewClient(), h.vman)
+	volAPI.BlobClient = h.blobClient
 	volAPI.RegisterRoutes(r)
 
 	go http.Serve(h.listener, httphelper.ContextInjector("host", httphelper.NewRequestLogger(r)))
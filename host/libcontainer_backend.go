@@ -982,6 +982,30 @@ func (l *LibcontainerBackend) Signal(id string, sig int) error {
 	return container.Signal(sig)
 }
 
+func (l *LibcontainerBackend) Stats(id string) (*host.JobStats, error) {
+	container, err := l.getContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := container.container.Stats()
+	if err != nil {
+		return nil, err
+	}
+	cg := stats.CgroupStats
+	if cg == nil {
+		return nil, errors.New("container has no cgroup stats")
+	}
+	memory := cg.MemoryStats.Usage.Usage
+	if cache := cg.MemoryStats.Cache; cache < memory {
+		memory -= cache
+	}
+	return &host.JobStats{
+		CPUUsage:    cg.CpuStats.CpuUsage.TotalUsage,
+		MemoryUsage: memory,
+		Time:        time.Now(),
+	}, nil
+}
+
 func (l *LibcontainerBackend) Attach(req *AttachRequest) (err error) {
 	client, err := l.getContainer(req.Job.Job.ID)
 	if err != nil {
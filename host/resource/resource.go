@@ -50,6 +50,10 @@ var defaults = Resources{
 
 type Resources map[Type]Spec
 
+// Capacity is the total amount of each type of resource a host makes
+// available to jobs, measured in the same units as the Request of a Spec.
+type Capacity map[Type]int64
+
 func Defaults() Resources {
 	r := make(Resources)
 	SetDefaults(&r)
@@ -10,6 +10,14 @@ import (
 // TagPrefix is the prefix added to tags in discoverd instance metadata
 const TagPrefix = "tag:"
 
+// The keys set in discoverd instance metadata when the host is being drained
+// (see DrainStatus)
+const (
+	DrainMetaKey     = "drain"
+	DrainDataMetaKey = "drain-data"
+	DrainedMetaKey   = "drained"
+)
+
 type Job struct {
 	ID string `json:"id,omitempty"`
 
@@ -138,7 +146,7 @@ type Service struct {
 }
 
 type HealthCheck struct {
-	// Type is one of tcp, http, https
+	// Type is one of tcp, http, https, exec, grpc
 	Type string `json:"type,omitempty"`
 	// Interval is the time to wait between checks after the service has been
 	// marked as up. It defaults to two seconds.
@@ -153,12 +161,25 @@ type HealthCheck struct {
 	// StartTimeout is the maximum duration that a service can take to come up
 	// for the first time if KillDown is true. It defaults to ten seconds.
 	StartTimeout time.Duration `json:"start_timeout,omitempty"`
+	// Timeout is the maximum duration of a single check. It defaults to two
+	// seconds.
+	Timeout time.Duration `json:"timeout,omitempty"`
 
 	// Extra optional config fields for http/https checks
 	Path   string `json:"path,omitempty"`
 	Host   string `json:"host,omitempty"`
 	Match  string `json:"match,omitempty"`
 	Status int    `json:"status,omitempty"`
+
+	// Command is run inside the job's container for exec checks, which pass
+	// if it exits with status 0
+	Command []string `json:"command,omitempty"`
+
+	// Extra optional config fields for grpc checks, GRPCService is the
+	// service name sent in the health check request (the server's overall
+	// health is checked if it is empty)
+	GRPCService string `json:"grpc_service,omitempty"`
+	TLS         bool   `json:"tls,omitempty"`
 }
 
 type Mount struct {
@@ -214,6 +235,19 @@ type ActiveJob struct {
 	Error       *string   `json:"error,omitempty"`
 }
 
+// JobStats is the resource usage of a running job, read from its cgroups
+type JobStats struct {
+	// CPUUsage is the total CPU time consumed by the job in nanoseconds
+	CPUUsage uint64 `json:"cpu_usage"`
+
+	// MemoryUsage is the memory used by the job in bytes, excluding the
+	// page cache
+	MemoryUsage uint64 `json:"memory_usage"`
+
+	// Time is the time the stats were read
+	Time time.Time `json:"time"`
+}
+
 func (j *ActiveJob) Dup() *ActiveJob {
 	job := *j
 	job.Job = j.Job.Dup()
@@ -300,6 +334,30 @@ type HostStatus struct {
 	Discoverd *DiscoverdConfig  `json:"discoverd,omitempty"`
 	Network   *NetworkConfig    `json:"network,omitempty"`
 	Version   string            `json:"version"`
+
+	// Capacity is the amount of memory and CPU the host makes available
+	// to jobs, which the scheduler uses to place jobs based on their
+	// resource requests. Resources missing from it are unlimited, and it
+	// is nil unless set with the daemon's --memory-capacity and
+	// --cpu-capacity flags.
+	Capacity resource.Capacity `json:"capacity,omitempty"`
+
+	// Drain is set when the host is being drained of its jobs
+	Drain *DrainStatus `json:"drain,omitempty"`
+}
+
+// DrainStatus is the status of a host which is being drained, in which case
+// the scheduler stops placing jobs on it and moves its jobs to other hosts one
+// at a time so that it can be rebooted or removed from the cluster
+type DrainStatus struct {
+	// Data indicates that jobs with data volumes should also be moved,
+	// starting them on other hosts with new volumes (otherwise they are
+	// left running and prevent the host from being drained)
+	Data bool `json:"data,omitempty"`
+
+	// Drained is set by the scheduler once it has moved all of its jobs
+	// off the host other than omni jobs (which run on every host)
+	Drained bool `json:"drained,omitempty"`
 }
 
 const (
@@ -3,4 +3,24 @@ package volume
 type PullCoordinate struct {
 	HostID     string `json:"host_id"`
 	SnapshotID string `json:"snapshot_id"`
+
+	// VolumeID is the volume on the source host to snapshot and transfer
+	// when pulling a volume rather than an existing snapshot.
+	VolumeID string `json:"volume_id,omitempty"`
+}
+
+// VolumeTransfer describes a snapshot of a volume on another host which was
+// pulled onto a volume.
+//
+// Pulling the same volume again onto the same receiving volume only transfers
+// what has changed since the last transfer, which allows a volume to be copied
+// whilst it is in use and then quickly brought up to date once it is not.
+type VolumeTransfer struct {
+	// SourceSnapshotID is the snapshot taken of the volume on the source
+	// host, which can be destroyed once it is no longer needed to send
+	// later snapshots incrementally.
+	SourceSnapshotID string `json:"source_snapshot_id"`
+
+	// Snapshot is the snapshot created on the receiving host.
+	Snapshot *Info `json:"snapshot"`
 }
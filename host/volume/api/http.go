@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/flynn/flynn/host/volume"
 	"github.com/flynn/flynn/host/volume/manager"
@@ -19,24 +20,36 @@ const snapshotContentType = "application/vnd.zfs.snapshot-stream"
 type HTTPAPI struct {
 	cluster *cluster.Client
 	vman    *volumemanager.Manager
+
+	// BlobClient is used to download snapshots from the blobstore when
+	// restoring volumes.
+	BlobClient *http.Client
 }
 
 func NewHTTPAPI(cluster *cluster.Client, vman *volumemanager.Manager) *HTTPAPI {
 	return &HTTPAPI{
-		cluster: cluster,
-		vman:    vman,
+		cluster:    cluster,
+		vman:       vman,
+		BlobClient: http.DefaultClient,
 	}
 }
 
 func (api *HTTPAPI) RegisterRoutes(r *httprouter.Router) {
 	r.POST("/storage/providers", api.CreateProvider)
 	r.POST("/storage/providers/:provider_id/volumes", api.Create)
+	// creates a volume and restores it from snapshots uploaded to the blobstore by a snapshot policy
+	r.POST("/storage/providers/:provider_id/restore", api.Restore)
 	r.GET("/storage/volumes", api.List)
 	r.GET("/storage/volumes/:volume_id", api.Inspect)
 	r.DELETE("/storage/volumes/:volume_id", api.Destroy)
 	r.PUT("/storage/volumes/:volume_id/snapshot", api.Snapshot)
+	r.GET("/storage/volumes/:volume_id/snapshot_policy", api.GetSnapshotPolicy)
+	r.PUT("/storage/volumes/:volume_id/snapshot_policy", api.SetSnapshotPolicy)
+	r.DELETE("/storage/volumes/:volume_id/snapshot_policy", api.DeleteSnapshotPolicy)
 	// takes host and volID parameters, triggers a send on the remote host and give it a list of snaps already here, and pipes it into recv
 	r.POST("/storage/volumes/:volume_id/pull_snapshot", api.Pull)
+	// takes host and volume_id parameters, snapshots the volume on the remote host and pulls the snapshot as above, used to move volumes between hosts
+	r.POST("/storage/volumes/:volume_id/pull_volume", api.PullVolume)
 	// responds with a snapshot stream binary.  only works on snapshots, takes 'haves' parameters, usually called by a node that's servicing a 'pull_snapshot' request
 	r.GET("/storage/volumes/:volume_id/send", api.Send)
 }
@@ -141,6 +154,92 @@ func (api *HTTPAPI) Snapshot(w http.ResponseWriter, r *http.Request, ps httprout
 	httphelper.JSON(w, 200, snap.Info())
 }
 
+func (api *HTTPAPI) GetSnapshotPolicy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	volumeID := ps.ByName("volume_id")
+	policy := api.vman.GetSnapshotPolicy(volumeID)
+	if policy == nil {
+		httphelper.ObjectNotFoundError(w, fmt.Sprintf("no snapshot policy for volume %q", volumeID))
+		return
+	}
+
+	httphelper.JSON(w, 200, policy)
+}
+
+func (api *HTTPAPI) SetSnapshotPolicy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	volumeID := ps.ByName("volume_id")
+
+	policy := &volume.SnapshotPolicy{}
+	if err := httphelper.DecodeJSON(r, policy); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if policy.Interval < time.Minute {
+		httphelper.ValidationError(w, "interval", "must be at least one minute")
+		return
+	}
+	if policy.Retention < 1 {
+		httphelper.ValidationError(w, "retention", "must be at least 1")
+		return
+	}
+
+	if err := api.vman.SetSnapshotPolicy(volumeID, policy); err != nil {
+		switch err {
+		case volumemanager.NoSuchVolume:
+			httphelper.ObjectNotFoundError(w, fmt.Sprintf("no volume with id %q", volumeID))
+			return
+		default:
+			httphelper.Error(w, err)
+			return
+		}
+	}
+
+	httphelper.JSON(w, 200, policy)
+}
+
+func (api *HTTPAPI) DeleteSnapshotPolicy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	volumeID := ps.ByName("volume_id")
+	if err := api.vman.SetSnapshotPolicy(volumeID, nil); err != nil {
+		switch err {
+		case volumemanager.NoSuchVolume:
+			httphelper.ObjectNotFoundError(w, fmt.Sprintf("no volume with id %q", volumeID))
+			return
+		default:
+			httphelper.Error(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+func (api *HTTPAPI) Restore(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	providerID := ps.ByName("provider_id")
+
+	req := &volume.RestoreRequest{}
+	if err := httphelper.DecodeJSON(r, req); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if req.URL == "" {
+		httphelper.ValidationError(w, "url", "must not be blank")
+		return
+	}
+
+	vol, err := api.vman.RestoreVolume(providerID, req, api.BlobClient)
+	if err != nil {
+		switch err {
+		case volumemanager.NoSuchProvider:
+			httphelper.ObjectNotFoundError(w, fmt.Sprintf("no volume provider with id %q", providerID))
+			return
+		default:
+			httphelper.Error(w, err)
+			return
+		}
+	}
+
+	httphelper.JSON(w, 200, vol.Info())
+}
+
 func (api *HTTPAPI) Pull(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	volumeID := ps.ByName("volume_id")
 
@@ -156,25 +255,72 @@ func (api *HTTPAPI) Pull(w http.ResponseWriter, r *http.Request, ps httprouter.P
 		return
 	}
 
-	haves, err := api.vman.ListHaves(volumeID)
+	snap, err := api.pull(volumeID, hostClient, pull.SnapshotID)
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+
+	httphelper.JSON(w, 200, snap.Info())
+}
+
+func (api *HTTPAPI) PullVolume(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	volumeID := ps.ByName("volume_id")
+
+	pull := &volume.PullCoordinate{}
+	if err := httphelper.DecodeJSON(r, &pull); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if pull.VolumeID == "" {
+		httphelper.ValidationError(w, "volume_id", "must not be blank")
+		return
+	}
+	if api.vman.GetVolume(volumeID) == nil {
+		httphelper.ObjectNotFoundError(w, fmt.Sprintf("no volume with id %q", volumeID))
+		return
+	}
+
+	hostClient, err := api.cluster.Host(pull.HostID)
 	if err != nil {
 		httphelper.Error(w, err)
 		return
 	}
 
-	reader, err := hostClient.SendSnapshot(pull.SnapshotID, haves)
+	source, err := hostClient.CreateSnapshot(pull.VolumeID)
 	if err != nil {
 		httphelper.Error(w, err)
 		return
 	}
 
-	snap, err := api.vman.ReceiveSnapshot(volumeID, reader)
+	snap, err := api.pull(volumeID, hostClient, source.ID)
 	if err != nil {
+		hostClient.DestroyVolume(source.ID)
 		httphelper.Error(w, err)
 		return
 	}
 
-	httphelper.JSON(w, 200, snap.Info())
+	httphelper.JSON(w, 200, &volume.VolumeTransfer{
+		SourceSnapshotID: source.ID,
+		Snapshot:         snap.Info(),
+	})
+}
+
+// pull receives the given snapshot from another host into a volume, sending
+// the volume's haves so that only what it doesn't have is transferred
+func (api *HTTPAPI) pull(volumeID string, hostClient *cluster.Host, snapshotID string) (volume.Volume, error) {
+	haves, err := api.vman.ListHaves(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := hostClient.SendSnapshot(snapshotID, haves)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return api.vman.ReceiveSnapshot(volumeID, reader)
 }
 
 func (api *HTTPAPI) Send(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -0,0 +1,302 @@
+package dir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/flynn/flynn/host/volume"
+	"github.com/flynn/flynn/pkg/random"
+)
+
+/*
+	The dir provider stores volumes as plain directories, so it works on any
+	Linux host regardless of the filesystem (unlike zfs, which needs a zpool).
+
+	Snapshots and forks are full copies made with `cp --reflink=auto`, which
+	shares data blocks between the copies on filesystems which support it (e.g.
+	btrfs and xfs) and falls back to copying data everywhere else.  Snapshots
+	are transmitted as tar streams, there is no support for incremental
+	transmission.
+*/
+
+type dirVolume struct {
+	info     *volume.Info
+	provider *Provider
+	path     string
+	snapshot bool
+}
+
+type Provider struct {
+	config  *ProviderConfig
+	volumes map[string]*dirVolume
+}
+
+// ProviderConfig describes dir config used at provider setup time.
+//
+// `volume.ProviderSpec.Config` is deserialized to this for dir, and it is also
+// the output of `MarshalGlobalState`.
+type ProviderConfig struct {
+	// RootDir specifies the directory this provider will create volumes in.
+	// A default will be chosen if left blank.
+	RootDir string `json:"root_dir"`
+}
+
+func NewProvider(config *ProviderConfig) (volume.Provider, error) {
+	for _, cmd := range []string{"cp", "tar"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			return nil, fmt.Errorf("%s command is not available", cmd)
+		}
+	}
+	if config.RootDir == "" {
+		config.RootDir = "/var/lib/flynn/volumes/dir/"
+	}
+	if err := os.MkdirAll(filepath.Join(config.RootDir, "mnt"), 0755); err != nil {
+		return nil, err
+	}
+	return &Provider{
+		config:  config,
+		volumes: make(map[string]*dirVolume),
+	}, nil
+}
+
+func (b Provider) Kind() string {
+	return "dir"
+}
+
+func (b *Provider) NewVolume() (volume.Volume, error) {
+	v := b.newVolume(false)
+	if err := os.Mkdir(v.path, 0755); err != nil {
+		return nil, err
+	}
+	b.volumes[v.info.ID] = v
+	return v, nil
+}
+
+func (b *Provider) newVolume(snapshot bool) *dirVolume {
+	id := random.UUID()
+	return &dirVolume{
+		info:     &volume.Info{ID: id},
+		provider: b,
+		path:     b.mountPath(id),
+		snapshot: snapshot,
+	}
+}
+
+func (b *Provider) owns(vol volume.Volume) (*dirVolume, error) {
+	dvol := b.volumes[vol.Info().ID]
+	if dvol == nil {
+		return nil, fmt.Errorf("volume does not belong to this provider")
+	}
+	if dvol != vol { // these pointers should be canonical
+		panic(fmt.Errorf("volume does not belong to this provider"))
+	}
+	return dvol, nil
+}
+
+func (b Provider) mountPath(id string) string {
+	return filepath.Join(b.config.RootDir, "/mnt/", id)
+}
+
+func (b *Provider) DestroyVolume(vol volume.Volume) error {
+	dvol, err := b.owns(vol)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dvol.path); err != nil {
+		return err
+	}
+	delete(b.volumes, vol.Info().ID)
+	return nil
+}
+
+func (b *Provider) CreateSnapshot(vol volume.Volume) (volume.Volume, error) {
+	dvol, err := b.owns(vol)
+	if err != nil {
+		return nil, err
+	}
+	snap := b.newVolume(true)
+	if err := copyDir(dvol.path, snap.path); err != nil {
+		return nil, fmt.Errorf("could not snapshot volume: %s", err)
+	}
+	b.volumes[snap.info.ID] = snap
+	return snap, nil
+}
+
+func (b *Provider) ForkVolume(vol volume.Volume) (volume.Volume, error) {
+	dvol, err := b.owns(vol)
+	if err != nil {
+		return nil, err
+	}
+	if !vol.IsSnapshot() {
+		return nil, fmt.Errorf("can only fork a snapshot")
+	}
+	v2 := b.newVolume(false)
+	if err := copyDir(dvol.path, v2.path); err != nil {
+		return nil, fmt.Errorf("could not fork volume: %s", err)
+	}
+	b.volumes[v2.info.ID] = v2
+	return v2, nil
+}
+
+// copyDir copies src to dst (which must not exist), preserving ownership,
+// permissions and links, and sharing data blocks if the filesystem supports
+// it.
+func copyDir(src, dst string) error {
+	var buf bytes.Buffer
+	cmd := exec.Command("cp", "-a", "--reflink=auto", src, dst)
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(dst)
+		return fmt.Errorf("%s (%s)", err, strings.TrimSpace(buf.String()))
+	}
+	return nil
+}
+
+// ListHaves returns no haves as snapshots are always sent in full.
+func (b *Provider) ListHaves(vol volume.Volume) ([]json.RawMessage, error) {
+	if _, err := b.owns(vol); err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{}, nil
+}
+
+func (b *Provider) SendSnapshot(vol volume.Volume, haves []json.RawMessage, output io.Writer) error {
+	dvol, err := b.owns(vol)
+	if err != nil {
+		return err
+	}
+	if !vol.IsSnapshot() {
+		return fmt.Errorf("can only send a snapshot")
+	}
+	var buf bytes.Buffer
+	cmd := exec.Command("tar", "-c", "-C", dvol.path, "--numeric-owner", ".")
+	cmd.Stdout = output
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("tar failed to send snapshot data: %s (%s)", err, strings.TrimSpace(buf.String()))
+	}
+	return nil
+}
+
+// ReceiveSnapshot reads a tar stream of a snapshotted filesystem, replaces the
+// contents of the given `vol` with it, and returns a new snapshot of the result
+// (which matches the behaviour of the zfs provider).
+func (b *Provider) ReceiveSnapshot(vol volume.Volume, input io.Reader) (volume.Volume, error) {
+	dvol, err := b.owns(vol)
+	if err != nil {
+		return nil, err
+	}
+	if vol.IsSnapshot() {
+		return nil, fmt.Errorf("cannot receive into a snapshot")
+	}
+
+	// extract into a temporary directory so a failed receive leaves the
+	// volume untouched
+	tmp, err := ioutil.TempDir(filepath.Join(b.config.RootDir, "mnt"), "recv-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	var buf bytes.Buffer
+	cmd := exec.Command("tar", "-x", "-C", tmp, "--numeric-owner", "--same-permissions")
+	cmd.Stdin = input
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("tar rejected snapshot data: %s (%s)", err, strings.TrimSpace(buf.String()))
+	}
+
+	// replace the contents rather than the directory itself, as it may be
+	// bind mounted into a container
+	if err := clearDir(dvol.path); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(tmp, entry.Name()), filepath.Join(dvol.path, entry.Name())); err != nil {
+			return nil, err
+		}
+	}
+	// the tar stream includes the root directory's mode and ownership
+	if info, err := os.Stat(tmp); err == nil {
+		os.Chmod(dvol.path, info.Mode())
+	}
+
+	return b.CreateSnapshot(dvol)
+}
+
+// clearDir removes everything inside dir.
+func clearDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *dirVolume) Provider() volume.Provider {
+	return v.provider
+}
+
+func (v *dirVolume) Location() string {
+	return v.path
+}
+
+func (b *Provider) MarshalGlobalState() (json.RawMessage, error) {
+	return json.Marshal(b.config)
+}
+
+type dirVolumeRecord struct {
+	Path     string `json:"path"`
+	Snapshot bool   `json:"snapshot,omitempty"`
+}
+
+func (b *Provider) MarshalVolumeState(volumeID string) (json.RawMessage, error) {
+	vol := b.volumes[volumeID]
+	return json.Marshal(dirVolumeRecord{
+		Path:     vol.path,
+		Snapshot: vol.snapshot,
+	})
+}
+
+func (b *Provider) RestoreVolumeState(volInfo *volume.Info, data json.RawMessage) (volume.Volume, error) {
+	record := &dirVolumeRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("cannot restore volume %q: %s", volInfo.ID, err)
+	}
+	if info, err := os.Stat(record.Path); err != nil {
+		return nil, fmt.Errorf("cannot restore volume %q: %s", volInfo.ID, err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("cannot restore volume %q: %s is not a directory", volInfo.ID, record.Path)
+	}
+	v := &dirVolume{
+		info:     volInfo,
+		provider: b,
+		path:     record.Path,
+		snapshot: record.Snapshot,
+	}
+	b.volumes[volInfo.ID] = v
+	return v, nil
+}
+
+func (v *dirVolume) Info() *volume.Info {
+	return v.info
+}
+
+func (v *dirVolume) IsSnapshot() bool {
+	return v.snapshot
+}
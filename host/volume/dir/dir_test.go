@@ -0,0 +1,142 @@
+package dir
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flynn/flynn/host/volume"
+	. "github.com/flynn/go-check"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type DirSuite struct {
+	root string
+	prov volume.Provider
+}
+
+var _ = Suite(&DirSuite{})
+
+func (s *DirSuite) SetUpTest(c *C) {
+	var err error
+	s.root, err = ioutil.TempDir("", "flynn-test-volumes-")
+	c.Assert(err, IsNil)
+	s.prov, err = NewProvider(&ProviderConfig{RootDir: s.root})
+	c.Assert(err, IsNil)
+}
+
+func (s *DirSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.root)
+}
+
+func writeFile(c *C, vol volume.Volume, name, data string) {
+	path := filepath.Join(vol.Location(), name)
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(path, []byte(data), 0644), IsNil)
+}
+
+func assertFile(c *C, vol volume.Volume, name, data string) {
+	actual, err := ioutil.ReadFile(filepath.Join(vol.Location(), name))
+	c.Assert(err, IsNil)
+	c.Assert(string(actual), Equals, data)
+}
+
+func (s *DirSuite) TestSnapshotAndFork(c *C) {
+	v, err := s.prov.NewVolume()
+	c.Assert(err, IsNil)
+	c.Assert(v.IsSnapshot(), Equals, false)
+	writeFile(c, v, "foo/bar", "original")
+
+	snap, err := s.prov.CreateSnapshot(v)
+	c.Assert(err, IsNil)
+	c.Assert(snap.IsSnapshot(), Equals, true)
+
+	// changes to the volume don't affect the snapshot
+	writeFile(c, v, "foo/bar", "changed")
+	assertFile(c, snap, "foo/bar", "original")
+
+	// only snapshots can be forked
+	_, err = s.prov.ForkVolume(v)
+	c.Assert(err, NotNil)
+	fork, err := s.prov.ForkVolume(snap)
+	c.Assert(err, IsNil)
+	c.Assert(fork.IsSnapshot(), Equals, false)
+	assertFile(c, fork, "foo/bar", "original")
+
+	for _, vol := range []volume.Volume{fork, snap, v} {
+		c.Assert(s.prov.DestroyVolume(vol), IsNil)
+		_, err := os.Stat(vol.Location())
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+}
+
+func (s *DirSuite) TestTransmit(c *C) {
+	v, err := s.prov.NewVolume()
+	c.Assert(err, IsNil)
+	writeFile(c, v, "foo/bar", "data")
+	c.Assert(os.Symlink("foo/bar", filepath.Join(v.Location(), "link")), IsNil)
+	snap, err := s.prov.CreateSnapshot(v)
+	c.Assert(err, IsNil)
+
+	// only snapshots can be sent
+	var buf bytes.Buffer
+	c.Assert(s.prov.SendSnapshot(v, nil, &buf), NotNil)
+	haves, err := s.prov.ListHaves(snap)
+	c.Assert(err, IsNil)
+	c.Assert(s.prov.SendSnapshot(snap, haves, &buf), IsNil)
+
+	// receiving replaces the existing content of the volume
+	dest, err := s.prov.NewVolume()
+	c.Assert(err, IsNil)
+	writeFile(c, dest, "existing", "data")
+	recv, err := s.prov.ReceiveSnapshot(dest, &buf)
+	c.Assert(err, IsNil)
+	c.Assert(recv.IsSnapshot(), Equals, true)
+	for _, vol := range []volume.Volume{dest, recv} {
+		assertFile(c, vol, "foo/bar", "data")
+		assertFile(c, vol, "link", "data")
+		_, err := os.Stat(filepath.Join(vol.Location(), "existing"))
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+
+	// invalid data leaves the volume untouched
+	_, err = s.prov.ReceiveSnapshot(dest, bytes.NewReader([]byte("not a tar stream")))
+	c.Assert(err, NotNil)
+	assertFile(c, dest, "foo/bar", "data")
+}
+
+func (s *DirSuite) TestRestoreState(c *C) {
+	v, err := s.prov.NewVolume()
+	c.Assert(err, IsNil)
+	writeFile(c, v, "foo", "data")
+	snap, err := s.prov.CreateSnapshot(v)
+	c.Assert(err, IsNil)
+
+	global, err := s.prov.MarshalGlobalState()
+	c.Assert(err, IsNil)
+	config := &ProviderConfig{}
+	c.Assert(json.Unmarshal(global, config), IsNil)
+	prov, err := NewProvider(config)
+	c.Assert(err, IsNil)
+
+	for _, vol := range []volume.Volume{v, snap} {
+		data, err := s.prov.MarshalVolumeState(vol.Info().ID)
+		c.Assert(err, IsNil)
+		restored, err := prov.RestoreVolumeState(vol.Info(), data)
+		c.Assert(err, IsNil)
+		c.Assert(restored.Location(), Equals, vol.Location())
+		c.Assert(restored.IsSnapshot(), Equals, vol.IsSnapshot())
+		assertFile(c, restored, "foo", "data")
+	}
+
+	// volumes whose directory has been removed cannot be restored
+	data, err := s.prov.MarshalVolumeState(v.Info().ID)
+	c.Assert(err, IsNil)
+	c.Assert(s.prov.DestroyVolume(v), IsNil)
+	_, err = prov.RestoreVolumeState(&volume.Info{ID: "foo"}, data)
+	c.Assert(err, NotNil)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"github.com/flynn/flynn/host/volume"
+	"github.com/flynn/flynn/host/volume/dir"
 	"github.com/flynn/flynn/host/volume/zfs"
 )
 
@@ -18,6 +19,15 @@ func NewProvider(pspec *volume.ProviderSpec) (provider volume.Provider, err erro
 			return
 		}
 		return
+	case "dir":
+		config := &dir.ProviderConfig{}
+		if err := json.Unmarshal(pspec.Config, config); err != nil {
+			return nil, err
+		}
+		if provider, err = dir.NewProvider(config); err != nil {
+			return
+		}
+		return
 	default:
 		return nil, volume.UnknownProviderKind
 	}
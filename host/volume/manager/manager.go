@@ -33,6 +33,9 @@ type Manager struct {
 	// `map[volume.Id]volume`
 	volumes map[string]volume.Volume
 
+	// `map[volume.Id]policy`
+	snapshotPolicies map[string]*volume.SnapshotPolicy
+
 	dbPath string
 	db     *bolt.DB
 	dbMtx  sync.RWMutex
@@ -43,14 +46,16 @@ type Manager struct {
 var NoSuchProvider = errors.New("no such provider")
 var ProviderAlreadyExists = errors.New("that provider id already exists")
 var NoSuchVolume = errors.New("no such volume")
+var NoSuchSnapshotPolicy = errors.New("no such snapshot policy")
 
 func New(dbPath string, defaultProvider func() (volume.Provider, error)) *Manager {
 	return &Manager{
-		providers:       make(map[string]volume.Provider),
-		providerIDs:     make(map[volume.Provider]string),
-		volumes:         make(map[string]volume.Volume),
-		dbPath:          dbPath,
-		defaultProvider: defaultProvider,
+		providers:        make(map[string]volume.Provider),
+		providerIDs:      make(map[volume.Provider]string),
+		volumes:          make(map[string]volume.Volume),
+		snapshotPolicies: make(map[string]*volume.SnapshotPolicy),
+		dbPath:           dbPath,
+		defaultProvider:  defaultProvider,
 	}
 }
 
@@ -75,6 +80,7 @@ func (m *Manager) OpenDB() error {
 		// idempotently create buckets.  (errors ignored because they're all compile-time impossible args checks.)
 		tx.CreateBucketIfNotExists([]byte("volumes"))
 		tx.CreateBucketIfNotExists([]byte("providers"))
+		tx.CreateBucketIfNotExists([]byte("snapshot_policies"))
 		return nil
 	}); err != nil {
 		return fmt.Errorf("could not initialize volume persistence db: %s", err)
@@ -210,13 +216,62 @@ func (m *Manager) DestroyVolume(id string) error {
 		return err
 	}
 	delete(m.volumes, id)
+	_, hasPolicy := m.snapshotPolicies[id]
+	delete(m.snapshotPolicies, id)
 	// commit both changes
 	m.persist(func(tx *bolt.Tx) error {
+		if hasPolicy {
+			if err := m.persistSnapshotPolicy(tx, id); err != nil {
+				return err
+			}
+		}
 		return m.persistVolume(tx, vol)
 	})
 	return nil
 }
 
+// SetSnapshotPolicy sets the policy for periodically snapshotting the given
+// volume and uploading the snapshots to the blobstore, or removes it if
+// policy is nil.
+func (m *Manager) SetSnapshotPolicy(id string, policy *volume.SnapshotPolicy) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	vol := m.volumes[id]
+	if vol == nil {
+		return NoSuchVolume
+	}
+	if policy != nil && vol.IsSnapshot() {
+		return errors.New("cannot set a snapshot policy for a snapshot")
+	}
+	if err := m.LockDB(); err != nil {
+		return err
+	}
+	defer m.UnlockDB()
+	if policy == nil {
+		delete(m.snapshotPolicies, id)
+	} else {
+		m.snapshotPolicies[id] = policy
+	}
+	m.persist(func(tx *bolt.Tx) error { return m.persistSnapshotPolicy(tx, id) })
+	return nil
+}
+
+func (m *Manager) GetSnapshotPolicy(id string) *volume.SnapshotPolicy {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.snapshotPolicies[id]
+}
+
+func (m *Manager) SnapshotPolicies() map[string]*volume.SnapshotPolicy {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	r := make(map[string]*volume.SnapshotPolicy, len(m.snapshotPolicies))
+	for k, v := range m.snapshotPolicies {
+		r[k] = v
+	}
+	return r
+}
+
 func (m *Manager) CreateSnapshot(id string) (volume.Volume, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -308,6 +363,20 @@ func (m *Manager) restore() error {
 		volumesBucket := tx.Bucket([]byte("volumes"))
 		providersBucket := tx.Bucket([]byte("providers"))
 
+		// restore snapshot policies
+		if policiesBucket := tx.Bucket([]byte("snapshot_policies")); policiesBucket != nil {
+			if err := policiesBucket.ForEach(func(k, v []byte) error {
+				policy := &volume.SnapshotPolicy{}
+				if err := json.Unmarshal(v, policy); err != nil {
+					return fmt.Errorf("failed to deserialize snapshot policy: %s", err)
+				}
+				m.snapshotPolicies[string(k)] = policy
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
 		// restore volume info
 		// keep this in a temporary map until we can get providers to transform them into reality
 		volInfos := make(map[string]*volume.Info)
@@ -446,6 +515,22 @@ func (m *Manager) persistVolume(tx *bolt.Tx, vol volume.Volume) error {
 	return nil
 }
 
+func (m *Manager) persistSnapshotPolicy(tx *bolt.Tx, id string) error {
+	policiesBucket := tx.Bucket([]byte("snapshot_policies"))
+	policy, ok := m.snapshotPolicies[id]
+	if !ok {
+		return policiesBucket.Delete([]byte(id))
+	}
+	b, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("failed to serialize snapshot policy: %s", err)
+	}
+	if err := policiesBucket.Put([]byte(id), b); err != nil {
+		return fmt.Errorf("could not persist snapshot policy to boltdb: %s", err)
+	}
+	return nil
+}
+
 func (m *Manager) persistProvider(tx *bolt.Tx, id string) error {
 	// Note: This method does *not* include re-serializing per-volume state,
 	// because we assume that hasn't changed unless the change request
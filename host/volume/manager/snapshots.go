@@ -0,0 +1,250 @@
+package volumemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/host/volume"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+/*
+	Snapshots of volumes with a snapshot policy are uploaded to the blobstore
+	as the streams produced by `SendSnapshot`, along with a manifest listing
+	them (see `volume.SnapshotManifest`).
+
+	The most recently uploaded snapshot is kept on the host so that the next
+	one can be sent incrementally, using the haves which were listed when it
+	was taken.  A full snapshot is sent instead once there are `Retention`
+	snapshots since the last full one, so that old snapshots can be pruned.
+*/
+
+// BackupVolume snapshots the given volume and uploads the snapshot to the
+// blobstore according to its snapshot policy.
+func (m *Manager) BackupVolume(id string, client *http.Client) (*volume.SnapshotRecord, error) {
+	policy := m.GetSnapshotPolicy(id)
+	if policy == nil {
+		return nil, NoSuchSnapshotPolicy
+	}
+	vol := m.GetVolume(id)
+	if vol == nil {
+		return nil, NoSuchVolume
+	}
+	baseURL := snapshotURL(id, policy)
+
+	manifest, err := getSnapshotManifest(client, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		manifest = &volume.SnapshotManifest{
+			VolumeID:     id,
+			ProviderKind: vol.Provider().Kind(),
+		}
+	}
+
+	// send incrementally from the previous snapshot if it is still on this
+	// host and the chain of incremental snapshots is not too long
+	var prev *volume.SnapshotRecord
+	var haves []json.RawMessage
+	if n := len(manifest.Snapshots); n > 0 {
+		prev = manifest.Snapshots[n-1]
+		chain := manifest.RestoreChain(prev.ID)
+		if len(chain) > 0 && len(chain) < policy.Retention && m.GetVolume(prev.ID) != nil {
+			haves = prev.Haves
+		}
+	}
+
+	snap, err := m.CreateSnapshot(id)
+	if err != nil {
+		return nil, err
+	}
+	record := &volume.SnapshotRecord{
+		ID:        snap.Info().ID,
+		CreatedAt: time.Now().UTC(),
+		Full:      len(haves) == 0,
+	}
+	if record.Haves, err = m.ListHaves(id); err != nil {
+		m.DestroyVolume(record.ID)
+		return nil, err
+	}
+
+	// stream the snapshot to the blobstore
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(m.SendSnapshot(record.ID, haves, w))
+	}()
+	err = blobRequest(client, "PUT", baseURL+"/"+record.ID, r, nil)
+	r.Close()
+	if err != nil {
+		m.DestroyVolume(record.ID)
+		return nil, err
+	}
+
+	// add the snapshot to the manifest, removing those which are no longer
+	// needed
+	manifest.Snapshots = append(manifest.Snapshots, record)
+	pruned := manifest.Prune(policy.Retention)
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := blobRequest(client, "PUT", baseURL+"/manifest.json", bytes.NewReader(data), nil); err != nil {
+		return nil, err
+	}
+	for _, s := range pruned {
+		blobRequest(client, "DELETE", baseURL+"/"+s.ID, nil, nil)
+	}
+
+	// only the latest snapshot needs to be kept on this host
+	if prev != nil && m.GetVolume(prev.ID) != nil {
+		m.DestroyVolume(prev.ID)
+	}
+
+	return record, nil
+}
+
+// RestoreVolume creates a new volume using the given provider and receives the
+// snapshots needed to restore it from the blobstore.
+func (m *Manager) RestoreVolume(providerID string, req *volume.RestoreRequest, client *http.Client) (volume.Volume, error) {
+	baseURL := strings.TrimSuffix(req.URL, "/")
+	manifest, err := getSnapshotManifest(client, baseURL)
+	if err != nil {
+		return nil, err
+	} else if manifest == nil {
+		return nil, fmt.Errorf("no snapshots found at %s", baseURL)
+	}
+	chain := manifest.RestoreChain(req.SnapshotID)
+	if chain == nil {
+		return nil, fmt.Errorf("snapshot %q not found at %s", req.SnapshotID, baseURL)
+	}
+
+	vol, err := m.NewVolumeFromProvider(providerID)
+	if err != nil {
+		return nil, err
+	}
+	if kind := vol.Provider().Kind(); kind != manifest.ProviderKind {
+		m.DestroyVolume(vol.Info().ID)
+		return nil, fmt.Errorf("cannot restore %s snapshots using a %s volume provider", manifest.ProviderKind, kind)
+	}
+
+	// the received snapshots are kept so that later ones in the chain can
+	// be applied incrementally
+	for _, s := range chain {
+		if err := blobRequest(client, "GET", baseURL+"/"+s.ID, nil, func(body io.Reader) error {
+			_, err := m.ReceiveSnapshot(vol.Info().ID, body)
+			return err
+		}); err != nil {
+			m.DestroyVolume(vol.Info().ID)
+			return nil, fmt.Errorf("error restoring snapshot %s: %s", s.ID, err)
+		}
+	}
+	return vol, nil
+}
+
+// RunSnapshotPolicies backs up volumes according to their snapshot policies
+// until stop is closed.
+func (m *Manager) RunSnapshotPolicies(client *http.Client, log log15.Logger, stop <-chan struct{}) {
+	// next is when each volume is next due to be backed up, which is
+	// determined from the manifest when first seen
+	next := make(map[string]time.Time)
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		policies := m.SnapshotPolicies()
+		for id := range next {
+			if _, ok := policies[id]; !ok {
+				delete(next, id)
+			}
+		}
+		for id, policy := range policies {
+			log := log.New("volume.id", id)
+			due, ok := next[id]
+			if !ok {
+				due = time.Now()
+				manifest, err := getSnapshotManifest(client, snapshotURL(id, policy))
+				if err != nil {
+					log.Error("error getting snapshot manifest", "err", err)
+					continue
+				}
+				if manifest != nil && len(manifest.Snapshots) > 0 {
+					due = manifest.Snapshots[len(manifest.Snapshots)-1].CreatedAt.Add(policy.Interval)
+				}
+				next[id] = due
+			}
+			if time.Now().Before(due) {
+				continue
+			}
+			log.Info("uploading volume snapshot")
+			snap, err := m.BackupVolume(id, client)
+			if err != nil {
+				log.Error("error uploading volume snapshot", "err", err)
+				if err == ErrDBClosed {
+					return
+				}
+				continue
+			}
+			log.Info("uploaded volume snapshot", "snapshot.id", snap.ID, "full", snap.Full)
+			next[id] = time.Now().Add(policy.Interval)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func snapshotURL(volumeID string, policy *volume.SnapshotPolicy) string {
+	if policy.URL == "" {
+		return volume.DefaultSnapshotURL(volumeID)
+	}
+	return strings.TrimSuffix(policy.URL, "/")
+}
+
+// getSnapshotManifest returns the manifest stored at baseURL, or nil if there
+// isn't one.
+func getSnapshotManifest(client *http.Client, baseURL string) (*volume.SnapshotManifest, error) {
+	var manifest *volume.SnapshotManifest
+	err := blobRequest(client, "GET", baseURL+"/manifest.json", nil, func(body io.Reader) error {
+		manifest = &volume.SnapshotManifest{}
+		return json.NewDecoder(body).Decode(manifest)
+	})
+	if err == errBlobNotFound {
+		return nil, nil
+	}
+	return manifest, err
+}
+
+var errBlobNotFound = errors.New("blob not found")
+
+// blobRequest makes a request to the blobstore, calling handleBody with the
+// response body if it is successful.
+func blobRequest(client *http.Client, method, url string, body io.Reader, handleBody func(io.Reader) error) error {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return errBlobNotFound
+	} else if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s %s", res.StatusCode, method, url)
+	}
+	if handleBody != nil {
+		return handleBody(res.Body)
+	}
+	return nil
+}
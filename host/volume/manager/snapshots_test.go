@@ -0,0 +1,186 @@
+package volumemanager_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/host/volume"
+	"github.com/flynn/flynn/host/volume/dir"
+	"github.com/flynn/flynn/host/volume/manager"
+	. "github.com/flynn/go-check"
+)
+
+type SnapshotTests struct{}
+
+var _ = Suite(&SnapshotTests{})
+
+// blobstore is an in-memory implementation of the blobstore API
+type blobstore struct {
+	mtx   sync.Mutex
+	blobs map[string][]byte
+}
+
+func (b *blobstore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	switch req.Method {
+	case "GET":
+		data, ok := b.blobs[req.URL.Path]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(data)
+	case "PUT":
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		b.blobs[req.URL.Path] = data
+	case "DELETE":
+		delete(b.blobs, req.URL.Path)
+	}
+}
+
+func (b *blobstore) manifest(c *C, path string) *volume.SnapshotManifest {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	manifest := &volume.SnapshotManifest{}
+	c.Assert(json.Unmarshal(b.blobs[path+"/manifest.json"], manifest), IsNil)
+	return manifest
+}
+
+func (b *blobstore) has(path string) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	_, ok := b.blobs[path]
+	return ok
+}
+
+func newDirManager(c *C, root string) *volumemanager.Manager {
+	vman := volumemanager.New(filepath.Join(root, "volumes.bolt"), func() (volume.Provider, error) {
+		return dir.NewProvider(&dir.ProviderConfig{RootDir: filepath.Join(root, "dir")})
+	})
+	c.Assert(vman.OpenDB(), IsNil)
+	return vman
+}
+
+func (SnapshotTests) TestBackupAndRestore(c *C) {
+	root, err := ioutil.TempDir("", "flynn-volume-snapshots-")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(root)
+
+	blobs := &blobstore{blobs: make(map[string][]byte)}
+	srv := httptest.NewServer(blobs)
+	defer srv.Close()
+	client := http.DefaultClient
+
+	vman := newDirManager(c, filepath.Join(root, "host1"))
+	defer vman.CloseDB()
+	vol, err := vman.NewVolume()
+	c.Assert(err, IsNil)
+	id := vol.Info().ID
+
+	// volumes without a policy can't be backed up
+	_, err = vman.BackupVolume(id, client)
+	c.Assert(err, Equals, volumemanager.NoSuchSnapshotPolicy)
+
+	policy := &volume.SnapshotPolicy{
+		Interval:  time.Hour,
+		Retention: 2,
+		URL:       srv.URL + "/snapshots/" + id,
+	}
+	c.Assert(vman.SetSnapshotPolicy(id, policy), IsNil)
+	c.Assert(vman.GetSnapshotPolicy(id), DeepEquals, policy)
+
+	// take three snapshots of changing data, checking only the retained
+	// snapshots are kept in the blobstore and only the latest on the host
+	var snaps []*volume.SnapshotRecord
+	for _, data := range []string{"one", "two", "three"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(vol.Location(), "data"), []byte(data), 0644), IsNil)
+		snap, err := vman.BackupVolume(id, client)
+		c.Assert(err, IsNil)
+		snaps = append(snaps, snap)
+	}
+	manifest := blobs.manifest(c, "/snapshots/"+id)
+	c.Assert(manifest.VolumeID, Equals, id)
+	c.Assert(manifest.ProviderKind, Equals, "dir")
+	c.Assert(manifest.Snapshots, HasLen, 2)
+	c.Assert(manifest.Snapshots[0].ID, Equals, snaps[1].ID)
+	c.Assert(manifest.Snapshots[1].ID, Equals, snaps[2].ID)
+	c.Assert(blobs.has("/snapshots/"+id+"/"+snaps[0].ID), Equals, false)
+	c.Assert(blobs.has("/snapshots/"+id+"/"+snaps[2].ID), Equals, true)
+	c.Assert(vman.GetVolume(snaps[1].ID), IsNil)
+	c.Assert(vman.GetVolume(snaps[2].ID), NotNil)
+
+	// the policy is persisted
+	c.Assert(vman.CloseDB(), IsNil)
+	vman = newDirManager(c, filepath.Join(root, "host1"))
+	c.Assert(vman.GetSnapshotPolicy(id), DeepEquals, policy)
+
+	// restore the latest and a specific snapshot on another host
+	vman2 := newDirManager(c, filepath.Join(root, "host2"))
+	defer vman2.CloseDB()
+	for snapID, data := range map[string]string{"": "three", snaps[1].ID: "two"} {
+		restored, err := vman2.RestoreVolume("default", &volume.RestoreRequest{
+			URL:        policy.URL,
+			SnapshotID: snapID,
+		}, client)
+		c.Assert(err, IsNil)
+		c.Assert(restored.IsSnapshot(), Equals, false)
+		actual, err := ioutil.ReadFile(filepath.Join(restored.Location(), "data"))
+		c.Assert(err, IsNil)
+		c.Assert(string(actual), Equals, data)
+	}
+
+	// pruned snapshots can't be restored
+	_, err = vman2.RestoreVolume("default", &volume.RestoreRequest{
+		URL:        policy.URL,
+		SnapshotID: snaps[0].ID,
+	}, client)
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), "not found"), Equals, true)
+
+	// destroying the volume removes its policy
+	c.Assert(vman.DestroyVolume(id), IsNil)
+	c.Assert(vman.SnapshotPolicies(), HasLen, 0)
+}
+
+func (SnapshotTests) TestManifest(c *C) {
+	manifest := &volume.SnapshotManifest{}
+	for i, full := range []bool{true, false, false, true, false} {
+		manifest.Snapshots = append(manifest.Snapshots, &volume.SnapshotRecord{
+			ID:   string(rune('a' + i)),
+			Full: full,
+		})
+	}
+	ids := func(records []*volume.SnapshotRecord) string {
+		var s string
+		for _, r := range records {
+			s += r.ID
+		}
+		return s
+	}
+
+	// restoring an incremental snapshot requires the snapshots back to
+	// the previous full one
+	c.Assert(ids(manifest.RestoreChain("")), Equals, "de")
+	c.Assert(ids(manifest.RestoreChain("c")), Equals, "abc")
+	c.Assert(ids(manifest.RestoreChain("a")), Equals, "a")
+	c.Assert(manifest.RestoreChain("z"), IsNil)
+
+	// pruning keeps snapshots which retained ones depend on
+	c.Assert(manifest.Prune(5), HasLen, 0)
+	c.Assert(ids(manifest.Prune(3)), Equals, "")
+	c.Assert(ids(manifest.Snapshots), Equals, "abcde")
+	c.Assert(ids(manifest.Prune(2)), Equals, "abc")
+	c.Assert(ids(manifest.Snapshots), Equals, "de")
+}
@@ -0,0 +1,105 @@
+package volume
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SnapshotPolicy configures the host daemon to periodically snapshot a volume
+// and upload the snapshots to the blobstore, so that the volume can be restored
+// on another host with `flynn-host volume restore` if the host is lost.
+type SnapshotPolicy struct {
+	// Interval is the time between snapshots.
+	Interval time.Duration `json:"interval"`
+
+	// Retention is the number of snapshots to keep in the blobstore.
+	// Snapshots are uploaded incrementally, so older snapshots are only
+	// removed once no retained snapshot depends on them.
+	Retention int `json:"retention"`
+
+	// URL is the blobstore directory snapshots are uploaded to, it defaults
+	// to DefaultSnapshotURL for the volume.
+	URL string `json:"url,omitempty"`
+}
+
+// DefaultSnapshotURL returns the blobstore directory snapshots of the given
+// volume are uploaded to if the policy doesn't specify one.
+func DefaultSnapshotURL(volumeID string) string {
+	return "http://blobstore.discoverd/volume-snapshots/" + volumeID
+}
+
+// SnapshotManifest is stored as `manifest.json` in the blobstore directory of a
+// snapshot policy and lists the uploaded snapshots, oldest first. The snapshot
+// streams themselves are stored alongside the manifest, named by snapshot ID.
+type SnapshotManifest struct {
+	VolumeID     string            `json:"volume_id"`
+	ProviderKind string            `json:"provider_kind"`
+	Snapshots    []*SnapshotRecord `json:"snapshots"`
+}
+
+type SnapshotRecord struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Full is true if the snapshot was sent in full, otherwise it was sent
+	// incrementally and restoring it requires first receiving the snapshots
+	// before it back to the previous full one.
+	Full bool `json:"full"`
+
+	// Haves is the result of calling ListHaves on the volume after the
+	// snapshot was taken, which is passed to SendSnapshot for the next
+	// snapshot so that it is sent incrementally.
+	Haves []json.RawMessage `json:"haves,omitempty"`
+}
+
+// RestoreChain returns the snapshots which need to be received in order to
+// restore the snapshot with the given ID (or the latest snapshot if id is
+// empty), or nil if there is no such snapshot.
+func (m *SnapshotManifest) RestoreChain(id string) []*SnapshotRecord {
+	target := len(m.Snapshots) - 1
+	if id != "" {
+		for target >= 0 && m.Snapshots[target].ID != id {
+			target--
+		}
+	}
+	if target < 0 {
+		return nil
+	}
+	start := target
+	for start > 0 && !m.Snapshots[start].Full {
+		start--
+	}
+	if !m.Snapshots[start].Full {
+		return nil
+	}
+	return m.Snapshots[start : target+1]
+}
+
+// Prune removes snapshots from the manifest which are no longer needed to
+// restore the latest retain snapshots, and returns the removed snapshots.
+func (m *SnapshotManifest) Prune(retain int) []*SnapshotRecord {
+	if retain < 1 {
+		retain = 1
+	}
+	oldest := len(m.Snapshots) - retain
+	if oldest <= 0 {
+		return nil
+	}
+	// keep the full snapshot which the oldest retained one depends on
+	for oldest > 0 && !m.Snapshots[oldest].Full {
+		oldest--
+	}
+	removed := m.Snapshots[:oldest]
+	m.Snapshots = append([]*SnapshotRecord(nil), m.Snapshots[oldest:]...)
+	return removed
+}
+
+// RestoreRequest is sent to a host to restore a volume from snapshots
+// uploaded to the blobstore.
+type RestoreRequest struct {
+	// URL is the blobstore directory of the snapshots.
+	URL string `json:"url"`
+
+	// SnapshotID is the snapshot to restore, it defaults to the latest.
+	SnapshotID string `json:"snapshot_id,omitempty"`
+}
@@ -5,7 +5,9 @@ import (
 	"sync"
 
 	"github.com/flynn/flynn/logaggregator/buffer"
+	"github.com/flynn/flynn/logaggregator/store"
 	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	"gopkg.in/inconshreveable/log15.v2"
 )
 
 var errBufferFull = errors.New("feed buffer full")
@@ -15,18 +17,26 @@ type Aggregator struct {
 	bmu     sync.Mutex // protects buffers
 	buffers map[string]*buffer.Buffer
 
+	// store, if set, retains messages on disk beyond what the buffers hold
+	store *store.Store
+
 	msgc chan *rfc5424.Message
 
 	pmu    sync.Mutex
 	pausec chan struct{}
+
+	done chan struct{}
 }
 
-// NewAggregator creates a new running Aggregator.
-func NewAggregator() *Aggregator {
+// NewAggregator creates a new running Aggregator which also appends messages
+// to s if it is not nil.
+func NewAggregator(s *store.Store) *Aggregator {
 	a := &Aggregator{
 		buffers: make(map[string]*buffer.Buffer),
+		store:   s,
 		msgc:    make(chan *rfc5424.Message, 1000),
 		pausec:  make(chan struct{}),
+		done:    make(chan struct{}),
 	}
 	go a.run()
 	return a
@@ -88,6 +98,18 @@ func (a *Aggregator) Reset() {
 func (a *Aggregator) Shutdown() {
 	a.Reset()
 	close(a.msgc)
+	<-a.done
+}
+
+// LoadStore fills the buffers with the most recent stored messages of each
+// channel.
+func (a *Aggregator) LoadStore() error {
+	for _, id := range a.store.Channels() {
+		if err := a.store.Read(id, store.Query{Limit: buffer.DefaultCapacity}, a.getBuffer(id).Add); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Read adds a subscriber channel for id.
@@ -109,6 +131,7 @@ func (a *Aggregator) getBuffer(id string) *buffer.Buffer {
 }
 
 func (a *Aggregator) run() {
+	defer close(a.done)
 	for {
 		select {
 		case msg, ok := <-a.msgc:
@@ -124,7 +147,13 @@ func (a *Aggregator) run() {
 }
 
 func (a *Aggregator) feed(msg *rfc5424.Message) {
-	if err := a.getBuffer(string(msg.AppName)).Add(msg); err != nil {
+	id := string(msg.AppName)
+	if err := a.getBuffer(id).Add(msg); err != nil {
 		panic(err)
 	}
+	if a.store != nil {
+		if err := a.store.Append(id, msg); err != nil {
+			log15.Error("error storing message", "channel", id, "err", err)
+		}
+	}
 }
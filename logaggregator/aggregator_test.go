@@ -8,7 +8,7 @@ import (
 func (s *LogAggregatorTestSuite) TestAggregator(c *C) {
 	data := zip(appAMessages[:100], appCRunMessages, appBJob2Messages, appCWebMessages, appBJob1Messages)
 
-	aggr := NewAggregator()
+	aggr := NewAggregator(nil)
 	defer aggr.Shutdown()
 
 	for _, msg := range data {
@@ -19,14 +19,15 @@ import (
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-func apiHandler(agg *Aggregator, cursors *HostCursors) http.Handler {
-	api := aggregatorAPI{agg, cursors}
+func apiHandler(agg *Aggregator, cursors *HostCursors, drains *drains) http.Handler {
+	api := aggregatorAPI{agg, cursors, drains}
 	r := httprouter.New()
 
 	r.Handler("GET", status.Path, status.HealthyHandler)
 	r.GET("/log/:channel_id", httphelper.WrapHandler(api.GetLog))
 	r.GET("/cursors", httphelper.WrapHandler(api.GetCursors))
 	r.GET("/snapshot", httphelper.WrapHandler(api.GetSnapshot))
+	r.GET("/drains", httphelper.WrapHandler(api.GetDrainStats))
 	return httphelper.ContextInjector(
 		"logaggregator-api",
 		httphelper.NewRequestLogger(r),
@@ -36,12 +37,27 @@ func apiHandler(agg *Aggregator, cursors *HostCursors) http.Handler {
 type aggregatorAPI struct {
 	agg     *Aggregator
 	cursors *HostCursors
+	drains  *drains
 }
 
 func (a *aggregatorAPI) GetCursors(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	httphelper.JSON(w, 200, a.cursors.Get())
 }
 
+// GetDrainStats returns the counters of each log drain, redirecting to the
+// leader if this aggregator is not forwarding messages to the drains.
+func (a *aggregatorAPI) GetDrainStats(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if a.drains == nil {
+		httphelper.JSON(w, 200, []*client.DrainStats{})
+		return
+	}
+	if leader, ok := a.drains.remoteLeader(); ok {
+		http.Redirect(w, req, drainStatsURL(leader), http.StatusTemporaryRedirect)
+		return
+	}
+	httphelper.JSON(w, 200, a.drains.Stats())
+}
+
 func (a *aggregatorAPI) GetLog(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithCancel(ctx)
 	if cn, ok := w.(http.CloseNotifier); ok {
@@ -80,6 +96,20 @@ func (a *aggregatorAPI) GetLog(ctx context.Context, w http.ResponseWriter, req *
 		backlog = lines > 0
 	}
 
+	var since, until time.Time
+	if strSince := req.FormValue("since"); strSince != "" {
+		if since, err = time.Parse(time.RFC3339Nano, strSince); err != nil {
+			httphelper.ValidationError(w, "since", "since must be an RFC3339 timestamp")
+			return
+		}
+	}
+	if strUntil := req.FormValue("until"); strUntil != "" {
+		if until, err = time.Parse(time.RFC3339Nano, strUntil); err != nil {
+			httphelper.ValidationError(w, "until", "until must be an RFC3339 timestamp")
+			return
+		}
+	}
+
 	filters := make(filterSlice, 0)
 	if jobID := req.FormValue("job_id"); jobID != "" {
 		filters = append(filters, filterJobID(jobID))
@@ -88,6 +118,25 @@ func (a *aggregatorAPI) GetLog(ctx context.Context, w http.ResponseWriter, req *
 		val := processTypeVals[len(processTypeVals)-1]
 		filters = append(filters, filterProcessType(val))
 	}
+	if strQuery := req.FormValue("query"); strQuery != "" {
+		query, err := ParseQuery(strQuery)
+		if err != nil {
+			httphelper.ValidationError(w, "query", err.Error())
+			return
+		}
+		filters = append(filters, query.Filter...)
+		// use the narrowest time range if both are given
+		if query.Since.After(since) {
+			since = query.Since
+		}
+		if !query.Until.IsZero() && (until.IsZero() || query.Until.Before(until)) {
+			until = query.Until
+		}
+	}
+	if follow && !until.IsZero() {
+		httphelper.ValidationError(w, "until", "until cannot be used when following")
+		return
+	}
 
 	iter := &Iterator{
 		id:      params.ByName("channel_id"),
@@ -96,6 +145,8 @@ func (a *aggregatorAPI) GetLog(ctx context.Context, w http.ResponseWriter, req *
 		lines:   lines,
 		filter:  filters,
 		donec:   ctx.Done(),
+		since:   since,
+		until:   until,
 	}
 
 	writeMessages(ctx, w, iter.Scan(a.agg))
@@ -136,10 +187,9 @@ func NewMessageFromSyslog(m *rfc5424.Message) client.Message {
 		JobID:       string(jobID),
 		Msg:         string(m.Msg),
 		ProcessType: string(processType),
-		// TODO(bgentry): source is always "app" for now, could be router in future
-		Source:    "app",
-		Stream:    streamName(m.MsgID),
-		Timestamp: m.Timestamp,
+		Source:      sourceName(m.MsgID),
+		Stream:      streamName(m.MsgID),
+		Timestamp:   m.Timestamp,
 	}
 }
 
@@ -156,12 +206,23 @@ func splitProcID(procID []byte) (processType, jobID []byte) {
 	return
 }
 
+// sourceName returns "router" for router access logs, which have a MSGID of
+// "router", and "app" otherwise
+func sourceName(msgID []byte) string {
+	if string(msgID) == "router" {
+		return "router"
+	}
+	return "app"
+}
+
 func streamName(msgID []byte) string {
 	switch string(msgID) {
 	case "ID1":
 		return "stdout"
 	case "ID2":
 		return "stderr"
+	case "router":
+		return "stdout"
 	default:
 		return "unknown"
 	}
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http/httptest"
 	"time"
 
 	"github.com/flynn/flynn/logaggregator/client"
+	"github.com/flynn/flynn/logaggregator/store"
 	"github.com/flynn/flynn/pkg/syslog/rfc5424"
 	"github.com/flynn/flynn/pkg/typeconv"
 	. "github.com/flynn/go-check"
@@ -185,6 +187,184 @@ func (s *LogAggregatorTestSuite) TestAPIGetLogFollow(c *C) {
 	}
 }
 
+func (s *LogAggregatorTestSuite) TestAPIGetLogRange(c *C) {
+	st, err := store.Open(store.Config{Dir: c.MkDir()})
+	c.Assert(err, IsNil)
+	srv := NewServer(ServerConfig{
+		SyslogAddr:  ":0",
+		ApiAddr:     ":0",
+		ServiceName: "test-logaggregator",
+		Store:       st,
+	})
+	defer srv.Shutdown()
+	api := httptest.NewServer(srv.api)
+	defer api.Close()
+	cl, err := client.New(api.URL)
+	c.Assert(err, IsNil)
+
+	// a message an hour for the last couple of days
+	appID := "test-app"
+	base := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+	msgs := make([]*rfc5424.Message, 11)
+	for i := range msgs {
+		msgs[i] = newMessageForApp(appID, "web.1", fmt.Sprintf("log message %d", i))
+		msgs[i].Hostname = []byte("host1")
+		msgs[i].Timestamp = base.Add(time.Duration(i) * time.Hour)
+		msgs[i].StructuredData = []byte(fmt.Sprintf(`[flynn seq="%d"]`, i))
+	}
+	for _, msg := range msgs[:10] {
+		srv.Aggregator.feed(msg)
+	}
+
+	// retained messages are still returned once they are no longer buffered
+	srv.Aggregator.Reset()
+
+	at := func(hours int) time.Time { return base.Add(time.Duration(hours) * time.Hour) }
+	for _, t := range []struct {
+		desc     string
+		opts     client.LogOpts
+		expected []*rfc5424.Message
+	}{
+		{"since", client.LogOpts{Since: at(7)}, msgs[7:10]},
+		{"until", client.LogOpts{Until: at(3)}, msgs[:3]},
+		{"range", client.LogOpts{Since: at(2), Until: at(5)}, msgs[2:5]},
+		{"page back", client.LogOpts{Until: at(5), Lines: typeconv.IntPtr(2)}, msgs[3:5]},
+		{"filter", client.LogOpts{Since: at(8), JobID: "1"}, msgs[8:10]},
+		{"no match", client.LogOpts{Since: at(2), JobID: "2"}, nil},
+	} {
+		c.Logf("%s", t.desc)
+		logrc, err := cl.GetLog(appID, &t.opts)
+		c.Assert(err, IsNil)
+		expected := ""
+		for _, msg := range t.expected {
+			expected += marshalMessage(msg)
+		}
+		assertAllLogsEquals(c, logrc, expected)
+		logrc.Close()
+	}
+
+	// following sends retained messages followed by new ones
+	logrc, err := cl.GetLog(appID, &client.LogOpts{Since: at(8), Follow: true})
+	c.Assert(err, IsNil)
+	defer logrc.Close()
+	lines := make(chan string)
+	go func() {
+		buf := bufio.NewReader(logrc)
+		for {
+			text, err := buf.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- text
+		}
+	}()
+	readLine := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(time.Second):
+			c.Fatal("timed out waiting for log output")
+			return ""
+		}
+	}
+	c.Assert(readLine(), Equals, marshalMessage(msgs[8]))
+	c.Assert(readLine(), Equals, marshalMessage(msgs[9]))
+	srv.Aggregator.feed(msgs[10])
+	c.Assert(readLine(), Equals, marshalMessage(msgs[10]))
+
+	// until cannot be used when following
+	_, err = cl.GetLog(appID, &client.LogOpts{Until: at(1), Follow: true})
+	c.Assert(err, NotNil)
+}
+
+func (s *LogAggregatorTestSuite) TestAPIGetLogQuery(c *C) {
+	appID := "test-app"
+	base := time.Now().UTC().Truncate(time.Hour)
+	msgs := make([]*rfc5424.Message, 6)
+	for i := range msgs {
+		msgs[i] = newMessageForApp(appID, "web.1", fmt.Sprintf("log message %d", i))
+		msgs[i].Timestamp = base.Add(time.Duration(i) * time.Minute)
+		msgs[i].MsgID = []byte("ID1")
+		if i%2 == 1 {
+			msgs[i].MsgID = []byte("ID2")
+		}
+	}
+	for _, msg := range msgs[:4] {
+		s.agg.feed(msg)
+	}
+
+	for _, t := range []struct {
+		desc     string
+		opts     client.LogOpts
+		expected []*rfc5424.Message
+	}{
+		{"text", client.LogOpts{Query: `"message 2"`}, msgs[2:3]},
+		{"stream", client.LogOpts{Query: "stream:stderr"}, []*rfc5424.Message{msgs[1], msgs[3]}},
+		{"regexp and stream", client.LogOpts{Query: "stream:stdout /[1-2]$/"}, msgs[2:3]},
+		{"time range", client.LogOpts{Query: "since:" + base.Add(time.Minute).Format(time.RFC3339)}, msgs[1:4]},
+		{"time range and params", client.LogOpts{
+			Since: base.Add(2 * time.Minute),
+			Query: "since:" + base.Add(time.Minute).Format(time.RFC3339),
+		}, msgs[2:4]},
+		{"query and params", client.LogOpts{ProcessType: typeconv.StringPtr("web"), Query: "severity:err"}, []*rfc5424.Message{msgs[1], msgs[3]}},
+		{"no match", client.LogOpts{Query: "host:host2"}, nil},
+	} {
+		c.Logf("%s", t.desc)
+		logrc, err := s.client.GetLog(appID, &t.opts)
+		c.Assert(err, IsNil)
+		expected := ""
+		for _, msg := range t.expected {
+			expected += marshalMessage(msg)
+		}
+		assertAllLogsEquals(c, logrc, expected)
+		logrc.Close()
+	}
+
+	// the query is also applied when following
+	logrc, err := s.client.GetLog(appID, &client.LogOpts{Query: "stream:stderr", Follow: true, Lines: typeconv.IntPtr(10)})
+	c.Assert(err, IsNil)
+	defer logrc.Close()
+	s.agg.feed(msgs[4])
+	s.agg.feed(msgs[5])
+	buf := bufio.NewReader(logrc)
+	for _, msg := range []*rfc5424.Message{msgs[1], msgs[3], msgs[5]} {
+		line, err := buf.ReadString('\n')
+		c.Assert(err, IsNil)
+		c.Assert(line, Equals, marshalMessage(msg))
+	}
+
+	// invalid queries are rejected, as are query time ranges when following
+	for _, opts := range []client.LogOpts{
+		{Query: "/(/"},
+		{Query: "until:" + base.Format(time.RFC3339), Follow: true},
+	} {
+		_, err = s.client.GetLog(appID, &opts)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *LogAggregatorTestSuite) TestAPIGetLogRangeBuffer(c *C) {
+	appID := "test-app"
+	base := time.Now().UTC().Truncate(time.Hour)
+	msgs := make([]*rfc5424.Message, 5)
+	for i := range msgs {
+		msgs[i] = newMessageForApp(appID, "web.1", fmt.Sprintf("log message %d", i))
+		msgs[i].Timestamp = base.Add(time.Duration(i) * time.Minute)
+		s.agg.feed(msgs[i])
+	}
+
+	// without a store, the time range is applied to the buffer
+	logrc, err := s.client.GetLog(appID, &client.LogOpts{
+		Since: base.Add(time.Minute),
+		Until: base.Add(4 * time.Minute),
+		Lines: typeconv.IntPtr(2),
+	})
+	c.Assert(err, IsNil)
+	defer logrc.Close()
+	assertAllLogsEquals(c, logrc, marshalMessage(msgs[2])+marshalMessage(msgs[3]))
+}
+
 func (s *LogAggregatorTestSuite) TestNewMessageFromSyslog(c *C) {
 	timestamp, err := time.Parse(time.RFC3339Nano, "2009-11-10T23:00:00.123450789Z")
 	c.Assert(err, IsNil)
@@ -204,6 +384,21 @@ func (s *LogAggregatorTestSuite) TestNewMessageFromSyslog(c *C) {
 	c.Assert(m.Source, Equals, "app")
 	c.Assert(m.Stream, Equals, "stdout")
 	c.Assert(m.Timestamp, Equals, timestamp)
+
+	// router access logs
+	m = NewMessageFromSyslog(rfc5424.NewMessage(
+		&rfc5424.Header{
+			Hostname:  []byte("router-flynn-abcd1234"),
+			ProcID:    []byte("router.flynn-abcd1234"),
+			MsgID:     []byte("router"),
+			Timestamp: timestamp,
+		},
+		[]byte("method=GET path=/ status=200"),
+	))
+	c.Assert(m.JobID, Equals, "flynn-abcd1234")
+	c.Assert(m.ProcessType, Equals, "router")
+	c.Assert(m.Source, Equals, "router")
+	c.Assert(m.Stream, Equals, "stdout")
 }
 
 func (s *LogAggregatorTestSuite) TestMessageMarshalJSON(c *C) {
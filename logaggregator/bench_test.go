@@ -14,7 +14,7 @@ func (s *LogAggregatorTestSuite) BenchmarkReplaySnapshot(c *C) {
 	c.ResetTimer()
 	for i := 0; i < c.N; i++ {
 		srv := &Server{
-			Aggregator: NewAggregator(),
+			Aggregator: NewAggregator(nil),
 		}
 
 		srv.LoadSnapshotFile("testdata/sample.dat")
@@ -27,7 +27,7 @@ func (*LogAggregatorTestSuite) BenchmarkTakeSnapshot(c *C) {
 	c.SetBytes(fi.Size())
 
 	srv := &Server{
-		Aggregator: NewAggregator(),
+		Aggregator: NewAggregator(nil),
 	}
 
 	srv.LoadSnapshotFile("testdata/sample.dat")
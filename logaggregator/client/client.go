@@ -57,6 +57,15 @@ func NewWithHTTP(uri string, httpClient *http.Client) (*Client, error) {
 // If lines is above zero, the number of lines returned will be capped at that
 // value. Otherwise, all available logs are returned. If follow is true, new log
 // lines are streamed after the buffered log.
+//
+// If Since or Until are set, only lines with timestamps in [Since, Until) are
+// returned, including retained lines which are no longer buffered. Older
+// lines can be paged through by setting Until to the timestamp of the first
+// line of the previous page.
+//
+// If Query is set, only lines matching the query expression are returned,
+// both from the history and when following (see the logaggregator's Query
+// type for the syntax).
 func (c *Client) GetLog(channelID string, options *LogOpts) (io.ReadCloser, error) {
 	path := fmt.Sprintf("/log/%s", channelID)
 	query := url.Values{}
@@ -74,6 +83,15 @@ func (c *Client) GetLog(channelID string, options *LogOpts) (io.ReadCloser, erro
 		if opts.ProcessType != nil {
 			query.Set("process_type", *opts.ProcessType)
 		}
+		if !opts.Since.IsZero() {
+			query.Set("since", opts.Since.Format(time.RFC3339Nano))
+		}
+		if !opts.Until.IsZero() {
+			query.Set("until", opts.Until.Format(time.RFC3339Nano))
+		}
+		if opts.Query != "" {
+			query.Set("query", opts.Query)
+		}
 	}
 	if encodedQuery := query.Encode(); encodedQuery != "" {
 		path = fmt.Sprintf("%s?%s", path, encodedQuery)
@@ -90,6 +108,9 @@ type LogOpts struct {
 	JobID       string
 	Lines       *int
 	ProcessType *string
+	Since       time.Time
+	Until       time.Time
+	Query       string
 }
 
 // Message represents a single log message.
@@ -124,3 +145,28 @@ func (c *Client) GetSnapshot() (io.ReadCloser, error) {
 	}
 	return res.Body, nil
 }
+
+// DrainStats are counters of the messages forwarded to a log drain by the
+// leader logaggregator.
+type DrainStats struct {
+	ID    string `json:"id"`
+	AppID string `json:"app"`
+	URL   string `json:"url"`
+	// Sent is the number of messages delivered to the drain.
+	Sent uint64 `json:"sent"`
+	// Dropped is the number of messages which were discarded, either
+	// because the drain's buffer was full or because delivery failed
+	// after retrying.
+	Dropped uint64 `json:"dropped"`
+	// Errors is the number of failed delivery attempts.
+	Errors      uint64     `json:"errors"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// GetDrainStats returns the counters for each log drain, which are only
+// tracked by the leader (other instances redirect the request to it).
+func (c *Client) GetDrainStats() ([]*DrainStats, error) {
+	var res []*DrainStats
+	return res, c.Get("/drains", &res)
+}
@@ -0,0 +1,323 @@
+// Package drain forwards app logs to external syslog and HTTP endpoints.
+package drain
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flynn/flynn/logaggregator/client"
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Config is the configuration of a log drain, which forwards the logs of the
+// app with ID AppID to URL.
+type Config struct {
+	ID    string
+	AppID string
+	URL   string
+}
+
+// BufferSize is the number of messages buffered for each drain whilst its
+// endpoint is slow or unavailable, after which new messages are dropped
+// rather than blocking the aggregator.
+const BufferSize = 10000
+
+var (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// ValidateURL returns an error if u is not a supported drain URL, which is
+// either syslog://host:port or syslog+tls://host:port for TCP syslog, or an
+// http or https URL.
+func ValidateURL(u string) error {
+	_, err := parseURL(u)
+	return err
+}
+
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+tls":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return nil, errors.New("syslog drain URLs must include a host and port")
+		}
+	case "http", "https":
+		if u.Host == "" {
+			return nil, errors.New("HTTP drain URLs must include a host")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported drain URL scheme %q, expected syslog, syslog+tls, http or https", u.Scheme)
+	}
+	return u, nil
+}
+
+// Manager forwards messages to the drains of the app which logged them.
+// Messages are only forwarded whilst the manager is active, so that when
+// multiple aggregators receive the same messages only the leader forwards
+// them.
+type Manager struct {
+	// TLSConfig is used to connect to syslog+tls and https drains, using
+	// the default configuration if nil. It must be set before adding
+	// drains.
+	TLSConfig *tls.Config
+
+	mtx    sync.RWMutex
+	drains map[string]*drain
+	apps   map[string]map[string]*drain
+	active bool
+
+	logger log15.Logger
+}
+
+func NewManager(logger log15.Logger) *Manager {
+	return &Manager{
+		drains: make(map[string]*drain),
+		apps:   make(map[string]map[string]*drain),
+		logger: logger,
+	}
+}
+
+// Add starts forwarding messages to the drain, replacing any existing drain
+// with the same ID if its configuration has changed.
+func (m *Manager) Add(conf Config) error {
+	u, err := parseURL(conf.URL)
+	if err != nil {
+		return err
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if d, ok := m.drains[conf.ID]; ok {
+		if d.conf == conf {
+			return nil
+		}
+		m.remove(d)
+	}
+	d := newDrain(conf, u, m.TLSConfig, m.logger)
+	m.drains[conf.ID] = d
+	if _, ok := m.apps[conf.AppID]; !ok {
+		m.apps[conf.AppID] = make(map[string]*drain)
+	}
+	m.apps[conf.AppID][conf.ID] = d
+	return nil
+}
+
+// Remove stops forwarding messages to the drain, discarding any buffered
+// messages.
+func (m *Manager) Remove(id string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if d, ok := m.drains[id]; ok {
+		m.remove(d)
+	}
+}
+
+// RemoveApp removes all of the app's drains.
+func (m *Manager) RemoveApp(appID string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for _, d := range m.apps[appID] {
+		m.remove(d)
+	}
+}
+
+func (m *Manager) remove(d *drain) {
+	delete(m.drains, d.conf.ID)
+	delete(m.apps[d.conf.AppID], d.conf.ID)
+	if len(m.apps[d.conf.AppID]) == 0 {
+		delete(m.apps, d.conf.AppID)
+	}
+	d.close()
+}
+
+// Set replaces the drains with the given ones, leaving those which are
+// unchanged running.
+func (m *Manager) Set(confs []Config) {
+	ids := make(map[string]struct{}, len(confs))
+	for _, conf := range confs {
+		ids[conf.ID] = struct{}{}
+		if err := m.Add(conf); err != nil {
+			m.logger.Error("invalid log drain", "drain.id", conf.ID, "app.id", conf.AppID, "err", err)
+		}
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for id, d := range m.drains {
+		if _, ok := ids[id]; !ok {
+			m.remove(d)
+		}
+	}
+}
+
+// SetActive sets whether messages are forwarded to the drains.
+func (m *Manager) SetActive(active bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if active != m.active {
+		m.logger.Info("setting log drain forwarding state", "active", active)
+	}
+	m.active = active
+}
+
+// Send queues the message to be forwarded to the drains of the app which
+// logged it, dropping it for any drains whose buffer is full.
+func (m *Manager) Send(msg *rfc5424.Message) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	if !m.active {
+		return
+	}
+	for _, d := range m.apps[string(msg.AppName)] {
+		d.send(msg)
+	}
+}
+
+// Stats returns the counters of each drain, ordered by app and drain ID.
+func (m *Manager) Stats() []*client.DrainStats {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	stats := make([]*client.DrainStats, 0, len(m.drains))
+	for _, d := range m.drains {
+		stats = append(stats, d.stats())
+	}
+	sort.Sort(sortStats(stats))
+	return stats
+}
+
+// Close removes all drains.
+func (m *Manager) Close() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for _, d := range m.drains {
+		m.remove(d)
+	}
+}
+
+type sortStats []*client.DrainStats
+
+func (s sortStats) Len() int      { return len(s) }
+func (s sortStats) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortStats) Less(i, j int) bool {
+	if s[i].AppID != s[j].AppID {
+		return s[i].AppID < s[j].AppID
+	}
+	return s[i].ID < s[j].ID
+}
+
+type drain struct {
+	conf      Config
+	url       *url.URL
+	tlsConfig *tls.Config
+	http      *http.Client
+	logger    log15.Logger
+
+	msgs chan *rfc5424.Message
+	done chan struct{}
+
+	// counters, accessed atomically
+	sent    uint64
+	dropped uint64
+	errors  uint64
+
+	errMtx      sync.Mutex
+	lastError   string
+	lastErrorAt time.Time
+}
+
+func newDrain(conf Config, u *url.URL, tlsConfig *tls.Config, logger log15.Logger) *drain {
+	d := &drain{
+		conf:      conf,
+		url:       u,
+		tlsConfig: tlsConfig,
+		logger:    logger.New("drain.id", conf.ID, "app.id", conf.AppID),
+		msgs:      make(chan *rfc5424.Message, BufferSize),
+		done:      make(chan struct{}),
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+tls":
+		go d.runSyslog()
+	default:
+		d.http = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   httpTimeout,
+		}
+		go d.runHTTP()
+	}
+	return d
+}
+
+func (d *drain) send(msg *rfc5424.Message) {
+	select {
+	case d.msgs <- msg:
+	default:
+		atomic.AddUint64(&d.dropped, 1)
+	}
+}
+
+func (d *drain) close() {
+	close(d.done)
+}
+
+func (d *drain) setError(err error) {
+	atomic.AddUint64(&d.errors, 1)
+	d.errMtx.Lock()
+	d.lastError = err.Error()
+	d.lastErrorAt = time.Now().UTC()
+	d.errMtx.Unlock()
+	d.logger.Error("error forwarding logs to drain", "err", err)
+}
+
+func (d *drain) stats() *client.DrainStats {
+	s := &client.DrainStats{
+		ID:      d.conf.ID,
+		AppID:   d.conf.AppID,
+		URL:     d.conf.URL,
+		Sent:    atomic.LoadUint64(&d.sent),
+		Dropped: atomic.LoadUint64(&d.dropped),
+		Errors:  atomic.LoadUint64(&d.errors),
+	}
+	d.errMtx.Lock()
+	defer d.errMtx.Unlock()
+	if d.lastError != "" {
+		t := d.lastErrorAt
+		s.LastError = d.lastError
+		s.LastErrorAt = &t
+	}
+	return s
+}
+
+// backoff doubles the delay between retries up to maxBackoff
+type backoff struct {
+	delay time.Duration
+}
+
+// wait waits before the next retry, returning false if done is closed first
+func (b *backoff) wait(done <-chan struct{}) bool {
+	if b.delay == 0 {
+		b.delay = minBackoff
+	} else if b.delay *= 2; b.delay > maxBackoff {
+		b.delay = maxBackoff
+	}
+	select {
+	case <-time.After(b.delay):
+		return true
+	case <-done:
+		return false
+	}
+}
+
+func (b *backoff) reset() {
+	b.delay = 0
+}
@@ -0,0 +1,119 @@
+package drain
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	"github.com/flynn/flynn/pkg/syslog/rfc6587"
+)
+
+const (
+	// httpBatchSize is the maximum number of messages sent in each request
+	httpBatchSize = 100
+
+	// httpAttempts is the number of times a batch is sent before it is
+	// dropped
+	httpAttempts = 5
+
+	httpTimeout = 30 * time.Second
+)
+
+// httpBatchInterval is how long messages are batched for before being sent
+var httpBatchInterval = time.Second
+
+type statusError int
+
+func (s statusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d", int(s))
+}
+
+// retryable returns whether a request which failed with err should be retried,
+// which is the case unless the endpoint rejected it with a client error
+func retryable(err error) bool {
+	if s, ok := err.(statusError); ok {
+		return s >= 500 || s == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// runHTTP POSTs batches of messages in the same format as Heroku's HTTPS
+// drains: a body of RFC6587 framed messages with an application/logplex-1
+// content type.
+func (d *drain) runHTTP() {
+	batch := make([]*rfc5424.Message, 0, httpBatchSize)
+	var flush <-chan time.Time
+	for {
+		select {
+		case msg := <-d.msgs:
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				flush = time.After(httpBatchInterval)
+			}
+			if len(batch) < httpBatchSize {
+				continue
+			}
+		case <-flush:
+		case <-d.done:
+			return
+		}
+		if !d.postBatch(batch) {
+			return
+		}
+		batch = batch[:0]
+		flush = nil
+	}
+}
+
+// postBatch sends the batch, retrying failed requests, and returns false if
+// the drain was closed whilst waiting to retry
+func (d *drain) postBatch(batch []*rfc5424.Message) bool {
+	var body bytes.Buffer
+	for _, msg := range batch {
+		body.Write(rfc6587.Bytes(msg))
+	}
+	count := uint64(len(batch))
+
+	var b backoff
+	for attempt := 1; ; attempt++ {
+		err := d.post(body.Bytes(), len(batch))
+		if err == nil {
+			atomic.AddUint64(&d.sent, count)
+			return true
+		}
+		d.setError(err)
+		if attempt == httpAttempts || !retryable(err) {
+			atomic.AddUint64(&d.dropped, count)
+			return true
+		}
+		if !b.wait(d.done) {
+			return false
+		}
+	}
+}
+
+func (d *drain) post(body []byte, count int) error {
+	req, err := http.NewRequest("POST", d.url.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/logplex-1")
+	req.Header.Set("Logplex-Msg-Count", strconv.Itoa(count))
+	req.Header.Set("Logplex-Drain-Token", d.conf.ID)
+	res, err := d.http.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return statusError(res.StatusCode)
+	}
+	return nil
+}
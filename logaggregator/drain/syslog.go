@@ -0,0 +1,68 @@
+package drain
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	"github.com/flynn/flynn/pkg/syslog/rfc6587"
+)
+
+const (
+	syslogDialTimeout  = 10 * time.Second
+	syslogWriteTimeout = 10 * time.Second
+)
+
+// runSyslog streams RFC6587 framed messages over a TCP (or TLS) connection,
+// reconnecting and retrying each message until it is written.
+func (d *drain) runSyslog() {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	var b backoff
+	for {
+		var msg *rfc5424.Message
+		select {
+		case msg = <-d.msgs:
+		case <-d.done:
+			return
+		}
+		frame := rfc6587.Bytes(msg)
+		for {
+			var err error
+			if conn == nil {
+				conn, err = d.dialSyslog()
+			}
+			if err == nil {
+				conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+				if _, err = conn.Write(frame); err != nil {
+					conn.Close()
+					conn = nil
+				}
+			}
+			if err == nil {
+				atomic.AddUint64(&d.sent, 1)
+				b.reset()
+				break
+			}
+			d.setError(err)
+			if !b.wait(d.done) {
+				return
+			}
+		}
+	}
+}
+
+func (d *drain) dialSyslog() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	if d.url.Scheme == "syslog+tls" {
+		return tls.DialWithDialer(dialer, "tcp", d.url.Host, d.tlsConfig)
+	}
+	return dialer.Dial("tcp", d.url.Host)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/logaggregator/drain"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// drains forwards messages to log drains whilst this aggregator is the
+// leader, so that each message is only forwarded once even though hosts send
+// them to every aggregator.
+type drains struct {
+	*drain.Manager
+
+	mtx sync.RWMutex
+	// leader is the address of the leader whilst it is another
+	// aggregator
+	leader string
+}
+
+// remoteLeader returns the address of the leader if it is another
+// aggregator.
+func (d *drains) remoteLeader() (string, bool) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+	return d.leader, d.leader != ""
+}
+
+// followLeader activates the drains whenever this aggregator, which is
+// registered with the given address, becomes the leader of the service.
+func (d *drains) followLeader(service discoverd.Service, addr string) {
+	log := log15.New("fn", "followLeader")
+	for {
+		leaders := make(chan *discoverd.Instance)
+		stream, err := service.Leaders(leaders)
+		if err != nil {
+			log.Error("error following leader", "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for leader := range leaders {
+			d.setLeader(leader, addr)
+		}
+		log.Error("leader stream closed", "err", stream.Err())
+		d.setLeader(nil, addr)
+		time.Sleep(time.Second)
+	}
+}
+
+func (d *drains) setLeader(leader *discoverd.Instance, addr string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.leader = ""
+	if leader != nil && leader.Addr != addr {
+		d.leader = leader.Addr
+	}
+	d.SetActive(leader != nil && leader.Addr == addr)
+}
+
+// syncDrains keeps the drains in sync with those configured in the
+// controller, loading them all and then following drain events (reloading
+// them if the event stream fails).
+func syncDrains(client controller.Client, m *drain.Manager) {
+	log := log15.New("fn", "syncDrains")
+	for {
+		if err := streamDrains(client, m); err != nil {
+			log.Error("error syncing log drains", "err", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func streamDrains(client controller.Client, m *drain.Manager) error {
+	events := make(chan *ct.Event)
+	stream, err := client.StreamEvents(ct.StreamEventsOptions{
+		ObjectTypes: []ct.EventType{
+			ct.EventTypeLogDrain,
+			ct.EventTypeLogDrainDeletion,
+			ct.EventTypeAppDeletion,
+		},
+	}, events)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// list the drains once subscribed to events so that changes made in
+	// between are not missed
+	list, err := client.AllLogDrainList()
+	if err != nil {
+		return err
+	}
+	confs := make([]drain.Config, len(list))
+	for i, d := range list {
+		confs[i] = drain.Config{ID: d.ID, AppID: d.AppID, URL: d.URL}
+	}
+	m.Set(confs)
+
+	for event := range events {
+		switch event.ObjectType {
+		case ct.EventTypeLogDrain:
+			var d ct.LogDrain
+			if err := json.Unmarshal(event.Data, &d); err != nil {
+				return err
+			}
+			if err := m.Add(drain.Config{ID: d.ID, AppID: d.AppID, URL: d.URL}); err != nil {
+				log15.Error("invalid log drain", "drain.id", d.ID, "err", err)
+			}
+		case ct.EventTypeLogDrainDeletion:
+			m.Remove(event.ObjectID)
+		case ct.EventTypeAppDeletion:
+			m.RemoveApp(event.AppID)
+		}
+	}
+	return stream.Err()
+}
+
+// drainStatsURL returns the URL of the drain stats of the aggregator with
+// the given syslog address, which serves its API on port 80
+func drainStatsURL(addr string) string {
+	host, _, _ := net.SplitHostPort(addr)
+	return "http://" + host + "/drains"
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"regexp"
+	"strconv"
 
 	"github.com/flynn/flynn/pkg/syslog/rfc5424"
 )
@@ -43,6 +45,89 @@ func filterProcessType(processType string) filterFunc {
 	}
 }
 
+func filterHostID(hostID string) filterFunc {
+	a := []byte(hostID)
+	return func(m *rfc5424.Message) bool {
+		return bytes.Equal(a, m.Hostname)
+	}
+}
+
+func filterStream(stream string) filterFunc {
+	return func(m *rfc5424.Message) bool {
+		return streamName(m.MsgID) == stream
+	}
+}
+
+func filterSubstring(substr string) filterFunc {
+	a := []byte(substr)
+	return func(m *rfc5424.Message) bool {
+		return bytes.Contains(m.Msg, a)
+	}
+}
+
+func filterRegexp(re *regexp.Regexp) filterFunc {
+	return func(m *rfc5424.Message) bool {
+		return re.Match(m.Msg)
+	}
+}
+
+// filterSeverity matches messages which are at least as severe as the given
+// severity (i.e. have a numerically lower or equal severity)
+func filterSeverity(severity int) filterFunc {
+	return func(m *rfc5424.Message) bool {
+		return messageSeverity(m) <= severity
+	}
+}
+
+// severityNames are the RFC5424 severity keywords (with some common
+// alternatives) indexed by severity
+var severityNames = map[string]int{
+	"emerg":     0,
+	"emergency": 0,
+	"alert":     1,
+	"crit":      2,
+	"critical":  2,
+	"err":       3,
+	"error":     3,
+	"warning":   4,
+	"warn":      4,
+	"notice":    5,
+	"info":      6,
+	"debug":     7,
+}
+
+// parseSeverity parses either a severity keyword or number
+func parseSeverity(s string) (int, bool) {
+	if n, ok := severityNames[s]; ok {
+		return n, true
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil && n >= 0 && n <= 7
+}
+
+var severityParam = []byte("severity")
+
+// messageSeverity returns the severity from the message's "severity"
+// structured data parameter if it has one, otherwise assuming that lines
+// written to stderr are errors and other lines are informational (jobs write
+// plain lines, so the severity in the message header isn't meaningful)
+func messageSeverity(m *rfc5424.Message) int {
+	if sd, err := rfc5424.ParseStructuredData(m.StructuredData); err == nil && sd != nil {
+		for _, p := range sd.Params {
+			if !bytes.Equal(p.Name, severityParam) {
+				continue
+			}
+			if n, ok := parseSeverity(string(p.Value)); ok {
+				return n
+			}
+		}
+	}
+	if streamName(m.MsgID) == "stderr" {
+		return severityNames["err"]
+	}
+	return severityNames["info"]
+}
+
 type filterSlice []Filter
 
 func (s filterSlice) Filter(unfiltered []*rfc5424.Message) []*rfc5424.Message {
@@ -1,6 +1,14 @@
 package main
 
-import "github.com/flynn/flynn/pkg/syslog/rfc5424"
+import (
+	"errors"
+	"time"
+
+	"github.com/flynn/flynn/logaggregator/store"
+	"github.com/flynn/flynn/logaggregator/utils"
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	"gopkg.in/inconshreveable/log15.v2"
+)
 
 type Iterator struct {
 	id      string
@@ -9,6 +17,11 @@ type Iterator struct {
 	lines   int
 	filter  Filter
 	donec   <-chan struct{}
+
+	// since and until restrict messages to those with timestamps in
+	// [since, until), and are read from the aggregator's store if it has
+	// one so that history beyond the buffer can be paged through
+	since, until time.Time
 }
 
 func (i *Iterator) Scan(agg *Aggregator) <-chan *rfc5424.Message {
@@ -21,6 +34,11 @@ func (i *Iterator) Scan(agg *Aggregator) <-chan *rfc5424.Message {
 func (i *Iterator) scan(agg *Aggregator, msgc chan<- *rfc5424.Message) {
 	defer close(msgc)
 
+	if !i.since.IsZero() || !i.until.IsZero() {
+		i.scanRange(agg, msgc)
+		return
+	}
+
 	if !i.follow {
 		for _, msg := range i.readLastN(agg) {
 			msgc <- msg
@@ -44,6 +62,90 @@ func (i *Iterator) scan(agg *Aggregator, msgc chan<- *rfc5424.Message) {
 	}
 }
 
+// scanRange sends the last i.lines messages in the time range, or all of them
+// if i.lines is zero, followed by new messages in the range if following.
+func (i *Iterator) scanRange(agg *Aggregator, msgc chan<- *rfc5424.Message) {
+	// subscribe before reading so that no messages are missed in between,
+	// using the host cursors to skip messages which have already been sent
+	var subc <-chan *rfc5424.Message
+	if i.follow {
+		subc = i.subscribe(agg)
+	}
+	cursors := make(utils.CursorSet)
+	send := func(msg *rfc5424.Message) bool {
+		select {
+		case msgc <- msg:
+			return true
+		case <-i.donec:
+			return false
+		}
+	}
+
+	if !i.readRange(agg, func(msg *rfc5424.Message) bool {
+		cursors.Advance(msg)
+		return send(msg)
+	}) || subc == nil {
+		return
+	}
+	for msg := range subc {
+		if !i.inRange(msg) || cursors.Seen(msg) {
+			continue
+		}
+		if !send(msg) {
+			return
+		}
+	}
+}
+
+var errIteratorDone = errors.New("iterator done")
+
+// readRange calls send with the messages in the time range, returning false
+// if send does
+func (i *Iterator) readRange(agg *Aggregator, send func(*rfc5424.Message) bool) bool {
+	if agg.store == nil {
+		for _, msg := range i.reverseFilter(i.rangeFilter(agg.Read(i.id))) {
+			if !send(msg) {
+				return false
+			}
+		}
+		return true
+	}
+
+	q := store.Query{
+		Since:  i.since,
+		Until:  i.until,
+		Filter: i.filter.Match,
+		Limit:  i.lines,
+	}
+	err := agg.store.Read(i.id, q, func(msg *rfc5424.Message) error {
+		if !send(msg) {
+			return errIteratorDone
+		}
+		return nil
+	})
+	if err == errIteratorDone {
+		return false
+	} else if err != nil {
+		log15.Error("error reading stored messages", "channel", i.id, "err", err)
+	}
+	return true
+}
+
+func (i *Iterator) inRange(msg *rfc5424.Message) bool {
+	return (i.since.IsZero() || !msg.Timestamp.Before(i.since)) &&
+		(i.until.IsZero() || msg.Timestamp.Before(i.until))
+}
+
+func (i *Iterator) rangeFilter(unfiltered []*rfc5424.Message) []*rfc5424.Message {
+	messages := make([]*rfc5424.Message, 0, len(unfiltered))
+	for _, msg := range unfiltered {
+		if i.inRange(msg) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
 func (i *Iterator) readLastN(agg *Aggregator) []*rfc5424.Message {
 	if agg == nil {
 		panic("agg is nil")
@@ -91,7 +91,7 @@ func (s *LogAggregatorTestSuite) TestReadLastN(c *C) {
 	}
 
 	for _, test := range tests {
-		aggr := NewAggregator()
+		aggr := NewAggregator(nil)
 		defer aggr.Shutdown()
 
 		for _, msg := range test.data {
@@ -208,7 +208,7 @@ func (s *LogAggregatorTestSuite) TestReadLastNAndSubscribe(c *C) {
 	}
 
 	for _, test := range tests {
-		aggr := NewAggregator()
+		aggr := NewAggregator(nil)
 		defer aggr.Shutdown()
 
 		for _, msg := range test.bufData {
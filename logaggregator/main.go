@@ -5,8 +5,11 @@ import (
 	"net"
 	"os"
 
+	"github.com/flynn/flynn/controller/client"
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/logaggregator/client"
+	"github.com/flynn/flynn/logaggregator/drain"
+	"github.com/flynn/flynn/logaggregator/store"
 	"github.com/flynn/flynn/pkg/shutdown"
 
 	"gopkg.in/inconshreveable/log15.v2"
@@ -22,6 +25,9 @@ func main() {
 
 	logAddr := flag.String("logaddr", ":3000", "syslog input listen address")
 	apiAddr := flag.String("apiaddr", ":"+apiPort, "api listen address")
+	dataDir := flag.String("datadir", "", "directory to retain logs in beyond the in-memory buffers (disabled if empty)")
+	retentionAge := flag.Duration("retention-age", store.DefaultMaxAge, "maximum age of retained logs for each app")
+	retentionSize := flag.Int64("retention-size", store.DefaultMaxSize, "maximum size in bytes of retained logs for each app")
 	flag.Parse()
 
 	conf := ServerConfig{
@@ -31,27 +37,41 @@ func main() {
 		ServiceName: "logaggregator",
 	}
 
+	if *dataDir != "" {
+		s, err := store.Open(store.Config{
+			Dir:       *dataDir,
+			Retention: store.Retention{MaxAge: *retentionAge, MaxSize: *retentionSize},
+		})
+		if err != nil {
+			shutdown.Fatal(err)
+		}
+		conf.Store = s
+	}
+
+	// forward logs to the drains configured in the controller if it can
+	// be accessed
+	if key := os.Getenv("CONTROLLER_KEY"); key != "" {
+		cc, err := controller.NewClient("", key)
+		if err != nil {
+			shutdown.Fatal(err)
+		}
+		conf.Drains = drain.NewManager(log15.New("component", "drains"))
+		go syncDrains(cc, conf.Drains)
+	}
+
 	srv := NewServer(conf)
 	shutdown.BeforeExit(srv.Shutdown)
 
-	// get leader for snapshot (if any)
-	leader, err := conf.Discoverd.Service(conf.ServiceName).Leader()
-	if err == nil {
-		host, _, _ := net.SplitHostPort(leader.Addr)
-		log15.Info("loading snapshot from leader", "leader", host)
-
-		c, _ := client.New("http://" + host)
-		snapshot, err := c.GetSnapshot()
-		if err == nil {
-			if err := srv.LoadSnapshot(snapshot); err != nil {
-				log15.Error("error receiving snapshot from leader", "error", err)
-			}
-			snapshot.Close()
-		} else {
-			log15.Error("error getting snapshot from leader", "error", err)
+	// load retained logs, or a snapshot from the leader if there are none
+	var loaded bool
+	if conf.Store != nil {
+		var err error
+		if loaded, err = srv.LoadStore(); err != nil {
+			shutdown.Fatal(err)
 		}
-	} else {
-		log15.Info("error finding leader for snapshot", "error", err)
+	}
+	if !loaded {
+		loadLeaderSnapshot(srv, conf)
 	}
 
 	if err := srv.Start(); err != nil {
@@ -59,3 +79,24 @@ func main() {
 	}
 	<-make(chan struct{})
 }
+
+func loadLeaderSnapshot(srv *Server, conf ServerConfig) {
+	leader, err := conf.Discoverd.Service(conf.ServiceName).Leader()
+	if err != nil {
+		log15.Info("error finding leader for snapshot", "error", err)
+		return
+	}
+	host, _, _ := net.SplitHostPort(leader.Addr)
+	log15.Info("loading snapshot from leader", "leader", host)
+
+	c, _ := client.New("http://" + host)
+	snapshot, err := c.GetSnapshot()
+	if err != nil {
+		log15.Error("error getting snapshot from leader", "error", err)
+		return
+	}
+	defer snapshot.Close()
+	if err := srv.LoadSnapshot(snapshot); err != nil {
+		log15.Error("error receiving snapshot from leader", "error", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Query is a parsed log query, which is a list of terms separated by
+// whitespace which messages must all match:
+//
+//	text or "quoted text"   the message contains the text
+//	/regexp/                the message matches the regular expression
+//	stream:<stream>         the message was written to stdout or stderr
+//	host:<id>               the message was logged on the host
+//	type:<type>             the message was logged by a job of the type
+//	job:<id>                the message was logged by the job
+//	severity:<level>        the message is at least as severe as the level
+//	                        (e.g. "warning" also matches "err")
+//	since:<time>            the message was logged at or after the time
+//	until:<time>            the message was logged before the time
+//
+// Times are RFC3339 timestamps. Quotes and slashes can be included in quoted
+// text and regular expressions by escaping them with a backslash.
+type Query struct {
+	Filter       filterSlice
+	Since, Until time.Time
+}
+
+func ParseQuery(s string) (*Query, error) {
+	terms, err := splitQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{}
+	for _, t := range terms {
+		if t.regexp {
+			re, err := regexp.Compile(t.text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression /%s/: %s", t.text, err)
+			}
+			q.Filter = append(q.Filter, filterRegexp(re))
+			continue
+		}
+		field, value := "", t.text
+		if !t.quoted {
+			if i := strings.Index(t.text, ":"); i > 0 {
+				field, value = t.text[:i], t.text[i+1:]
+			}
+		}
+		switch field {
+		case "stream":
+			if value != "stdout" && value != "stderr" {
+				return nil, fmt.Errorf("invalid stream %q, expected stdout or stderr", value)
+			}
+			q.Filter = append(q.Filter, filterStream(value))
+		case "host":
+			q.Filter = append(q.Filter, filterHostID(value))
+		case "type":
+			q.Filter = append(q.Filter, filterProcessType(value))
+		case "job":
+			q.Filter = append(q.Filter, filterJobID(value))
+		case "severity":
+			severity, ok := parseSeverity(value)
+			if !ok {
+				return nil, fmt.Errorf("invalid severity %q", value)
+			}
+			q.Filter = append(q.Filter, filterSeverity(severity))
+		case "since", "until":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s time %q, expected an RFC3339 timestamp", field, value)
+			}
+			if field == "since" {
+				q.Since = t
+			} else {
+				q.Until = t
+			}
+		default:
+			// terms with unknown fields (e.g. URLs) are matched as text
+			q.Filter = append(q.Filter, filterSubstring(t.text))
+		}
+	}
+	return q, nil
+}
+
+type queryTerm struct {
+	text   string
+	quoted bool
+	regexp bool
+}
+
+func splitQuery(s string) ([]queryTerm, error) {
+	var terms []queryTerm
+	for i := 0; i < len(s); {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n':
+			i++
+		case '"', '/':
+			end := closingQuote(s, i+1, c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %c in query", c)
+			}
+			if c == '"' {
+				text := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s[i+1 : end])
+				terms = append(terms, queryTerm{text: text, quoted: true})
+			} else {
+				// leave escaped slashes for the regexp package to
+				// unescape
+				terms = append(terms, queryTerm{text: s[i+1 : end], regexp: true})
+			}
+			i = end + 1
+		default:
+			end := strings.IndexAny(s[i:], " \t\n")
+			if end < 0 {
+				end = len(s)
+			} else {
+				end += i
+			}
+			terms = append(terms, queryTerm{text: s[i:end]})
+			i = end
+		}
+	}
+	return terms, nil
+}
+
+// closingQuote returns the index of the first unescaped quote character q in
+// s at or after i, or -1 if there isn't one
+func closingQuote(s string, i int, q byte) int {
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case q:
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	. "github.com/flynn/go-check"
+)
+
+func (s *LogAggregatorTestSuite) TestParseQuery(c *C) {
+	newMsg := func(host, procID, msgID, sd, text string) *rfc5424.Message {
+		m := newMessageForApp("app", procID, text)
+		m.Hostname = []byte(host)
+		m.MsgID = []byte(msgID)
+		if sd != "" {
+			m.StructuredData = []byte(sd)
+		}
+		return m
+	}
+	stdout := newMsg("host1", "web.job1", "ID1", "", "GET /foo/bar 200")
+	stderr := newMsg("host1", "web.job1", "ID2", "", "connection refused")
+	warning := newMsg("host2", "worker.job2", "ID1", `[flynn seq="1" severity="warning"]`, "retrying \"job\"")
+	debug := newMsg("host2", "worker.job2", "ID2", `[flynn seq="1" severity="debug"]`, "GET /foo/baz 404")
+	msgs := []*rfc5424.Message{stdout, stderr, warning, debug}
+
+	for _, t := range []struct {
+		query    string
+		expected []*rfc5424.Message
+	}{
+		{"", msgs},
+		{"GET", []*rfc5424.Message{stdout, debug}},
+		{"GET 404", []*rfc5424.Message{debug}},
+		{`"connection refused"`, []*rfc5424.Message{stderr}},
+		{`"retrying \"job\""`, []*rfc5424.Message{warning}},
+		{`"stream:stdout"`, nil},
+		{`/^GET \/foo\/ba[rz] [0-9]+$/`, []*rfc5424.Message{stdout, debug}},
+		{`/refused|404/`, []*rfc5424.Message{stderr, debug}},
+		{"stream:stdout", []*rfc5424.Message{stdout, warning}},
+		{"stream:stderr GET", []*rfc5424.Message{debug}},
+		{"host:host2", []*rfc5424.Message{warning, debug}},
+		{"type:web", []*rfc5424.Message{stdout, stderr}},
+		{"job:job2", []*rfc5424.Message{warning, debug}},
+		{"severity:err", []*rfc5424.Message{stderr}},
+		{"severity:warning", []*rfc5424.Message{stderr, warning}},
+		{"severity:7", msgs},
+		{"http://example.com", nil},
+	} {
+		q, err := ParseQuery(t.query)
+		c.Assert(err, IsNil, Commentf("query = %q", t.query))
+		var matched []*rfc5424.Message
+		for _, m := range msgs {
+			if q.Filter.Match(m) {
+				matched = append(matched, m)
+			}
+		}
+		c.Assert(matched, DeepEquals, t.expected, Commentf("query = %q", t.query))
+	}
+
+	q, err := ParseQuery("since:2016-03-01T12:00:00Z until:2016-03-01T13:00:00.5Z")
+	c.Assert(err, IsNil)
+	c.Assert(q.Filter, HasLen, 0)
+	c.Assert(q.Since.Equal(time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)), Equals, true)
+	c.Assert(q.Until.Equal(time.Date(2016, 3, 1, 13, 0, 0, 5e8, time.UTC)), Equals, true)
+
+	for _, query := range []string{
+		`"unterminated`,
+		"/unterminated",
+		"/(/",
+		"stream:stdin",
+		"severity:loud",
+		"severity:8",
+		"since:yesterday",
+	} {
+		_, err := ParseQuery(query)
+		c.Assert(err, NotNil, Commentf("query = %q", query))
+	}
+}
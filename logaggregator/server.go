@@ -9,7 +9,9 @@ import (
 	"sync"
 
 	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/logaggregator/drain"
 	"github.com/flynn/flynn/logaggregator/snapshot"
+	"github.com/flynn/flynn/logaggregator/store"
 	"github.com/flynn/flynn/logaggregator/utils"
 	"github.com/flynn/flynn/pkg/keepalive"
 	"github.com/flynn/flynn/pkg/syslog/rfc6587"
@@ -20,6 +22,8 @@ type Server struct {
 	*Aggregator
 	Cursors *HostCursors
 
+	drains *drains
+
 	conf ServerConfig
 
 	syslogListener net.Listener
@@ -40,16 +44,28 @@ type ServerConfig struct {
 
 	ServiceName string
 	Discoverd   *discoverd.Client
+
+	// Store, if set, retains messages on disk and is closed on shutdown
+	Store *store.Store
+
+	// Drains, if set, receives messages to forward to log drains whilst
+	// this aggregator is the leader
+	Drains *drain.Manager
 }
 
 func NewServer(conf ServerConfig) *Server {
-	a := NewAggregator()
+	a := NewAggregator(conf.Store)
 	c := NewHostCursors()
+	var d *drains
+	if conf.Drains != nil {
+		d = &drains{Manager: conf.Drains}
+	}
 	return &Server{
 		Aggregator: a,
 		Cursors:    c,
+		drains:     d,
 		conf:       conf,
-		api:        apiHandler(a, c),
+		api:        apiHandler(a, c, d),
 		shutdown:   make(chan struct{}),
 		syslogDone: make(chan struct{}),
 	}
@@ -82,6 +98,32 @@ func (s *Server) Shutdown() {
 
 	// shutdown aggregator
 	s.Aggregator.Shutdown()
+
+	if s.drains != nil {
+		s.drains.Close()
+	}
+
+	if s.conf.Store != nil {
+		if err := s.conf.Store.Close(); err != nil {
+			log15.Error("store shutdown error", "err", err)
+		}
+	}
+}
+
+// LoadStore fills the buffers with the most recent stored messages and sets
+// the cursors to the latest stored message from each host so that hosts
+// resume streaming from there. It returns false if the store is empty.
+func (s *Server) LoadStore() (bool, error) {
+	if len(s.conf.Store.Channels()) == 0 {
+		return false, nil
+	}
+	if err := s.Aggregator.LoadStore(); err != nil {
+		return false, err
+	}
+	for host, cursor := range s.conf.Store.Cursors() {
+		s.Cursors.Update(host, cursor)
+	}
+	return true, nil
 }
 
 func (s *Server) LoadSnapshotFile(path string) error {
@@ -141,6 +183,9 @@ func (s *Server) Start() error {
 		if err != nil {
 			return err
 		}
+		if s.drains != nil {
+			go s.drains.followLeader(s.conf.Discoverd.Service(s.conf.ServiceName), s.hb.Addr())
+		}
 	}
 
 	go s.runSyslog()
@@ -191,6 +236,9 @@ func (s *Server) drainSyslogConn(conn net.Conn) {
 		} else {
 			s.Cursors.Update(string(msg.Hostname), cursor)
 			s.Aggregator.Feed(msg)
+			if s.drains != nil {
+				s.drains.Send(msg)
+			}
 		}
 		if s.testMessageHook != nil {
 			s.testMessageHook <- struct{}{}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"net"
 	"time"
 
+	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/logaggregator/client"
+	"github.com/flynn/flynn/logaggregator/drain"
+	"github.com/flynn/flynn/logaggregator/store"
 	"github.com/flynn/flynn/logaggregator/utils"
 	"github.com/flynn/flynn/pkg/syslog/rfc5424"
 	"github.com/flynn/flynn/pkg/syslog/rfc6587"
 	. "github.com/flynn/go-check"
+	"gopkg.in/inconshreveable/log15.v2"
 )
 
 const (
@@ -103,6 +108,140 @@ func (s *ServerTestSuite) TestHostCursors(c *C) {
 	})
 }
 
+func (s *ServerTestSuite) TestLoadStore(c *C) {
+	dir := c.MkDir()
+	newServer := func() *Server {
+		st, err := store.Open(store.Config{Dir: dir})
+		c.Assert(err, IsNil)
+		return NewServer(ServerConfig{
+			SyslogAddr:  ":0",
+			ApiAddr:     ":0",
+			ServiceName: "test-logaggregator",
+			Store:       st,
+		})
+	}
+
+	// nothing is loaded from an empty store
+	srv := newServer()
+	loaded, err := srv.LoadStore()
+	c.Assert(err, IsNil)
+	c.Assert(loaded, Equals, false)
+
+	now := time.Now().UTC()
+	msgs := make([]*rfc5424.Message, 3)
+	for i := range msgs {
+		msgs[i] = newSeqMessage("host1", i+1, 0)
+		msgs[i].Timestamp = now.Add(time.Duration(i) * time.Second)
+		srv.Aggregator.feed(msgs[i])
+	}
+	srv.Shutdown()
+
+	// the buffers and cursors are restored after a restart
+	srv = newServer()
+	defer srv.Shutdown()
+	loaded, err = srv.LoadStore()
+	c.Assert(err, IsNil)
+	c.Assert(loaded, Equals, true)
+	got := srv.Aggregator.Read("foo")
+	c.Assert(got, HasLen, len(msgs))
+	for i, msg := range got {
+		c.Assert(msg.Msg, DeepEquals, msgs[i].Msg)
+		c.Assert(msg.Timestamp.Equal(msgs[i].Timestamp), Equals, true)
+	}
+	cursor := srv.Cursors.Get()["host1"]
+	c.Assert(cursor, NotNil)
+	c.Assert(cursor.Seq, Equals, uint64(3))
+	c.Assert(cursor.Time.Equal(msgs[2].Timestamp), Equals, true)
+}
+
+func (s *ServerTestSuite) TestServerDrains(c *C) {
+	// a local syslog listener to act as the drain
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	received := make(chan *rfc5424.Message)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		sc.Split(rfc6587.Split)
+		for sc.Scan() {
+			msg, err := rfc5424.Parse(sc.Bytes())
+			if err != nil {
+				return
+			}
+			received <- msg
+		}
+	}()
+
+	m := drain.NewManager(log15.New())
+	c.Assert(m.Add(drain.Config{ID: "drain1", AppID: "app-A", URL: "syslog://" + l.Addr().String()}), IsNil)
+	srv := NewServer(ServerConfig{
+		SyslogAddr:  ":0",
+		ApiAddr:     ":0",
+		ServiceName: "test-logaggregator",
+		Drains:      m,
+	})
+	srv.testMessageHook = make(chan struct{}, 1)
+	c.Assert(srv.Start(), IsNil)
+	defer srv.Shutdown()
+	cl := testClient(c, srv)
+
+	conn, err := net.Dial("tcp", srv.SyslogAddr().String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// messages are only forwarded whilst this aggregator is the leader
+	self := &discoverd.Instance{Addr: "10.0.0.1:514"}
+	srv.drains.setLeader(self, self.Addr)
+	write := func(msg *rfc5424.Message) {
+		conn.Write(rfc6587.Bytes(msg))
+		<-srv.testMessageHook
+	}
+	for _, msg := range []*rfc5424.Message{appBJob1Messages[0], appAMessages[0], appAMessages[1]} {
+		write(msg)
+	}
+	for _, want := range appAMessages[:2] {
+		select {
+		case got := <-received:
+			c.Assert(string(got.AppName), Equals, "app-A")
+			c.Assert(string(got.Msg), Equals, string(want.Msg))
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for drained message")
+		}
+	}
+	var stats []*client.DrainStats
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		stats, err = cl.GetDrainStats()
+		c.Assert(err, IsNil)
+		c.Assert(stats, HasLen, 1)
+		if stats[0].Sent == 2 {
+			break
+		}
+	}
+	c.Assert(stats[0].ID, Equals, "drain1")
+	c.Assert(stats[0].Sent, Equals, uint64(2))
+
+	// followers don't forward messages, and redirect stats requests to
+	// the leader
+	srv.drains.setLeader(&discoverd.Instance{Addr: "10.0.0.2:514"}, self.Addr)
+	leader, ok := srv.drains.remoteLeader()
+	c.Assert(ok, Equals, true)
+	c.Assert(drainStatsURL(leader), Equals, "http://10.0.0.2/drains")
+	write(appAMessages[2])
+	srv.drains.setLeader(self, self.Addr)
+	write(appAMessages[3])
+	select {
+	case got := <-received:
+		c.Assert(string(got.Msg), Equals, string(appAMessages[3].Msg))
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for drained message")
+	}
+}
+
 func newSeqMessage(hostname string, seq, timeDiff int) *rfc5424.Message {
 	m := rfc5424.NewMessage(
 		&rfc5424.Header{
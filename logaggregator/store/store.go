@@ -0,0 +1,673 @@
+// Package store implements disk-backed retention of log messages beyond what
+// the in-memory buffers hold.
+//
+// The messages of each channel are appended to a sequence of segment files in
+// a directory named after the channel. Once the active segment reaches
+// SegmentSize or SegmentDuration it is sealed by renaming it to include the
+// time range of the messages it contains, so that queries only read the
+// segments which overlap the requested range. Sealed segments are removed
+// when they fall outside of the retention policy.
+package store
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/logaggregator/utils"
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	"github.com/flynn/flynn/pkg/syslog/rfc6587"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	DefaultSegmentSize     = 16 << 20
+	DefaultSegmentDuration = time.Hour
+	DefaultMaxAge          = 7 * 24 * time.Hour
+	DefaultMaxSize         = 1 << 30
+
+	// expireInterval is how often the retention policy is applied
+	expireInterval = time.Minute
+
+	segmentExt = ".log"
+)
+
+var ErrClosed = errors.New("store: closed")
+
+// Retention is the retention policy applied to each channel. Segments are
+// removed once all of their messages are older than MaxAge, and the oldest
+// segments are removed while the total size of a channel exceeds MaxSize.
+type Retention struct {
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+type Config struct {
+	// Dir is the directory the segments are stored in, it is created if
+	// it does not exist.
+	Dir string
+
+	// SegmentSize and SegmentDuration are the maximum size and age of the
+	// active segment of a channel before it is sealed and a new one is
+	// started. They default to DefaultSegmentSize and
+	// DefaultSegmentDuration.
+	SegmentSize     int64
+	SegmentDuration time.Duration
+
+	// Retention defaults to DefaultMaxAge and DefaultMaxSize.
+	Retention Retention
+}
+
+// Store is an on-disk log store, safe for concurrent use.
+type Store struct {
+	conf Config
+
+	mtx      sync.Mutex // protects channels and closed
+	channels map[string]*channel
+	closed   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens the store in conf.Dir, sealing any segments which were left
+// active when the store was last closed, and starts applying the retention
+// policy.
+func Open(conf Config) (*Store, error) {
+	if conf.SegmentSize == 0 {
+		conf.SegmentSize = DefaultSegmentSize
+	}
+	if conf.SegmentDuration == 0 {
+		conf.SegmentDuration = DefaultSegmentDuration
+	}
+	if conf.Retention.MaxAge == 0 {
+		conf.Retention.MaxAge = DefaultMaxAge
+	}
+	if conf.Retention.MaxSize == 0 {
+		conf.Retention.MaxSize = DefaultMaxSize
+	}
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(conf.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		conf:     conf,
+		channels: make(map[string]*channel),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		id, err := base64.RawURLEncoding.DecodeString(e.Name())
+		if err != nil {
+			continue
+		}
+		ch, err := openChannel(string(id), filepath.Join(conf.Dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		s.channels[ch.id] = ch
+	}
+	go s.expireLoop()
+	return s, nil
+}
+
+// Close seals the active segments and stops applying the retention policy.
+func (s *Store) Close() error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mtx.Unlock()
+
+	close(s.stop)
+	<-s.done
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var err error
+	for _, ch := range s.channels {
+		ch.mtx.Lock()
+		if e := ch.seal(); e != nil && err == nil {
+			err = e
+		}
+		ch.mtx.Unlock()
+	}
+	return err
+}
+
+// Channels returns the IDs of the channels which have stored messages.
+func (s *Store) Channels() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	ids := make([]string, 0, len(s.channels))
+	for id := range s.channels {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Cursors returns the latest cursor stored from each host, which is where
+// hosts should resume streaming from after a restart.
+func (s *Store) Cursors() map[string]*utils.HostCursor {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	res := make(map[string]*utils.HostCursor)
+	for _, ch := range s.channels {
+		ch.mtx.RLock()
+		for host, c := range ch.cursors {
+			if curr, ok := res[host]; !ok || c.After(*curr) {
+				res[host] = c
+			}
+		}
+		ch.mtx.RUnlock()
+	}
+	return res
+}
+
+// Append appends msg to the channel with the given ID. Messages from a host
+// which are not after the last message stored from that host before the store
+// was opened are dropped, so that messages resent when a host resumes
+// streaming from an older cursor are not stored twice.
+func (s *Store) Append(id string, msg *rfc5424.Message) error {
+	ch, err := s.channel(id, true)
+	if err != nil {
+		return err
+	}
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+	return ch.append(msg, time.Now(), &s.conf)
+}
+
+// Query selects messages to read from a channel.
+type Query struct {
+	// Since and Until restrict the messages to those with timestamps in
+	// [Since, Until), a zero time leaves that end of the range open.
+	Since, Until time.Time
+
+	// Filter, if set, restricts the messages to those it returns true for.
+	Filter func(*rfc5424.Message) bool
+
+	// Limit, if above zero, restricts the messages to the last Limit
+	// matching messages.
+	Limit int
+}
+
+func (q *Query) match(msg *rfc5424.Message) bool {
+	if !q.Since.IsZero() && msg.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !msg.Timestamp.Before(q.Until) {
+		return false
+	}
+	return q.Filter == nil || q.Filter(msg)
+}
+
+func (q *Query) overlaps(seg *segment) bool {
+	if !q.Since.IsZero() && seg.end.Before(q.Since) {
+		return false
+	}
+	return q.Until.IsZero() || seg.start.Before(q.Until)
+}
+
+// Read calls fn with each message in the channel with the given ID which
+// matches q, in timestamp order. Reading stops at the first error returned by
+// fn, which is returned.
+//
+// Segments are read in the order they were written and messages are sorted
+// within each segment, so messages which arrive more than SegmentDuration
+// after their timestamp may be returned out of order.
+func (s *Store) Read(id string, q Query, fn func(*rfc5424.Message) error) error {
+	ch, err := s.channel(id, false)
+	if err != nil || ch == nil {
+		return err
+	}
+
+	// open the segments while holding the lock so that they are still
+	// readable if they are sealed or removed while being read
+	ch.mtx.RLock()
+	var files []*segmentFile
+	for _, seg := range ch.all() {
+		if seg.size == 0 || !q.overlaps(seg) {
+			continue
+		}
+		f, err := os.Open(seg.path)
+		if err != nil {
+			ch.mtx.RUnlock()
+			closeSegmentFiles(files)
+			return err
+		}
+		files = append(files, &segmentFile{File: f, size: seg.size})
+	}
+	ch.mtx.RUnlock()
+	defer closeSegmentFiles(files)
+
+	if q.Limit <= 0 {
+		for _, f := range files {
+			msgs, err := f.read(&q)
+			if err != nil {
+				return err
+			}
+			for _, msg := range msgs {
+				if err := fn(msg); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	// read backwards from the newest segment until enough messages have
+	// been found
+	var msgs []*rfc5424.Message
+	for i := len(files) - 1; i >= 0 && len(msgs) < q.Limit; i-- {
+		segMsgs, err := files[i].read(&q)
+		if err != nil {
+			return err
+		}
+		msgs = append(segMsgs, msgs...)
+	}
+	if len(msgs) > q.Limit {
+		msgs = msgs[len(msgs)-q.Limit:]
+	}
+	for _, msg := range msgs {
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Size returns the total size of the segments of the channel with the given
+// ID.
+func (s *Store) Size(id string) int64 {
+	ch, _ := s.channel(id, false)
+	if ch == nil {
+		return 0
+	}
+	ch.mtx.RLock()
+	defer ch.mtx.RUnlock()
+	return ch.size()
+}
+
+// Expire applies the retention policy to all channels, sealing active
+// segments which have reached SegmentDuration so that channels which are no
+// longer written to also expire.
+func (s *Store) Expire(now time.Time) error {
+	s.mtx.Lock()
+	channels := make([]*channel, 0, len(s.channels))
+	for _, ch := range s.channels {
+		channels = append(channels, ch)
+	}
+	s.mtx.Unlock()
+
+	var err error
+	for _, ch := range channels {
+		ch.mtx.Lock()
+		if e := ch.expire(now, &s.conf); e != nil && err == nil {
+			err = e
+		}
+		ch.mtx.Unlock()
+	}
+	return err
+}
+
+func (s *Store) expireLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Expire(time.Now()); err != nil {
+				log15.Error("error expiring log segments", "err", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Store) channel(id string, create bool) (*channel, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if ch, ok := s.channels[id]; ok || !create {
+		return ch, nil
+	}
+	dir := filepath.Join(s.conf.Dir, base64.RawURLEncoding.EncodeToString([]byte(id)))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	ch := &channel{id: id, dir: dir, cursors: make(utils.CursorSet)}
+	s.channels[id] = ch
+	return ch, nil
+}
+
+type channel struct {
+	id  string
+	dir string
+
+	mtx      sync.RWMutex // protects the following
+	segments []*segment   // sealed segments, oldest first
+	active   *segment
+
+	// cursors is the latest cursor stored from each host, and opened is
+	// the same as of when the store was opened
+	cursors utils.CursorSet
+	opened  utils.CursorSet
+}
+
+func openChannel(id, dir string) (*channel, error) {
+	ch := &channel{id: id, dir: dir, cursors: make(utils.CursorSet), opened: make(utils.CursorSet)}
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range names {
+		seg, sealed, err := parseSegmentPath(path)
+		if err != nil {
+			log15.Error("ignoring invalid log segment", "path", path, "err", err)
+			continue
+		}
+		if !sealed {
+			if seg, err = recoverSegment(seg); err != nil {
+				return nil, err
+			} else if seg == nil {
+				continue
+			}
+		}
+		ch.segments = append(ch.segments, seg)
+	}
+	sort.Sort(segmentsByCreated(ch.segments))
+
+	// the cursors are recovered from the newest segment, which contains
+	// the latest message from any host which is still streaming
+	if n := len(ch.segments); n > 0 {
+		f, err := os.Open(ch.segments[n-1].path)
+		if err != nil {
+			return nil, err
+		}
+		_, err = (&segmentFile{File: f, size: -1}).scan(func(msg *rfc5424.Message) {
+			ch.cursors.Advance(msg)
+			ch.opened.Advance(msg)
+		})
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ch, nil
+}
+
+// all returns the sealed segments followed by the active segment, if any
+func (c *channel) all() []*segment {
+	if c.active == nil {
+		return c.segments
+	}
+	return append(c.segments[:len(c.segments):len(c.segments)], c.active)
+}
+
+func (c *channel) size() int64 {
+	var size int64
+	for _, seg := range c.all() {
+		size += seg.size
+	}
+	return size
+}
+
+func (c *channel) append(msg *rfc5424.Message, now time.Time, conf *Config) error {
+	if c.opened.Seen(msg) {
+		return nil
+	}
+	if c.active != nil && (c.active.size >= conf.SegmentSize || now.Sub(c.active.created) >= conf.SegmentDuration) {
+		if err := c.seal(); err != nil {
+			return err
+		}
+	}
+	if c.active == nil {
+		seg := &segment{created: now}
+		seg.path = filepath.Join(c.dir, seg.name(false))
+		f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		seg.file = f
+		c.active = seg
+	}
+	n, err := c.active.file.Write(rfc6587.Bytes(msg))
+	c.active.size += int64(n)
+	if err != nil {
+		return err
+	}
+	c.active.add(msg.Timestamp)
+	c.cursors.Advance(msg)
+	return nil
+}
+
+// seal closes the active segment and renames it to include the time range of
+// its messages
+func (c *channel) seal() error {
+	seg := c.active
+	if seg == nil {
+		return nil
+	}
+	c.active = nil
+	if err := seg.file.Close(); err != nil {
+		return err
+	}
+	seg.file = nil
+	if seg.size == 0 {
+		return os.Remove(seg.path)
+	}
+	path := filepath.Join(c.dir, seg.name(true))
+	if err := os.Rename(seg.path, path); err != nil {
+		return err
+	}
+	seg.path = path
+	c.segments = append(c.segments, seg)
+	return nil
+}
+
+func (c *channel) expire(now time.Time, conf *Config) error {
+	if c.active != nil && now.Sub(c.active.created) >= conf.SegmentDuration {
+		if err := c.seal(); err != nil {
+			return err
+		}
+	}
+
+	cutoff := now.Add(-conf.Retention.MaxAge)
+	size := c.size()
+	segments := c.segments[:0]
+	for _, seg := range c.segments {
+		if seg.end.Before(cutoff) || size > conf.Retention.MaxSize {
+			if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			size -= seg.size
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	c.segments = segments
+	return nil
+}
+
+type segment struct {
+	path    string
+	created time.Time
+	size    int64
+
+	// start and end are the earliest and latest message timestamps
+	start, end time.Time
+
+	// file is only set for the active segment
+	file *os.File
+}
+
+func (s *segment) add(t time.Time) {
+	if s.start.IsZero() || t.Before(s.start) {
+		s.start = t
+	}
+	if t.After(s.end) {
+		s.end = t
+	}
+}
+
+// name returns the file name of the segment, active segments are named after
+// their creation time and sealed segments also include their time range
+func (s *segment) name(sealed bool) string {
+	if !sealed {
+		return formatTime(s.created) + segmentExt
+	}
+	return strings.Join([]string{formatTime(s.created), formatTime(s.start), formatTime(s.end)}, "-") + segmentExt
+}
+
+func parseSegmentPath(path string) (*segment, bool, error) {
+	fields := strings.Split(strings.TrimSuffix(filepath.Base(path), segmentExt), "-")
+	if len(fields) != 1 && len(fields) != 3 {
+		return nil, false, fmt.Errorf("store: invalid segment name %q", filepath.Base(path))
+	}
+	times := make([]time.Time, len(fields))
+	for i, field := range fields {
+		n, err := strconv.ParseUint(field, 16, 64)
+		if err != nil {
+			return nil, false, err
+		}
+		times[i] = time.Unix(0, int64(n))
+	}
+	seg := &segment{path: path, created: times[0]}
+	if len(times) == 1 {
+		return seg, false, nil
+	}
+	seg.start, seg.end = times[1], times[2]
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	seg.size = info.Size()
+	return seg, true, nil
+}
+
+func formatTime(t time.Time) string {
+	return fmt.Sprintf("%016x", uint64(t.UnixNano()))
+}
+
+// recoverSegment seals a segment which was active when the store was last
+// closed, truncating any partially written message. It returns nil if the
+// segment is empty.
+func recoverSegment(seg *segment) (*segment, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return nil, err
+	}
+	size, err := (&segmentFile{File: f, size: -1}).scan(func(msg *rfc5424.Message) {
+		seg.add(msg.Timestamp)
+	})
+	f.Close()
+	if err != nil {
+		log15.Error("truncating log segment", "path", seg.path, "size", size, "err", err)
+	}
+	if size == 0 {
+		return nil, os.Remove(seg.path)
+	}
+	if err := os.Truncate(seg.path, size); err != nil {
+		return nil, err
+	}
+	seg.size = size
+	path := filepath.Join(filepath.Dir(seg.path), seg.name(true))
+	if err := os.Rename(seg.path, path); err != nil {
+		return nil, err
+	}
+	seg.path = path
+	return seg, nil
+}
+
+type segmentFile struct {
+	*os.File
+	size int64
+}
+
+// scan calls fn with each message in the first size bytes of the segment, or
+// the whole segment if size is negative, and returns the offset following the
+// last complete message. A partially written message at the end of the
+// segment is ignored.
+func (f *segmentFile) scan(fn func(*rfc5424.Message)) (int64, error) {
+	var r io.Reader = f.File
+	if f.size >= 0 {
+		r = io.LimitReader(f.File, f.size)
+	}
+	var offset, next int64
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), rfc6587.MaxMsgLen+16)
+	sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := rfc6587.Split(data, atEOF)
+		next += int64(advance)
+		return advance, token, err
+	})
+	for sc.Scan() {
+		// the scanner reuses its buffer, so the message is parsed
+		// from a copy
+		data := make([]byte, len(sc.Bytes()))
+		copy(data, sc.Bytes())
+		msg, err := rfc5424.Parse(data)
+		if err != nil {
+			return offset, fmt.Errorf("store: error parsing message in %s: %s", f.Name(), err)
+		}
+		fn(msg)
+		offset = next
+	}
+	return offset, sc.Err()
+}
+
+// read returns the messages in the segment which match q, sorted by
+// timestamp
+func (f *segmentFile) read(q *Query) ([]*rfc5424.Message, error) {
+	var msgs []*rfc5424.Message
+	_, err := f.scan(func(msg *rfc5424.Message) {
+		if q.match(msg) {
+			msgs = append(msgs, msg)
+		}
+	})
+	sort.Stable(messagesByTime(msgs))
+	return msgs, err
+}
+
+func closeSegmentFiles(files []*segmentFile) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+type segmentsByCreated []*segment
+
+func (s segmentsByCreated) Len() int           { return len(s) }
+func (s segmentsByCreated) Less(i, j int) bool { return s[i].created.Before(s[j].created) }
+func (s segmentsByCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type messagesByTime []*rfc5424.Message
+
+func (m messagesByTime) Len() int           { return len(m) }
+func (m messagesByTime) Less(i, j int) bool { return m[i].Timestamp.Before(m[j].Timestamp) }
+func (m messagesByTime) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	. "github.com/flynn/go-check"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct {
+	dir string
+}
+
+var _ = Suite(&S{})
+
+func (s *S) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+var baseTime = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newMessage(app, host string, seq int) *rfc5424.Message {
+	return newMessageAt(app, host, seq, baseTime)
+}
+
+// newMessageAt returns a message with the given seq which is timestamped seq
+// seconds after base
+func newMessageAt(app, host string, seq int, base time.Time) *rfc5424.Message {
+	msg := rfc5424.NewMessage(&rfc5424.Header{
+		Hostname:  []byte(host),
+		AppName:   []byte(app),
+		ProcID:    []byte("web.job1"),
+		MsgID:     []byte("ID1"),
+		Timestamp: base.Add(time.Duration(seq) * time.Second),
+	}, []byte(fmt.Sprintf("line %d", seq)))
+	msg.StructuredData = []byte(fmt.Sprintf(`[flynn seq="%d"]`, seq))
+	return msg
+}
+
+func (s *S) open(c *C, conf Config) *Store {
+	conf.Dir = s.dir
+	st, err := Open(conf)
+	c.Assert(err, IsNil)
+	return st
+}
+
+func readAll(c *C, st *Store, id string, q Query) []string {
+	var lines []string
+	c.Assert(st.Read(id, q, func(msg *rfc5424.Message) error {
+		lines = append(lines, string(msg.Msg))
+		return nil
+	}), IsNil)
+	return lines
+}
+
+func lineRange(from, to int) []string {
+	lines := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	return lines
+}
+
+func (s *S) TestAppendRead(c *C) {
+	st := s.open(c, Config{SegmentSize: 1024})
+	defer st.Close()
+
+	for i := 0; i < 100; i++ {
+		c.Assert(st.Append("app-A", newMessage("app-A", "host1", i)), IsNil)
+	}
+	c.Assert(st.Append("app-B", newMessage("app-B", "host1", 100)), IsNil)
+	c.Assert(st.Channels(), DeepEquals, []string{"app-A", "app-B"})
+
+	segments, err := filepath.Glob(filepath.Join(st.channels["app-A"].dir, "*"+segmentExt))
+	c.Assert(err, IsNil)
+	c.Assert(len(segments) > 1, Equals, true)
+
+	for _, t := range []struct {
+		desc     string
+		query    Query
+		expected []string
+	}{
+		{"all", Query{}, lineRange(0, 100)},
+		{"since", Query{Since: baseTime.Add(90 * time.Second)}, lineRange(90, 100)},
+		{"until", Query{Until: baseTime.Add(10 * time.Second)}, lineRange(0, 10)},
+		{"range", Query{Since: baseTime.Add(40 * time.Second), Until: baseTime.Add(60 * time.Second)}, lineRange(40, 60)},
+		{"limit", Query{Limit: 5}, lineRange(95, 100)},
+		{"range with limit", Query{Until: baseTime.Add(50 * time.Second), Limit: 30}, lineRange(20, 50)},
+		{"filter", Query{Filter: func(msg *rfc5424.Message) bool { return string(msg.Msg) == "line 42" }}, lineRange(42, 43)},
+		{"no match", Query{Since: baseTime.Add(time.Hour)}, nil},
+	} {
+		c.Assert(readAll(c, st, "app-A", t.query), DeepEquals, t.expected, Commentf(t.desc))
+	}
+	c.Assert(readAll(c, st, "app-B", Query{}), DeepEquals, lineRange(100, 101))
+	c.Assert(readAll(c, st, "app-C", Query{}), IsNil)
+}
+
+func (s *S) TestAppendOutOfOrder(c *C) {
+	st := s.open(c, Config{})
+	defer st.Close()
+
+	// messages from different jobs on a host may arrive out of order
+	for _, i := range []int{0, 2, 1, 3} {
+		c.Assert(st.Append("app", newMessage("app", "host1", i)), IsNil)
+	}
+	c.Assert(readAll(c, st, "app", Query{}), DeepEquals, lineRange(0, 4))
+	c.Assert(st.Cursors()["host1"].Seq, Equals, uint64(3))
+}
+
+func (s *S) TestReopen(c *C) {
+	st := s.open(c, Config{SegmentSize: 1024})
+	for i := 0; i < 50; i++ {
+		c.Assert(st.Append("app", newMessage("app", "host1", i)), IsNil)
+	}
+	c.Assert(st.Close(), IsNil)
+	c.Assert(st.Append("app", newMessage("app", "host1", 50)), Equals, ErrClosed)
+
+	st = s.open(c, Config{SegmentSize: 1024})
+	defer st.Close()
+	c.Assert(readAll(c, st, "app", Query{}), DeepEquals, lineRange(0, 50))
+	c.Assert(st.Cursors()["host1"].Seq, Equals, uint64(49))
+
+	// messages resent from an older cursor are not stored again, but
+	// other hosts are tracked separately
+	for i := 45; i < 55; i++ {
+		c.Assert(st.Append("app", newMessage("app", "host1", i)), IsNil)
+	}
+	c.Assert(st.Append("app", newMessage("app", "host2", 55)), IsNil)
+	c.Assert(readAll(c, st, "app", Query{}), DeepEquals, lineRange(0, 56))
+}
+
+func (s *S) TestRecoverActiveSegment(c *C) {
+	st := s.open(c, Config{})
+	for i := 0; i < 10; i++ {
+		c.Assert(st.Append("app", newMessage("app", "host1", i)), IsNil)
+	}
+
+	// simulate a crash part way through writing a message
+	active := st.channels["app"].active
+	c.Assert(active, NotNil)
+	size := active.size
+	_, err := active.file.Write([]byte("100 <1>1 2016-03-01T12:00:10Z"))
+	c.Assert(err, IsNil)
+	st.channels["app"].active = nil
+	active.file.Close()
+	c.Assert(st.Close(), IsNil)
+
+	st = s.open(c, Config{})
+	defer st.Close()
+	c.Assert(readAll(c, st, "app", Query{}), DeepEquals, lineRange(0, 10))
+	segments := st.channels["app"].segments
+	c.Assert(segments, HasLen, 1)
+	c.Assert(segments[0].size, Equals, size)
+	c.Assert(segments[0].start.Equal(baseTime), Equals, true)
+	c.Assert(segments[0].end.Equal(baseTime.Add(9*time.Second)), Equals, true)
+	info, err := os.Stat(segments[0].path)
+	c.Assert(err, IsNil)
+	c.Assert(info.Size(), Equals, size)
+
+	// the store continues appending to a new segment
+	c.Assert(st.Append("app", newMessage("app", "host1", 10)), IsNil)
+	c.Assert(readAll(c, st, "app", Query{}), DeepEquals, lineRange(0, 11))
+}
+
+func (s *S) TestExpireAge(c *C) {
+	st := s.open(c, Config{SegmentDuration: time.Hour, Retention: Retention{MaxAge: 24 * time.Hour}})
+	defer st.Close()
+
+	base := time.Now()
+	for i := 0; i < 10; i++ {
+		c.Assert(st.Append("app", newMessageAt("app", "host1", i, base)), IsNil)
+	}
+
+	// the active segment is sealed once it is older than SegmentDuration
+	c.Assert(st.Expire(time.Now().Add(time.Hour)), IsNil)
+	c.Assert(st.channels["app"].active, IsNil)
+	c.Assert(st.channels["app"].segments, HasLen, 1)
+	c.Assert(readAll(c, st, "app", Query{}), DeepEquals, lineRange(0, 10))
+
+	// and removed once all of its messages are older than MaxAge
+	c.Assert(st.Expire(base.Add(24*time.Hour+9*time.Second)), IsNil)
+	c.Assert(readAll(c, st, "app", Query{}), DeepEquals, lineRange(0, 10))
+	c.Assert(st.Expire(base.Add(24*time.Hour+10*time.Second)), IsNil)
+	c.Assert(readAll(c, st, "app", Query{}), IsNil)
+	c.Assert(st.Size("app"), Equals, int64(0))
+	files, err := ioutil.ReadDir(st.channels["app"].dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+}
+
+func (s *S) TestExpireSize(c *C) {
+	st := s.open(c, Config{SegmentSize: 1024, Retention: Retention{MaxSize: 4096}})
+	defer st.Close()
+
+	base := time.Now()
+	for i := 0; i < 200; i++ {
+		c.Assert(st.Append("app", newMessageAt("app", "host1", i, base)), IsNil)
+	}
+	c.Assert(st.Size("app") > 4096, Equals, true)
+	c.Assert(st.Expire(time.Now()), IsNil)
+	c.Assert(st.Size("app") <= 4096, Equals, true)
+
+	// the newest messages are retained
+	lines := readAll(c, st, "app", Query{})
+	c.Assert(len(lines) > 0 && len(lines) < 200, Equals, true)
+	c.Assert(lines, DeepEquals, lineRange(200-len(lines), 200))
+}
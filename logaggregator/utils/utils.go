@@ -53,3 +53,34 @@ type HostCursor struct {
 func (c HostCursor) After(other HostCursor) bool {
 	return c.Time.After(other.Time) || (c.Time.Equal(other.Time) && c.Seq > other.Seq)
 }
+
+// CursorSet tracks the latest cursor received from each host.
+type CursorSet map[string]*HostCursor
+
+// Advance records the cursor of msg if it is after the latest cursor recorded
+// for its host, returning whether it was. Messages without a cursor are
+// ignored.
+func (s CursorSet) Advance(msg *rfc5424.Message) bool {
+	c, err := ParseHostCursor(msg)
+	if err != nil {
+		return false
+	}
+	host := string(msg.Hostname)
+	if curr, ok := s[host]; ok && !c.After(*curr) {
+		return false
+	}
+	s[host] = c
+	return true
+}
+
+// Seen returns whether msg is not after the latest cursor recorded for its
+// host, which is the case for messages resent when a host resumes streaming
+// from that cursor.
+func (s CursorSet) Seen(msg *rfc5424.Message) bool {
+	curr, ok := s[string(msg.Hostname)]
+	if !ok {
+		return false
+	}
+	c, err := ParseHostCursor(msg)
+	return err == nil && !c.After(*curr)
+}
@@ -0,0 +1,124 @@
+package pinkerton
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+
+	"github.com/docker/docker/cliconfig"
+	"github.com/docker/docker/graph"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+var ErrNoDigest = errors.New("pinkerton: missing digest of pushed image")
+
+// Exists returns whether the image with the given ID has been pulled or
+// registered
+func (c *Context) Exists(id string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.graph.Exists(id)
+}
+
+// ImageConfig returns the config of the image with the given ID
+func (c *Context) ImageConfig(id string) (*ImageConfig, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	data, err := c.graph.RawJSON(id)
+	if err != nil {
+		return nil, err
+	}
+	config := &ImageConfig{}
+	return config, json.Unmarshal(data, config)
+}
+
+// Commit registers the changes made to the checkout with the given ID as a new
+// image, the parent of which must be the image the checkout was created from
+func (c *Context) Commit(checkoutID string, config *ImageConfig) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	diff, err := c.driver.Diff("tmp-"+checkoutID, config.ParentID)
+	if err != nil {
+		return err
+	}
+	defer diff.Close()
+	return c.graph.Register(&Image{config: config}, diff)
+}
+
+// Register registers an image using an uncompressed layer previously read
+// with Layer
+func (c *Context) Register(config *ImageConfig, layer io.Reader) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.graph.Register(&Image{config: config}, layer)
+}
+
+// Layer returns the uncompressed layer of the image with the given ID
+func (c *Context) Layer(id string) (io.ReadCloser, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	img, err := c.graph.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.graph.TarLayer(img)
+}
+
+// PushDocker pushes the image with the given ID to the registry and tag
+// referred to by url (which has the same format as for PullDocker), returning
+// the digest of the pushed manifest
+func (c *Context) PushDocker(url, imageID string, out io.Writer) (string, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	ref, err := NewRef(url)
+	if err != nil {
+		return "", err
+	}
+	if ref.imageID != "" {
+		return "", errors.New("pinkerton: images can only be pushed to a tag")
+	}
+	if err := c.store.Tag(ref.DockerRepo(), ref.Tag(), imageID, true); err != nil {
+		return "", err
+	}
+
+	w := &digestWriter{Writer: out}
+	config := &graph.ImagePushConfig{
+		AuthConfig: &cliconfig.AuthConfig{
+			Username: ref.username,
+			Password: ref.password,
+		},
+		Tag:       ref.Tag(),
+		OutStream: w,
+	}
+	if err := c.store.Push(ref.DockerRepo(), config); err != nil {
+		return "", err
+	}
+	if w.digest == "" {
+		return "", ErrNoDigest
+	}
+	return w.digest, nil
+}
+
+var digestPattern = regexp.MustCompile(`digest: (\S+)`)
+
+// digestWriter reads the digest of a pushed manifest from the JSON messages
+// written whilst pushing
+type digestWriter struct {
+	io.Writer
+	digest string
+}
+
+func (w *digestWriter) Write(p []byte) (int, error) {
+	var msg jsonmessage.JSONMessage
+	if err := json.Unmarshal(p, &msg); err == nil {
+		if match := digestPattern.FindStringSubmatch(msg.Status); match != nil {
+			w.digest = match[1]
+		}
+	}
+	return w.Writer.Write(p)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/docker/pkg/term"
 	"github.com/docker/docker/registry"
+	"github.com/docker/libtrust"
 	"github.com/flynn/flynn/pinkerton/layer"
 	"github.com/flynn/flynn/pkg/tufutil"
 	"github.com/flynn/flynn/pkg/version"
@@ -56,8 +57,15 @@ func BuildContext(driver, root string) (*Context, error) {
 		return nil, err
 	}
 
+	// manifests are signed with an ephemeral key when pushing images
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
 	config := &graph.TagStoreConfig{
 		Graph:  g,
+		Key:    key,
 		Events: events.New(),
 		Registry: registry.NewService(&registry.Options{
 			Mirrors:            opts.NewListOpts(nil),
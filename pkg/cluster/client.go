@@ -93,6 +93,18 @@ func HostTagsFromMeta(meta map[string]string) map[string]string {
 	return tags
 }
 
+// HostDrainFromMeta returns the drain status of a host from its metadata, or
+// nil if the host is not being drained
+func HostDrainFromMeta(meta map[string]string) *host.DrainStatus {
+	if meta[host.DrainMetaKey] != "true" {
+		return nil
+	}
+	return &host.DrainStatus{
+		Data:    meta[host.DrainDataMetaKey] == "true",
+		Drained: meta[host.DrainedMetaKey] == "true",
+	}
+}
+
 func (c *Client) StreamHostEvents(ch chan *discoverd.Event) (stream.Stream, error) {
 	return c.s.Watch(ch)
 }
@@ -117,6 +117,13 @@ func (c *Host) SignalJob(id string, sig int) error {
 	return c.c.Put(fmt.Sprintf("/host/jobs/%s/signal/%d", id, sig), nil, nil)
 }
 
+// JobStats returns the current resource usage of a running job.
+func (c *Host) JobStats(id string) (*host.JobStats, error) {
+	var res host.JobStats
+	err := c.c.Get(fmt.Sprintf("/host/jobs/%s/stats", id), &res)
+	return &res, err
+}
+
 // StreamEvents about job state changes to ch. id may be "all" or a single
 // job ID.
 func (c *Host) StreamEvents(id string, ch chan *host.Event) (stream.Stream, error) {
@@ -145,6 +152,32 @@ func (c *Host) CreateSnapshot(volumeID string) (*volume.Info, error) {
 	return &res, err
 }
 
+// GetSnapshotPolicy returns the snapshot policy of a volume.
+func (c *Host) GetSnapshotPolicy(volumeID string) (*volume.SnapshotPolicy, error) {
+	var res volume.SnapshotPolicy
+	err := c.c.Get(fmt.Sprintf("/storage/volumes/%s/snapshot_policy", volumeID), &res)
+	return &res, err
+}
+
+// SetSnapshotPolicy configures the host to periodically upload snapshots of a
+// volume to the blobstore.
+func (c *Host) SetSnapshotPolicy(volumeID string, policy *volume.SnapshotPolicy) error {
+	return c.c.Put(fmt.Sprintf("/storage/volumes/%s/snapshot_policy", volumeID), policy, policy)
+}
+
+// DeleteSnapshotPolicy stops the host from uploading snapshots of a volume.
+func (c *Host) DeleteSnapshotPolicy(volumeID string) error {
+	return c.c.Delete(fmt.Sprintf("/storage/volumes/%s/snapshot_policy", volumeID))
+}
+
+// RestoreVolume creates a new volume and restores it from snapshots uploaded
+// to the blobstore by a snapshot policy. Returns the info for the new volume.
+func (c *Host) RestoreVolume(providerID string, req *volume.RestoreRequest) (*volume.Info, error) {
+	var res volume.Info
+	err := c.c.Post(fmt.Sprintf("/storage/providers/%s/restore", providerID), req, &res)
+	return &res, err
+}
+
 // PullSnapshot requests the host pull a snapshot from another host onto one of
 // its volumes. Returns the info for the new snapshot.
 func (c *Host) PullSnapshot(receiveVolID string, sourceHostID string, sourceSnapID string) (*volume.Info, error) {
@@ -157,6 +190,20 @@ func (c *Host) PullSnapshot(receiveVolID string, sourceHostID string, sourceSnap
 	return &res, err
 }
 
+// PullVolume requests the host snapshot a volume on another host and pull the
+// snapshot onto one of its volumes. Pulling the same volume onto the same
+// receiving volume again only transfers what has changed since the previous
+// pull.
+func (c *Host) PullVolume(receiveVolID string, sourceHostID string, sourceVolID string) (*volume.VolumeTransfer, error) {
+	var res volume.VolumeTransfer
+	pull := volume.PullCoordinate{
+		HostID:   sourceHostID,
+		VolumeID: sourceVolID,
+	}
+	err := c.c.Post(fmt.Sprintf("/storage/volumes/%s/pull_volume", receiveVolID), pull, &res)
+	return &res, err
+}
+
 // SendSnapshot requests transfer of volume snapshot data (this is used by other
 // hosts in service of the PullSnapshot request).
 func (c *Host) SendSnapshot(snapID string, assumeHaves []json.RawMessage) (io.ReadCloser, error) {
@@ -206,3 +253,20 @@ func (c *Host) Update(name string, args ...string) (pid int, err error) {
 func (c *Host) UpdateTags(tags map[string]string) error {
 	return c.c.Post("/host/tags", tags, nil)
 }
+
+// Drain starts draining the host of its jobs, including jobs with data
+// volumes if data is true.
+func (c *Host) Drain(data bool) error {
+	return c.c.Put("/host/drain", &host.DrainStatus{Data: data}, nil)
+}
+
+// Undrain stops draining the host so that jobs can be placed on it again.
+func (c *Host) Undrain() error {
+	return c.c.Delete("/host/drain")
+}
+
+// MarkDrained marks the host as drained once its jobs have been moved to
+// other hosts.
+func (c *Host) MarkDrained() error {
+	return c.c.Put("/host/drain/drained", nil, nil)
+}
@@ -0,0 +1,232 @@
+// Package cron implements parsing of cron expressions and calculating the
+// times at which they are next due.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// domStar and dowStar indicate that the day of month and day of week
+	// fields were unrestricted, which determines how they are combined
+	// (a day matches if it matches either field when both are restricted)
+	domStar bool
+	dowStar bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// day of week allows 7 as an alias for Sunday
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a standard five field cron expression (minute, hour, day of
+// month, month and day of week) or one of the descriptors @yearly,
+// @annually, @monthly, @weekly, @daily, @midnight or @hourly.
+//
+// Fields may contain lists ("1,2,3"), ranges ("1-5"), steps ("*/15" or
+// "0-30/10") and, for months and days of the week, three letter names.
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = expanded
+	} else if strings.HasPrefix(spec, "@") {
+		return nil, fmt.Errorf("cron: unknown descriptor %q", spec)
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d", len(fields))
+	}
+
+	s := &Schedule{
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	// treat 7 as Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+func (f field) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		b, err := f.parsePart(part)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+func (f field) parsePart(part string) (uint64, error) {
+	rangeAndStep := strings.SplitN(part, "/", 2)
+	step := 1
+	if len(rangeAndStep) == 2 {
+		var err error
+		step, err = strconv.Atoi(rangeAndStep[1])
+		if err != nil || step <= 0 {
+			return 0, fmt.Errorf("cron: invalid step %q in %s field", rangeAndStep[1], f.name)
+		}
+	}
+
+	var start, end int
+	switch r := rangeAndStep[0]; {
+	case r == "*" || r == "?":
+		start, end = f.min, f.max
+	case strings.Contains(r, "-"):
+		bounds := strings.SplitN(r, "-", 2)
+		var err error
+		if start, err = f.parseValue(bounds[0]); err != nil {
+			return 0, err
+		}
+		if end, err = f.parseValue(bounds[1]); err != nil {
+			return 0, err
+		}
+		if start > end {
+			return 0, fmt.Errorf("cron: invalid range %q in %s field", r, f.name)
+		}
+	default:
+		var err error
+		if start, err = f.parseValue(r); err != nil {
+			return 0, err
+		}
+		end = start
+		// "N/step" means every step starting at N
+		if len(rangeAndStep) == 2 {
+			end = f.max
+		}
+	}
+
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+	return bits, nil
+}
+
+func (f field) parseValue(s string) (int, error) {
+	if n, ok := f.names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid value %q in %s field", s, f.name)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("cron: value %d out of range [%d-%d] in %s field", n, f.min, f.max, f.name)
+	}
+	return n, nil
+}
+
+// maxSearch bounds the search for the next matching time, expressions which
+// can never match (e.g. "0 0 30 2 *") return the zero time
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// ErrNeverDue is returned by Validate for expressions which never match.
+var ErrNeverDue = errors.New("cron: expression never matches")
+
+// Next returns the first time after t which matches the schedule, or the
+// zero time if there is no such time. The returned time has minute
+// precision and is in the same location as t.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.Add(maxSearch)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Validate parses the given expression and checks that it is due at some
+// point in the future.
+func Validate(spec string) error {
+	s, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	if s.Next(time.Now()).IsZero() {
+		return ErrNeverDue
+	}
+	return nil
+}
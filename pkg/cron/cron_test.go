@@ -0,0 +1,72 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flynn/flynn/pkg/cron"
+	. "github.com/flynn/go-check"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&S{})
+
+type S struct{}
+
+func (S) TestNext(c *C) {
+	start := time.Date(2016, time.March, 14, 10, 30, 15, 0, time.UTC)
+	for _, t := range []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2016, time.March, 14, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2016, time.March, 14, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2016, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2016, time.March, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2016, time.March, 14, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2016, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2016, time.March, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2016, time.March, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * jan mon-fri", time.Date(2017, time.January, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2016, time.March, 18, 0, 0, 0, 0, time.UTC)},
+		{"5,10 12 * * *", time.Date(2016, time.March, 14, 12, 5, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2016, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2016, time.March, 20, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		s, err := cron.Parse(t.spec)
+		c.Assert(err, IsNil, Commentf("spec = %q", t.spec))
+		c.Assert(s.Next(start), Equals, t.next, Commentf("spec = %q", t.spec))
+	}
+}
+
+func (S) TestNextNeverDue(c *C) {
+	s, err := cron.Parse("0 0 30 2 *")
+	c.Assert(err, IsNil)
+	c.Assert(s.Next(time.Now()).IsZero(), Equals, true)
+	c.Assert(cron.Validate("0 0 30 2 *"), Equals, cron.ErrNeverDue)
+}
+
+func (S) TestParseErrors(c *C) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@fortnightly",
+	} {
+		_, err := cron.Parse(spec)
+		c.Assert(err, NotNil, Commentf("spec = %q", spec))
+	}
+}
@@ -29,6 +29,7 @@ const (
 	ValidationErrorCode         ErrorCode = "validation_error"
 	PreconditionFailedErrorCode ErrorCode = "precondition_failed"
 	UnauthorizedErrorCode       ErrorCode = "unauthorized"
+	ForbiddenErrorCode          ErrorCode = "forbidden"
 	UnknownErrorCode            ErrorCode = "unknown_error"
 	RatelimitedErrorCode        ErrorCode = "ratelimited"
 	ServiceUnavailableErrorCode ErrorCode = "service_unavailable"
@@ -43,6 +44,7 @@ var errorResponseCodes = map[ErrorCode]int{
 	SyntaxErrorCode:             400,
 	ValidationErrorCode:         400,
 	UnauthorizedErrorCode:       401,
+	ForbiddenErrorCode:          403,
 	UnknownErrorCode:            500,
 	RatelimitedErrorCode:        429,
 	ServiceUnavailableErrorCode: 503,
@@ -68,6 +70,10 @@ func IsObjectExistsError(err error) bool {
 	return isJSONErrorWithCode(err, ObjectExistsErrorCode)
 }
 
+func IsConflictError(err error) bool {
+	return isJSONErrorWithCode(err, ConflictErrorCode)
+}
+
 func IsPreconditionFailedError(err error) bool {
 	return isJSONErrorWithCode(err, PreconditionFailedErrorCode)
 }
@@ -76,6 +82,10 @@ func IsValidationError(err error) bool {
 	return isJSONErrorWithCode(err, ValidationErrorCode)
 }
 
+func IsForbiddenError(err error) bool {
+	return isJSONErrorWithCode(err, ForbiddenErrorCode)
+}
+
 // IsRetryableError indicates whether a HTTP request can be safely retried.
 func IsRetryableError(err error) bool {
 	e, ok := err.(JSONError)
@@ -216,14 +226,22 @@ func ObjectExistsError(w http.ResponseWriter, message string) {
 	Error(w, ObjectExistsErr(message))
 }
 
+func ConflictErr(message string) error {
+	return JSONError{Code: ConflictErrorCode, Message: message}
+}
+
 func ConflictError(w http.ResponseWriter, message string) {
-	Error(w, JSONError{Code: ConflictErrorCode, Message: message})
+	Error(w, ConflictErr(message))
 }
 
 func PreconditionFailedErr(message string) error {
 	return JSONError{Code: PreconditionFailedErrorCode, Message: message}
 }
 
+func ForbiddenError(w http.ResponseWriter, message string) {
+	Error(w, JSONError{Code: ForbiddenErrorCode, Message: message})
+}
+
 func ServiceUnavailableError(w http.ResponseWriter, message string) {
 	Error(w, JSONError{Code: ServiceUnavailableErrorCode, Message: message, Retry: true})
 }
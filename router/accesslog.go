@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/flynn/flynn/discoverd/cache"
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	"github.com/flynn/flynn/pkg/syslog/rfc6587"
+	"github.com/flynn/flynn/router/proxy"
+)
+
+// appParentRefPrefix is the prefix the controller uses for the parent refs
+// of app routes, followed by the app ID
+const appParentRefPrefix = "controller/apps/"
+
+// routeAppID returns the ID of the app a route belongs to, if any
+func routeAppID(parentRef string) string {
+	if strings.HasPrefix(parentRef, appParentRefPrefix) {
+		return strings.TrimPrefix(parentRef, appParentRefPrefix)
+	}
+	return ""
+}
+
+const (
+	// accessLogBuffer is the number of log lines which are buffered while
+	// sending to logaggregator, after which lines are dropped rather than
+	// slowing down requests
+	accessLogBuffer = 10000
+
+	// accessLogMsgID is the syslog MSGID of access log lines, which
+	// logaggregator uses to distinguish them from app output
+	accessLogMsgID = "router"
+
+	accessLogDialTimeout  = time.Second
+	accessLogWriteTimeout = 5 * time.Second
+	accessLogRetryDelay   = 5 * time.Second
+)
+
+var errAccessLogRetryDelay = errors.New("router: waiting to reconnect to logaggregator")
+
+// accessLog sends one log line per proxied HTTP request to every
+// logaggregator instance, with the app of the request's route as the syslog
+// app name so that the lines appear in the app's log stream.
+type accessLog struct {
+	// seq and dropped are accessed atomically so must be the first fields
+	// to guarantee 64-bit alignment
+	seq     uint64
+	dropped uint64
+
+	// hostname identifies this router instance to logaggregator, which
+	// tracks message sequence numbers per hostname, so must not be the ID
+	// of the host the router is running on
+	hostname string
+	procID   string
+
+	sc     cache.ServiceCache
+	conns  map[string]net.Conn
+	failed map[string]time.Time
+
+	msgs chan *rfc5424.Message
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newAccessLog starts sending access logs to the logaggregator instances in
+// the given service cache, identifying the router by its job ID.
+func newAccessLog(jobID string, sc cache.ServiceCache) *accessLog {
+	a := &accessLog{
+		hostname: "router-" + jobID,
+		procID:   "router." + jobID,
+		sc:       sc,
+		conns:    make(map[string]net.Conn),
+		failed:   make(map[string]time.Time),
+		msgs:     make(chan *rfc5424.Message, accessLogBuffer),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Log records a request served by the given route, dropping the line if
+// the buffer is full.
+func (a *accessLog) Log(r *httpRoute, req *http.Request, info *proxy.RequestInfo, rw *responseRecorder, duration time.Duration) {
+	appID := routeAppID(r.ParentRef)
+	if appID == "" {
+		return
+	}
+	hdr := &rfc5424.Header{
+		Hostname: []byte(a.hostname),
+		AppName:  []byte(appID),
+		ProcID:   []byte(a.procID),
+		MsgID:    []byte(accessLogMsgID),
+	}
+	msg := rfc5424.NewMessage(hdr, formatAccessLog(r, req, info, rw, duration))
+	seq := atomic.AddUint64(&a.seq, 1)
+	msg.StructuredData = []byte(`[flynn seq="` + strconv.FormatUint(seq, 10) + `"]`)
+
+	select {
+	case a.msgs <- msg:
+	default:
+		if n := atomic.AddUint64(&a.dropped, 1); n%1000 == 1 {
+			logger.Error("access log buffer full, dropping lines", "dropped", n)
+		}
+	}
+}
+
+// Close stops sending access logs, dropping any buffered lines.
+func (a *accessLog) Close() {
+	close(a.stop)
+	<-a.done
+}
+
+func (a *accessLog) run() {
+	defer close(a.done)
+	for {
+		select {
+		case msg := <-a.msgs:
+			a.send(msg)
+		case <-a.stop:
+			for _, conn := range a.conns {
+				conn.Close()
+			}
+			return
+		}
+	}
+}
+
+func (a *accessLog) send(msg *rfc5424.Message) {
+	data := rfc6587.Bytes(msg)
+	addrs := a.sc.Addrs()
+	for _, addr := range addrs {
+		conn, err := a.conn(addr)
+		if err != nil {
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(accessLogWriteTimeout))
+		if _, err := conn.Write(data); err != nil {
+			logger.Error("error writing access log to logaggregator", "addr", addr, "err", err)
+			conn.Close()
+			delete(a.conns, addr)
+			a.failed[addr] = time.Now()
+		}
+	}
+
+	// close connections to instances which have gone away
+	if len(a.conns) > len(addrs) {
+	outer:
+		for addr, conn := range a.conns {
+			for _, current := range addrs {
+				if current == addr {
+					continue outer
+				}
+			}
+			conn.Close()
+			delete(a.conns, addr)
+		}
+	}
+}
+
+func (a *accessLog) conn(addr string) (net.Conn, error) {
+	if conn, ok := a.conns[addr]; ok {
+		return conn, nil
+	}
+	if t, ok := a.failed[addr]; ok && time.Since(t) < accessLogRetryDelay {
+		return nil, errAccessLogRetryDelay
+	}
+	conn, err := net.DialTimeout("tcp", addr, accessLogDialTimeout)
+	if err != nil {
+		logger.Error("error connecting to logaggregator", "addr", addr, "err", err)
+		a.failed[addr] = time.Now()
+		return nil, err
+	}
+	delete(a.failed, addr)
+	a.conns[addr] = conn
+	return conn, nil
+}
+
+// formatAccessLog formats a request as a line of key=value pairs
+func formatAccessLog(r *httpRoute, req *http.Request, info *proxy.RequestInfo, rw *responseRecorder, duration time.Duration) []byte {
+	var buf bytes.Buffer
+	write := func(key, value string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		if value == "" || strings.ContainsAny(value, " \"=") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+	clientIP, _, _ := net.SplitHostPort(req.RemoteAddr)
+	write("method", req.Method)
+	write("host", req.Host)
+	write("path", req.URL.Path)
+	write("request_id", req.Header.Get("X-Request-Id"))
+	write("client_ip", clientIP)
+	write("route", "http/"+r.ID)
+	write("backend", info.Backend)
+	write("status", strconv.Itoa(rw.Status()))
+	write("bytes", strconv.FormatInt(rw.bytes, 10))
+	write("duration", duration.String())
+	return buf.Bytes()
+}
+
+// responseRecorder records the status and number of body bytes of a
+// response, passing through the optional interfaces the proxy relies on.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// Status returns the response status, which is 101 for hijacked
+// connections and defaults to 200 if no header was written.
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return 200
+	}
+	return r.status
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = 200
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) CloseNotify() <-chan bool {
+	return r.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if r.status == 0 {
+		r.status = 101
+	}
+	return r.ResponseWriter.(http.Hijacker).Hijack()
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/router/types"
+	"github.com/jackc/pgx"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/net/context"
+)
+
+const (
+	// acmeCheckInterval is how often routes are checked for certificates
+	// which need to be obtained or renewed
+	acmeCheckInterval = time.Hour
+
+	// acmeRenewBefore is how long before they expire certificates are
+	// renewed, or a third of their lifetime if that is shorter
+	acmeRenewBefore = 30 * 24 * time.Hour
+
+	// acmeObtainTimeout is how long obtaining a certificate can take,
+	// including waiting for the ACME server to validate the challenge
+	acmeObtainTimeout = 5 * time.Minute
+
+	// acmeRetryDelay is how long to wait before trying to obtain a
+	// certificate for a domain again after failing to
+	acmeRetryDelay = time.Hour
+
+	// acmeLockRetryDelay is how long to wait before checking again when
+	// another router instance is checking
+	acmeLockRetryDelay = 30 * time.Second
+
+	// acmeLockID is the Postgres advisory lock held while checking so that
+	// only one router instance obtains certificates at a time
+	acmeLockID = 0x61636d65
+
+	// acmeChallengesChannel is the Postgres channel notified with the
+	// token of challenges when they are added or removed
+	acmeChallengesChannel = "acme_challenges"
+
+	// acmeChallengePath is the path prefix HTTP-01 challenges are
+	// requested from, followed by the challenge token
+	acmeChallengePath = "/.well-known/acme-challenge/"
+)
+
+var errACMEWildcard = errors.New("router: certificates for wildcard domains cannot be obtained automatically")
+
+// acmeManager obtains and renews certificates for HTTP routes which have
+// AutoTLS set using ACME, and serves the responses to HTTP-01 challenges.
+//
+// Every router instance runs a manager so that challenges can be answered by
+// whichever instance the ACME server reaches, with the responses shared
+// through Postgres and kept in memory by each instance so that serving them
+// doesn't query Postgres.
+type acmeManager struct {
+	directoryURL string
+	contact      []string
+	httpClient   *http.Client
+
+	pgx *pgx.ConnPool
+	l   *HTTPListener
+
+	// client and failed are only accessed by the run goroutine
+	client *acme.Client
+	failed map[string]time.Time
+
+	// challenges maps the tokens of pending challenges to their responses
+	challenges    map[string]string
+	challengesMtx sync.RWMutex
+
+	events   chan *router.Event
+	check    chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	syncDone chan struct{}
+}
+
+// newACMEManager returns a manager which uses the ACME server with the given
+// directory URL, registering an account with the given contact email if
+// there isn't one already.
+func newACMEManager(directoryURL, email string, pgx *pgx.ConnPool) *acmeManager {
+	m := &acmeManager{
+		directoryURL: directoryURL,
+		pgx:          pgx,
+		failed:       make(map[string]time.Time),
+		challenges:   make(map[string]string),
+		events:       make(chan *router.Event),
+		check:        make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+		syncDone:     make(chan struct{}),
+	}
+	if email != "" {
+		m.contact = []string{"mailto:" + email}
+	}
+	return m
+}
+
+// Start loads the pending challenges and starts syncing them, then starts
+// checking the routes of the given listener, checking again whenever an
+// AutoTLS route is set.
+func (m *acmeManager) Start(l *HTTPListener) error {
+	errc, err := m.startChallengeSync()
+	if err != nil {
+		return err
+	}
+	go m.runChallengeSync(errc)
+	m.l = l
+	l.Watch(m.events)
+	go m.watch()
+	go m.run()
+	return nil
+}
+
+func (m *acmeManager) Close() {
+	if m.l == nil {
+		return
+	}
+	m.l.Unwatch(m.events)
+	close(m.stop)
+	<-m.done
+	<-m.syncDone
+}
+
+func (m *acmeManager) watch() {
+	for event := range m.events {
+		if event.Event != "set" || event.Route == nil || !event.Route.AutoTLS {
+			continue
+		}
+		select {
+		case m.check <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (m *acmeManager) run() {
+	defer close(m.done)
+	log := logger.New("fn", "acmeManager.run")
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.check:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timer.C:
+		}
+
+		next := acmeCheckInterval
+		locked, err := m.Check()
+		if err != nil {
+			log.Error("error checking certificates", "err", err)
+		} else if !locked {
+			next = acmeLockRetryDelay
+		}
+		timer.Reset(next)
+	}
+}
+
+// Check obtains certificates for AutoTLS routes which don't have a valid
+// certificate expiring after acmeRenewBefore, returning false if another
+// router instance is already checking.
+func (m *acmeManager) Check() (bool, error) {
+	conn, err := m.pgx.Acquire()
+	if err != nil {
+		return false, err
+	}
+	defer m.pgx.Release(conn)
+	var locked bool
+	if err := conn.QueryRow("SELECT pg_try_advisory_lock($1)", acmeLockID).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer conn.Exec("SELECT pg_advisory_unlock($1)", acmeLockID)
+
+	routes, err := m.l.ds.List()
+	if err != nil {
+		return true, err
+	}
+	now := time.Now()
+	for _, r := range routes {
+		// the certificate of a domain's default route is used for all
+		// of its paths
+		if !r.AutoTLS || r.Path != "/" || !needsCert(r, now) {
+			continue
+		}
+		if t, ok := m.failed[r.Domain]; ok && now.Sub(t) < acmeRetryDelay {
+			continue
+		}
+		log := logger.New("fn", "acmeManager.Check", "route.id", r.ID, "domain", r.Domain)
+		log.Info("obtaining certificate")
+		cert, err := m.obtain(r.Domain)
+		if err != nil {
+			log.Error("error obtaining certificate", "err", err)
+			m.failed[r.Domain] = now
+			continue
+		}
+		delete(m.failed, r.Domain)
+		cert.Routes = []string{r.ID}
+		if err := m.l.ds.AddCert(cert); err != nil {
+			return true, err
+		}
+		log.Info("obtained certificate", "cert.id", cert.ID)
+	}
+	return true, nil
+}
+
+// needsCert returns whether the route has no certificate for its domain or
+// its certificate is due to be renewed
+func needsCert(r *router.Route, now time.Time) bool {
+	if r.Certificate == nil {
+		return true
+	}
+	block, _ := pem.Decode([]byte(r.Certificate.Cert))
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	if cert.VerifyHostname(r.Domain) != nil {
+		return true
+	}
+	renewBefore := acmeRenewBefore
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime/3 < renewBefore {
+		renewBefore = lifetime / 3
+	}
+	return cert.NotAfter.Sub(now) < renewBefore
+}
+
+// obtain obtains a certificate for the domain, answering the HTTP-01
+// challenges of its authorizations
+func (m *acmeManager) obtain(domain string) (*router.Certificate, error) {
+	if strings.HasPrefix(domain, "*.") {
+		return nil, errACMEWildcard
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), acmeObtainTimeout)
+	defer cancel()
+	client, err := m.account(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, url); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &router.Certificate{
+		Cert: string(certPEM),
+		Key:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}, nil
+}
+
+// authorize answers the HTTP-01 challenge of the authorization with the given
+// URL unless it is already valid, then waits for the ACME server to validate
+// it
+func (m *acmeManager) authorize(ctx context.Context, client *acme.Client, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("router: no HTTP-01 challenge offered for %s", authz.Identifier.Value)
+	}
+	keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return err
+	}
+	if err := m.addChallenge(chal.Token, keyAuth); err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.deleteChallenge(chal.Token); err != nil {
+			logger.Error("error removing ACME challenge", "fn", "authorize", "err", err)
+		}
+	}()
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+const (
+	sqlGetACMEAccount      = `SELECT key FROM acme_accounts WHERE directory_url = $1`
+	sqlAddACMEAccount      = `INSERT INTO acme_accounts (directory_url, key, url) VALUES ($1, $2, $3)`
+	sqlListACMEChallenges  = `SELECT token, key_authorization FROM acme_challenges`
+	sqlGetACMEChallenge    = `SELECT key_authorization FROM acme_challenges WHERE token = $1`
+	sqlAddACMEChallenge    = `INSERT INTO acme_challenges (token, key_authorization) VALUES ($1, $2)`
+	sqlRemoveACMEChallenge = `DELETE FROM acme_challenges WHERE token = $1`
+)
+
+// account returns a client for the ACME account, which is registered and
+// stored the first time it is needed
+func (m *acmeManager) account(ctx context.Context) (*acme.Client, error) {
+	if m.client != nil {
+		return m.client, nil
+	}
+	client := &acme.Client{DirectoryURL: m.directoryURL, HTTPClient: m.httpClient}
+
+	var keyPEM string
+	err := m.pgx.QueryRow(sqlGetACMEAccount, m.directoryURL).Scan(&keyPEM)
+	switch err {
+	case nil:
+		block, _ := pem.Decode([]byte(keyPEM))
+		if block == nil {
+			return nil, errors.New("router: invalid ACME account key")
+		}
+		if client.Key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	case pgx.ErrNoRows:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		client.Key = key
+		account, err := client.Register(ctx, &acme.Account{Contact: m.contact}, acme.AcceptTOS)
+		if err != nil {
+			return nil, err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+		if _, err := m.pgx.Exec(sqlAddACMEAccount, m.directoryURL, keyPEM, account.URI); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	m.client = client
+	return client, nil
+}
+
+// addChallenge stores the response to a challenge so that any router
+// instance can serve it
+func (m *acmeManager) addChallenge(token, keyAuth string) error {
+	if _, err := m.pgx.Exec(sqlAddACMEChallenge, token, keyAuth); err != nil {
+		return err
+	}
+	m.setChallenge(token, keyAuth)
+	return nil
+}
+
+// deleteChallenge removes the response to a challenge once it is no longer
+// pending
+func (m *acmeManager) deleteChallenge(token string) error {
+	if _, err := m.pgx.Exec(sqlRemoveACMEChallenge, token); err != nil {
+		return err
+	}
+	m.removeChallenge(token)
+	return nil
+}
+
+func (m *acmeManager) setChallenge(token, keyAuth string) {
+	m.challengesMtx.Lock()
+	defer m.challengesMtx.Unlock()
+	m.challenges[token] = keyAuth
+}
+
+func (m *acmeManager) removeChallenge(token string) {
+	m.challengesMtx.Lock()
+	defer m.challengesMtx.Unlock()
+	delete(m.challenges, token)
+}
+
+// startChallengeSync listens for challenges being added and removed and loads
+// the pending challenges, then keeps them up to date until the manager is
+// closed or an error is sent on the returned channel.
+func (m *acmeManager) startChallengeSync() (<-chan error, error) {
+	conn, err := m.pgx.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Listen(acmeChallengesChannel); err != nil {
+		m.pgx.Release(conn)
+		return nil, err
+	}
+	if err := m.loadChallenges(); err != nil {
+		unlistenAndRelease(m.pgx, conn, acmeChallengesChannel)
+		return nil, err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		defer unlistenAndRelease(m.pgx, conn, acmeChallengesChannel)
+		for {
+			select {
+			case <-m.stop:
+				errc <- nil
+				return
+			default:
+			}
+			notification, err := conn.WaitForNotification(time.Second)
+			if err == pgx.ErrNotificationTimeout {
+				continue
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			if err := m.updateChallenge(notification.Payload); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	return errc, nil
+}
+
+// runChallengeSync restarts syncing challenges whenever it fails until the
+// manager is closed
+func (m *acmeManager) runChallengeSync(errc <-chan error) {
+	defer close(m.syncDone)
+	log := logger.New("fn", "acmeManager.runChallengeSync")
+	for {
+		err := <-errc
+		if err == nil {
+			return
+		}
+		log.Error("error syncing ACME challenges", "err", err)
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-time.After(2 * time.Second):
+			}
+			if errc, err = m.startChallengeSync(); err == nil {
+				break
+			}
+			log.Error("error syncing ACME challenges", "err", err)
+		}
+	}
+}
+
+// loadChallenges replaces the challenges with those currently in Postgres
+func (m *acmeManager) loadChallenges() error {
+	rows, err := m.pgx.Query(sqlListACMEChallenges)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	challenges := make(map[string]string)
+	for rows.Next() {
+		var token, keyAuth string
+		if err := rows.Scan(&token, &keyAuth); err != nil {
+			return err
+		}
+		challenges[token] = keyAuth
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	m.challengesMtx.Lock()
+	m.challenges = challenges
+	m.challengesMtx.Unlock()
+	return nil
+}
+
+// updateChallenge updates the challenge with the given token after being
+// notified that it was added or removed
+func (m *acmeManager) updateChallenge(token string) error {
+	var keyAuth string
+	switch err := m.pgx.QueryRow(sqlGetACMEChallenge, token).Scan(&keyAuth); err {
+	case nil:
+		m.setChallenge(token, keyAuth)
+	case pgx.ErrNoRows:
+		m.removeChallenge(token)
+	default:
+		return err
+	}
+	return nil
+}
+
+// ServeChallenge serves the response to an HTTP-01 challenge, returning false
+// if the request is not for a pending challenge so that it can be routed to
+// the app instead.
+func (m *acmeManager) ServeChallenge(w http.ResponseWriter, req *http.Request) bool {
+	token := strings.TrimPrefix(req.URL.Path, acmeChallengePath)
+	m.challengesMtx.RLock()
+	keyAuth, ok := m.challenges[token]
+	m.challengesMtx.RUnlock()
+	if !ok {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+	return true
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/router/types"
+	. "github.com/flynn/go-check"
+)
+
+// pebbleHTTPAddr is the address Pebble connects to when validating HTTP-01
+// challenges, with its DNS server resolving every domain to 127.0.0.1
+const pebbleHTTPAddr = "127.0.0.1:5002"
+
+// TestAutoTLS obtains a certificate from a Pebble ACME server, which is
+// configured with the PEBBLE_DIRECTORY_URL and PEBBLE_CA environment variables
+// (the latter being the path to the certificate Pebble serves its API with).
+func (s *S) TestAutoTLS(c *C) {
+	directoryURL := os.Getenv("PEBBLE_DIRECTORY_URL")
+	if directoryURL == "" {
+		c.Skip("Pebble not configured")
+	}
+	caPEM, err := ioutil.ReadFile(os.Getenv("PEBBLE_CA"))
+	c.Assert(err, IsNil)
+	pool := x509.NewCertPool()
+	c.Assert(pool.AppendCertsFromPEM(caPEM), Equals, true)
+
+	srv := httptest.NewServer(httpTestHandler("1"))
+	defer srv.Close()
+
+	l := &HTTPListener{
+		Addr:      pebbleHTTPAddr,
+		TLSAddr:   "127.0.0.1:0",
+		ds:        NewPostgresDataStore("http", s.pgx),
+		discoverd: s.discoverd,
+		acme:      newACMEManager(directoryURL, "admin@example.com", s.pgx),
+	}
+	l.acme.httpClient = &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+	c.Assert(l.Start(), IsNil)
+	defer l.Close()
+
+	route := addRoute(c, l, router.HTTPRoute{
+		Domain:  "auto-tls.example.com",
+		Service: "test",
+		AutoTLS: true,
+	}.ToRoute())
+	discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+
+	// wait for the certificate to be obtained and stored with the route
+	var cert *router.Certificate
+	for start := time.Now(); time.Since(start) < 30*time.Second; time.Sleep(100 * time.Millisecond) {
+		r, err := l.Get(route.ID)
+		c.Assert(err, IsNil)
+		if r.Certificate != nil {
+			cert = r.Certificate
+			break
+		}
+	}
+	c.Assert(cert, NotNil)
+	c.Assert(needsCert(&router.Route{Domain: route.Domain, Certificate: cert}, time.Now()), Equals, false)
+
+	// the challenge response is removed once validated
+	var count int
+	c.Assert(s.pgx.QueryRow("SELECT count(*) FROM acme_challenges").Scan(&count), IsNil)
+	c.Assert(count, Equals, 0)
+
+	// the certificate is served once the route has been synced (Pebble's
+	// root is only available from its management API, so the served
+	// certificate is compared with the stored one rather than verified)
+	block, _ := pem.Decode([]byte(cert.Cert))
+	c.Assert(block, NotNil)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: route.Domain, InsecureSkipVerify: true},
+	}}
+	var res *http.Response
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(100 * time.Millisecond) {
+		res, err = client.Do(newReq("https://"+l.TLSAddr, route.Domain))
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(res.TLS.PeerCertificates[0].Raw, block.Bytes) {
+			break
+		}
+		res.Body.Close()
+		res, err = nil, errors.New("certificate not served")
+	}
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "1")
+
+	// requests for unknown challenges are routed to the app
+	assertGet(c, "http://"+l.Addr+acmeChallengePath+"unknown", route.Domain, "1")
+}
+
+func (s *S) TestACMEChallengeSync(c *C) {
+	_, err := s.pgx.Exec(sqlAddACMEChallenge, "sync-token-1", "sync-token-1.key")
+	c.Assert(err, IsNil)
+	defer s.pgx.Exec(sqlRemoveACMEChallenge, "sync-token-1")
+
+	// challenges which are pending when the manager starts are loaded
+	m := newACMEManager("", "", s.pgx)
+	errc, err := m.startChallengeSync()
+	c.Assert(err, IsNil)
+	go m.runChallengeSync(errc)
+	defer func() {
+		close(m.stop)
+		<-m.syncDone
+	}()
+
+	serve := func(token string) (bool, string) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://example.com"+acmeChallengePath+token, nil)
+		c.Assert(err, IsNil)
+		ok := m.ServeChallenge(w, req)
+		return ok, w.Body.String()
+	}
+	ok, body := serve("sync-token-1")
+	c.Assert(ok, Equals, true)
+	c.Assert(body, Equals, "sync-token-1.key")
+
+	// challenges added and removed by other router instances are synced
+	waitServed := func(token string, expected bool) {
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			if ok, _ := serve(token); ok == expected {
+				return
+			}
+		}
+		c.Fatalf("timed out waiting for challenge %s to be synced", token)
+	}
+	_, err = s.pgx.Exec(sqlAddACMEChallenge, "sync-token-2", "sync-token-2.key")
+	c.Assert(err, IsNil)
+	defer s.pgx.Exec(sqlRemoveACMEChallenge, "sync-token-2")
+	waitServed("sync-token-2", true)
+	_, body = serve("sync-token-2")
+	c.Assert(body, Equals, "sync-token-2.key")
+	_, err = s.pgx.Exec(sqlRemoveACMEChallenge, "sync-token-1")
+	c.Assert(err, IsNil)
+	waitServed("sync-token-1", false)
+}
+
+func (s *S) TestAPIAutoTLSWildcardDomain(c *C) {
+	srv := s.newTestAPIServer(c)
+	defer srv.Close()
+
+	err := srv.CreateRoute(router.HTTPRoute{
+		Domain:  "*.auto-tls.example.com",
+		Service: "test",
+		AutoTLS: true,
+	}.ToRoute())
+	c.Assert(err, NotNil)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestNeedsCert(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	now := time.Now()
+	generate := func(domain string, lifetime, remaining time.Duration) *router.Certificate {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: domain},
+			DNSNames:     []string{domain},
+			NotBefore:    now.Add(remaining - lifetime),
+			NotAfter:     now.Add(remaining),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		c.Assert(err, IsNil)
+		return &router.Certificate{Cert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))}
+	}
+	day := 24 * time.Hour
+
+	for _, t := range []struct {
+		desc     string
+		cert     *router.Certificate
+		expected bool
+	}{
+		{"no certificate", nil, true},
+		{"invalid certificate", &router.Certificate{Cert: "invalid"}, true},
+		{"different domain", generate("other.example.com", 90*day, 89*day), true},
+		{"new certificate", generate("example.com", 90*day, 89*day), false},
+		{"expiring certificate", generate("example.com", 90*day, 29*day), true},
+		{"new short-lived certificate", generate("example.com", 6*day, 5*day), false},
+		{"expiring short-lived certificate", generate("example.com", 6*day, day), true},
+	} {
+		r := &router.Route{Domain: "example.com", Certificate: t.cert}
+		c.Assert(needsCert(r, now), Equals, t.expected, Commentf(t.desc))
+	}
+}
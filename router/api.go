@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"sort"
+	"strings"
 
 	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
@@ -23,7 +24,7 @@ func apiHandler(rtr *Router) http.Handler {
 	api := &API{router: rtr}
 	r := httprouter.New()
 
-	r.HandlerFunc("GET", status.Path, status.HealthyHandler.ServeHTTP)
+	r.HandlerFunc("GET", status.Path, status.Handler(api.Status).ServeHTTP)
 
 	r.POST("/routes", httphelper.WrapHandler(api.CreateRoute))
 	r.PUT("/routes/:route_type/:id", httphelper.WrapHandler(api.UpdateRoute))
@@ -36,12 +37,31 @@ func apiHandler(rtr *Router) http.Handler {
 	r.DELETE("/certificates/:id", httphelper.WrapHandler(api.DeleteCert))
 	r.GET("/certificates", httphelper.WrapHandler(api.GetCerts))
 	r.GET("/events", httphelper.WrapHandler(api.StreamEvents))
+	r.GET("/stats/http", httphelper.WrapHandler(api.GetHTTPServiceStats))
+	r.GET("/metrics", httphelper.WrapHandler(api.GetMetrics))
 
 	r.HandlerFunc("GET", "/debug/*path", pprof.Handler.ServeHTTP)
 
 	return httphelper.ContextInjector("router", httphelper.NewRequestLogger(r))
 }
 
+// Status reports the router as healthy, with the number of requests and
+// connections rejected by route limits as the detail
+func (api *API) Status() status.Status {
+	limits := make([]*router.RouteLimitStats, 0)
+	if l, ok := api.router.HTTP.(*HTTPListener); ok {
+		limits = append(limits, l.LimitStats()...)
+	}
+	if l, ok := api.router.TCP.(*TCPListener); ok {
+		limits = append(limits, l.LimitStats()...)
+	}
+	s, err := status.New(true, map[string]interface{}{"limits": limits})
+	if err != nil {
+		return status.Healthy
+	}
+	return s
+}
+
 func (api *API) CreateRoute(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	log, _ := ctxhelper.LoggerFromContext(ctx)
 
@@ -57,6 +77,10 @@ func (api *API) CreateRoute(ctx context.Context, w http.ResponseWriter, req *htt
 		httphelper.ValidationError(w, "type", "Invalid route type")
 		return
 	}
+	if route.AutoTLS && strings.HasPrefix(route.Domain, "*.") {
+		httphelper.ValidationError(w, "auto_tls", "Certificates cannot be obtained automatically for wildcard domains")
+		return
+	}
 
 	err := l.AddRoute(route)
 	if err != nil {
@@ -104,6 +128,10 @@ func (api *API) UpdateRoute(ctx context.Context, w http.ResponseWriter, req *htt
 		httphelper.ValidationError(w, "type", "Invalid route type")
 		return
 	}
+	if route.AutoTLS && strings.HasPrefix(route.Domain, "*.") {
+		httphelper.ValidationError(w, "auto_tls", "Certificates cannot be obtained automatically for wildcard domains")
+		return
+	}
 
 	if err := l.UpdateRoute(route); err != nil {
 		if err == ErrNotFound {
@@ -274,6 +302,21 @@ func (api *API) GetCerts(ctx context.Context, w http.ResponseWriter, req *http.R
 	httphelper.JSON(w, 200, certs)
 }
 
+func (api *API) GetHTTPServiceStats(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	l := api.router.HTTP.(*HTTPListener)
+	httphelper.JSON(w, 200, l.ServiceStats())
+}
+
+// GetMetrics serves per-route HTTP metrics in the Prometheus text format
+func (api *API) GetMetrics(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	l := api.router.HTTP.(*HTTPListener)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := l.Metrics(w); err != nil {
+		log, _ := ctxhelper.LoggerFromContext(ctx)
+		log.Error("error writing metrics", "err", err)
+	}
+}
+
 func (api *API) DeleteCert(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 
@@ -148,12 +148,13 @@ func (s *S) TestAPISetHTTPRoute(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(r.ID, Not(IsNil))
 
-	r = router.HTTPRoute{ID: r.ID, Domain: "example.com", Service: "bar", Leader: true, Sticky: true}.ToRoute()
+	r = router.HTTPRoute{ID: r.ID, Domain: "example.com", Service: "bar", Leader: true, Sticky: true, Release: "release-1"}.ToRoute()
 	err = srv.UpdateRoute(r)
 	c.Assert(err, IsNil)
 	r, err = srv.GetRoute("http", r.ID)
 	c.Assert(err, IsNil)
 	c.Assert(r.Sticky, Equals, true)
+	c.Assert(r.Release, Equals, "release-1")
 	c.Assert(r.Leader, Equals, true)
 	c.Assert(r.Service, Equals, "bar")
 }
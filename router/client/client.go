@@ -76,6 +76,10 @@ type Client interface {
 	ListCerts() ([]*router.Certificate, error)
 	// ListCertRoutes returns a list of routes assigned to the specified certificate.
 	ListCertRoutes(id string) ([]*router.Route, error)
+
+	// ListHTTPServiceStats returns request counters for the services
+	// referenced by HTTP routes.
+	ListHTTPServiceStats() ([]*router.HTTPServiceStats, error)
 }
 
 func (c *client) CreateRoute(r *router.Route) error {
@@ -137,3 +141,9 @@ func (c *client) ListCertRoutes(id string) ([]*router.Route, error) {
 	err := c.Get(fmt.Sprintf("/certificates/%s/routes", id), &res)
 	return res, err
 }
+
+func (c *client) ListHTTPServiceStats() ([]*router.HTTPServiceStats, error) {
+	var res []*router.HTTPServiceStats
+	err := c.Get("/stats/http", &res)
+	return res, err
+}
@@ -86,13 +86,13 @@ func (d *pgDataStore) Ping() error {
 }
 
 const sqlAddRouteHTTP = `
-INSERT INTO ` + tableNameHTTP + ` (parent_ref, service, leader, domain, sticky, path)
-	VALUES ($1, $2, $3, $4, $5, $6)
+INSERT INTO ` + tableNameHTTP + ` (parent_ref, service, leader, domain, sticky, path, release, targets, limits, auto_tls)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 	RETURNING id, created_at, updated_at`
 
 const sqlAddRouteTCP = `
-INSERT INTO ` + tableNameTCP + ` (parent_ref, service, leader, port)
-	VALUES ($1, $2, $3, $4)
+INSERT INTO ` + tableNameTCP + ` (parent_ref, service, leader, port, limits)
+	VALUES ($1, $2, $3, $4, $5)
 	RETURNING id, created_at, updated_at`
 
 func (d *pgDataStore) Add(r *router.Route) (err error) {
@@ -127,6 +127,10 @@ func (d *pgDataStore) addHTTP(r *router.Route) error {
 		r.Domain,
 		r.Sticky,
 		r.Path,
+		r.Release,
+		r.Targets,
+		r.Limits,
+		r.AutoTLS,
 	).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt); err != nil {
 		tx.Rollback()
 		return err
@@ -145,6 +149,7 @@ func (d *pgDataStore) addTCP(r *router.Route) error {
 		r.Service,
 		r.Leader,
 		r.Port,
+		r.Limits,
 	).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
 }
 
@@ -316,13 +321,13 @@ func (d *pgDataStore) RemoveCert(id string) error {
 
 const sqlUpdateRouteHTTP = `
 UPDATE ` + tableNameHTTP + ` AS r
-	SET parent_ref = $1, service = $2, leader = $3, sticky = $4, path = $5
-	WHERE id = $6 AND domain = $7 AND deleted_at IS NULL
+	SET parent_ref = $1, service = $2, leader = $3, sticky = $4, path = $5, release = $6, targets = $7, limits = $8, auto_tls = $9
+	WHERE id = $10 AND domain = $11 AND deleted_at IS NULL
 	RETURNING %s`
 
 const sqlUpdateRouteTCP = `
-UPDATE ` + tableNameTCP + ` SET parent_ref = $1, service = $2, leader = $3
-	WHERE id = $4 AND port = $5 AND deleted_at IS NULL
+UPDATE ` + tableNameTCP + ` SET parent_ref = $1, service = $2, leader = $3, limits = $4
+	WHERE id = $5 AND port = $6 AND deleted_at IS NULL
 	RETURNING %s`
 
 func (d *pgDataStore) Update(r *router.Route) error {
@@ -352,6 +357,10 @@ func (d *pgDataStore) updateHTTP(r *router.Route) error {
 		r.Leader,
 		r.Sticky,
 		r.Path,
+		r.Release,
+		r.Targets,
+		r.Limits,
+		r.AutoTLS,
 		r.ID,
 		r.Domain,
 	)); err != nil {
@@ -371,6 +380,7 @@ func (d *pgDataStore) updateTCP(r *router.Route) error {
 		r.ParentRef,
 		r.Service,
 		r.Leader,
+		r.Limits,
 		r.ID,
 		r.Port,
 	))
@@ -563,9 +573,9 @@ func (d *pgDataStore) startListener(ctx context.Context) (<-chan string, <-chan
 }
 
 const (
-	selectColumnsHTTP     = "r.id, r.parent_ref, r.service, r.leader, r.domain, r.sticky, r.path, r.created_at, r.updated_at"
+	selectColumnsHTTP     = "r.id, r.parent_ref, r.service, r.leader, r.domain, r.sticky, r.path, r.release, r.targets, r.limits, r.auto_tls, r.created_at, r.updated_at"
 	selectColumnsHTTPCert = "c.id, c.cert, c.key, c.created_at, c.updated_at"
-	selectColumnsTCP      = "id, parent_ref, service, leader, port, created_at, updated_at"
+	selectColumnsTCP      = "id, parent_ref, service, leader, port, limits, created_at, updated_at"
 )
 
 func (d *pgDataStore) columnNames() string {
@@ -595,6 +605,10 @@ func (d *pgDataStore) scanRouteWithoutCert(route *router.Route, s scannable) err
 			&route.Domain,
 			&route.Sticky,
 			&route.Path,
+			&route.Release,
+			&route.Targets,
+			&route.Limits,
+			&route.AutoTLS,
 			&route.CreatedAt,
 			&route.UpdatedAt,
 		)
@@ -605,6 +619,7 @@ func (d *pgDataStore) scanRouteWithoutCert(route *router.Route, s scannable) err
 			&route.Service,
 			&route.Leader,
 			&route.Port,
+			&route.Limits,
 			&route.CreatedAt,
 			&route.UpdatedAt,
 		)
@@ -626,6 +641,10 @@ func (d *pgDataStore) scanRoute(route *router.Route, s scannable) error {
 			&route.Domain,
 			&route.Sticky,
 			&route.Path,
+			&route.Release,
+			&route.Targets,
+			&route.Limits,
+			&route.AutoTLS,
 			&route.CreatedAt,
 			&route.UpdatedAt,
 			&certID,
@@ -653,6 +672,7 @@ func (d *pgDataStore) scanRoute(route *router.Route, s scannable) error {
 			&route.Service,
 			&route.Leader,
 			&route.Port,
+			&route.Limits,
 			&route.CreatedAt,
 			&route.UpdatedAt,
 		)
@@ -5,12 +5,14 @@ import (
 	"crypto/tls"
 	"encoding/hex"
 	"errors"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/flynn/flynn/discoverd/cache"
@@ -47,6 +49,10 @@ type HTTPListener struct {
 	cookieKey   *[32]byte
 	keypair     tls.Certificate
 
+	metrics   *httpMetrics
+	accessLog *accessLog
+	acme      *acmeManager
+
 	preSync  func()
 	postSync func(<-chan struct{})
 }
@@ -72,6 +78,12 @@ func (s *HTTPListener) Close() error {
 	if s.tlsListener != nil {
 		s.tlsListener.Close()
 	}
+	if s.accessLog != nil {
+		s.accessLog.Close()
+	}
+	if s.acme != nil {
+		s.acme.Close()
+	}
 	s.closed = true
 	return nil
 }
@@ -100,6 +112,9 @@ func (s *HTTPListener) Start() error {
 	if s.cookieKey == nil {
 		s.cookieKey = &[32]byte{}
 	}
+	if s.metrics == nil {
+		s.metrics = newHTTPMetrics()
+	}
 
 	if err := s.startSync(ctx); err != nil {
 		s.Close()
@@ -111,6 +126,13 @@ func (s *HTTPListener) Start() error {
 		return err
 	}
 
+	if s.acme != nil {
+		if err := s.acme.Start(s); err != nil {
+			s.Close()
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -288,36 +310,53 @@ func (h *httpSyncHandler) Set(data *router.Route) error {
 		return nil
 	}
 
-	service := h.l.services[r.Service]
-	if service != nil && service.name != r.Service {
-		service.refs--
-		if service.refs <= 0 {
-			service.sc.Close()
-			delete(h.l.services, service.name)
+	service, err := h.l.addServiceRef(r.Service)
+	if err != nil {
+		return err
+	}
+	if len(r.Targets) > 0 {
+		backends := make([]*proxy.WeightedBackends, 0, len(r.Targets))
+		for _, t := range r.Targets {
+			ts, err := h.l.addServiceRef(t.Service)
+			if err != nil {
+				h.l.removeServiceRef(service)
+				for _, ts := range r.targets {
+					h.l.removeServiceRef(ts)
+				}
+				return err
+			}
+			r.targets = append(r.targets, ts)
+			backends = append(backends, &proxy.WeightedBackends{
+				Weight:   t.Weight,
+				Backends: backendListFunc(ts.sc, false, t.Release),
+			})
 		}
-		service = nil
+		r.rp = proxy.NewWeightedReverseProxy(backends, h.l.cookieKey, r.Sticky, logger)
+	} else {
+		r.rp = proxy.NewReverseProxy(backendListFunc(service.sc, r.Leader, r.Release), h.l.cookieKey, r.Sticky, logger)
 	}
-	if service == nil {
-		sc, err := cache.New(h.l.discoverd.Service(r.Service))
-		if err != nil {
-			return err
+	r.service = service
+	if r.Limits != nil {
+		if r.Limits.RequestsPerSecond > 0 {
+			r.rateLimiter = newRateLimiter(r.Limits.RequestsPerSecond)
 		}
-
-		service = &httpService{
-			name: r.Service,
-			sc:   sc,
+		if r.Limits.MaxConcurrentRequests > 0 {
+			r.concurrency = newConcurrencyLimiter(r.Limits.MaxConcurrentRequests)
 		}
-		h.l.services[r.Service] = service
 	}
-	service.refs++
-	var bf proxy.BackendListFunc
-	if r.Leader {
-		bf = service.sc.LeaderAddr
+	if existing, ok := h.l.routes[data.ID]; ok {
+		r.limited = existing.limited
+
+		// release the services of the route being replaced now that
+		// the new route holds its own refs, which keeps the caches of
+		// services still in use open
+		h.l.removeServiceRef(existing.service)
+		for _, ts := range existing.targets {
+			h.l.removeServiceRef(ts)
+		}
 	} else {
-		bf = service.sc.Addrs
+		r.limited = &limitCounters{}
 	}
-	r.rp = proxy.NewReverseProxy(bf, h.l.cookieKey, r.Sticky, logger)
-	r.service = service
 	h.l.routes[data.ID] = r
 	if data.Path == "/" {
 		if tree, ok := h.l.domains[strings.ToLower(r.Domain)]; ok {
@@ -348,13 +387,13 @@ func (h *httpSyncHandler) Remove(id string) error {
 		return ErrNotFound
 	}
 
-	r.service.refs--
-	if r.service.refs <= 0 {
-		r.service.sc.Close()
-		delete(h.l.services, r.service.name)
+	h.l.removeServiceRef(r.service)
+	for _, service := range r.targets {
+		h.l.removeServiceRef(service)
 	}
 
 	delete(h.l.routes, id)
+	h.l.metrics.Remove(id)
 	if tree, ok := h.l.domains[r.Domain]; ok {
 		if r.Path == "/" && tree.backend == r {
 			delete(h.l.domains, r.Domain)
@@ -470,15 +509,33 @@ func fail(w http.ResponseWriter, code int) {
 }
 
 func (s *HTTPListener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
 	ctx := context.Background()
-	ctx = ctxhelper.NewContextStartTime(ctx, time.Now())
+	ctx = ctxhelper.NewContextStartTime(ctx, start)
+	if s.acme != nil && strings.HasPrefix(req.URL.Path, acmeChallengePath) && s.acme.ServeChallenge(w, req) {
+		return
+	}
 	r := s.findRoute(req.Host, req.URL.Path)
 	if r == nil {
 		fail(w, 404)
 		return
 	}
 
-	r.ServeHTTP(ctx, w, req)
+	info := &proxy.RequestInfo{}
+	ctx = proxy.NewContextRequestInfo(ctx, info)
+	rw := &responseRecorder{ResponseWriter: w}
+	r.ServeHTTP(ctx, rw, req)
+
+	duration := time.Since(start)
+	s.metrics.Observe(r, rw.Status(), rw.bytes, duration)
+	if s.accessLog != nil {
+		s.accessLog.Log(r, req, info, rw, duration)
+	}
+}
+
+// Metrics writes per-route request metrics in the Prometheus text format.
+func (s *HTTPListener) Metrics(w io.Writer) error {
+	return s.metrics.WriteText(w)
 }
 
 // A domain served by a listener, associated TLS certs,
@@ -488,21 +545,125 @@ type httpRoute struct {
 
 	keypair *tls.Certificate
 	service *httpService
+	targets []*httpService
 	rp      *proxy.ReverseProxy
+
+	rateLimiter *rateLimiter
+	concurrency *concurrencyLimiter
+	limited     *limitCounters
 }
 
 // A service definition: name, and set of backends.
 type httpService struct {
+	// requests is accessed atomically so must be the first field to
+	// guarantee 64-bit alignment
+	requests uint64
+
 	name string
 	sc   cache.ServiceCache
 	refs int
 }
 
+// addServiceRef returns the named service, creating it if it is not yet
+// referenced by any route. s.mtx must be held by the caller.
+func (s *HTTPListener) addServiceRef(name string) (*httpService, error) {
+	service := s.services[name]
+	if service == nil {
+		sc, err := cache.New(s.discoverd.Service(name))
+		if err != nil {
+			return nil, err
+		}
+		service = &httpService{
+			name: name,
+			sc:   sc,
+		}
+		s.services[name] = service
+	}
+	service.refs++
+	return service, nil
+}
+
+// ServiceStats returns request counters for the services currently
+// referenced by HTTP routes.
+func (s *HTTPListener) ServiceStats() []*router.HTTPServiceStats {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	stats := make([]*router.HTTPServiceStats, 0, len(s.services))
+	for name, service := range s.services {
+		stats = append(stats, &router.HTTPServiceStats{
+			Service:  name,
+			Requests: atomic.LoadUint64(&service.requests),
+		})
+	}
+	return stats
+}
+
+// LimitStats returns the number of requests rejected by the limits of each
+// route which has limits.
+func (s *HTTPListener) LimitStats() []*router.RouteLimitStats {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	stats := make([]*router.RouteLimitStats, 0)
+	for _, r := range s.routes {
+		if r.Limits == nil {
+			continue
+		}
+		stats = append(stats, &router.RouteLimitStats{
+			Route:              r.FormattedID(),
+			RateLimited:        atomic.LoadUint64(&r.limited.rate),
+			ConcurrencyLimited: atomic.LoadUint64(&r.limited.concurrency),
+		})
+	}
+	return stats
+}
+
+// removeServiceRef drops a route's reference to the service, closing it if
+// no routes reference it any more. s.mtx must be held by the caller.
+func (s *HTTPListener) removeServiceRef(service *httpService) {
+	service.refs--
+	if service.refs <= 0 {
+		service.sc.Close()
+		delete(s.services, service.name)
+	}
+}
+
+// backendListFunc returns a function which lists the addresses of either the
+// service leader, or all service instances optionally restricted to those
+// belonging to the given release.
+func backendListFunc(sc cache.ServiceCache, leader bool, release string) proxy.BackendListFunc {
+	if leader {
+		return sc.LeaderAddr
+	}
+	if release != "" {
+		return func() []string { return sc.AddrsWithMeta("FLYNN_RELEASE_ID", release) }
+	}
+	return sc.Addrs
+}
+
 func (r *httpRoute) ServeHTTP(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	start, _ := ctxhelper.StartTimeFromContext(ctx)
 	req.Header.Set("X-Request-Start", strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10))
 	req.Header.Set("X-Request-Id", random.UUID())
 
+	if r.rateLimiter != nil {
+		clientIP, _, _ := net.SplitHostPort(req.RemoteAddr)
+		if !r.rateLimiter.Allow(clientIP) {
+			atomic.AddUint64(&r.limited.rate, 1)
+			w.Header().Set("Retry-After", "1")
+			fail(w, http.StatusTooManyRequests)
+			return
+		}
+	}
+	if r.concurrency != nil {
+		if !r.concurrency.Acquire() {
+			atomic.AddUint64(&r.limited.concurrency, 1)
+			fail(w, http.StatusTooManyRequests)
+			return
+		}
+		defer r.concurrency.Release()
+	}
+
+	atomic.AddUint64(&r.service.requests, 1)
 	r.rp.ServeHTTP(ctx, w, req)
 }
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -17,9 +18,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/flynn/flynn/discoverd/cache"
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/discoverd/testutil"
 	"github.com/flynn/flynn/pkg/httpclient"
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	"github.com/flynn/flynn/pkg/syslog/rfc6587"
 	"github.com/flynn/flynn/pkg/tlscert"
 	"github.com/flynn/flynn/router/types"
 	. "github.com/flynn/go-check"
@@ -545,6 +549,321 @@ func (s *S) TestLeaderRouting(c *C) {
 	assertGet(c, "http://"+l.Addr, "foo.bar", "2")
 }
 
+func (s *S) TestReleaseRouting(c *C) {
+	srv1 := httptest.NewServer(httpTestHandler("1"))
+	srv2 := httptest.NewServer(httpTestHandler("2"))
+	defer srv1.Close()
+	defer srv2.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	r := addRoute(c, l, router.HTTPRoute{
+		Domain:  "foo.bar",
+		Service: "release-routing-http",
+		Release: "release-1",
+	}.ToRoute())
+
+	discoverdRegisterHTTPInstance(c, l, "release-routing-http", &discoverd.Instance{
+		Addr: srv1.Listener.Addr().String(),
+		Meta: map[string]string{"FLYNN_RELEASE_ID": "release-1"},
+	})
+	discoverdRegisterHTTPInstance(c, l, "release-routing-http", &discoverd.Instance{
+		Addr: srv2.Listener.Addr().String(),
+		Meta: map[string]string{"FLYNN_RELEASE_ID": "release-2"},
+	})
+
+	for i := 0; i < 10; i++ {
+		assertGet(c, "http://"+l.Addr, "foo.bar", "1")
+	}
+
+	// switch the route to the other release
+	wait := waitForEvent(c, l, "set", "")
+	r.Release = "release-2"
+	c.Assert(l.UpdateRoute(r), IsNil)
+	wait()
+	httpClient.Transport.(*http.Transport).CloseIdleConnections()
+
+	for i := 0; i < 10; i++ {
+		assertGet(c, "http://"+l.Addr, "foo.bar", "2")
+	}
+}
+
+func (s *S) TestWeightedRouting(c *C) {
+	srv1 := httptest.NewServer(httpTestHandler("1"))
+	srv2 := httptest.NewServer(httpTestHandler("2"))
+	defer srv1.Close()
+	defer srv2.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	r := addRoute(c, l, router.HTTPRoute{
+		Domain:  "foo.bar",
+		Service: "weighted-routing-1",
+		Targets: []*router.Target{
+			{Service: "weighted-routing-1", Weight: 1},
+			{Service: "weighted-routing-2", Weight: 0},
+		},
+	}.ToRoute())
+
+	unregister := discoverdRegisterHTTPService(c, l, "weighted-routing-1", srv1.Listener.Addr().String())
+	discoverdRegisterHTTPService(c, l, "weighted-routing-2", srv2.Listener.Addr().String())
+
+	// a target with no weight does not receive traffic
+	for i := 0; i < 10; i++ {
+		assertGet(c, "http://"+l.Addr, "foo.bar", "1")
+	}
+
+	// traffic is split between targets with weights
+	wait := waitForEvent(c, l, "set", "")
+	r.Targets[1].Weight = 1
+	c.Assert(l.UpdateRoute(r), IsNil)
+	wait()
+	seen := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		res, err := newHTTPClient("foo.bar").Do(newReq("http://"+l.Addr, "foo.bar"))
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.Assert(err, IsNil)
+		seen[string(data)]++
+	}
+	c.Assert(seen["1"] > 0, Equals, true)
+	c.Assert(seen["2"] > 0, Equals, true)
+	c.Assert(seen["1"]+seen["2"], Equals, 100)
+
+	// other targets are used when a target has no backends
+	unregister()
+	for i := 0; i < 10; i++ {
+		assertGet(c, "http://"+l.Addr, "foo.bar", "2")
+	}
+}
+
+func (s *S) TestHTTPRouteUpdateReleasesServices(c *C) {
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	r := addRoute(c, l, router.HTTPRoute{
+		Domain:  "foo.bar",
+		Service: "update-services-1",
+		Targets: []*router.Target{
+			{Service: "update-services-1", Weight: 1},
+			{Service: "update-services-2", Weight: 1},
+		},
+	}.ToRoute())
+
+	services := func() map[string]int {
+		l.mtx.RLock()
+		defer l.mtx.RUnlock()
+		refs := make(map[string]int, len(l.services))
+		for name, service := range l.services {
+			refs[name] = service.refs
+		}
+		return refs
+	}
+	c.Assert(services(), DeepEquals, map[string]int{"update-services-1": 2, "update-services-2": 1})
+
+	// replacing a target closes the service which is no longer used and
+	// keeps a single set of refs to the others
+	wait := waitForEvent(c, l, "set", "")
+	r.Targets[1].Service = "update-services-3"
+	c.Assert(l.UpdateRoute(r), IsNil)
+	wait()
+	c.Assert(services(), DeepEquals, map[string]int{"update-services-1": 2, "update-services-3": 1})
+
+	wait = waitForEvent(c, l, "set", "")
+	r.Targets = nil
+	c.Assert(l.UpdateRoute(r), IsNil)
+	wait()
+	c.Assert(services(), DeepEquals, map[string]int{"update-services-1": 1})
+}
+
+func (s *S) TestHTTPServiceStats(c *C) {
+	srv := httptest.NewServer(httpTestHandler("1"))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addHTTPRoute(c, l)
+	discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+
+	for i := 0; i < 5; i++ {
+		assertGet(c, "http://"+l.Addr, "example.com", "1")
+	}
+	stats := l.ServiceStats()
+	c.Assert(stats, HasLen, 1)
+	c.Assert(stats[0].Service, Equals, "test")
+	c.Assert(stats[0].Requests, Equals, uint64(5))
+}
+
+func (s *S) TestHTTPRateLimit(c *C) {
+	srv := httptest.NewServer(httpTestHandler("1"))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{
+		Domain:  "example.com",
+		Service: "test",
+		Limits:  &router.RouteLimits{RequestsPerSecond: 1},
+	}.ToRoute())
+	discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+
+	assertGet(c, "http://"+l.Addr, "example.com", "1")
+
+	res, err := newHTTPClient("example.com").Do(newReq("http://"+l.Addr, "example.com"))
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 429)
+	c.Assert(res.Header.Get("Retry-After"), Equals, "1")
+
+	stats := l.LimitStats()
+	c.Assert(stats, HasLen, 1)
+	c.Assert(stats[0].RateLimited, Equals, uint64(1))
+	c.Assert(stats[0].ConcurrencyLimited, Equals, uint64(0))
+
+	// requests are allowed again once the bucket has refilled
+	time.Sleep(time.Second)
+	assertGet(c, "http://"+l.Addr, "example.com", "1")
+}
+
+func (s *S) TestHTTPConcurrencyLimit(c *C) {
+	started := make(chan struct{}, 1)
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-done
+		w.Write([]byte("1"))
+	}))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{
+		Domain:  "example.com",
+		Service: "test",
+		Limits:  &router.RouteLimits{MaxConcurrentRequests: 1},
+	}.ToRoute())
+	discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+
+	errs := make(chan error)
+	go func() {
+		res, err := newHTTPClient("example.com").Do(newReq("http://"+l.Addr, "example.com"))
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode != 200 {
+				err = fmt.Errorf("unexpected status %d", res.StatusCode)
+			}
+		}
+		errs <- err
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for request")
+	}
+
+	res, err := newHTTPClient("example.com").Do(newReq("http://"+l.Addr, "example.com"))
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 429)
+
+	close(done)
+	c.Assert(<-errs, IsNil)
+	assertGet(c, "http://"+l.Addr, "example.com", "1")
+
+	stats := l.LimitStats()
+	c.Assert(stats, HasLen, 1)
+	c.Assert(stats[0].ConcurrencyLimited, Equals, uint64(1))
+}
+
+func (s *S) TestHTTPAccessLogAndMetrics(c *C) {
+	srv := httptest.NewServer(httpTestHandler("1"))
+	defer srv.Close()
+
+	// start a fake logaggregator which receives the access logs
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer ln.Close()
+	msgs := make(chan *rfc5424.Message)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		sc.Split(rfc6587.Split)
+		for sc.Scan() {
+			msg, err := rfc5424.Parse(append([]byte(nil), sc.Bytes()...))
+			if err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	sc, err := cache.New(s.discoverd.Service("test-logaggregator"))
+	c.Assert(err, IsNil)
+	defer sc.Close()
+	discoverdRegister(c, s.discoverd, sc.(serviceCache), "test-logaggregator", ln.Addr().String())
+
+	l := s.newHTTPListener(c)
+	l.accessLog = newAccessLog("test-job", sc)
+	defer l.Close()
+
+	route := addRoute(c, l, router.HTTPRoute{
+		Domain:    "example.com",
+		Service:   "test",
+		ParentRef: appParentRefPrefix + "test-app",
+	}.ToRoute())
+	discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+
+	assertGet(c, "http://"+l.Addr+"/foo", "example.com", "1")
+
+	select {
+	case msg := <-msgs:
+		c.Assert(string(msg.AppName), Equals, "test-app")
+		c.Assert(string(msg.ProcID), Equals, "router.test-job")
+		c.Assert(string(msg.MsgID), Equals, "router")
+		line := string(msg.Msg)
+		for _, field := range []string{
+			"method=GET",
+			"host=example.com",
+			"path=/foo",
+			"route=http/" + route.ID,
+			"backend=" + srv.Listener.Addr().String(),
+			"status=200",
+			"bytes=1",
+		} {
+			c.Assert(strings.Contains(line, field), Equals, true, Commentf("missing %q in %q", field, line))
+		}
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for access log")
+	}
+
+	var buf bytes.Buffer
+	c.Assert(l.Metrics(&buf), IsNil)
+	labels := fmt.Sprintf(`route="%s",domain="example.com",app="test-app"`, route.ID)
+	for _, line := range []string{
+		fmt.Sprintf(`router_http_requests_total{%s,status="200"} 1`, labels),
+		fmt.Sprintf(`router_http_response_bytes_total{%s} 1`, labels),
+		fmt.Sprintf(`router_http_request_duration_seconds_bucket{%s,le="+Inf"} 1`, labels),
+		fmt.Sprintf(`router_http_request_duration_seconds_count{%s} 1`, labels),
+	} {
+		c.Assert(strings.Contains(buf.String(), line+"\n"), Equals, true, Commentf("missing %q", line))
+	}
+
+	// removing the route removes its metrics
+	removeHTTPRoute(c, l, route.ID)
+	buf.Reset()
+	c.Assert(l.Metrics(&buf), IsNil)
+	c.Assert(strings.Contains(buf.String(), route.ID), Equals, false)
+}
+
 func (s *S) TestPathRouting(c *C) {
 	srv1 := httptest.NewServer(httpTestHandler("1"))
 	srv2 := httptest.NewServer(httpTestHandler("2"))
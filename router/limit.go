@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiterSweepInterval is how often buckets of clients which have
+// stopped making requests are removed
+const rateLimiterSweepInterval = time.Minute
+
+// rateLimiter limits the rate of requests from each client using a token
+// bucket per client, allowing bursts of up to one second's worth of requests.
+type rateLimiter struct {
+	rate float64
+
+	mtx       sync.Mutex
+	clients   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	return &rateLimiter{
+		rate:      float64(perSecond),
+		clients:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow returns whether a request from the given client is within the limit.
+func (l *rateLimiter) Allow(client string) bool {
+	now := time.Now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if now.Sub(l.lastSweep) > rateLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.rate, last: now}
+		l.clients[client] = b
+	} else {
+		b.tokens = l.fill(b, now)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// fill returns the number of tokens in the bucket at the given time
+func (l *rateLimiter) fill(b *tokenBucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate
+	if tokens > l.rate {
+		return l.rate
+	}
+	return tokens
+}
+
+// sweep removes the buckets which have filled up, which behave the same as
+// new ones. l.mtx must be held by the caller.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.clients {
+		if l.fill(b, now) >= l.rate {
+			delete(l.clients, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// concurrencyLimiter limits the number of requests or connections which are
+// handled at once.
+type concurrencyLimiter struct {
+	// current is accessed atomically so must be the first field to
+	// guarantee 64-bit alignment
+	current int64
+	max     int64
+}
+
+func newConcurrencyLimiter(max int) *concurrencyLimiter {
+	return &concurrencyLimiter{max: int64(max)}
+}
+
+// Acquire returns whether another request is within the limit, in which
+// case Release must be called once it has been handled.
+func (l *concurrencyLimiter) Acquire() bool {
+	if atomic.AddInt64(&l.current, 1) > l.max {
+		atomic.AddInt64(&l.current, -1)
+		return false
+	}
+	return true
+}
+
+func (l *concurrencyLimiter) Release() {
+	atomic.AddInt64(&l.current, -1)
+}
+
+// limitCounters counts the requests or connections a route has rejected
+// because of its limits, and is shared by the versions of a route as it is
+// updated. The fields are accessed atomically.
+type limitCounters struct {
+	rate        uint64
+	concurrency uint64
+	connections uint64
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds in seconds of the request duration
+// histogram buckets, matching the Prometheus client defaults
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// httpMetrics collects per-route request counters and duration histograms
+// which are exposed in the Prometheus text format.
+type httpMetrics struct {
+	mtx sync.RWMutex
+	// routes is keyed by the rendered labels rather than the route ID so
+	// that updating a route's domain starts a new series
+	routes map[string]*routeMetrics
+}
+
+type routeMetrics struct {
+	routeID string
+	labels  string
+
+	mtx   sync.Mutex
+	stats routeStats
+}
+
+type routeStats struct {
+	statuses map[int]uint64
+	bytes    uint64
+	buckets  []uint64
+	count    uint64
+	sum      float64
+}
+
+// copy returns a deep copy of the stats
+func (s *routeStats) copy() routeStats {
+	c := *s
+	c.statuses = make(map[int]uint64, len(s.statuses))
+	for status, n := range s.statuses {
+		c.statuses[status] = n
+	}
+	c.buckets = append([]uint64(nil), s.buckets...)
+	return c
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{routes: make(map[string]*routeMetrics)}
+}
+
+func (m *httpMetrics) get(r *httpRoute) *routeMetrics {
+	labels := fmt.Sprintf(`route="%s",domain="%s",app="%s"`, escapeLabel(r.ID), escapeLabel(r.Domain), escapeLabel(routeAppID(r.ParentRef)))
+
+	m.mtx.RLock()
+	rm, ok := m.routes[labels]
+	m.mtx.RUnlock()
+	if ok {
+		return rm
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if rm, ok := m.routes[labels]; ok {
+		return rm
+	}
+	rm = &routeMetrics{
+		routeID: r.ID,
+		labels:  labels,
+		stats: routeStats{
+			statuses: make(map[int]uint64),
+			buckets:  make([]uint64, len(durationBuckets)),
+		},
+	}
+	m.routes[labels] = rm
+	return rm
+}
+
+// Observe records a request served by the given route.
+func (m *httpMetrics) Observe(r *httpRoute, status int, bytes int64, duration time.Duration) {
+	rm := m.get(r)
+	seconds := duration.Seconds()
+
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	s := &rm.stats
+	s.statuses[status]++
+	s.bytes += uint64(bytes)
+	s.count++
+	s.sum += seconds
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			s.buckets[i]++
+			break
+		}
+	}
+}
+
+// Remove drops the metrics of a route which has been removed.
+func (m *httpMetrics) Remove(routeID string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for labels, rm := range m.routes {
+		if rm.routeID == routeID {
+			delete(m.routes, labels)
+		}
+	}
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format.
+func (m *httpMetrics) WriteText(w io.Writer) error {
+	m.mtx.RLock()
+	routes := make([]*routeMetrics, 0, len(m.routes))
+	for _, rm := range m.routes {
+		routes = append(routes, rm)
+	}
+	m.mtx.RUnlock()
+	sort.Sort(routeMetricsByLabels(routes))
+
+	// take a copy of each route's metrics so that the output is consistent
+	// without holding the locks while writing
+	labels := make([]string, len(routes))
+	stats := make([]routeStats, len(routes))
+	for i, rm := range routes {
+		labels[i] = rm.labels
+		rm.mtx.Lock()
+		stats[i] = rm.stats.copy()
+		rm.mtx.Unlock()
+	}
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "# HELP router_http_requests_total Number of HTTP requests by route and response status.")
+	fmt.Fprintln(bw, "# TYPE router_http_requests_total counter")
+	for i, s := range stats {
+		statuses := make([]int, 0, len(s.statuses))
+		for status := range s.statuses {
+			statuses = append(statuses, status)
+		}
+		sort.Ints(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(bw, "router_http_requests_total{%s,status=\"%d\"} %d\n", labels[i], status, s.statuses[status])
+		}
+	}
+
+	fmt.Fprintln(bw, "# HELP router_http_response_bytes_total Number of response body bytes sent by route.")
+	fmt.Fprintln(bw, "# TYPE router_http_response_bytes_total counter")
+	for i, s := range stats {
+		fmt.Fprintf(bw, "router_http_response_bytes_total{%s} %d\n", labels[i], s.bytes)
+	}
+
+	fmt.Fprintln(bw, "# HELP router_http_request_duration_seconds Time taken to serve HTTP requests by route.")
+	fmt.Fprintln(bw, "# TYPE router_http_request_duration_seconds histogram")
+	for i, s := range stats {
+		var cumulative uint64
+		for j, le := range durationBuckets {
+			cumulative += s.buckets[j]
+			fmt.Fprintf(bw, "router_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels[i], formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(bw, "router_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels[i], s.count)
+		fmt.Fprintf(bw, "router_http_request_duration_seconds_sum{%s} %s\n", labels[i], formatFloat(s.sum))
+		fmt.Fprintf(bw, "router_http_request_duration_seconds_count{%s} %d\n", labels[i], s.count)
+	}
+
+	return bw.Flush()
+}
+
+type routeMetricsByLabels []*routeMetrics
+
+func (r routeMetricsByLabels) Len() int           { return len(r) }
+func (r routeMetricsByLabels) Less(i, j int) bool { return r[i].labels < r[j].labels }
+func (r routeMetricsByLabels) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
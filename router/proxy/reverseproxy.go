@@ -59,9 +59,16 @@ type ReverseProxy struct {
 // backends, a stickyKey for encrypting sticky session cookies, and a flag
 // sticky to enable sticky sessions.
 func NewReverseProxy(bf BackendListFunc, stickyKey *[32]byte, sticky bool, l log15.Logger) *ReverseProxy {
+	return NewWeightedReverseProxy([]*WeightedBackends{{Weight: 1, Backends: bf}}, stickyKey, sticky, l)
+}
+
+// NewWeightedReverseProxy initializes a new ReverseProxy which splits requests
+// between several sets of backends in proportion to their weights, falling
+// back to the other sets if none of the chosen set's backends are available.
+func NewWeightedReverseProxy(backends []*WeightedBackends, stickyKey *[32]byte, sticky bool, l log15.Logger) *ReverseProxy {
 	return &ReverseProxy{
 		transport: &transport{
-			getBackends:       bf,
+			backends:          backends,
 			stickyCookieKey:   stickyKey,
 			useStickySessions: sticky,
 		},
@@ -70,6 +77,36 @@ func NewReverseProxy(bf BackendListFunc, stickyKey *[32]byte, sticky bool, l log
 	}
 }
 
+type ctxKey int
+
+const ctxKeyRequestInfo ctxKey = iota
+
+// RequestInfo records details of how a request was proxied, for example for
+// access logging.
+type RequestInfo struct {
+	// Backend is the address of the backend which served the request, and
+	// is empty if no backend could be reached.
+	Backend string
+}
+
+// NewContextRequestInfo creates a new context that carries info, which
+// ReverseProxy fills in when serving requests with the context.
+func NewContextRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, ctxKeyRequestInfo, info)
+}
+
+// RequestInfoFromContext extracts request info from a context.
+func RequestInfoFromContext(ctx context.Context) (info *RequestInfo, ok bool) {
+	info, ok = ctx.Value(ctxKeyRequestInfo).(*RequestInfo)
+	return
+}
+
+func setBackend(ctx context.Context, backend string) {
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		info.Backend = backend
+	}
+}
+
 // ServeHTTP implements http.Handler.
 func (p *ReverseProxy) ServeHTTP(ctx context.Context, rw http.ResponseWriter, req *http.Request) {
 	transport := p.transport
@@ -82,7 +119,7 @@ func (p *ReverseProxy) ServeHTTP(ctx context.Context, rw http.ResponseWriter, re
 	l := p.Logger.New("request_id", req.Header.Get("X-Request-Id"), "client_addr", req.RemoteAddr, "host", req.Host, "path", req.URL.Path, "method", req.Method)
 
 	if isConnectionUpgrade(req.Header) {
-		p.serveUpgrade(rw, l, outreq)
+		p.serveUpgrade(ctx, rw, l, outreq)
 		return
 	}
 
@@ -105,6 +142,7 @@ func (p *ReverseProxy) ServeHTTP(ctx context.Context, rw http.ResponseWriter, re
 		return
 	}
 	defer res.Body.Close()
+	setBackend(ctx, outreq.URL.Host)
 
 	prepareResponseHeaders(res)
 	p.writeResponse(rw, res)
@@ -141,7 +179,7 @@ func (p *ReverseProxy) ServeConn(ctx context.Context, dconn net.Conn) {
 	joinConns(uconn, dconn)
 }
 
-func (p *ReverseProxy) serveUpgrade(rw http.ResponseWriter, l log15.Logger, req *http.Request) {
+func (p *ReverseProxy) serveUpgrade(ctx context.Context, rw http.ResponseWriter, l log15.Logger, req *http.Request) {
 	transport := p.transport
 	if transport == nil {
 		panic("router: nil transport for proxy")
@@ -154,6 +192,7 @@ func (p *ReverseProxy) serveUpgrade(rw http.ResponseWriter, l log15.Logger, req
 		return
 	}
 	defer uconn.Close()
+	setBackend(ctx, req.URL.Host)
 
 	prepareResponseHeaders(res)
 	if res.StatusCode != 101 {
@@ -39,16 +39,22 @@ var (
 // BackendListFunc returns a slice of backend hosts (hostname:port).
 type BackendListFunc func() []string
 
+// WeightedBackends is a set of backends which is tried first for a share of
+// requests proportional to Weight.
+type WeightedBackends struct {
+	Weight   int
+	Backends BackendListFunc
+}
+
 type transport struct {
-	getBackends BackendListFunc
+	backends []*WeightedBackends
 
 	stickyCookieKey   *[32]byte
 	useStickySessions bool
 }
 
 func (t *transport) getOrderedBackends(stickyBackend string) []string {
-	backends := t.getBackends()
-	shuffle(backends)
+	backends := t.getWeightedBackends()
 
 	if stickyBackend != "" {
 		swapToFront(backends, stickyBackend)
@@ -56,6 +62,56 @@ func (t *transport) getOrderedBackends(stickyBackend string) []string {
 	return backends
 }
 
+// getWeightedBackends returns the backends from each set of backends, with
+// the sets ordered by weighted random selection and the backends within each
+// set shuffled. Sets with no weight are only used once all others have been
+// tried.
+func (t *transport) getWeightedBackends() []string {
+	if len(t.backends) == 1 {
+		backends := t.backends[0].Backends()
+		shuffle(backends)
+		return backends
+	}
+
+	sets := make([][]string, 0, len(t.backends))
+	weights := make([]int, 0, len(t.backends))
+	var total, count int
+	for _, b := range t.backends {
+		backends := b.Backends()
+		if len(backends) == 0 {
+			continue
+		}
+		shuffle(backends)
+		weight := b.Weight
+		if weight < 0 {
+			weight = 0
+		}
+		sets = append(sets, backends)
+		weights = append(weights, weight)
+		total += weight
+		count += len(backends)
+	}
+
+	res := make([]string, 0, count)
+	for len(sets) > 0 {
+		i := 0
+		if total > 0 {
+			n := random.Math.Intn(total)
+			for i = range weights {
+				if n < weights[i] {
+					break
+				}
+				n -= weights[i]
+			}
+		}
+		res = append(res, sets[i]...)
+		total -= weights[i]
+		sets = append(sets[:i], sets[i+1:]...)
+		weights = append(weights[:i], weights[i+1:]...)
+	}
+	return res
+}
+
 func (t *transport) getStickyBackend(req *http.Request) string {
 	if t.useStickySessions {
 		return getStickyCookieBackend(req, *t.stickyCookieKey)
@@ -210,6 +210,45 @@ CREATE TRIGGER notify_route_certificates_update
 	AFTER INSERT OR UPDATE OR DELETE ON route_certificates
 	FOR EACH ROW EXECUTE PROCEDURE notify_route_certificates_update()`,
 	)
+	migrations.Add(6,
+		`ALTER TABLE http_routes ADD COLUMN release text NOT NULL DEFAULT ''`,
+	)
+	migrations.Add(7,
+		`ALTER TABLE http_routes ADD COLUMN targets jsonb`,
+	)
+	migrations.Add(8,
+		`ALTER TABLE http_routes ADD COLUMN limits jsonb`,
+		`ALTER TABLE tcp_routes ADD COLUMN limits jsonb`,
+	)
+	migrations.Add(9,
+		`ALTER TABLE http_routes ADD COLUMN auto_tls boolean NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE acme_accounts (
+			directory_url text PRIMARY KEY,
+			key text NOT NULL,
+			url text NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		)`,
+		`CREATE TABLE acme_challenges (
+			token text PRIMARY KEY,
+			key_authorization text NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		)`,
+		`
+CREATE FUNCTION notify_acme_challenge_update() RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		PERFORM pg_notify('acme_challenges', OLD.token);
+	ELSE
+		PERFORM pg_notify('acme_challenges', NEW.token);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`,
+		`
+CREATE TRIGGER notify_acme_challenge_update
+	AFTER INSERT OR UPDATE OR DELETE ON acme_challenges
+	FOR EACH ROW EXECUTE PROCEDURE notify_acme_challenge_update()`,
+	)
 }
 
 func migrateDB(db *postgres.DB) error {
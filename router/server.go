@@ -9,9 +9,11 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/flynn/flynn/discoverd/cache"
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/pkg/keepalive"
 	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/pkg/shutdown"
 	"github.com/flynn/flynn/router/types"
 	"gopkg.in/inconshreveable/log15.v2"
@@ -132,6 +134,23 @@ func main() {
 
 	shutdown.BeforeExit(func() { db.Close() })
 
+	// access logs are sent to logaggregator so they appear in app logs
+	logaggregator, err := cache.New(discoverd.NewService("logaggregator"))
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+	jobID := os.Getenv("FLYNN_JOB_ID")
+	if jobID == "" {
+		jobID = random.UUID()
+	}
+
+	// certificates are obtained for auto TLS routes if an ACME server is
+	// configured
+	var autoTLS *acmeManager
+	if dir := os.Getenv("ACME_DIRECTORY_URL"); dir != "" {
+		autoTLS = newACMEManager(dir, os.Getenv("ACME_EMAIL"), db.ConnPool)
+	}
+
 	httpAddr := net.JoinHostPort(os.Getenv("LISTEN_IP"), *httpPort)
 	httpsAddr := net.JoinHostPort(os.Getenv("LISTEN_IP"), *httpsPort)
 	r := Router{
@@ -149,6 +168,8 @@ func main() {
 			keypair:   keypair,
 			ds:        NewPostgresDataStore("http", db.ConnPool),
 			discoverd: discoverd.DefaultClient,
+			accessLog: newAccessLog(jobID, logaggregator),
+			acme:      autoTLS,
 		},
 	}
 
@@ -26,6 +26,7 @@ func init() {
 type discoverdClient interface {
 	DiscoverdClient
 	AddServiceAndRegister(string, string) (discoverd.Heartbeater, error)
+	AddServiceAndRegisterInstance(string, *discoverd.Instance) (discoverd.Heartbeater, error)
 }
 
 // discoverdWrapper wraps a discoverd client to expose Close method that closes
@@ -44,6 +45,15 @@ func (d *discoverdWrapper) AddServiceAndRegister(service, addr string) (discover
 	return hb, nil
 }
 
+func (d *discoverdWrapper) AddServiceAndRegisterInstance(service string, inst *discoverd.Instance) (discoverd.Heartbeater, error) {
+	hb, err := d.discoverdClient.AddServiceAndRegisterInstance(service, inst)
+	if err != nil {
+		return nil, err
+	}
+	d.hbs = append(d.hbs, hb)
+	return hb, nil
+}
+
 func (d *discoverdWrapper) Cleanup() {
 	for _, hb := range d.hbs {
 		hb.Close()
@@ -157,6 +167,12 @@ func discoverdRegisterHTTPService(c *C, l *HTTPListener, name, addr string) func
 	return discoverdRegister(c, dc, sc.(serviceCache), name, addr)
 }
 
+func discoverdRegisterHTTPInstance(c *C, l *HTTPListener, name string, inst *discoverd.Instance) func() {
+	dc := l.discoverd.(discoverdClient)
+	sc := l.services[name].sc
+	return discoverdRegisterInstance(c, dc, sc.(serviceCache), name, inst)
+}
+
 func discoverdSetLeaderHTTP(c *C, l *HTTPListener, name, id string) {
 	dc := l.discoverd.(discoverdClient)
 	sc := l.services[name].sc.(serviceCache)
@@ -212,6 +228,28 @@ func discoverdRegister(c *C, dc discoverdClient, sc serviceCache, name, addr str
 	return discoverdUnregisterFunc(c, hb, sc)
 }
 
+func discoverdRegisterInstance(c *C, dc discoverdClient, sc serviceCache, name string, inst *discoverd.Instance) func() {
+	done := make(chan struct{})
+	go func() {
+		events, unwatch := sc.Watch(true)
+		defer unwatch()
+		for event := range events {
+			if event.Kind == discoverd.EventKindUp && event.Instance.Addr == inst.Addr {
+				close(done)
+				return
+			}
+		}
+	}()
+	hb, err := dc.AddServiceAndRegisterInstance(name, inst)
+	c.Assert(err, IsNil)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		c.Fatal("timed out waiting for discoverd registration")
+	}
+	return discoverdUnregisterFunc(c, hb, sc)
+}
+
 func discoverdUnregisterFunc(c *C, hb discoverd.Heartbeater, sc serviceCache) func() {
 	return func() {
 		done := make(chan struct{})
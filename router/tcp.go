@@ -7,6 +7,7 @@ import (
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/flynn/flynn/discoverd/cache"
@@ -245,6 +246,14 @@ func (h *tcpSyncHandler) Set(data *router.Route) error {
 		bf = service.sc.Addrs
 	}
 	r.rp = proxy.NewReverseProxy(bf, nil, false, logger)
+	if r.Limits != nil && r.Limits.MaxConnections > 0 {
+		r.connections = newConcurrencyLimiter(r.Limits.MaxConnections)
+	}
+	if existing, ok := h.l.routes[data.ID]; ok {
+		r.limited = existing.limited
+	} else {
+		r.limited = &limitCounters{}
+	}
 	if listener, ok := h.l.listeners[r.Port]; ok {
 		r.l = listener
 		delete(h.l.listeners, r.Port)
@@ -297,6 +306,9 @@ type tcpRoute struct {
 	service *tcpService
 	rp      *proxy.ReverseProxy
 	mtx     sync.RWMutex
+
+	connections *concurrencyLimiter
+	limited     *limitCounters
 }
 
 func (r *tcpRoute) Serve(started chan<- error) {
@@ -346,5 +358,31 @@ type tcpService struct {
 }
 
 func (r *tcpRoute) ServeConn(conn net.Conn) {
+	if r.connections != nil {
+		if !r.connections.Acquire() {
+			atomic.AddUint64(&r.limited.connections, 1)
+			conn.Close()
+			return
+		}
+		defer r.connections.Release()
+	}
 	r.rp.ServeConn(context.Background(), connutil.CloseNotifyConn(conn))
 }
+
+// LimitStats returns the number of connections rejected by the limits of
+// each route which has limits.
+func (l *TCPListener) LimitStats() []*router.RouteLimitStats {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	stats := make([]*router.RouteLimitStats, 0)
+	for _, r := range l.routes {
+		if r.Limits == nil {
+			continue
+		}
+		stats = append(stats, &router.RouteLimitStats{
+			Route:             r.FormattedID(),
+			ConnectionLimited: atomic.LoadUint64(&r.limited.connections),
+		})
+	}
+	return stats
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/discoverd/testutil"
@@ -106,6 +107,71 @@ func (s *S) TestAddTCPRoute(c *C) {
 	c.Assert(err, Not(IsNil))
 }
 
+func (s *S) TestTCPConnectionLimit(c *C) {
+	portInt := allocatePort()
+	addr := "127.0.0.1:" + strconv.Itoa(portInt)
+
+	srv := NewTCPTestServer("1")
+	defer srv.Close()
+
+	l := s.newTCPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.TCPRoute{
+		Service: "test",
+		Port:    portInt,
+		Limits:  &router.RouteLimits{MaxConnections: 1},
+	}.ToRoute())
+	discoverdRegisterTCP(c, l, srv.Addr)
+
+	// hold open a connection, waiting for the prefix to know it is proxied
+	conn, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	buf := make([]byte, 1)
+	_, err = io.ReadFull(conn, buf)
+	c.Assert(err, IsNil)
+
+	// a second connection is closed without being proxied
+	conn2, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	res, _ := ioutil.ReadAll(conn2)
+	conn2.Close()
+	c.Assert(string(res), Equals, "")
+
+	stats := l.LimitStats()
+	c.Assert(stats, HasLen, 1)
+	c.Assert(stats[0].ConnectionLimited, Equals, uint64(1))
+
+	// connections are accepted again once the first is closed
+	conn.Close()
+	var lastErr error
+	for i := 0; i < 50; i++ {
+		if lastErr = tryTCPConn(addr, "1"); lastErr == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(lastErr, IsNil)
+}
+
+func tryTCPConn(addr, prefix string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.Write([]byte("asdf"))
+	conn.(*net.TCPConn).CloseWrite()
+	res, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if string(res) != prefix+"asdf" {
+		return fmt.Errorf("unexpected response %q", res)
+	}
+	return nil
+}
+
 func addTCPRoute(c *C, l *TCPListener, port int) *router.TCPRoute {
 	wait := waitForEvent(c, l, "set", "")
 	r := router.TCPRoute{
@@ -51,6 +51,10 @@ type Route struct {
 	LegacyTLSCert string `json:"tls_cert,omitempty"`
 	LegacyTLSKey  string `json:"tls_key,omitempty"`
 
+	// AutoTLS is whether the router should obtain and renew a certificate
+	// for the domain using ACME. It is only used for HTTP routes.
+	AutoTLS bool `json:"auto_tls,omitempty"`
+
 	// Sticky is whether or not to use sticky sessions for this route. It is only
 	// used for HTTP routes.
 	Sticky bool `json:"sticky,omitempty"`
@@ -58,11 +62,60 @@ type Route struct {
 	// the TLS options and can only be set if a "default" route with the same domain
 	// and no Path already exists in the route table.
 	Path string `json:"path,omitempty"`
+	// Release is the optional ID of a release to restrict traffic to. When set,
+	// only service instances with a matching FLYNN_RELEASE_ID are used as
+	// backends. It is only used for HTTP routes.
+	Release string `json:"release,omitempty"`
+	// Targets optionally splits traffic between several services (or the
+	// instances of a service which belong to a release) in proportion to
+	// their weights. When set, it is used instead of Service and Release to
+	// pick backends. It is only used for HTTP routes.
+	Targets []*Target `json:"targets,omitempty"`
+
+	// Limits optionally restricts the traffic the route accepts.
+	Limits *RouteLimits `json:"limits,omitempty"`
 
 	// Port is the TCP port to listen on for TCP Routes.
 	Port int32 `json:"port,omitempty"`
 }
 
+// Target is a destination for a proportion of an HTTP route's traffic.
+type Target struct {
+	// Service is the ID of the service.
+	Service string `json:"service"`
+	// Release is the optional ID of a release to restrict the service's
+	// instances to.
+	Release string `json:"release,omitempty"`
+	// Weight is the share of traffic sent to this target relative to the
+	// sum of the weights of all the route's targets.
+	Weight int `json:"weight"`
+}
+
+// RouteLimits are optional limits on the traffic a route accepts, with zero
+// values meaning no limit.
+type RouteLimits struct {
+	// RequestsPerSecond is the rate of requests accepted from each client
+	// IP, with bursts of up to the same number of requests. It is only used
+	// for HTTP routes.
+	RequestsPerSecond int `json:"requests_per_second,omitempty"`
+	// MaxConcurrentRequests is the number of requests which are proxied at
+	// once. It is only used for HTTP routes.
+	MaxConcurrentRequests int `json:"max_concurrent_requests,omitempty"`
+	// MaxConnections is the number of connections which are proxied at
+	// once. It is only used for TCP routes.
+	MaxConnections int `json:"max_connections,omitempty"`
+}
+
+// RouteLimitStats counts the requests or connections a route has rejected
+// because of its limits, counted since the router started serving the
+// route
+type RouteLimitStats struct {
+	Route              string `json:"route"`
+	RateLimited        uint64 `json:"rate_limited"`
+	ConcurrencyLimited uint64 `json:"concurrency_limited"`
+	ConnectionLimited  uint64 `json:"connection_limited"`
+}
+
 func (r Route) FormattedID() string {
 	return r.Type + "/" + r.ID
 }
@@ -80,8 +133,12 @@ func (r Route) HTTPRoute() *HTTPRoute {
 		Certificate:   r.Certificate,
 		LegacyTLSCert: r.LegacyTLSCert,
 		LegacyTLSKey:  r.LegacyTLSKey,
+		AutoTLS:       r.AutoTLS,
 		Sticky:        r.Sticky,
 		Path:          r.Path,
+		Release:       r.Release,
+		Targets:       r.Targets,
+		Limits:        r.Limits,
 	}
 }
 
@@ -94,7 +151,8 @@ func (r Route) TCPRoute() *TCPRoute {
 		CreatedAt: r.CreatedAt,
 		UpdatedAt: r.UpdatedAt,
 
-		Port: int(r.Port),
+		Port:   int(r.Port),
+		Limits: r.Limits,
 	}
 }
 
@@ -111,8 +169,12 @@ type HTTPRoute struct {
 	Certificate   *Certificate `json:"certificate,omitempty"`
 	LegacyTLSCert string       `json:"tls_cert,omitempty"`
 	LegacyTLSKey  string       `json:"tls_key,omitempty"`
+	AutoTLS       bool
 	Sticky        bool
 	Path          string
+	Release       string
+	Targets       []*Target
+	Limits        *RouteLimits
 }
 
 func (r HTTPRoute) FormattedID() string {
@@ -139,8 +201,12 @@ func (r HTTPRoute) ToRoute() *Route {
 		Certificate:   r.Certificate,
 		LegacyTLSCert: r.LegacyTLSCert,
 		LegacyTLSKey:  r.LegacyTLSKey,
+		AutoTLS:       r.AutoTLS,
 		Sticky:        r.Sticky,
 		Path:          r.Path,
+		Release:       r.Release,
+		Targets:       r.Targets,
+		Limits:        r.Limits,
 	}
 }
 
@@ -153,7 +219,8 @@ type TCPRoute struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 
-	Port int
+	Port   int
+	Limits *RouteLimits
 }
 
 func (r TCPRoute) FormattedID() string {
@@ -174,10 +241,18 @@ func (r TCPRoute) ToRoute() *Route {
 		CreatedAt: r.CreatedAt,
 		UpdatedAt: r.UpdatedAt,
 
-		Port: int32(r.Port),
+		Port:   int32(r.Port),
+		Limits: r.Limits,
 	}
 }
 
+// HTTPServiceStats contains request counters for an HTTP service, counted
+// since the router started routing to the service
+type HTTPServiceStats struct {
+	Service  string `json:"service"`
+	Requests uint64 `json:"requests"`
+}
+
 type Event struct {
 	Event string
 	ID    string
@@ -103,8 +103,18 @@ if [[ -f "${env_cookie}" ]]; then
   envdir="true"
 fi
 
+restore_cache() {
+  curl "$1" | tar --extract --gunzip --directory "${cache_root}" &>/dev/null
+}
+
+# BUILD_CACHE_URL is keyed by the buildpack and the app's dependencies, if it
+# doesn't exist yet, start from BUILD_CACHE_FALLBACK_URL (usually the previous
+# build's cache) instead
 if [[ -n "${BUILD_CACHE_URL}" ]]; then
-  curl "${BUILD_CACHE_URL}" | tar --extract --gunzip --directory "${cache_root}" &>/dev/null || true
+  if ! restore_cache "${BUILD_CACHE_URL}" && [[ -n "${BUILD_CACHE_FALLBACK_URL}" ]]; then
+    find "${cache_root}" -mindepth 1 -delete
+    restore_cache "${BUILD_CACHE_FALLBACK_URL}" || true
+  fi
 fi
 
 # In heroku, there are two separate directories, and some
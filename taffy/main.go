@@ -41,11 +41,12 @@ func ensureDir(dir string) {
 
 func main() {
 	usage := `
-Usage: taffy <app> <repo> <branch> <rev> [-e <var>=<val>]... [-m <key>=<val>]...
+Usage: taffy <app> <repo> <branch> <rev> [--review] [-e <var>=<val>]... [-m <key>=<val>]...
 
 Options:
 	-e,--env <var>=<val>
 	-m,--meta <key>=<val>
+	--review              deploy to the review app for the branch
 `[1:]
 	args, _ := docopt.Parse(usage, nil, true, version.String(), false)
 
@@ -99,7 +100,11 @@ Options:
 	if err := cloneRepo(repo, branch); err != nil {
 		log.Fatal(err)
 	}
-	if err := runReceiver(app, rev, env, meta); err != nil {
+	var reviewBranch string
+	if args.Bool["--review"] {
+		reviewBranch = branch
+	}
+	if err := runReceiver(app, rev, reviewBranch, env, meta); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -118,10 +123,13 @@ func gitArchive(rev string, w io.Writer) error {
 	return cmd.Run()
 }
 
-func runReceiver(app, rev string, env, meta map[string]string) error {
-	args := make([]string, 0, len(env)+len(meta)+2)
+func runReceiver(app, rev, reviewBranch string, env, meta map[string]string) error {
+	args := make([]string, 0, len(env)+len(meta)+4)
 	args = append(args, app)
 	args = append(args, rev)
+	if reviewBranch != "" {
+		args = append(args, "--branch", reviewBranch)
+	}
 	for name, m := range map[string]map[string]string{"--env": env, "--meta": meta} {
 		for k, v := range m {
 			args = append(args, name)
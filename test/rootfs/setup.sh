@@ -215,6 +215,14 @@ mv "${GOPATH}/bin/tuf-client" /usr/bin/tuf-client
 # install go cover
 go get golang.org/x/tools/cmd/cover
 
+# install Pebble for testing obtaining certificates with ACME
+pebble_version="v2.3.0"
+git clone --branch "${pebble_version}" https://github.com/letsencrypt/pebble.git /opt/pebble
+for bin in pebble pebble-challtestsrv; do
+  curl -fsLo "/usr/local/bin/${bin}" "https://github.com/letsencrypt/pebble/releases/download/${pebble_version}/${bin}_linux-amd64"
+  chmod +x "/usr/local/bin/${bin}"
+done
+
 # allow the test runner to set certain environment variables
 echo AcceptEnv TEST_RUNNER_AUTH_KEY BLOBSTORE_S3_CONFIG >> /etc/ssh/sshd_config
 
@@ -10,6 +10,28 @@ bats script/test
 export PGHOST=/var/run/postgresql
 sudo service postgresql start
 
+# start Pebble for the router's ACME tests, resolving every domain to
+# 127.0.0.1 so that it validates challenges against the test router
+pebble-challtestsrv \
+  -defaultIPv4 127.0.0.1 \
+  -defaultIPv6 "" \
+  -http01 "" \
+  -https01 "" \
+  -tlsalpn01 "" \
+  -doh "" \
+  &>/tmp/pebble-challtestsrv.log &
+challtestsrv_pid=$!
+pushd /opt/pebble >/dev/null
+PEBBLE_VA_NOSLEEP=1 pebble \
+  -config test/config/pebble-config.json \
+  -dnsserver 127.0.0.1:8053 \
+  &>/tmp/pebble.log &
+pebble_pid=$!
+popd >/dev/null
+trap "kill ${pebble_pid} ${challtestsrv_pid}" EXIT
+export PEBBLE_DIRECTORY_URL="https://localhost:14000/dir"
+export PEBBLE_CA="/opt/pebble/test/certs/pebble.minica.pem"
+
 make test-unit-root
 
 sudo service postgresql stop
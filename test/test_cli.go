@@ -1065,6 +1065,85 @@ func (s *CLISuite) TestDeployTimeout(t *c.C) {
 	t.Assert(timeout.Output, c.Equals, "150\n")
 }
 
+func (s *CLISuite) TestDeployCanary(t *c.C) {
+	app := s.newCliTestApp(t)
+	defer app.cleanup()
+
+	t.Assert(app.flynn("deployment", "strategy", "canary"), Succeeds)
+	strategy := app.flynn("deployment", "strategy")
+	t.Assert(strategy, Succeeds)
+	t.Assert(strategy.Output, c.Equals, "canary\n")
+
+	canary := app.flynn("deployment", "canary")
+	t.Assert(canary, Succeeds)
+	t.Assert(canary.Output, c.Equals, "count: 1\nperiod: 60\n")
+
+	t.Assert(app.flynn("deployment", "canary", "--count", "2", "--period", "300"), Succeeds)
+	canary = app.flynn("deployment", "canary")
+	t.Assert(canary, Succeeds)
+	t.Assert(canary.Output, c.Equals, "count: 2\nperiod: 300\n")
+}
+
+func (s *CLISuite) TestDeployBlueGreen(t *c.C) {
+	app := s.newCliTestApp(t)
+	defer app.cleanup()
+
+	t.Assert(app.flynn("deployment", "strategy", "blue-green"), Succeeds)
+	strategy := app.flynn("deployment", "strategy")
+	t.Assert(strategy, Succeeds)
+	t.Assert(strategy.Output, c.Equals, "blue-green\n")
+
+	opts := app.flynn("deployment", "blue-green")
+	t.Assert(opts, Succeeds)
+	t.Assert(opts.Output, c.Equals, "preview-period: 60\nwarm-period: 600\n")
+
+	t.Assert(app.flynn("deployment", "blue-green", "--preview-period", "120", "--warm-period", "3600"), Succeeds)
+	opts = app.flynn("deployment", "blue-green")
+	t.Assert(opts, Succeeds)
+	t.Assert(opts.Output, c.Equals, "preview-period: 120\nwarm-period: 3600\n")
+
+	// there is no blue-green deployment to roll back
+	t.Assert(app.flynn("deployment", "rollback"), c.Not(Succeeds))
+}
+
+func (s *CLISuite) TestCron(t *c.C) {
+	app := s.newCliTestApp(t)
+	defer app.cleanup()
+
+	// invalid expressions are rejected
+	t.Assert(app.flynn("cron", "add", "* * *", "--", "echo", "foo"), c.Not(Succeeds))
+
+	// add a schedule which runs every minute
+	add := app.flynn("cron", "add", "--concurrency-policy=forbid", "* * * * *", "--", "echo", "scheduled")
+	t.Assert(add, Succeeds)
+	id := strings.TrimSuffix(strings.TrimPrefix(add.Output, "Created schedule "), ".\n")
+	t.Assert(app.flynn("cron"), SuccessfulOutputContains, id)
+
+	// wait for the schedule to run a job
+	err := attempt.Strategy{
+		Total: 3 * time.Minute,
+		Delay: 5 * time.Second,
+	}.Run(func() error {
+		runs := app.flynn("cron", "runs", id)
+		if runs.Err != nil {
+			return runs.Err
+		}
+		if len(strings.Split(strings.TrimSpace(runs.Output), "\n")) < 2 {
+			return errors.New("schedule has not run")
+		}
+		return nil
+	})
+	t.Assert(err, c.IsNil)
+
+	// update the schedule
+	t.Assert(app.flynn("cron", "update", id, "--cron=@daily"), Succeeds)
+	t.Assert(app.flynn("cron"), SuccessfulOutputContains, "@daily")
+
+	// remove the schedule
+	t.Assert(app.flynn("cron", "remove", id), Succeeds)
+	t.Assert(app.flynn("cron"), c.Not(SuccessfulOutputContains), id)
+}
+
 func (s *CLISuite) TestReleaseDelete(t *c.C) {
 	// create an app and release it twice
 	r := s.newGitRepo(t, "http")
@@ -6,6 +6,7 @@ import (
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/router/types"
 	c "github.com/flynn/go-check"
 )
 
@@ -360,3 +361,136 @@ func (s *DeployerSuite) TestOmniProcess(t *c.C) {
 	expected = append(expected, &ct.DeploymentEvent{ReleaseID: deployment.NewReleaseID, Status: "complete"})
 	waitForDeploymentEvents(t, events, expected)
 }
+
+func (s *DeployerSuite) TestCanaryStrategy(t *c.C) {
+	app, release := s.createRelease(t, "printer", "canary")
+	client := s.controllerClient(t)
+	app.DeployOptions = &ct.DeployOptions{CanaryCount: 1, CanaryPeriod: 5}
+	t.Assert(client.UpdateApp(app), c.IsNil)
+
+	oldReleaseID := release.ID
+	release.ID = ""
+	t.Assert(client.CreateRelease(release), c.IsNil)
+	deployment, err := client.CreateDeployment(app.ID, release.ID)
+	t.Assert(err, c.IsNil)
+	t.Assert(deployment.Strategy, c.Equals, "canary")
+
+	events := make(chan *ct.DeploymentEvent)
+	stream, err := client.StreamDeployment(deployment, events)
+	t.Assert(err, c.IsNil)
+	defer stream.Close()
+
+	expected := []*ct.DeploymentEvent{
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "pending"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateStarting, Status: "running"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateUp, Status: "running"},
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "canary"},
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "promoted"},
+		{ReleaseID: oldReleaseID, JobType: "printer", JobState: ct.JobStateStopping, Status: "running"},
+		{ReleaseID: oldReleaseID, JobType: "printer", JobState: ct.JobStateDown, Status: "running"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateStarting, Status: "running"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateUp, Status: "running"},
+		{ReleaseID: oldReleaseID, JobType: "printer", JobState: ct.JobStateStopping, Status: "running"},
+		{ReleaseID: oldReleaseID, JobType: "printer", JobState: ct.JobStateDown, Status: "running"},
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "complete"},
+	}
+	waitForDeploymentEvents(t, events, expected)
+}
+
+func (s *DeployerSuite) TestCanaryRollback(t *c.C) {
+	app, release := s.createRelease(t, "printer", "canary")
+	client := s.controllerClient(t)
+	app.DeployOptions = &ct.DeployOptions{CanaryCount: 1, CanaryPeriod: 30}
+	t.Assert(client.UpdateApp(app), c.IsNil)
+
+	// deploy a release which starts but exits during the observation period
+	release.ID = ""
+	printer := release.Processes["printer"]
+	printer.Args = []string{"sh", "-c", "sleep 2; exit 1"}
+	release.Processes["printer"] = printer
+	t.Assert(client.CreateRelease(release), c.IsNil)
+	deployment, err := client.CreateDeployment(app.ID, release.ID)
+	t.Assert(err, c.IsNil)
+
+	events := make(chan *ct.DeploymentEvent)
+	stream, err := client.StreamDeployment(deployment, events)
+	t.Assert(err, c.IsNil)
+	defer stream.Close()
+	expected := []*ct.DeploymentEvent{
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "pending"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateStarting, Status: "running"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateUp, Status: "running"},
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "canary"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateDown, Status: "running"},
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "failed", Error: "deployer: canary printer job went down during the observation period"},
+	}
+	waitForDeploymentEvents(t, events, expected)
+
+	s.assertRolledBack(t, deployment, map[string]int{"printer": 2})
+}
+
+func (s *DeployerSuite) TestBlueGreenStrategy(t *c.C) {
+	app, release := s.createRelease(t, "printer", "blue-green")
+	client := s.controllerClient(t)
+	app.DeployOptions = &ct.DeployOptions{PreviewPeriod: 1, WarmPeriod: 3600}
+	t.Assert(client.UpdateApp(app), c.IsNil)
+
+	route := &router.Route{
+		Type:    "http",
+		Domain:  app.Name + ".example.com",
+		Service: app.Name + "-web",
+	}
+	t.Assert(client.CreateRoute(app.ID, route), c.IsNil)
+
+	oldReleaseID := release.ID
+	release.ID = ""
+	t.Assert(client.CreateRelease(release), c.IsNil)
+	deployment, err := client.CreateDeployment(app.ID, release.ID)
+	t.Assert(err, c.IsNil)
+	t.Assert(deployment.Strategy, c.Equals, "blue-green")
+
+	events := make(chan *ct.DeploymentEvent)
+	stream, err := client.StreamDeployment(deployment, events)
+	t.Assert(err, c.IsNil)
+	defer stream.Close()
+
+	expected := []*ct.DeploymentEvent{
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "pending"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateStarting, Status: "running"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateStarting, Status: "running"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateUp, Status: "running"},
+		{ReleaseID: release.ID, JobType: "printer", JobState: ct.JobStateUp, Status: "running"},
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "preview"},
+		{ReleaseID: release.ID, JobType: "", JobState: "", Status: "complete"},
+	}
+	waitForDeploymentEvents(t, events, expected)
+
+	// check the route was switched, the preview route was deleted and the
+	// old formation is still running
+	routes, err := client.RouteList(app.ID)
+	t.Assert(err, c.IsNil)
+	t.Assert(routes, c.HasLen, 1)
+	t.Assert(routes[0].Release, c.Equals, release.ID)
+	formation, err := client.GetFormation(app.ID, oldReleaseID)
+	t.Assert(err, c.IsNil)
+	t.Assert(formation.Processes, c.DeepEquals, map[string]int{"printer": 2})
+
+	// check rolling back switches the route straight back
+	deployment, err = client.RollbackDeployment(app.ID)
+	t.Assert(err, c.IsNil)
+	t.Assert(deployment.Status, c.Equals, "rolled-back")
+	appRelease, err := client.GetAppRelease(app.ID)
+	t.Assert(err, c.IsNil)
+	t.Assert(appRelease.ID, c.Equals, oldReleaseID)
+	routes, err = client.RouteList(app.ID)
+	t.Assert(err, c.IsNil)
+	t.Assert(routes, c.HasLen, 1)
+	t.Assert(routes[0].Release, c.Equals, oldReleaseID)
+	formation, err = client.GetFormation(app.ID, release.ID)
+	t.Assert(err, c.IsNil)
+	t.Assert(formation.Processes["printer"], c.Equals, 0)
+
+	// check a second rollback is rejected
+	_, err = client.RollbackDeployment(app.ID)
+	t.Assert(err, c.NotNil)
+}
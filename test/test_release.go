@@ -244,6 +244,7 @@ func (s *ReleaseSuite) TestReleaseImages(t *c.C) {
 	t.Assert(err, c.IsNil)
 	assertImage(gitreceive.Env["SLUGBUILDER_IMAGE_URI"], "flynn/slugbuilder")
 	assertImage(gitreceive.Env["SLUGRUNNER_IMAGE_URI"], "flynn/slugrunner")
+	assertImage(gitreceive.Env["DOCKERBUILDER_IMAGE_URI"], "flynn/dockerbuilder")
 
 	// check slug based app was deployed correctly
 	release, err = client.GetAppRelease(slugApp.Name)
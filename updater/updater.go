@@ -19,7 +19,7 @@ import (
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-var slugbuilderURI, slugrunnerURI string
+var slugbuilderURI, slugrunnerURI, dockerbuilderURI string
 
 // use a flag to determine whether to use a TTY log formatter because actually
 // assigning a TTY to the job causes reading images via stdin to fail.
@@ -108,6 +108,7 @@ func run() error {
 	}
 	slugbuilderURI = uris["slugbuilder"]
 	slugrunnerURI = uris["slugrunner"]
+	dockerbuilderURI = uris["dockerbuilder"]
 
 	// deploy system apps in order first
 	for _, appInfo := range updater.SystemApps {
@@ -236,8 +237,16 @@ func deployApp(client controller.Client, app *ct.App, uri string, updateFn updat
 
 func updateSlugURIs(env map[string]string) bool {
 	uris := map[string]string{
-		"SLUGBUILDER_IMAGE_URI": slugbuilderURI,
-		"SLUGRUNNER_IMAGE_URI":  slugrunnerURI,
+		"SLUGBUILDER_IMAGE_URI":   slugbuilderURI,
+		"SLUGRUNNER_IMAGE_URI":    slugrunnerURI,
+		"DOCKERBUILDER_IMAGE_URI": dockerbuilderURI,
+	}
+	// releases created before dockerbuilder existed have a slugbuilder
+	// URI but no dockerbuilder URI, so add one
+	if _, ok := env["SLUGBUILDER_IMAGE_URI"]; ok && dockerbuilderURI != "" {
+		if _, ok := env["DOCKERBUILDER_IMAGE_URI"]; !ok {
+			env["DOCKERBUILDER_IMAGE_URI"] = ""
+		}
 	}
 	updated := false
 	for key, uri := range uris {